@@ -0,0 +1,638 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/audit"
+	"github.com/GravSpace/GravSpace/internal/auth"
+	"github.com/GravSpace/GravSpace/internal/config"
+	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/storage"
+)
+
+// Exit codes of maintenance commands
+const (
+	exitOK      = 0
+	exitFailed  = 1 // The command ran and found a problem
+	exitError   = 2 // The command could not run
+	exitUsage   = 64
+	commandHelp = `Usage: storage-server [flags] <command> [arguments]
+
+Maintenance commands work on the database and data directory of a stopped instance and print
+their result as JSON.
+
+Commands:
+  user create <name> [--password P] [--admin]   Create a user; prints a generated password if none is given
+  user reset-password <name> [--password P]     Set a new password; prints a generated one if none is given
+  user add-key <name>                           Generate an S3 access key
+  bucket list                                   List buckets with their usage
+  bucket create <name> [--versioning]           Create a bucket
+  bucket delete <name> [--force]                Delete an empty bucket, or with --force all its objects too
+  bucket erasure <name> <data+parity|off>       Erasure-code new objects across the data drives, e.g. 4+2
+  db status                                     Show pending schema migrations and row counts
+  db migrate                                    Apply pending schema migrations
+  fsck [--repair] [--quick] [--upload-age D]    Check database rows against files, CAS blobs and uploads
+  export [--output file]                        Export the metadata database as JSON lines
+  import <file> [--replace]                     Import an export; --replace clears the tables first
+  gc-cas [--dry-run]                            Remove CAS blobs no object references
+  heal                                          Restore the copies of every CAS blob on the data drives
+  audit verify                                  Walk the audit hash chain and report the first broken link
+`
+)
+
+// commands maps the maintenance commands to their implementation. Each receives the arguments
+// after the command name.
+var commands = map[string]func(cfg *config.Config, args []string, out io.Writer) int{
+	"user create":         userCreateCommand,
+	"user reset-password": userResetPasswordCommand,
+	"user add-key":        userAddKeyCommand,
+	"bucket list":         bucketListCommand,
+	"bucket create":       bucketCreateCommand,
+	"bucket delete":       bucketDeleteCommand,
+	"bucket erasure":      bucketErasureCommand,
+	"db status":           dbStatusCommand,
+	"db migrate":          dbMigrateCommand,
+	"fsck":                fsckCommand,
+	"export":              exportCommand,
+	"import":              importCommand,
+	"gc-cas":              gcCASCommand,
+	"heal":                healCommand,
+	"audit verify":        auditVerifyCommand,
+}
+
+// runCommand executes a maintenance subcommand and returns the process exit code.
+// Commands write their JSON result to stdout; messages the packages print go to stderr.
+func runCommand(cfg *config.Config, args []string) int {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = out }()
+
+	if len(args) >= 2 {
+		if run, ok := commands[args[0]+" "+args[1]]; ok {
+			return run(cfg, args[2:], out)
+		}
+	}
+	if run, ok := commands[args[0]]; ok {
+		return run(cfg, args[1:], out)
+	}
+	fmt.Fprint(os.Stderr, commandHelp)
+	return exitUsage
+}
+
+// errUsage is returned by parseArgs for arguments the command does not accept
+var errUsage = errors.New("usage")
+
+// parseArgs parses the flags of a command, which may come before or after its positional
+// arguments, and checks the number of positional arguments
+func parseArgs(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	fs.SetOutput(os.Stderr)
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, errUsage
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(rest) != positional {
+		fmt.Fprint(os.Stderr, commandHelp)
+		return nil, errUsage
+	}
+	return rest, nil
+}
+
+// writeJSON prints the result of a command
+func writeJSON(out io.Writer, v any) {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// fail prints err as a JSON error and returns code
+func fail(out io.Writer, code int, err error) int {
+	writeJSON(out, jsonObject{"error": err.Error()})
+	return code
+}
+
+// jsonObject is the JSON object most commands print
+type jsonObject = map[string]any
+
+func openDatabase(cfg *config.Config) (*database.Database, error) {
+	db, err := database.Open(cfg.Database.URL, cfg.Database.AuthToken, cfg.Paths.Database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
+// openUsers opens the user manager with the admin user and policies seeded like on startup
+func openUsers(cfg *config.Config) (*auth.UserManager, func(), error) {
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	um, err := auth.NewUserManager(db)
+	if err == nil {
+		err = um.Initialize()
+	}
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	return um, func() { db.Close() }, nil
+}
+
+func openStorage(cfg *config.Config) (*storage.FileStorage, func(), error) {
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := storage.NewOfflineStorage(cfg.Paths.Data, db)
+	if err == nil && len(cfg.Drives.Paths) > 0 {
+		err = store.UseDrives(cfg.Drives.Paths, cfg.Drives.Copies, cfg.Drives.AllowUnmounted)
+	}
+	if err == nil {
+		store.Blobs, err = openBlobStore(cfg.BlobStore)
+	}
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return store, func() { db.Close() }, nil
+}
+
+// generatePassword returns a random password for users created without one
+func generatePassword() string {
+	b := make([]byte, 18)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func userCreateCommand(cfg *config.Config, args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	password := fs.String("password", "", "Password of the new user (generated if empty)")
+	admin := fs.Bool("admin", false, "Attach the AdministratorAccess policy")
+	rest, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return exitUsage
+	}
+	name := rest[0]
+
+	um, done, err := openUsers(cfg)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	defer done()
+
+	if _, ok := um.Users[name]; ok {
+		return fail(out, exitFailed, fmt.Errorf("user %q already exists", name))
+	}
+	result := jsonObject{"username": name}
+	if *password == "" {
+		*password = generatePassword()
+		result["password"] = *password
+	}
+	um.CreateUser(name)
+	if err := um.UpdatePassword(name, *password); err != nil {
+		return fail(out, exitError, err)
+	}
+	if *admin {
+		if err := um.AttachPolicyTemplate(name, "AdministratorAccess"); err != nil {
+			return fail(out, exitError, err)
+		}
+		result["policies"] = []string{"AdministratorAccess"}
+	}
+	writeJSON(out, result)
+	return exitOK
+}
+
+func userResetPasswordCommand(cfg *config.Config, args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+	password := fs.String("password", "", "New password (generated if empty)")
+	rest, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return exitUsage
+	}
+	name := rest[0]
+
+	um, done, err := openUsers(cfg)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	defer done()
+
+	if _, ok := um.Users[name]; !ok {
+		return fail(out, exitFailed, fmt.Errorf("user %q does not exist", name))
+	}
+	result := jsonObject{"username": name}
+	if *password == "" {
+		*password = generatePassword()
+		result["password"] = *password
+	}
+	if err := um.UpdatePassword(name, *password); err != nil {
+		return fail(out, exitError, err)
+	}
+	writeJSON(out, result)
+	return exitOK
+}
+
+func userAddKeyCommand(cfg *config.Config, args []string, out io.Writer) int {
+	rest, err := parseArgs(flag.NewFlagSet("user add-key", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return exitUsage
+	}
+	name := rest[0]
+
+	um, done, err := openUsers(cfg)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	defer done()
+
+	key := um.GenerateKey(name)
+	if key == nil {
+		return fail(out, exitFailed, fmt.Errorf("user %q does not exist", name))
+	}
+	writeJSON(out, jsonObject{"username": name, "accessKeyId": key.AccessKeyID, "secretAccessKey": key.SecretAccessKey})
+	return exitOK
+}
+
+// bucketInfo is a line of bucket list
+type bucketInfo struct {
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	CreatedAt  time.Time `json:"created_at"`
+	Versioning bool      `json:"versioning"`
+	ObjectLock bool      `json:"object_lock"`
+	Erasure    string    `json:"erasure,omitempty"`
+	Objects    int       `json:"objects"`
+	Bytes      int64     `json:"bytes"`
+}
+
+func bucketListCommand(cfg *config.Config, args []string, out io.Writer) int {
+	if _, err := parseArgs(flag.NewFlagSet("bucket list", flag.ContinueOnError), args, 0); err != nil {
+		return exitUsage
+	}
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	defer db.Close()
+
+	names, err := db.ListBuckets()
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	buckets := []bucketInfo{}
+	for _, name := range names {
+		b, err := db.GetBucket(name)
+		if err != nil || b == nil {
+			return fail(out, exitError, fmt.Errorf("read bucket %s: %v", name, err))
+		}
+		info := bucketInfo{Name: b.Name, Owner: b.Owner, CreatedAt: b.CreatedAt, Versioning: b.VersioningEnabled, ObjectLock: b.ObjectLockEnabled}
+		if b.ErasureDataShards > 0 {
+			info.Erasure = fmt.Sprintf("%d+%d", b.ErasureDataShards, b.ErasureParityShards)
+		}
+		if info.Objects, info.Bytes, err = db.GetBucketStats(name); err != nil {
+			return fail(out, exitError, err)
+		}
+		buckets = append(buckets, info)
+	}
+	writeJSON(out, buckets)
+	return exitOK
+}
+
+func bucketCreateCommand(cfg *config.Config, args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("bucket create", flag.ContinueOnError)
+	versioning := fs.Bool("versioning", false, "Enable versioning")
+	rest, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return exitUsage
+	}
+	name := rest[0]
+
+	store, done, err := openStorage(cfg)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	defer done()
+
+	if exists, err := store.DB.BucketExists(name); err != nil {
+		return fail(out, exitError, err)
+	} else if exists {
+		return fail(out, exitFailed, fmt.Errorf("bucket %q already exists", name))
+	}
+	if err := store.CreateBucket(name); err != nil {
+		return fail(out, exitFailed, err)
+	}
+	if *versioning {
+		if err := store.DB.SetBucketVersioning(name, true); err != nil {
+			return fail(out, exitError, err)
+		}
+	}
+	writeJSON(out, jsonObject{"bucket": name, "versioning": *versioning})
+	return exitOK
+}
+
+func bucketDeleteCommand(cfg *config.Config, args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("bucket delete", flag.ContinueOnError)
+	force := fs.Bool("force", false, "Delete all objects, versions and trash of the bucket too")
+	rest, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return exitUsage
+	}
+	name := rest[0]
+
+	store, done, err := openStorage(cfg)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	defer done()
+
+	if exists, err := store.DB.BucketExists(name); err != nil {
+		return fail(out, exitError, err)
+	} else if !exists {
+		return fail(out, exitFailed, fmt.Errorf("bucket %q does not exist", name))
+	}
+	objects, err := store.DB.CountBucketObjects(name)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	switch {
+	case *force:
+		err = store.ForceDeleteBucket(name)
+	case objects > 0:
+		return fail(out, exitFailed, fmt.Errorf("bucket %q is not empty (%d object versions); use --force to delete them", name, objects))
+	default:
+		err = store.DeleteBucket(name)
+	}
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	writeJSON(out, jsonObject{"bucket": name, "deleted_objects": objects})
+	return exitOK
+}
+
+// bucketErasureCommand sets the erasure code of the objects a bucket stores from now on;
+// existing objects keep their layout
+func bucketErasureCommand(cfg *config.Config, args []string, out io.Writer) int {
+	rest, err := parseArgs(flag.NewFlagSet("bucket erasure", flag.ContinueOnError), args, 2)
+	if err != nil {
+		return exitUsage
+	}
+	name, layout := rest[0], rest[1]
+	var dataShards, parityShards int
+	if layout != "off" {
+		if _, err := fmt.Sscanf(layout, "%d+%d", &dataShards, &parityShards); err != nil {
+			return fail(out, exitUsage, fmt.Errorf("invalid layout %q, want data+parity shards like 4+2 or off", layout))
+		}
+	}
+
+	store, done, err := openStorage(cfg)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	defer done()
+
+	if exists, err := store.DB.BucketExists(name); err != nil {
+		return fail(out, exitError, err)
+	} else if !exists {
+		return fail(out, exitFailed, fmt.Errorf("bucket %q does not exist", name))
+	}
+	if err := store.SetBucketErasure(name, dataShards, parityShards); err != nil {
+		if errors.Is(err, storage.ErrErasureLayout) {
+			return fail(out, exitFailed, err)
+		}
+		return fail(out, exitError, err)
+	}
+	writeJSON(out, jsonObject{"bucket": name, "data_shards": dataShards, "parity_shards": parityShards})
+	return exitOK
+}
+
+// dbStatusCommand exits with exitFailed when migrations are pending
+func dbStatusCommand(cfg *config.Config, args []string, out io.Writer) int {
+	if _, err := parseArgs(flag.NewFlagSet("db status", flag.ContinueOnError), args, 0); err != nil {
+		return exitUsage
+	}
+	db, err := database.Connect(cfg.Database.URL, cfg.Database.AuthToken, cfg.Paths.Database)
+	if err != nil {
+		return fail(out, exitError, fmt.Errorf("failed to open database: %w", err))
+	}
+	defer db.Close()
+
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	counts, err := db.TableRowCounts()
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	writeJSON(out, jsonObject{"up_to_date": len(pending) == 0, "pending": nonNil(pending), "tables": counts})
+	if len(pending) > 0 {
+		return exitFailed
+	}
+	return exitOK
+}
+
+func dbMigrateCommand(cfg *config.Config, args []string, out io.Writer) int {
+	if _, err := parseArgs(flag.NewFlagSet("db migrate", flag.ContinueOnError), args, 0); err != nil {
+		return exitUsage
+	}
+	db, err := database.Connect(cfg.Database.URL, cfg.Database.AuthToken, cfg.Paths.Database)
+	if err != nil {
+		return fail(out, exitError, fmt.Errorf("failed to open database: %w", err))
+	}
+	defer db.Close()
+
+	applied, err := db.Migrate()
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	writeJSON(out, jsonObject{"applied": nonNil(applied)})
+	return exitOK
+}
+
+// fsckCommand exits with exitFailed when problems are left unrepaired
+func fsckCommand(cfg *config.Config, args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	var opts storage.FsckOptions
+	fs.BoolVar(&opts.Repair, "repair", false, "Repair the problems found instead of only reporting them")
+	fs.BoolVar(&opts.Quick, "quick", false, "Skip reading every object to verify its content hash")
+	fs.DurationVar(&opts.StaleUploadAge, "upload-age", storage.DefaultStaleUploadAge, "Idle time after which a multipart upload is stale")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return exitUsage
+	}
+	store, done, err := openStorage(cfg)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	defer done()
+
+	report, err := store.Fsck(opts)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	writeJSON(out, report)
+	if report.Unrepaired() > 0 {
+		return exitFailed
+	}
+	return exitOK
+}
+
+// exportCommand writes the export to stdout, or to --output followed by a summary on stdout
+func exportCommand(cfg *config.Config, args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("output", "", "Write the export to this file instead of stdout")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return exitUsage
+	}
+	db, err := database.Connect(cfg.Database.URL, cfg.Database.AuthToken, cfg.Paths.Database)
+	if err != nil {
+		return fail(out, exitError, fmt.Errorf("failed to open database: %w", err))
+	}
+	defer db.Close()
+
+	if *output == "" {
+		if _, err := db.Export(out); err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			return exitError
+		}
+		return exitOK
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	counts, err := db.Export(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*output)
+		return fail(out, exitError, err)
+	}
+	writeJSON(out, jsonObject{"output": *output, "tables": counts})
+	return exitOK
+}
+
+func importCommand(cfg *config.Config, args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	replace := fs.Bool("replace", false, "Delete the current rows of every imported table first")
+	rest, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return exitUsage
+	}
+
+	var in io.Reader = os.Stdin
+	if rest[0] != "-" {
+		f, err := os.Open(rest[0])
+		if err != nil {
+			return fail(out, exitError, err)
+		}
+		defer f.Close()
+		in = f
+	}
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	defer db.Close()
+
+	imported, skipped, err := db.Import(in, *replace)
+	if err != nil {
+		writeJSON(out, jsonObject{"error": err.Error(), "imported": imported})
+		return exitFailed
+	}
+	writeJSON(out, jsonObject{"imported": imported, "skipped": nonNil(skipped)})
+	return exitOK
+}
+
+func gcCASCommand(cfg *config.Config, args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("gc-cas", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Only report the blobs that would be removed")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return exitUsage
+	}
+	store, done, err := openStorage(cfg)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	defer done()
+
+	report, err := store.GarbageCollectCAS(*dryRun)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	writeJSON(out, report)
+	return exitOK
+}
+
+// healCommand exits with exitFailed when blobs are lost or still short of copies
+func healCommand(cfg *config.Config, args []string, out io.Writer) int {
+	if _, err := parseArgs(flag.NewFlagSet("heal", flag.ContinueOnError), args, 0); err != nil {
+		return exitUsage
+	}
+	store, done, err := openStorage(cfg)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	defer done()
+
+	report, err := store.HealDrives("heal command")
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	writeJSON(out, report)
+	if len(report.Lost) > 0 || report.UnderReplicated > 0 || len(report.Errors) > 0 {
+		return exitFailed
+	}
+	return exitOK
+}
+
+// auditVerifyCommand exits with exitFailed when the chain is broken
+func auditVerifyCommand(cfg *config.Config, args []string, out io.Writer) int {
+	if _, err := parseArgs(flag.NewFlagSet("audit verify", flag.ContinueOnError), args, 0); err != nil {
+		return exitUsage
+	}
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return fail(out, exitError, err)
+	}
+	defer db.Close()
+
+	signer, err := audit.NewSigner(cfg.Audit.SigningKey)
+	if err != nil {
+		return fail(out, exitError, fmt.Errorf("invalid AUDIT_SIGNING_KEY: %w", err))
+	}
+	result, err := audit.Verify(db, signer)
+	if err != nil {
+		return fail(out, exitError, fmt.Errorf("verification failed: %w", err))
+	}
+
+	writeJSON(out, result)
+	if !result.Valid {
+		return exitFailed
+	}
+	return exitOK
+}
+
+// nonNil makes empty lists print as [] rather than null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
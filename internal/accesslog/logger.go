@@ -0,0 +1,310 @@
+// Package accesslog writes S3 server access logs for buckets that have logging enabled.
+// Entries use the AWS server access log format, so existing log-analysis tools can parse them.
+package accesslog
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/auth"
+	"github.com/GravSpace/GravSpace/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// A target is flushed early once this many entries are waiting
+	maxBatchEntries = 1000
+	// Entries beyond this are dropped while a target cannot be written
+	maxPendingEntries = 100000
+)
+
+type target struct {
+	bucket string
+	prefix string
+}
+
+// Logger buffers access log entries per target and writes them as log objects into the
+// target bucket on every flush interval
+type Logger struct {
+	store         storage.Storage
+	flushInterval time.Duration
+
+	mu      sync.Mutex
+	pending map[target][]string
+
+	wake     chan struct{}
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewLogger(store storage.Storage, flushInterval time.Duration) *Logger {
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Minute
+	}
+	return &Logger{
+		store:         store,
+		flushInterval: flushInterval,
+		pending:       make(map[target][]string),
+		wake:          make(chan struct{}, 1),
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+func (l *Logger) Start() {
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(l.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.quit:
+				l.Flush()
+				return
+			case <-ticker.C:
+				l.Flush()
+			case <-l.wake:
+				l.Flush()
+			}
+		}
+	}()
+	log.Printf("Access logger started (Flush interval: %s)", l.flushInterval)
+}
+
+// Stop writes the remaining entries
+func (l *Logger) Stop() {
+	l.stopOnce.Do(func() {
+		close(l.quit)
+		<-l.done
+	})
+}
+
+func (l *Logger) add(t target, entry string) {
+	l.mu.Lock()
+	queue := l.pending[t]
+	if len(queue) >= maxPendingEntries {
+		l.mu.Unlock()
+		return
+	}
+	l.pending[t] = append(queue, entry)
+	full := len(queue)+1 >= maxBatchEntries
+	l.mu.Unlock()
+
+	if full {
+		select {
+		case l.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes one log object per target with all entries buffered for it
+func (l *Logger) Flush() {
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = make(map[target][]string)
+	l.mu.Unlock()
+
+	for t, entries := range pending {
+		key := t.prefix + time.Now().UTC().Format("2006-01-02-15-04-05") + "-" + randomHex(8)
+		body := strings.Join(entries, "\n") + "\n"
+		if _, err := l.store.PutObject(t.bucket, key, strings.NewReader(body), ""); err != nil {
+			log.Printf("Access logger: failed to write %d entries to %s/%s: %v", len(entries), t.bucket, key, err)
+		}
+	}
+}
+
+// Middleware records one entry per request to a bucket with logging enabled. It also assigns
+// the request ID that is returned in x-amz-request-id and in error responses.
+func (l *Logger) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := randomHex(8)
+		c.Set("s3_request_id", requestID)
+		c.Header("x-amz-request-id", requestID)
+
+		c.Next()
+
+		bucket := c.Param("bucket")
+		if bucket == "" {
+			return
+		}
+		// Only existing buckets can have logging enabled; their info is cached
+		if info, err := l.store.GetBucketInfo(bucket); err != nil || info == nil {
+			return
+		}
+		config, err := l.store.GetBucketLogging(bucket)
+		if err != nil || config == nil {
+			return
+		}
+		l.add(target{bucket: config.TargetBucket, prefix: config.TargetPrefix}, formatEntry(c, bucket, requestID, start))
+	}
+}
+
+// formatEntry renders a request in the AWS server access log format:
+// bucket_owner bucket [time] remote_ip requester request_id operation key "request_uri" http_status
+// error_code bytes_sent object_size total_time turn_around_time "referrer" "user_agent" version_id
+// host_id signature_version cipher_suite authentication_type host_header tls_version access_point_arn acl_required
+func formatEntry(c *gin.Context, bucket, requestID string, start time.Time) string {
+	r := c.Request
+
+	requester := "-"
+	if u, ok := c.Get("user"); ok {
+		if user, ok := u.(*auth.User); ok && user.Username != "anonymous" {
+			requester = user.Username
+		}
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if key != "" {
+		key = (&url.URL{Path: key}).EscapedPath()
+	}
+
+	bytesSent := "-"
+	if n := c.Writer.Size(); n > 0 {
+		bytesSent = strconv.Itoa(n)
+	}
+
+	versionID := c.Writer.Header().Get("x-amz-version-id")
+	if versionID == "" {
+		versionID = c.Query("versionId")
+	}
+
+	signatureVersion, authType := "-", "-"
+	if strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-") {
+		signatureVersion, authType = "SigV4", "AuthHeader"
+	} else if c.Query("X-Amz-Algorithm") != "" {
+		signatureVersion, authType = "SigV4", "QueryString"
+	}
+
+	cipherSuite, tlsVersion := "-", "-"
+	if r.TLS != nil {
+		cipherSuite = tls.CipherSuiteName(r.TLS.CipherSuite)
+		tlsVersion = strings.Replace(tls.VersionName(r.TLS.Version), "TLS 1.", "TLSv1.", 1)
+	}
+
+	fields := []string{
+		"-", // Bucket owner
+		bucket,
+		"[" + start.UTC().Format("02/Jan/2006:15:04:05 -0700") + "]",
+		c.ClientIP(),
+		requester,
+		requestID,
+		operation(c),
+		dash(key),
+		quote(r.Method + " " + r.RequestURI + " " + r.Proto),
+		strconv.Itoa(c.Writer.Status()),
+		dash(c.GetString("s3_error_code")),
+		bytesSent,
+		objectSize(c),
+		strconv.FormatInt(time.Since(start).Milliseconds(), 10),
+		"-", // Turn-around time
+		quote(dash(r.Referer())),
+		quote(dash(r.UserAgent())),
+		dash(versionID),
+		"-", // Host ID
+		signatureVersion,
+		cipherSuite,
+		authType,
+		dash(r.Host),
+		tlsVersion,
+		"-", // Access point ARN
+		"-", // ACL required
+	}
+	return strings.Join(fields, " ")
+}
+
+// Subresources and the name S3 uses for them in the operation field
+var subresourceOperations = []struct{ query, name string }{
+	{"uploadId", "UPLOAD"},
+	{"uploads", "UPLOADS"},
+	{"tagging", "TAGGING"},
+	{"retention", "OBJECT_LOCK_RETENTION"},
+	{"legal-hold", "OBJECT_LOCK_LEGAL_HOLD"},
+	{"restore", "RESTORE"},
+	{"acl", "ACL"},
+	{"versioning", "VERSIONING"},
+	{"versions", "BUCKETVERSIONS"},
+	{"cors", "CORS"},
+	{"lifecycle", "LIFECYCLE"},
+	{"website", "WEBSITE"},
+	{"logging", "LOGGING_STATUS"},
+	{"inventory", "INVENTORY"},
+	{"object-lock", "OBJECT_LOCK_CONFIGURATION"},
+	{"replication", "REPLICATION"},
+	{"policy", "BUCKETPOLICY"},
+	{"location", "LOCATION"},
+	{"delete", "MULTI_OBJECT_DELETE"},
+}
+
+// operation returns the REST.<method>.<resource> name of a request
+func operation(c *gin.Context) string {
+	resource := "BUCKET"
+	if c.Param("key") != "" && c.Param("key") != "/" {
+		resource = "OBJECT"
+		if c.Request.Method == "PUT" && c.GetHeader("x-amz-copy-source") != "" {
+			resource = "OBJECT_COPY"
+		}
+	}
+	query := c.Request.URL.Query()
+	for _, sub := range subresourceOperations {
+		if _, ok := query[sub.query]; ok {
+			if sub.query == "uploadId" && c.Request.Method == "PUT" {
+				resource = "PART"
+			} else {
+				resource = sub.name
+			}
+			break
+		}
+	}
+	return "REST." + c.Request.Method + "." + resource
+}
+
+// objectSize is the full size of the object a request read or wrote
+func objectSize(c *gin.Context) string {
+	if c.Param("key") == "" || c.Param("key") == "/" {
+		return "-"
+	}
+	switch c.Request.Method {
+	case "GET", "HEAD":
+		// Ranged reads report the total size after the slash
+		if cr := c.Writer.Header().Get("Content-Range"); cr != "" {
+			if i := strings.LastIndex(cr, "/"); i >= 0 && cr[i+1:] != "*" {
+				return cr[i+1:]
+			}
+		}
+		if cl := c.Writer.Header().Get("Content-Length"); cl != "" {
+			return cl
+		}
+	case "PUT", "POST":
+		if c.Request.ContentLength > 0 {
+			return strconv.FormatInt(c.Request.ContentLength, 10)
+		}
+	}
+	return "-"
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func quote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
@@ -0,0 +1,200 @@
+package accesslog
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/auth"
+	"github.com/GravSpace/GravSpace/internal/cache"
+	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// serve runs a request through a router with S3-style routes and returns the context the
+// handler saw after the response was written
+func serve(req *http.Request, handler gin.HandlerFunc, after func(c *gin.Context)) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	wrap := func(c *gin.Context) {
+		handler(c)
+		after(c)
+	}
+	r.Any("/:bucket", wrap)
+	r.Any("/:bucket/*key", wrap)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestFormatEntry(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 30, 45, 0, time.UTC)
+	req := httptest.NewRequest(http.MethodGet, "/photos/2026/cat%20one.jpg?versionId=v1", nil)
+	req.RemoteAddr = "192.0.2.10:4711"
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIA/20260301/us-east-1/s3/aws4_request")
+	req.Header.Set("User-Agent", `aws-cli/2.15 "quoted"`)
+	req.Header.Set("Range", "bytes=0-4")
+
+	var entry string
+	serve(req, func(c *gin.Context) {
+		c.Set("user", &auth.User{Username: "alice"})
+		c.Header("Content-Range", "bytes 0-4/11")
+		c.Header("x-amz-version-id", "v1")
+		c.String(http.StatusPartialContent, "hello")
+	}, func(c *gin.Context) {
+		entry = formatEntry(c, "photos", "REQ1", start)
+	})
+
+	want := `- photos [01/Mar/2026:12:30:45 +0000] 192.0.2.10 alice REQ1 REST.GET.OBJECT 2026/cat%20one.jpg ` +
+		`"GET /photos/2026/cat%20one.jpg?versionId=v1 HTTP/1.1" 206 - 5 11 TOTAL - "-" "aws-cli/2.15 \"quoted\"" v1 ` +
+		`- SigV4 - AuthHeader example.com - - -`
+	pattern := "^" + strings.Replace(regexp.QuoteMeta(want), "TOTAL", `\d+`, 1) + "$"
+	if !regexp.MustCompile(pattern).MatchString(entry) {
+		t.Errorf("entry =\n%s\nwant\n%s", entry, want)
+	}
+
+	// Anonymous requests and errors without a body
+	req = httptest.NewRequest(http.MethodPut, "/photos?tagging", strings.NewReader("<Tagging/>"))
+	req.RemoteAddr = "192.0.2.11:4711"
+	serve(req, func(c *gin.Context) {
+		c.Set("user", &auth.User{Username: "anonymous"})
+		c.Set("s3_error_code", "AccessDenied")
+		c.Status(http.StatusForbidden)
+	}, func(c *gin.Context) {
+		entry = formatEntry(c, "photos", "REQ2", start)
+	})
+	want = `- photos [01/Mar/2026:12:30:45 +0000] 192.0.2.11 - REQ2 REST.PUT.TAGGING - "PUT /photos?tagging HTTP/1.1" 403 AccessDenied - - TOTAL - "-" "-" - - - - - example.com - - -`
+	pattern = "^" + strings.Replace(regexp.QuoteMeta(want), "TOTAL", `\d+`, 1) + "$"
+	if !regexp.MustCompile(pattern).MatchString(entry) {
+		t.Errorf("entry =\n%s\nwant\n%s", entry, want)
+	}
+}
+
+func TestOperation(t *testing.T) {
+	cases := []struct {
+		method, path, copySource, want string
+	}{
+		{http.MethodGet, "/photos", "", "REST.GET.BUCKET"},
+		{http.MethodGet, "/photos/", "", "REST.GET.BUCKET"},
+		{http.MethodGet, "/photos/a.jpg", "", "REST.GET.OBJECT"},
+		{http.MethodHead, "/photos/a.jpg", "", "REST.HEAD.OBJECT"},
+		{http.MethodPut, "/photos/a.jpg", "", "REST.PUT.OBJECT"},
+		{http.MethodPut, "/photos/a.jpg", "/other/b.jpg", "REST.PUT.OBJECT_COPY"},
+		{http.MethodPost, "/photos/a.jpg?uploads", "", "REST.POST.UPLOADS"},
+		{http.MethodPut, "/photos/a.jpg?partNumber=1&uploadId=u1", "", "REST.PUT.PART"},
+		{http.MethodPost, "/photos/a.jpg?uploadId=u1", "", "REST.POST.UPLOAD"},
+		{http.MethodDelete, "/photos/a.jpg?uploadId=u1", "", "REST.DELETE.UPLOAD"},
+		{http.MethodGet, "/photos/a.jpg?tagging", "", "REST.GET.TAGGING"},
+		{http.MethodPut, "/photos/a.jpg?legal-hold", "", "REST.PUT.OBJECT_LOCK_LEGAL_HOLD"},
+		{http.MethodGet, "/photos?versions", "", "REST.GET.BUCKETVERSIONS"},
+		{http.MethodGet, "/photos?versioning", "", "REST.GET.VERSIONING"},
+		{http.MethodPut, "/photos?logging", "", "REST.PUT.LOGGING_STATUS"},
+		{http.MethodPost, "/photos?delete", "", "REST.POST.MULTI_OBJECT_DELETE"},
+		{http.MethodPut, "/photos", "", "REST.PUT.BUCKET"},
+		{http.MethodDelete, "/photos", "", "REST.DELETE.BUCKET"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.copySource != "" {
+			req.Header.Set("x-amz-copy-source", tc.copySource)
+		}
+		var got string
+		serve(req, func(c *gin.Context) { c.Status(http.StatusOK) }, func(c *gin.Context) { got = operation(c) })
+		if got != tc.want {
+			t.Errorf("%s %s = %s, want %s", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestObjectSize(t *testing.T) {
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		headers map[string]string
+		want    string
+	}{
+		{"full read", http.MethodGet, "/photos/a.jpg", "", map[string]string{"Content-Length": "11"}, "11"},
+		{"ranged read", http.MethodGet, "/photos/a.jpg", "", map[string]string{"Content-Range": "bytes 0-4/11", "Content-Length": "5"}, "11"},
+		{"unknown total", http.MethodGet, "/photos/a.jpg", "", map[string]string{"Content-Range": "bytes 0-4/*", "Content-Length": "5"}, "5"},
+		{"head", http.MethodHead, "/photos/a.jpg", "", map[string]string{"Content-Length": "42"}, "42"},
+		{"upload", http.MethodPut, "/photos/a.jpg", "hello world", nil, "11"},
+		{"empty upload", http.MethodPut, "/photos/a.jpg", "", nil, "-"},
+		{"delete", http.MethodDelete, "/photos/a.jpg", "", nil, "-"},
+		{"bucket", http.MethodGet, "/photos", "", map[string]string{"Content-Length": "300"}, "-"},
+	}
+	for _, tc := range cases {
+		var body io.Reader
+		if tc.body != "" {
+			body = strings.NewReader(tc.body)
+		}
+		var got string
+		serve(httptest.NewRequest(tc.method, tc.path, body), func(c *gin.Context) {
+			for k, v := range tc.headers {
+				c.Header(k, v)
+			}
+			c.Status(http.StatusOK)
+		}, func(c *gin.Context) { got = objectSize(c) })
+		if got != tc.want {
+			t.Errorf("%s: objectSize = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("DATABASE_URL", "file:"+filepath.Join(root, "test.db"))
+	db, err := database.NewDatabase("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cache.NewInMemoryCache()
+	store := &storage.FileStorage{Root: filepath.Join(root, "data"), DB: db, Cache: c}
+	store.CreateBucket("photos")
+	store.CreateBucket("logs")
+	if err := store.PutBucketLogging("photos", &storage.BucketLoggingConfiguration{TargetBucket: "logs", TargetPrefix: "photos/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLogger(store, time.Hour)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(l.Middleware())
+	r.Any("/:bucket/*key", func(c *gin.Context) { c.Status(http.StatusOK) })
+	for _, path := range []string{"/photos/a.jpg", "/logs/b.jpg", "/ghost/c.jpg"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Header().Get("x-amz-request-id") == "" {
+			t.Errorf("%s: no request ID", path)
+		}
+	}
+
+	// Lookups for buckets that do not exist are not cached
+	var cached storage.BucketLoggingConfiguration
+	if c.Get(cache.BucketLoggingKey("ghost"), &cached) {
+		t.Error("logging configuration cached for a bucket that does not exist")
+	}
+	if !c.Get(cache.BucketLoggingKey("logs"), &cached) || cached.TargetBucket != "" {
+		t.Errorf("disabled logging not cached for an existing bucket: %+v", cached)
+	}
+
+	l.Flush()
+	objects, _, err := store.ListObjects("logs", "photos/", "", "")
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("log objects = %+v, %v", objects, err)
+	}
+	reader, _, err := store.GetObject("logs", objects[0].Key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], " photos ") || !strings.Contains(lines[0], "REST.GET.OBJECT a.jpg") {
+		t.Errorf("log object = %q", data)
+	}
+}
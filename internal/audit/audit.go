@@ -1,14 +1,17 @@
 package audit
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"log"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/tracing"
 	"github.com/gorilla/websocket"
 )
 
@@ -21,39 +24,191 @@ type AuditLog struct {
 	IP        string            `json:"ip"`
 	UserAgent string            `json:"user_agent,omitempty"`
 	Details   map[string]string `json:"details,omitempty"`
+	Seq       int64             `json:"seq,omitempty"`
+	PrevHash  string            `json:"prev_hash,omitempty"`
+	Hash      string            `json:"hash,omitempty"`
+	TraceID   string            `json:"trace_id,omitempty"`
 }
 
 type AuditLogger struct {
-	logFile *os.File
+	logFile *RotatingFile
 	db      *database.Database
-	clients map[*websocket.Conn]bool
+	clients map[*websocket.Conn]*database.AuditLogFilter
 	mu      sync.Mutex
+
+	// Hash chain state, guarded by chainMu so entries are appended one at a time
+	chainMu        sync.Mutex
+	seq            int64
+	lastHash       string
+	checkpointSeq  int64
+	lastSignature  string // Of the checkpoint written last, which the next one signs
+	signer         Signer
+	checkpointQuit chan struct{}
+	checkpointDone chan struct{}
+
+	// Forwarding to external systems, reconfigured at runtime by SetSinks
+	sinksMu     sync.RWMutex
+	sinks       []*bufferedSink
+	sinkConfigs []SinkConfig
 }
 
-func NewAuditLogger(logPath string, db *database.Database) (*AuditLogger, error) {
-	// Ensure log directory exists
-	dir := filepath.Dir(logPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+// NewAuditLogger writes entries to the database and to a local JSON lines file rotated as configured
+func NewAuditLogger(file FileConfig, db *database.Database) (*AuditLogger, error) {
+	if err := file.Validate(); err != nil {
 		return nil, err
 	}
-
-	// Open log file in append mode
-	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	logFile, err := OpenRotatingFile(file)
 	if err != nil {
 		return nil, err
 	}
 
-	return &AuditLogger{
-		logFile: file,
+	a := &AuditLogger{
+		logFile: logFile,
 		db:      db,
-		clients: make(map[*websocket.Conn]bool),
-	}, nil
+		clients: make(map[*websocket.Conn]*database.AuditLogFilter),
+	}
+	if err := a.loadChainHead(); err != nil {
+		logFile.Close()
+		return nil, err
+	}
+	a.loadSinks()
+	return a, nil
+}
+
+// loadChainHead continues the hash chain from the last entry in the database
+func (a *AuditLogger) loadChainHead() error {
+	if a.db == nil {
+		return nil
+	}
+	head, err := a.db.GetAuditChainHead()
+	if err != nil {
+		return err
+	}
+	checkpoints, err := a.db.ListAuditCheckpoints()
+	if err != nil {
+		return err
+	}
+	// Checkpoints are listed by seq; anchors written by retention may have lower ones than earlier checkpoints
+	var lastID int64
+	for _, cp := range checkpoints {
+		if cp.ID > lastID {
+			lastID, a.lastSignature = cp.ID, cp.Signature
+		}
+	}
+	if n := len(checkpoints); n > 0 {
+		a.checkpointSeq = checkpoints[n-1].Seq
+		// Every entry may have been archived by retention; the chain continues from the checkpoint
+		if head == nil || checkpoints[n-1].Seq > head.Seq {
+			head = &database.AuditLogRecord{Seq: checkpoints[n-1].Seq, Hash: checkpoints[n-1].Hash}
+		}
+	}
+	if head != nil {
+		a.seq, a.lastHash = head.Seq, head.Hash
+	}
+	return nil
+}
+
+// SetSigner sets the key used to sign checkpoints. Without one, checkpoints are recorded unsigned.
+func (a *AuditLogger) SetSigner(signer Signer) {
+	a.chainMu.Lock()
+	defer a.chainMu.Unlock()
+	a.signer = signer
+}
+
+// StartCheckpoints records a checkpoint of the chain head on every interval in which new entries were logged
+func (a *AuditLogger) StartCheckpoints(interval time.Duration) {
+	a.checkpointQuit = make(chan struct{})
+	a.checkpointDone = make(chan struct{})
+	go func() {
+		defer close(a.checkpointDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.checkpointQuit:
+				return
+			case <-ticker.C:
+				if _, err := a.Checkpoint(); err != nil {
+					log.Printf("Audit: failed to write checkpoint: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Checkpoint signs the current chain head and stores it. It returns nil when nothing was logged since the last checkpoint.
+func (a *AuditLogger) Checkpoint() (*database.AuditCheckpointRecord, error) {
+	a.chainMu.Lock()
+	defer a.chainMu.Unlock()
+	if a.db == nil || a.seq == 0 || a.seq == a.checkpointSeq {
+		return nil, nil
+	}
+	cp, err := a.writeCheckpoint(a.seq, a.lastHash)
+	if err != nil {
+		return nil, err
+	}
+	a.checkpointSeq = cp.Seq
+	return cp, nil
+}
+
+// writeCheckpoint signs and stores a checkpoint, chained to the previous one, and writes it to the
+// log file and sinks too, so that checkpoints deleted from the database can be told. The caller
+// holds chainMu.
+func (a *AuditLogger) writeCheckpoint(seq int64, hash string) (*database.AuditCheckpointRecord, error) {
+	cp := &database.AuditCheckpointRecord{
+		Seq:           seq,
+		Hash:          hash,
+		Algorithm:     "none",
+		PrevSignature: a.lastSignature,
+		CreatedAt:     time.Now().UTC(),
+	}
+	if a.signer != nil {
+		cp.Algorithm = a.signer.Algorithm()
+		cp.Signature = hex.EncodeToString(a.signer.Sign(checkpointMessage(cp.Seq, cp.Hash, cp.PrevSignature)))
+	}
+	if err := a.db.CreateAuditCheckpoint(cp); err != nil {
+		return nil, err
+	}
+	a.lastSignature = cp.Signature
+
+	a.publish(AuditLog{
+		Timestamp: cp.CreatedAt,
+		User:      "system",
+		Action:    "audit:Checkpoint",
+		Resource:  "audit",
+		Result:    "success",
+		Details: map[string]string{
+			"id":             strconv.FormatInt(cp.ID, 10),
+			"seq":            strconv.FormatInt(cp.Seq, 10),
+			"hash":           cp.Hash,
+			"algorithm":      cp.Algorithm,
+			"signature":      cp.Signature,
+			"prev_signature": cp.PrevSignature,
+		},
+	}, false)
+	return cp, nil
+}
+
+// Verify walks the stored chain using the logger's signing key. The head is sealed with a
+// checkpoint first, so that entries logged since the last one are covered.
+func (a *AuditLogger) Verify() (*VerifyResult, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("audit log is not stored in a database")
+	}
+	if _, err := a.Checkpoint(); err != nil {
+		return nil, err
+	}
+	a.chainMu.Lock()
+	signer, sealed := a.signer, a.seq
+	a.chainMu.Unlock()
+	return verify(a.db, signer, sealed)
 }
 
-func (a *AuditLogger) RegisterClient(ws *websocket.Conn) {
+// RegisterClient streams new entries to a websocket client, only those matching the filter when one is given
+func (a *AuditLogger) RegisterClient(ws *websocket.Conn, filter *database.AuditLogFilter) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	a.clients[ws] = true
+	a.clients[ws] = filter
 }
 
 func (a *AuditLogger) UnregisterClient(ws *websocket.Conn) {
@@ -65,10 +220,13 @@ func (a *AuditLogger) UnregisterClient(ws *websocket.Conn) {
 	}
 }
 
-func (a *AuditLogger) BroadcastLog(jsonData []byte) {
+func (a *AuditLogger) BroadcastLog(entry AuditLog, jsonData []byte) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	for client := range a.clients {
+	for client, filter := range a.clients {
+		if filter != nil && !Matches(*filter, entry) {
+			continue
+		}
 		err := client.WriteMessage(websocket.TextMessage, jsonData)
 		if err != nil {
 			client.Close()
@@ -78,8 +236,33 @@ func (a *AuditLogger) BroadcastLog(jsonData []byte) {
 }
 
 func (a *AuditLogger) Log(entry AuditLog) error {
-	entry.Timestamp = time.Now()
+	entry.Timestamp = chainTimestamp(time.Now())
+	detailsJSON, _ := json.Marshal(entry.Details)
 
+	a.chainMu.Lock()
+	record := &database.AuditLogRecord{
+		Timestamp: entry.Timestamp,
+		Username:  entry.User,
+		Action:    entry.Action,
+		Resource:  entry.Resource,
+		Result:    entry.Result,
+		IP:        entry.IP,
+		UserAgent: entry.UserAgent,
+		Details:   string(detailsJSON),
+		TraceID:   entry.TraceID,
+	}
+	err := a.appendToChain(record)
+	a.chainMu.Unlock()
+	if err != nil {
+		log.Printf("Audit: failed to store entry: %v", err)
+	}
+	entry.Seq, entry.PrevHash, entry.Hash = record.Seq, record.PrevHash, record.Hash
+	return a.publish(entry, true)
+}
+
+// publish writes an entry to the log file, the sinks and stdout, and to the websocket clients
+// when broadcast is set
+func (a *AuditLogger) publish(entry AuditLog, broadcast bool) error {
 	jsonData, err := json.Marshal(entry)
 	if err != nil {
 		return err
@@ -87,26 +270,16 @@ func (a *AuditLogger) Log(entry AuditLog) error {
 
 	// Save to file
 	if a.logFile != nil {
-		a.logFile.WriteString(string(jsonData) + "\n")
+		a.logFile.Write(append(jsonData, '\n'))
 	}
 
-	// Save to database
-	if a.db != nil {
-		detailsJSON, _ := json.Marshal(entry.Details)
-		a.db.CreateAuditLog(&database.AuditLogRecord{
-			Timestamp: entry.Timestamp,
-			Username:  entry.User,
-			Action:    entry.Action,
-			Resource:  entry.Resource,
-			Result:    entry.Result,
-			IP:        entry.IP,
-			UserAgent: entry.UserAgent,
-			Details:   string(detailsJSON),
-		})
-	}
+	// Forward to the configured sinks without waiting for them
+	a.dispatchToSinks(entry)
 
 	// Broadcast to active WebSocket clients
-	a.BroadcastLog(jsonData)
+	if broadcast {
+		a.BroadcastLog(entry, jsonData)
+	}
 
 	// Also print to stdout for container logs
 	fmt.Println(string(jsonData))
@@ -114,7 +287,37 @@ func (a *AuditLogger) Log(entry AuditLog) error {
 	return nil
 }
 
-func (a *AuditLogger) LogSuccess(user, action, resource, ip, userAgent string, details map[string]string) {
+// appendToChain links the record to the chain head and saves it. Another process writing to the
+// same database takes the sequence number first, so the head is reloaded and the insert retried once.
+func (a *AuditLogger) appendToChain(record *database.AuditLogRecord) error {
+	link := func() {
+		record.Seq = a.seq + 1
+		record.PrevHash = a.lastHash
+		record.Hash = EntryHash(record)
+	}
+	link()
+	if a.db == nil {
+		a.seq, a.lastHash = record.Seq, record.Hash
+		return nil
+	}
+
+	err := a.db.CreateAuditLog(record)
+	if err != nil {
+		if reloadErr := a.loadChainHead(); reloadErr != nil {
+			return err
+		}
+		link()
+		err = a.db.CreateAuditLog(record)
+	}
+	if err != nil {
+		return err
+	}
+	a.seq, a.lastHash = record.Seq, record.Hash
+	return nil
+}
+
+// LogSuccess records a successful action, tagged with the trace of the request in ctx
+func (a *AuditLogger) LogSuccess(ctx context.Context, user, action, resource, ip, userAgent string, details map[string]string) {
 	a.Log(AuditLog{
 		User:      user,
 		Action:    action,
@@ -123,10 +326,12 @@ func (a *AuditLogger) LogSuccess(user, action, resource, ip, userAgent string, d
 		IP:        ip,
 		UserAgent: userAgent,
 		Details:   details,
+		TraceID:   tracing.TraceID(ctx),
 	})
 }
 
-func (a *AuditLogger) LogDenied(user, action, resource, ip, userAgent, reason string) {
+// LogDenied records a refused action, tagged with the trace of the request in ctx
+func (a *AuditLogger) LogDenied(ctx context.Context, user, action, resource, ip, userAgent, reason string) {
 	details := map[string]string{
 		"reason": reason,
 	}
@@ -138,10 +343,22 @@ func (a *AuditLogger) LogDenied(user, action, resource, ip, userAgent, reason st
 		IP:        ip,
 		UserAgent: userAgent,
 		Details:   details,
+		TraceID:   tracing.TraceID(ctx),
 	})
 }
 
 func (a *AuditLogger) Close() error {
+	if a.checkpointQuit != nil {
+		close(a.checkpointQuit)
+		<-a.checkpointDone
+	}
+	// Seal the chain so entries written since the last checkpoint cannot be removed unnoticed
+	if _, err := a.Checkpoint(); err != nil {
+		log.Printf("Audit: failed to write checkpoint: %v", err)
+	}
+
+	a.closeSinks()
+
 	a.mu.Lock()
 	for client := range a.clients {
 		client.Close()
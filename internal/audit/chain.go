@@ -0,0 +1,281 @@
+package audit
+
+import (
+	"cmp"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+// chainEntry is the canonical form of an audit entry that is hashed into the chain.
+// Field order is fixed by the struct, so the encoding is stable.
+type chainEntry struct {
+	Seq       int64  `json:"seq"`
+	PrevHash  string `json:"prev_hash"`
+	Timestamp string `json:"timestamp"`
+	User      string `json:"user"`
+	Action    string `json:"action"`
+	Resource  string `json:"resource"`
+	Result    string `json:"result"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Details   string `json:"details"`
+	TraceID   string `json:"trace_id,omitempty"` // Omitted when empty so entries hashed before tracing still verify
+}
+
+// chainTimestamp truncates to microseconds so the hashed time survives a round trip through the database
+func chainTimestamp(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// EntryHash computes the chain hash of an audit row: SHA-256 over the previous hash and the entry's fields
+func EntryHash(l *database.AuditLogRecord) string {
+	data, _ := json.Marshal(chainEntry{
+		Seq:       l.Seq,
+		PrevHash:  l.PrevHash,
+		Timestamp: chainTimestamp(l.Timestamp).Format(time.RFC3339Nano),
+		User:      l.Username,
+		Action:    l.Action,
+		Resource:  l.Resource,
+		Result:    l.Result,
+		IP:        l.IP,
+		UserAgent: l.UserAgent,
+		Details:   l.Details,
+		TraceID:   l.TraceID,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Signer signs checkpoints of the audit chain
+type Signer interface {
+	Algorithm() string
+	Sign(message []byte) []byte
+	Verify(message, signature []byte) bool
+}
+
+type hmacSigner struct {
+	key []byte
+}
+
+func (s *hmacSigner) Algorithm() string { return "hmac-sha256" }
+
+func (s *hmacSigner) Sign(message []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func (s *hmacSigner) Verify(message, signature []byte) bool {
+	return hmac.Equal(s.Sign(message), signature)
+}
+
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+func (s *ed25519Signer) Algorithm() string { return "ed25519" }
+
+func (s *ed25519Signer) Sign(message []byte) []byte {
+	return ed25519.Sign(s.key, message)
+}
+
+func (s *ed25519Signer) Verify(message, signature []byte) bool {
+	return ed25519.Verify(s.key.Public().(ed25519.PublicKey), message, signature)
+}
+
+// NewSigner parses a checkpoint signing key. "ed25519:<base64>" selects Ed25519 with a
+// base64-encoded 32-byte seed or 64-byte private key; any other value is used as an HMAC-SHA256 secret.
+func NewSigner(spec string) (Signer, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	if encoded, ok := strings.CutPrefix(spec, "ed25519:"); ok {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ed25519 key: %w", err)
+		}
+		switch len(raw) {
+		case ed25519.SeedSize:
+			return &ed25519Signer{key: ed25519.NewKeyFromSeed(raw)}, nil
+		case ed25519.PrivateKeySize:
+			return &ed25519Signer{key: ed25519.PrivateKey(raw)}, nil
+		default:
+			return nil, fmt.Errorf("ed25519 key must be %d or %d bytes", ed25519.SeedSize, ed25519.PrivateKeySize)
+		}
+	}
+	return &hmacSigner{key: []byte(spec)}, nil
+}
+
+// checkpointMessage is what a checkpoint signs: the chain head and the signature of the previous
+// checkpoint, so that checkpoints form a chain of their own. Checkpoints written before they were
+// chained have no previous signature.
+func checkpointMessage(seq int64, hash, prevSignature string) []byte {
+	if prevSignature == "" {
+		return []byte(fmt.Sprintf("gravspace-audit:%d:%s", seq, hash))
+	}
+	return []byte(fmt.Sprintf("gravspace-audit:%d:%s:%s", seq, hash, prevSignature))
+}
+
+// VerifyResult reports the outcome of walking the audit chain
+type VerifyResult struct {
+	Valid          bool   `json:"valid"`
+	Entries        int64  `json:"entries"`
+	FirstSeq       int64  `json:"first_seq"`
+	LastSeq        int64  `json:"last_seq"`
+	Checkpoints    int    `json:"checkpoints"`
+	UnsignedChecks int    `json:"unsigned_checkpoints"`
+	Unchained      int64  `json:"unchained_entries"` // Rows written before the chain was introduced
+	BrokenSeq      int64  `json:"broken_seq,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+func (r *VerifyResult) fail(seq int64, reason string) *VerifyResult {
+	r.Valid = false
+	r.BrokenSeq = seq
+	r.Reason = reason
+	return r
+}
+
+// Verify walks the audit chain in the database and reports the first broken link. Checkpoints
+// are checked against their signature and against the chain, which detects rewritten or truncated chains.
+//
+// With a signer every checkpoint must be signed, each must sign the one written before it, and the
+// last entry must be covered by a signed checkpoint: otherwise the checkpoints could have been
+// deleted and the chain rebuilt from scratch.
+func Verify(db *database.Database, signer Signer) (*VerifyResult, error) {
+	return verify(db, signer, 0)
+}
+
+// verify is Verify for a running logger, which may append entries after sealedSeq while the chain
+// is walked; those need not be covered by a checkpoint yet. Zero requires every entry to be covered.
+func verify(db *database.Database, signer Signer, sealedSeq int64) (*VerifyResult, error) {
+	result := &VerifyResult{Valid: true}
+
+	unchained, err := db.CountUnchainedAuditLogs()
+	if err != nil {
+		return nil, err
+	}
+	result.Unchained = unchained
+
+	checkpoints, err := db.ListAuditCheckpoints()
+	if err != nil {
+		return nil, err
+	}
+	result.Checkpoints = len(checkpoints)
+	checkpointsBySeq := make(map[int64][]*database.AuditCheckpointRecord)
+	var signedSeq int64
+	for _, cp := range checkpoints {
+		if cp.Algorithm == "none" {
+			result.UnsignedChecks++
+			if signer != nil {
+				return result.fail(cp.Seq, fmt.Sprintf("checkpoint %d is not signed", cp.ID)), nil
+			}
+		} else {
+			if signer == nil {
+				return nil, fmt.Errorf("checkpoint %d is signed with %s but no signing key is configured", cp.ID, cp.Algorithm)
+			}
+			sig, err := hex.DecodeString(cp.Signature)
+			if err != nil || cp.Algorithm != signer.Algorithm() || !signer.Verify(checkpointMessage(cp.Seq, cp.Hash, cp.PrevSignature), sig) {
+				return result.fail(cp.Seq, fmt.Sprintf("checkpoint %d has an invalid signature", cp.ID)), nil
+			}
+			signedSeq = max(signedSeq, cp.Seq)
+		}
+		checkpointsBySeq[cp.Seq] = append(checkpointsBySeq[cp.Seq], cp)
+	}
+	if signer != nil {
+		if cp := brokenCheckpointLink(checkpoints); cp != nil {
+			return result.fail(cp.Seq, fmt.Sprintf("the checkpoint written before checkpoint %d is missing", cp.ID)), nil
+		}
+	}
+
+	var prev *database.AuditLogRecord
+	var after int64
+	for {
+		batch, err := db.ListAuditChain(after, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range batch {
+			if prev == nil {
+				result.FirstSeq = l.Seq
+				// A chain that does not start at 1 must continue from a checkpoint, written when older rows were archived
+				if l.Seq != 1 && !checkpointMatches(checkpointsBySeq[l.Seq-1], l.PrevHash) {
+					return result.fail(l.Seq, fmt.Sprintf("entries before seq %d are missing", l.Seq)), nil
+				}
+				if l.Seq == 1 && l.PrevHash != "" {
+					return result.fail(l.Seq, "first entry has a previous hash"), nil
+				}
+			} else {
+				if l.Seq != prev.Seq+1 {
+					return result.fail(prev.Seq+1, fmt.Sprintf("entries %d to %d are missing", prev.Seq+1, l.Seq-1)), nil
+				}
+				if l.PrevHash != prev.Hash {
+					return result.fail(l.Seq, "previous hash does not match the preceding entry"), nil
+				}
+			}
+			if EntryHash(l) != l.Hash {
+				return result.fail(l.Seq, "entry hash does not match its contents"), nil
+			}
+			for _, cp := range checkpointsBySeq[l.Seq] {
+				if cp.Hash != l.Hash {
+					return result.fail(l.Seq, fmt.Sprintf("entry does not match checkpoint %d", cp.ID)), nil
+				}
+			}
+			result.Entries++
+			result.LastSeq = l.Seq
+			prev = l
+		}
+		if len(batch) < 1000 {
+			break
+		}
+		after = batch[len(batch)-1].Seq
+	}
+
+	// A checkpoint beyond the last entry means the end of the chain was cut off
+	if n := len(checkpoints); n > 0 && checkpoints[n-1].Seq > result.LastSeq {
+		return result.fail(result.LastSeq+1, fmt.Sprintf("entries after seq %d are missing (checkpoint at seq %d)", result.LastSeq, checkpoints[n-1].Seq)), nil
+	}
+	covered := result.LastSeq
+	if sealedSeq > 0 {
+		covered = min(covered, sealedSeq)
+	}
+	if signer != nil && covered > signedSeq {
+		return result.fail(signedSeq+1, fmt.Sprintf("entries after seq %d are not covered by a signed checkpoint", signedSeq)), nil
+	}
+	return result, nil
+}
+
+// brokenCheckpointLink returns the first checkpoint, in the order they were written, that does not
+// sign the signature of the one before it. Checkpoints written before they were chained are skipped.
+func brokenCheckpointLink(checkpoints []*database.AuditCheckpointRecord) *database.AuditCheckpointRecord {
+	written := slices.Clone(checkpoints)
+	slices.SortFunc(written, func(a, b *database.AuditCheckpointRecord) int { return cmp.Compare(a.ID, b.ID) })
+	for i, cp := range written {
+		if cp.PrevSignature == "" {
+			continue
+		}
+		if i == 0 || written[i-1].Signature != cp.PrevSignature {
+			return cp
+		}
+	}
+	return nil
+}
+
+func checkpointMatches(checkpoints []*database.AuditCheckpointRecord, hash string) bool {
+	for _, cp := range checkpoints {
+		if cp.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
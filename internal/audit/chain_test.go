@@ -0,0 +1,230 @@
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+func TestChainVerification(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DATABASE_URL", "file:"+filepath.Join(dir, "audit.db"))
+	db, err := database.NewDatabase(filepath.Join(dir, "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seed := make([]byte, ed25519.SeedSize)
+	signer, err := NewSigner("ed25519:" + base64.StdEncoding.EncodeToString(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewAuditLogger(FileConfig{Path: filepath.Join(dir, "audit.log")}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.SetSigner(signer)
+	for i := 0; i < 3; i++ {
+		a.LogSuccess(context.Background(), "admin", "s3:CreateBucket", "bucket", "127.0.0.1", "test", map[string]string{"n": "1"})
+	}
+	if cp, err := a.Checkpoint(); err != nil || cp == nil || cp.Seq != 3 || cp.Algorithm != "ed25519" {
+		t.Fatalf("checkpoint = %+v, %v", cp, err)
+	}
+	a.Close()
+
+	result, err := Verify(db, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Entries != 3 || result.LastSeq != 3 {
+		t.Fatalf("intact chain = %+v", result)
+	}
+
+	// A signature made with another key is rejected
+	other, _ := NewSigner("hmac-secret")
+	if result, err := Verify(db, other); err != nil || result.Valid {
+		t.Fatalf("verification with the wrong key = %+v, %v", result, err)
+	}
+
+	// A row appended outside the logger does not link to the chain
+	forged := &database.AuditLogRecord{Timestamp: time.Now(), Username: "mallory", Seq: 4, PrevHash: strings.Repeat("0", 64)}
+	forged.Hash = EntryHash(forged)
+	if err := db.CreateAuditLog(forged); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Verify(db, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid || result.BrokenSeq != 4 {
+		t.Fatalf("forged chain = %+v", result)
+	}
+}
+
+type memoryArchive map[string][]byte
+
+func (m memoryArchive) PutObject(bucket, key string, reader io.Reader, encryptionType string) (string, error) {
+	data, err := io.ReadAll(reader)
+	m[bucket+"/"+key] = data
+	return "", err
+}
+
+func TestRetentionKeepsChainVerifiable(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DATABASE_URL", "file:"+filepath.Join(dir, "audit.db"))
+	db, err := database.NewDatabase(filepath.Join(dir, "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two entries from a month ago, chained like the logger does
+	prev := ""
+	for seq := int64(1); seq <= 2; seq++ {
+		l := &database.AuditLogRecord{Timestamp: chainTimestamp(time.Now().AddDate(0, -1, 0)), Username: "old", Action: "s3:GetObject", Seq: seq, PrevHash: prev}
+		l.Hash = EntryHash(l)
+		if err := db.CreateAuditLog(l); err != nil {
+			t.Fatal(err)
+		}
+		prev = l.Hash
+	}
+
+	a, err := NewAuditLogger(FileConfig{Path: filepath.Join(dir, "audit.log")}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	a.LogSuccess(context.Background(), "admin", "s3:PutObject", "photos/cat.jpg", "10.0.0.1", "test", nil)
+
+	logs, err := db.ListAuditLogs(database.AuditLogFilter{Action: "s3:Get*"}, 10, 0)
+	if err != nil || len(logs) != 2 {
+		t.Fatalf("wildcard filter = %d entries, %v", len(logs), err)
+	}
+	logs, err = db.ListAuditLogs(database.AuditLogFilter{ResourcePrefix: "photos/", Username: "admin"}, 10, 0)
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("resource filter = %d entries, %v", len(logs), err)
+	}
+
+	archive := memoryArchive{}
+	w := NewRetentionWorker(a, archive)
+	if err := w.SetConfig(RetentionConfig{Enabled: true, Days: 7, ArchiveBucket: "audit", ArchivePrefix: "archive/"}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := w.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Archived != 2 || result.FromSeq != 1 || result.ToSeq != 2 {
+		t.Fatalf("retention result = %+v", result)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive["audit/"+result.ArchiveKey]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(gz)
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Fatalf("archive has %d lines", lines)
+	}
+
+	verify, err := Verify(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verify.Valid || verify.FirstSeq != 3 || verify.LastSeq != 4 {
+		t.Fatalf("chain after retention = %+v", verify)
+	}
+}
+
+func TestDeletedCheckpointsAreDetected(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name string) *database.Database {
+		t.Helper()
+		t.Setenv("DATABASE_URL", "file:"+filepath.Join(dir, name))
+		db, err := database.NewDatabase(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	signer, _ := NewSigner("hmac-secret")
+
+	db := open("audit.db")
+	a, err := NewAuditLogger(FileConfig{Path: filepath.Join(dir, "audit.log")}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.SetSigner(signer)
+	for i := 0; i < 4; i++ {
+		a.LogSuccess(context.Background(), "admin", "s3:PutObject", "bucket/key", "127.0.0.1", "test", nil)
+		if i%2 == 1 {
+			a.Checkpoint()
+		}
+	}
+	a.Close()
+	if result, err := Verify(db, signer); err != nil || !result.Valid || result.Checkpoints != 2 {
+		t.Fatalf("intact chain = %+v, %v", result, err)
+	}
+	checkpoints, _ := db.ListAuditCheckpoints()
+	if checkpoints[1].PrevSignature != checkpoints[0].Signature {
+		t.Fatalf("checkpoints are not chained: %+v", checkpoints)
+	}
+	// Checkpoints are written to the log file too, for comparison with the database
+	logged, _ := os.ReadFile(filepath.Join(dir, "audit.log"))
+	if n := strings.Count(string(logged), `"action":"audit:Checkpoint"`); n != 2 {
+		t.Errorf("log file holds %d checkpoints", n)
+	}
+	entries, _ := db.ListAuditChain(0, 100)
+
+	// All checkpoints deleted and the chain rebuilt from seq 1 with a forged entry
+	rewritten := open("rewritten.db")
+	prev := ""
+	for _, l := range entries {
+		forged := *l
+		forged.PrevHash = prev
+		if forged.Seq == 2 {
+			forged.Username = "mallory"
+		}
+		forged.Hash = EntryHash(&forged)
+		if err := rewritten.CreateAuditLog(&forged); err != nil {
+			t.Fatal(err)
+		}
+		prev = forged.Hash
+	}
+	if result, err := Verify(rewritten, signer); err != nil || result.Valid {
+		t.Fatalf("rewritten chain without checkpoints = %+v, %v", result, err)
+	}
+
+	// The first checkpoint deleted
+	pruned := open("pruned.db")
+	for _, l := range entries {
+		if err := pruned.CreateAuditLog(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kept := *checkpoints[1]
+	pruned.CreateAuditCheckpoint(&kept)
+	if result, err := Verify(pruned, signer); err != nil || result.Valid || !strings.Contains(result.Reason, "missing") {
+		t.Fatalf("chain with a deleted checkpoint = %+v, %v", result, err)
+	}
+
+	// An unsigned checkpoint does not count when a key is configured
+	unsigned := open("unsigned.db")
+	for _, l := range entries {
+		unsigned.CreateAuditLog(l)
+	}
+	unsigned.CreateAuditCheckpoint(&database.AuditCheckpointRecord{Seq: 4, Hash: entries[3].Hash, Algorithm: "none", CreatedAt: time.Now()})
+	if result, err := Verify(unsigned, signer); err != nil || result.Valid {
+		t.Fatalf("chain with an unsigned checkpoint = %+v, %v", result, err)
+	}
+}
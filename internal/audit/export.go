@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+// Export formats
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// ExportRecord is the exported form of an audit entry. Details keep the stored JSON text so
+// exported and archived entries can still be checked against their chain hash.
+type ExportRecord struct {
+	ID        int64     `json:"id"`
+	Seq       int64     `json:"seq,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	User      string    `json:"user"`
+	Action    string    `json:"action"`
+	Resource  string    `json:"resource"`
+	Result    string    `json:"result"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Details   string    `json:"details"`
+	PrevHash  string    `json:"prev_hash,omitempty"`
+	Hash      string    `json:"hash,omitempty"`
+	TraceID   string    `json:"trace_id,omitempty"`
+}
+
+func exportRecord(l *database.AuditLogRecord) ExportRecord {
+	return ExportRecord{
+		ID:        l.ID,
+		Seq:       l.Seq,
+		Timestamp: l.Timestamp.UTC(),
+		User:      l.Username,
+		Action:    l.Action,
+		Resource:  l.Resource,
+		Result:    l.Result,
+		IP:        l.IP,
+		UserAgent: l.UserAgent,
+		Details:   l.Details,
+		PrevHash:  l.PrevHash,
+		Hash:      l.Hash,
+		TraceID:   l.TraceID,
+	}
+}
+
+var csvHeader = []string{"id", "seq", "timestamp", "user", "action", "resource", "result", "ip", "user_agent", "details", "prev_hash", "hash", "trace_id"}
+
+// Exporter writes audit entries in one of the export formats
+type Exporter struct {
+	format string
+	csv    *csv.Writer
+	json   *json.Encoder
+}
+
+func NewExporter(w io.Writer, format string) (*Exporter, error) {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &Exporter{format: format, csv: cw}, nil
+	case FormatJSONL:
+		return &Exporter{format: format, json: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+func (e *Exporter) Write(l *database.AuditLogRecord) error {
+	r := exportRecord(l)
+	if e.json != nil {
+		return e.json.Encode(r)
+	}
+	seq := ""
+	if r.Seq > 0 {
+		seq = strconv.FormatInt(r.Seq, 10)
+	}
+	return e.csv.Write([]string{strconv.FormatInt(r.ID, 10), seq, r.Timestamp.Format(time.RFC3339Nano),
+		r.User, r.Action, r.Resource, r.Result, r.IP, r.UserAgent, r.Details, r.PrevHash, r.Hash, r.TraceID})
+}
+
+// Flush writes buffered CSV rows
+func (e *Exporter) Flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	return nil
+}
+
+// Matches reports whether a live entry passes a filter, using the same rules as the database query
+func Matches(f database.AuditLogFilter, entry AuditLog) bool {
+	if f.Since != nil && entry.Timestamp.Before(*f.Since) {
+		return false
+	}
+	if f.Until != nil && !entry.Timestamp.Before(*f.Until) {
+		return false
+	}
+	if f.Username != "" && entry.User != f.Username {
+		return false
+	}
+	if f.Action != "" && !f.MatchesAction(entry.Action) {
+		return false
+	}
+	if f.ResourcePrefix != "" && !strings.HasPrefix(entry.Resource, f.ResourcePrefix) {
+		return false
+	}
+	if f.Result != "" && entry.Result != f.Result {
+		return false
+	}
+	if f.IP != "" && entry.IP != f.IP {
+		return false
+	}
+	if f.Search != "" {
+		details, _ := json.Marshal(entry.Details)
+		if !strings.Contains(strings.ToLower(string(details)), strings.ToLower(f.Search)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+func TestMatchesAgreesWithTheDatabase(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DATABASE_URL", "file:"+filepath.Join(dir, "audit.db"))
+	db, err := database.NewDatabase(filepath.Join(dir, "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAuditLogger(FileConfig{Path: filepath.Join(dir, "audit.log")}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := []string{"s3:GetObject", "s3:PutObject", "S3:GETOBJECTACL", "s3:Get[x]", `s3:a\b`, "s3:Get_Thing", "s3:Get%"}
+	for _, action := range actions {
+		a.LogSuccess(context.Background(), "admin", action, "bucket", "127.0.0.1", "test", nil)
+	}
+
+	for _, pattern := range []string{"s3:GetObject", "s3:Get*", "s3:?utObject", "*object*", "s3:Get[*", `s3:a\*`, "s3:Get_*", "s3:Get%", "s3:Get?"} {
+		filter := database.AuditLogFilter{Action: pattern}
+		rows, err := db.ListAuditLogs(filter, 100, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fromDB, live []string
+		for _, r := range rows {
+			fromDB = append(fromDB, r.Action)
+		}
+		for _, action := range actions {
+			if Matches(filter, AuditLog{Action: action}) {
+				live = append(live, action)
+			}
+		}
+		sort.Strings(fromDB)
+		sort.Strings(live)
+		if strings.Join(fromDB, " ") != strings.Join(live, " ") || len(live) == 0 {
+			t.Errorf("%q: database matches %q, live filter %q", pattern, fromDB, live)
+		}
+	}
+}
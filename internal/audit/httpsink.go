@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPConfig posts batches of entries as a JSON array to an endpoint
+type HTTPConfig struct {
+	URL           string            `json:"url"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	BatchSize     int               `json:"batch_size,omitempty"`     // Entries per request (default 100)
+	FlushInterval string            `json:"flush_interval,omitempty"` // Send a partial batch after this long (default 1s)
+	Timeout       string            `json:"timeout,omitempty"`        // Request timeout (default 10s)
+}
+
+func (c HTTPConfig) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL")
+	}
+	if c.BatchSize < 0 {
+		return fmt.Errorf("batch_size must not be negative")
+	}
+	for name, value := range map[string]string{"flush_interval": c.FlushInterval, "timeout": c.Timeout} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s duration %q", name, value)
+		}
+	}
+	return nil
+}
+
+type HTTPSink struct {
+	config HTTPConfig
+	client *http.Client
+}
+
+func NewHTTPSink(config HTTPConfig) (*HTTPSink, error) {
+	timeout := 10 * time.Second
+	if d, err := time.ParseDuration(config.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+	return &HTTPSink{config: config, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (s *HTTPSink) Send(entries []AuditLog) error {
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
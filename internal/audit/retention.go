@@ -0,0 +1,229 @@
+package audit
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+// RetentionSettingKey is the system_settings key the retention policy is persisted under
+const RetentionSettingKey = "audit_retention"
+
+// RetentionConfig moves audit entries older than Days into a bucket as gzip-compressed JSON lines
+type RetentionConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Days          int    `json:"days"`
+	ArchiveBucket string `json:"archive_bucket"`
+	ArchivePrefix string `json:"archive_prefix"`
+}
+
+func (c RetentionConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Days < 1 {
+		return fmt.Errorf("days must be at least 1")
+	}
+	if c.ArchiveBucket == "" {
+		return fmt.Errorf("archive_bucket is required")
+	}
+	return nil
+}
+
+// ArchiveStore is where archived entries are written, implemented by storage.FileStorage
+type ArchiveStore interface {
+	PutObject(bucket, key string, reader io.Reader, encryptionType string) (string, error)
+}
+
+// RetentionResult describes one retention run
+type RetentionResult struct {
+	Archived   int64  `json:"archived"`
+	ArchiveKey string `json:"archive_key,omitempty"`
+	FromSeq    int64  `json:"from_seq,omitempty"`
+	ToSeq      int64  `json:"to_seq,omitempty"`
+}
+
+// RetentionWorker applies the retention policy every hour
+type RetentionWorker struct {
+	logger *AuditLogger
+	store  ArchiveStore
+
+	mu     sync.Mutex
+	config RetentionConfig
+	runMu  sync.Mutex
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+func NewRetentionWorker(logger *AuditLogger, store ArchiveStore) *RetentionWorker {
+	w := &RetentionWorker{
+		logger: logger,
+		store:  store,
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if logger.db != nil {
+		if data, err := logger.db.GetSystemSetting(RetentionSettingKey); err == nil && data != "" {
+			if err := json.Unmarshal([]byte(data), &w.config); err != nil {
+				log.Printf("Audit: ignoring invalid retention policy: %v", err)
+			}
+		}
+	}
+	return w
+}
+
+func (w *RetentionWorker) Config() RetentionConfig {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.config
+}
+
+// SetConfig validates and persists a new retention policy
+func (w *RetentionWorker) SetConfig(config RetentionConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if w.logger.db == nil {
+		return fmt.Errorf("audit log is not stored in a database")
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := w.logger.db.SetSystemSetting(RetentionSettingKey, string(data)); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.config = config
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *RetentionWorker) Start() {
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.quit:
+				return
+			case <-ticker.C:
+				if !w.Config().Enabled {
+					continue
+				}
+				if result, err := w.Run(); err != nil {
+					log.Printf("Audit: retention failed: %v", err)
+				} else if result.Archived > 0 {
+					log.Printf("Audit: archived %d entries to %s", result.Archived, result.ArchiveKey)
+				}
+			}
+		}
+	}()
+	log.Println("Audit retention worker started")
+}
+
+func (w *RetentionWorker) Stop() {
+	close(w.quit)
+	<-w.done
+}
+
+// Run archives the entries older than the retention period and removes them from the table.
+// The rows are only deleted after the archive was written, and a checkpoint of the last archived
+// entry anchors the remaining chain so it still verifies.
+func (w *RetentionWorker) Run() (*RetentionResult, error) {
+	w.runMu.Lock()
+	defer w.runMu.Unlock()
+
+	config := w.Config()
+	if config.Days < 1 || config.ArchiveBucket == "" {
+		return nil, fmt.Errorf("no retention policy configured")
+	}
+	db := w.logger.db
+	if db == nil {
+		return nil, fmt.Errorf("audit log is not stored in a database")
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -config.Days)
+	maxSeq, unchained, err := db.GetAuditRetentionBoundary(cutoff)
+	if err != nil {
+		return nil, err
+	}
+	result := &RetentionResult{ToSeq: maxSeq}
+	if maxSeq == 0 && unchained == 0 {
+		return result, nil
+	}
+
+	// Write the archive to a temporary file first, so no query is open while the object is stored
+	tmp, err := os.CreateTemp("", "audit-archive-*.jsonl.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	gz := gzip.NewWriter(tmp)
+	exporter, _ := NewExporter(gz, FormatJSONL)
+	err = db.ForEachAuditLogBefore(cutoff, maxSeq, func(l *database.AuditLogRecord) error {
+		if result.FromSeq == 0 && l.Seq > 0 {
+			result.FromSeq = l.Seq
+		}
+		result.Archived++
+		return exporter.Write(l)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	result.ArchiveKey = config.ArchivePrefix + cutoff.Format("2006-01-02-15-04-05") + "-seq-" +
+		strconv.FormatInt(result.FromSeq, 10) + "-" + strconv.FormatInt(maxSeq, 10) + ".jsonl.gz"
+	if _, err := w.store.PutObject(config.ArchiveBucket, result.ArchiveKey, tmp, ""); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	if maxSeq > 0 {
+		if err := w.logger.anchor(maxSeq); err != nil {
+			return nil, err
+		}
+	}
+	w.logger.LogSuccess(context.Background(), "system", "audit:Archive", config.ArchiveBucket+"/"+result.ArchiveKey, "", "", map[string]string{
+		"entries":  strconv.FormatInt(result.Archived, 10),
+		"from_seq": strconv.FormatInt(result.FromSeq, 10),
+		"to_seq":   strconv.FormatInt(maxSeq, 10),
+	})
+
+	if _, err := db.DeleteAuditLogsBefore(cutoff, maxSeq); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// anchor records a checkpoint at an entry, so the chain still verifies once the entries up to it are removed
+func (a *AuditLogger) anchor(seq int64) error {
+	a.chainMu.Lock()
+	defer a.chainMu.Unlock()
+	l, err := a.db.GetAuditLogBySeq(seq)
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		return fmt.Errorf("audit entry %d not found", seq)
+	}
+	_, err = a.writeCheckpoint(l.Seq, l.Hash)
+	return err
+}
@@ -0,0 +1,220 @@
+package audit
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileConfig writes entries as JSON lines to a file that is rotated by size and age
+type FileConfig struct {
+	Path        string `json:"path"`
+	MaxSizeMB   int    `json:"max_size_mb,omitempty"`  // Rotate once the file reaches this size (0 = no limit)
+	RotateEvery string `json:"rotate_every,omitempty"` // Rotate files older than this duration, e.g. 24h
+	MaxAge      string `json:"max_age,omitempty"`      // Remove rotated files older than this duration
+	Compress    bool   `json:"compress,omitempty"`     // Gzip rotated files
+}
+
+func (c FileConfig) Validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if c.MaxSizeMB < 0 {
+		return fmt.Errorf("max_size_mb must not be negative")
+	}
+	for name, value := range map[string]string{"rotate_every": c.RotateEvery, "max_age": c.MaxAge} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s duration %q", name, value)
+		}
+	}
+	return nil
+}
+
+// RotatingFile is an append-only file that rotates to <path>.<timestamp>[.gz] when it grows
+// too large or too old, and removes rotated files past their maximum age
+type RotatingFile struct {
+	path        string
+	maxSize     int64
+	rotateEvery time.Duration
+	maxAge      time.Duration
+	compress    bool
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func OpenRotatingFile(config FileConfig) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:     config.Path,
+		maxSize:  int64(config.MaxSizeMB) * 1024 * 1024,
+		compress: config.Compress,
+	}
+	r.rotateEvery, _ = time.ParseDuration(config.RotateEvery)
+	r.maxAge, _ = time.ParseDuration(config.MaxAge)
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	// An existing file is as old as its first write; the modification time is the best estimate available
+	r.openedAt = time.Now()
+	if r.size > 0 {
+		r.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && ((r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize) ||
+		(r.rotateEvery > 0 && time.Since(r.openedAt) >= r.rotateEvery)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and starts a new one; the caller holds mu
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	rotated := r.path + "." + time.Now().UTC().Format("20060102T150405.000")
+	if err := os.Rename(r.path, rotated); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	// Compression and cleanup run in the background so writers are not held up
+	go func() {
+		if r.compress {
+			if err := gzipFile(rotated); err != nil {
+				log.Printf("Audit: failed to compress %s: %v", rotated, err)
+			}
+		}
+		r.removeExpired()
+	}()
+	return nil
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// removeExpired deletes rotated files older than the maximum age
+func (r *RotatingFile) removeExpired() {
+	if r.maxAge <= 0 {
+		return
+	}
+	matches, _ := filepath.Glob(r.path + ".*")
+	for _, m := range matches {
+		// Leave files that are still being compressed
+		if !strings.HasSuffix(m, ".gz") && r.compress {
+			continue
+		}
+		if info, err := os.Stat(m); err == nil && time.Since(info.ModTime()) > r.maxAge {
+			os.Remove(m)
+		}
+	}
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// FileSink writes entries as JSON lines to a rotating file
+type FileSink struct {
+	file *RotatingFile
+}
+
+func NewFileSink(config FileConfig) (*FileSink, error) {
+	file, err := OpenRotatingFile(config)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Send(entries []AuditLog) error {
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := s.file.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,354 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/metrics"
+)
+
+// SinksSettingKey is the system_settings key the sink configuration is persisted under
+const SinksSettingKey = "audit_sinks"
+
+// Sink types
+const (
+	SinkSyslog = "syslog"
+	SinkFile   = "file"
+	SinkHTTP   = "http"
+)
+
+const (
+	defaultSinkBuffer = 10000
+	sinkMaxAttempts   = 3
+)
+
+// Sink delivers audit entries to an external system. Send is only called from the sink's own
+// delivery goroutine, so implementations need no locking of their own.
+type Sink interface {
+	Send(entries []AuditLog) error
+	Close() error
+}
+
+// SinkConfig configures one audit sink; only the block matching Type is used
+type SinkConfig struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"` // syslog, file or http
+	Enabled    bool   `json:"enabled"`
+	BufferSize int    `json:"buffer_size,omitempty"` // Entries held while the sink is slow; more are dropped
+
+	Syslog *SyslogConfig `json:"syslog,omitempty"`
+	File   *FileConfig   `json:"file,omitempty"`
+	HTTP   *HTTPConfig   `json:"http,omitempty"`
+}
+
+func (c SinkConfig) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("sink name is required")
+	}
+	if c.BufferSize < 0 {
+		return fmt.Errorf("sink %s: buffer_size must not be negative", c.Name)
+	}
+	var err error
+	switch c.Type {
+	case SinkSyslog:
+		if c.Syslog == nil {
+			return fmt.Errorf("sink %s: syslog settings are required", c.Name)
+		}
+		err = c.Syslog.Validate()
+	case SinkFile:
+		if c.File == nil {
+			return fmt.Errorf("sink %s: file settings are required", c.Name)
+		}
+		err = c.File.Validate()
+	case SinkHTTP:
+		if c.HTTP == nil {
+			return fmt.Errorf("sink %s: http settings are required", c.Name)
+		}
+		err = c.HTTP.Validate()
+	default:
+		return fmt.Errorf("sink %s: unknown type %q", c.Name, c.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("sink %s: %w", c.Name, err)
+	}
+	return nil
+}
+
+func newSink(c SinkConfig) (Sink, error) {
+	switch c.Type {
+	case SinkSyslog:
+		return NewSyslogSink(*c.Syslog)
+	case SinkFile:
+		return NewFileSink(*c.File)
+	case SinkHTTP:
+		return NewHTTPSink(*c.HTTP)
+	}
+	return nil, fmt.Errorf("unknown sink type %q", c.Type)
+}
+
+// batchSize is how many entries a sink accepts per Send
+func (c SinkConfig) batchSize() int {
+	if c.Type == SinkHTTP && c.HTTP.BatchSize > 0 {
+		return c.HTTP.BatchSize
+	}
+	if c.Type == SinkHTTP {
+		return 100
+	}
+	return 1
+}
+
+// SinkStatus reports the delivery counters of a running sink
+type SinkStatus struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Queued    int    `json:"queued"`
+	Delivered int64  `json:"delivered"`
+	Failed    int64  `json:"failed"`
+	Dropped   int64  `json:"dropped"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// bufferedSink decouples a sink from the request path: entries go into a bounded queue that is
+// drained by a goroutine, and entries that do not fit are dropped instead of blocking the caller.
+type bufferedSink struct {
+	config SinkConfig
+	sink   Sink
+	queue  chan AuditLog
+	done   chan struct{}
+
+	mu     sync.Mutex
+	status SinkStatus
+}
+
+func startSink(config SinkConfig) (*bufferedSink, error) {
+	sink, err := newSink(config)
+	if err != nil {
+		return nil, err
+	}
+	size := config.BufferSize
+	if size == 0 {
+		size = defaultSinkBuffer
+	}
+	b := &bufferedSink{
+		config: config,
+		sink:   sink,
+		queue:  make(chan AuditLog, size),
+		done:   make(chan struct{}),
+		status: SinkStatus{Name: config.Name, Type: config.Type},
+	}
+	go b.run()
+	return b, nil
+}
+
+func (b *bufferedSink) enqueue(entry AuditLog) {
+	select {
+	case b.queue <- entry:
+	default:
+		b.mu.Lock()
+		b.status.Dropped++
+		b.mu.Unlock()
+		metrics.RecordAuditSinkEntries(b.config.Name, "dropped", 1)
+	}
+}
+
+func (b *bufferedSink) run() {
+	defer close(b.done)
+	batchSize := b.config.batchSize()
+	flushInterval := time.Second
+	if b.config.Type == SinkHTTP && b.config.HTTP.FlushInterval != "" {
+		if d, err := time.ParseDuration(b.config.HTTP.FlushInterval); err == nil && d > 0 {
+			flushInterval = d
+		}
+	}
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AuditLog, 0, batchSize)
+	for {
+		select {
+		case entry, ok := <-b.queue:
+			if !ok {
+				b.deliver(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= batchSize {
+				b.deliver(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			b.deliver(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// deliver sends a batch, retrying with a short backoff before giving up on it
+func (b *bufferedSink) deliver(batch []AuditLog) {
+	if len(batch) == 0 {
+		return
+	}
+	var err error
+	for attempt := 0; attempt < sinkMaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		if err = b.sink.Send(batch); err == nil {
+			break
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		b.status.Failed += int64(len(batch))
+		b.status.LastError = err.Error()
+		metrics.RecordAuditSinkEntries(b.config.Name, "failed", len(batch))
+		log.Printf("Audit: sink %s failed to deliver %d entries: %v", b.config.Name, len(batch), err)
+		return
+	}
+	b.status.Delivered += int64(len(batch))
+	metrics.RecordAuditSinkEntries(b.config.Name, "delivered", len(batch))
+}
+
+// stop delivers the queued entries and closes the sink
+func (b *bufferedSink) stop() {
+	close(b.queue)
+	<-b.done
+	if err := b.sink.Close(); err != nil {
+		log.Printf("Audit: failed to close sink %s: %v", b.config.Name, err)
+	}
+}
+
+func (b *bufferedSink) currentStatus() SinkStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := b.status
+	status.Queued = len(b.queue)
+	return status
+}
+
+// SinkConfigs returns the configured sinks
+func (a *AuditLogger) SinkConfigs() []SinkConfig {
+	a.sinksMu.RLock()
+	defer a.sinksMu.RUnlock()
+	return append([]SinkConfig{}, a.sinkConfigs...)
+}
+
+// SinkStatuses returns the delivery counters of the running sinks
+func (a *AuditLogger) SinkStatuses() []SinkStatus {
+	a.sinksMu.RLock()
+	defer a.sinksMu.RUnlock()
+	statuses := []SinkStatus{}
+	for _, s := range a.sinks {
+		statuses = append(statuses, s.currentStatus())
+	}
+	return statuses
+}
+
+// SetSinks replaces the running sinks with the given configuration and persists it.
+// The previous sinks deliver what they have queued before they are closed.
+func (a *AuditLogger) SetSinks(configs []SinkConfig) error {
+	names := make(map[string]bool)
+	for _, c := range configs {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicate sink name %q", c.Name)
+		}
+		names[c.Name] = true
+	}
+
+	var started []*bufferedSink
+	for _, c := range configs {
+		if !c.Enabled {
+			continue
+		}
+		s, err := startSink(c)
+		if err != nil {
+			for _, s := range started {
+				s.stop()
+			}
+			return fmt.Errorf("sink %s: %w", c.Name, err)
+		}
+		started = append(started, s)
+	}
+
+	if a.db != nil {
+		data, err := json.Marshal(configs)
+		if err != nil {
+			return err
+		}
+		if err := a.db.SetSystemSetting(SinksSettingKey, string(data)); err != nil {
+			for _, s := range started {
+				s.stop()
+			}
+			return err
+		}
+	}
+
+	a.sinksMu.Lock()
+	previous := a.sinks
+	a.sinks = started
+	a.sinkConfigs = append([]SinkConfig{}, configs...)
+	a.sinksMu.Unlock()
+
+	for _, s := range previous {
+		s.stop()
+	}
+	return nil
+}
+
+// loadSinks starts the sinks persisted in the database
+func (a *AuditLogger) loadSinks() {
+	if a.db == nil {
+		return
+	}
+	data, err := a.db.GetSystemSetting(SinksSettingKey)
+	if err != nil || data == "" {
+		return
+	}
+	var configs []SinkConfig
+	if err := json.Unmarshal([]byte(data), &configs); err != nil {
+		log.Printf("Audit: ignoring invalid sink configuration: %v", err)
+		return
+	}
+	for _, c := range configs {
+		if !c.Enabled {
+			continue
+		}
+		if err := c.Validate(); err != nil {
+			log.Printf("Audit: skipping sink: %v", err)
+			continue
+		}
+		s, err := startSink(c)
+		if err != nil {
+			log.Printf("Audit: failed to start sink %s: %v", c.Name, err)
+			continue
+		}
+		a.sinks = append(a.sinks, s)
+	}
+	a.sinkConfigs = configs
+}
+
+func (a *AuditLogger) dispatchToSinks(entry AuditLog) {
+	a.sinksMu.RLock()
+	defer a.sinksMu.RUnlock()
+	for _, s := range a.sinks {
+		s.enqueue(entry)
+	}
+}
+
+func (a *AuditLogger) closeSinks() {
+	a.sinksMu.Lock()
+	sinks := a.sinks
+	a.sinks = nil
+	a.sinksMu.Unlock()
+	for _, s := range sinks {
+		s.stop()
+	}
+}
@@ -0,0 +1,192 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogFormat(t *testing.T) {
+	s, err := NewSyslogSink(SyslogConfig{Network: "udp", Address: "127.0.0.1:514"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.hostname = "host"
+	msg := s.format(AuditLog{
+		Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		User:      "alice",
+		Action:    "s3:GetObject",
+		Resource:  `photos/a"b]`,
+		Result:    "denied",
+		IP:        "10.0.0.1",
+		Seq:       7,
+		Hash:      "abc",
+	})
+	want := `<108>1 2024-05-01T12:00:00.000000Z host gravspace `
+	if !strings.HasPrefix(msg, want) {
+		t.Fatalf("header = %q", msg)
+	}
+	if !strings.Contains(msg, ` s3:GetObject [audit@32473 user="alice" action="s3:GetObject" resource="photos/a\"b\]" result="denied" ip="10.0.0.1" seq="7" hash="abc"] {`) {
+		t.Fatalf("structured data = %q", msg)
+	}
+
+	// Facility 0 is kern, not the default
+	kern := 0
+	if s, err = NewSyslogSink(SyslogConfig{Network: "udp", Address: "127.0.0.1:514", Facility: &kern}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := s.format(AuditLog{Timestamp: time.Now(), Action: "s3:GetObject"}); !strings.HasPrefix(msg, "<4>1 ") {
+		t.Errorf("header with facility 0 = %q", msg)
+	}
+	if err := (SyslogConfig{Network: "udp", Address: "127.0.0.1:514", Facility: &kern}).Validate(); err != nil {
+		t.Errorf("facility 0 rejected: %v", err)
+	}
+	user := 24
+	if err := (SyslogConfig{Network: "udp", Address: "127.0.0.1:514", Facility: &user}).Validate(); err == nil {
+		t.Error("facility 24 accepted")
+	}
+}
+
+func TestSyslogTCPFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		length, _ := r.ReadString(' ')
+		n, _ := strconv.Atoi(strings.TrimSpace(length))
+		msg := make([]byte, n)
+		io.ReadFull(r, msg)
+		received <- string(msg)
+	}()
+
+	sink, err := startSink(SinkConfig{Name: "siem", Type: SinkSyslog, Syslog: &SyslogConfig{Network: "tcp", Address: ln.Addr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.enqueue(AuditLog{Timestamp: time.Now(), User: "bob", Action: "s3:PutObject", Result: "success"})
+	select {
+	case msg := <-received:
+		if !strings.HasPrefix(msg, "<110>1 ") || !strings.HasSuffix(msg, "}") {
+			t.Fatalf("framed message = %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	sink.stop()
+	if status := sink.currentStatus(); status.Delivered != 1 {
+		t.Fatalf("status = %+v", status)
+	}
+}
+
+func TestHTTPSinkBatches(t *testing.T) {
+	batches := make(chan []AuditLog, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var batch []AuditLog
+		json.NewDecoder(r.Body).Decode(&batch)
+		batches <- batch
+	}))
+	defer srv.Close()
+
+	sink, err := startSink(SinkConfig{Name: "collector", Type: SinkHTTP, HTTP: &HTTPConfig{
+		URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer token"}, BatchSize: 2, FlushInterval: "50ms",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		sink.enqueue(AuditLog{Action: "s3:GetObject"})
+	}
+	sink.stop()
+
+	total := 0
+	for len(batches) > 0 {
+		total += len(<-batches)
+	}
+	if total != 3 {
+		t.Fatalf("delivered %d entries", total)
+	}
+}
+
+type blockingSink struct{ release chan struct{} }
+
+func (s *blockingSink) Send(entries []AuditLog) error { <-s.release; return nil }
+func (s *blockingSink) Close() error                  { return nil }
+
+func TestSlowSinkDropsInsteadOfBlocking(t *testing.T) {
+	slow := &blockingSink{release: make(chan struct{})}
+	b := &bufferedSink{
+		config: SinkConfig{Name: "slow", Type: SinkFile},
+		sink:   slow,
+		queue:  make(chan AuditLog, 2),
+		done:   make(chan struct{}),
+	}
+	go b.run()
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		b.enqueue(AuditLog{})
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("enqueue blocked on a slow sink")
+	}
+	if status := b.currentStatus(); status.Dropped < 7 {
+		t.Fatalf("status = %+v", status)
+	}
+	close(slow.release)
+	b.stop()
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	f, err := OpenRotatingFile(FileConfig{Path: path, MaxSizeMB: 1, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(strings.Repeat("x", 1023) + "\n")
+	for i := 0; i < 1500; i++ {
+		if _, err := f.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	// The rotated file is compressed in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		matches, _ := filepath.Glob(path + ".*.gz")
+		if len(matches) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rotated files = %v", matches)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != int64(476*len(line)) {
+		t.Fatalf("current file = %v, %v", info, err)
+	}
+}
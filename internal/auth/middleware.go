@@ -12,8 +12,11 @@ import (
 
 	"github.com/GravSpace/GravSpace/internal/audit"
 	"github.com/GravSpace/GravSpace/internal/storage"
+	"github.com/GravSpace/GravSpace/internal/tracing"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type S3Error struct {
@@ -224,10 +227,14 @@ func S3AuthMiddleware(um *UserManager, auditLogger *audit.AuditLogger, store sto
 
 		// Policy Enforcement
 		action, resource := determineS3Action(c)
+		// Name the request span after the S3 operation rather than the catch-all route
+		span := trace.SpanFromContext(c.Request.Context())
+		span.SetName(action)
+		span.SetAttributes(attribute.String("s3.action", action), tracing.Bucket(c.Param("bucket")), attribute.String("enduser.id", user.Username))
 		if !um.CheckPermission(user, action, resource) {
 			// Audit log the denial
 			if auditLogger != nil {
-				auditLogger.LogDenied(user.Username, action, resource, c.ClientIP(), c.GetHeader("User-Agent"), "IAM Policy Denied")
+				auditLogger.LogDenied(c.Request.Context(), user.Username, action, resource, c.ClientIP(), c.GetHeader("User-Agent"), "IAM Policy Denied")
 			}
 			sendS3Error(c, "AccessDenied", "Access Denied by IAM Policy", c.Param("bucket"), c.Param("key"))
 			c.Abort()
@@ -236,6 +243,7 @@ func S3AuthMiddleware(um *UserManager, auditLogger *audit.AuditLogger, store sto
 
 		// Store action & resource for post-request audit
 		c.Set("user", user)
+		c.Set("access_key", accessKeyID)
 		c.Set("s3_action", action)
 		c.Set("s3_resource", resource)
 
@@ -255,6 +263,7 @@ func S3AuthMiddleware(um *UserManager, auditLogger *audit.AuditLogger, store sto
 				Result:    result,
 				IP:        c.ClientIP(),
 				UserAgent: c.GetHeader("User-Agent"),
+				TraceID:   tracing.TraceID(c.Request.Context()),
 				Details: map[string]string{
 					"method":      c.Request.Method,
 					"status_code": fmt.Sprintf("%d", statusCode),
@@ -297,10 +306,21 @@ func AdminOnlyMiddleware(c *gin.Context) {
 }
 
 func sendS3Error(c *gin.Context, code, message, bucket, key string) {
-	reqID := make([]byte, 8)
-	rand.Read(reqID)
+	SendS3ErrorStatus(c, http.StatusForbidden, code, message, bucket, key)
+}
+
+// SendS3ErrorStatus writes an S3-style XML error response with the given HTTP status
+func SendS3ErrorStatus(c *gin.Context, status int, code, message, bucket, key string) {
+	// Reuse the request ID assigned by the access logger so both can be correlated
+	requestID := c.GetString("s3_request_id")
+	if requestID == "" {
+		reqID := make([]byte, 8)
+		rand.Read(reqID)
+		requestID = strings.ToUpper(hex.EncodeToString(reqID))
+	}
 	hostID := make([]byte, 32)
 	rand.Read(hostID)
+	c.Set("s3_error_code", code)
 
 	errRes := S3Error{
 		Code:       code,
@@ -308,11 +328,11 @@ func sendS3Error(c *gin.Context, code, message, bucket, key string) {
 		Key:        key,
 		BucketName: bucket,
 		Resource:   fmt.Sprintf("/%s/%s", bucket, key),
-		RequestId:  strings.ToUpper(hex.EncodeToString(reqID)),
+		RequestId:  requestID,
 		HostId:     hex.EncodeToString(hostID),
 	}
 
-	c.XML(http.StatusForbidden, errRes)
+	c.XML(status, errRes)
 }
 
 func determineS3Action(c *gin.Context) (string, string) {
@@ -367,6 +387,9 @@ func determineS3Action(c *gin.Context) (string, string) {
 		if key == "" {
 			return "s3:PostBucket", resource
 		}
+		if _, ok := c.GetQuery("restore"); ok {
+			return "s3:RestoreObject", resource
+		}
 		return "s3:PutObject", resource // Post is often used for uploads too
 	case "DELETE":
 		if key == "" {
@@ -377,3 +400,25 @@ func determineS3Action(c *gin.Context) (string, string) {
 
 	return "s3:Unknown", resource
 }
+
+// Authentication types of a request, as reported in request metrics
+const (
+	AuthTypeSigned    = "signed"
+	AuthTypePresigned = "presigned"
+	AuthTypeAnonymous = "anonymous"
+)
+
+// S3RequestLabels returns the S3 action a request resolves to and how it is authenticated,
+// following the same rules as S3AuthMiddleware
+func S3RequestLabels(c *gin.Context) (operation, authType string) {
+	operation, _ = determineS3Action(c)
+	switch {
+	case c.Query("X-Amz-Credential") != "":
+		authType = AuthTypePresigned
+	case strings.HasPrefix(c.GetHeader("Authorization"), "AWS4-HMAC-SHA256"):
+		authType = AuthTypeSigned
+	default:
+		authType = AuthTypeAnonymous
+	}
+	return operation, authType
+}
@@ -167,6 +167,10 @@ func BucketWebsiteKey(bucket string) string {
 	return "website:" + bucket
 }
 
+func BucketLoggingKey(bucket string) string {
+	return "logging:" + bucket
+}
+
 func ObjectMetadataKey(bucket, key, versionID string) string {
 	if versionID != "" {
 		return "object:" + bucket + ":" + key + ":" + versionID
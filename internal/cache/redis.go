@@ -101,6 +101,16 @@ func (r *RedisCache) Stats() CacheStats {
 	return stats
 }
 
+// Ping checks that the Redis server is reachable
+func (r *RedisCache) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// Client exposes the underlying connection for features that need more than key/value caching
+func (r *RedisCache) Client() *redis.Client {
+	return r.client
+}
+
 // Close closes the Redis connection
 func (r *RedisCache) Close() error {
 	return r.client.Close()
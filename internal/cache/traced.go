@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedCache records each cache call as a span of the trace in ctx
+type tracedCache struct {
+	Cache
+	ctx context.Context
+}
+
+// Traced wraps c so its calls are recorded as spans of the trace in ctx. When ctx carries no
+// recording span c is returned unchanged.
+func Traced(c Cache, ctx context.Context) Cache {
+	if c == nil || !trace.SpanFromContext(ctx).IsRecording() {
+		return c
+	}
+	if t, ok := c.(*tracedCache); ok {
+		c = t.Cache
+	}
+	return &tracedCache{Cache: c, ctx: ctx}
+}
+
+func (t *tracedCache) Get(key string, target interface{}) bool {
+	start := time.Now()
+	hit := t.Cache.Get(key, target)
+	tracing.Record(t.ctx, "cache.Get", start, nil, attribute.String("cache.key", key), attribute.Bool("cache.hit", hit))
+	return hit
+}
+
+func (t *tracedCache) Set(key string, value interface{}, ttl time.Duration) error {
+	start := time.Now()
+	err := t.Cache.Set(key, value, ttl)
+	tracing.Record(t.ctx, "cache.Set", start, err, attribute.String("cache.key", key))
+	return err
+}
+
+func (t *tracedCache) Delete(key string) error {
+	start := time.Now()
+	err := t.Cache.Delete(key)
+	tracing.Record(t.ctx, "cache.Delete", start, err, attribute.String("cache.key", key))
+	return err
+}
+
+func (t *tracedCache) DeleteByPrefix(prefix string) error {
+	start := time.Now()
+	err := t.Cache.DeleteByPrefix(prefix)
+	tracing.Record(t.ctx, "cache.DeleteByPrefix", start, err, attribute.String("cache.key", prefix))
+	return err
+}
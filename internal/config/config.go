@@ -0,0 +1,627 @@
+// Package config loads the server configuration from a YAML or TOML file, applies environment
+// variable overrides and validates the result.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/health"
+	"github.com/GravSpace/GravSpace/internal/metrics"
+	"github.com/GravSpace/GravSpace/internal/ratelimit"
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Log levels. Request log lines below the level are not written.
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+// Blob store types
+const (
+	BlobStoreDrives = "drives"
+	BlobStoreLocal  = "local"
+	BlobStoreS3     = "s3"
+)
+
+// DefaultDatabaseURL is the local sqld/tursodb sync server
+const DefaultDatabaseURL = "http://127.0.0.1:8085"
+
+// Duration is a time.Duration written as a Go duration string such as "90s" or "5m"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// durationError is returned by Duration.UnmarshalJSON; the decoder does not say which setting
+// it belongs to
+type durationError struct{ value string }
+
+func (e *durationError) Error() string {
+	return fmt.Sprintf("expected a duration such as \"5m\", got %s", e.value)
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return &durationError{value: string(data)}
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return &durationError{value: strconv.Quote(s)}
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config is the complete server configuration
+type Config struct {
+	Server      ServerConfig      `json:"server"`
+	Auth        AuthConfig        `json:"auth"`
+	Paths       PathsConfig       `json:"paths"`
+	Database    DatabaseConfig    `json:"database"`
+	Redis       RedisConfig       `json:"redis"`
+	ColdStorage ColdStorageConfig `json:"cold_storage"`
+	Drives      DrivesConfig      `json:"drives"`
+	BlobStore   BlobStoreConfig   `json:"blob_store"`
+	Workers     WorkersConfig     `json:"workers"`
+	Health      HealthConfig      `json:"health"`
+	Audit       AuditConfig       `json:"audit"`
+	AccessLog   AccessLogConfig   `json:"access_log"`
+	Metrics     MetricsConfig     `json:"metrics"`
+	// RateLimits, when set, replaces the limits stored through the admin API
+	RateLimits *ratelimit.Config `json:"rate_limits,omitempty"`
+}
+
+type ServerConfig struct {
+	AdminPort string `json:"admin_port"`
+	S3Port    string `json:"s3_port"`
+	// Region is reported in event notifications and used to sign presigned URLs
+	Region string `json:"region"`
+	// Host is the host[:port] of presigned URLs; localhost and the S3 port when empty
+	Host            string   `json:"host"`
+	CORSOrigins     []string `json:"cors_origins"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	LogLevel        string   `json:"log_level"`
+}
+
+type AuthConfig struct {
+	JWTSecret    string `json:"jwt_secret"`
+	SSEMasterKey string `json:"sse_master_key"`
+}
+
+type PathsConfig struct {
+	Data string `json:"data"`
+	// Database is the local database file, used when database.url is empty
+	Database string `json:"database"`
+	Logs     string `json:"logs"`
+}
+
+type DatabaseConfig struct {
+	URL       string `json:"url"`
+	AuthToken string `json:"auth_token"`
+}
+
+type RedisConfig struct {
+	URL string `json:"url"`
+}
+
+type ColdStorageConfig struct {
+	Path string `json:"path"`
+	// Compression is "gzip" or "none"
+	Compression string `json:"compression"`
+}
+
+// DrivesConfig spreads the CAS blobs over extra data directories, one per disk, besides
+// paths.data, which keeps the bucket namespace
+type DrivesConfig struct {
+	Paths []string `json:"paths"`
+	// Copies is how many copies of each blob are kept on distinct drives, 1 to 3
+	Copies        int      `json:"copies"`
+	CheckInterval Duration `json:"check_interval"`
+	// AllowUnmounted formats drives that are not mount points, e.g. directories on one disk
+	AllowUnmounted bool `json:"allow_unmounted"`
+}
+
+// BlobStoreConfig moves the CAS blobs off the data drives. Blobs stored before it was set stay
+// where they are.
+type BlobStoreConfig struct {
+	// Type is "drives" to keep the blobs on the data drives, "local" for a directory at Path or
+	// "s3" for a bucket of an S3-compatible service
+	Type string            `json:"type"`
+	Path string            `json:"path"`
+	S3   S3BlobStoreConfig `json:"s3"`
+}
+
+type S3BlobStoreConfig struct {
+	// Endpoint is empty for AWS
+	Endpoint     string `json:"endpoint"`
+	Region       string `json:"region"`
+	Bucket       string `json:"bucket"`
+	Prefix       string `json:"prefix"`
+	AccessKey    string `json:"access_key"`
+	SecretKey    string `json:"secret_key"`
+	UsePathStyle bool   `json:"use_path_style"`
+}
+
+type WorkersConfig struct {
+	SyncInterval             Duration `json:"sync_interval"`
+	LifecycleInterval        Duration `json:"lifecycle_interval"`
+	TrashInterval            Duration `json:"trash_interval"`
+	MetricsReconcileInterval Duration `json:"metrics_reconcile_interval"`
+	// ScrubInterval is how often every CAS blob is re-read and verified; ScrubRateMB caps the
+	// scrubber's reads in MB per second, zero for no limit
+	ScrubInterval Duration `json:"scrub_interval"`
+	ScrubRateMB   int      `json:"scrub_rate_mb"`
+	// JobWorkers and WebhookWorkers are the sizes of the background job and webhook delivery pools
+	JobWorkers     int `json:"job_workers"`
+	WebhookWorkers int `json:"webhook_workers"`
+}
+
+type HealthConfig struct {
+	DiskWarnPercent     float64  `json:"disk_warn_percent"`
+	DiskCriticalPercent float64  `json:"disk_critical_percent"`
+	HeartbeatTimeout    Duration `json:"heartbeat_timeout"`
+}
+
+type AuditConfig struct {
+	// MaxAge is how long rotated files are kept, e.g. "30d" or "720h"; empty keeps them forever
+	MaxAge             string   `json:"max_age"`
+	MaxSizeMB          int      `json:"max_size_mb"`
+	SigningKey         string   `json:"signing_key"`
+	CheckpointInterval Duration `json:"checkpoint_interval"`
+}
+
+type AccessLogConfig struct {
+	FlushInterval Duration `json:"flush_interval"`
+}
+
+type MetricsConfig struct {
+	BucketLabelLimit int `json:"bucket_label_limit"`
+}
+
+// Default returns the configuration used when neither a file nor the environment set a value
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			AdminPort:       "8080",
+			S3Port:          "9000",
+			Region:          "us-east-1",
+			CORSOrigins:     []string{"*"},
+			ShutdownTimeout: Duration(30 * time.Second),
+			LogLevel:        LogInfo,
+		},
+		Paths: PathsConfig{
+			Data:     "./data",
+			Database: "./db/metadata.db",
+			Logs:     "./logs",
+		},
+		Database:    DatabaseConfig{URL: DefaultDatabaseURL},
+		ColdStorage: ColdStorageConfig{Compression: "gzip"},
+		Drives:      DrivesConfig{Copies: 1, CheckInterval: Duration(time.Minute)},
+		BlobStore:   BlobStoreConfig{Type: BlobStoreDrives, S3: S3BlobStoreConfig{Region: "us-east-1"}},
+		Workers: WorkersConfig{
+			SyncInterval:             Duration(5 * time.Minute),
+			LifecycleInterval:        Duration(time.Hour),
+			TrashInterval:            Duration(time.Hour),
+			MetricsReconcileInterval: Duration(5 * time.Minute),
+			ScrubInterval:            Duration(24 * time.Hour),
+			ScrubRateMB:              16,
+			JobWorkers:               4,
+			WebhookWorkers:           5,
+		},
+		Health: HealthConfig{
+			DiskWarnPercent:     health.DefaultDiskWarnPercent,
+			DiskCriticalPercent: health.DefaultDiskCriticalPercent,
+			HeartbeatTimeout:    Duration(health.DefaultHeartbeatTimeout),
+		},
+		Audit: AuditConfig{
+			MaxSizeMB:          100,
+			CheckpointInterval: Duration(time.Hour),
+		},
+		AccessLog: AccessLogConfig{FlushInterval: Duration(5 * time.Minute)},
+		Metrics:   MetricsConfig{BucketLabelLimit: metrics.DefaultBucketLabelLimit},
+	}
+}
+
+// Load reads the configuration file at path (YAML, or TOML when it ends in .toml) on top of the
+// defaults, applies the environment variable overrides and validates the result. An empty path
+// loads the defaults and the environment only.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+	if err := cfg.applyEnv(os.Getenv); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile decodes the file into a generic document and then into the config through JSON, so
+// YAML and TOML share the field names, the duration format and the unknown field checks
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	doc := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	case ".yaml", ".yml", "":
+		err = yaml.Unmarshal(data, &doc)
+	default:
+		return fmt.Errorf("unsupported format %q, use .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return err
+	}
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(normalized))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		var durErr *durationError
+		if errors.As(err, &durErr) {
+			// The setting is the last key before the point where decoding stopped
+			keys := settingKey.FindAllSubmatch(normalized[:dec.InputOffset()], -1)
+			if len(keys) > 0 {
+				return fmt.Errorf("%s: %w", keys[len(keys)-1][1], err)
+			}
+		}
+		return decodeError(err)
+	}
+	return nil
+}
+
+var settingKey = regexp.MustCompile(`"([a-z0-9_]+)":`)
+
+// decodeError rewrites JSON decoding errors in terms of the config file
+func decodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("%s: expected %s, got %s", typeErr.Field, typeName(typeErr.Type), typeErr.Value)
+	}
+	msg := strings.TrimPrefix(err.Error(), "json: ")
+	return errors.New(strings.Replace(msg, "unknown field", "unknown setting", 1))
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64:
+		return "an integer"
+	case reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return "a list"
+	case reflect.Struct, reflect.Map:
+		return "a table of settings"
+	}
+	return t.String()
+}
+
+// applyEnv overrides file settings with the environment variables the server has always read.
+// Unset or empty variables are ignored; malformed values are errors.
+func (c *Config) applyEnv(getenv func(string) string) error {
+	var errs []error
+	str := func(dst *string, names ...string) {
+		for _, name := range names {
+			if v := getenv(name); v != "" {
+				*dst = v
+				return
+			}
+		}
+	}
+	duration := func(dst *Duration, name string, bareMinutes bool) {
+		v := getenv(name)
+		if v == "" {
+			return
+		}
+		// The worker intervals were historically whole minutes
+		if minutes, err := strconv.Atoi(v); err == nil && bareMinutes {
+			*dst = Duration(time.Duration(minutes) * time.Minute)
+			return
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q, expected a value such as \"5m\"", name, v))
+			return
+		}
+		*dst = Duration(d)
+	}
+	integer := func(dst *int, name string) {
+		if v := getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: expected an integer, got %q", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	boolean := func(dst *bool, name string) {
+		if v := getenv(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: expected true or false, got %q", name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	float := func(dst *float64, name string) {
+		if v := getenv(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: expected a number, got %q", name, v))
+				return
+			}
+			*dst = f
+		}
+	}
+
+	str(&c.Server.AdminPort, "ADMIN_PORT")
+	str(&c.Server.S3Port, "S3_PORT")
+	str(&c.Server.Region, "S3_REGION")
+	str(&c.Server.Host, "SERVER_HOST")
+	if v := getenv("CORS_ORIGINS"); v != "" {
+		c.Server.CORSOrigins = nil
+		for _, origin := range strings.Split(v, ",") {
+			c.Server.CORSOrigins = append(c.Server.CORSOrigins, strings.TrimSpace(origin))
+		}
+	}
+	duration(&c.Server.ShutdownTimeout, "SHUTDOWN_TIMEOUT", false)
+	str(&c.Server.LogLevel, "LOG_LEVEL")
+
+	str(&c.Auth.JWTSecret, "JWT_SECRET")
+	str(&c.Auth.SSEMasterKey, "SSE_MASTER_KEY")
+
+	str(&c.Paths.Data, "DATA_DIR")
+	str(&c.Paths.Database, "DATABASE_PATH")
+	str(&c.Paths.Logs, "LOG_DIR")
+	str(&c.Database.URL, "DATABASE_URL", "TURSO_DATABASE_URL")
+	str(&c.Database.AuthToken, "DATABASE_AUTH_TOKEN", "TURSO_AUTH_TOKEN")
+	str(&c.Redis.URL, "REDIS_URL")
+	str(&c.ColdStorage.Path, "COLD_STORAGE_PATH")
+	str(&c.ColdStorage.Compression, "COLD_STORAGE_COMPRESSION")
+	if v := getenv("DATA_DRIVES"); v != "" {
+		c.Drives.Paths = nil
+		for _, path := range strings.Split(v, ",") {
+			c.Drives.Paths = append(c.Drives.Paths, strings.TrimSpace(path))
+		}
+	}
+	integer(&c.Drives.Copies, "DATA_COPIES")
+	boolean(&c.Drives.AllowUnmounted, "DATA_DRIVES_ALLOW_UNMOUNTED")
+	duration(&c.Drives.CheckInterval, "DRIVE_CHECK_INTERVAL", false)
+	str(&c.BlobStore.Type, "BLOB_STORE")
+	str(&c.BlobStore.Path, "BLOB_STORE_PATH")
+	str(&c.BlobStore.S3.Endpoint, "BLOB_STORE_S3_ENDPOINT")
+	str(&c.BlobStore.S3.Region, "BLOB_STORE_S3_REGION")
+	str(&c.BlobStore.S3.Bucket, "BLOB_STORE_S3_BUCKET")
+	str(&c.BlobStore.S3.Prefix, "BLOB_STORE_S3_PREFIX")
+	str(&c.BlobStore.S3.AccessKey, "BLOB_STORE_S3_ACCESS_KEY")
+	str(&c.BlobStore.S3.SecretKey, "BLOB_STORE_S3_SECRET_KEY")
+	boolean(&c.BlobStore.S3.UsePathStyle, "BLOB_STORE_S3_PATH_STYLE")
+
+	duration(&c.Workers.SyncInterval, "SYNC_WORKER_INTERVAL", true)
+	duration(&c.Workers.LifecycleInterval, "LIFECYCLE_WORKER_INTERVAL", true)
+	duration(&c.Workers.TrashInterval, "TRASH_WORKER_INTERVAL", false)
+	duration(&c.Workers.MetricsReconcileInterval, "METRICS_RECONCILE_INTERVAL", false)
+	duration(&c.Workers.ScrubInterval, "SCRUB_INTERVAL", false)
+	integer(&c.Workers.ScrubRateMB, "SCRUB_RATE_MB")
+	integer(&c.Workers.JobWorkers, "JOB_WORKERS")
+	integer(&c.Workers.WebhookWorkers, "WEBHOOK_WORKERS")
+
+	float(&c.Health.DiskWarnPercent, "HEALTH_DISK_WARN_PERCENT")
+	float(&c.Health.DiskCriticalPercent, "HEALTH_DISK_CRITICAL_PERCENT")
+	duration(&c.Health.HeartbeatTimeout, "HEALTH_HEARTBEAT_TIMEOUT", false)
+
+	str(&c.Audit.MaxAge, "AUDIT_LOG_MAX_AGE")
+	integer(&c.Audit.MaxSizeMB, "AUDIT_LOG_MAX_SIZE_MB")
+	str(&c.Audit.SigningKey, "AUDIT_SIGNING_KEY")
+	duration(&c.Audit.CheckpointInterval, "AUDIT_CHECKPOINT_INTERVAL", false)
+	duration(&c.AccessLog.FlushInterval, "ACCESS_LOG_FLUSH_INTERVAL", false)
+	integer(&c.Metrics.BucketLabelLimit, "METRICS_BUCKET_LABEL_LIMIT")
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting, one per line
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	for field, port := range map[string]string{"server.admin_port": c.Server.AdminPort, "server.s3_port": c.Server.S3Port} {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			fail(field, "%q is not a port number between 1 and 65535", port)
+		}
+	}
+	if c.Server.AdminPort == c.Server.S3Port {
+		fail("server.s3_port", "must differ from server.admin_port (%s)", c.Server.AdminPort)
+	}
+	if c.Server.Region == "" {
+		fail("server.region", "must not be empty")
+	}
+	if len(c.Server.CORSOrigins) == 0 {
+		fail("server.cors_origins", "must list at least one origin, or \"*\"")
+	}
+	for _, origin := range c.Server.CORSOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			fail("server.cors_origins", "%q must be \"*\" or start with http:// or https://", origin)
+		}
+	}
+	switch c.Server.LogLevel {
+	case LogDebug, LogInfo, LogWarn, LogError:
+	default:
+		fail("server.log_level", "%q is not one of debug, info, warn, error", c.Server.LogLevel)
+	}
+
+	if n := len(c.Auth.SSEMasterKey); n != 0 && n != 16 && n != 24 && n != 32 {
+		fail("auth.sse_master_key", "must be 16, 24 or 32 bytes long, got %d", n)
+	}
+
+	for field, path := range map[string]string{"paths.data": c.Paths.Data, "paths.logs": c.Paths.Logs} {
+		if path == "" {
+			fail(field, "must not be empty")
+		}
+	}
+	if c.Database.URL == "" && c.Paths.Database == "" {
+		fail("paths.database", "must not be empty when database.url is not set")
+	}
+	if c.ColdStorage.Compression != "gzip" && c.ColdStorage.Compression != "none" {
+		fail("cold_storage.compression", "%q is not one of gzip, none", c.ColdStorage.Compression)
+	}
+
+	drives := map[string]bool{filepath.Clean(c.Paths.Data): true}
+	for _, path := range c.Drives.Paths {
+		if path == "" {
+			fail("drives.paths", "must not contain empty paths")
+		} else if drives[filepath.Clean(path)] {
+			fail("drives.paths", "%q is listed twice or is paths.data", path)
+		}
+		drives[filepath.Clean(path)] = true
+	}
+	if c.Drives.Copies < 1 || c.Drives.Copies > 3 {
+		fail("drives.copies", "must be between 1 and 3, got %d", c.Drives.Copies)
+	} else if c.Drives.Copies > len(c.Drives.Paths)+1 {
+		fail("drives.copies", "%d copies need as many drives, paths.data and %d in drives.paths are configured", c.Drives.Copies, len(c.Drives.Paths))
+	}
+
+	switch c.BlobStore.Type {
+	case BlobStoreDrives:
+	case BlobStoreLocal:
+		if c.BlobStore.Path == "" {
+			fail("blob_store.path", "must not be empty when blob_store.type is local")
+		}
+	case BlobStoreS3:
+		if c.BlobStore.S3.Bucket == "" {
+			fail("blob_store.s3.bucket", "must not be empty when blob_store.type is s3")
+		}
+		if c.BlobStore.S3.Endpoint != "" && !strings.HasPrefix(c.BlobStore.S3.Endpoint, "http://") && !strings.HasPrefix(c.BlobStore.S3.Endpoint, "https://") {
+			fail("blob_store.s3.endpoint", "%q must start with http:// or https://", c.BlobStore.S3.Endpoint)
+		}
+	default:
+		fail("blob_store.type", "%q is not one of drives, local, s3", c.BlobStore.Type)
+	}
+	if c.BlobStore.Type != BlobStoreDrives && c.Drives.Copies > 1 {
+		fail("drives.copies", "must be 1 when blob_store.type is %s: new blobs are not kept on the drives", c.BlobStore.Type)
+	}
+
+	positive := map[string]Duration{
+		"server.shutdown_timeout":            c.Server.ShutdownTimeout,
+		"drives.check_interval":              c.Drives.CheckInterval,
+		"workers.sync_interval":              c.Workers.SyncInterval,
+		"workers.lifecycle_interval":         c.Workers.LifecycleInterval,
+		"workers.trash_interval":             c.Workers.TrashInterval,
+		"workers.metrics_reconcile_interval": c.Workers.MetricsReconcileInterval,
+		"workers.scrub_interval":             c.Workers.ScrubInterval,
+		"health.heartbeat_timeout":           c.Health.HeartbeatTimeout,
+		"audit.checkpoint_interval":          c.Audit.CheckpointInterval,
+		"access_log.flush_interval":          c.AccessLog.FlushInterval,
+	}
+	for field, d := range positive {
+		if d <= 0 {
+			fail(field, "must be a positive duration, got %v", time.Duration(d))
+		}
+	}
+	if c.Workers.JobWorkers < 1 {
+		fail("workers.job_workers", "must be at least 1, got %d", c.Workers.JobWorkers)
+	}
+	if c.Workers.WebhookWorkers < 1 {
+		fail("workers.webhook_workers", "must be at least 1, got %d", c.Workers.WebhookWorkers)
+	}
+	if c.Workers.ScrubRateMB < 0 {
+		fail("workers.scrub_rate_mb", "must not be negative, got %d", c.Workers.ScrubRateMB)
+	}
+
+	for field, p := range map[string]float64{"health.disk_warn_percent": c.Health.DiskWarnPercent, "health.disk_critical_percent": c.Health.DiskCriticalPercent} {
+		if p < 0 || p > 100 {
+			fail(field, "must be between 0 and 100, got %v", p)
+		}
+	}
+	if c.Health.DiskCriticalPercent > c.Health.DiskWarnPercent {
+		fail("health.disk_critical_percent", "must not exceed health.disk_warn_percent (%v)", c.Health.DiskWarnPercent)
+	}
+
+	if c.Audit.MaxSizeMB < 0 {
+		fail("audit.max_size_mb", "must not be negative, got %d", c.Audit.MaxSizeMB)
+	}
+	if c.Metrics.BucketLabelLimit < 0 {
+		fail("metrics.bucket_label_limit", "must not be negative, got %d", c.Metrics.BucketLabelLimit)
+	}
+	if c.RateLimits != nil {
+		if err := c.RateLimits.Validate(); err != nil {
+			fail("rate_limits", "%v", err)
+		}
+	}
+
+	// Map iteration order is random; report the problems in a stable order
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	slices.Sort(messages)
+	if len(messages) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(messages, "\n  "))
+	}
+	return nil
+}
+
+// RestartRequired lists the sections that differ between c and next in settings a reload does
+// not apply
+func (c *Config) RestartRequired(next *Config) []string {
+	a, b := *c, *next
+	for _, cfg := range []*Config{&a, &b} {
+		cfg.Server.CORSOrigins = nil
+		cfg.Server.LogLevel = ""
+		cfg.Workers.SyncInterval = 0
+		cfg.Workers.LifecycleInterval = 0
+		cfg.Workers.TrashInterval = 0
+		cfg.Workers.MetricsReconcileInterval = 0
+		cfg.Workers.ScrubInterval = 0
+		cfg.Workers.ScrubRateMB = 0
+		cfg.Drives.CheckInterval = 0
+		cfg.Metrics = MetricsConfig{}
+		cfg.RateLimits = nil
+	}
+
+	var changed []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			tag, _, _ := strings.Cut(va.Type().Field(i).Tag.Get("json"), ",")
+			changed = append(changed, tag)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFileAndEnv(t *testing.T) {
+	files := map[string]string{
+		"gravspace.yaml": `
+server:
+  region: eu-west-1
+  cors_origins: ["https://console.example.com"]
+paths:
+  data: /srv/gravspace/data
+workers:
+  sync_interval: 90s
+  job_workers: 8
+rate_limits:
+  enabled: true
+  ip:
+    default:
+      write: {rate: 10, burst: 20}
+`,
+		"gravspace.toml": `
+[server]
+region = "eu-west-1"
+cors_origins = ["https://console.example.com"]
+
+[paths]
+data = "/srv/gravspace/data"
+
+[workers]
+sync_interval = "90s"
+job_workers = 8
+
+[rate_limits]
+enabled = true
+
+[rate_limits.ip.default.write]
+rate = 10
+burst = 20
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("LIFECYCLE_WORKER_INTERVAL", "30")
+			t.Setenv("WEBHOOK_WORKERS", "2")
+			cfg, err := Load(writeConfig(t, name, content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Region != "eu-west-1" || cfg.Paths.Data != "/srv/gravspace/data" {
+				t.Errorf("file settings not applied: %+v", cfg.Server)
+			}
+			if !reflect.DeepEqual(cfg.Server.CORSOrigins, []string{"https://console.example.com"}) {
+				t.Errorf("cors origins = %v", cfg.Server.CORSOrigins)
+			}
+			if cfg.Workers.SyncInterval != Duration(90*time.Second) || cfg.Workers.JobWorkers != 8 {
+				t.Errorf("workers = %+v", cfg.Workers)
+			}
+			// Bare numbers in the legacy interval variables are minutes
+			if cfg.Workers.LifecycleInterval != Duration(30*time.Minute) || cfg.Workers.WebhookWorkers != 2 {
+				t.Errorf("env overrides = %+v", cfg.Workers)
+			}
+			if cfg.Server.S3Port != "9000" || cfg.Paths.Database != "./db/metadata.db" {
+				t.Errorf("defaults lost: %+v %+v", cfg.Server, cfg.Paths)
+			}
+			if cfg.RateLimits == nil || !cfg.RateLimits.Enabled || cfg.RateLimits.IP.Default.Write.Burst != 20 {
+				t.Errorf("rate limits = %+v", cfg.RateLimits)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"unknown.yaml", "workers:\n  sync_intreval: 5m\n", `unknown setting "sync_intreval"`},
+		{"type.yaml", "workers:\n  job_workers: many\n", "workers.job_workers: expected an integer, got string"},
+		{"duration.yaml", "workers:\n  trash_interval: 10\n", `trash_interval: expected a duration such as "5m"`},
+		{"invalid.yaml", "server:\n  s3_port: \"8080\"\n  log_level: verbose\nworkers:\n  job_workers: 0\n", "server.log_level"},
+		{"copies.yaml", "drives:\n  paths: [/mnt/disk1]\n  copies: 3\n", "drives.copies: 3 copies need as many drives"},
+		{"drives.yaml", "paths:\n  data: /mnt/disk0\ndrives:\n  paths: [/mnt/disk0/]\n", `drives.paths: "/mnt/disk0/" is listed twice or is paths.data`},
+		{"blobstore.yaml", "blob_store:\n  type: s3\n", "blob_store.s3.bucket: must not be empty"},
+		{"format.ini", "", "unsupported format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.name, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+
+	// Every problem is reported at once
+	_, err := Load(writeConfig(t, "all.yaml", "server:\n  s3_port: \"8080\"\n  log_level: verbose\nworkers:\n  job_workers: 0\n"))
+	for _, field := range []string{"server.s3_port", "server.log_level", "workers.job_workers"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error does not mention %s:\n%v", field, err)
+		}
+	}
+
+	t.Setenv("SYNC_WORKER_INTERVAL", "soon")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "SYNC_WORKER_INTERVAL") {
+		t.Errorf("bad env value error = %v", err)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	cur := Default()
+	next := Default()
+	next.Workers.SyncInterval = Duration(time.Minute)
+	next.Server.CORSOrigins = []string{"https://example.com"}
+	next.Server.LogLevel = LogWarn
+	if changed := cur.RestartRequired(next); len(changed) != 0 {
+		t.Errorf("reloadable changes reported: %v", changed)
+	}
+
+	next.Paths.Data = "/elsewhere"
+	next.Workers.JobWorkers = 16
+	if changed := cur.RestartRequired(next); !reflect.DeepEqual(changed, []string{"paths", "workers"}) {
+		t.Errorf("changed = %v", changed)
+	}
+}
@@ -224,3 +224,15 @@ func (r *decryptReader) Close() error {
 func GetMasterKey() []byte {
 	return masterKey
 }
+
+// SetMasterKey replaces the key read from SSE_MASTER_KEY. It must be called before any object
+// is encrypted or decrypted.
+func SetMasterKey(key string) error {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("master key must be 16, 24 or 32 bytes long, got %d", len(key))
+	}
+	masterKey = []byte(key)
+	return nil
+}
@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -10,7 +12,9 @@ import (
 	"path/filepath"
 
 	"github.com/GravSpace/GravSpace/internal/metrics"
+	"github.com/GravSpace/GravSpace/internal/tracing"
 	_ "github.com/tursodatabase/libsql-client-go/libsql" // Remote Turso/libSQL driver
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/crypto/bcrypt"
 	_ "modernc.org/sqlite"          // SQLite driver (for local)
 	_ "turso.tech/database/tursogo" // Local Turso driver
@@ -18,6 +22,34 @@ import (
 
 type Database struct {
 	db *sql.DB
+
+	// schemaChanges records the tables and columns initSchema added, or would add in a dry run
+	schemaChanges []SchemaChange
+	dryRun        bool
+
+	// ctx carries the trace of the request the queries run for; see WithContext
+	ctx context.Context
+}
+
+// WithContext returns a copy of the database handle whose queries are recorded as spans of the
+// trace in ctx. The copy shares the connection pool.
+func (d *Database) WithContext(ctx context.Context) *Database {
+	if d == nil {
+		return nil
+	}
+	return &Database{db: d.db, ctx: ctx}
+}
+
+// observe records the duration of a query and, when the handle carries a trace, a span for it
+// marked failed by err. A lookup that finds no row is not a failure.
+func (d *Database) observe(operation string, start time.Time, err error) {
+	metrics.RecordDBQuery(operation, time.Since(start))
+	if d.ctx != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		}
+		tracing.Record(d.ctx, "db."+operation, start, err, attribute.String("db.operation.name", operation))
+	}
 }
 
 type BucketRow struct {
@@ -31,6 +63,8 @@ type BucketRow struct {
 	SoftDeleteEnabled    bool
 	SoftDeleteRetention  int // in days
 	QuotaBytes           int64
+	ErasureDataShards    int // Non-zero when new objects are erasure-coded instead of mirrored
+	ErasureParityShards  int
 }
 
 type ObjectRow struct {
@@ -53,6 +87,14 @@ type ObjectRow struct {
 	CompressionType *string // zstd, brotli, etc.
 	OriginalSize    *int64  // Size before compression
 	IsDeduplicated  bool    // Flag for CAS storage
+
+	StorageClass     *string    // STANDARD (NULL), GLACIER or DEEP_ARCHIVE
+	RestoreStatus    *string    // "ongoing" or "restored" for archived objects
+	RestoreExpiresAt *time.Time // When the temporary restored copy is removed
+
+	WebsiteRedirectLocation *string    // x-amz-website-redirect-location
+	ReplicationStatus       *string    // PENDING, COMPLETED or FAILED on sources, REPLICA on replicas
+	CorruptedAt             *time.Time // When the scrubber found the content damaged; reads fail until it is restored
 }
 
 type ObjectTag struct {
@@ -120,6 +162,21 @@ type AuditLogRecord struct {
 	IP        string
 	UserAgent string
 	Details   string // JSON
+	Seq       int64  // 0 for entries written before the hash chain was introduced
+	PrevHash  string
+	Hash      string
+	TraceID   string // Trace of the request that produced the entry
+}
+
+// AuditCheckpointRecord is a signed snapshot of the audit chain head
+type AuditCheckpointRecord struct {
+	ID            int64     `json:"id"`
+	Seq           int64     `json:"seq"`
+	Hash          string    `json:"hash"`
+	Algorithm     string    `json:"algorithm"` // hmac-sha256, ed25519 or none
+	Signature     string    `json:"signature"`
+	PrevSignature string    `json:"prev_signature,omitempty"` // Signature of the checkpoint written before, signed along
+	CreatedAt     time.Time `json:"created_at"`
 }
 
 type StorageSnapshotRecord struct {
@@ -149,6 +206,138 @@ type ReplicationRow struct {
 	Prefix            *string   `json:"prefix"`
 	Enabled           bool      `json:"enabled"`
 	CreatedAt         time.Time `json:"created_at"`
+	TargetID          *int64    `json:"target_id"` // NULL replicates to a bucket on this server
+
+	// DeleteMarkerReplication replicates deletes of the current object (not of specific versions)
+	DeleteMarkerReplication bool `json:"delete_marker_replication"`
+	// MetadataReplication replicates tags, legal holds and retention settings
+	MetadataReplication bool `json:"metadata_replication"`
+}
+
+// JobRow is a persisted background job
+type JobRow struct {
+	ID             int64      `json:"id"`
+	Type           string     `json:"type"`
+	Name           string     `json:"name"`
+	Payload        string     `json:"payload"`
+	UniqueKey      *string    `json:"unique_key,omitempty"`
+	State          string     `json:"state"` // queued, running, succeeded, dead or cancelled
+	Attempts       int        `json:"attempts"`
+	MaxAttempts    int        `json:"max_attempts"`
+	NextRunAt      time.Time  `json:"next_run_at"`
+	LeaseOwner     *string    `json:"lease_owner,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	LastError      *string    `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// ReplicationBackfillRow tracks a job copying objects that existed before a rule was created
+type ReplicationBackfillRow struct {
+	ID           int64      `json:"id"`
+	RuleID       int64      `json:"rule_id"`
+	SourceBucket string     `json:"source_bucket"`
+	Status       string     `json:"status"` // running, completed or failed
+	Scanned      int64      `json:"scanned"`
+	Queued       int64      `json:"queued"`
+	Error        *string    `json:"error,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// QuarantinedBlobRow is a CAS blob the scrubber found damaged and moved out of the store
+type QuarantinedBlobRow struct {
+	Hash         string     `json:"hash"`
+	Path         string     `json:"path"` // Where the damaged blob was moved
+	Size         int64      `json:"size"`
+	Reason       string     `json:"reason"`
+	Objects      int64      `json:"objects"` // Versions flagged as corrupted
+	DetectedAt   time.Time  `json:"detected_at"`
+	RestoredAt   *time.Time `json:"restored_at,omitempty"`
+	RestoredFrom *string    `json:"restored_from,omitempty"`
+}
+
+// ErasureBlobRow describes a blob stored as erasure-coded shards instead of whole copies
+type ErasureBlobRow struct {
+	Hash         string    `json:"hash"`
+	DataShards   int       `json:"data_shards"`
+	ParityShards int       `json:"parity_shards"`
+	BlockSize    int64     `json:"block_size"` // Bytes of one shard per stripe
+	Size         int64     `json:"size"`       // Bytes of the stored blob
+	Checksums    []string  `json:"checksums"`  // SHA-256 of every shard file, data shards first
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// BatchJobRow is a batch operation applied to every object of a manifest. The manifest and
+// the operation parameters are stored as JSON and decoded by the storage layer.
+type BatchJobRow struct {
+	ID            int64      `json:"id"`
+	Operation     string     `json:"operation"`
+	Manifest      string     `json:"-"`
+	Params        string     `json:"-"`
+	Status        string     `json:"status"` // pending, running, completed, failed or cancelled
+	Processed     int64      `json:"processed"`
+	Succeeded     int64      `json:"succeeded"`
+	Failed        int64      `json:"failed"`
+	Cursor        string     `json:"-"` // Position in the manifest the job resumes from
+	RatePerSecond int        `json:"rate_per_second"`
+	ReportBucket  *string    `json:"report_bucket,omitempty"`
+	ReportPrefix  *string    `json:"report_prefix,omitempty"`
+	ReportKey     *string    `json:"report_key,omitempty"`
+	Error         *string    `json:"error,omitempty"`
+	CreatedBy     *string    `json:"created_by,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+// BatchJobResultRow is the outcome of a batch operation for one object
+type BatchJobResultRow struct {
+	BatchID     int64     `json:"batch_id"`
+	Bucket      string    `json:"bucket"`
+	Key         string    `json:"key"`
+	VersionID   string    `json:"version_id"`
+	Status      string    `json:"status"` // succeeded or failed
+	Error       *string   `json:"error,omitempty"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// InventoryRow is an inventory report configuration of a bucket. The configuration is stored
+// as JSON and decoded by the storage layer.
+type InventoryRow struct {
+	Bucket          string
+	ID              string
+	Config          string
+	LastRunAt       *time.Time
+	LastManifestKey *string
+}
+
+// ReplicationTargetRow is a remote S3-compatible endpoint objects can be replicated to
+type ReplicationTargetRow struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Endpoint     string    `json:"endpoint"`
+	Region       string    `json:"region"`
+	AccessKey    string    `json:"access_key"`
+	SecretKey    string    `json:"-"`
+	UsePathStyle bool      `json:"use_path_style"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ReplicationTaskRow is a queued replication of one object version for one rule
+type ReplicationTaskRow struct {
+	ID            int64     `json:"id"`
+	RuleID        int64     `json:"rule_id"`
+	SourceBucket  string    `json:"source_bucket"`
+	Key           string    `json:"key"`
+	VersionID     string    `json:"version_id"`
+	Operation     string    `json:"operation"` // put, delete or metadata
+	Status        string    `json:"status"`    // pending, completed or failed
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     *string   `json:"last_error"`
+	CreatedAt     time.Time `json:"created_at"`
 }
 
 // NewDatabase creates a new database connection
@@ -181,6 +370,28 @@ func NewDatabase(dbPath string) (*Database, error) {
 		dbToken = os.Getenv("TURSO_AUTH_TOKEN")
 	}
 
+	return Open(dbURL, dbToken, dbPath)
+}
+
+// Open connects to the database at dbURL, authenticating with dbToken, and brings the schema up
+// to date. An empty dbURL opens the local SQLite file at dbPath.
+func Open(dbURL, dbToken, dbPath string) (*Database, error) {
+	d, err := Connect(dbURL, dbToken, dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize schema
+	if err := d.initSchema(); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	}
+
+	return d, nil
+}
+
+// Connect connects to the database like Open, but leaves the schema as it is
+func Connect(dbURL, dbToken, dbPath string) (*Database, error) {
 	var db *sql.DB
 	var err error
 
@@ -255,14 +466,7 @@ func NewDatabase(dbPath string) (*Database, error) {
 	// Note: Turso/libSQL driver may not support certain SQLite PRAGMAs directly via Exec.
 	// WAL mode and foreign keys are typically handled by the driver or server.
 
-	d := &Database{db: db}
-
-	// Initialize schema
-	if err := d.initSchema(); err != nil {
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
-	}
-
-	return d, nil
+	return &Database{db: db}, nil
 }
 
 func (d *Database) GetDB() *sql.DB {
@@ -281,7 +485,9 @@ func (d *Database) initSchema() error {
 		default_retention_days INTEGER,
 		soft_delete_enabled BOOLEAN DEFAULT FALSE,
 		soft_delete_retention INTEGER DEFAULT 30,
-		quota_bytes INTEGER DEFAULT 0
+		quota_bytes INTEGER DEFAULT 0,
+		erasure_data_shards INTEGER DEFAULT 0,
+		erasure_parity_shards INTEGER DEFAULT 0
 	);
 
 	CREATE TABLE IF NOT EXISTS objects (
@@ -468,14 +674,150 @@ func (d *Database) initSchema() error {
 		enabled BOOLEAN DEFAULT TRUE,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY (source_bucket) REFERENCES buckets(name) ON DELETE CASCADE,
-		FOREIGN KEY (destination_bucket) REFERENCES buckets(name) ON DELETE CASCADE,
 		UNIQUE(source_bucket, destination_bucket, prefix)
 	);
+
+	CREATE TABLE IF NOT EXISTS replication_targets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		endpoint TEXT NOT NULL,
+		region TEXT NOT NULL DEFAULT 'us-east-1',
+		access_key TEXT NOT NULL,
+		secret_key TEXT NOT NULL,
+		use_path_style BOOLEAN DEFAULT TRUE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS replication_queue (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_id INTEGER NOT NULL,
+		source_bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		version_id TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER DEFAULT 0,
+		next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_error TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_replication_queue_due ON replication_queue(status, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS idx_replication_queue_object ON replication_queue(source_bucket, key, version_id);
+
+	CREATE TABLE IF NOT EXISTS jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		type TEXT NOT NULL,
+		name TEXT,
+		payload TEXT NOT NULL,
+		unique_key TEXT,
+		state TEXT NOT NULL DEFAULT 'queued',
+		attempts INTEGER DEFAULT 0,
+		max_attempts INTEGER DEFAULT 5,
+		next_run_at TIMESTAMP NOT NULL,
+		lease_owner TEXT,
+		lease_expires_at TIMESTAMP,
+		last_error TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		finished_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(state, next_run_at);
+	CREATE INDEX IF NOT EXISTS idx_jobs_unique ON jobs(unique_key, state);
+
+	CREATE TABLE IF NOT EXISTS batch_jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		operation TEXT NOT NULL,
+		manifest TEXT NOT NULL,
+		params TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		processed INTEGER DEFAULT 0,
+		succeeded INTEGER DEFAULT 0,
+		failed INTEGER DEFAULT 0,
+		cursor TEXT NOT NULL DEFAULT '',
+		rate_per_second INTEGER DEFAULT 0,
+		report_bucket TEXT,
+		report_prefix TEXT,
+		report_key TEXT,
+		error TEXT,
+		created_by TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		started_at TIMESTAMP,
+		finished_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS batch_job_results (
+		batch_id INTEGER NOT NULL,
+		bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		version_id TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		error TEXT,
+		processed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (batch_id, bucket, key, version_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS bucket_inventories (
+		bucket TEXT NOT NULL,
+		id TEXT NOT NULL,
+		config TEXT NOT NULL,
+		last_run_at TIMESTAMP,
+		last_manifest_key TEXT,
+		PRIMARY KEY (bucket, id)
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_checkpoints (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		seq INTEGER NOT NULL,
+		hash TEXT NOT NULL,
+		algorithm TEXT NOT NULL,
+		signature TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_checkpoints_seq ON audit_checkpoints(seq);
+
+	CREATE TABLE IF NOT EXISTS replication_backfills (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_id INTEGER NOT NULL,
+		source_bucket TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'running',
+		scanned INTEGER DEFAULT 0,
+		queued INTEGER DEFAULT 0,
+		error TEXT,
+		started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		finished_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS quarantined_blobs (
+		hash TEXT PRIMARY KEY,
+		path TEXT NOT NULL,
+		size INTEGER DEFAULT 0,
+		reason TEXT NOT NULL,
+		objects INTEGER DEFAULT 0,
+		detected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		restored_at TIMESTAMP,
+		restored_from TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS erasure_blobs (
+		hash TEXT PRIMARY KEY,
+		data_shards INTEGER NOT NULL,
+		parity_shards INTEGER NOT NULL,
+		block_size INTEGER NOT NULL,
+		size INTEGER NOT NULL,
+		checksums TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
 	`
 
-	if _, err := d.db.Exec(schema); err != nil {
+	if err := d.recordMissingTables(schema); err != nil {
 		return err
 	}
+	if !d.dryRun {
+		if _, err := d.db.Exec(schema); err != nil {
+			return err
+		}
+	}
 
 	// Migrations for existing tables
 	if err := d.addColumnIfNotExists("buckets", "object_lock_enabled", "BOOLEAN DEFAULT FALSE"); err != nil {
@@ -487,6 +829,12 @@ func (d *Database) initSchema() error {
 	if err := d.addColumnIfNotExists("buckets", "default_retention_days", "INTEGER"); err != nil {
 		return err
 	}
+	if err := d.addColumnIfNotExists("buckets", "erasure_data_shards", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfNotExists("buckets", "erasure_parity_shards", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
 	if err := d.addColumnIfNotExists("objects", "retain_until_date", "TIMESTAMP"); err != nil {
 		return err
 	}
@@ -503,6 +851,9 @@ func (d *Database) initSchema() error {
 	if err := d.addColumnIfNotExists("bucket_configs", "website_config", "TEXT"); err != nil {
 		return err
 	}
+	if err := d.addColumnIfNotExists("bucket_configs", "logging_config", "TEXT"); err != nil {
+		return err
+	}
 	if err := d.addColumnIfNotExists("buckets", "soft_delete_enabled", "BOOLEAN DEFAULT FALSE"); err != nil {
 		return err
 	}
@@ -530,6 +881,65 @@ func (d *Database) initSchema() error {
 	if err := d.addColumnIfNotExists("buckets", "quota_bytes", "INTEGER DEFAULT 0"); err != nil {
 		return err
 	}
+	// Migration: lifecycle transitions to the cold tier
+	if err := d.addColumnIfNotExists("objects", "storage_class", "TEXT"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfNotExists("objects", "restore_status", "TEXT"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfNotExists("objects", "restore_expires_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	// Migration: per-object website redirects
+	if err := d.addColumnIfNotExists("objects", "website_redirect_location", "TEXT"); err != nil {
+		return err
+	}
+	// Migration: remote replication targets
+	if err := d.addColumnIfNotExists("objects", "replication_status", "TEXT"); err != nil {
+		return err
+	}
+	// Migration: background scrubbing
+	if err := d.addColumnIfNotExists("objects", "corrupted_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfNotExists("bucket_replications", "target_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfNotExists("bucket_replications", "delete_marker_replication", "BOOLEAN DEFAULT FALSE"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfNotExists("bucket_replications", "metadata_replication", "BOOLEAN DEFAULT FALSE"); err != nil {
+		return err
+	}
+
+	// Migration: hash-chained audit log
+	if err := d.addColumnIfNotExists("audit_logs", "seq", "INTEGER"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfNotExists("audit_logs", "prev_hash", "TEXT"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfNotExists("audit_logs", "hash", "TEXT"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfNotExists("audit_logs", "trace_id", "TEXT"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfNotExists("audit_checkpoints", "prev_signature", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if d.dryRun {
+		return nil
+	}
+	if _, err := d.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_logs_seq ON audit_logs(seq);"); err != nil {
+		return err
+	}
+
+	// At most one pending job per unique key
+	if _, err := d.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_pending_unique ON jobs(unique_key) WHERE unique_key IS NOT NULL AND state IN ('queued', 'running');"); err != nil {
+		fmt.Printf("Warning: failed to create idx_jobs_pending_unique: %v\n", err)
+	}
 
 	// Create indexes for deduplication
 	if _, err := d.db.Exec("CREATE INDEX IF NOT EXISTS idx_objects_content_hash ON objects(content_hash) WHERE content_hash IS NOT NULL;"); err != nil {
@@ -552,8 +962,9 @@ func (d *Database) addColumnIfNotExists(table, column, colType string) error {
 	}
 	defer rows.Close()
 
-	found := false
+	found, tableExists := false, false
 	for rows.Next() {
+		tableExists = true
 		var cid int
 		var name, ctype string
 		var notnull int
@@ -568,12 +979,18 @@ func (d *Database) addColumnIfNotExists(table, column, colType string) error {
 		}
 	}
 
-	if !found {
+	if !found && d.dryRun {
+		// Tables that do not exist yet are reported as a whole
+		if tableExists {
+			d.schemaChanges = append(d.schemaChanges, SchemaChange{Table: table, Column: column})
+		}
+	} else if !found {
 		alterQuery := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, colType)
 		if _, err := d.db.Exec(alterQuery); err != nil {
 			return fmt.Errorf("failed to add column %s to table %s: %w", column, table, err)
 		}
 		fmt.Printf("Added column %s to table %s\n", column, table)
+		d.schemaChanges = append(d.schemaChanges, SchemaChange{Table: table, Column: column})
 	}
 
 	return nil
@@ -583,21 +1000,21 @@ func (d *Database) addColumnIfNotExists(table, column, colType string) error {
 func (d *Database) CreateBucket(name, owner string) error {
 	start := time.Now()
 	_, err := d.db.Exec("INSERT INTO buckets (name, owner) VALUES (?, ?)", name, owner)
-	metrics.RecordDBQuery("CreateBucket", time.Since(start))
+	d.observe("CreateBucket", start, err)
 	return err
 }
 
 func (d *Database) DeleteBucket(name string) error {
 	start := time.Now()
 	_, err := d.db.Exec("DELETE FROM buckets WHERE name = ?", name)
-	metrics.RecordDBQuery("DeleteBucket", time.Since(start))
+	d.observe("DeleteBucket", start, err)
 	return err
 }
 
 func (d *Database) ListBuckets() ([]string, error) {
 	start := time.Now()
 	rows, err := d.db.Query("SELECT name FROM buckets ORDER BY name")
-	metrics.RecordDBQuery("ListBuckets", time.Since(start))
+	d.observe("ListBuckets", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -620,12 +1037,14 @@ func (d *Database) BucketExists(name string) (bool, error) {
 	return exists, err
 }
 
-func (d *Database) GetBucket(name string) (*BucketRow, error) {
+func (d *Database) GetBucket(name string) (_ *BucketRow, err error) {
+	start := time.Now()
+	defer func() { d.observe("GetBucket", start, err) }()
 	var bucket BucketRow
 	var mode sql.NullString
 	var days sql.NullInt64
-	err := d.db.QueryRow("SELECT name, created_at, owner, versioning_enabled, object_lock_enabled, default_retention_mode, default_retention_days, soft_delete_enabled, soft_delete_retention, quota_bytes FROM buckets WHERE name = ?", name).
-		Scan(&bucket.Name, &bucket.CreatedAt, &bucket.Owner, &bucket.VersioningEnabled, &bucket.ObjectLockEnabled, &mode, &days, &bucket.SoftDeleteEnabled, &bucket.SoftDeleteRetention, &bucket.QuotaBytes)
+	err = d.db.QueryRow("SELECT name, created_at, owner, versioning_enabled, object_lock_enabled, default_retention_mode, default_retention_days, soft_delete_enabled, soft_delete_retention, quota_bytes, erasure_data_shards, erasure_parity_shards FROM buckets WHERE name = ?", name).
+		Scan(&bucket.Name, &bucket.CreatedAt, &bucket.Owner, &bucket.VersioningEnabled, &bucket.ObjectLockEnabled, &mode, &days, &bucket.SoftDeleteEnabled, &bucket.SoftDeleteRetention, &bucket.QuotaBytes, &bucket.ErasureDataShards, &bucket.ErasureParityShards)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -641,36 +1060,66 @@ func (d *Database) GetBucket(name string) (*BucketRow, error) {
 func (d *Database) SetBucketVersioning(name string, enabled bool) error {
 	start := time.Now()
 	_, err := d.db.Exec("UPDATE buckets SET versioning_enabled = ? WHERE name = ?", enabled, name)
-	metrics.RecordDBQuery("SetBucketVersioning", time.Since(start))
+	d.observe("SetBucketVersioning", start, err)
 	return err
 }
 
 func (d *Database) SetBucketObjectLock(name string, enabled bool) error {
 	start := time.Now()
 	_, err := d.db.Exec("UPDATE buckets SET object_lock_enabled = ? WHERE name = ?", enabled, name)
-	metrics.RecordDBQuery("SetBucketObjectLock", time.Since(start))
+	d.observe("SetBucketObjectLock", start, err)
 	return err
 }
 
 func (d *Database) SetBucketDefaultRetention(name string, mode string, days int) error {
 	start := time.Now()
 	_, err := d.db.Exec("UPDATE buckets SET default_retention_mode = ?, default_retention_days = ? WHERE name = ?", mode, days, name)
-	metrics.RecordDBQuery("SetBucketDefaultRetention", time.Since(start))
+	d.observe("SetBucketDefaultRetention", start, err)
 	return err
 }
 
 func (d *Database) SetBucketQuota(name string, quotaBytes int64) error {
 	start := time.Now()
 	_, err := d.db.Exec("UPDATE buckets SET quota_bytes = ? WHERE name = ?", quotaBytes, name)
-	metrics.RecordDBQuery("SetBucketQuota", time.Since(start))
+	d.observe("SetBucketQuota", start, err)
+	return err
+}
+
+// SetBucketErasure sets the erasure code of new objects in a bucket; zero shards turn it off
+func (d *Database) SetBucketErasure(name string, dataShards, parityShards int) error {
+	start := time.Now()
+	_, err := d.db.Exec("UPDATE buckets SET erasure_data_shards = ?, erasure_parity_shards = ? WHERE name = ?", dataShards, parityShards, name)
+	d.observe("SetBucketErasure", start, err)
 	return err
 }
 
+// objectColumns is the column list shared by every query that scans into ObjectRow.
+const objectColumns = `id, bucket, key, version_id, size, etag, content_type, modified_at, is_latest, encryption_type, retain_until_date, legal_hold, lock_mode, deleted_at, content_hash, compression_type, original_size, is_deduplicated, storage_class, restore_status, restore_expires_at, website_redirect_location, replication_status, corrupted_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanObject(row rowScanner) (*ObjectRow, error) {
+	var obj ObjectRow
+	err := row.Scan(&obj.ID, &obj.Bucket, &obj.Key, &obj.VersionID, &obj.Size,
+		&obj.ETag, &obj.ContentType, &obj.ModifiedAt, &obj.IsLatest, &obj.EncryptionType,
+		&obj.RetainUntilDate, &obj.LegalHold, &obj.LockMode, &obj.DeletedAt,
+		&obj.ContentHash, &obj.CompressionType, &obj.OriginalSize, &obj.IsDeduplicated,
+		&obj.StorageClass, &obj.RestoreStatus, &obj.RestoreExpiresAt, &obj.WebsiteRedirectLocation, &obj.ReplicationStatus,
+		&obj.CorruptedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
 // Object operations
 func (d *Database) CreateObject(obj *ObjectRow) (int64, error) {
 	start := time.Now()
 	obj.Key = strings.TrimPrefix(obj.Key, "/")
-	// Mark previous versions as not latest (exclude current version_id)
+	// Mark previous versions as not latest (exclude current version_id).
+	// Rewriting an existing version (non-versioned overwrites) replaces its metadata.
 	if obj.IsLatest {
 		_, err := d.db.Exec("UPDATE objects SET is_latest = FALSE WHERE bucket = ? AND key = ? AND version_id != ?", obj.Bucket, obj.Key, obj.VersionID)
 		if err != nil {
@@ -679,11 +1128,19 @@ func (d *Database) CreateObject(obj *ObjectRow) (int64, error) {
 	}
 
 	result, err := d.db.Exec(`
-		INSERT INTO objects (bucket, key, version_id, size, etag, content_type, is_latest, encryption_type, retain_until_date, legal_hold, lock_mode, content_hash, compression_type, original_size, is_deduplicated)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, obj.Bucket, obj.Key, obj.VersionID, obj.Size, obj.ETag, obj.ContentType, obj.IsLatest, obj.EncryptionType, obj.RetainUntilDate, obj.LegalHold, obj.LockMode, obj.ContentHash, obj.CompressionType, obj.OriginalSize, obj.IsDeduplicated)
-
-	metrics.RecordDBQuery("CreateObject", time.Since(start))
+		INSERT INTO objects (bucket, key, version_id, size, etag, content_type, is_latest, encryption_type, retain_until_date, legal_hold, lock_mode, content_hash, compression_type, original_size, is_deduplicated, website_redirect_location)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(bucket, key, version_id) DO UPDATE SET
+			size = excluded.size, etag = excluded.etag, content_type = excluded.content_type,
+			modified_at = CURRENT_TIMESTAMP, is_latest = excluded.is_latest, encryption_type = excluded.encryption_type,
+			retain_until_date = excluded.retain_until_date, legal_hold = excluded.legal_hold, lock_mode = excluded.lock_mode,
+			content_hash = excluded.content_hash, compression_type = excluded.compression_type,
+			original_size = excluded.original_size, is_deduplicated = excluded.is_deduplicated,
+			deleted_at = NULL, storage_class = NULL, restore_status = NULL, restore_expires_at = NULL,
+			website_redirect_location = excluded.website_redirect_location, replication_status = NULL, corrupted_at = NULL
+	`, obj.Bucket, obj.Key, obj.VersionID, obj.Size, obj.ETag, obj.ContentType, obj.IsLatest, obj.EncryptionType, obj.RetainUntilDate, obj.LegalHold, obj.LockMode, obj.ContentHash, obj.CompressionType, obj.OriginalSize, obj.IsDeduplicated, obj.WebsiteRedirectLocation)
+
+	d.observe("CreateObject", start, err)
 	if err != nil {
 		return 0, err
 	}
@@ -694,28 +1151,20 @@ func (d *Database) CreateObject(obj *ObjectRow) (int64, error) {
 func (d *Database) GetObject(bucket, key, versionID string) (*ObjectRow, error) {
 	start := time.Now()
 	key = strings.TrimPrefix(key, "/")
-	query := `SELECT id, bucket, key, version_id, size, etag, content_type, modified_at, is_latest, encryption_type, retain_until_date, legal_hold, lock_mode, deleted_at, content_hash, compression_type, original_size, is_deduplicated
+	query := `SELECT ` + objectColumns + `
 	          FROM objects WHERE bucket = ? AND key = ? AND deleted_at IS NULL`
 
-	var obj ObjectRow
+	var obj *ObjectRow
 	var err error
 
 	if versionID != "" {
 		query += " AND version_id = ?"
-		err = d.db.QueryRow(query, bucket, key, versionID).Scan(
-			&obj.ID, &obj.Bucket, &obj.Key, &obj.VersionID, &obj.Size,
-			&obj.ETag, &obj.ContentType, &obj.ModifiedAt, &obj.IsLatest, &obj.EncryptionType,
-			&obj.RetainUntilDate, &obj.LegalHold, &obj.LockMode, &obj.DeletedAt,
-			&obj.ContentHash, &obj.CompressionType, &obj.OriginalSize, &obj.IsDeduplicated)
+		obj, err = scanObject(d.db.QueryRow(query, bucket, key, versionID))
 	} else {
 		query += " AND is_latest = TRUE"
-		err = d.db.QueryRow(query, bucket, key).Scan(
-			&obj.ID, &obj.Bucket, &obj.Key, &obj.VersionID, &obj.Size,
-			&obj.ETag, &obj.ContentType, &obj.ModifiedAt, &obj.IsLatest, &obj.EncryptionType,
-			&obj.RetainUntilDate, &obj.LegalHold, &obj.LockMode, &obj.DeletedAt,
-			&obj.ContentHash, &obj.CompressionType, &obj.OriginalSize, &obj.IsDeduplicated)
+		obj, err = scanObject(d.db.QueryRow(query, bucket, key))
 	}
-	metrics.RecordDBQuery("GetObject", time.Since(start))
+	d.observe("GetObject", start, err)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -724,34 +1173,26 @@ func (d *Database) GetObject(bucket, key, versionID string) (*ObjectRow, error)
 		return nil, err
 	}
 
-	return &obj, nil
+	return obj, nil
 }
 
 func (d *Database) GetObjectIncludeDeleted(bucket, key, versionID string) (*ObjectRow, error) {
 	start := time.Now()
 	key = strings.TrimPrefix(key, "/")
-	query := `SELECT id, bucket, key, version_id, size, etag, content_type, modified_at, is_latest, encryption_type, retain_until_date, legal_hold, lock_mode, deleted_at, content_hash, compression_type, original_size, is_deduplicated
+	query := `SELECT ` + objectColumns + `
 	          FROM objects WHERE bucket = ? AND key = ?`
 
-	var obj ObjectRow
+	var obj *ObjectRow
 	var err error
 
 	if versionID != "" {
 		query += " AND version_id = ?"
-		err = d.db.QueryRow(query, bucket, key, versionID).Scan(
-			&obj.ID, &obj.Bucket, &obj.Key, &obj.VersionID, &obj.Size,
-			&obj.ETag, &obj.ContentType, &obj.ModifiedAt, &obj.IsLatest, &obj.EncryptionType,
-			&obj.RetainUntilDate, &obj.LegalHold, &obj.LockMode, &obj.DeletedAt,
-			&obj.ContentHash, &obj.CompressionType, &obj.OriginalSize, &obj.IsDeduplicated)
+		obj, err = scanObject(d.db.QueryRow(query, bucket, key, versionID))
 	} else {
 		query += " AND is_latest = TRUE"
-		err = d.db.QueryRow(query, bucket, key).Scan(
-			&obj.ID, &obj.Bucket, &obj.Key, &obj.VersionID, &obj.Size,
-			&obj.ETag, &obj.ContentType, &obj.ModifiedAt, &obj.IsLatest, &obj.EncryptionType,
-			&obj.RetainUntilDate, &obj.LegalHold, &obj.LockMode, &obj.DeletedAt,
-			&obj.ContentHash, &obj.CompressionType, &obj.OriginalSize, &obj.IsDeduplicated)
+		obj, err = scanObject(d.db.QueryRow(query, bucket, key))
 	}
-	metrics.RecordDBQuery("GetObjectIncludeDeleted", time.Since(start))
+	d.observe("GetObjectIncludeDeleted", start, err)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -760,7 +1201,7 @@ func (d *Database) GetObjectIncludeDeleted(bucket, key, versionID string) (*Obje
 		return nil, err
 	}
 
-	return &obj, nil
+	return obj, nil
 }
 
 func (d *Database) UpdateObjectLatest(bucket, key, versionID string, isLatest bool) error {
@@ -768,7 +1209,7 @@ func (d *Database) UpdateObjectLatest(bucket, key, versionID string, isLatest bo
 	key = strings.TrimPrefix(key, "/")
 	_, err := d.db.Exec("UPDATE objects SET is_latest = ? WHERE bucket = ? AND key = ? AND version_id = ?",
 		isLatest, bucket, key, versionID)
-	metrics.RecordDBQuery("UpdateObjectLatest", time.Since(start))
+	d.observe("UpdateObjectLatest", start, err)
 	return err
 }
 
@@ -777,11 +1218,11 @@ func (d *Database) DeleteObject(bucket, key, versionID string) error {
 	key = strings.TrimPrefix(key, "/")
 	if versionID != "" {
 		_, err := d.db.Exec("DELETE FROM objects WHERE bucket = ? AND key = ? AND version_id = ?", bucket, key, versionID)
-		metrics.RecordDBQuery("DeleteObject", time.Since(start))
+		d.observe("DeleteObject", start, err)
 		return err
 	}
 	_, err := d.db.Exec("DELETE FROM objects WHERE bucket = ? AND key = ?", bucket, key)
-	metrics.RecordDBQuery("DeleteObject", time.Since(start))
+	d.observe("DeleteObject", start, err)
 	return err
 }
 
@@ -801,7 +1242,7 @@ func (d *Database) DeleteObjectsByID(ids []int64) error {
 
 	query := fmt.Sprintf("DELETE FROM objects WHERE id IN (%s)", strings.Join(placeholders, ","))
 	_, err := d.db.Exec(query, args...)
-	metrics.RecordDBQuery("DeleteObjectsByID", time.Since(start))
+	d.observe("DeleteObjectsByID", start, err)
 	return err
 }
 
@@ -811,11 +1252,11 @@ func (d *Database) SoftDeleteObject(bucket, key, versionID string) error {
 	now := time.Now()
 	if versionID != "" {
 		_, err := d.db.Exec("UPDATE objects SET deleted_at = ?, is_latest = FALSE WHERE bucket = ? AND key = ? AND version_id = ?", now, bucket, key, versionID)
-		metrics.RecordDBQuery("SoftDeleteObject", time.Since(start))
+		d.observe("SoftDeleteObject", start, err)
 		return err
 	}
 	_, err := d.db.Exec("UPDATE objects SET deleted_at = ?, is_latest = FALSE WHERE bucket = ? AND key = ? AND is_latest = TRUE", now, bucket, key)
-	metrics.RecordDBQuery("SoftDeleteObject", time.Since(start))
+	d.observe("SoftDeleteObject", start, err)
 	return err
 }
 
@@ -829,13 +1270,13 @@ func (d *Database) RestoreObject(bucket, key, versionID string) error {
 		// Mark others as not latest
 		d.db.Exec("UPDATE objects SET is_latest = FALSE WHERE bucket = ? AND key = ? AND version_id != ?", bucket, key, versionID)
 	}
-	metrics.RecordDBQuery("RestoreObject", time.Since(start))
+	d.observe("RestoreObject", start, err)
 	return err
 }
 
 func (d *Database) ListTrashObjects(bucket, search string) ([]*ObjectRow, error) {
 	start := time.Now()
-	query := `SELECT id, bucket, key, version_id, size, etag, content_type, modified_at, is_latest, encryption_type, retain_until_date, legal_hold, lock_mode, deleted_at, content_hash, compression_type, original_size, is_deduplicated
+	query := `SELECT ` + objectColumns + `
 	          FROM objects WHERE deleted_at IS NOT NULL`
 	args := []interface{}{}
 	if bucket != "" {
@@ -856,16 +1297,13 @@ func (d *Database) ListTrashObjects(bucket, search string) ([]*ObjectRow, error)
 
 	var objects []*ObjectRow
 	for rows.Next() {
-		var obj ObjectRow
-		if err := rows.Scan(&obj.ID, &obj.Bucket, &obj.Key, &obj.VersionID, &obj.Size,
-			&obj.ETag, &obj.ContentType, &obj.ModifiedAt, &obj.IsLatest, &obj.EncryptionType,
-			&obj.RetainUntilDate, &obj.LegalHold, &obj.LockMode, &obj.DeletedAt,
-			&obj.ContentHash, &obj.CompressionType, &obj.OriginalSize, &obj.IsDeduplicated); err != nil {
+		obj, err := scanObject(rows)
+		if err != nil {
 			return nil, err
 		}
-		objects = append(objects, &obj)
+		objects = append(objects, obj)
 	}
-	metrics.RecordDBQuery("ListTrashObjects", time.Since(start))
+	d.observe("ListTrashObjects", start, rows.Err())
 	return objects, rows.Err()
 }
 
@@ -878,7 +1316,7 @@ func (d *Database) EmptyTrash(bucket string) error {
 		args = append(args, bucket)
 	}
 	_, err := d.db.Exec(query, args...)
-	metrics.RecordDBQuery("EmptyTrash", time.Since(start))
+	d.observe("EmptyTrash", start, err)
 	return err
 }
 
@@ -886,7 +1324,7 @@ func (d *Database) DeletePrefix(bucket, prefix string) error {
 	start := time.Now()
 	prefix = strings.TrimPrefix(prefix, "/")
 	_, err := d.db.Exec("DELETE FROM objects WHERE bucket = ? AND key LIKE ?", bucket, prefix+"%")
-	metrics.RecordDBQuery("DeletePrefix", time.Since(start))
+	d.observe("DeletePrefix", start, err)
 	return err
 }
 
@@ -895,7 +1333,7 @@ func (d *Database) SoftDeletePrefix(bucket, prefix string) error {
 	prefix = strings.TrimPrefix(prefix, "/")
 	now := time.Now()
 	_, err := d.db.Exec("UPDATE objects SET deleted_at = ?, is_latest = FALSE WHERE bucket = ? AND key LIKE ? AND deleted_at IS NULL", now, bucket, prefix+"%")
-	metrics.RecordDBQuery("SoftDeletePrefix", time.Since(start))
+	d.observe("SoftDeletePrefix", start, err)
 	return err
 }
 
@@ -903,12 +1341,46 @@ func (d *Database) RestorePrefix(bucket, prefix string) error {
 	start := time.Now()
 	prefix = strings.TrimPrefix(prefix, "/")
 	_, err := d.db.Exec("UPDATE objects SET deleted_at = NULL, is_latest = TRUE WHERE bucket = ? AND key LIKE ? AND deleted_at IS NOT NULL", bucket, prefix+"%")
-	metrics.RecordDBQuery("RestorePrefix", time.Since(start))
+	d.observe("RestorePrefix", start, err)
 	return err
 }
 
+// ListObjectsAfter returns a page of current objects ordered by key, starting after startAfter.
+// Background jobs use it to walk large buckets without loading them at once.
+func (d *Database) ListObjectsAfter(bucket, prefix, startAfter string, limit int) ([]*ObjectRow, error) {
+	start := time.Now()
+	query := `SELECT ` + objectColumns + `
+	          FROM objects WHERE bucket = ? AND is_latest = TRUE AND deleted_at IS NULL AND key > ?`
+	args := []interface{}{bucket, startAfter}
+
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" {
+		query += " AND key LIKE ?"
+		args = append(args, prefix+"%")
+	}
+	query += " ORDER BY key LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := d.db.Query(query, args...)
+	d.observe("ListObjectsAfter", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []*ObjectRow
+	for rows.Next() {
+		obj, err := scanObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
 func (d *Database) ListObjects(bucket, prefix, search string, limit int) ([]*ObjectRow, error) {
-	query := `SELECT id, bucket, key, version_id, size, etag, content_type, modified_at, is_latest, encryption_type, retain_until_date, legal_hold, lock_mode, deleted_at, content_hash, compression_type, original_size, is_deduplicated
+	query := `SELECT ` + objectColumns + `
 	          FROM objects WHERE bucket = ? AND is_latest = TRUE AND deleted_at IS NULL`
 
 	args := []interface{}{bucket}
@@ -935,14 +1407,11 @@ func (d *Database) ListObjects(bucket, prefix, search string, limit int) ([]*Obj
 
 	var objects []*ObjectRow
 	for rows.Next() {
-		var obj ObjectRow
-		if err := rows.Scan(&obj.ID, &obj.Bucket, &obj.Key, &obj.VersionID, &obj.Size,
-			&obj.ETag, &obj.ContentType, &obj.ModifiedAt, &obj.IsLatest, &obj.EncryptionType,
-			&obj.RetainUntilDate, &obj.LegalHold, &obj.LockMode, &obj.DeletedAt,
-			&obj.ContentHash, &obj.CompressionType, &obj.OriginalSize, &obj.IsDeduplicated); err != nil {
+		obj, err := scanObject(rows)
+		if err != nil {
 			return nil, err
 		}
-		objects = append(objects, &obj)
+		objects = append(objects, obj)
 	}
 
 	return objects, rows.Err()
@@ -954,7 +1423,7 @@ func (d *Database) SetObjectRetention(bucket, key, versionID string, retainUntil
 	_, err := d.db.Exec(`UPDATE objects SET retain_until_date = ?, lock_mode = ? 
 	                      WHERE bucket = ? AND key = ? AND version_id = ?`,
 		retainUntil, mode, bucket, key, versionID)
-	metrics.RecordDBQuery("SetObjectRetention", time.Since(start))
+	d.observe("SetObjectRetention", start, err)
 	return err
 }
 
@@ -962,7 +1431,7 @@ func (d *Database) SetObjectLegalHold(bucket, key, versionID string, hold bool,
 	start := time.Now()
 	key = strings.TrimPrefix(key, "/")
 	_, err := d.db.Exec("UPDATE objects SET legal_hold = ?, legal_hold_reason = ? WHERE bucket = ? AND key = ? AND version_id = ?", hold, reason, bucket, key, versionID)
-	metrics.RecordDBQuery("SetObjectLegalHold", time.Since(start))
+	d.observe("SetObjectLegalHold", start, err)
 	return err
 }
 
@@ -970,28 +1439,28 @@ func (d *Database) IsSignatureUsed(signature string) (bool, error) {
 	start := time.Now()
 	var count int
 	err := d.db.QueryRow("SELECT COUNT(*) FROM used_signatures WHERE signature = ?", signature).Scan(&count)
-	metrics.RecordDBQuery("IsSignatureUsed", time.Since(start))
+	d.observe("IsSignatureUsed", start, err)
 	return count > 0, err
 }
 
 func (d *Database) RecordSignature(signature string, expiresAt time.Time) error {
 	start := time.Now()
 	_, err := d.db.Exec("INSERT INTO used_signatures (signature, expires_at) VALUES (?, ?)", signature, expiresAt)
-	metrics.RecordDBQuery("RecordSignature", time.Since(start))
+	d.observe("RecordSignature", start, err)
 	return err
 }
 
 func (d *Database) CleanupExpiredSignatures() error {
 	start := time.Now()
 	_, err := d.db.Exec("DELETE FROM used_signatures WHERE expires_at < ?", time.Now())
-	metrics.RecordDBQuery("CleanupExpiredSignatures", time.Since(start))
+	d.observe("CleanupExpiredSignatures", start, err)
 	return err
 }
 
 func (d *Database) SetBucketSoftDelete(name string, enabled bool, retentionDays int) error {
 	start := time.Now()
 	_, err := d.db.Exec("UPDATE buckets SET soft_delete_enabled = ?, soft_delete_retention = ? WHERE name = ?", enabled, retentionDays, name)
-	metrics.RecordDBQuery("SetBucketSoftDelete", time.Since(start))
+	d.observe("SetBucketSoftDelete", start, err)
 	return err
 }
 
@@ -1106,6 +1575,28 @@ func (d *Database) DeleteBucketWebsite(bucket string) error {
 	return err
 }
 
+func (d *Database) PutBucketLogging(bucket string, loggingJSON string) error {
+	_, err := d.db.Exec(`
+		INSERT INTO bucket_configs (bucket, logging_config) VALUES (?, ?)
+		ON CONFLICT(bucket) DO UPDATE SET logging_config = excluded.logging_config
+	`, bucket, loggingJSON)
+	return err
+}
+
+func (d *Database) GetBucketLogging(bucket string) (string, error) {
+	var loggingJSON sql.NullString
+	err := d.db.QueryRow("SELECT logging_config FROM bucket_configs WHERE bucket = ?", bucket).Scan(&loggingJSON)
+	if err == sql.ErrNoRows || !loggingJSON.Valid {
+		return "", nil
+	}
+	return loggingJSON.String, err
+}
+
+func (d *Database) DeleteBucketLogging(bucket string) error {
+	_, err := d.db.Exec("UPDATE bucket_configs SET logging_config = NULL WHERE bucket = ?", bucket)
+	return err
+}
+
 func (d *Database) GetAllLifecycles() (map[string]string, error) {
 	rows, err := d.db.Query("SELECT bucket, lifecycle_config FROM bucket_configs WHERE lifecycle_config IS NOT NULL AND lifecycle_config != ''")
 	if err != nil {
@@ -1134,6 +1625,8 @@ func (d *Database) GetGlobalStats() (count int, size int64, err error) {
 }
 
 func (d *Database) GetBucketStats(bucket string) (count int, size int64, err error) {
+	start := time.Now()
+	defer func() { d.observe("GetBucketStats", start, err) }()
 	err = d.db.QueryRow(`
 		SELECT COUNT(*), COALESCE(SUM(size), 0)
 		FROM objects 
@@ -1142,6 +1635,74 @@ func (d *Database) GetBucketStats(bucket string) (count int, size int64, err err
 	return
 }
 
+// BucketUsage is the number and total size of the current, not deleted objects of a bucket
+type BucketUsage struct {
+	Objects int64
+	Bytes   int64
+}
+
+// GetBucketUsage returns the usage of the given buckets, or of all buckets when none are given.
+// Buckets without objects are left out of the result.
+func (d *Database) GetBucketUsage(buckets ...string) (map[string]BucketUsage, error) {
+	start := time.Now()
+	query := `SELECT bucket, COUNT(*), COALESCE(SUM(size), 0) FROM objects WHERE is_latest = 1 AND deleted_at IS NULL`
+	args := make([]interface{}, len(buckets))
+	if len(buckets) > 0 {
+		query += " AND bucket IN (?" + strings.Repeat(", ?", len(buckets)-1) + ")"
+		for i, b := range buckets {
+			args[i] = b
+		}
+	}
+	rows, err := d.db.Query(query+" GROUP BY bucket", args...)
+	d.observe("GetBucketUsage", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := make(map[string]BucketUsage)
+	for rows.Next() {
+		var bucket string
+		var u BucketUsage
+		if err := rows.Scan(&bucket, &u.Objects, &u.Bytes); err != nil {
+			return nil, err
+		}
+		usage[bucket] = u
+	}
+	return usage, rows.Err()
+}
+
+// CASUsage describes the content-addressed store. LogicalBytes is the uploaded size of every
+// version that references a blob, StoredBytes what those versions would take on disk without
+// deduplication and PhysicalBytes what the distinct blobs actually take.
+type CASUsage struct {
+	Blobs         int64
+	LogicalBytes  int64
+	StoredBytes   int64
+	PhysicalBytes int64
+}
+
+func (d *Database) GetCASUsage() (_ *CASUsage, err error) {
+	start := time.Now()
+	defer func() { d.observe("GetCASUsage", start, err) }()
+
+	// Archived versions live in the cold tier and no longer pin the CAS blob
+	const where = `content_hash IS NOT NULL AND content_hash != '' AND (storage_class IS NULL OR storage_class = 'STANDARD')`
+	var u CASUsage
+	err = d.db.QueryRow(`SELECT COALESCE(SUM(size), 0), COALESCE(SUM(COALESCE(original_size, size)), 0) FROM objects WHERE `+where).
+		Scan(&u.LogicalBytes, &u.StoredBytes)
+	if err != nil {
+		return nil, err
+	}
+	err = d.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(blob_size), 0) FROM (
+		SELECT MAX(COALESCE(original_size, size)) AS blob_size FROM objects WHERE `+where+` GROUP BY content_hash
+	) blobs`).Scan(&u.Blobs, &u.PhysicalBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
 // User operations
 func (d *Database) UpsertUser(username, passwordHash string) error {
 	_, err := d.db.Exec(`
@@ -1315,7 +1876,7 @@ func (d *Database) GetExpiredObjects(bucket string, prefix string, days int) ([]
 	prefix = strings.TrimPrefix(prefix, "/")
 	// Calculate cutoff time in Go to allow index usage on modified_at column
 	cutoff := time.Now().AddDate(0, 0, -days)
-	query := `SELECT id, bucket, key, version_id, size, etag, content_type, modified_at, is_latest, encryption_type, retain_until_date, legal_hold, lock_mode, deleted_at, content_hash, compression_type, original_size, is_deduplicated
+	query := `SELECT ` + objectColumns + `
 	          FROM objects 
 	          WHERE bucket = ? AND is_latest = 1 AND modified_at < ? AND deleted_at IS NULL`
 
@@ -1333,27 +1894,36 @@ func (d *Database) GetExpiredObjects(bucket string, prefix string, days int) ([]
 
 	var objects []*ObjectRow
 	for rows.Next() {
-		var obj ObjectRow
-		if err := rows.Scan(&obj.ID, &obj.Bucket, &obj.Key, &obj.VersionID, &obj.Size,
-			&obj.ETag, &obj.ContentType, &obj.ModifiedAt, &obj.IsLatest, &obj.EncryptionType,
-			&obj.RetainUntilDate, &obj.LegalHold, &obj.LockMode, &obj.DeletedAt,
-			&obj.ContentHash, &obj.CompressionType, &obj.OriginalSize, &obj.IsDeduplicated); err != nil {
+		obj, err := scanObject(rows)
+		if err != nil {
 			return nil, err
 		}
-		objects = append(objects, &obj)
+		objects = append(objects, obj)
 	}
-	metrics.RecordDBQuery("GetExpiredObjects", time.Since(start))
+	d.observe("GetExpiredObjects", start, rows.Err())
 	return objects, rows.Err()
 }
 
-func (d *Database) Close() error {
-	return d.db.Close()
-}
+// GetTransitionCandidates returns current versions older than the given number of days
+// that sit in a warmer storage class than storageClass. GLACIER objects are only
+// candidates for a DEEP_ARCHIVE transition.
+func (d *Database) GetTransitionCandidates(bucket string, prefix string, days int, storageClass string) ([]*ObjectRow, error) {
+	start := time.Now()
+	prefix = strings.TrimPrefix(prefix, "/")
+	cutoff := time.Now().AddDate(0, 0, -days)
+	query := `SELECT ` + objectColumns + `
+	          FROM objects
+	          WHERE bucket = ? AND is_latest = 1 AND modified_at < ? AND deleted_at IS NULL
+	          AND version_id != 'folder'
+	          AND (storage_class IS NULL OR storage_class = 'STANDARD' OR (? = 'DEEP_ARCHIVE' AND storage_class = 'GLACIER'))`
 
-func (d *Database) ListAllObjects() ([]*ObjectRow, error) {
-	query := `SELECT id, bucket, key, version_id, size, etag, content_type, modified_at, is_latest, encryption_type, retain_until_date, legal_hold, lock_mode, deleted_at, content_hash, compression_type, original_size, is_deduplicated
-	          FROM objects`
-	rows, err := d.db.Query(query)
+	args := []interface{}{bucket, cutoff, storageClass}
+	if prefix != "" {
+		query += " AND key LIKE ?"
+		args = append(args, prefix+"%")
+	}
+
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -1361,42 +1931,138 @@ func (d *Database) ListAllObjects() ([]*ObjectRow, error) {
 
 	var objects []*ObjectRow
 	for rows.Next() {
-		var obj ObjectRow
-		if err := rows.Scan(&obj.ID, &obj.Bucket, &obj.Key, &obj.VersionID, &obj.Size,
-			&obj.ETag, &obj.ContentType, &obj.ModifiedAt, &obj.IsLatest, &obj.EncryptionType,
-			&obj.RetainUntilDate, &obj.LegalHold, &obj.LockMode, &obj.DeletedAt,
-			&obj.ContentHash, &obj.CompressionType, &obj.OriginalSize, &obj.IsDeduplicated); err != nil {
+		obj, err := scanObject(rows)
+		if err != nil {
 			return nil, err
 		}
-		objects = append(objects, &obj)
+		objects = append(objects, obj)
 	}
-
+	d.observe("GetTransitionCandidates", start, rows.Err())
 	return objects, rows.Err()
 }
-func (d *Database) CreateWebhook(w *WebhookRecord) (int64, error) {
+
+func (d *Database) SetObjectStorageClass(bucket, key, versionID, storageClass string) error {
 	start := time.Now()
-	res, err := d.db.Exec(`INSERT INTO webhooks (bucket, url, events, secret, active) 
-	                        VALUES (?, ?, ?, ?, ?)`,
-		w.Bucket, w.URL, w.Events, w.Secret, w.Active)
-	metrics.RecordDBQuery("CreateWebhook", time.Since(start))
-	if err != nil {
-		return 0, err
-	}
-	return res.LastInsertId()
+	key = strings.TrimPrefix(key, "/")
+	_, err := d.db.Exec("UPDATE objects SET storage_class = ?, restore_status = NULL, restore_expires_at = NULL WHERE bucket = ? AND key = ? AND version_id = ?",
+		storageClass, bucket, key, versionID)
+	d.observe("SetObjectStorageClass", start, err)
+	return err
 }
 
-func (d *Database) ListWebhooks(bucket string) ([]*WebhookRecord, error) {
+// SetObjectContentHash points an object version at another CAS blob
+func (d *Database) SetObjectContentHash(bucket, key, versionID, hash string) error {
 	start := time.Now()
-	rows, err := d.db.Query(`SELECT id, bucket, url, events, secret, active, created_at 
-	                          FROM webhooks WHERE bucket = ?`, bucket)
-	metrics.RecordDBQuery("ListWebhooks", time.Since(start))
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	key = strings.TrimPrefix(key, "/")
+	_, err := d.db.Exec("UPDATE objects SET content_hash = ? WHERE bucket = ? AND key = ? AND version_id = ?",
+		hash, bucket, key, versionID)
+	d.observe("SetObjectContentHash", start, err)
+	return err
+}
 
-	hooks := []*WebhookRecord{}
-	for rows.Next() {
+// SetObjectRestoreStatus records the state of a restore request for an archived object.
+// A nil status clears any previous restore.
+func (d *Database) SetObjectRestoreStatus(bucket, key, versionID string, status *string, expiresAt *time.Time) error {
+	start := time.Now()
+	key = strings.TrimPrefix(key, "/")
+	_, err := d.db.Exec("UPDATE objects SET restore_status = ?, restore_expires_at = ? WHERE bucket = ? AND key = ? AND version_id = ?",
+		status, expiresAt, bucket, key, versionID)
+	d.observe("SetObjectRestoreStatus", start, err)
+	return err
+}
+
+// StartObjectRestore sets the restore status of a version without one in a single statement,
+// so that concurrent requests cannot both start a restore. It reports whether it was set.
+func (d *Database) StartObjectRestore(bucket, key, versionID, status string) (bool, error) {
+	start := time.Now()
+	key = strings.TrimPrefix(key, "/")
+	res, err := d.db.Exec("UPDATE objects SET restore_status = ?, restore_expires_at = NULL WHERE bucket = ? AND key = ? AND version_id = ? AND restore_status IS NULL",
+		status, bucket, key, versionID)
+	d.observe("StartObjectRestore", start, err)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// GetExpiredRestores returns archived objects whose temporary restored copy has expired.
+func (d *Database) GetExpiredRestores() ([]*ObjectRow, error) {
+	start := time.Now()
+	query := `SELECT ` + objectColumns + `
+	          FROM objects WHERE restore_status = 'restored' AND restore_expires_at < ?`
+	rows, err := d.db.Query(query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []*ObjectRow
+	for rows.Next() {
+		obj, err := scanObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	d.observe("GetExpiredRestores", start, rows.Err())
+	return objects, rows.Err()
+}
+
+func (d *Database) Close() error {
+	return d.db.Close()
+}
+
+// Ping runs a trivial query, which also checks that the database file is readable
+func (d *Database) Ping(ctx context.Context) error {
+	var one int
+	return d.db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+func (d *Database) ListAllObjects() ([]*ObjectRow, error) {
+	query := `SELECT ` + objectColumns + `
+	          FROM objects`
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []*ObjectRow
+	for rows.Next() {
+		obj, err := scanObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+
+	return objects, rows.Err()
+}
+func (d *Database) CreateWebhook(w *WebhookRecord) (int64, error) {
+	start := time.Now()
+	res, err := d.db.Exec(`INSERT INTO webhooks (bucket, url, events, secret, active) 
+	                        VALUES (?, ?, ?, ?, ?)`,
+		w.Bucket, w.URL, w.Events, w.Secret, w.Active)
+	d.observe("CreateWebhook", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (d *Database) ListWebhooks(bucket string) ([]*WebhookRecord, error) {
+	start := time.Now()
+	rows, err := d.db.Query(`SELECT id, bucket, url, events, secret, active, created_at 
+	                          FROM webhooks WHERE bucket = ?`, bucket)
+	d.observe("ListWebhooks", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []*WebhookRecord{}
+	for rows.Next() {
 		h := &WebhookRecord{}
 		err := rows.Scan(&h.ID, &h.Bucket, &h.URL, &h.Events, &h.Secret, &h.Active, &h.CreatedAt)
 		if err != nil {
@@ -1410,7 +2076,7 @@ func (d *Database) ListWebhooks(bucket string) ([]*WebhookRecord, error) {
 func (d *Database) DeleteWebhook(id int64) error {
 	start := time.Now()
 	_, err := d.db.Exec("DELETE FROM webhooks WHERE id = ?", id)
-	metrics.RecordDBQuery("DeleteWebhook", time.Since(start))
+	d.observe("DeleteWebhook", start, err)
 	return err
 }
 
@@ -1418,20 +2084,138 @@ func (d *Database) GetWebhooksByBucket(bucket string) ([]*WebhookRecord, error)
 	return d.ListWebhooks(bucket)
 }
 
+// Audit rows and checkpoints are insert-only: there are deliberately no methods that update them,
+// and the hash chain makes changes made outside the application detectable. The only delete is
+// DeleteAuditLogsBefore, used by retention after the rows were archived and the chain anchored by a checkpoint.
+
+const auditLogColumns = `id, timestamp, COALESCE(username, ''), COALESCE(action, ''), COALESCE(resource, ''),
+	COALESCE(result, ''), COALESCE(ip, ''), COALESCE(user_agent, ''), COALESCE(details, ''),
+	COALESCE(seq, 0), COALESCE(prev_hash, ''), COALESCE(hash, ''), COALESCE(trace_id, '')`
+
+func scanAuditLog(row rowScanner) (*AuditLogRecord, error) {
+	l := &AuditLogRecord{}
+	err := row.Scan(&l.ID, &l.Timestamp, &l.Username, &l.Action, &l.Resource, &l.Result, &l.IP, &l.UserAgent, &l.Details,
+		&l.Seq, &l.PrevHash, &l.Hash, &l.TraceID)
+	return l, err
+}
+
 func (d *Database) CreateAuditLog(l *AuditLogRecord) error {
 	start := time.Now()
-	_, err := d.db.Exec(`INSERT INTO audit_logs (username, action, resource, result, ip, user_agent, details) 
-	                        VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		l.Username, l.Action, l.Resource, l.Result, l.IP, l.UserAgent, l.Details)
-	metrics.RecordDBQuery("CreateAuditLog", time.Since(start))
+	var seq interface{}
+	if l.Seq > 0 {
+		seq = l.Seq
+	}
+	_, err := d.db.Exec(`INSERT INTO audit_logs (timestamp, username, action, resource, result, ip, user_agent, details, seq, prev_hash, hash, trace_id)
+	                        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		l.Timestamp, l.Username, l.Action, l.Resource, l.Result, l.IP, l.UserAgent, l.Details, seq, l.PrevHash, l.Hash, l.TraceID)
+	d.observe("CreateAuditLog", start, err)
 	return err
 }
 
-func (d *Database) ListAuditLogs(limit, offset int) ([]*AuditLogRecord, error) {
+// AuditLogFilter narrows audit log queries. Empty fields match everything.
+type AuditLogFilter struct {
+	Since          *time.Time
+	Until          *time.Time
+	Username       string
+	Action         string // May contain * and ? wildcards
+	ResourcePrefix string
+	Result         string
+	IP             string
+	Search         string // Substring of the details
+}
+
+// likeEscaper escapes the LIKE metacharacters of user input; patterns use ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// MatchesAction reports whether an action passes the action filter the way the database query
+// does: exactly, or with * matching any run of characters and ? any one character, ignoring
+// case like LIKE. No other character is special.
+func (f AuditLogFilter) MatchesAction(action string) bool {
+	if !strings.ContainsAny(f.Action, "*?") {
+		return action == f.Action
+	}
+	return matchWildcard([]rune(strings.ToLower(f.Action)), []rune(strings.ToLower(action)))
+}
+
+func matchWildcard(pattern, s []rune) bool {
+	// Backtrack to the last * on a mismatch
+	star, next := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, i
+			p++
+		case star >= 0:
+			next++
+			p, i = star+1, next
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+func (f AuditLogFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if f.Since != nil {
+		conds = append(conds, "timestamp >= ?")
+		args = append(args, f.Since.UTC())
+	}
+	if f.Until != nil {
+		conds = append(conds, "timestamp < ?")
+		args = append(args, f.Until.UTC())
+	}
+	if f.Username != "" {
+		conds = append(conds, "username = ?")
+		args = append(args, f.Username)
+	}
+	if f.Action != "" {
+		if strings.ContainsAny(f.Action, "*?") {
+			pattern := strings.NewReplacer("*", "%", "?", "_").Replace(likeEscaper.Replace(f.Action))
+			conds = append(conds, `action LIKE ? ESCAPE '\'`)
+			args = append(args, pattern)
+		} else {
+			conds = append(conds, "action = ?")
+			args = append(args, f.Action)
+		}
+	}
+	if f.ResourcePrefix != "" {
+		conds = append(conds, `resource LIKE ? ESCAPE '\'`)
+		args = append(args, likeEscaper.Replace(f.ResourcePrefix)+"%")
+	}
+	if f.Result != "" {
+		conds = append(conds, "result = ?")
+		args = append(args, f.Result)
+	}
+	if f.IP != "" {
+		conds = append(conds, "ip = ?")
+		args = append(args, f.IP)
+	}
+	if f.Search != "" {
+		conds = append(conds, `details LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(f.Search)+"%")
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func (d *Database) ListAuditLogs(filter AuditLogFilter, limit, offset int) ([]*AuditLogRecord, error) {
 	start := time.Now()
-	rows, err := d.db.Query(`SELECT id, timestamp, username, action, resource, result, ip, user_agent, details 
-	                          FROM audit_logs ORDER BY timestamp DESC LIMIT ? OFFSET ?`, limit, offset)
-	metrics.RecordDBQuery("ListAuditLogs", time.Since(start))
+	where, args := filter.where()
+	args = append(args, limit, offset)
+	rows, err := d.db.Query(`SELECT `+auditLogColumns+`
+	                          FROM audit_logs`+where+` ORDER BY timestamp DESC, id DESC LIMIT ? OFFSET ?`, args...)
+	d.observe("ListAuditLogs", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -1439,8 +2223,7 @@ func (d *Database) ListAuditLogs(limit, offset int) ([]*AuditLogRecord, error) {
 
 	logs := []*AuditLogRecord{}
 	for rows.Next() {
-		l := &AuditLogRecord{}
-		err := rows.Scan(&l.ID, &l.Timestamp, &l.Username, &l.Action, &l.Resource, &l.Result, &l.IP, &l.UserAgent, &l.Details)
+		l, err := scanAuditLog(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -1449,10 +2232,158 @@ func (d *Database) ListAuditLogs(limit, offset int) ([]*AuditLogRecord, error) {
 	return logs, nil
 }
 
+// ForEachAuditLog streams the audit entries matching the filter, oldest first
+func (d *Database) ForEachAuditLog(filter AuditLogFilter, fn func(*AuditLogRecord) error) (err error) {
+	start := time.Now()
+	where, args := filter.where()
+	rows, err := d.db.Query(`SELECT `+auditLogColumns+` FROM audit_logs`+where+` ORDER BY timestamp ASC, id ASC`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	defer func() { d.observe("ForEachAuditLog", start, err) }()
+
+	for rows.Next() {
+		l, err := scanAuditLog(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (d *Database) GetAuditLogBySeq(seq int64) (*AuditLogRecord, error) {
+	start := time.Now()
+	l, err := scanAuditLog(d.db.QueryRow(`SELECT `+auditLogColumns+` FROM audit_logs WHERE seq = ?`, seq))
+	d.observe("GetAuditLogBySeq", start, err)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return l, err
+}
+
+// GetAuditRetentionBoundary returns the highest sequence number logged before the cutoff and the
+// number of older entries written before the hash chain was introduced
+func (d *Database) GetAuditRetentionBoundary(cutoff time.Time) (int64, int64, error) {
+	var maxSeq, unchained int64
+	err := d.db.QueryRow(`SELECT COALESCE(MAX(seq), 0), COALESCE(SUM(CASE WHEN seq IS NULL THEN 1 ELSE 0 END), 0)
+		FROM audit_logs WHERE timestamp < ?`, cutoff.UTC()).Scan(&maxSeq, &unchained)
+	return maxSeq, unchained, err
+}
+
+// ForEachAuditLogBefore streams the entries retention removes: the chain up to maxSeq and unchained entries before the cutoff
+func (d *Database) ForEachAuditLogBefore(cutoff time.Time, maxSeq int64, fn func(*AuditLogRecord) error) error {
+	rows, err := d.db.Query(`SELECT `+auditLogColumns+` FROM audit_logs
+		WHERE seq <= ? OR (seq IS NULL AND timestamp < ?) ORDER BY COALESCE(seq, 0), id`, maxSeq, cutoff.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		l, err := scanAuditLog(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DeleteAuditLogsBefore removes the entries selected by ForEachAuditLogBefore
+func (d *Database) DeleteAuditLogsBefore(cutoff time.Time, maxSeq int64) (int64, error) {
+	start := time.Now()
+	res, err := d.db.Exec(`DELETE FROM audit_logs WHERE seq <= ? OR (seq IS NULL AND timestamp < ?)`, maxSeq, cutoff.UTC())
+	d.observe("DeleteAuditLogsBefore", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetAuditChainHead returns the last chained audit entry, or nil when the chain is empty
+func (d *Database) GetAuditChainHead() (*AuditLogRecord, error) {
+	start := time.Now()
+	row := d.db.QueryRow(`SELECT ` + auditLogColumns + ` FROM audit_logs WHERE seq IS NOT NULL ORDER BY seq DESC LIMIT 1`)
+	l, err := scanAuditLog(row)
+	d.observe("GetAuditChainHead", start, err)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return l, err
+}
+
+// ListAuditChain returns chained audit entries with a sequence number above afterSeq, in chain order
+func (d *Database) ListAuditChain(afterSeq int64, limit int) ([]*AuditLogRecord, error) {
+	start := time.Now()
+	rows, err := d.db.Query(`SELECT `+auditLogColumns+` FROM audit_logs
+		WHERE seq > ? ORDER BY seq ASC LIMIT ?`, afterSeq, limit)
+	d.observe("ListAuditChain", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []*AuditLogRecord{}
+	for rows.Next() {
+		l, err := scanAuditLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
+
+// CountUnchainedAuditLogs counts entries written before the hash chain was introduced
+func (d *Database) CountUnchainedAuditLogs() (int64, error) {
+	var count int64
+	err := d.db.QueryRow(`SELECT count(*) FROM audit_logs WHERE seq IS NULL`).Scan(&count)
+	return count, err
+}
+
+func (d *Database) CreateAuditCheckpoint(cp *AuditCheckpointRecord) error {
+	start := time.Now()
+	res, err := d.db.Exec(`INSERT INTO audit_checkpoints (seq, hash, algorithm, signature, prev_signature, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		cp.Seq, cp.Hash, cp.Algorithm, cp.Signature, cp.PrevSignature, cp.CreatedAt)
+	d.observe("CreateAuditCheckpoint", start, err)
+	if err != nil {
+		return err
+	}
+	cp.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// ListAuditCheckpoints returns all checkpoints ordered by sequence number
+func (d *Database) ListAuditCheckpoints() ([]*AuditCheckpointRecord, error) {
+	start := time.Now()
+	rows, err := d.db.Query(`SELECT id, seq, hash, algorithm, signature, COALESCE(prev_signature, ''), created_at FROM audit_checkpoints ORDER BY seq ASC, id ASC`)
+	d.observe("ListAuditCheckpoints", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := []*AuditCheckpointRecord{}
+	for rows.Next() {
+		cp := &AuditCheckpointRecord{}
+		if err := rows.Scan(&cp.ID, &cp.Seq, &cp.Hash, &cp.Algorithm, &cp.Signature, &cp.PrevSignature, &cp.CreatedAt); err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	return checkpoints, rows.Err()
+}
+
 func (d *Database) CreateStorageSnapshot(bucket string, size int64) error {
 	start := time.Now()
 	_, err := d.db.Exec(`INSERT INTO storage_snapshots (bucket, size) VALUES (?, ?)`, bucket, size)
-	metrics.RecordDBQuery("CreateStorageSnapshot", time.Since(start))
+	d.observe("CreateStorageSnapshot", start, err)
 	return err
 }
 
@@ -1462,7 +2393,7 @@ func (d *Database) HasSnapshotForToday(bucket string) (bool, error) {
 	// Check if a snapshot exists for this bucket with today's date (UTC)
 	err := d.db.QueryRow(`SELECT count(*) FROM storage_snapshots 
 	                      WHERE bucket = ? AND date(timestamp) = date('now')`, bucket).Scan(&count)
-	metrics.RecordDBQuery("HasSnapshotForToday", time.Since(start))
+	d.observe("HasSnapshotForToday", start, err)
 	return count > 0, err
 }
 
@@ -1472,7 +2403,7 @@ func (d *Database) GetStorageHistory(days int) ([]*StorageSnapshotRecord, error)
 	                          FROM storage_snapshots 
 	                          WHERE timestamp >= date('now', ?) 
 	                          ORDER BY timestamp ASC`, fmt.Sprintf("-%d days", days))
-	metrics.RecordDBQuery("GetStorageHistory", time.Since(start))
+	d.observe("GetStorageHistory", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -1498,7 +2429,7 @@ func (d *Database) GetActionTrends(days int) (map[string][]map[string]interface{
 	                          WHERE timestamp >= date('now', ?) 
 	                          GROUP BY day, action 
 	                          ORDER BY day ASC`, fmt.Sprintf("-%d days", days))
-	metrics.RecordDBQuery("GetActionTrends", time.Since(start))
+	d.observe("GetActionTrends", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -1523,7 +2454,7 @@ func (d *Database) GetSystemSetting(key string) (string, error) {
 	start := time.Now()
 	var value string
 	err := d.db.QueryRow("SELECT value FROM system_settings WHERE key = ?", key).Scan(&value)
-	metrics.RecordDBQuery("GetSystemSetting", time.Since(start))
+	d.observe("GetSystemSetting", start, err)
 	if err == sql.ErrNoRows {
 		return "", nil // Return empty string if not found
 	}
@@ -1536,22 +2467,18 @@ func (d *Database) SetSystemSetting(key, value string) error {
 	                      VALUES (?, ?, CURRENT_TIMESTAMP)
 	                      ON CONFLICT(key) DO UPDATE SET value = ?, updated_at = CURRENT_TIMESTAMP`,
 		key, value, value)
-	metrics.RecordDBQuery("SetSystemSetting", time.Since(start))
+	d.observe("SetSystemSetting", start, err)
 	return err
 }
 
 func (d *Database) GetObjectByHash(hash string) (*ObjectRow, error) {
 	start := time.Now()
-	query := `SELECT id, bucket, key, version_id, size, etag, content_type, modified_at, is_latest, encryption_type, retain_until_date, legal_hold, lock_mode, deleted_at, content_hash, compression_type, original_size, is_deduplicated
-	          FROM objects WHERE content_hash = ? AND deleted_at IS NULL LIMIT 1`
+	query := `SELECT ` + objectColumns + `
+	          FROM objects WHERE content_hash = ? AND deleted_at IS NULL
+	          AND (storage_class IS NULL OR storage_class = 'STANDARD') LIMIT 1`
 
-	var obj ObjectRow
-	err := d.db.QueryRow(query, hash).Scan(
-		&obj.ID, &obj.Bucket, &obj.Key, &obj.VersionID, &obj.Size,
-		&obj.ETag, &obj.ContentType, &obj.ModifiedAt, &obj.IsLatest, &obj.EncryptionType,
-		&obj.RetainUntilDate, &obj.LegalHold, &obj.LockMode, &obj.DeletedAt,
-		&obj.ContentHash, &obj.CompressionType, &obj.OriginalSize, &obj.IsDeduplicated)
-	metrics.RecordDBQuery("GetObjectByHash", time.Since(start))
+	obj, err := scanObject(d.db.QueryRow(query, hash))
+	d.observe("GetObjectByHash", start, err)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -1559,14 +2486,59 @@ func (d *Database) GetObjectByHash(hash string) (*ObjectRow, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &obj, nil
+	return obj, nil
+}
+
+// ListObjectsByHash returns the versions, including those in the trash, that share the CAS
+// blob of a content hash. Archived versions live in the cold tier and are left out.
+func (d *Database) ListObjectsByHash(hash string) ([]*ObjectRow, error) {
+	start := time.Now()
+	query := `SELECT ` + objectColumns + `
+	          FROM objects WHERE content_hash = ? AND (storage_class IS NULL OR storage_class = 'STANDARD')
+	          ORDER BY id`
+	rows, err := d.db.Query(query, hash)
+	d.observe("ListObjectsByHash", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []*ObjectRow
+	for rows.Next() {
+		obj, err := scanObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// SetContentCorrupted flags or clears every version stored under a content hash and returns
+// how many were changed. Archived versions have their own copy in the cold tier.
+func (d *Database) SetContentCorrupted(hash string, corrupted bool) (int64, error) {
+	start := time.Now()
+	const standardClass = ` AND (storage_class IS NULL OR storage_class = 'STANDARD')`
+	var res sql.Result
+	var err error
+	if corrupted {
+		res, err = d.db.Exec(`UPDATE objects SET corrupted_at = ? WHERE content_hash = ? AND corrupted_at IS NULL`+standardClass, time.Now().UTC(), hash)
+	} else {
+		res, err = d.db.Exec(`UPDATE objects SET corrupted_at = NULL WHERE content_hash = ? AND corrupted_at IS NOT NULL`+standardClass, hash)
+	}
+	d.observe("SetContentCorrupted", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
 }
 
 func (d *Database) CountObjectHashReferences(hash string) (int, error) {
 	start := time.Now()
 	var count int
-	err := d.db.QueryRow("SELECT COUNT(*) FROM objects WHERE content_hash = ?", hash).Scan(&count)
-	metrics.RecordDBQuery("CountObjectHashReferences", time.Since(start))
+	// Archived versions live in the cold tier and no longer pin the CAS blob
+	err := d.db.QueryRow("SELECT COUNT(*) FROM objects WHERE content_hash = ? AND (storage_class IS NULL OR storage_class = 'STANDARD')", hash).Scan(&count)
+	d.observe("CountObjectHashReferences", start, err)
 	return count, err
 }
 
@@ -1575,7 +2547,7 @@ func (d *Database) GetWebhook(id int64) (*WebhookRecord, error) {
 	var h WebhookRecord
 	err := d.db.QueryRow("SELECT id, bucket, url, events, secret, active, created_at FROM webhooks WHERE id = ?", id).
 		Scan(&h.ID, &h.Bucket, &h.URL, &h.Events, &h.Secret, &h.Active, &h.CreatedAt)
-	metrics.RecordDBQuery("GetWebhook", time.Since(start))
+	d.observe("GetWebhook", start, err)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -1587,7 +2559,7 @@ func (d *Database) CreateWebhookDLQ(webhookID int64, bucket, url, eventName, pay
 	res, err := d.db.Exec(`INSERT INTO webhook_dlq (webhook_id, bucket, url, event_name, payload, error_message) 
 	                        VALUES (?, ?, ?, ?, ?, ?)`,
 		webhookID, bucket, url, eventName, payload, errorMsg)
-	metrics.RecordDBQuery("CreateWebhookDLQ", time.Since(start))
+	d.observe("CreateWebhookDLQ", start, err)
 	if err != nil {
 		return 0, err
 	}
@@ -1598,7 +2570,7 @@ func (d *Database) ListWebhookDLQ(bucket string) ([]*WebhookDLQRecord, error) {
 	start := time.Now()
 	rows, err := d.db.Query(`SELECT id, webhook_id, bucket, url, event_name, payload, error_message, failed_at 
 	                        FROM webhook_dlq WHERE bucket = ? ORDER BY failed_at DESC`, bucket)
-	metrics.RecordDBQuery("ListWebhookDLQ", time.Since(start))
+	d.observe("ListWebhookDLQ", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -1621,17 +2593,26 @@ func (d *Database) GetWebhookDLQ(id int64) (*WebhookDLQRecord, error) {
 	err := d.db.QueryRow(`SELECT id, webhook_id, bucket, url, event_name, payload, error_message, failed_at 
 	                      FROM webhook_dlq WHERE id = ?`, id).
 		Scan(&r.ID, &r.WebhookID, &r.Bucket, &r.URL, &r.EventName, &r.Payload, &r.ErrorMessage, &r.FailedAt)
-	metrics.RecordDBQuery("GetWebhookDLQ", time.Since(start))
+	d.observe("GetWebhookDLQ", start, err)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	return &r, err
 }
 
+// CountWebhookDLQ returns the number of failed deliveries in the dead letter queue
+func (d *Database) CountWebhookDLQ() (int64, error) {
+	start := time.Now()
+	var n int64
+	err := d.db.QueryRow("SELECT COUNT(*) FROM webhook_dlq").Scan(&n)
+	d.observe("CountWebhookDLQ", start, err)
+	return n, err
+}
+
 func (d *Database) DeleteWebhookDLQ(id int64) error {
 	start := time.Now()
 	_, err := d.db.Exec("DELETE FROM webhook_dlq WHERE id = ?", id)
-	metrics.RecordDBQuery("DeleteWebhookDLQ", time.Since(start))
+	d.observe("DeleteWebhookDLQ", start, err)
 	return err
 }
 
@@ -1647,7 +2628,7 @@ func (d *Database) GetContentTypeBreakdown() ([]*ContentTypeBreakdown, error) {
 	                          FROM objects 
 	                          WHERE is_latest = 1 AND deleted_at IS NULL 
 	                          GROUP BY ct`)
-	metrics.RecordDBQuery("GetContentTypeBreakdown", time.Since(start))
+	d.observe("GetContentTypeBreakdown", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -1704,7 +2685,7 @@ func (d *Database) CreatePresignedURL(row *PresignedURLRow) error {
 		INSERT INTO presigned_urls (bucket, key, url, signature, expires_at, allowed_ip, one_time_use)
 		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`, row.Bucket, row.Key, row.URL, row.Signature, row.ExpiresAt, row.AllowedIP, row.OneTimeUse)
-	metrics.RecordDBQuery("CreatePresignedURL", time.Since(start))
+	d.observe("CreatePresignedURL", start, err)
 	return err
 }
 
@@ -1715,7 +2696,7 @@ func (d *Database) ListPresignedURLs() ([]*PresignedURLRow, error) {
 		FROM presigned_urls
 		ORDER BY created_at DESC
 	`)
-	metrics.RecordDBQuery("ListPresignedURLs", time.Since(start))
+	d.observe("ListPresignedURLs", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -1750,7 +2731,7 @@ func (d *Database) RevokeSignature(signature string) error {
 	_, err2 := d.db.Exec(`
 		UPDATE presigned_urls SET is_revoked = TRUE WHERE signature = ?
 	`, signature)
-	metrics.RecordDBQuery("RevokeSignature", time.Since(start))
+	d.observe("RevokeSignature", start, err2)
 	return err2
 }
 
@@ -1760,7 +2741,7 @@ func (d *Database) IsSignatureRevoked(signature string) (bool, error) {
 	err := d.db.QueryRow(`
 		SELECT COUNT(*) FROM revoked_signatures WHERE signature = ?
 	`, signature).Scan(&count)
-	metrics.RecordDBQuery("IsSignatureRevoked", time.Since(start))
+	d.observe("IsSignatureRevoked", start, err)
 	if err != nil {
 		return false, err
 	}
@@ -1771,21 +2752,24 @@ func (d *Database) IsSignatureRevoked(signature string) (bool, error) {
 func (d *Database) CreateReplicationRule(row *ReplicationRow) error {
 	start := time.Now()
 	_, err := d.db.Exec(`
-		INSERT INTO bucket_replications (source_bucket, destination_bucket, prefix, enabled)
-		VALUES (?, ?, ?, ?)
-	`, row.SourceBucket, row.DestinationBucket, row.Prefix, row.Enabled)
-	metrics.RecordDBQuery("CreateReplicationRule", time.Since(start))
+		INSERT INTO bucket_replications (source_bucket, destination_bucket, prefix, enabled, target_id,
+			delete_marker_replication, metadata_replication)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, row.SourceBucket, row.DestinationBucket, row.Prefix, row.Enabled, row.TargetID,
+		row.DeleteMarkerReplication, row.MetadataReplication)
+	d.observe("CreateReplicationRule", start, err)
 	return err
 }
 
 func (d *Database) GetReplicationRules(bucket string) ([]ReplicationRow, error) {
 	start := time.Now()
 	rows, err := d.db.Query(`
-		SELECT id, source_bucket, destination_bucket, prefix, enabled, created_at
+		SELECT id, source_bucket, destination_bucket, prefix, enabled, created_at, target_id,
+			delete_marker_replication, metadata_replication
 		FROM bucket_replications
 		WHERE source_bucket = ?
 	`, bucket)
-	metrics.RecordDBQuery("GetReplicationRules", time.Since(start))
+	d.observe("GetReplicationRules", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -1794,7 +2778,8 @@ func (d *Database) GetReplicationRules(bucket string) ([]ReplicationRow, error)
 	var results []ReplicationRow
 	for rows.Next() {
 		var r ReplicationRow
-		err := rows.Scan(&r.ID, &r.SourceBucket, &r.DestinationBucket, &r.Prefix, &r.Enabled, &r.CreatedAt)
+		err := rows.Scan(&r.ID, &r.SourceBucket, &r.DestinationBucket, &r.Prefix, &r.Enabled, &r.CreatedAt, &r.TargetID,
+			&r.DeleteMarkerReplication, &r.MetadataReplication)
 		if err != nil {
 			return nil, err
 		}
@@ -1808,6 +2793,1061 @@ func (d *Database) DeleteReplicationRule(id int64) error {
 	_, err := d.db.Exec(`
 		DELETE FROM bucket_replications WHERE id = ?
 	`, id)
-	metrics.RecordDBQuery("DeleteReplicationRule", time.Since(start))
+	d.observe("DeleteReplicationRule", start, err)
 	return err
 }
+
+func (d *Database) GetReplicationRule(id int64) (*ReplicationRow, error) {
+	start := time.Now()
+	var r ReplicationRow
+	err := d.db.QueryRow(`
+		SELECT id, source_bucket, destination_bucket, prefix, enabled, created_at, target_id,
+			delete_marker_replication, metadata_replication
+		FROM bucket_replications WHERE id = ?
+	`, id).Scan(&r.ID, &r.SourceBucket, &r.DestinationBucket, &r.Prefix, &r.Enabled, &r.CreatedAt, &r.TargetID,
+		&r.DeleteMarkerReplication, &r.MetadataReplication)
+	d.observe("GetReplicationRule", start, err)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Replication Target Operations
+func (d *Database) CreateReplicationTarget(t *ReplicationTargetRow) (int64, error) {
+	start := time.Now()
+	result, err := d.db.Exec(`
+		INSERT INTO replication_targets (name, endpoint, region, access_key, secret_key, use_path_style)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, t.Name, t.Endpoint, t.Region, t.AccessKey, t.SecretKey, t.UsePathStyle)
+	d.observe("CreateReplicationTarget", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (d *Database) ListReplicationTargets() ([]ReplicationTargetRow, error) {
+	start := time.Now()
+	rows, err := d.db.Query(`
+		SELECT id, name, endpoint, region, access_key, secret_key, use_path_style, created_at
+		FROM replication_targets ORDER BY name
+	`)
+	d.observe("ListReplicationTargets", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []ReplicationTargetRow
+	for rows.Next() {
+		var t ReplicationTargetRow
+		if err := rows.Scan(&t.ID, &t.Name, &t.Endpoint, &t.Region, &t.AccessKey, &t.SecretKey, &t.UsePathStyle, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		results = append(results, t)
+	}
+	return results, rows.Err()
+}
+
+func (d *Database) GetReplicationTarget(id int64) (*ReplicationTargetRow, error) {
+	start := time.Now()
+	var t ReplicationTargetRow
+	err := d.db.QueryRow(`
+		SELECT id, name, endpoint, region, access_key, secret_key, use_path_style, created_at
+		FROM replication_targets WHERE id = ?
+	`, id).Scan(&t.ID, &t.Name, &t.Endpoint, &t.Region, &t.AccessKey, &t.SecretKey, &t.UsePathStyle, &t.CreatedAt)
+	d.observe("GetReplicationTarget", start, err)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (d *Database) DeleteReplicationTarget(id int64) error {
+	start := time.Now()
+	var inUse int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM bucket_replications WHERE target_id = ?", id).Scan(&inUse); err != nil {
+		return err
+	}
+	if inUse > 0 {
+		return fmt.Errorf("replication target is used by %d replication rule(s)", inUse)
+	}
+	_, err := d.db.Exec("DELETE FROM replication_targets WHERE id = ?", id)
+	d.observe("DeleteReplicationTarget", start, err)
+	return err
+}
+
+// Replication Queue Operations
+
+// EnqueueReplicationTask queues an operation on an object version for replication. A task that is
+// already pending for the same rule, object and operation is reused, and finished tasks it supersedes are dropped.
+func (d *Database) EnqueueReplicationTask(task *ReplicationTaskRow) (id int64, created bool, err error) {
+	start := time.Now()
+	task.Key = strings.TrimPrefix(task.Key, "/")
+	defer func() { d.observe("EnqueueReplicationTask", start, err) }()
+
+	if _, err := d.db.Exec(`
+		DELETE FROM replication_queue
+		WHERE rule_id = ? AND source_bucket = ? AND key = ? AND version_id = ? AND operation = ? AND status != 'pending'
+	`, task.RuleID, task.SourceBucket, task.Key, task.VersionID, task.Operation); err != nil {
+		return 0, false, err
+	}
+
+	err = d.db.QueryRow(`
+		SELECT id FROM replication_queue
+		WHERE rule_id = ? AND source_bucket = ? AND key = ? AND version_id = ? AND operation = ? AND status = 'pending'
+		LIMIT 1
+	`, task.RuleID, task.SourceBucket, task.Key, task.VersionID, task.Operation).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	res, err := d.db.Exec(`
+		INSERT INTO replication_queue (rule_id, source_bucket, key, version_id, operation, status, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, 'pending', ?)
+	`, task.RuleID, task.SourceBucket, task.Key, task.VersionID, task.Operation, time.Now().UTC())
+	if err != nil {
+		return 0, false, err
+	}
+	id, err = res.LastInsertId()
+	return id, err == nil, err
+}
+
+func (d *Database) GetReplicationTask(id int64) (*ReplicationTaskRow, error) {
+	start := time.Now()
+	var t ReplicationTaskRow
+	err := d.db.QueryRow(`
+		SELECT id, rule_id, source_bucket, key, version_id, operation, status, attempts, next_attempt_at, last_error, created_at
+		FROM replication_queue WHERE id = ?
+	`, id).Scan(&t.ID, &t.RuleID, &t.SourceBucket, &t.Key, &t.VersionID, &t.Operation, &t.Status,
+		&t.Attempts, &t.NextAttemptAt, &t.LastError, &t.CreatedAt)
+	d.observe("GetReplicationTask", start, err)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// UpdateReplicationTask records the outcome of an attempt
+func (d *Database) UpdateReplicationTask(id int64, status string, attempts int, nextAttemptAt time.Time, lastError *string) error {
+	start := time.Now()
+	_, err := d.db.Exec(`
+		UPDATE replication_queue SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, status, attempts, nextAttemptAt.UTC(), lastError, id)
+	d.observe("UpdateReplicationTask", start, err)
+	return err
+}
+
+// GetReplicationTaskStatuses returns the status of every put task queued for an object version
+func (d *Database) GetReplicationTaskStatuses(bucket, key, versionID string) ([]string, error) {
+	start := time.Now()
+	key = strings.TrimPrefix(key, "/")
+	rows, err := d.db.Query(`
+		SELECT status FROM replication_queue
+		WHERE source_bucket = ? AND key = ? AND version_id = ? AND operation = 'put'
+	`, bucket, key, versionID)
+	d.observe("GetReplicationTaskStatuses", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statuses []string
+	for rows.Next() {
+		var status string
+		if err := rows.Scan(&status); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, rows.Err()
+}
+
+// GetReplicationBacklog returns the number of pending tasks and when the oldest was queued
+func (d *Database) GetReplicationBacklog() (_ int, _ *time.Time, err error) {
+	start := time.Now()
+	defer func() { d.observe("GetReplicationBacklog", start, err) }()
+
+	var count int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM replication_queue WHERE status = 'pending'").Scan(&count); err != nil {
+		return 0, nil, err
+	}
+	if count == 0 {
+		return 0, nil, nil
+	}
+	var oldest time.Time
+	err = d.db.QueryRow("SELECT created_at FROM replication_queue WHERE status = 'pending' ORDER BY id LIMIT 1").Scan(&oldest)
+	if err == sql.ErrNoRows {
+		return count, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return count, &oldest, nil
+}
+
+// ListReplicationTasks returns queued tasks of a bucket, optionally filtered by status
+func (d *Database) ListReplicationTasks(bucket, status string, limit int) ([]ReplicationTaskRow, error) {
+	start := time.Now()
+	query := `
+		SELECT id, rule_id, source_bucket, key, version_id, operation, status, attempts, next_attempt_at, last_error, created_at
+		FROM replication_queue WHERE source_bucket = ?`
+	args := []interface{}{bucket}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := d.db.Query(query, args...)
+	d.observe("ListReplicationTasks", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []ReplicationTaskRow
+	for rows.Next() {
+		var t ReplicationTaskRow
+		if err := rows.Scan(&t.ID, &t.RuleID, &t.SourceBucket, &t.Key, &t.VersionID, &t.Operation, &t.Status,
+			&t.Attempts, &t.NextAttemptAt, &t.LastError, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// HasReplicationTask reports whether an object version was already queued or replicated for a rule
+func (d *Database) HasReplicationTask(ruleID int64, bucket, key, versionID, operation string) (bool, error) {
+	start := time.Now()
+	key = strings.TrimPrefix(key, "/")
+	var count int
+	err := d.db.QueryRow(`
+		SELECT COUNT(*) FROM replication_queue
+		WHERE rule_id = ? AND source_bucket = ? AND key = ? AND version_id = ? AND operation = ? AND status != 'failed'
+	`, ruleID, bucket, key, versionID, operation).Scan(&count)
+	d.observe("HasReplicationTask", start, err)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Replication Backfill Operations
+func (d *Database) CreateReplicationBackfill(ruleID int64, bucket string) (int64, error) {
+	start := time.Now()
+	res, err := d.db.Exec(`
+		INSERT INTO replication_backfills (rule_id, source_bucket, status, started_at) VALUES (?, ?, 'running', ?)
+	`, ruleID, bucket, time.Now().UTC())
+	d.observe("CreateReplicationBackfill", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// UpdateReplicationBackfill records progress; a finished backfill also gets its end time
+func (d *Database) UpdateReplicationBackfill(id int64, status string, scanned, queued int64, lastError *string) error {
+	start := time.Now()
+	var finishedAt *time.Time
+	if status != "running" {
+		now := time.Now().UTC()
+		finishedAt = &now
+	}
+	_, err := d.db.Exec(`
+		UPDATE replication_backfills SET status = ?, scanned = ?, queued = ?, error = ?, finished_at = ?
+		WHERE id = ?
+	`, status, scanned, queued, lastError, finishedAt, id)
+	d.observe("UpdateReplicationBackfill", start, err)
+	return err
+}
+
+func (d *Database) ListReplicationBackfills(bucket string) ([]ReplicationBackfillRow, error) {
+	start := time.Now()
+	rows, err := d.db.Query(`
+		SELECT id, rule_id, source_bucket, status, scanned, queued, error, started_at, finished_at
+		FROM replication_backfills WHERE source_bucket = ?
+		ORDER BY id DESC LIMIT 50
+	`, bucket)
+	d.observe("ListReplicationBackfills", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []ReplicationBackfillRow
+	for rows.Next() {
+		var b ReplicationBackfillRow
+		if err := rows.Scan(&b.ID, &b.RuleID, &b.SourceBucket, &b.Status, &b.Scanned, &b.Queued, &b.Error,
+			&b.StartedAt, &b.FinishedAt); err != nil {
+			return nil, err
+		}
+		results = append(results, b)
+	}
+	return results, rows.Err()
+}
+
+// Quarantine Operations
+
+// QuarantineBlob records a damaged blob; a blob quarantined again replaces its earlier record
+func (d *Database) QuarantineBlob(q *QuarantinedBlobRow) error {
+	start := time.Now()
+	_, err := d.db.Exec(`
+		INSERT INTO quarantined_blobs (hash, path, size, reason, objects, detected_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(hash) DO UPDATE SET path = excluded.path, size = excluded.size, reason = excluded.reason,
+			objects = excluded.objects, detected_at = excluded.detected_at, restored_at = NULL, restored_from = NULL
+	`, q.Hash, q.Path, q.Size, q.Reason, q.Objects, q.DetectedAt)
+	d.observe("QuarantineBlob", start, err)
+	return err
+}
+
+// MarkBlobRestored records where an intact copy of a quarantined blob came from
+func (d *Database) MarkBlobRestored(hash, source string) error {
+	start := time.Now()
+	_, err := d.db.Exec("UPDATE quarantined_blobs SET restored_at = ?, restored_from = ? WHERE hash = ?",
+		time.Now().UTC(), source, hash)
+	d.observe("MarkBlobRestored", start, err)
+	return err
+}
+
+// ListQuarantinedBlobs returns the quarantined blobs, those still missing an intact copy first
+func (d *Database) ListQuarantinedBlobs() ([]QuarantinedBlobRow, error) {
+	start := time.Now()
+	rows, err := d.db.Query(`
+		SELECT hash, path, size, reason, objects, detected_at, restored_at, restored_from
+		FROM quarantined_blobs ORDER BY restored_at IS NOT NULL, detected_at DESC
+	`)
+	d.observe("ListQuarantinedBlobs", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []QuarantinedBlobRow
+	for rows.Next() {
+		var q QuarantinedBlobRow
+		if err := rows.Scan(&q.Hash, &q.Path, &q.Size, &q.Reason, &q.Objects, &q.DetectedAt, &q.RestoredAt, &q.RestoredFrom); err != nil {
+			return nil, err
+		}
+		results = append(results, q)
+	}
+	return results, rows.Err()
+}
+
+// Erasure Coding Operations
+
+// CreateErasureBlob records the shards of an erasure-coded blob
+func (d *Database) CreateErasureBlob(e *ErasureBlobRow) error {
+	start := time.Now()
+	_, err := d.db.Exec(`
+		INSERT INTO erasure_blobs (hash, data_shards, parity_shards, block_size, size, checksums, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(hash) DO UPDATE SET data_shards = excluded.data_shards, parity_shards = excluded.parity_shards,
+			block_size = excluded.block_size, size = excluded.size, checksums = excluded.checksums
+	`, e.Hash, e.DataShards, e.ParityShards, e.BlockSize, e.Size, strings.Join(e.Checksums, ","), e.CreatedAt)
+	d.observe("CreateErasureBlob", start, err)
+	return err
+}
+
+// GetErasureBlob returns the shards of a blob, or nil when the blob is not erasure-coded
+func (d *Database) GetErasureBlob(hash string) (_ *ErasureBlobRow, err error) {
+	start := time.Now()
+	defer func() { d.observe("GetErasureBlob", start, err) }()
+	row := d.db.QueryRow(`SELECT hash, data_shards, parity_shards, block_size, size, checksums, created_at
+		FROM erasure_blobs WHERE hash = ?`, hash)
+	e, err := scanErasureBlob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return e, err
+}
+
+// ListErasureBlobs returns every erasure-coded blob
+func (d *Database) ListErasureBlobs() ([]*ErasureBlobRow, error) {
+	start := time.Now()
+	rows, err := d.db.Query(`SELECT hash, data_shards, parity_shards, block_size, size, checksums, created_at
+		FROM erasure_blobs ORDER BY hash`)
+	d.observe("ListErasureBlobs", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*ErasureBlobRow
+	for rows.Next() {
+		e, err := scanErasureBlob(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, e)
+	}
+	return results, rows.Err()
+}
+
+// DeleteErasureBlob forgets an erasure-coded blob once its shards are removed
+func (d *Database) DeleteErasureBlob(hash string) error {
+	start := time.Now()
+	_, err := d.db.Exec("DELETE FROM erasure_blobs WHERE hash = ?", hash)
+	d.observe("DeleteErasureBlob", start, err)
+	return err
+}
+
+func scanErasureBlob(row rowScanner) (*ErasureBlobRow, error) {
+	var e ErasureBlobRow
+	var checksums string
+	if err := row.Scan(&e.Hash, &e.DataShards, &e.ParityShards, &e.BlockSize, &e.Size, &checksums, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	e.Checksums = strings.Split(checksums, ",")
+	return &e, nil
+}
+
+// SetObjectReplicationStatus stores the x-amz-replication-status of an object version
+func (d *Database) SetObjectReplicationStatus(bucket, key, versionID, status string) error {
+	start := time.Now()
+	key = strings.TrimPrefix(key, "/")
+	_, err := d.db.Exec("UPDATE objects SET replication_status = ? WHERE bucket = ? AND key = ? AND version_id = ?",
+		status, bucket, key, versionID)
+	d.observe("SetObjectReplicationStatus", start, err)
+	return err
+}
+
+// Job Queue Operations
+
+const jobColumns = `id, type, name, payload, unique_key, state, attempts, max_attempts, next_run_at,
+	lease_owner, lease_expires_at, last_error, created_at, updated_at, finished_at`
+
+func scanJob(row rowScanner) (*JobRow, error) {
+	var j JobRow
+	var name sql.NullString
+	err := row.Scan(&j.ID, &j.Type, &name, &j.Payload, &j.UniqueKey, &j.State, &j.Attempts, &j.MaxAttempts,
+		&j.NextRunAt, &j.LeaseOwner, &j.LeaseExpiresAt, &j.LastError, &j.CreatedAt, &j.UpdatedAt, &j.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	j.Name = name.String
+	return &j, nil
+}
+
+// CreateJob queues a job. When the job has a unique key and an unfinished job with the same key
+// exists, that job's ID is returned instead and created is false.
+func (d *Database) CreateJob(j *JobRow) (id int64, created bool, err error) {
+	start := time.Now()
+	defer func() { d.observe("CreateJob", start, err) }()
+
+	now := time.Now().UTC()
+	if j.NextRunAt.IsZero() {
+		j.NextRunAt = now
+	}
+	// One statement, so concurrent enqueues of a unique job cannot both insert it; the partial
+	// unique index covers databases shared between processes
+	res, err := d.db.Exec(`
+		INSERT INTO jobs (type, name, payload, unique_key, state, max_attempts, next_run_at, created_at, updated_at)
+		SELECT ?, ?, ?, ?, 'queued', ?, ?, ?, ?
+		WHERE ? IS NULL OR NOT EXISTS (SELECT 1 FROM jobs WHERE unique_key = ? AND state IN ('queued', 'running'))
+		ON CONFLICT DO NOTHING
+	`, j.Type, j.Name, j.Payload, j.UniqueKey, j.MaxAttempts, j.NextRunAt.UTC(), now, now, j.UniqueKey, j.UniqueKey)
+	if err != nil {
+		return 0, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, false, err
+	} else if n == 1 {
+		id, err = res.LastInsertId()
+		return id, err == nil, err
+	}
+
+	err = d.db.QueryRow(`
+		SELECT id FROM jobs WHERE unique_key = ? AND state IN ('queued', 'running') LIMIT 1
+	`, j.UniqueKey).Scan(&id)
+	if err == sql.ErrNoRows {
+		// The pending job finished in between
+		return 0, false, nil
+	}
+	return id, false, err
+}
+
+// LeaseJob claims the next due job for owner until leaseUntil. Jobs whose lease expired
+// (their worker died) are claimed again while they have attempts left; the lost attempt
+// counts and is recorded as the last error. It returns nil when nothing is due.
+func (d *Database) LeaseJob(owner string, leaseUntil time.Time) (_ *JobRow, err error) {
+	start := time.Now()
+	defer func() { d.observe("LeaseJob", start, err) }()
+
+	for i := 0; i < 5; i++ {
+		now := time.Now().UTC()
+		var id int64
+		err := d.db.QueryRow(`
+			SELECT id FROM jobs
+			WHERE (state = 'queued' AND next_run_at <= ?) OR (state = 'running' AND lease_expires_at <= ? AND attempts < max_attempts)
+			ORDER BY next_run_at, id LIMIT 1
+		`, now, now).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Compare-and-set so that concurrent workers never claim the same job
+		res, err := d.db.Exec(`
+			UPDATE jobs SET state = 'running', attempts = attempts + 1, lease_owner = ?, lease_expires_at = ?, updated_at = ?,
+				last_error = CASE WHEN state = 'running' THEN ? ELSE last_error END
+			WHERE id = ? AND ((state = 'queued' AND next_run_at <= ?) OR (state = 'running' AND lease_expires_at <= ? AND attempts < max_attempts))
+		`, owner, leaseUntil.UTC(), now, JobLeaseExpired, id, now, now)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return d.GetJob(id)
+		}
+	}
+	return nil, nil
+}
+
+// JobLeaseExpired is the last error of a job whose worker stopped renewing its lease
+const JobLeaseExpired = "lease expired before the attempt finished"
+
+// DeadLetterExpiredJobs marks running jobs dead whose lease expired on their last attempt,
+// which LeaseJob no longer claims, and returns them.
+func (d *Database) DeadLetterExpiredJobs() (_ []*JobRow, err error) {
+	start := time.Now()
+	defer func() { d.observe("DeadLetterExpiredJobs", start, err) }()
+
+	now := time.Now().UTC()
+	rows, err := d.db.Query(`SELECT `+jobColumns+` FROM jobs
+		WHERE state = 'running' AND lease_expires_at <= ? AND attempts >= max_attempts`, now)
+	if err != nil {
+		return nil, err
+	}
+	var expired []*JobRow
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		expired = append(expired, j)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var dead []*JobRow
+	for _, j := range expired {
+		// Compare-and-set against a worker renewing the lease late
+		res, err := d.db.Exec(`
+			UPDATE jobs SET state = 'dead', last_error = ?, lease_owner = NULL, lease_expires_at = NULL, updated_at = ?, finished_at = ?
+			WHERE id = ? AND state = 'running' AND lease_expires_at <= ?
+		`, JobLeaseExpired, now, now, j.ID, now)
+		if err != nil {
+			return dead, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			dead = append(dead, j)
+		}
+	}
+	return dead, nil
+}
+
+// ExtendJobLease keeps a long-running job claimed by its worker
+func (d *Database) ExtendJobLease(id int64, owner string, leaseUntil time.Time) error {
+	start := time.Now()
+	_, err := d.db.Exec(`
+		UPDATE jobs SET lease_expires_at = ? WHERE id = ? AND state = 'running' AND lease_owner = ?
+	`, leaseUntil.UTC(), id, owner)
+	d.observe("ExtendJobLease", start, err)
+	return err
+}
+
+// FinishJob records the outcome of a leased job. It does nothing when the job was cancelled
+// or claimed by another worker in the meantime.
+func (d *Database) FinishJob(id int64, owner, state string, nextRunAt time.Time, lastError *string) error {
+	start := time.Now()
+	now := time.Now().UTC()
+	var finishedAt *time.Time
+	if state != "queued" {
+		finishedAt = &now
+	}
+	_, err := d.db.Exec(`
+		UPDATE jobs SET state = ?, next_run_at = ?, last_error = ?, lease_owner = NULL, lease_expires_at = NULL,
+			updated_at = ?, finished_at = ?
+		WHERE id = ? AND state = 'running' AND lease_owner = ?
+	`, state, nextRunAt.UTC(), lastError, now, finishedAt, id, owner)
+	d.observe("FinishJob", start, err)
+	return err
+}
+
+func (d *Database) GetJob(id int64) (*JobRow, error) {
+	start := time.Now()
+	j, err := scanJob(d.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id))
+	d.observe("GetJob", start, err)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return j, err
+}
+
+// ListJobs returns the most recent jobs, optionally filtered by state and type
+func (d *Database) ListJobs(state, jobType string, limit int) ([]*JobRow, error) {
+	start := time.Now()
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE 1=1`
+	var args []interface{}
+	if state != "" {
+		query += " AND state = ?"
+		args = append(args, state)
+	}
+	if jobType != "" {
+		query += " AND type = ?"
+		args = append(args, jobType)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := d.db.Query(query, args...)
+	d.observe("ListJobs", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*JobRow
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, j)
+	}
+	return results, rows.Err()
+}
+
+// RetryJob queues a dead, cancelled or succeeded job again with a fresh attempt budget
+func (d *Database) RetryJob(id int64) (bool, error) {
+	start := time.Now()
+	now := time.Now().UTC()
+	res, err := d.db.Exec(`
+		UPDATE jobs SET state = 'queued', attempts = 0, next_run_at = ?, last_error = NULL, updated_at = ?, finished_at = NULL
+		WHERE id = ? AND state IN ('dead', 'cancelled', 'succeeded')
+	`, now, now, id)
+	d.observe("RetryJob", start, err)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// CancelJob stops a queued job from running. A running job finishes its current attempt
+// but is not retried.
+func (d *Database) CancelJob(id int64) (bool, error) {
+	start := time.Now()
+	now := time.Now().UTC()
+	res, err := d.db.Exec(`
+		UPDATE jobs SET state = 'cancelled', lease_owner = NULL, lease_expires_at = NULL, updated_at = ?, finished_at = ?
+		WHERE id = ? AND state IN ('queued', 'running')
+	`, now, now, id)
+	d.observe("CancelJob", start, err)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// CountJobsByState returns the number of jobs in each state
+func (d *Database) CountJobsByState() (map[string]int, error) {
+	start := time.Now()
+	rows, err := d.db.Query("SELECT state, COUNT(*) FROM jobs GROUP BY state")
+	d.observe("CountJobsByState", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var state string
+		var count int
+		if err := rows.Scan(&state, &count); err != nil {
+			return nil, err
+		}
+		counts[state] = count
+	}
+	return counts, rows.Err()
+}
+
+// DeleteFinishedJobs removes succeeded and cancelled jobs that finished before the cutoff.
+// Dead jobs are kept until an admin retries or inspects them.
+func (d *Database) DeleteFinishedJobs(before time.Time) (int64, error) {
+	start := time.Now()
+	res, err := d.db.Exec(`
+		DELETE FROM jobs WHERE state IN ('succeeded', 'cancelled') AND finished_at < ?
+	`, before.UTC())
+	d.observe("DeleteFinishedJobs", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Batch Operations
+
+const batchJobColumns = `id, operation, manifest, params, status, processed, succeeded, failed, cursor, rate_per_second,
+	report_bucket, report_prefix, report_key, error, created_by, created_at, started_at, finished_at`
+
+func scanBatchJob(row rowScanner) (*BatchJobRow, error) {
+	var b BatchJobRow
+	err := row.Scan(&b.ID, &b.Operation, &b.Manifest, &b.Params, &b.Status, &b.Processed, &b.Succeeded, &b.Failed,
+		&b.Cursor, &b.RatePerSecond, &b.ReportBucket, &b.ReportPrefix, &b.ReportKey, &b.Error, &b.CreatedBy,
+		&b.CreatedAt, &b.StartedAt, &b.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (d *Database) CreateBatchJob(b *BatchJobRow) (int64, error) {
+	start := time.Now()
+	res, err := d.db.Exec(`
+		INSERT INTO batch_jobs (operation, manifest, params, status, rate_per_second, report_bucket, report_prefix, created_by, created_at)
+		VALUES (?, ?, ?, 'pending', ?, ?, ?, ?, ?)
+	`, b.Operation, b.Manifest, b.Params, b.RatePerSecond, b.ReportBucket, b.ReportPrefix, b.CreatedBy, time.Now().UTC())
+	d.observe("CreateBatchJob", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (d *Database) GetBatchJob(id int64) (*BatchJobRow, error) {
+	start := time.Now()
+	b, err := scanBatchJob(d.db.QueryRow(`SELECT `+batchJobColumns+` FROM batch_jobs WHERE id = ?`, id))
+	d.observe("GetBatchJob", start, err)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return b, err
+}
+
+// ListBatchJobs returns the most recent batch jobs, optionally only those with the given status
+func (d *Database) ListBatchJobs(status string, limit int) ([]*BatchJobRow, error) {
+	start := time.Now()
+	query := `SELECT ` + batchJobColumns + ` FROM batch_jobs`
+	args := []interface{}{}
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := d.db.Query(query, args...)
+	d.observe("ListBatchJobs", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*BatchJobRow
+	for rows.Next() {
+		b, err := scanBatchJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, b)
+	}
+	return results, rows.Err()
+}
+
+// StartBatchJob marks a pending or interrupted batch job as running. It returns false when
+// the job was cancelled or has already finished.
+func (d *Database) StartBatchJob(id int64) (bool, error) {
+	start := time.Now()
+	res, err := d.db.Exec(`
+		UPDATE batch_jobs SET status = 'running', started_at = COALESCE(started_at, ?)
+		WHERE id = ? AND status IN ('pending', 'running')
+	`, time.Now().UTC(), id)
+	d.observe("StartBatchJob", start, err)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// UpdateBatchJobProgress stores the counters and the manifest position of a running job
+func (d *Database) UpdateBatchJobProgress(id int64, processed, succeeded, failed int64, cursor string) error {
+	start := time.Now()
+	_, err := d.db.Exec(`
+		UPDATE batch_jobs SET processed = ?, succeeded = ?, failed = ?, cursor = ? WHERE id = ?
+	`, processed, succeeded, failed, cursor, id)
+	d.observe("UpdateBatchJobProgress", start, err)
+	return err
+}
+
+// FinishBatchJob records the final state of a batch job. A cancelled job keeps its status.
+func (d *Database) FinishBatchJob(id int64, status string, reportKey, lastError *string) error {
+	start := time.Now()
+	_, err := d.db.Exec(`
+		UPDATE batch_jobs SET status = CASE WHEN status = 'cancelled' THEN status ELSE ? END,
+			report_key = ?, error = ?, finished_at = ?
+		WHERE id = ?
+	`, status, reportKey, lastError, time.Now().UTC(), id)
+	d.observe("FinishBatchJob", start, err)
+	return err
+}
+
+// CancelBatchJob stops a pending or running batch job after the object it is working on
+func (d *Database) CancelBatchJob(id int64) (bool, error) {
+	start := time.Now()
+	res, err := d.db.Exec(`
+		UPDATE batch_jobs SET status = 'cancelled' WHERE id = ? AND status IN ('pending', 'running')
+	`, id)
+	d.observe("CancelBatchJob", start, err)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// RecordBatchJobResult stores the outcome for one object; a retried object overwrites its earlier result
+func (d *Database) RecordBatchJobResult(r *BatchJobResultRow) error {
+	start := time.Now()
+	_, err := d.db.Exec(`
+		INSERT INTO batch_job_results (batch_id, bucket, key, version_id, status, error, processed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(batch_id, bucket, key, version_id) DO UPDATE SET
+			status = excluded.status, error = excluded.error, processed_at = excluded.processed_at
+	`, r.BatchID, r.Bucket, r.Key, r.VersionID, r.Status, r.Error, time.Now().UTC())
+	d.observe("RecordBatchJobResult", start, err)
+	return err
+}
+
+// HasBatchJobResult reports whether an object was already processed by a batch job
+func (d *Database) HasBatchJobResult(batchID int64, bucket, key, versionID string) (bool, error) {
+	start := time.Now()
+	var count int
+	err := d.db.QueryRow(`
+		SELECT COUNT(*) FROM batch_job_results WHERE batch_id = ? AND bucket = ? AND key = ? AND version_id = ?
+	`, batchID, bucket, key, versionID).Scan(&count)
+	d.observe("HasBatchJobResult", start, err)
+	return count > 0, err
+}
+
+// CountBatchJobResults returns the number of results of a batch job by status
+func (d *Database) CountBatchJobResults(batchID int64) (map[string]int64, error) {
+	start := time.Now()
+	rows, err := d.db.Query(`SELECT status, COUNT(*) FROM batch_job_results WHERE batch_id = ? GROUP BY status`, batchID)
+	d.observe("CountBatchJobResults", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var status string
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+// ListBatchJobResults pages through the results of a batch job in processing order.
+// afterRowID is the rowid of the last result of the previous page.
+func (d *Database) ListBatchJobResults(batchID int64, status string, afterRowID int64, limit int) ([]*BatchJobResultRow, int64, error) {
+	start := time.Now()
+	query := `SELECT rowid, batch_id, bucket, key, version_id, status, error, processed_at
+	          FROM batch_job_results WHERE batch_id = ? AND rowid > ?`
+	args := []interface{}{batchID, afterRowID}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY rowid LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := d.db.Query(query, args...)
+	d.observe("ListBatchJobResults", start, err)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var results []*BatchJobResultRow
+	lastRowID := afterRowID
+	for rows.Next() {
+		var r BatchJobResultRow
+		if err := rows.Scan(&lastRowID, &r.BatchID, &r.Bucket, &r.Key, &r.VersionID, &r.Status, &r.Error, &r.ProcessedAt); err != nil {
+			return nil, 0, err
+		}
+		results = append(results, &r)
+	}
+	return results, lastRowID, rows.Err()
+}
+
+// ListTrashObjectsAfter pages through the trash of a bucket in deletion-row order.
+// afterID is the ID of the last object of the previous page.
+func (d *Database) ListTrashObjectsAfter(bucket, prefix string, afterID int64, limit int) ([]*ObjectRow, error) {
+	start := time.Now()
+	query := `SELECT ` + objectColumns + `
+	          FROM objects WHERE bucket = ? AND deleted_at IS NOT NULL AND id > ?`
+	args := []interface{}{bucket, afterID}
+
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" {
+		query += " AND key LIKE ?"
+		args = append(args, prefix+"%")
+	}
+	query += " ORDER BY id LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := d.db.Query(query, args...)
+	d.observe("ListTrashObjectsAfter", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []*ObjectRow
+	for rows.Next() {
+		obj, err := scanObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// Inventory Operations
+
+func (d *Database) PutBucketInventory(bucket, id, configJSON string) error {
+	start := time.Now()
+	_, err := d.db.Exec(`
+		INSERT INTO bucket_inventories (bucket, id, config) VALUES (?, ?, ?)
+		ON CONFLICT(bucket, id) DO UPDATE SET config = excluded.config
+	`, bucket, id, configJSON)
+	d.observe("PutBucketInventory", start, err)
+	return err
+}
+
+func (d *Database) GetBucketInventory(bucket, id string) (*InventoryRow, error) {
+	start := time.Now()
+	var r InventoryRow
+	err := d.db.QueryRow(`
+		SELECT bucket, id, config, last_run_at, last_manifest_key FROM bucket_inventories WHERE bucket = ? AND id = ?
+	`, bucket, id).Scan(&r.Bucket, &r.ID, &r.Config, &r.LastRunAt, &r.LastManifestKey)
+	d.observe("GetBucketInventory", start, err)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListBucketInventories returns the inventory configurations of a bucket, or of all buckets when bucket is empty
+func (d *Database) ListBucketInventories(bucket string) ([]InventoryRow, error) {
+	start := time.Now()
+	query := "SELECT bucket, id, config, last_run_at, last_manifest_key FROM bucket_inventories"
+	args := []interface{}{}
+	if bucket != "" {
+		query += " WHERE bucket = ?"
+		args = append(args, bucket)
+	}
+	query += " ORDER BY bucket, id"
+
+	rows, err := d.db.Query(query, args...)
+	d.observe("ListBucketInventories", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []InventoryRow
+	for rows.Next() {
+		var r InventoryRow
+		if err := rows.Scan(&r.Bucket, &r.ID, &r.Config, &r.LastRunAt, &r.LastManifestKey); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+func (d *Database) DeleteBucketInventory(bucket, id string) error {
+	start := time.Now()
+	_, err := d.db.Exec("DELETE FROM bucket_inventories WHERE bucket = ? AND id = ?", bucket, id)
+	d.observe("DeleteBucketInventory", start, err)
+	return err
+}
+
+// SetInventoryLastRun records when a report was last written and where its manifest is
+func (d *Database) SetInventoryLastRun(bucket, id string, runAt time.Time, manifestKey string) error {
+	start := time.Now()
+	_, err := d.db.Exec(`
+		UPDATE bucket_inventories SET last_run_at = ?, last_manifest_key = ? WHERE bucket = ? AND id = ?
+	`, runAt.UTC(), manifestKey, bucket, id)
+	d.observe("SetInventoryLastRun", start, err)
+	return err
+}
+
+// ForEachObject streams the objects of a bucket in key order without loading them into memory.
+// Only current versions are visited unless allVersions is set; deleted objects and folders are skipped.
+func (d *Database) ForEachObject(bucket, prefix string, allVersions bool, fn func(*ObjectRow) error) (err error) {
+	start := time.Now()
+	query := `SELECT ` + objectColumns + `
+	          FROM objects WHERE bucket = ? AND deleted_at IS NULL AND version_id != 'folder'`
+	args := []interface{}{bucket}
+	if !allVersions {
+		query += " AND is_latest = TRUE"
+	}
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" {
+		query += ` AND key LIKE ? ESCAPE '\'`
+		args = append(args, likeEscaper.Replace(prefix)+"%")
+	}
+	query += " ORDER BY key, modified_at DESC"
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	defer func() { d.observe("ForEachObject", start, err) }()
+
+	for rows.Next() {
+		obj, err := scanObject(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,349 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SchemaChange is a table, or a column of an existing table, that brings a database up to date
+type SchemaChange struct {
+	Table  string `json:"table"`
+	Column string `json:"column,omitempty"`
+}
+
+var createTable = regexp.MustCompile(`CREATE TABLE IF NOT EXISTS (\w+)`)
+
+// recordMissingTables records the tables of schema that do not exist yet
+func (d *Database) recordMissingTables(schema string) error {
+	existing, err := d.TableNames()
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		have[name] = true
+	}
+	for _, m := range createTable.FindAllStringSubmatch(schema, -1) {
+		if !have[m[1]] {
+			d.schemaChanges = append(d.schemaChanges, SchemaChange{Table: m[1]})
+		}
+	}
+	return nil
+}
+
+// Migrate brings the schema up to date and returns the tables and columns it added
+func (d *Database) Migrate() ([]SchemaChange, error) {
+	d.schemaChanges = nil
+	err := d.initSchema()
+	return d.schemaChanges, err
+}
+
+// PendingMigrations returns the tables and columns Migrate would add, without changing anything
+func (d *Database) PendingMigrations() ([]SchemaChange, error) {
+	d.schemaChanges = nil
+	d.dryRun = true
+	defer func() { d.dryRun = false }()
+	err := d.initSchema()
+	return d.schemaChanges, err
+}
+
+// TableNames returns the application tables in creation order, so parents come before the
+// tables that reference them
+func (d *Database) TableNames() ([]string, error) {
+	rows, err := d.db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name NOT LIKE '_litestream%' ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// TableRowCounts returns the number of rows of every table
+func (d *Database) TableRowCounts() (map[string]int64, error) {
+	names, err := d.TableNames()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(names))
+	for _, name := range names {
+		var n int64
+		if err := d.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %q", name)).Scan(&n); err != nil {
+			return nil, fmt.Errorf("count %s: %w", name, err)
+		}
+		counts[name] = n
+	}
+	return counts, nil
+}
+
+// CountBucketObjects returns the number of object versions of a bucket, including deleted ones
+// still in the trash
+func (d *Database) CountBucketObjects(bucket string) (int64, error) {
+	start := time.Now()
+	var n int64
+	err := d.db.QueryRow("SELECT COUNT(*) FROM objects WHERE bucket = ? AND version_id != 'folder'", bucket).Scan(&n)
+	d.observe("CountBucketObjects", start, err)
+	return n, err
+}
+
+// ListBucketContentHashes returns the distinct content hashes of the object versions of a bucket
+func (d *Database) ListBucketContentHashes(bucket string) (_ []string, err error) {
+	start := time.Now()
+	defer func() { d.observe("ListBucketContentHashes", start, err) }()
+	rows, err := d.db.Query("SELECT DISTINCT content_hash FROM objects WHERE bucket = ? AND content_hash IS NOT NULL AND content_hash != ''", bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// ExportFormat identifies metadata exports written by Export
+const ExportFormat = "gravspace-metadata"
+
+// ExportHeader is the first line of an export. Each table follows as a TableHeader line and
+// one JSON array of column values per row.
+type ExportHeader struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// TableHeader starts the rows of a table in an export
+type TableHeader struct {
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+	Rows    int64    `json:"rows"`
+}
+
+// Export writes every table of the database to w as JSON lines and returns the number of rows
+// written per table
+func (d *Database) Export(w io.Writer) (map[string]int64, error) {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(ExportHeader{Format: ExportFormat, Version: 1, ExportedAt: time.Now().UTC()}); err != nil {
+		return nil, err
+	}
+	counts, err := d.TableRowCounts()
+	if err != nil {
+		return nil, err
+	}
+	names, err := d.TableNames()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, table := range names {
+		rows, err := d.db.Query(fmt.Sprintf("SELECT * FROM %q", table))
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", table, err)
+		}
+		columns, err := rows.Columns()
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := enc.Encode(TableHeader{Table: table, Columns: columns, Rows: counts[table]}); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		values := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		var written int64
+		for rows.Next() && written < counts[table] {
+			if err := rows.Scan(ptrs...); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("export %s: %w", table, err)
+			}
+			for i, v := range values {
+				switch v := v.(type) {
+				case []byte:
+					values[i] = string(v)
+				case time.Time:
+					values[i] = v.UTC().Format("2006-01-02 15:04:05.999999999")
+				}
+			}
+			if err := enc.Encode(values); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			written++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", table, err)
+		}
+		counts[table] = written
+	}
+	return counts, nil
+}
+
+// Import loads an export written by Export. Tables are imported in a transaction each; with
+// replace their current rows are removed first, otherwise rows that already exist are an
+// error. Columns the schema no longer has are skipped and reported. It returns the number of
+// rows imported per table.
+func (d *Database) Import(r io.Reader, replace bool) (map[string]int64, []string, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var header ExportHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, nil, fmt.Errorf("read export header: %w", err)
+	}
+	if header.Format != ExportFormat || header.Version != 1 {
+		return nil, nil, fmt.Errorf("not a metadata export (format %q, version %d)", header.Format, header.Version)
+	}
+	existing, err := d.TableNames()
+	if err != nil {
+		return nil, nil, err
+	}
+	known := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		known[name] = true
+	}
+
+	imported := make(map[string]int64)
+	var skipped []string
+	for {
+		var table TableHeader
+		if err := dec.Decode(&table); err == io.EOF {
+			break
+		} else if err != nil {
+			return imported, skipped, fmt.Errorf("read table header: %w", err)
+		}
+		n, skippedColumns, err := d.importTable(dec, table, known[table.Table], replace)
+		if err != nil {
+			return imported, skipped, fmt.Errorf("import %s: %w", table.Table, err)
+		}
+		if !known[table.Table] {
+			skipped = append(skipped, table.Table)
+			continue
+		}
+		for _, column := range skippedColumns {
+			skipped = append(skipped, table.Table+"."+column)
+		}
+		imported[table.Table] = n
+	}
+	return imported, skipped, nil
+}
+
+// importTable reads the rows of one table. Rows of tables the database does not have are read
+// and dropped.
+func (d *Database) importTable(dec *json.Decoder, table TableHeader, exists, replace bool) (int64, []string, error) {
+	if !exists {
+		for i := int64(0); i < table.Rows; i++ {
+			var row []json.RawMessage
+			if err := dec.Decode(&row); err != nil {
+				return 0, nil, err
+			}
+		}
+		return 0, nil, nil
+	}
+
+	columns, err := d.tableColumns(table.Table)
+	if err != nil {
+		return 0, nil, err
+	}
+	var keep []int
+	var names, skipped []string
+	for i, column := range table.Columns {
+		if columns[column] {
+			keep = append(keep, i)
+			names = append(names, fmt.Sprintf("%q", column))
+		} else {
+			skipped = append(skipped, column)
+		}
+	}
+	insert := fmt.Sprintf("INSERT INTO %q (%s) VALUES (%s)", table.Table, strings.Join(names, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "))
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+	if replace {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %q", table.Table)); err != nil {
+			return 0, nil, err
+		}
+	}
+	stmt, err := tx.Prepare(insert)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer stmt.Close()
+
+	var n int64
+	for ; n < table.Rows; n++ {
+		var row []any
+		if err := dec.Decode(&row); err != nil {
+			return 0, nil, fmt.Errorf("row %d: %w", n+1, err)
+		}
+		if len(row) != len(table.Columns) {
+			return 0, nil, fmt.Errorf("row %d has %d values for %d columns", n+1, len(row), len(table.Columns))
+		}
+		args := make([]any, len(keep))
+		for i, col := range keep {
+			args[i] = importValue(row[col])
+		}
+		if _, err := stmt.Exec(args...); err != nil {
+			return 0, nil, fmt.Errorf("row %d: %w", n+1, err)
+		}
+	}
+	return n, skipped, tx.Commit()
+}
+
+// importValue turns a decoded JSON value back into the type it was exported from
+func importValue(v any) any {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+func (d *Database) tableColumns(table string) (map[string]bool, error) {
+	rows, err := d.db.Query(fmt.Sprintf("SELECT * FROM %q LIMIT 0", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]bool, len(names))
+	for _, name := range names {
+		columns[name] = true
+	}
+	return columns, nil
+}
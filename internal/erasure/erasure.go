@@ -0,0 +1,270 @@
+// Package erasure implements a systematic Reed-Solomon erasure code over GF(2^8).
+//
+// A Codec turns DataShards equally sized data shards into ParityShards parity shards, such
+// that any DataShards of the resulting shards are enough to recover all of them.
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxShards is the largest total number of shards a codec supports
+const MaxShards = 256
+
+var (
+	// ErrTooFewShards is returned when fewer than DataShards shards survive
+	ErrTooFewShards = errors.New("erasure: too few shards to reconstruct")
+	// ErrShardSize is returned when the shards are not all the same length
+	ErrShardSize = errors.New("erasure: shards differ in size")
+)
+
+// GF(2^8) with the polynomial x^8 + x^4 + x^3 + x^2 + 1
+var (
+	expTable [510]byte
+	logTable [256]byte
+	mulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			mulTable[a][b] = expTable[int(logTable[a])+int(logTable[b])]
+		}
+	}
+}
+
+func inverse(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+// power returns a^n
+func power(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])*n%255]
+}
+
+// Codec encodes and reconstructs shards for a fixed data and parity shard count. It is safe
+// for concurrent use.
+type Codec struct {
+	dataShards   int
+	parityShards int
+	// matrix maps the data shards to every shard; its top rows are the identity
+	matrix [][]byte
+}
+
+// New returns a codec for dataShards data and parityShards parity shards
+func New(dataShards, parityShards int) (*Codec, error) {
+	if dataShards < 1 || parityShards < 1 {
+		return nil, fmt.Errorf("erasure: need at least one data and one parity shard, got %d+%d", dataShards, parityShards)
+	}
+	if dataShards+parityShards > MaxShards {
+		return nil, fmt.Errorf("erasure: %d+%d shards exceed the maximum of %d", dataShards, parityShards, MaxShards)
+	}
+
+	// Any dataShards rows of a Vandermonde matrix are independent; multiplying by the inverse
+	// of its top square keeps that property and makes the code systematic.
+	total := dataShards + parityShards
+	vandermonde := make([][]byte, total)
+	for r := range vandermonde {
+		vandermonde[r] = make([]byte, dataShards)
+		for c := range vandermonde[r] {
+			vandermonde[r][c] = power(byte(r), c)
+		}
+	}
+	top, err := invert(vandermonde[:dataShards])
+	if err != nil {
+		return nil, err
+	}
+	return &Codec{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		matrix:       multiply(vandermonde, top),
+	}, nil
+}
+
+// DataShards returns the number of data shards
+func (c *Codec) DataShards() int { return c.dataShards }
+
+// ParityShards returns the number of parity shards
+func (c *Codec) ParityShards() int { return c.parityShards }
+
+// Shards returns the total number of shards
+func (c *Codec) Shards() int { return c.dataShards + c.parityShards }
+
+// Encode computes the parity shards from the data shards. shards holds every shard, data
+// first; the parity shards are allocated when nil.
+func (c *Codec) Encode(shards [][]byte) error {
+	if len(shards) != c.Shards() {
+		return fmt.Errorf("erasure: got %d shards, want %d", len(shards), c.Shards())
+	}
+	size := len(shards[0])
+	for _, shard := range shards[:c.dataShards] {
+		if len(shard) != size {
+			return ErrShardSize
+		}
+	}
+	for i := c.dataShards; i < len(shards); i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+		} else if len(shards[i]) != size {
+			return ErrShardSize
+		}
+		c.combine(c.matrix[i], shards[:c.dataShards], shards[i])
+	}
+	return nil
+}
+
+// Reconstruct recomputes the missing shards, those that are nil, from the others
+func (c *Codec) Reconstruct(shards [][]byte) error {
+	if err := c.ReconstructData(shards); err != nil {
+		return err
+	}
+	for i := c.dataShards; i < len(shards); i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, len(shards[0]))
+			c.combine(c.matrix[i], shards[:c.dataShards], shards[i])
+		}
+	}
+	return nil
+}
+
+// ReconstructData recomputes the missing data shards only, which is all a read needs
+func (c *Codec) ReconstructData(shards [][]byte) error {
+	if len(shards) != c.Shards() {
+		return fmt.Errorf("erasure: got %d shards, want %d", len(shards), c.Shards())
+	}
+	size := -1
+	present := make([]int, 0, c.dataShards)
+	for i, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		if size == -1 {
+			size = len(shard)
+		} else if len(shard) != size {
+			return ErrShardSize
+		}
+		if len(present) < c.dataShards {
+			present = append(present, i)
+		}
+	}
+	if len(present) < c.dataShards {
+		return ErrTooFewShards
+	}
+
+	// Recover the missing data shards from the first dataShards surviving ones
+	missingData := false
+	for _, shard := range shards[:c.dataShards] {
+		if shard == nil {
+			missingData = true
+			break
+		}
+	}
+	if missingData {
+		rows := make([][]byte, c.dataShards)
+		inputs := make([][]byte, c.dataShards)
+		for i, index := range present {
+			rows[i] = c.matrix[index]
+			inputs[i] = shards[index]
+		}
+		decode, err := invert(rows)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < c.dataShards; i++ {
+			if shards[i] == nil {
+				shards[i] = make([]byte, size)
+				c.combine(decode[i], inputs, shards[i])
+			}
+		}
+	}
+	return nil
+}
+
+// combine sets out to the linear combination of inputs with the given coefficients
+func (c *Codec) combine(coefficients []byte, inputs [][]byte, out []byte) {
+	clear(out)
+	for j, input := range inputs {
+		coefficient := coefficients[j]
+		if coefficient == 0 {
+			continue
+		}
+		row := &mulTable[coefficient]
+		for k, b := range input {
+			out[k] ^= row[b]
+		}
+	}
+}
+
+// multiply returns a × b
+func multiply(a, b [][]byte) [][]byte {
+	out := make([][]byte, len(a))
+	for r := range a {
+		out[r] = make([]byte, len(b[0]))
+		for c := range out[r] {
+			var v byte
+			for k := range b {
+				v ^= mulTable[a[r][k]][b[k][c]]
+			}
+			out[r][c] = v
+		}
+	}
+	return out
+}
+
+// invert returns the inverse of a square matrix by Gauss-Jordan elimination
+func invert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	work := make([][]byte, n)
+	for r := range m {
+		work[r] = make([]byte, 2*n)
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("erasure: singular matrix")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		if scale := work[col][col]; scale != 1 {
+			row := &mulTable[inverse(scale)]
+			for k := range work[col] {
+				work[col][k] = row[work[col][k]]
+			}
+		}
+		for r := 0; r < n; r++ {
+			if r == col || work[r][col] == 0 {
+				continue
+			}
+			row := &mulTable[work[r][col]]
+			for k := range work[r] {
+				work[r][k] ^= row[work[col][k]]
+			}
+		}
+	}
+	out := make([][]byte, n)
+	for r := range work {
+		out[r] = work[r][n:]
+	}
+	return out, nil
+}
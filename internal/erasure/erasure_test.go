@@ -0,0 +1,58 @@
+package erasure
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestReconstructAnyLoss(t *testing.T) {
+	codec, err := New(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	shards := make([][]byte, codec.Shards())
+	for i := 0; i < codec.DataShards(); i++ {
+		shards[i] = make([]byte, 1000)
+		rng.Read(shards[i])
+	}
+	if err := codec.Encode(shards); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every combination of up to two lost shards is recovered
+	for a := 0; a < codec.Shards(); a++ {
+		for b := a; b < codec.Shards(); b++ {
+			damaged := make([][]byte, len(shards))
+			copy(damaged, shards)
+			damaged[a], damaged[b] = nil, nil
+			if err := codec.Reconstruct(damaged); err != nil {
+				t.Fatalf("losing %d and %d: %v", a, b, err)
+			}
+			for i := range shards {
+				if !bytes.Equal(damaged[i], shards[i]) {
+					t.Fatalf("losing %d and %d: shard %d differs", a, b, i)
+				}
+			}
+		}
+	}
+
+	damaged := make([][]byte, len(shards))
+	copy(damaged, shards)
+	damaged[0], damaged[3], damaged[5] = nil, nil, nil
+	if err := codec.Reconstruct(damaged); err != ErrTooFewShards {
+		t.Errorf("losing three shards = %v", err)
+	}
+}
+
+func TestNewLimits(t *testing.T) {
+	for _, counts := range [][2]int{{0, 2}, {4, 0}, {200, 57}} {
+		if _, err := New(counts[0], counts[1]); err == nil {
+			t.Errorf("New(%d, %d) succeeded", counts[0], counts[1])
+		}
+	}
+	if _, err := New(17, 3); err != nil {
+		t.Errorf("New(17, 3) = %v", err)
+	}
+}
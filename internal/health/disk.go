@@ -0,0 +1,190 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DiskUsage is the free space and inodes of the filesystem holding a path
+type DiskUsage struct {
+	Path              string  `json:"path"`
+	TotalBytes        uint64  `json:"total_bytes"`
+	FreeBytes         uint64  `json:"free_bytes"`
+	FreePercent       float64 `json:"free_percent"`
+	TotalInodes       uint64  `json:"total_inodes,omitempty"`
+	FreeInodes        uint64  `json:"free_inodes,omitempty"`
+	FreeInodesPercent float64 `json:"free_inodes_percent,omitempty"`
+}
+
+// lowest returns the smaller of the free space and free inode percentages. Filesystems that do
+// not report inodes only count free space.
+func (u *DiskUsage) lowest() float64 {
+	if u.TotalInodes > 0 && u.FreeInodesPercent < u.FreePercent {
+		return u.FreeInodesPercent
+	}
+	return u.FreePercent
+}
+
+// Default free space thresholds, in percent of the filesystem
+const (
+	DefaultDiskWarnPercent     = 10
+	DefaultDiskCriticalPercent = 5
+)
+
+const diskSampleInterval = 10 * time.Second
+
+// DiskMonitor samples the free space of the data root and the other data drives. The disk
+// counts as full, and writes should be rejected until space is freed, while free space or free
+// inodes of the data root are below the critical threshold, or fewer drives than the number of
+// copies kept of each blob are above it.
+type DiskMonitor struct {
+	// Paths are the data drives, the data root first
+	Paths           []string
+	Copies          int
+	WarnPercent     float64
+	CriticalPercent float64
+
+	mu     sync.RWMutex
+	last   *DiskUsage
+	drives []DiskUsage
+	full   atomic.Bool
+
+	quit      chan struct{}
+	waitGroup sync.WaitGroup
+	stopOnce  sync.Once
+}
+
+// NewDiskMonitor monitors the data drives in paths, the data root first, where each write
+// needs room on copies of them
+func NewDiskMonitor(paths []string, copies int, warnPercent, criticalPercent float64) *DiskMonitor {
+	return &DiskMonitor{
+		Paths:           paths,
+		Copies:          max(copies, 1),
+		WarnPercent:     warnPercent,
+		CriticalPercent: criticalPercent,
+		quit:            make(chan struct{}),
+	}
+}
+
+// Start samples the disk now and then every 10 seconds until Stop is called
+func (m *DiskMonitor) Start() {
+	if _, err := m.Refresh(); err != nil {
+		log.Printf("Disk monitor: %v", err)
+	}
+	m.waitGroup.Add(1)
+	go func() {
+		defer m.waitGroup.Done()
+		ticker := time.NewTicker(diskSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.quit:
+				return
+			case <-ticker.C:
+				m.Refresh()
+			}
+		}
+	}()
+}
+
+func (m *DiskMonitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.quit)
+		m.waitGroup.Wait()
+	})
+}
+
+// Refresh samples the drives and updates whether the disk is full. It returns the sample of
+// the data root. A drive that cannot be sampled has no room.
+func (m *DiskMonitor) Refresh() (*DiskUsage, error) {
+	u, err := Usage(m.Paths[0])
+	if err != nil {
+		return nil, err
+	}
+	drives := []DiskUsage{*u}
+	for _, path := range m.Paths[1:] {
+		if d, err := Usage(path); err == nil {
+			drives = append(drives, *d)
+		}
+	}
+	m.mu.Lock()
+	m.last = u
+	m.drives = drives
+	m.mu.Unlock()
+
+	rootFull := u.lowest() < m.CriticalPercent
+	roomy := m.roomy(drives)
+	full := rootFull || roomy < m.Copies
+	if m.full.Swap(full) != full {
+		switch {
+		case rootFull:
+			log.Printf("Disk %s is below %.1f%% free (%.1f%% space, %.1f%% inodes), rejecting writes", u.Path, m.CriticalPercent, u.FreePercent, u.FreeInodesPercent)
+		case full:
+			log.Printf("Only %d of %d data drives are above %.1f%% free and %d copies are kept, rejecting writes", roomy, len(m.Paths), m.CriticalPercent, m.Copies)
+		default:
+			log.Printf("Disk %s is above %.1f%% free again, accepting writes", u.Path, m.CriticalPercent)
+		}
+	}
+	return u, nil
+}
+
+// roomy counts the drives above the critical threshold
+func (m *DiskMonitor) roomy(drives []DiskUsage) int {
+	n := 0
+	for _, d := range drives {
+		if d.lowest() >= m.CriticalPercent {
+			n++
+		}
+	}
+	return n
+}
+
+// Last returns the most recent sample, or nil before the first one
+func (m *DiskMonitor) Last() *DiskUsage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.last
+}
+
+// Drives returns the most recent sample of every data drive that could be sampled
+func (m *DiskMonitor) Drives() []DiskUsage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.drives
+}
+
+// Full reports whether the data root or too many drives were below the critical threshold at the
+// last sample.
+// It is safe to call on a nil monitor.
+func (m *DiskMonitor) Full() bool {
+	return m != nil && m.full.Load()
+}
+
+// Check is the readiness check for the disk. A disk below the warning or critical threshold is
+// reported as a warning: reads keep working while writes are rejected.
+func (m *DiskMonitor) Check(ctx context.Context) error {
+	u, err := m.Refresh()
+	if err != nil {
+		return err
+	}
+	free := fmt.Sprintf("%.1f%% space free", u.FreePercent)
+	if u.TotalInodes > 0 {
+		free += fmt.Sprintf(", %.1f%% inodes free", u.FreeInodesPercent)
+	}
+	switch lowest := u.lowest(); {
+	case lowest < m.CriticalPercent:
+		return Warn("critical: " + free + ", writes are rejected")
+	case m.Full():
+		m.mu.RLock()
+		roomy := m.roomy(m.drives)
+		m.mu.RUnlock()
+		return Warn(fmt.Sprintf("critical: %d of %d data drives above the threshold, %d copies are kept, writes are rejected", roomy, len(m.Paths), m.Copies))
+	case lowest < m.WarnPercent:
+		return Warn("low: " + free)
+	}
+	return nil
+}
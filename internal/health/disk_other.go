@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+
+package health
+
+import "os"
+
+// Usage only checks that path exists on platforms without statfs and reports the disk as free
+func Usage(path string) (*DiskUsage, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return &DiskUsage{Path: path, FreePercent: 100}, nil
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd
+
+package health
+
+import "syscall"
+
+// Usage returns the free space and inodes of the filesystem holding path
+func Usage(path string) (*DiskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	u := &DiskUsage{
+		Path:        path,
+		TotalBytes:  uint64(st.Blocks) * uint64(st.Bsize),
+		FreeBytes:   uint64(st.Bavail) * uint64(st.Bsize),
+		TotalInodes: uint64(st.Files),
+		FreeInodes:  uint64(st.Ffree),
+	}
+	if u.TotalBytes > 0 {
+		u.FreePercent = float64(u.FreeBytes) / float64(u.TotalBytes) * 100
+	}
+	if u.TotalInodes > 0 {
+		u.FreeInodesPercent = float64(u.FreeInodes) / float64(u.TotalInodes) * 100
+	}
+	return u, nil
+}
@@ -1,29 +1,85 @@
 package health
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Check statuses
+const (
+	StatusUp   = "UP"
+	StatusWarn = "WARN"
+	StatusDown = "DOWN"
+)
+
+// checkTimeout bounds each readiness check, so a hung dependency cannot hang the probe
+const checkTimeout = 2 * time.Second
+
 type HealthStatus struct {
 	Status    string            `json:"status"`
 	Timestamp time.Time         `json:"timestamp"`
 	Checks    map[string]string `json:"checks,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	Disk      *DiskUsage        `json:"disk,omitempty"`
+	Drives    []DiskUsage       `json:"drives,omitempty"`
+}
+
+// CheckFunc checks a dependency. An error fails the check; errors made with Warn only degrade it.
+type CheckFunc func(ctx context.Context) error
+
+type warning struct{ msg string }
+
+func (w *warning) Error() string { return w.msg }
+
+// Warn returns an error that reports a check as degraded rather than failed
+func Warn(msg string) error {
+	return &warning{msg: msg}
+}
+
+type check struct {
+	name     string
+	critical bool
+	fn       CheckFunc
 }
 
 type HealthChecker struct {
 	StartTime time.Time
+
+	// DataRoot is the directory that must be writable for the server to be ready
+	DataRoot string
+	// Disk, when set, reports free space and inodes of the data drives
+	Disk *DiskMonitor
+	// HeartbeatTimeout is how long a worker may go without a heartbeat
+	HeartbeatTimeout time.Duration
+
+	mu     sync.RWMutex
+	checks []check
 }
 
 func NewHealthChecker() *HealthChecker {
 	return &HealthChecker{
-		StartTime: time.Now(),
+		StartTime:        time.Now(),
+		DataRoot:         "./data",
+		HeartbeatTimeout: DefaultHeartbeatTimeout,
 	}
 }
 
+// AddCheck registers a readiness check. When a critical check fails the server is not ready;
+// other failing checks only degrade readiness.
+func (h *HealthChecker) AddCheck(name string, critical bool, fn CheckFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, check{name: name, critical: critical, fn: fn})
+}
+
 // Liveness probe - indicates if the application is running
 func (h *HealthChecker) LivenessHandler(c *gin.Context) {
 	status := HealthStatus{
@@ -33,36 +89,82 @@ func (h *HealthChecker) LivenessHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, status)
 }
 
-// Readiness probe - indicates if the application is ready to serve traffic
+// Readiness probe - indicates if the application is ready to serve traffic. The server is DOWN
+// (503) when the data directory, the database or another critical dependency fails, and
+// DEGRADED (200) when only the disk is low or a background worker has stopped reporting.
 func (h *HealthChecker) ReadinessHandler(c *gin.Context) {
-	checks := make(map[string]string)
-	allHealthy := true
-
-	// Check disk space
-	diskStatus := h.checkDiskSpace()
-	checks["disk"] = diskStatus
-	if diskStatus != "UP" {
-		allHealthy = false
+	h.mu.RLock()
+	checks := append([]check{{name: "data_directory", critical: true, fn: h.checkDataDirectory}}, h.checks...)
+	h.mu.RUnlock()
+	if h.Disk != nil {
+		checks = append(checks, check{name: "disk", fn: h.Disk.Check})
+	}
+	names, beats := workerHeartbeats()
+	for _, name := range names {
+		checks = append(checks, check{name: "worker:" + name, fn: h.checkHeartbeat(beats[name])})
 	}
 
-	// Check if data directory is writable
-	dataStatus := h.checkDataDirectory()
-	checks["data_directory"] = dataStatus
-	if dataStatus != "UP" {
-		allHealthy = false
+	// Checks run concurrently, each with its own timeout
+	results := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, chk := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.Request.Context(), checkTimeout)
+			defer cancel()
+			results[i] = runCheck(ctx, chk.fn)
+		}()
 	}
+	wg.Wait()
 
 	status := HealthStatus{
+		Status:    "UP",
 		Timestamp: time.Now(),
-		Checks:    checks,
+		Checks:    make(map[string]string, len(checks)),
+		Details:   make(map[string]string),
+	}
+	for i, chk := range checks {
+		err := results[i]
+		var w *warning
+		switch {
+		case err == nil:
+			status.Checks[chk.name] = StatusUp
+			continue
+		case errors.As(err, &w) || !chk.critical:
+			status.Checks[chk.name] = StatusWarn
+			if status.Status == "UP" {
+				status.Status = "DEGRADED"
+			}
+		default:
+			status.Checks[chk.name] = StatusDown
+			status.Status = "DOWN"
+		}
+		status.Details[chk.name] = err.Error()
+	}
+	if h.Disk != nil {
+		status.Disk = h.Disk.Last()
+		if len(h.Disk.Paths) > 1 {
+			status.Drives = h.Disk.Drives()
+		}
 	}
 
-	if allHealthy {
-		status.Status = "UP"
-		c.JSON(http.StatusOK, status)
-	} else {
-		status.Status = "DOWN"
+	if status.Status == "DOWN" {
 		c.JSON(http.StatusServiceUnavailable, status)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// runCheck runs fn and gives up when ctx expires, even if fn ignores the context
+func runCheck(ctx context.Context, fn CheckFunc) error {
+	done := make(chan error, 1)
+	go func() { done <- fn(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %v", checkTimeout)
 	}
 }
 
@@ -83,22 +185,21 @@ func (h *HealthChecker) StartupHandler(c *gin.Context) {
 	})
 }
 
-func (h *HealthChecker) checkDiskSpace() string {
-	// Simple check: try to get file stats on data directory
-	_, err := os.Stat("./data")
-	if err != nil {
-		return "DOWN"
+func (h *HealthChecker) checkDataDirectory(ctx context.Context) error {
+	// Try to create a temp file to verify write access
+	testFile := filepath.Join(h.DataRoot, ".health_check")
+	if err := os.WriteFile(testFile, []byte("test"), 0644); err != nil {
+		return err
 	}
-	return "UP"
+	os.Remove(testFile)
+	return nil
 }
 
-func (h *HealthChecker) checkDataDirectory() string {
-	// Try to create a temp file to verify write access
-	testFile := "./data/.health_check"
-	err := os.WriteFile(testFile, []byte("test"), 0644)
-	if err != nil {
-		return "DOWN"
+func (h *HealthChecker) checkHeartbeat(hb *Heartbeat) CheckFunc {
+	return func(ctx context.Context) error {
+		if age := time.Since(hb.Last()); age > h.HeartbeatTimeout {
+			return fmt.Errorf("no heartbeat for %v", age.Round(time.Second))
+		}
+		return nil
 	}
-	os.Remove(testFile)
-	return "UP"
 }
@@ -0,0 +1,102 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func readiness(t *testing.T, h *HealthChecker) (int, HealthStatus) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/health/ready", h.ReadinessHandler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	var status HealthStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, status
+}
+
+func TestReadinessChecks(t *testing.T) {
+	h := NewHealthChecker()
+	h.DataRoot = t.TempDir()
+	h.Disk = NewDiskMonitor([]string{h.DataRoot}, 1, 0, 0)
+	h.AddCheck("database", true, func(context.Context) error { return nil })
+
+	if code, status := readiness(t, h); code != http.StatusOK || status.Status != "UP" || status.Disk == nil {
+		t.Fatalf("healthy: %d %+v", code, status)
+	}
+
+	// A stale worker or a non-critical dependency only degrades readiness
+	Worker("test-worker").last.Store(time.Now().Add(-time.Hour).UnixNano())
+	defer Worker("test-worker").Beat()
+	h.AddCheck("redis", false, func(context.Context) error { return errors.New("fallback") })
+	code, status := readiness(t, h)
+	if code != http.StatusOK || status.Status != "DEGRADED" {
+		t.Fatalf("degraded: %d %+v", code, status)
+	}
+	if status.Checks["worker:test-worker"] != StatusWarn || status.Checks["redis"] != StatusWarn {
+		t.Errorf("checks = %v", status.Checks)
+	}
+
+	// A critical dependency that hangs fails the probe once it times out
+	h.AddCheck("hung", true, func(ctx context.Context) error { select {} })
+	code, status = readiness(t, h)
+	if code != http.StatusServiceUnavailable || status.Checks["hung"] != StatusDown {
+		t.Fatalf("down: %d %+v", code, status)
+	}
+}
+
+func TestDiskMonitorFull(t *testing.T) {
+	m := NewDiskMonitor([]string{t.TempDir()}, 1, 100, 0)
+	if _, err := m.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Full() {
+		t.Fatal("disk reported full below a 0% threshold")
+	}
+	if err := m.Check(context.Background()); err == nil {
+		t.Error("expected a low disk warning at a 100% threshold")
+	}
+
+	m.CriticalPercent = 101
+	m.Refresh()
+	if !m.Full() {
+		t.Error("disk not reported full above a 100% threshold")
+	}
+}
+
+func TestDiskMonitorNeedsRoomForEveryCopy(t *testing.T) {
+	root, drive := t.TempDir(), t.TempDir()
+	missing := filepath.Join(root, "unmounted")
+
+	// Two copies fit on the root and the second drive
+	m := NewDiskMonitor([]string{root, drive, missing}, 2, 0, 0)
+	if _, err := m.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Full() || len(m.Drives()) != 2 {
+		t.Fatalf("full = %v with drives %+v", m.Full(), m.Drives())
+	}
+
+	// A third copy has nowhere to go while a drive cannot be read
+	m = NewDiskMonitor([]string{root, drive, missing}, 3, 0, 0)
+	m.Refresh()
+	if !m.Full() {
+		t.Error("disk not reported full without room for every copy")
+	}
+	if err := m.Check(context.Background()); err == nil || !strings.Contains(err.Error(), "2 of 3 data drives") {
+		t.Errorf("check = %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package health
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HeartbeatInterval is how often background workers report that they are alive
+const HeartbeatInterval = 30 * time.Second
+
+// DefaultHeartbeatTimeout is how long a worker may go without a heartbeat before readiness
+// reports it as stale
+const DefaultHeartbeatTimeout = 5 * time.Minute
+
+// Heartbeat records when a background worker last showed it was making progress
+type Heartbeat struct {
+	last atomic.Int64
+}
+
+// Beat records that the worker is alive. It is safe to call on a nil heartbeat.
+func (h *Heartbeat) Beat() {
+	if h != nil {
+		h.last.Store(time.Now().UnixNano())
+	}
+}
+
+// Last returns the time of the last beat
+func (h *Heartbeat) Last() time.Time {
+	return time.Unix(0, h.last.Load())
+}
+
+var workers = struct {
+	sync.Mutex
+	beats map[string]*Heartbeat
+}{beats: make(map[string]*Heartbeat)}
+
+// Worker returns the heartbeat of a background worker, registering it with readiness on first use
+func Worker(name string) *Heartbeat {
+	workers.Lock()
+	defer workers.Unlock()
+	h, ok := workers.beats[name]
+	if !ok {
+		h = &Heartbeat{}
+		workers.beats[name] = h
+	}
+	return h
+}
+
+// workerHeartbeats returns the registered workers, sorted by name
+func workerHeartbeats() ([]string, map[string]*Heartbeat) {
+	workers.Lock()
+	defer workers.Unlock()
+	names := make([]string, 0, len(workers.beats))
+	beats := make(map[string]*Heartbeat, len(workers.beats))
+	for name, h := range workers.beats {
+		names = append(names, name)
+		beats[name] = h
+	}
+	sort.Strings(names)
+	return names, beats
+}
@@ -1,70 +1,360 @@
 package jobs
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"os"
 	"sync"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/metrics"
 )
 
-// Job represents a background task
+// Job represents a background task. Jobs are persisted as JSON, so the exported fields of a
+// job make up its payload; dependencies such as the storage engine are set by its Factory.
 type Job interface {
 	Execute() error
 	Name() string
+	Type() string
+}
+
+// UniqueJob is implemented by jobs that must not be queued twice while one is still pending
+type UniqueJob interface {
+	UniqueKey() string
+}
+
+// DeadJob is implemented by jobs that need to react when they are given up on
+type DeadJob interface {
+	Dead(err error)
+}
+
+// Factory returns an empty job of a registered type, ready to have its payload decoded into it
+type Factory func() Job
+
+// Job states
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateDead      = "dead"
+	StateCancelled = "cancelled"
+)
+
+const (
+	defaultMaxAttempts = 5
+	baseBackoff        = 5 * time.Second
+	maxBackoff         = time.Hour
+	leaseTimeout       = 5 * time.Minute
+	pollInterval       = 2 * time.Second
+	leaseCheckInterval = time.Minute
+	finishedRetention  = 7 * 24 * time.Hour
+)
+
+type jobType struct {
+	factory     Factory
+	maxAttempts int
 }
 
-// Manager handles background job execution using a worker pool
+// Manager runs jobs from the persistent jobs table with a pool of workers. Workers lease a job
+// for a visibility timeout that is extended while it runs, so jobs of a crashed process are
+// picked up again. Failed jobs are retried with exponential backoff and marked dead after
+// their last attempt.
 type Manager struct {
-	jobChan     chan Job
+	db          *database.Database
 	workerCount int
-	waitGroup   sync.WaitGroup
-	quit        chan bool
+	owner       string
+
+	mu    sync.RWMutex
+	types map[string]jobType
+
+	wake      chan struct{}
+	waitGroup sync.WaitGroup
+	quit      chan bool
+	stopOnce  sync.Once
 }
 
-func NewManager(workerCount int) *Manager {
+func NewManager(db *database.Database, workerCount int) *Manager {
+	hostname, _ := os.Hostname()
 	return &Manager{
-		jobChan:     make(chan Job, 1000), // Buffer up to 1000 jobs
+		db:          db,
 		workerCount: workerCount,
+		owner:       fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		types:       make(map[string]jobType),
+		wake:        make(chan struct{}, 1),
 		quit:        make(chan bool),
 	}
 }
 
+// Register makes a job type runnable. maxAttempts <= 0 uses the default of 5.
+func (m *Manager) Register(name string, maxAttempts int, factory Factory) {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.types[name] = jobType{factory: factory, maxAttempts: maxAttempts}
+}
+
 func (m *Manager) Start() {
+	if m.db == nil {
+		log.Printf("Background Job Manager running without a database; jobs run immediately and are not retried")
+		return
+	}
 	for i := 0; i < m.workerCount; i++ {
 		m.waitGroup.Add(1)
 		go m.worker(i)
 	}
+	m.waitGroup.Add(1)
+	go m.janitor()
 	log.Printf("Background Job Manager started with %d workers", m.workerCount)
 }
 
+// Stop waits for running jobs to finish their current attempt
 func (m *Manager) Stop() {
-	close(m.quit)
-	close(m.jobChan)
-	m.waitGroup.Wait()
-	log.Println("Background Job Manager stopped")
+	m.stopOnce.Do(func() {
+		close(m.quit)
+		m.waitGroup.Wait()
+		log.Println("Background Job Manager stopped")
+	})
 }
 
-func (m *Manager) Enqueue(job Job) {
+// Enqueue persists a job and returns its ID. Unique jobs that are already pending are not queued again.
+func (m *Manager) Enqueue(job Job) (int64, error) {
+	m.mu.RLock()
+	t, ok := m.types[job.Type()]
+	m.mu.RUnlock()
+	if !ok {
+		metrics.JobsDroppedTotal.WithLabelValues(job.Type()).Inc()
+		return 0, fmt.Errorf("job type %q is not registered", job.Type())
+	}
+
+	if m.db == nil {
+		go func() {
+			if err := job.Execute(); err != nil {
+				log.Printf("Job %s failed: %v", job.Name(), err)
+			}
+		}()
+		return 0, nil
+	}
+
+	payload, err := json.Marshal(job)
+	if err != nil {
+		metrics.JobsDroppedTotal.WithLabelValues(job.Type()).Inc()
+		return 0, fmt.Errorf("failed to encode job %s: %w", job.Name(), err)
+	}
+	row := &database.JobRow{
+		Type:        job.Type(),
+		Name:        job.Name(),
+		Payload:     string(payload),
+		MaxAttempts: t.maxAttempts,
+	}
+	if u, ok := job.(UniqueJob); ok {
+		key := u.UniqueKey()
+		row.UniqueKey = &key
+	}
+
+	id, created, err := m.db.CreateJob(row)
+	if err != nil {
+		metrics.JobsDroppedTotal.WithLabelValues(job.Type()).Inc()
+		return 0, err
+	}
+	if created {
+		m.Wake()
+	}
+	return id, nil
+}
+
+// UpdateMetrics sets the job gauges from the jobs table. States without jobs are reported as 0.
+func (m *Manager) UpdateMetrics() {
+	if m.db == nil {
+		return
+	}
+	counts, err := m.db.CountJobsByState()
+	if err != nil {
+		log.Printf("Failed to count jobs: %v", err)
+		return
+	}
+	for _, state := range []string{StateQueued, StateRunning, StateSucceeded, StateDead, StateCancelled} {
+		metrics.Jobs.WithLabelValues(state).Set(float64(counts[state]))
+	}
+}
+
+// Wake lets an idle worker look for due jobs right away
+func (m *Manager) Wake() {
 	select {
-	case m.jobChan <- job:
-		// Job queued successfully
+	case m.wake <- struct{}{}:
 	default:
-		log.Printf("Warning: Job queue full, dropping job: %s", job.Name())
 	}
 }
 
+func (m *Manager) Get(id int64) (*database.JobRow, error) {
+	return m.db.GetJob(id)
+}
+
+func (m *Manager) List(state, jobType string, limit int) ([]*database.JobRow, error) {
+	return m.db.ListJobs(state, jobType, limit)
+}
+
+// Retry queues a finished job again
+func (m *Manager) Retry(id int64) (bool, error) {
+	ok, err := m.db.RetryJob(id)
+	if ok {
+		m.Wake()
+	}
+	return ok, err
+}
+
+func (m *Manager) Cancel(id int64) (bool, error) {
+	return m.db.CancelJob(id)
+}
+
 func (m *Manager) worker(id int) {
 	defer m.waitGroup.Done()
 	for {
 		select {
-		case job, ok := <-m.jobChan:
-			if !ok {
+		case <-m.quit:
+			return
+		default:
+		}
+
+		row, err := m.db.LeaseJob(m.owner, time.Now().Add(leaseTimeout))
+		if err != nil {
+			log.Printf("Worker %d: failed to lease job: %v", id, err)
+		}
+		if row != nil {
+			m.run(id, row)
+			continue
+		}
+
+		select {
+		case <-m.quit:
+			return
+		case <-m.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (m *Manager) run(workerID int, row *database.JobRow) {
+	m.mu.RLock()
+	t, ok := m.types[row.Type]
+	m.mu.RUnlock()
+	if !ok {
+		msg := fmt.Sprintf("unknown job type %q", row.Type)
+		m.db.FinishJob(row.ID, m.owner, StateDead, time.Now(), &msg)
+		metrics.RecordJob(row.Type, StateDead, 0)
+		return
+	}
+
+	job := t.factory()
+	if err := json.Unmarshal([]byte(row.Payload), job); err != nil {
+		msg := fmt.Sprintf("invalid payload: %v", err)
+		m.db.FinishJob(row.ID, m.owner, StateDead, time.Now(), &msg)
+		metrics.RecordJob(row.Type, StateDead, 0)
+		return
+	}
+
+	// Keep the lease alive while the job runs
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(leaseTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
 				return
+			case <-ticker.C:
+				m.db.ExtendJobLease(row.ID, m.owner, time.Now().Add(leaseTimeout))
 			}
-			// log.Printf("Worker %d starting job: %s", id, job.Name())
-			if err := job.Execute(); err != nil {
-				log.Printf("Worker %d: Job %s failed: %v", id, job.Name(), err)
-			}
+		}
+	}()
+	start := time.Now()
+	err := job.Execute()
+	close(done)
+
+	if err == nil {
+		m.db.FinishJob(row.ID, m.owner, StateSucceeded, time.Now(), nil)
+		metrics.RecordJob(row.Type, StateSucceeded, time.Since(start))
+		return
+	}
+
+	msg := err.Error()
+	if row.Attempts >= row.MaxAttempts {
+		log.Printf("Worker %d: Job %s (#%d) failed permanently after %d attempts: %v", workerID, job.Name(), row.ID, row.Attempts, err)
+		m.db.FinishJob(row.ID, m.owner, StateDead, time.Now(), &msg)
+		metrics.RecordJob(row.Type, StateDead, time.Since(start))
+		if d, ok := job.(DeadJob); ok {
+			d.Dead(err)
+		}
+		return
+	}
+	next := time.Now().Add(Backoff(row.Attempts))
+	log.Printf("Worker %d: Job %s (#%d) failed (attempt %d/%d), retrying at %s: %v", workerID, job.Name(), row.ID, row.Attempts, row.MaxAttempts, next.Format(time.RFC3339), err)
+	m.db.FinishJob(row.ID, m.owner, StateQueued, next, &msg)
+	metrics.RecordJob(row.Type, "retried", time.Since(start))
+}
+
+// Backoff doubles the delay before each retry, up to an hour
+func Backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// janitor removes old finished jobs so the table does not grow without bound, and marks
+// jobs dead whose worker died during their last attempt
+func (m *Manager) janitor() {
+	defer m.waitGroup.Done()
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	leases := time.NewTicker(leaseCheckInterval)
+	defer leases.Stop()
+	m.deadLetterExpired()
+	for {
+		select {
 		case <-m.quit:
 			return
+		case <-leases.C:
+			m.deadLetterExpired()
+		case <-ticker.C:
+			if n, err := m.db.DeleteFinishedJobs(time.Now().Add(-finishedRetention)); err == nil && n > 0 {
+				log.Printf("Removed %d finished jobs", n)
+			}
+		}
+	}
+}
+
+// deadLetterExpired marks jobs dead whose lease expired on their last attempt and runs their
+// dead hooks. Their attempts never returned, so LeaseJob does not claim them again.
+func (m *Manager) deadLetterExpired() {
+	rows, err := m.db.DeadLetterExpiredJobs()
+	if err != nil {
+		log.Printf("Failed to dead-letter jobs with expired leases: %v", err)
+	}
+	for _, row := range rows {
+		log.Printf("Job %s (#%d) failed permanently after %d attempts: %s", row.Name, row.ID, row.Attempts, database.JobLeaseExpired)
+		metrics.RecordJob(row.Type, StateDead, 0)
+
+		m.mu.RLock()
+		t, ok := m.types[row.Type]
+		m.mu.RUnlock()
+		if !ok {
+			continue
+		}
+		job := t.factory()
+		if err := json.Unmarshal([]byte(row.Payload), job); err != nil {
+			continue
+		}
+		if d, ok := job.(DeadJob); ok {
+			d.Dead(errors.New(database.JobLeaseExpired))
 		}
 	}
 }
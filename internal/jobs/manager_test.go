@@ -0,0 +1,191 @@
+package jobs
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+type testJob struct {
+	Fail bool `json:"fail"`
+
+	ran  chan bool
+	dead chan error
+}
+
+func (j *testJob) Type() string { return "test_job" }
+func (j *testJob) Name() string { return "TestJob" }
+
+func (j *testJob) Execute() error {
+	j.ran <- j.Fail
+	if j.Fail {
+		return errors.New("boom")
+	}
+	return nil
+}
+
+func (j *testJob) Dead(err error) { j.dead <- err }
+
+func waitState(t *testing.T, m *Manager, id int64, state string) *database.JobRow {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		row, err := m.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row != nil && row.State == state {
+			return row
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("job %d did not reach state %s", id, state)
+	return nil
+}
+
+func TestManagerRunsRetriesAndCancelsJobs(t *testing.T) {
+	t.Setenv("DATABASE_URL", "file:"+filepath.Join(t.TempDir(), "jobs.db"))
+	db, err := database.NewDatabase("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ran := make(chan bool, 10)
+	dead := make(chan error, 10)
+	m := NewManager(db, 2)
+	m.Register("test_job", 1, func() Job { return &testJob{ran: ran, dead: dead} })
+	m.Start()
+	defer m.Stop()
+
+	// Succeeding job
+	id, err := m.Enqueue(&testJob{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, m, id, StateSucceeded)
+
+	// Failing job with a single attempt goes dead and runs its hook
+	id, err = m.Enqueue(&testJob{Fail: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := waitState(t, m, id, StateDead)
+	if row.Attempts != 1 || row.LastError == nil || *row.LastError != "boom" {
+		t.Errorf("dead job = attempts %d, error %v", row.Attempts, row.LastError)
+	}
+	select {
+	case <-dead:
+	case <-time.After(time.Second):
+		t.Error("Dead hook was not called")
+	}
+
+	// Retrying a dead job runs it again
+	if ok, err := m.Retry(id); err != nil || !ok {
+		t.Fatalf("Retry = %v, %v", ok, err)
+	}
+	waitState(t, m, id, StateDead)
+
+	// Finished jobs cannot be cancelled
+	if ok, _ := m.Cancel(id); ok {
+		t.Error("cancelled a dead job")
+	}
+}
+
+func TestExpiredLeasesCountAsAttempts(t *testing.T) {
+	t.Setenv("DATABASE_URL", "file:"+filepath.Join(t.TempDir(), "jobs.db"))
+	db, err := database.NewDatabase("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dead := make(chan error, 1)
+	m := NewManager(db, 1)
+	m.Register("test_job", 2, func() Job { return &testJob{dead: dead} })
+	id, err := m.Enqueue(&testJob{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A worker that dies mid-attempt leaves its lease to expire; the job is claimed again
+	for attempt := 1; attempt <= 2; attempt++ {
+		row, err := db.LeaseJob("crashed", time.Now().Add(-time.Second))
+		if err != nil || row == nil || row.ID != id || row.Attempts != attempt {
+			t.Fatalf("lease %d = %+v, %v", attempt, row, err)
+		}
+		if attempt == 2 && (row.LastError == nil || *row.LastError != database.JobLeaseExpired) {
+			t.Errorf("re-leased job error = %v", row.LastError)
+		}
+	}
+
+	// The last attempt expired too: the job is not claimed a third time but dead-lettered
+	if row, err := db.LeaseJob("worker", time.Now().Add(time.Minute)); err != nil || row != nil {
+		t.Fatalf("lease after the last attempt = %+v, %v", row, err)
+	}
+	m.deadLetterExpired()
+	row, _ := m.Get(id)
+	if row.State != StateDead || row.Attempts != 2 || row.LastError == nil || *row.LastError != database.JobLeaseExpired {
+		t.Errorf("job after its last lease expired = %s, attempts %d, error %v", row.State, row.Attempts, row.LastError)
+	}
+	select {
+	case err := <-dead:
+		if err == nil || err.Error() != database.JobLeaseExpired {
+			t.Errorf("Dead hook error = %v", err)
+		}
+	default:
+		t.Error("Dead hook was not called")
+	}
+}
+
+type uniqueTestJob struct{ testJob }
+
+func (j *uniqueTestJob) UniqueKey() string { return "test_job:only" }
+
+func TestUniqueJobsAreQueuedOnce(t *testing.T) {
+	t.Setenv("DATABASE_URL", "file:"+filepath.Join(t.TempDir(), "jobs.db"))
+	db, err := database.NewDatabase("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(db, 1)
+	m.Register("test_job", 1, func() Job { return &testJob{} })
+
+	first, err := m.Enqueue(&uniqueTestJob{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := m.Enqueue(&uniqueTestJob{}); err != nil || id != first {
+		t.Fatalf("second enqueue = %d, %v, want the pending job %d", id, err, first)
+	}
+	if rows, _ := db.ListJobs("", "test_job", 10); len(rows) != 1 {
+		t.Fatalf("%d jobs queued for one unique key", len(rows))
+	}
+	// Jobs without a key are not deduplicated
+	a, _ := m.Enqueue(&testJob{})
+	b, _ := m.Enqueue(&testJob{})
+	if a == b || a == 0 {
+		t.Errorf("jobs without a unique key = %d, %d", a, b)
+	}
+
+	// Once the pending job is finished the key can be queued again
+	if ok, err := m.Cancel(first); err != nil || !ok {
+		t.Fatalf("Cancel = %v, %v", ok, err)
+	}
+	if id, err := m.Enqueue(&uniqueTestJob{}); err != nil || id == first || id == 0 {
+		t.Errorf("enqueue after the pending job finished = %d, %v", id, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if got := Backoff(1); got != 5*time.Second {
+		t.Errorf("Backoff(1) = %v", got)
+	}
+	if got := Backoff(3); got != 20*time.Second {
+		t.Errorf("Backoff(3) = %v", got)
+	}
+	if got := Backoff(50); got != time.Hour {
+		t.Errorf("Backoff(50) = %v", got)
+	}
+}
@@ -8,13 +8,14 @@ import (
 )
 
 var (
-	// Request Metrics
+	// Request Metrics, labelled by API (admin or s3), operation (the S3 action, or the route for
+	// the admin API), bucket (capped, see SetBucketLabelLimit), status class and authentication type
 	RequestsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "gravspace_requests_total",
 			Help: "Total number of HTTP requests",
 		},
-		[]string{"method", "status", "path"},
+		[]string{"api", "operation", "bucket", "status_class", "auth_type"},
 	)
 	RequestDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -22,7 +23,7 @@ var (
 			Help:    "Duration of HTTP requests",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"method", "path"},
+		[]string{"api", "operation", "bucket"},
 	)
 	RequestSize = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -30,7 +31,7 @@ var (
 			Help:    "Size of HTTP requests",
 			Buckets: prometheus.ExponentialBuckets(100, 10, 8),
 		},
-		[]string{"method", "path"},
+		[]string{"api", "operation"},
 	)
 	ResponseSize = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -38,7 +39,21 @@ var (
 			Help:    "Size of HTTP responses",
 			Buckets: prometheus.ExponentialBuckets(100, 10, 8),
 		},
-		[]string{"method", "path"},
+		[]string{"api", "operation"},
+	)
+	RequestsInFlight = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gravspace_requests_in_flight",
+			Help: "Number of HTTP requests being served",
+		},
+		[]string{"api", "operation"},
+	)
+	RequestErrorsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_request_errors_total",
+			Help: "Total number of failed HTTP requests by S3 error code, or status for other errors",
+		},
+		[]string{"api", "operation", "code"},
 	)
 
 	// Storage Metrics
@@ -95,20 +110,243 @@ var (
 		},
 		[]string{"operation"},
 	)
-)
 
-// StartMetricsUpdater is a placeholder for background updates of gauge metrics.
-// In a real application, this might query the database or filesystem periodically.
-func StartMetricsUpdater() {
-	// For now, we'll let the individual packages update their own gauges on operations,
-	// or we could add a periodic task here if we want absolute numbers.
-	go func() {
-		ticker := time.NewTicker(1 * time.Minute)
-		for range ticker.C {
-			// Periodic update logic could go here
-		}
-	}()
-}
+	// Rate Limiting Metrics
+	RateLimitedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_rate_limited_requests_total",
+			Help: "Total number of requests rejected with SlowDown",
+		},
+		[]string{"scope", "class"},
+	)
+
+	// Replication Metrics
+	ReplicationTasksTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_replication_tasks_total",
+			Help: "Total number of replication attempts",
+		},
+		[]string{"operation", "result"},
+	)
+	ReplicationBacklog = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gravspace_replication_backlog",
+			Help: "Number of replication tasks waiting to be processed",
+		},
+	)
+	ReplicationLagSeconds = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gravspace_replication_lag_seconds",
+			Help: "Age of the oldest pending replication task",
+		},
+	)
+
+	// Background Job Metrics
+	Jobs = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gravspace_jobs",
+			Help: "Number of background jobs by state (queued, running, dead, ...)",
+		},
+		[]string{"state"},
+	)
+	JobsProcessedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_jobs_processed_total",
+			Help: "Total number of background job attempts by outcome (succeeded, retried, dead)",
+		},
+		[]string{"type", "result"},
+	)
+	JobDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gravspace_job_duration_seconds",
+			Help:    "Duration of background job attempts",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+		},
+		[]string{"type"},
+	)
+	JobsDroppedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_jobs_dropped_total",
+			Help: "Total number of background jobs that could not be queued",
+		},
+		[]string{"type"},
+	)
+
+	// Webhook Metrics
+	WebhookQueueDepth = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gravspace_webhook_queue_depth",
+			Help: "Number of events waiting for webhook delivery",
+		},
+	)
+	WebhookDeliveriesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_webhook_deliveries_total",
+			Help: "Total number of webhook deliveries by outcome (delivered, failed)",
+		},
+		[]string{"result"},
+	)
+	WebhookDeliveryDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "gravspace_webhook_delivery_duration_seconds",
+			Help:    "Time to deliver a webhook, including retries",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+	WebhookDLQSize = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gravspace_webhook_dlq_size",
+			Help: "Number of failed webhook deliveries in the dead letter queue",
+		},
+	)
+
+	// Worker Metrics
+	WorkerRunsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_worker_runs_total",
+			Help: "Total number of background worker runs (sync, lifecycle, trash)",
+		},
+		[]string{"worker"},
+	)
+	WorkerRunDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gravspace_worker_run_duration_seconds",
+			Help:    "Duration of background worker runs",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+		},
+		[]string{"worker"},
+	)
+	WorkerDeletionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_worker_deletions_total",
+			Help: "Total number of object versions removed by a background worker (lifecycle, trash)",
+		},
+		[]string{"worker"},
+	)
+	SyncRecordsFixedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_sync_records_fixed_total",
+			Help: "Total number of metadata records corrected by the filesystem sync, by kind of fix",
+		},
+		[]string{"kind"},
+	)
+
+	// Content-Addressed Storage Metrics
+	CASBlobs = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gravspace_cas_blobs",
+			Help: "Number of distinct blobs in the content-addressed store",
+		},
+	)
+	CASBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gravspace_cas_bytes",
+			Help: "Object data by kind: logical (as uploaded), stored (after compression, before deduplication) and physical (on disk)",
+		},
+		[]string{"kind"},
+	)
+
+	// Scrubber Metrics
+	ScrubBlobsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_scrub_blobs_total",
+			Help: "Total number of CAS blobs verified by the scrubber, by result (ok, corrupted)",
+		},
+		[]string{"result"},
+	)
+	ScrubBytesTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gravspace_scrub_bytes_total",
+			Help: "Total number of bytes read from disk by the scrubber",
+		},
+	)
+	ScrubRestoredTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_scrub_restored_total",
+			Help: "Total number of quarantined blobs replaced by an intact copy, by source (mirror, replica, upload)",
+		},
+		[]string{"source"},
+	)
+	ScrubProgress = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gravspace_scrub_progress_ratio",
+			Help: "Fraction of the CAS blobs verified by the running scrub pass",
+		},
+	)
+	ScrubLastPassCompleted = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gravspace_scrub_last_pass_completed_timestamp_seconds",
+			Help: "Unix time at which the last complete scrub pass finished",
+		},
+	)
+	QuarantinedBlobs = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gravspace_quarantined_blobs",
+			Help: "Number of quarantined blobs still waiting for an intact copy",
+		},
+	)
+
+	// Data Drive Metrics
+	DriveOnline = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gravspace_drive_online",
+			Help: "Whether a data drive is readable (1) or offline (0)",
+		},
+		[]string{"drive"},
+	)
+	DriveFreeBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gravspace_drive_free_bytes",
+			Help: "Free space of the filesystem holding a data drive",
+		},
+		[]string{"drive"},
+	)
+	DriveHealBlobsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_drive_heal_blobs_total",
+			Help: "Total number of CAS blobs handled by drive heals, by result (copied, lost)",
+		},
+		[]string{"result"},
+	)
+	UnderReplicatedBlobs = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gravspace_under_replicated_blobs",
+			Help: "Number of CAS blobs with fewer copies than configured, as of the last drive heal",
+		},
+	)
+
+	// Erasure Coding Metrics
+	ErasureDegradedReadsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gravspace_erasure_degraded_reads_total",
+			Help: "Total number of erasure-coded reads that reconstructed missing or corrupt shards",
+		},
+	)
+	ErasureShardsRebuiltTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_erasure_shards_rebuilt_total",
+			Help: "Total number of erasure shards rebuilt, by trigger (heal, scrub, fsck)",
+		},
+		[]string{"trigger"},
+	)
+
+	// Multipart Upload Metrics
+	MultipartUploadsInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gravspace_multipart_uploads_in_flight",
+			Help: "Number of multipart uploads initiated but not completed or aborted",
+		},
+	)
+
+	// Audit Sink Metrics
+	AuditSinkEntriesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gravspace_audit_sink_entries_total",
+			Help: "Total number of audit entries handed to a sink, by outcome (delivered, failed, dropped)",
+		},
+		[]string{"sink", "result"},
+	)
+)
 
 func RecordCacheHit(cacheType string) {
 	CacheHits.WithLabelValues(cacheType).Inc()
@@ -122,3 +360,27 @@ func RecordDBQuery(operation string, duration time.Duration) {
 	DBQueriesTotal.WithLabelValues(operation).Inc()
 	DBQueryDuration.WithLabelValues(operation).Observe(duration.Seconds())
 }
+
+func RecordRateLimited(scope, class string) {
+	RateLimitedTotal.WithLabelValues(scope, class).Inc()
+}
+
+func RecordReplicationTask(operation, result string) {
+	ReplicationTasksTotal.WithLabelValues(operation, result).Inc()
+}
+
+func RecordAuditSinkEntries(sink, result string, count int) {
+	AuditSinkEntriesTotal.WithLabelValues(sink, result).Add(float64(count))
+}
+
+// RecordWorkerRun records a completed run of a background worker
+func RecordWorkerRun(worker string, duration time.Duration) {
+	WorkerRunsTotal.WithLabelValues(worker).Inc()
+	WorkerRunDuration.WithLabelValues(worker).Observe(duration.Seconds())
+}
+
+// RecordJob records the outcome of a background job attempt
+func RecordJob(jobType, result string, duration time.Duration) {
+	JobsProcessedTotal.WithLabelValues(jobType, result).Inc()
+	JobDuration.WithLabelValues(jobType).Observe(duration.Seconds())
+}
@@ -2,48 +2,104 @@ package metrics
 
 import (
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/labstack/echo/v4"
+	"github.com/gin-gonic/gin"
 )
 
-// Middleware returns an Echo middleware that records Prometheus metrics.
-func Middleware() echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			if c.Path() == "/metrics" {
-				return next(c)
-			}
+// Labeler resolves the operation and authentication type a request is recorded under
+type Labeler func(c *gin.Context) (operation, authType string)
 
-			start := time.Now()
-			req := c.Request()
+// OtherBucket is the bucket label used once the bucket label limit is reached
+const OtherBucket = "_other"
 
-			// Get request size
-			reqSize := float64(req.ContentLength)
-			if reqSize < 0 {
-				reqSize = 0
-			}
+// DefaultBucketLabelLimit is how many distinct buckets get their own label by default
+const DefaultBucketLabelLimit = 100
 
-			err := next(c)
+// bucketLabels caps the number of distinct bucket label values. A bucket earns its own label
+// with its first successful request, so requests for made-up bucket names cannot use up the limit.
+var bucketLabels = struct {
+	sync.RWMutex
+	limit int
+	seen  map[string]bool
+}{limit: DefaultBucketLabelLimit, seen: make(map[string]bool)}
 
-			status := c.Response().Status
-			if err != nil {
-				if e, ok := err.(*echo.HTTPError); ok {
-					status = e.Code
-				}
-			}
+// SetBucketLabelLimit sets how many distinct buckets get their own label
+func SetBucketLabelLimit(limit int) {
+	bucketLabels.Lock()
+	defer bucketLabels.Unlock()
+	bucketLabels.limit = limit
+}
 
-			elapsed := time.Since(start).Seconds()
-			method := req.Method
-			path := c.Path()
-			statusStr := strconv.Itoa(status)
+func bucketLabel(bucket string, status int) string {
+	if bucket == "" {
+		return ""
+	}
+	bucketLabels.RLock()
+	seen, full := bucketLabels.seen[bucket], len(bucketLabels.seen) >= bucketLabels.limit
+	bucketLabels.RUnlock()
+	if seen {
+		return bucket
+	}
+	if full || status >= 400 {
+		return OtherBucket
+	}
+	bucketLabels.Lock()
+	defer bucketLabels.Unlock()
+	if len(bucketLabels.seen) >= bucketLabels.limit {
+		return OtherBucket
+	}
+	bucketLabels.seen[bucket] = true
+	return bucket
+}
 
-			RequestsTotal.WithLabelValues(method, statusStr, path).Inc()
-			RequestDuration.WithLabelValues(method, path).Observe(elapsed)
-			RequestSize.WithLabelValues(method, path).Observe(reqSize)
-			ResponseSize.WithLabelValues(method, path).Observe(float64(c.Response().Size))
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
 
-			return err
+// Middleware records request counts, latency, sizes, in-flight requests and errors for one of
+// the APIs ("admin" or "s3"). Scrapes and health probes are not recorded.
+func Middleware(api string, labeler Labeler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if path == "/metrics" || strings.HasPrefix(path, "/health/") {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		operation, authType := labeler(c)
+		inFlight := RequestsInFlight.WithLabelValues(api, operation)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		c.Next()
+
+		status := c.Writer.Status()
+		bucket := bucketLabel(c.Param("bucket"), status)
+		reqSize := float64(c.Request.ContentLength)
+		if reqSize < 0 {
+			reqSize = 0
+		}
+		respSize := float64(c.Writer.Size())
+		if respSize < 0 {
+			respSize = 0
+		}
+
+		RequestsTotal.WithLabelValues(api, operation, bucket, statusClass(status), authType).Inc()
+		RequestDuration.WithLabelValues(api, operation, bucket).Observe(time.Since(start).Seconds())
+		RequestSize.WithLabelValues(api, operation).Observe(reqSize)
+		ResponseSize.WithLabelValues(api, operation).Observe(respSize)
+
+		if status >= 400 {
+			// S3 errors are counted by their error code, anything else by status
+			code := c.GetString("s3_error_code")
+			if code == "" {
+				code = strconv.Itoa(status)
+			}
+			RequestErrorsTotal.WithLabelValues(api, operation, code).Inc()
 		}
 	}
 }
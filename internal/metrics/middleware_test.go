@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddlewareLabels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	SetBucketLabelLimit(1)
+	defer SetBucketLabelLimit(DefaultBucketLabelLimit)
+
+	r := gin.New()
+	r.Use(Middleware("s3", func(c *gin.Context) (string, string) { return "s3:GetObject", "signed" }))
+	r.GET("/:bucket/*key", func(c *gin.Context) {
+		if c.Param("bucket") == "missing" {
+			c.Set("s3_error_code", "NoSuchBucket")
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.String(http.StatusOK, "data")
+	})
+
+	for _, path := range []string{"/first/a", "/first/b", "/missing/a", "/second/a"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if n := testutil.ToFloat64(RequestsTotal.WithLabelValues("s3", "s3:GetObject", "first", "2xx", "signed")); n != 2 {
+		t.Errorf("first bucket requests = %v", n)
+	}
+	// The failed request does not claim a label, and the limit sends the next bucket to _other
+	if n := testutil.ToFloat64(RequestsTotal.WithLabelValues("s3", "s3:GetObject", OtherBucket, "4xx", "signed")); n != 1 {
+		t.Errorf("missing bucket requests = %v", n)
+	}
+	if n := testutil.ToFloat64(RequestsTotal.WithLabelValues("s3", "s3:GetObject", OtherBucket, "2xx", "signed")); n != 1 {
+		t.Errorf("over-limit bucket requests = %v", n)
+	}
+	if n := testutil.ToFloat64(RequestErrorsTotal.WithLabelValues("s3", "s3:GetObject", "NoSuchBucket")); n != 1 {
+		t.Errorf("errors = %v", n)
+	}
+	if n := testutil.ToFloat64(RequestsInFlight.WithLabelValues("s3", "s3:GetObject")); n != 0 {
+		t.Errorf("in flight = %v", n)
+	}
+}
@@ -2,24 +2,48 @@ package notifications
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/health"
+	"github.com/GravSpace/GravSpace/internal/metrics"
+	"github.com/GravSpace/GravSpace/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Dispatcher struct {
 	db        *database.Database
 	eventChan chan Event
 	workers   int
+	awsRegion string
+	processed atomic.Uint64
+
+	// mu guards stopped; Dispatch holds it for reading while it queues an event
+	mu        sync.RWMutex
+	stopped   bool
+	draining  atomic.Bool
+	quit      chan struct{}
+	waitGroup sync.WaitGroup
 }
 
+// errShutdown is recorded in the dead letter queue for events that were still queued when the
+// delivery deadline of Stop passed
+var errShutdown = errors.New("server shut down before delivery")
+
 type Event struct {
 	Bucket    string
 	EventName string
@@ -27,6 +51,9 @@ type Event struct {
 	Size      int64
 	ETag      string
 	VersionID string
+
+	// trace is the span of the request that caused the event; delivery is traced as its child
+	trace trace.SpanContext
 }
 
 type S3Event struct {
@@ -62,32 +89,125 @@ func NewDispatcher(db *database.Database, workerCount int) *Dispatcher {
 		db:        db,
 		eventChan: make(chan Event, 100),
 		workers:   workerCount,
+		quit:      make(chan struct{}),
 	}
 }
 
+// SetRegion sets the region reported in event records
+func (d *Dispatcher) SetRegion(region string) {
+	d.awsRegion = region
+}
+
+func (d *Dispatcher) region() string {
+	if d.awsRegion == "" {
+		return "vdev" // Local region
+	}
+	return d.awsRegion
+}
+
 func (d *Dispatcher) Start() {
 	for i := 0; i < d.workers; i++ {
+		d.waitGroup.Add(1)
 		go d.worker()
 	}
+	go d.monitor()
+}
+
+// Stop stops accepting events and waits for the queued ones to be delivered. Once ctx expires,
+// retries are cut short and the events that are still queued are stored in the dead letter
+// queue instead of being sent, so they can be retried after the restart.
+func (d *Dispatcher) Stop(ctx context.Context) {
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return
+	}
+	d.stopped = true
+	close(d.eventChan)
+	close(d.quit)
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.waitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Webhook dispatcher: shutdown deadline reached, storing %d queued events in the DLQ", len(d.eventChan))
+		d.draining.Store(true)
+		<-done
+	}
+	log.Println("Webhook dispatcher stopped")
+}
+
+// monitor reports the dispatcher as alive while its queue is empty or events keep being processed
+func (d *Dispatcher) monitor() {
+	heartbeat := health.Worker("webhooks")
+	heartbeat.Beat()
+	ticker := time.NewTicker(health.HeartbeatInterval)
+	defer ticker.Stop()
+	var last uint64
+	for {
+		select {
+		case <-d.quit:
+			return
+		case <-ticker.C:
+		}
+		processed := d.processed.Load()
+		if processed != last || len(d.eventChan) == 0 {
+			heartbeat.Beat()
+		}
+		last = processed
+	}
 }
 
-func (d *Dispatcher) Dispatch(e Event) {
+func (d *Dispatcher) Dispatch(ctx context.Context, e Event) {
+	e.trace = trace.SpanContextFromContext(ctx)
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.stopped {
+		log.Printf("Webhook dispatcher is stopped, dropping %s event for %s/%s", e.EventName, e.Bucket, e.Key)
+		return
+	}
 	d.eventChan <- e
+	metrics.WebhookQueueDepth.Set(float64(len(d.eventChan)))
+}
+
+// UpdateMetrics sets the queue depth and dead letter queue gauges
+func (d *Dispatcher) UpdateMetrics() {
+	metrics.WebhookQueueDepth.Set(float64(len(d.eventChan)))
+	if d.db == nil {
+		return
+	}
+	if n, err := d.db.CountWebhookDLQ(); err == nil {
+		metrics.WebhookDLQSize.Set(float64(n))
+	} else {
+		log.Printf("Failed to count webhook DLQ: %v", err)
+	}
 }
 
 func (d *Dispatcher) worker() {
+	defer d.waitGroup.Done()
 	client := &http.Client{Timeout: 10 * time.Second}
 	for e := range d.eventChan {
+		metrics.WebhookQueueDepth.Set(float64(len(d.eventChan)))
 		d.processEvent(e, client)
+		d.processed.Add(1)
 	}
 }
 
 func (d *Dispatcher) processEvent(e Event, client *http.Client) {
-	hooks, err := d.db.GetWebhooksByBucket(e.Bucket)
+	ctx, span := tracing.Start(trace.ContextWithRemoteSpanContext(context.Background(), e.trace), "webhook.Dispatch",
+		tracing.Bucket(e.Bucket), tracing.Key(e.Key), attribute.String("s3.event", e.EventName))
+	hooks, err := d.db.WithContext(ctx).GetWebhooksByBucket(e.Bucket)
 	if err != nil {
+		tracing.End(span, err)
 		log.Printf("Webhook error fetching hooks for %s: %v", e.Bucket, err)
 		return
 	}
+	defer span.End()
 
 	for _, h := range hooks {
 		if !h.Active {
@@ -107,18 +227,23 @@ func (d *Dispatcher) processEvent(e Event, client *http.Client) {
 		}
 
 		if match {
-			d.sendWebhook(h, e, client)
+			d.sendWebhook(ctx, h, e, client)
 		}
 	}
 }
 
-func (d *Dispatcher) sendWebhook(h *database.WebhookRecord, e Event, client *http.Client) {
+func (d *Dispatcher) sendWebhook(ctx context.Context, h *database.WebhookRecord, e Event, client *http.Client) {
+	ctx, span := tracing.Start(ctx, "webhook.Send", attribute.Int64("webhook.id", h.ID), attribute.String("url.full", h.URL))
+	var lastErr error
+	defer func() { tracing.End(span, lastErr) }()
+	start := time.Now()
+
 	payload := S3Event{
 		Records: []S3EventRecord{
 			{
 				EventVersion: "2.1",
 				EventSource:  "aws:s3",
-				AwsRegion:    "vdev", // Local region
+				AwsRegion:    d.region(),
 				EventTime:    time.Now().Format(time.RFC3339),
 				EventName:    e.EventName,
 				S3: S3Entity{
@@ -149,7 +274,6 @@ func (d *Dispatcher) sendWebhook(h *database.WebhookRecord, e Event, client *htt
 
 	body, _ := json.Marshal(payload)
 
-	var lastErr error
 	backoff := 1 * time.Second
 	maxRetries := 3
 
@@ -158,8 +282,15 @@ func (d *Dispatcher) sendWebhook(h *database.WebhookRecord, e Event, client *htt
 			time.Sleep(backoff)
 			backoff *= 2
 		}
+		if d.draining.Load() {
+			if lastErr == nil {
+				lastErr = errShutdown
+			}
+			break
+		}
+		span.SetAttributes(attribute.Int("webhook.attempts", attempt+1))
 
-		req, err := http.NewRequest("POST", h.URL, bytes.NewBuffer(body))
+		req, err := http.NewRequestWithContext(ctx, "POST", h.URL, bytes.NewBuffer(body))
 		if err != nil {
 			lastErr = err
 			continue
@@ -168,6 +299,7 @@ func (d *Dispatcher) sendWebhook(h *database.WebhookRecord, e Event, client *htt
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("User-Agent", "GravSpace-Webhook-Dispatcher/1.0")
 		req.Header.Set("X-GravSpace-Event", e.EventName)
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 		// HMAC Signature if secret exists
 		if h.Secret != "" {
@@ -196,13 +328,20 @@ func (d *Dispatcher) sendWebhook(h *database.WebhookRecord, e Event, client *htt
 		break
 	}
 
-	if lastErr != nil {
-		log.Printf("Webhook delivery permanently failed for %s (stored in DLQ): %v", h.URL, lastErr)
-		if d.db != nil {
-			_, err := d.db.CreateWebhookDLQ(h.ID, e.Bucket, h.URL, e.EventName, string(body), lastErr.Error())
-			if err != nil {
-				log.Printf("Failed to store webhook in DLQ: %v", err)
-			}
+	metrics.WebhookDeliveryDuration.Observe(time.Since(start).Seconds())
+	if lastErr == nil {
+		metrics.WebhookDeliveriesTotal.WithLabelValues("delivered").Inc()
+		return
+	}
+
+	metrics.WebhookDeliveriesTotal.WithLabelValues("failed").Inc()
+	log.Printf("Webhook delivery permanently failed for %s (stored in DLQ): %v", h.URL, lastErr)
+	if d.db != nil {
+		_, err := d.db.WithContext(ctx).CreateWebhookDLQ(h.ID, e.Bucket, h.URL, e.EventName, string(body), lastErr.Error())
+		if err != nil {
+			log.Printf("Failed to store webhook in DLQ: %v", err)
+		} else {
+			metrics.WebhookDLQSize.Inc()
 		}
 	}
 }
@@ -0,0 +1,61 @@
+package notifications
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+func TestStopStoresQueuedEventsInDLQ(t *testing.T) {
+	t.Setenv("DATABASE_URL", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	db, err := database.NewDatabase("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	if _, err := db.CreateWebhook(&database.WebhookRecord{Bucket: "hooked", URL: failing.URL, Events: `["*"]`, Active: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(db, 1)
+	d.Start()
+	d.Dispatch(context.Background(), Event{Bucket: "hooked", Key: "a", EventName: "ObjectCreated:Put"})
+	d.Dispatch(context.Background(), Event{Bucket: "hooked", Key: "b", EventName: "ObjectCreated:Put"})
+
+	// The deadline passes during the first event's retries; neither event is sent again
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	d.Stop(ctx)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Stop took %v", elapsed)
+	}
+
+	dlq, err := db.ListWebhookDLQ("hooked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dlq) != 2 {
+		t.Fatalf("DLQ has %d entries, want 2", len(dlq))
+	}
+	shutdown := 0
+	for _, r := range dlq {
+		if r.ErrorMessage == errShutdown.Error() {
+			shutdown++
+		}
+	}
+	if shutdown != 1 {
+		t.Errorf("%d entries were stored without an attempt, want 1", shutdown)
+	}
+
+	// Events raised after Stop are dropped instead of panicking on the closed queue
+	d.Dispatch(context.Background(), Event{Bucket: "hooked", Key: "c", EventName: "ObjectCreated:Put"})
+}
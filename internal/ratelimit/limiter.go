@@ -0,0 +1,342 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SettingKey is the system_settings key the limits are persisted under
+const SettingKey = "rate_limits"
+
+// Request classes, each with its own budget
+const (
+	ClassRead  = "read"
+	ClassWrite = "write"
+	ClassList  = "list"
+)
+
+// Scopes a request is limited by
+const (
+	ScopeAccessKey = "access_key"
+	ScopeUser      = "user"
+	ScopeBucket    = "bucket"
+	ScopeIP        = "ip"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second holding up to Burst tokens.
+// A zero Rate means unlimited.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Budgets holds the per-class limits of one subject
+type Budgets struct {
+	Read  *Limit `json:"read,omitempty"`
+	Write *Limit `json:"write,omitempty"`
+	List  *Limit `json:"list,omitempty"`
+}
+
+func (b Budgets) forClass(class string) *Limit {
+	switch class {
+	case ClassRead:
+		return b.Read
+	case ClassWrite:
+		return b.Write
+	case ClassList:
+		return b.List
+	}
+	return nil
+}
+
+// ScopeLimits applies Default to every subject of a scope (every access key, every IP, ...),
+// unless the subject has its own entry in Overrides
+type ScopeLimits struct {
+	Default   Budgets            `json:"default"`
+	Overrides map[string]Budgets `json:"overrides,omitempty"`
+}
+
+func (s ScopeLimits) limitFor(subject, class string) *Limit {
+	if b, ok := s.Overrides[subject]; ok {
+		return b.forClass(class)
+	}
+	return s.Default.forClass(class)
+}
+
+// Config is the full rate limiting configuration
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Distributed keeps the token buckets in Redis so that all nodes share them
+	Distributed bool `json:"distributed"`
+
+	AccessKey ScopeLimits `json:"access_key"`
+	User      ScopeLimits `json:"user"`
+	Bucket    ScopeLimits `json:"bucket"`
+	IP        ScopeLimits `json:"ip"`
+}
+
+// ParseConfig decodes a configuration stored in system settings
+func ParseConfig(data string) (Config, error) {
+	var cfg Config
+	if data == "" {
+		return cfg, nil
+	}
+	err := json.Unmarshal([]byte(data), &cfg)
+	return cfg, err
+}
+
+// Validate rejects negative rates or bursts
+func (c Config) Validate() error {
+	scopes := map[string]ScopeLimits{
+		ScopeAccessKey: c.AccessKey,
+		ScopeUser:      c.User,
+		ScopeBucket:    c.Bucket,
+		ScopeIP:        c.IP,
+	}
+	for scope, limits := range scopes {
+		all := map[string]Budgets{"default": limits.Default}
+		for subject, b := range limits.Overrides {
+			all[subject] = b
+		}
+		for subject, b := range all {
+			for _, limit := range []*Limit{b.Read, b.Write, b.List} {
+				if limit != nil && (limit.Rate < 0 || limit.Burst < 0) {
+					return fmt.Errorf("%s limit for %q must not be negative", scope, subject)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Request identifies who is making a request and what kind of request it is
+type Request struct {
+	AccessKey string
+	User      string
+	Bucket    string
+	IP        string
+	Class     string
+}
+
+type tokenBucket struct {
+	tokens   float64
+	last     time.Time
+	rate     float64
+	burst    float64
+	lastUsed time.Time
+}
+
+// Limiter enforces token-bucket limits in memory, or in Redis when configured as distributed
+type Limiter struct {
+	mu        sync.Mutex
+	config    Config
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	redis     *redis.Client
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// SetRedis enables the shared Redis backend used when the configuration is distributed
+func (l *Limiter) SetRedis(client *redis.Client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.redis = client
+}
+
+// SetConfig replaces the limits. Buckets keep their tokens while their limit is unchanged, so a
+// reload does not refill throttled clients; a bucket whose limit changed starts full on its next
+// request, and buckets of limits that were removed are swept once idle.
+func (l *Limiter) SetConfig(cfg Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = cfg
+}
+
+func (l *Limiter) Config() Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.config
+}
+
+// bucketCheck is one token bucket a request is limited by
+type bucketCheck struct {
+	scope string
+	key   string
+	limit *Limit
+}
+
+// Allow takes a token from every bucket that applies to the request. When one of them is
+// empty it returns false, the scope that throttled the request and how long to wait; no
+// token is taken from any bucket then, so a request throttled by one scope does not use up
+// the budgets of the others.
+func (l *Limiter) Allow(req Request) (bool, string, time.Duration) {
+	l.mu.Lock()
+	cfg := l.config
+	client := l.redis
+	l.mu.Unlock()
+
+	if !cfg.Enabled {
+		return true, "", 0
+	}
+
+	subjects := []struct {
+		scope   string
+		subject string
+		limits  ScopeLimits
+	}{
+		{ScopeIP, req.IP, cfg.IP},
+		{ScopeAccessKey, req.AccessKey, cfg.AccessKey},
+		{ScopeUser, req.User, cfg.User},
+		{ScopeBucket, req.Bucket, cfg.Bucket},
+	}
+	var checks []bucketCheck
+	for _, s := range subjects {
+		if s.subject == "" {
+			continue
+		}
+		limit := s.limits.limitFor(s.subject, req.Class)
+		if limit == nil || limit.Rate <= 0 {
+			continue
+		}
+		checks = append(checks, bucketCheck{scope: s.scope, key: s.scope + ":" + s.subject + ":" + req.Class, limit: limit})
+	}
+	if len(checks) == 0 {
+		return true, "", 0
+	}
+
+	var blocked int
+	var wait time.Duration
+	if cfg.Distributed && client != nil {
+		var err error
+		blocked, wait, err = l.takeRedis(client, checks)
+		if err != nil {
+			// Fall back to the local buckets rather than failing requests when Redis is unavailable
+			log.Printf("Rate limit: Redis error, using local limits: %v", err)
+			blocked, wait = l.takeLocal(checks)
+		}
+	} else {
+		blocked, wait = l.takeLocal(checks)
+	}
+	if blocked >= 0 {
+		return false, checks[blocked].scope, wait
+	}
+	return true, "", 0
+}
+
+// takeLocal takes a token from every bucket if all of them hold one. Otherwise it returns
+// the index of the first empty bucket and how long it takes to refill; it returns -1 when
+// the tokens were taken.
+func (l *Limiter) takeLocal(checks []bucketCheck) (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	buckets := make([]*tokenBucket, len(checks))
+	for i, check := range checks {
+		burst := math.Max(float64(check.limit.Burst), 1)
+		b, ok := l.buckets[check.key]
+		if !ok || b.rate != check.limit.Rate || b.burst != burst {
+			b = &tokenBucket{tokens: burst, last: now, rate: check.limit.Rate, burst: burst}
+			l.buckets[check.key] = b
+		}
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		b.lastUsed = now
+		buckets[i] = b
+	}
+	l.sweepLocked(now)
+
+	for i, b := range buckets {
+		if b.tokens < 1 {
+			return i, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		}
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return -1, 0
+}
+
+// sweepLocked drops idle buckets so per-IP state does not grow without bound
+func (l *Limiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastUsed) > 10*time.Minute {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// tokenBucketScript refills the buckets in KEYS and takes a token from each of them only if
+// all hold one, atomically. ARGV is the current time in milliseconds followed by the rate and
+// burst of every key. It returns {blocked, wait_ms}, where blocked is the 1-based index of the
+// first empty bucket or 0 when the tokens were taken.
+var tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local tokens = {}
+local blocked = 0
+local wait = 0
+for i, key in ipairs(KEYS) do
+  local rate = tonumber(ARGV[i * 2])
+  local burst = tonumber(ARGV[i * 2 + 1])
+  local state = redis.call('HMGET', key, 'tokens', 'ts')
+  local t = tonumber(state[1])
+  local ts = tonumber(state[2])
+  if t == nil then
+    t = burst
+    ts = now
+  end
+  t = math.min(burst, t + math.max(0, now - ts) / 1000 * rate)
+  if t < 1 and blocked == 0 then
+    blocked = i
+    wait = math.ceil((1 - t) / rate * 1000)
+  end
+  tokens[i] = t
+end
+for i, key in ipairs(KEYS) do
+  local rate = tonumber(ARGV[i * 2])
+  local burst = tonumber(ARGV[i * 2 + 1])
+  if blocked == 0 then
+    tokens[i] = tokens[i] - 1
+  end
+  redis.call('HSET', key, 'tokens', tostring(tokens[i]), 'ts', now)
+  redis.call('PEXPIRE', key, math.ceil(burst / rate * 1000) + 1000)
+end
+return {blocked, wait}
+`)
+
+func (l *Limiter) takeRedis(client *redis.Client, checks []bucketCheck) (int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	keys := make([]string, len(checks))
+	args := []interface{}{time.Now().UnixMilli()}
+	for i, check := range checks {
+		keys[i] = "ratelimit:" + check.key
+		args = append(args, check.limit.Rate, math.Max(float64(check.limit.Burst), 1))
+	}
+	res, err := tokenBucketScript.Run(ctx, client, keys, args...).Int64Slice()
+	if err != nil {
+		return -1, 0, err
+	}
+	if len(res) != 2 || res[0] == 0 {
+		return -1, 0, nil
+	}
+	return int(res[0]) - 1, time.Duration(res[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import "testing"
+
+func TestLimiterBudgets(t *testing.T) {
+	l := NewLimiter()
+	l.SetConfig(Config{
+		Enabled: true,
+		AccessKey: ScopeLimits{
+			Default: Budgets{Write: &Limit{Rate: 1, Burst: 2}},
+			Overrides: map[string]Budgets{
+				"VIP": {Write: &Limit{Rate: 100, Burst: 100}},
+			},
+		},
+	})
+
+	req := Request{AccessKey: "AK1", IP: "10.0.0.1", Class: ClassWrite}
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := l.Allow(req); !ok {
+			t.Fatalf("request %d within burst was throttled", i+1)
+		}
+	}
+	ok, scope, wait := l.Allow(req)
+	if ok || scope != ScopeAccessKey || wait <= 0 {
+		t.Fatalf("expected throttling by access key, got ok=%v scope=%q wait=%v", ok, scope, wait)
+	}
+
+	// Reads have their own (unlimited) budget
+	if ok, _, _ := l.Allow(Request{AccessKey: "AK1", Class: ClassRead}); !ok {
+		t.Error("read was throttled by the write budget")
+	}
+
+	// Overrides replace the default
+	vip := Request{AccessKey: "VIP", Class: ClassWrite}
+	for i := 0; i < 10; i++ {
+		if ok, _, _ := l.Allow(vip); !ok {
+			t.Fatalf("override request %d was throttled", i+1)
+		}
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := NewLimiter()
+	l.SetConfig(Config{IP: ScopeLimits{Default: Budgets{Read: &Limit{Rate: 1, Burst: 1}}}})
+	for i := 0; i < 5; i++ {
+		if ok, _, _ := l.Allow(Request{IP: "10.0.0.1", Class: ClassRead}); !ok {
+			t.Fatal("disabled limiter throttled a request")
+		}
+	}
+}
+
+func TestLimiterTakesNoTokensWhenThrottled(t *testing.T) {
+	l := NewLimiter()
+	l.SetConfig(Config{
+		Enabled:   true,
+		IP:        ScopeLimits{Default: Budgets{Read: &Limit{Rate: 0.001, Burst: 3}}},
+		AccessKey: ScopeLimits{Default: Budgets{Read: &Limit{Rate: 0.001, Burst: 1}}},
+	})
+
+	if ok, _, _ := l.Allow(Request{IP: "10.0.0.1", AccessKey: "AK1", Class: ClassRead}); !ok {
+		t.Fatal("first request was throttled")
+	}
+	// Throttled by the access key; the IP budget must not pay for the rejected requests
+	for i := 0; i < 5; i++ {
+		if ok, scope, _ := l.Allow(Request{IP: "10.0.0.1", AccessKey: "AK1", Class: ClassRead}); ok || scope != ScopeAccessKey {
+			t.Fatalf("request %d: ok=%v scope=%q, want throttling by access key", i+1, ok, scope)
+		}
+	}
+	for _, key := range []string{"AK2", "AK3"} {
+		if ok, _, _ := l.Allow(Request{IP: "10.0.0.1", AccessKey: key, Class: ClassRead}); !ok {
+			t.Fatalf("request of %s was throttled by the IP budget", key)
+		}
+	}
+	if ok, scope, _ := l.Allow(Request{IP: "10.0.0.1", AccessKey: "AK4", Class: ClassRead}); ok || scope != ScopeIP {
+		t.Errorf("ok=%v scope=%q, want throttling by IP once its burst is used", ok, scope)
+	}
+}
+
+func TestLimiterKeepsBucketsAcrossSetConfig(t *testing.T) {
+	cfg := Config{Enabled: true, IP: ScopeLimits{Default: Budgets{Write: &Limit{Rate: 0.001, Burst: 1}}}}
+	l := NewLimiter()
+	l.SetConfig(cfg)
+	req := Request{IP: "10.0.0.1", Class: ClassWrite}
+	if ok, _, _ := l.Allow(req); !ok {
+		t.Fatal("first request was throttled")
+	}
+
+	// Reloading the same limits keeps the client throttled
+	l.SetConfig(cfg)
+	if ok, _, _ := l.Allow(req); ok {
+		t.Fatal("unchanged limits were refilled by SetConfig")
+	}
+
+	// A changed limit starts a full bucket
+	l.SetConfig(Config{Enabled: true, IP: ScopeLimits{Default: Budgets{Write: &Limit{Rate: 0.001, Burst: 2}}}})
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := l.Allow(req); !ok {
+			t.Fatalf("request %d under the new burst was throttled", i+1)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/GravSpace/GravSpace/internal/auth"
+	"github.com/GravSpace/GravSpace/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware throttles S3 requests by the given scopes. The IP scope does not depend on the
+// caller and is mounted before S3AuthMiddleware, so floods of unauthenticated requests are
+// throttled before their signatures are checked. The access key and user scopes must run
+// after S3AuthMiddleware, which resolves them.
+func Middleware(l *Limiter, scopes ...string) gin.HandlerFunc {
+	enabled := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		enabled[scope] = true
+	}
+	return func(c *gin.Context) {
+		req := Request{Class: requestClass(c)}
+		if enabled[ScopeIP] {
+			req.IP = c.ClientIP()
+		}
+		if enabled[ScopeBucket] {
+			req.Bucket = c.Param("bucket")
+		}
+		if enabled[ScopeAccessKey] {
+			req.AccessKey = c.GetString("access_key")
+		}
+		if u, ok := c.Get("user"); ok && enabled[ScopeUser] {
+			if user, ok := u.(*auth.User); ok {
+				req.User = user.Username
+			}
+		}
+
+		allowed, scope, wait := l.Allow(req)
+		if !allowed {
+			metrics.RecordRateLimited(scope, req.Class)
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			auth.SendS3ErrorStatus(c, http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.", c.Param("bucket"), c.Param("key"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func requestClass(c *gin.Context) string {
+	// Website requests only read, including the index document of a bucket
+	if strings.HasPrefix(c.FullPath(), "/website/") {
+		return ClassRead
+	}
+	operation, _ := auth.S3RequestLabels(c)
+	switch operation {
+	case "s3:ListBucket", "s3:ListAllMyBuckets":
+		return ClassList
+	}
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		return ClassRead
+	}
+	return ClassWrite
+}
@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GravSpace/GravSpace/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMiddlewareScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := NewLimiter()
+	l.SetConfig(Config{
+		Enabled:   true,
+		IP:        ScopeLimits{Default: Budgets{Read: &Limit{Rate: 0.001, Burst: 2}}},
+		AccessKey: ScopeLimits{Default: Budgets{Read: &Limit{Rate: 0.001, Burst: 1}}},
+	})
+
+	// Rejects every request, as S3AuthMiddleware does for bad signatures
+	denyAll := func(c *gin.Context) {
+		auth.SendS3ErrorStatus(c, http.StatusForbidden, "SignatureDoesNotMatch", "", "", "")
+		c.Abort()
+	}
+	r := gin.New()
+	s3 := r.Group("", Middleware(l, ScopeIP), denyAll, Middleware(l, ScopeAccessKey, ScopeUser, ScopeBucket))
+	s3.GET("/:bucket/*key", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.Group("/website", Middleware(l, ScopeIP, ScopeBucket)).GET("/:bucket", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func(path, ip string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	// Unauthenticated requests are throttled by IP before authentication
+	for i, want := range []int{http.StatusForbidden, http.StatusForbidden, http.StatusServiceUnavailable} {
+		if got := get("/photos/a.jpg", "192.0.2.1"); got != want {
+			t.Errorf("request %d = %d, want %d", i+1, got, want)
+		}
+	}
+	// Website requests share the IP budget
+	if got := get("/website/photos", "192.0.2.1"); got != http.StatusServiceUnavailable {
+		t.Errorf("website request = %d, want throttling by IP", got)
+	}
+	if got := get("/website/photos", "192.0.2.2"); got != http.StatusOK {
+		t.Errorf("website request from another IP = %d", got)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"mime"
 	"net/http"
@@ -20,6 +22,8 @@ import (
 	"github.com/GravSpace/GravSpace/internal/audit"
 	"github.com/GravSpace/GravSpace/internal/auth"
 	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/jobs"
+	"github.com/GravSpace/GravSpace/internal/ratelimit"
 	"github.com/GravSpace/GravSpace/internal/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -29,11 +33,30 @@ type AdminHandler struct {
 	UserManager *auth.UserManager
 	Storage     storage.Storage
 	S3Port      string
+	// Region signs presigned URLs; us-east-1 when empty
+	Region string
+	// ServerHost is the host[:port] of presigned URLs; localhost and S3Port when empty
+	ServerHost  string
 	AuditLogger *audit.AuditLogger
+	RateLimiter *ratelimit.Limiter
+	// AuditRetention archives and removes old audit entries; nil without audit logging
+	AuditRetention *audit.RetentionWorker
+}
+
+func (h *AdminHandler) region() string {
+	if h.Region == "" {
+		return "us-east-1"
+	}
+	return h.Region
+}
+
+// store returns the storage bound to the request's trace
+func (h *AdminHandler) store(c *gin.Context) storage.Storage {
+	return tracedStorage(h.Storage, c)
 }
 
 func (h *AdminHandler) ListBuckets(c *gin.Context) {
-	buckets, err := h.Storage.ListBuckets()
+	buckets, err := h.store(c).ListBuckets()
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -43,37 +66,37 @@ func (h *AdminHandler) ListBuckets(c *gin.Context) {
 
 func (h *AdminHandler) CreateBucket(c *gin.Context) {
 	bucket := c.Param("bucket")
-	if err := h.Storage.CreateBucket(bucket); err != nil {
+	if err := h.store(c).CreateBucket(bucket); err != nil {
 		if h.AuditLogger != nil {
-			h.AuditLogger.LogDenied("admin", "s3:CreateBucket", bucket, c.ClientIP(), c.GetHeader("User-Agent"), err.Error())
+			h.AuditLogger.LogDenied(c.Request.Context(), "admin", "s3:CreateBucket", bucket, c.ClientIP(), c.GetHeader("User-Agent"), err.Error())
 		}
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 	if h.AuditLogger != nil {
-		h.AuditLogger.LogSuccess("admin", "s3:CreateBucket", bucket, c.ClientIP(), c.GetHeader("User-Agent"), nil)
+		h.AuditLogger.LogSuccess(c.Request.Context(), "admin", "s3:CreateBucket", bucket, c.ClientIP(), c.GetHeader("User-Agent"), nil)
 	}
 	c.Status(http.StatusCreated)
 }
 
 func (h *AdminHandler) DeleteBucket(c *gin.Context) {
 	bucket := c.Param("bucket")
-	if err := h.Storage.DeleteBucket(bucket); err != nil {
+	if err := h.store(c).DeleteBucket(bucket); err != nil {
 		if h.AuditLogger != nil {
-			h.AuditLogger.LogDenied("admin", "s3:DeleteBucket", bucket, c.ClientIP(), c.GetHeader("User-Agent"), err.Error())
+			h.AuditLogger.LogDenied(c.Request.Context(), "admin", "s3:DeleteBucket", bucket, c.ClientIP(), c.GetHeader("User-Agent"), err.Error())
 		}
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 	if h.AuditLogger != nil {
-		h.AuditLogger.LogSuccess("admin", "s3:DeleteBucket", bucket, c.ClientIP(), c.GetHeader("User-Agent"), nil)
+		h.AuditLogger.LogSuccess(c.Request.Context(), "admin", "s3:DeleteBucket", bucket, c.ClientIP(), c.GetHeader("User-Agent"), nil)
 	}
 	c.Status(http.StatusOK)
 }
 
 func (h *AdminHandler) GetBucketInfo(c *gin.Context) {
 	bucket := c.Param("bucket")
-	info, err := h.Storage.GetBucketInfo(bucket)
+	info, err := h.store(c).GetBucketInfo(bucket)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -84,7 +107,7 @@ func (h *AdminHandler) GetBucketInfo(c *gin.Context) {
 	}
 
 	// Get current size
-	_, currentSize, _ := h.Storage.GetBucketStats(bucket)
+	_, currentSize, _ := h.store(c).GetBucketStats(bucket)
 
 	c.JSON(http.StatusOK, gin.H{
 		"Name":                 info.Name,
@@ -97,6 +120,8 @@ func (h *AdminHandler) GetBucketInfo(c *gin.Context) {
 		"SoftDeleteEnabled":    info.SoftDeleteEnabled,
 		"SoftDeleteRetention":  info.SoftDeleteRetention,
 		"QuotaBytes":           info.QuotaBytes,
+		"ErasureDataShards":    info.ErasureDataShards,
+		"ErasureParityShards":  info.ErasureParityShards,
 		"CurrentSize":          currentSize,
 	})
 }
@@ -110,7 +135,7 @@ func (h *AdminHandler) SetBucketVersioning(c *gin.Context) {
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
-	if err := h.Storage.SetBucketVersioning(bucket, req.Enabled); err != nil {
+	if err := h.store(c).SetBucketVersioning(bucket, req.Enabled); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -126,7 +151,7 @@ func (h *AdminHandler) SetBucketObjectLock(c *gin.Context) {
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
-	if err := h.Storage.SetBucketObjectLock(bucket, req.Enabled); err != nil {
+	if err := h.store(c).SetBucketObjectLock(bucket, req.Enabled); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -143,7 +168,7 @@ func (h *AdminHandler) SetBucketDefaultRetention(c *gin.Context) {
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
-	if err := h.Storage.SetBucketDefaultRetention(bucket, req.Mode, req.Days); err != nil {
+	if err := h.store(c).SetBucketDefaultRetention(bucket, req.Mode, req.Days); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -159,13 +184,34 @@ func (h *AdminHandler) SetBucketQuota(c *gin.Context) {
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
-	if err := h.Storage.SetBucketQuota(bucket, req.QuotaBytes); err != nil {
+	if err := h.store(c).SetBucketQuota(bucket, req.QuotaBytes); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 	c.Status(http.StatusOK)
 }
 
+func (h *AdminHandler) SetBucketErasure(c *gin.Context) {
+	bucket := c.Param("bucket")
+	var req struct {
+		DataShards   int `json:"data_shards"`
+		ParityShards int `json:"parity_shards"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request")
+		return
+	}
+	if err := h.store(c).SetBucketErasure(bucket, req.DataShards, req.ParityShards); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, storage.ErrErasureLayout) {
+			status = http.StatusBadRequest
+		}
+		c.String(status, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
 func (h *AdminHandler) SetObjectRetention(c *gin.Context) {
 	bucket := c.Param("bucket")
 	key := c.Query("key")
@@ -191,7 +237,7 @@ func (h *AdminHandler) SetObjectRetention(c *gin.Context) {
 		return
 	}
 
-	if err := h.Storage.SetObjectRetention(bucket, key, versionID, retainUntil, req.Mode); err != nil {
+	if err := h.store(c).SetObjectRetention(bucket, key, versionID, retainUntil, req.Mode); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -212,7 +258,7 @@ func (h *AdminHandler) SetObjectLegalHold(c *gin.Context) {
 		return
 	}
 
-	if err := h.Storage.SetObjectLegalHold(bucket, key, versionID, req.Hold, req.Reason); err != nil {
+	if err := h.store(c).SetObjectLegalHold(bucket, key, versionID, req.Hold, req.Reason); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -226,14 +272,14 @@ func (h *AdminHandler) ListObjects(c *gin.Context) {
 	search := c.Query("search")
 
 	if c.Query("versions") != "" {
-		objects, commonPrefixes, err := h.Storage.ListObjects(bucket, prefix, delimiter, search)
+		objects, commonPrefixes, err := h.store(c).ListObjects(bucket, prefix, delimiter, search)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 		var allVersions []storage.Object
 		for _, o := range objects {
-			versions, _ := h.Storage.ListVersions(bucket, o.Key)
+			versions, _ := h.store(c).ListVersions(bucket, o.Key)
 			allVersions = append(allVersions, versions...)
 		}
 		c.JSON(http.StatusOK, gin.H{
@@ -243,7 +289,7 @@ func (h *AdminHandler) ListObjects(c *gin.Context) {
 		return
 	}
 
-	objects, commonPrefixes, err := h.Storage.ListObjects(bucket, prefix, delimiter, search)
+	objects, commonPrefixes, err := h.store(c).ListObjects(bucket, prefix, delimiter, search)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -260,7 +306,11 @@ func (h *AdminHandler) GetObject(c *gin.Context) {
 	key := c.Param("key")
 	versionID := c.Query("versionId")
 
-	reader, obj, err := h.Storage.GetObject(bucket, key, versionID)
+	reader, obj, err := h.store(c).GetObject(bucket, key, versionID)
+	if errors.Is(err, storage.ErrObjectCorrupted) {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
 	if err != nil {
 		fmt.Printf("DEBUG: GetObject failed for bucket=%s, key=%s, err=%v\n", bucket, key, err)
 		c.String(http.StatusNotFound, fmt.Sprintf("Object not found: %v", err))
@@ -291,17 +341,17 @@ func (h *AdminHandler) PutObject(c *gin.Context) {
 
 	log.Printf("Admin PUT Object: bucket=%s, key=%s", bucket, key)
 
-	vid, err := h.Storage.PutObject(bucket, key, c.Request.Body, "")
+	vid, err := h.store(c).PutObject(bucket, key, c.Request.Body, "")
 	if err != nil {
 		log.Printf("Error in PutObject: %v", err)
 		if h.AuditLogger != nil {
-			h.AuditLogger.LogDenied("admin", "s3:PutObject", bucket+"/"+key, c.ClientIP(), c.GetHeader("User-Agent"), err.Error())
+			h.AuditLogger.LogDenied(c.Request.Context(), "admin", "s3:PutObject", bucket+"/"+key, c.ClientIP(), c.GetHeader("User-Agent"), err.Error())
 		}
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 	if h.AuditLogger != nil {
-		h.AuditLogger.LogSuccess("admin", "s3:PutObject", bucket+"/"+key, c.ClientIP(), c.GetHeader("User-Agent"), map[string]string{"version_id": vid})
+		h.AuditLogger.LogSuccess(c.Request.Context(), "admin", "s3:PutObject", bucket+"/"+key, c.ClientIP(), c.GetHeader("User-Agent"), map[string]string{"version_id": vid})
 	}
 	c.Header("x-amz-version-id", vid)
 	log.Printf("Successfully put object: %s/%s, version: %s", bucket, key, vid)
@@ -313,7 +363,11 @@ func (h *AdminHandler) DownloadObject(c *gin.Context) {
 	key := c.Param("key")
 	versionID := c.Query("versionId")
 
-	reader, obj, err := h.Storage.GetObject(bucket, key, versionID)
+	reader, obj, err := h.store(c).GetObject(bucket, key, versionID)
+	if errors.Is(err, storage.ErrObjectCorrupted) {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
 	if err != nil {
 		fmt.Printf("DEBUG: DownloadObject failed for bucket=%s, key=%s, err=%v\n", bucket, key, err)
 		c.String(http.StatusNotFound, fmt.Sprintf("Object not found: %v", err))
@@ -321,7 +375,7 @@ func (h *AdminHandler) DownloadObject(c *gin.Context) {
 	}
 
 	if h.AuditLogger != nil {
-		h.AuditLogger.LogSuccess("admin", "s3:GetObject", bucket+"/"+key, c.ClientIP(), c.GetHeader("User-Agent"), nil)
+		h.AuditLogger.LogSuccess(c.Request.Context(), "admin", "s3:GetObject", bucket+"/"+key, c.ClientIP(), c.GetHeader("User-Agent"), nil)
 	}
 
 	contentType := mime.TypeByExtension(filepath.Ext(key))
@@ -341,15 +395,15 @@ func (h *AdminHandler) DeleteObject(c *gin.Context) {
 	versionID := c.Query("versionId")
 	bypass := c.Query("bypassGovernance") != "false" // Admin by default bypasses unless explicitly false
 
-	if err := h.Storage.DeleteObject(bucket, key, versionID, bypass); err != nil {
+	if err := h.store(c).DeleteObject(bucket, key, versionID, bypass); err != nil {
 		if h.AuditLogger != nil {
-			h.AuditLogger.LogDenied("admin", "s3:DeleteObject", bucket+"/"+key, c.ClientIP(), c.GetHeader("User-Agent"), err.Error())
+			h.AuditLogger.LogDenied(c.Request.Context(), "admin", "s3:DeleteObject", bucket+"/"+key, c.ClientIP(), c.GetHeader("User-Agent"), err.Error())
 		}
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 	if h.AuditLogger != nil {
-		h.AuditLogger.LogSuccess("admin", "s3:DeleteObject", bucket+"/"+key, c.ClientIP(), c.GetHeader("User-Agent"), nil)
+		h.AuditLogger.LogSuccess(c.Request.Context(), "admin", "s3:DeleteObject", bucket+"/"+key, c.ClientIP(), c.GetHeader("User-Agent"), nil)
 	}
 	c.Status(http.StatusNoContent)
 }
@@ -359,7 +413,7 @@ func (h *AdminHandler) GetObjectTagging(c *gin.Context) {
 	key := c.Param("key")
 	versionID := c.Query("versionId")
 
-	tags, err := h.Storage.GetObjectTagging(bucket, key, versionID)
+	tags, err := h.store(c).GetObjectTagging(bucket, key, versionID)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -378,7 +432,7 @@ func (h *AdminHandler) PutObjectTagging(c *gin.Context) {
 		return
 	}
 
-	if err := h.Storage.PutObjectTagging(bucket, key, versionID, tags); err != nil {
+	if err := h.store(c).PutObjectTagging(bucket, key, versionID, tags); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -496,7 +550,7 @@ func (h *AdminHandler) AttachPolicyTemplate(c *gin.Context) {
 var startTime = time.Now()
 
 func (h *AdminHandler) GetSystemStats(c *gin.Context) {
-	count, size, err := h.Storage.GetGlobalStats()
+	count, size, err := h.store(c).GetGlobalStats()
 	if err != nil {
 		count, size = 0, 0
 	}
@@ -546,7 +600,7 @@ func (h *AdminHandler) GeneratePresignURL(c *gin.Context) {
 
 	now := time.Now().UTC().Format("20060102T150405Z")
 	date := now[:8]
-	region := "us-east-1"
+	region := h.region()
 	service := "s3"
 	credentialScope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
 	algorithm := "AWS4-HMAC-SHA256"
@@ -613,7 +667,7 @@ func (h *AdminHandler) GetObjectLegalHold(c *gin.Context) {
 	key := c.Param("key")
 	versionID := c.Query("versionId")
 
-	obj, err := h.Storage.StatObject(bucket, key, versionID)
+	obj, err := h.store(c).StatObject(bucket, key, versionID)
 	if err != nil {
 		c.String(http.StatusNotFound, err.Error())
 		return
@@ -678,6 +732,33 @@ func (h *AdminHandler) DeleteWebhook(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+// auditFilterFromQuery reads the audit log filters shared by listing, export and streaming
+func auditFilterFromQuery(c *gin.Context) (database.AuditLogFilter, error) {
+	filter := database.AuditLogFilter{
+		Username:       c.Query("user"),
+		Action:         c.Query("action"),
+		ResourcePrefix: c.Query("resource"),
+		Result:         c.Query("result"),
+		IP:             c.Query("ip"),
+		Search:         c.Query("q"),
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from time, expected RFC 3339")
+		}
+		filter.Since = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to time, expected RFC 3339")
+		}
+		filter.Until = &t
+	}
+	return filter, nil
+}
+
 func (h *AdminHandler) GetAuditLogs(c *gin.Context) {
 	limitStr := c.Query("limit")
 	offsetStr := c.Query("offset")
@@ -688,8 +769,14 @@ func (h *AdminHandler) GetAuditLogs(c *gin.Context) {
 	}
 	offset, _ := strconv.Atoi(offsetStr)
 
+	filter, err := auditFilterFromQuery(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
 	db := h.Storage.(*storage.FileStorage).DB
-	logs, err := db.ListAuditLogs(limit, offset)
+	logs, err := db.ListAuditLogs(filter, limit, offset)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -698,6 +785,261 @@ func (h *AdminHandler) GetAuditLogs(c *gin.Context) {
 	c.JSON(http.StatusOK, logs)
 }
 
+// ExportAuditLogs sends every audit entry matching the filters as CSV or JSON lines
+func (h *AdminHandler) ExportAuditLogs(c *gin.Context) {
+	filter, err := auditFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", audit.FormatJSONL)
+	contentType := "application/x-ndjson"
+	if format == audit.FormatCSV {
+		contentType = "text/csv"
+	}
+
+	// Write the export to a temporary file first: the local database has a single connection,
+	// and a query left open while a slow client reads would block every audit entry logged
+	tmp, err := os.CreateTemp("", "audit-export-*")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	exporter, err := audit.NewExporter(tmp, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	db := h.Storage.(*storage.FileStorage).DB
+	err = db.ForEachAuditLog(filter, func(l *database.AuditLogRecord) error {
+		return exporter.Write(l)
+	})
+	if err == nil {
+		err = exporter.Flush()
+	}
+	var size int64
+	if err == nil {
+		size, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("audit export failed: %v", err)})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.%s"`, time.Now().UTC().Format("20060102-150405"), format))
+	c.DataFromReader(http.StatusOK, size, contentType, tmp, nil)
+}
+
+func (h *AdminHandler) GetAuditRetention(c *gin.Context) {
+	if h.AuditRetention == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audit logging is not enabled"})
+		return
+	}
+	c.JSON(http.StatusOK, h.AuditRetention.Config())
+}
+
+func (h *AdminHandler) UpdateAuditRetention(c *gin.Context) {
+	if h.AuditRetention == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audit logging is not enabled"})
+		return
+	}
+	var config audit.RetentionConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid retention policy"})
+		return
+	}
+	if config.Enabled {
+		if exists, err := h.store(c).BucketExists(config.ArchiveBucket); err != nil || !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Archive bucket does not exist"})
+			return
+		}
+	}
+	if err := h.AuditRetention.SetConfig(config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, config)
+}
+
+// RunAuditRetention archives old entries right away instead of waiting for the hourly run
+func (h *AdminHandler) RunAuditRetention(c *gin.Context) {
+	if h.AuditRetention == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audit logging is not enabled"})
+		return
+	}
+	result, err := h.AuditRetention.Run()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// VerifyAuditLog walks the audit hash chain and reports the first broken link
+func (h *AdminHandler) VerifyAuditLog(c *gin.Context) {
+	if h.AuditLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audit logging is not enabled"})
+		return
+	}
+	result, err := h.AuditLogger.Verify()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// RunFsck checks the metadata database against the data directory. GET only reports the
+// problems; POST /fsck/repair also repairs them. quick=true skips verifying content hashes.
+func (h *AdminHandler) RunFsck(c *gin.Context) {
+	opts := storage.FsckOptions{
+		Repair: strings.HasSuffix(c.FullPath(), "/repair"),
+		Quick:  c.Query("quick") == "true",
+	}
+	if age := c.Query("upload_age"); age != "" {
+		d, err := time.ParseDuration(age)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "upload_age must be a positive duration such as 48h"})
+			return
+		}
+		opts.StaleUploadAge = d
+	}
+
+	report, err := h.Storage.(*storage.FileStorage).Fsck(opts)
+	if errors.Is(err, storage.ErrFsckRunning) || errors.Is(err, storage.ErrDrivesOffline) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if opts.Repair && h.AuditLogger != nil {
+		h.AuditLogger.LogSuccess(c.Request.Context(), "admin", "admin:RepairStorage", "fsck", c.ClientIP(), c.GetHeader("User-Agent"),
+			map[string]string{"issues": strconv.Itoa(len(report.Issues)), "repaired": strconv.Itoa(report.Repaired)})
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// GetScrubStatus reports the progress of the scrubber and the blobs it quarantined
+func (h *AdminHandler) GetScrubStatus(c *gin.Context) {
+	store := h.Storage.(*storage.FileStorage)
+	quarantined, err := store.DB.ListQuarantinedBlobs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if quarantined == nil {
+		quarantined = []database.QuarantinedBlobRow{}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": store.Scrubber.Status(), "quarantined": quarantined})
+}
+
+// RunScrub starts a scrub pass without waiting for the scrub interval
+func (h *AdminHandler) RunScrub(c *gin.Context) {
+	store := h.Storage.(*storage.FileStorage)
+	if err := store.Scrubber.RunNow(); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if h.AuditLogger != nil {
+		h.AuditLogger.LogSuccess(c.Request.Context(), "admin", "admin:RunScrub", "scrub", c.ClientIP(), c.GetHeader("User-Agent"), nil)
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Scrub pass started"})
+}
+
+// GetDrives reports the state of the data drives and of the running or last drive heal
+func (h *AdminHandler) GetDrives(c *gin.Context) {
+	drives := h.Storage.(*storage.FileStorage).Drives
+	c.JSON(http.StatusOK, gin.H{
+		"drives":  drives.Drives(),
+		"copies":  drives.Copies(),
+		"healing": drives.Healing(),
+		"heal":    drives.LastHeal(),
+	})
+}
+
+// HealDrives queues a heal that restores the copies of every blob, e.g. after a drive was
+// replaced while the server kept running
+func (h *AdminHandler) HealDrives(c *gin.Context) {
+	store := h.Storage.(*storage.FileStorage)
+	if store.Drives.Healing() {
+		c.JSON(http.StatusConflict, gin.H{"error": storage.ErrHealRunning.Error()})
+		return
+	}
+	id, err := store.StartDriveHeal("requested by an administrator")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if h.AuditLogger != nil {
+		h.AuditLogger.LogSuccess(c.Request.Context(), "admin", "admin:HealDrives", "drives", c.ClientIP(), c.GetHeader("User-Agent"), nil)
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Drive heal queued", "job_id": id})
+}
+
+func (h *AdminHandler) ListAuditCheckpoints(c *gin.Context) {
+	db := h.Storage.(*storage.FileStorage).DB
+	checkpoints, err := db.ListAuditCheckpoints()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, checkpoints)
+}
+
+// CreateAuditCheckpoint signs the current head of the audit chain right away
+func (h *AdminHandler) CreateAuditCheckpoint(c *gin.Context) {
+	if h.AuditLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audit logging is not enabled"})
+		return
+	}
+	cp, err := h.AuditLogger.Checkpoint()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if cp == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusCreated, cp)
+}
+
+func (h *AdminHandler) GetAuditSinks(c *gin.Context) {
+	if h.AuditLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audit logging is not enabled"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sinks": h.AuditLogger.SinkConfigs(), "status": h.AuditLogger.SinkStatuses()})
+}
+
+// UpdateAuditSinks replaces the audit sink configuration; it takes effect immediately
+func (h *AdminHandler) UpdateAuditSinks(c *gin.Context) {
+	if h.AuditLogger == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audit logging is not enabled"})
+		return
+	}
+	var req struct {
+		Sinks []audit.SinkConfig `json:"sinks"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sink configuration"})
+		return
+	}
+	if err := h.AuditLogger.SetSinks(req.Sinks); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sinks": h.AuditLogger.SinkConfigs(), "status": h.AuditLogger.SinkStatuses()})
+}
+
 func (h *AdminHandler) GetStorageAnalytics(c *gin.Context) {
 	daysStr := c.Query("days")
 	days, _ := strconv.Atoi(daysStr)
@@ -716,7 +1058,7 @@ func (h *AdminHandler) GetStorageAnalytics(c *gin.Context) {
 }
 
 func (h *AdminHandler) GetContentTypeBreakdown(c *gin.Context) {
-	breakdown, err := h.Storage.GetContentTypeBreakdown()
+	breakdown, err := h.store(c).GetContentTypeBreakdown()
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -743,7 +1085,7 @@ func (h *AdminHandler) GetActionAnalytics(c *gin.Context) {
 
 func (h *AdminHandler) GetBucketCors(c *gin.Context) {
 	bucket := c.Param("bucket")
-	config, err := h.Storage.GetBucketCors(bucket)
+	config, err := h.store(c).GetBucketCors(bucket)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			c.JSON(http.StatusOK, []interface{}{})
@@ -763,7 +1105,7 @@ func (h *AdminHandler) PutBucketCors(c *gin.Context) {
 		return
 	}
 
-	if err := h.Storage.PutBucketCors(bucket, config); err != nil {
+	if err := h.store(c).PutBucketCors(bucket, config); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -772,7 +1114,7 @@ func (h *AdminHandler) PutBucketCors(c *gin.Context) {
 
 func (h *AdminHandler) DeleteBucketCors(c *gin.Context) {
 	bucket := c.Param("bucket")
-	if err := h.Storage.DeleteBucketCors(bucket); err != nil {
+	if err := h.store(c).DeleteBucketCors(bucket); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -781,7 +1123,7 @@ func (h *AdminHandler) DeleteBucketCors(c *gin.Context) {
 
 func (h *AdminHandler) GetBucketWebsite(c *gin.Context) {
 	bucket := c.Param("bucket")
-	config, err := h.Storage.GetBucketWebsite(bucket)
+	config, err := h.store(c).GetBucketWebsite(bucket)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			c.JSON(http.StatusOK, nil)
@@ -804,8 +1146,12 @@ func (h *AdminHandler) SetBucketWebsite(c *gin.Context) {
 		c.String(http.StatusBadRequest, "Invalid website configuration")
 		return
 	}
+	if err := config.Validate(); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
 
-	if err := h.Storage.PutBucketWebsite(bucket, config); err != nil {
+	if err := h.store(c).PutBucketWebsite(bucket, config); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -814,13 +1160,81 @@ func (h *AdminHandler) SetBucketWebsite(c *gin.Context) {
 
 func (h *AdminHandler) DeleteBucketWebsite(c *gin.Context) {
 	bucket := c.Param("bucket")
-	if err := h.Storage.DeleteBucketWebsite(bucket); err != nil {
+	if err := h.store(c).DeleteBucketWebsite(bucket); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 	c.Status(http.StatusOK)
 }
 
+func (h *AdminHandler) GetBucketLogging(c *gin.Context) {
+	config, err := h.store(c).GetBucketLogging(c.Param("bucket"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if config == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "target_bucket": config.TargetBucket, "target_prefix": config.TargetPrefix})
+}
+
+// SetBucketLogging enables server access logging; an empty target bucket disables it
+func (h *AdminHandler) SetBucketLogging(c *gin.Context) {
+	var config storage.BucketLoggingConfiguration
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid logging configuration"})
+		return
+	}
+	if err := h.store(c).PutBucketLogging(c.Param("bucket"), &config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (h *AdminHandler) ListBucketInventories(c *gin.Context) {
+	inventories, err := h.store(c).ListBucketInventories(c.Param("bucket"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, inventories)
+}
+
+func (h *AdminHandler) PutBucketInventory(c *gin.Context) {
+	bucket := c.Param("bucket")
+	var config storage.InventoryConfiguration
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid inventory configuration"})
+		return
+	}
+	config.ID = c.Param("id")
+	if err := h.store(c).PutBucketInventory(bucket, config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (h *AdminHandler) DeleteBucketInventory(c *gin.Context) {
+	if err := h.store(c).DeleteBucketInventory(c.Param("bucket"), c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// RunBucketInventory writes an inventory report now instead of waiting for its schedule
+func (h *AdminHandler) RunBucketInventory(c *gin.Context) {
+	if err := h.store(c).RunBucketInventory(c.Param("bucket"), c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
 func (h *AdminHandler) SetBucketSoftDelete(c *gin.Context) {
 	bucket := c.Param("bucket")
 	var req struct {
@@ -832,7 +1246,7 @@ func (h *AdminHandler) SetBucketSoftDelete(c *gin.Context) {
 		c.String(http.StatusBadRequest, fmt.Sprintf("Invalid request: %v", err))
 		return
 	}
-	if err := h.Storage.SetBucketSoftDelete(bucket, req.Enabled, req.RetentionDays); err != nil {
+	if err := h.store(c).SetBucketSoftDelete(bucket, req.Enabled, req.RetentionDays); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -842,7 +1256,7 @@ func (h *AdminHandler) SetBucketSoftDelete(c *gin.Context) {
 func (h *AdminHandler) ListTrash(c *gin.Context) {
 	bucket := c.Query("bucket")
 	search := c.Query("search")
-	objects, err := h.Storage.ListTrash(bucket, search)
+	objects, err := h.store(c).ListTrash(bucket, search)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -852,7 +1266,10 @@ func (h *AdminHandler) ListTrash(c *gin.Context) {
 
 func (h *AdminHandler) EmptyTrash(c *gin.Context) {
 	bucket := c.Query("bucket")
-	if err := h.Storage.EmptyTrash(bucket); err != nil {
+	if h.enqueueBulkOperation(c, &storage.BulkOperationJob{Operation: storage.BulkOpEmptyTrash, Bucket: bucket}) {
+		return
+	}
+	if err := h.store(c).EmptyTrash(bucket); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -869,7 +1286,7 @@ func (h *AdminHandler) RestoreObject(c *gin.Context) {
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
-	if err := h.Storage.RestoreObject(req.Bucket, req.Key, req.VersionID); err != nil {
+	if err := h.store(c).RestoreObject(req.Bucket, req.Key, req.VersionID); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -887,7 +1304,7 @@ func (h *AdminHandler) DeleteTrashObject(c *gin.Context) {
 	}
 
 	// Permanent deletion from trash
-	if err := h.Storage.DeleteTrashObject(bucket, key, versionID); err != nil {
+	if err := h.store(c).DeleteTrashObject(bucket, key, versionID); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -896,38 +1313,36 @@ func (h *AdminHandler) DeleteTrashObject(c *gin.Context) {
 
 func (h *AdminHandler) BulkRestoreObjects(c *gin.Context) {
 	var req struct {
-		Items []struct {
-			Bucket    string `json:"bucket"`
-			Key       string `json:"key"`
-			VersionID string `json:"versionId"`
-		} `json:"items"`
+		Items []storage.BulkItem `json:"items"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
+	if h.enqueueBulkOperation(c, &storage.BulkOperationJob{Operation: storage.BulkOpRestore, Items: req.Items}) {
+		return
+	}
 
 	for _, item := range req.Items {
-		h.Storage.RestoreObject(item.Bucket, item.Key, item.VersionID)
+		h.store(c).RestoreObject(item.Bucket, item.Key, item.VersionID)
 	}
 	c.Status(http.StatusOK)
 }
 
 func (h *AdminHandler) BulkDeleteTrashObjects(c *gin.Context) {
 	var req struct {
-		Items []struct {
-			Bucket    string `json:"bucket"`
-			Key       string `json:"key"`
-			VersionID string `json:"versionId"`
-		} `json:"items"`
+		Items []storage.BulkItem `json:"items"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.String(http.StatusBadRequest, "Invalid request")
 		return
 	}
+	if h.enqueueBulkOperation(c, &storage.BulkOperationJob{Operation: storage.BulkOpPurge, Items: req.Items}) {
+		return
+	}
 
 	for _, item := range req.Items {
-		h.Storage.DeleteTrashObject(item.Bucket, item.Key, item.VersionID)
+		h.store(c).DeleteTrashObject(item.Bucket, item.Key, item.VersionID)
 	}
 
 	// Notify if significant number of items deleted
@@ -1023,7 +1438,7 @@ func (h *AdminHandler) GeneratePresignedURL(bucket, key, versionID string, expir
 	accessKey := keys[0].AccessKeyID
 	secretKey := keys[0].SecretAccessKey
 
-	region := "us-east-1"
+	region := h.region()
 	date := time.Now().UTC()
 	algorithm := "AWS4-HMAC-SHA256"
 	service := "s3"
@@ -1034,8 +1449,8 @@ func (h *AdminHandler) GeneratePresignedURL(bucket, key, versionID string, expir
 		hostPort = "9000"
 	}
 	host := "localhost:" + hostPort
-	if envHost := os.Getenv("SERVER_HOST"); envHost != "" {
-		host = envHost
+	if h.ServerHost != "" {
+		host = h.ServerHost
 	}
 
 	// URL components often need to be encoded
@@ -1134,6 +1549,48 @@ func (h *AdminHandler) UpdateSystemSettings(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+func (h *AdminHandler) GetRateLimits(c *gin.Context) {
+	if h.RateLimiter == nil {
+		c.String(http.StatusNotImplemented, "Rate limiting not available")
+		return
+	}
+	c.JSON(http.StatusOK, h.RateLimiter.Config())
+}
+
+func (h *AdminHandler) UpdateRateLimits(c *gin.Context) {
+	if h.RateLimiter == nil {
+		c.String(http.StatusNotImplemented, "Rate limiting not available")
+		return
+	}
+	var cfg ratelimit.Config
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.String(http.StatusBadRequest, "Invalid rate limit configuration")
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fs, ok := h.Storage.(*storage.FileStorage)
+	if !ok {
+		c.String(http.StatusInternalServerError, "Storage type not supported")
+		return
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := fs.DB.SetSystemSetting(ratelimit.SettingKey, string(data)); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.RateLimiter.SetConfig(cfg)
+	c.JSON(http.StatusOK, cfg)
+}
+
 func (h *AdminHandler) ListWebhookDLQ(c *gin.Context) {
 	bucket := c.Param("bucket")
 	fs, ok := h.Storage.(*storage.FileStorage)
@@ -1240,7 +1697,17 @@ func (h *AdminHandler) StreamAuditLogs(c *gin.Context) {
 		return
 	}
 
-	h.AuditLogger.RegisterClient(ws)
+	f, err := auditFilterFromQuery(c)
+	if err != nil {
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseUnsupportedData, err.Error()))
+		ws.Close()
+		return
+	}
+	var filter *database.AuditLogFilter
+	if f != (database.AuditLogFilter{}) {
+		filter = &f
+	}
+	h.AuditLogger.RegisterClient(ws, filter)
 
 	defer func() {
 		h.AuditLogger.UnregisterClient(ws)
@@ -1261,7 +1728,7 @@ func (h *AdminHandler) GlobalSearch(c *gin.Context) {
 		return
 	}
 
-	buckets, err := h.Storage.ListBuckets()
+	buckets, err := h.store(c).ListBuckets()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1352,58 +1819,23 @@ func (h *AdminHandler) CopyObjects(c *gin.Context) {
 		return
 	}
 
-	exists, err := h.Storage.BucketExists(req.DestinationBucket)
+	exists, err := h.store(c).BucketExists(req.DestinationBucket)
 	if err != nil || !exists {
 		c.String(http.StatusBadRequest, "Destination bucket does not exist")
 		return
 	}
 
-	copiedCount := 0
-	var errs []string
-	for _, key := range req.Keys {
-		if strings.HasSuffix(key, "/") {
-			objects, _, err := h.Storage.ListObjects(srcBucket, key, "", "")
-			if err == nil {
-				for _, obj := range objects {
-					parentDir := filepath.Dir(strings.TrimSuffix(key, "/"))
-					var relKey string
-					if parentDir == "." || parentDir == "/" || parentDir == "" {
-						relKey = obj.Key
-					} else {
-						relKey, _ = filepath.Rel(parentDir, obj.Key)
-					}
-					destKey := req.DestinationPrefix + relKey
-
-					reader, stat, err := h.Storage.GetObject(srcBucket, obj.Key, "")
-					if err == nil {
-						_, err = h.Storage.PutObject(req.DestinationBucket, destKey, reader, stat.EncryptionType)
-						reader.Close()
-						if err != nil {
-							errs = append(errs, fmt.Sprintf("Failed to copy %s: %v", obj.Key, err))
-						} else {
-							copiedCount++
-						}
-					}
-				}
-			}
-		} else {
-			destKey := req.DestinationPrefix + filepath.Base(key)
-			if req.DestinationPrefix == "" {
-				destKey = key
-			}
-			reader, stat, err := h.Storage.GetObject(srcBucket, key, "")
-			if err == nil {
-				_, err = h.Storage.PutObject(req.DestinationBucket, destKey, reader, stat.EncryptionType)
-				reader.Close()
-				if err != nil {
-					errs = append(errs, fmt.Sprintf("Failed to copy %s: %v", key, err))
-				} else {
-					copiedCount++
-				}
-			}
-		}
+	if h.enqueueBulkOperation(c, &storage.BulkOperationJob{
+		Operation:         storage.BulkOpCopy,
+		Bucket:            srcBucket,
+		Keys:              req.Keys,
+		DestinationBucket: req.DestinationBucket,
+		DestinationPrefix: req.DestinationPrefix,
+	}) {
+		return
 	}
 
+	copiedCount, errs := h.store(c).CopyObjects(srcBucket, req.Keys, req.DestinationBucket, req.DestinationPrefix)
 	if len(errs) > 0 {
 		c.JSON(http.StatusMultiStatus, gin.H{"copiedCount": copiedCount, "errors": errs})
 		return
@@ -1422,31 +1854,16 @@ func (h *AdminHandler) BulkDeleteObjects(c *gin.Context) {
 	}
 
 	bypass := c.Query("bypassGovernance") != "false"
-	deletedCount := 0
-	var errs []string
-
-	for _, key := range req.Keys {
-		if strings.HasSuffix(key, "/") {
-			objects, _, err := h.Storage.ListObjects(bucket, key, "", "")
-			if err == nil {
-				for _, obj := range objects {
-					if err := h.Storage.DeleteObject(bucket, obj.Key, "", bypass); err != nil {
-						errs = append(errs, fmt.Sprintf("Failed to delete %s: %v", obj.Key, err))
-					} else {
-						deletedCount++
-					}
-				}
-			}
-			h.Storage.DeleteObject(bucket, key, "", bypass)
-		} else {
-			if err := h.Storage.DeleteObject(bucket, key, "", bypass); err != nil {
-				errs = append(errs, fmt.Sprintf("Failed to delete %s: %v", key, err))
-			} else {
-				deletedCount++
-			}
-		}
+	if h.enqueueBulkOperation(c, &storage.BulkOperationJob{
+		Operation:        storage.BulkOpDelete,
+		Bucket:           bucket,
+		Keys:             req.Keys,
+		BypassGovernance: bypass,
+	}) {
+		return
 	}
 
+	deletedCount, errs := h.store(c).DeleteObjects(bucket, req.Keys, bypass)
 	if len(errs) > 0 {
 		c.JSON(http.StatusMultiStatus, gin.H{"deletedCount": deletedCount, "errors": errs})
 		return
@@ -1454,9 +1871,30 @@ func (h *AdminHandler) BulkDeleteObjects(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"deletedCount": deletedCount})
 }
 
+// enqueueBulkOperation runs a bulk request as a background job when ?async=true is set and
+// answers with the job ID. It returns false when the request should be handled synchronously.
+func (h *AdminHandler) enqueueBulkOperation(c *gin.Context, job *storage.BulkOperationJob) bool {
+	if c.Query("async") != "true" {
+		return false
+	}
+	fs, ok := h.Storage.(*storage.FileStorage)
+	if !ok || fs.Jobs == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Background jobs are not available"})
+		return true
+	}
+	job.Storage = fs
+	id, err := fs.Jobs.Enqueue(job)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	c.JSON(http.StatusAccepted, gin.H{"jobId": id})
+	return true
+}
+
 func (h *AdminHandler) ListReplicationRules(c *gin.Context) {
 	bucket := c.Param("bucket")
-	rules, err := h.Storage.GetReplicationRules(bucket)
+	rules, err := h.store(c).GetReplicationRules(bucket)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1467,14 +1905,24 @@ func (h *AdminHandler) ListReplicationRules(c *gin.Context) {
 func (h *AdminHandler) CreateReplicationRule(c *gin.Context) {
 	bucket := c.Param("bucket")
 	var req struct {
-		DestinationBucket string `json:"destinationBucket"`
-		Prefix            string `json:"prefix"`
+		DestinationBucket       string `json:"destinationBucket"`
+		Prefix                  string `json:"prefix"`
+		TargetID                *int64 `json:"targetId"` // Remote target; nil replicates to a local bucket
+		DeleteMarkerReplication bool   `json:"deleteMarkerReplication"`
+		MetadataReplication     bool   `json:"metadataReplication"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
-	err := h.Storage.CreateReplicationRule(bucket, req.DestinationBucket, req.Prefix)
+	err := h.store(c).CreateReplicationRule(&database.ReplicationRow{
+		SourceBucket:            bucket,
+		DestinationBucket:       req.DestinationBucket,
+		Prefix:                  &req.Prefix,
+		TargetID:                req.TargetID,
+		DeleteMarkerReplication: req.DeleteMarkerReplication,
+		MetadataReplication:     req.MetadataReplication,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1486,11 +1934,260 @@ func (h *AdminHandler) DeleteReplicationRule(c *gin.Context) {
 	idStr := c.Param("id")
 	var id int64
 	fmt.Sscanf(idStr, "%d", &id)
-	err := h.Storage.DeleteReplicationRule(id)
+	err := h.store(c).DeleteReplicationRule(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// StartReplicationBackfill queues the objects that existed before a rule was created
+func (h *AdminHandler) StartReplicationBackfill(c *gin.Context) {
+	bucket := c.Param("bucket")
+	idStr := c.Param("id")
+	var ruleID int64
+	fmt.Sscanf(idStr, "%d", &ruleID)
+	id, err := h.store(c).StartReplicationBackfill(bucket, ruleID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"id": id})
+}
+
+func (h *AdminHandler) ListReplicationBackfills(c *gin.Context) {
+	backfills, err := h.store(c).ListReplicationBackfills(c.Param("bucket"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, backfills)
+}
+
+// ListJobs returns background jobs, filtered by ?state= and ?type=
+func (h *AdminHandler) ListJobs(c *gin.Context) {
+	fs, ok := h.Storage.(*storage.FileStorage)
+	if !ok || fs.Jobs == nil || fs.DB == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Background jobs are not available"})
+		return
+	}
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	list, err := fs.Jobs.List(c.Query("state"), c.Query("type"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	counts, _ := fs.DB.CountJobsByState()
+	c.JSON(http.StatusOK, gin.H{"jobs": list, "counts": counts})
+}
+
+func (h *AdminHandler) GetJob(c *gin.Context) {
+	fs, ok := h.Storage.(*storage.FileStorage)
+	if !ok || fs.Jobs == nil || fs.DB == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Background jobs are not available"})
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+	job, err := fs.Jobs.Get(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// RetryJob queues a dead, cancelled or finished job again
+func (h *AdminHandler) RetryJob(c *gin.Context) {
+	h.changeJobState(c, func(m *jobs.Manager, id int64) (bool, error) { return m.Retry(id) })
+}
+
+// CancelJob keeps a queued job from running, or a running job from being retried
+func (h *AdminHandler) CancelJob(c *gin.Context) {
+	h.changeJobState(c, func(m *jobs.Manager, id int64) (bool, error) { return m.Cancel(id) })
+}
+
+func (h *AdminHandler) changeJobState(c *gin.Context, change func(m *jobs.Manager, id int64) (bool, error)) {
+	fs, ok := h.Storage.(*storage.FileStorage)
+	if !ok || fs.Jobs == nil || fs.DB == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Background jobs are not available"})
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+	changed, err := change(fs.Jobs, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !changed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job not found or not in a state that allows this"})
+		return
+	}
+	job, _ := fs.Jobs.Get(id)
+	c.JSON(http.StatusOK, job)
+}
+
+// CreateBatchJob starts a batch operation over the objects of a manifest
+func (h *AdminHandler) CreateBatchJob(c *gin.Context) {
+	var req storage.BatchJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if userInter, exists := c.Get("user"); exists {
+		req.CreatedBy = userInter.(*auth.User).Username
+	}
+	id, err := h.store(c).CreateBatchJob(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"id": id})
+}
+
+// ListBatchJobs returns recent batch jobs, filtered by ?status=
+func (h *AdminHandler) ListBatchJobs(c *gin.Context) {
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	list, err := h.store(c).ListBatchJobs(c.Query("status"), limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, list)
+}
+
+func (h *AdminHandler) GetBatchJob(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch job ID"})
+		return
+	}
+	job, err := h.store(c).GetBatchJob(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Batch job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// ListBatchJobResults pages through the per-object results of a batch job.
+// ?status=failed lists only failures; ?after= takes the next cursor of the previous page.
+func (h *AdminHandler) ListBatchJobResults(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch job ID"})
+		return
+	}
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	after, _ := strconv.ParseInt(c.Query("after"), 10, 64)
+	results, next, err := h.store(c).ListBatchJobResults(id, c.Query("status"), after, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results, "next": next})
+}
+
+// CancelBatchJob stops a pending or running batch job
+func (h *AdminHandler) CancelBatchJob(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch job ID"})
+		return
+	}
+	cancelled, err := h.store(c).CancelBatchJob(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Batch job not found or already finished"})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// ListReplicationQueue shows queued, completed and failed replication tasks of a bucket
+func (h *AdminHandler) ListReplicationQueue(c *gin.Context) {
+	bucket := c.Param("bucket")
+	tasks, err := h.store(c).ListReplicationTasks(bucket, c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tasks)
+}
+
+func (h *AdminHandler) ListReplicationTargets(c *gin.Context) {
+	targets, err := h.store(c).ListReplicationTargets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, targets)
+}
+
+func (h *AdminHandler) CreateReplicationTarget(c *gin.Context) {
+	var req struct {
+		Name         string `json:"name"`
+		Endpoint     string `json:"endpoint"`
+		Region       string `json:"region"`
+		AccessKey    string `json:"accessKey"`
+		SecretKey    string `json:"secretKey"`
+		UsePathStyle bool   `json:"usePathStyle"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	id, err := h.store(c).CreateReplicationTarget(&database.ReplicationTargetRow{
+		Name:         req.Name,
+		Endpoint:     strings.TrimRight(req.Endpoint, "/"),
+		Region:       req.Region,
+		AccessKey:    req.AccessKey,
+		SecretKey:    req.SecretKey,
+		UsePathStyle: req.UsePathStyle,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"id": id})
+}
+
+func (h *AdminHandler) DeleteReplicationTarget(c *gin.Context) {
+	idStr := c.Param("id")
+	var id int64
+	fmt.Sscanf(idStr, "%d", &id)
+	if err := h.store(c).DeleteReplicationTarget(id); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	c.Status(http.StatusOK)
 }
 
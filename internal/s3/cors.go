@@ -0,0 +1,153 @@
+package s3
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/GravSpace/GravSpace/internal/auth"
+	"github.com/GravSpace/GravSpace/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// BucketCORSMiddleware evaluates the CORS rules stored on the target bucket for S3 and
+// website requests. Preflight requests are answered here, before authentication.
+// Requests for buckets without a CORS configuration are handled by fallback.
+func BucketCORSMiddleware(store storage.Storage, fallback gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		bucket := bucketFromPath(c.Request.URL.Path)
+		if bucket == "" {
+			fallback(c)
+			return
+		}
+
+		config, err := store.GetBucketCors(bucket)
+		if err != nil || config == nil || len(config.CORSRules) == 0 {
+			fallback(c)
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Origin")
+
+		// Preflight
+		requestMethod := c.GetHeader("Access-Control-Request-Method")
+		if c.Request.Method == http.MethodOptions && requestMethod != "" {
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+
+			var requestHeaders []string
+			for _, h := range strings.Split(c.GetHeader("Access-Control-Request-Headers"), ",") {
+				if h = strings.TrimSpace(h); h != "" {
+					requestHeaders = append(requestHeaders, h)
+				}
+			}
+
+			rule := matchCORSRule(config.CORSRules, origin, requestMethod, requestHeaders)
+			if rule == nil {
+				auth.SendS3ErrorStatus(c, http.StatusForbidden, "AccessForbidden", "CORSResponse: This CORS request is not allowed. This is usually because the evalution of Origin, request method / Access-Control-Request-Method or Access-Control-Request-Headers are not whitelisted by the resource's CORS spec.", bucket, "")
+				c.Abort()
+				return
+			}
+
+			setCORSOriginHeaders(c, rule, origin)
+			c.Header("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+			if len(requestHeaders) > 0 {
+				c.Header("Access-Control-Allow-Headers", strings.Join(requestHeaders, ", "))
+			}
+			if rule.MaxAgeSeconds > 0 {
+				c.Header("Access-Control-Max-Age", strconv.Itoa(rule.MaxAgeSeconds))
+			}
+			c.AbortWithStatus(http.StatusOK)
+			return
+		}
+
+		// Actual request: without a matching rule the response simply carries no CORS headers
+		if rule := matchCORSRule(config.CORSRules, origin, c.Request.Method, nil); rule != nil {
+			setCORSOriginHeaders(c, rule, origin)
+			if len(rule.ExposeHeaders) > 0 {
+				c.Header("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+			}
+		}
+		c.Next()
+	}
+}
+
+func setCORSOriginHeaders(c *gin.Context, rule *storage.CORSRule, origin string) {
+	for _, allowed := range rule.AllowedOrigins {
+		if allowed == "*" {
+			// Same as S3: a wildcard rule answers with a wildcard and no credentials
+			c.Header("Access-Control-Allow-Origin", "*")
+			return
+		}
+	}
+	c.Header("Access-Control-Allow-Origin", origin)
+	c.Header("Access-Control-Allow-Credentials", "true")
+}
+
+// matchCORSRule returns the first rule allowing the origin, the method and every requested header
+func matchCORSRule(rules []storage.CORSRule, origin, method string, headers []string) *storage.CORSRule {
+	for i := range rules {
+		rule := &rules[i]
+		if !matchesAnyPattern(rule.AllowedOrigins, origin, false) {
+			continue
+		}
+		if !matchesAnyPattern(rule.AllowedMethods, method, false) {
+			continue
+		}
+		allowed := true
+		for _, h := range headers {
+			if !matchesAnyPattern(rule.AllowedHeaders, h, true) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return rule
+		}
+	}
+	return nil
+}
+
+func matchesAnyPattern(patterns []string, value string, caseInsensitive bool) bool {
+	for _, pattern := range patterns {
+		if caseInsensitive {
+			if wildcardMatch(strings.ToLower(pattern), strings.ToLower(value)) {
+				return true
+			}
+		} else if wildcardMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardMatch matches value against a pattern containing at most one "*", as S3 CORS rules allow
+func wildcardMatch(pattern, value string) bool {
+	idx := strings.Index(pattern, "*")
+	if idx < 0 {
+		return pattern == value
+	}
+	prefix, suffix := pattern[:idx], pattern[idx+1:]
+	return len(value) >= len(prefix)+len(suffix) &&
+		strings.HasPrefix(value, prefix) && strings.HasSuffix(value, suffix)
+}
+
+// bucketFromPath extracts the bucket from a path-style S3 or website request path.
+// It runs before routing, so gin params are not available yet.
+func bucketFromPath(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	bucket := parts[0]
+	if bucket == "website" && len(parts) > 1 {
+		bucket = parts[1]
+	}
+	if bucket == "website" || bucket == "." || bucket == ".." {
+		return ""
+	}
+	return bucket
+}
@@ -0,0 +1,107 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GravSpace/GravSpace/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func TestWildcardMatch(t *testing.T) {
+	cases := []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"*", "https://example.com", true},
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "http://example.com", false},
+		{"x-amz-*", "x-amz-date", true},
+	}
+	for _, tc := range cases {
+		if got := wildcardMatch(tc.pattern, tc.value); got != tc.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tc.pattern, tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestBucketCORSMiddleware(t *testing.T) {
+	root := t.TempDir()
+	store := &storage.FileStorage{Root: root}
+	if err := os.MkdirAll(filepath.Join(root, "site"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := store.PutBucketCors("site", storage.CORSConfiguration{CORSRules: []storage.CORSRule{{
+		AllowedOrigins: []string{"https://*.example.com"},
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedHeaders: []string{"Content-Type", "x-amz-*"},
+		ExposeHeaders:  []string{"ETag"},
+		MaxAgeSeconds:  600,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fallbackCalled := false
+	r := gin.New()
+	r.Use(BucketCORSMiddleware(store, func(c *gin.Context) { fallbackCalled = true }))
+	r.GET("/:bucket/*key", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Allowed preflight is answered without reaching any route
+	w := do(http.MethodOptions, "/site/a.txt", map[string]string{
+		"Origin":                         "https://app.example.com",
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "content-type, X-Amz-Date",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("preflight status = %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("Max-Age = %q", got)
+	}
+
+	// Disallowed method
+	w = do(http.MethodOptions, "/website/site/a.txt", map[string]string{
+		"Origin":                        "https://app.example.com",
+		"Access-Control-Request-Method": "DELETE",
+	})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("disallowed preflight status = %d, want 403", w.Code)
+	}
+
+	// Actual request gets expose headers
+	w = do(http.MethodGet, "/site/a.txt", map[string]string{"Origin": "https://app.example.com"})
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got != "ETag" {
+		t.Errorf("Expose-Headers = %q", got)
+	}
+
+	// Unmatched origin gets no CORS headers
+	w = do(http.MethodGet, "/site/a.txt", map[string]string{"Origin": "https://evil.test"})
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("unexpected Allow-Origin %q for unmatched origin", got)
+	}
+
+	// Buckets without rules use the fallback
+	do(http.MethodGet, "/other/a.txt", map[string]string{"Origin": "https://app.example.com"})
+	if !fallbackCalled {
+		t.Error("fallback was not used for a bucket without CORS rules")
+	}
+}
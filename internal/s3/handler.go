@@ -2,14 +2,17 @@ package s3
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/GravSpace/GravSpace/internal/auth"
 	"github.com/GravSpace/GravSpace/internal/storage"
 	"github.com/gin-gonic/gin"
 )
@@ -31,7 +34,36 @@ func init() {
 }
 
 type S3Handler struct {
-	Storage storage.Storage
+	Storage     storage.Storage
+	UserManager *auth.UserManager
+}
+
+// allowed reports whether the authenticated caller may perform action on resource. The
+// middleware only authorizes the bucket a request addresses; configurations that make the
+// server write into another bucket check the caller's access to that bucket with this.
+func (h *S3Handler) allowed(c *gin.Context, action, resource string) bool {
+	if h.UserManager == nil {
+		return true
+	}
+	user, ok := c.Get("user")
+	if !ok {
+		return false
+	}
+	u, ok := user.(*auth.User)
+	return ok && h.UserManager.CheckPermission(u, action, resource)
+}
+
+// store returns the storage bound to the request's trace, so storage operations show up as
+// spans of the request
+func (h *S3Handler) store(c *gin.Context) storage.Storage {
+	return tracedStorage(h.Storage, c)
+}
+
+func tracedStorage(s storage.Storage, c *gin.Context) storage.Storage {
+	if fs, ok := s.(*storage.FileStorage); ok {
+		return fs.WithContext(c.Request.Context())
+	}
+	return s
 }
 
 type ListAllMyBucketsResult struct {
@@ -172,10 +204,11 @@ type LifecycleConfiguration struct {
 }
 
 type LifecycleRule struct {
-	ID         string            `xml:"ID"`
-	Status     string            `xml:"Status"`
-	Filter     LifecycleFilter   `xml:"Filter"`
-	Expiration ElementExpiration `xml:"Expiration"`
+	ID          string             `xml:"ID"`
+	Status      string             `xml:"Status"`
+	Filter      LifecycleFilter    `xml:"Filter"`
+	Expiration  *ElementExpiration `xml:"Expiration,omitempty"`
+	Transitions []Transition       `xml:"Transition"`
 }
 
 type LifecycleFilter struct {
@@ -186,6 +219,16 @@ type ElementExpiration struct {
 	Days int `xml:"Days"`
 }
 
+type Transition struct {
+	Days         int    `xml:"Days"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type RestoreRequest struct {
+	XMLName xml.Name `xml:"RestoreRequest"`
+	Days    int      `xml:"Days"`
+}
+
 type ObjectLockConfiguration struct {
 	XMLName           xml.Name        `xml:"ObjectLockConfiguration"`
 	ObjectLockEnabled string          `xml:"ObjectLockEnabled"`
@@ -215,7 +258,7 @@ func (h *S3Handler) PostBucket(c *gin.Context) {
 		bypass := c.GetHeader("x-amz-bypass-governance-retention") == "true"
 		result := DeleteResult{}
 		for _, obj := range req.Objects {
-			if err := h.Storage.DeleteObject(bucket, obj.Key, obj.VersionId, bypass); err != nil {
+			if err := h.store(c).DeleteObject(bucket, obj.Key, obj.VersionId, bypass); err != nil {
 				result.Error = append(result.Error, struct {
 					Key     string `xml:"Key"`
 					Code    string `xml:"Code"`
@@ -259,7 +302,7 @@ func (h *S3Handler) PutBucket(c *gin.Context) {
 			})
 		}
 
-		if err := h.Storage.PutBucketCors(bucket, config); err != nil {
+		if err := h.store(c).PutBucketCors(bucket, config); err != nil {
 			h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
 			return
 		}
@@ -267,6 +310,18 @@ func (h *S3Handler) PutBucket(c *gin.Context) {
 		return
 	}
 
+	// Inventory
+	if _, ok := c.GetQuery("inventory"); ok {
+		h.putBucketInventory(c, bucket)
+		return
+	}
+
+	// Server Access Logging
+	if _, ok := c.GetQuery("logging"); ok {
+		h.putBucketLogging(c, bucket)
+		return
+	}
+
 	// Lifecycle
 	if c.Query("lifecycle") != "" || strings.Contains(c.Request.URL.RawQuery, "lifecycle") {
 		var req LifecycleConfiguration
@@ -277,19 +332,30 @@ func (h *S3Handler) PutBucket(c *gin.Context) {
 
 		config := storage.LifecycleConfiguration{}
 		for _, r := range req.Rules {
-			config.Rules = append(config.Rules, storage.LifecycleRule{
+			rule := storage.LifecycleRule{
 				ID:     r.ID,
 				Status: r.Status,
 				Filter: storage.LifecycleFilter{
 					Prefix: r.Filter.Prefix,
 				},
-				Expiration: storage.ElementExpiration{
-					Days: r.Expiration.Days,
-				},
-			})
+			}
+			if r.Expiration != nil {
+				rule.Expiration.Days = r.Expiration.Days
+			}
+			for _, t := range r.Transitions {
+				if !storage.IsArchivedStorageClass(t.StorageClass) {
+					auth.SendS3ErrorStatus(c, http.StatusBadRequest, "InvalidStorageClass", "The storage class you specified is not valid", bucket, "")
+					return
+				}
+				rule.Transitions = append(rule.Transitions, storage.Transition{
+					Days:         t.Days,
+					StorageClass: t.StorageClass,
+				})
+			}
+			config.Rules = append(config.Rules, rule)
 		}
 
-		if err := h.Storage.PutBucketLifecycle(bucket, config); err != nil {
+		if err := h.store(c).PutBucketLifecycle(bucket, config); err != nil {
 			h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
 			return
 		}
@@ -305,12 +371,12 @@ func (h *S3Handler) PutBucket(c *gin.Context) {
 			return
 		}
 		enabled := req.ObjectLockEnabled == "Enabled"
-		if err := h.Storage.SetBucketObjectLock(bucket, enabled); err != nil {
+		if err := h.store(c).SetBucketObjectLock(bucket, enabled); err != nil {
 			h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
 			return
 		}
 		if req.Rule != nil && req.Rule.DefaultRetention != nil {
-			if err := h.Storage.SetBucketDefaultRetention(bucket, req.Rule.DefaultRetention.Mode, req.Rule.DefaultRetention.Days); err != nil {
+			if err := h.store(c).SetBucketDefaultRetention(bucket, req.Rule.DefaultRetention.Mode, req.Rule.DefaultRetention.Days); err != nil {
 				h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
 				return
 			}
@@ -319,7 +385,7 @@ func (h *S3Handler) PutBucket(c *gin.Context) {
 		return
 	}
 
-	exists, err := h.Storage.BucketExists(bucket)
+	exists, err := h.store(c).BucketExists(bucket)
 	if err != nil {
 		h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
 		return
@@ -328,7 +394,7 @@ func (h *S3Handler) PutBucket(c *gin.Context) {
 		c.Status(http.StatusOK)
 		return
 	}
-	if err := h.Storage.CreateBucket(bucket); err != nil {
+	if err := h.store(c).CreateBucket(bucket); err != nil {
 		h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
 		return
 	}
@@ -341,7 +407,7 @@ func (h *S3Handler) sendS3Error(c *gin.Context, code, message, bucket, key strin
 }
 
 func (h *S3Handler) ListBuckets(c *gin.Context) {
-	buckets, err := h.Storage.ListBuckets()
+	buckets, err := h.store(c).ListBuckets()
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -360,7 +426,7 @@ func (h *S3Handler) ListBuckets(c *gin.Context) {
 
 func (h *S3Handler) CreateBucket(c *gin.Context) {
 	bucket := c.Param("bucket")
-	exists, err := h.Storage.BucketExists(bucket)
+	exists, err := h.store(c).BucketExists(bucket)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -370,7 +436,7 @@ func (h *S3Handler) CreateBucket(c *gin.Context) {
 		c.Status(http.StatusOK)
 		return
 	}
-	if err := h.Storage.CreateBucket(bucket); err != nil {
+	if err := h.store(c).CreateBucket(bucket); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -379,7 +445,7 @@ func (h *S3Handler) CreateBucket(c *gin.Context) {
 
 func (h *S3Handler) HeadBucket(c *gin.Context) {
 	bucket := c.Param("bucket")
-	exists, err := h.Storage.BucketExists(bucket)
+	exists, err := h.store(c).BucketExists(bucket)
 	if err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
@@ -397,7 +463,7 @@ func (h *S3Handler) GetObject(c *gin.Context) {
 	versionID := c.Query("versionId")
 
 	if c.Query("tagging") != "" || strings.Contains(c.Request.URL.RawQuery, "tagging") {
-		tags, err := h.Storage.GetObjectTagging(bucket, key, versionID)
+		tags, err := h.store(c).GetObjectTagging(bucket, key, versionID)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
@@ -411,8 +477,29 @@ func (h *S3Handler) GetObject(c *gin.Context) {
 		return
 	}
 
-	reader, obj, err := h.Storage.GetObject(bucket, key, versionID)
+	// Ranges are read from the offset on rather than skipped to, which matters for blob stores
+	// that serve them remotely
+	rangeHeader := c.GetHeader("Range")
+	isRange := strings.HasPrefix(rangeHeader, "bytes=")
+	var start, end int64
+	length := int64(-1)
+	if isRange {
+		fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end)
+		if end > 0 {
+			length = end - start + 1
+		}
+	}
+
+	reader, obj, err := h.store(c).GetObjectRange(bucket, key, versionID, start, length)
 	if err != nil {
+		if errors.Is(err, storage.ErrInvalidObjectState) {
+			auth.SendS3ErrorStatus(c, http.StatusForbidden, "InvalidObjectState", err.Error(), bucket, key)
+			return
+		}
+		if errors.Is(err, storage.ErrObjectCorrupted) {
+			auth.SendS3ErrorStatus(c, http.StatusInternalServerError, "InternalError", err.Error(), bucket, key)
+			return
+		}
 		c.Status(http.StatusNotFound)
 		return
 	}
@@ -421,6 +508,13 @@ func (h *S3Handler) GetObject(c *gin.Context) {
 	if obj.EncryptionType != "" {
 		c.Header("x-amz-server-side-encryption", obj.EncryptionType)
 	}
+	setStorageClassHeaders(c, obj)
+	if obj.WebsiteRedirectLocation != "" {
+		c.Header("x-amz-website-redirect-location", obj.WebsiteRedirectLocation)
+	}
+	if obj.ReplicationStatus != "" {
+		c.Header("x-amz-replication-status", obj.ReplicationStatus)
+	}
 
 	contentType := obj.ContentType
 	if contentType == "" || contentType == "application/octet-stream" {
@@ -438,10 +532,7 @@ func (h *S3Handler) GetObject(c *gin.Context) {
 	c.Header("Last-Modified", obj.ModTime.UTC().Format(time.RFC3339))
 
 	// Handle Range Request
-	rangeHeader := c.GetHeader("Range")
-	if rangeHeader != "" && strings.HasPrefix(rangeHeader, "bytes=") {
-		var start, end int64
-		fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end)
+	if isRange {
 		if end == 0 || end >= obj.Size {
 			end = obj.Size - 1
 		}
@@ -450,8 +541,6 @@ func (h *S3Handler) GetObject(c *gin.Context) {
 		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, obj.Size))
 		c.Header("Content-Length", fmt.Sprintf("%d", contentLength))
 
-		// Skip 'start' bytes
-		io.CopyN(io.Discard, reader, start)
 		c.DataFromReader(http.StatusPartialContent, contentLength, contentType, io.LimitReader(reader, contentLength), nil)
 		return
 	}
@@ -473,7 +562,7 @@ func (h *S3Handler) HeadObject(c *gin.Context) {
 	key := c.Param("key")
 	versionID := c.Query("versionId")
 
-	obj, err := h.Storage.StatObject(bucket, key, versionID)
+	obj, err := h.store(c).StatObject(bucket, key, versionID)
 	if err != nil {
 		c.Status(http.StatusNotFound)
 		return
@@ -497,10 +586,28 @@ func (h *S3Handler) HeadObject(c *gin.Context) {
 	if obj.EncryptionType != "" {
 		c.Header("x-amz-server-side-encryption", obj.EncryptionType)
 	}
+	setStorageClassHeaders(c, obj)
+	if obj.WebsiteRedirectLocation != "" {
+		c.Header("x-amz-website-redirect-location", obj.WebsiteRedirectLocation)
+	}
+	if obj.ReplicationStatus != "" {
+		c.Header("x-amz-replication-status", obj.ReplicationStatus)
+	}
 
 	c.Status(http.StatusOK)
 }
 
+// setStorageClassHeaders reports the storage class and restore state of archived objects
+func setStorageClassHeaders(c *gin.Context, obj *storage.Object) {
+	if obj.StorageClass == "" || obj.StorageClass == storage.StorageClassStandard {
+		return
+	}
+	c.Header("x-amz-storage-class", obj.StorageClass)
+	if restore := obj.RestoreHeader(); restore != "" {
+		c.Header("x-amz-restore", restore)
+	}
+}
+
 func (h *S3Handler) PutObject(c *gin.Context) {
 	bucket := c.Param("bucket")
 	key := c.Param("key")
@@ -518,7 +625,7 @@ func (h *S3Handler) PutObject(c *gin.Context) {
 		for _, t := range req.TagSet {
 			tags[t.Key] = t.Value
 		}
-		if err := h.Storage.PutObjectTagging(bucket, key, versionID, tags); err != nil {
+		if err := h.store(c).PutObjectTagging(bucket, key, versionID, tags); err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
@@ -529,7 +636,7 @@ func (h *S3Handler) PutObject(c *gin.Context) {
 	if uploadID != "" && partNumber != "" {
 		var pn int
 		fmt.Sscanf(partNumber, "%d", &pn)
-		etag, err := h.Storage.UploadPart(bucket, key, uploadID, pn, c.Request.Body)
+		etag, err := h.store(c).UploadPart(bucket, key, uploadID, pn, c.Request.Body)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
@@ -540,8 +647,14 @@ func (h *S3Handler) PutObject(c *gin.Context) {
 	}
 
 	encryptionType := c.GetHeader("x-amz-server-side-encryption")
+	redirectLocation := c.GetHeader("x-amz-website-redirect-location")
+	if redirectLocation != "" && !strings.HasPrefix(redirectLocation, "/") &&
+		!strings.HasPrefix(redirectLocation, "http://") && !strings.HasPrefix(redirectLocation, "https://") {
+		auth.SendS3ErrorStatus(c, http.StatusBadRequest, "InvalidRedirectLocation", "The website redirect location must have a prefix of 'http://' or 'https://' or '/'", bucket, key)
+		return
+	}
 
-	vid, err := h.Storage.PutObject(bucket, key, c.Request.Body, encryptionType)
+	vid, err := h.store(c).PutObjectWithRedirect(bucket, key, c.Request.Body, encryptionType, redirectLocation)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -558,9 +671,15 @@ func (h *S3Handler) PostObject(c *gin.Context) {
 	key := c.Param("key")
 	uploadID := c.Query("uploadId")
 
+	// Restore an archived object from the cold tier
+	if _, ok := c.GetQuery("restore"); ok {
+		h.RestoreObject(c)
+		return
+	}
+
 	// Initiate Multipart Upload
 	if c.Query("uploads") != "" || strings.Contains(c.Request.URL.RawQuery, "uploads") {
-		uid, err := h.Storage.InitiateMultipartUpload(bucket, key)
+		uid, err := h.store(c).InitiateMultipartUpload(bucket, key)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
@@ -591,7 +710,7 @@ func (h *S3Handler) PostObject(c *gin.Context) {
 			})
 		}
 
-		vid, err := h.Storage.CompleteMultipartUpload(bucket, key, uploadID, parts)
+		vid, err := h.store(c).CompleteMultipartUpload(bucket, key, uploadID, parts)
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
@@ -612,6 +731,35 @@ func (h *S3Handler) PostObject(c *gin.Context) {
 	c.Status(http.StatusNotFound)
 }
 
+// RestoreObject handles POST /:bucket/*key?restore for objects in the GLACIER or DEEP_ARCHIVE class
+func (h *S3Handler) RestoreObject(c *gin.Context) {
+	bucket := c.Param("bucket")
+	key := c.Param("key")
+	versionID := c.Query("versionId")
+
+	req := RestoreRequest{Days: 1}
+	if err := xml.NewDecoder(c.Request.Body).Decode(&req); err != nil && err != io.EOF {
+		auth.SendS3ErrorStatus(c, http.StatusBadRequest, "MalformedXML", err.Error(), bucket, key)
+		return
+	}
+
+	alreadyRestored, err := h.store(c).RestoreArchivedObject(bucket, key, versionID, req.Days)
+	switch {
+	case err == nil && alreadyRestored:
+		c.Status(http.StatusOK)
+	case err == nil:
+		c.Status(http.StatusAccepted)
+	case errors.Is(err, storage.ErrRestoreInProgress):
+		auth.SendS3ErrorStatus(c, http.StatusConflict, "RestoreAlreadyInProgress", err.Error(), bucket, key)
+	case errors.Is(err, storage.ErrInvalidObjectState):
+		auth.SendS3ErrorStatus(c, http.StatusForbidden, "InvalidObjectState", "Restore is not allowed for the object's current storage class", bucket, key)
+	case errors.Is(err, os.ErrNotExist):
+		auth.SendS3ErrorStatus(c, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.", bucket, key)
+	default:
+		auth.SendS3ErrorStatus(c, http.StatusBadRequest, "InvalidArgument", err.Error(), bucket, key)
+	}
+}
+
 func (h *S3Handler) DeleteObject(c *gin.Context) {
 	bucket := c.Param("bucket")
 	key := c.Param("key")
@@ -619,7 +767,7 @@ func (h *S3Handler) DeleteObject(c *gin.Context) {
 	uploadID := c.Query("uploadId")
 
 	if uploadID != "" {
-		if err := h.Storage.AbortMultipartUpload(bucket, key, uploadID); err != nil {
+		if err := h.store(c).AbortMultipartUpload(bucket, key, uploadID); err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
@@ -628,7 +776,7 @@ func (h *S3Handler) DeleteObject(c *gin.Context) {
 	}
 
 	bypass := c.GetHeader("x-amz-bypass-governance-retention") == "true"
-	if err := h.Storage.DeleteObject(bucket, key, versionID, bypass); err != nil {
+	if err := h.store(c).DeleteObject(bucket, key, versionID, bypass); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -640,7 +788,7 @@ func (h *S3Handler) DeleteBucket(c *gin.Context) {
 
 	// CORS
 	if c.Query("cors") != "" || strings.Contains(c.Request.URL.RawQuery, "cors") {
-		if err := h.Storage.DeleteBucketCors(bucket); err != nil {
+		if err := h.store(c).DeleteBucketCors(bucket); err != nil {
 			h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
 			return
 		}
@@ -648,9 +796,15 @@ func (h *S3Handler) DeleteBucket(c *gin.Context) {
 		return
 	}
 
+	// Inventory
+	if _, ok := c.GetQuery("inventory"); ok {
+		h.deleteBucketInventory(c, bucket)
+		return
+	}
+
 	// Lifecycle
 	if c.Query("lifecycle") != "" || strings.Contains(c.Request.URL.RawQuery, "lifecycle") {
-		if err := h.Storage.DeleteBucketLifecycle(bucket); err != nil {
+		if err := h.store(c).DeleteBucketLifecycle(bucket); err != nil {
 			h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
 			return
 		}
@@ -658,7 +812,7 @@ func (h *S3Handler) DeleteBucket(c *gin.Context) {
 		return
 	}
 
-	if err := h.Storage.DeleteBucket(bucket); err != nil {
+	if err := h.store(c).DeleteBucket(bucket); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -673,7 +827,7 @@ func (h *S3Handler) ListObjects(c *gin.Context) {
 
 	// CORS
 	if c.Query("cors") != "" || strings.Contains(c.Request.URL.RawQuery, "cors") {
-		config, err := h.Storage.GetBucketCors(bucket)
+		config, err := h.store(c).GetBucketCors(bucket)
 		if err != nil {
 			h.sendS3Error(c, "NoSuchCORSConfiguration", "The CORS configuration does not exist", bucket, "")
 			return
@@ -695,9 +849,21 @@ func (h *S3Handler) ListObjects(c *gin.Context) {
 		return
 	}
 
+	// Inventory
+	if _, ok := c.GetQuery("inventory"); ok {
+		h.getBucketInventory(c, bucket)
+		return
+	}
+
+	// Server Access Logging
+	if _, ok := c.GetQuery("logging"); ok {
+		h.getBucketLogging(c, bucket)
+		return
+	}
+
 	// Lifecycle
 	if c.Query("lifecycle") != "" || strings.Contains(c.Request.URL.RawQuery, "lifecycle") {
-		config, err := h.Storage.GetBucketLifecycle(bucket)
+		config, err := h.store(c).GetBucketLifecycle(bucket)
 		if err != nil {
 			h.sendS3Error(c, "NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist", bucket, "")
 			return
@@ -705,16 +871,23 @@ func (h *S3Handler) ListObjects(c *gin.Context) {
 
 		result := LifecycleConfiguration{}
 		for _, r := range config.Rules {
-			result.Rules = append(result.Rules, LifecycleRule{
+			rule := LifecycleRule{
 				ID:     r.ID,
 				Status: r.Status,
 				Filter: LifecycleFilter{
 					Prefix: r.Filter.Prefix,
 				},
-				Expiration: ElementExpiration{
-					Days: r.Expiration.Days,
-				},
-			})
+			}
+			if r.Expiration.Days > 0 {
+				rule.Expiration = &ElementExpiration{Days: r.Expiration.Days}
+			}
+			for _, t := range r.Transitions {
+				rule.Transitions = append(rule.Transitions, Transition{
+					Days:         t.Days,
+					StorageClass: t.StorageClass,
+				})
+			}
+			result.Rules = append(result.Rules, rule)
 		}
 
 		c.Header("Content-Type", "application/xml")
@@ -723,7 +896,7 @@ func (h *S3Handler) ListObjects(c *gin.Context) {
 	}
 	// Object Lock
 	if c.Query("object-lock") != "" || strings.Contains(c.Request.URL.RawQuery, "object-lock") {
-		enabled, mode, days, err := h.Storage.GetBucketObjectLock(bucket)
+		enabled, mode, days, err := h.store(c).GetBucketObjectLock(bucket)
 		if err != nil {
 			h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
 			return
@@ -753,7 +926,7 @@ func (h *S3Handler) ListObjects(c *gin.Context) {
 		return
 	}
 
-	objects, commonPrefixes, err := h.Storage.ListObjects(bucket, prefix, delimiter, "")
+	objects, commonPrefixes, err := h.store(c).ListObjects(bucket, prefix, delimiter, "")
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -775,7 +948,7 @@ func (h *S3Handler) ListObjects(c *gin.Context) {
 				Size:         o.Size,
 				LastModified: o.ModTime.UTC().Format(time.RFC3339),
 				ETag:         fmt.Sprintf("\"%s\"", o.VersionID),
-				StorageClass: "STANDARD",
+				StorageClass: storageClassOf(o),
 			})
 		}
 		c.Header("Content-Type", "application/xml")
@@ -795,7 +968,7 @@ func (h *S3Handler) ListObjects(c *gin.Context) {
 			Size:         o.Size,
 			LastModified: o.ModTime.UTC().Format(time.RFC3339),
 			ETag:         fmt.Sprintf("\"%s\"", o.VersionID),
-			StorageClass: "STANDARD",
+			StorageClass: storageClassOf(o),
 		})
 	}
 
@@ -803,12 +976,19 @@ func (h *S3Handler) ListObjects(c *gin.Context) {
 	c.XML(http.StatusOK, result)
 }
 
+func storageClassOf(o storage.Object) string {
+	if o.StorageClass == "" {
+		return storage.StorageClassStandard
+	}
+	return o.StorageClass
+}
+
 func (h *S3Handler) ListVersions(c *gin.Context) {
 	bucket := c.Param("bucket")
 	prefix := c.Query("prefix")
 	delimiter := c.Query("delimiter")
 
-	objects, _, err := h.Storage.ListObjects(bucket, prefix, "", "") // Get all objects first
+	objects, _, err := h.store(c).ListObjects(bucket, prefix, "", "") // Get all objects first
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
@@ -821,7 +1001,7 @@ func (h *S3Handler) ListVersions(c *gin.Context) {
 	}
 
 	for _, o := range objects {
-		versions, err := h.Storage.ListVersions(bucket, o.Key)
+		versions, err := h.store(c).ListVersions(bucket, o.Key)
 		if err != nil {
 			continue
 		}
@@ -839,65 +1019,3 @@ func (h *S3Handler) ListVersions(c *gin.Context) {
 	c.Header("Content-Type", "application/xml")
 	c.XML(http.StatusOK, result)
 }
-
-// ServeWebsite serves static website content from a bucket
-func (h *S3Handler) ServeWebsite(c *gin.Context) {
-	bucket := c.Param("bucket")
-	path := c.Param("key")
-
-	// Get website configuration
-	config, err := h.Storage.GetBucketWebsite(bucket)
-	if err != nil || config == nil {
-		c.String(http.StatusNotFound, "Website configuration not found for this bucket")
-		return
-	}
-
-	// Determine the key to fetch
-	key := path
-	if key == "" || strings.HasSuffix(key, "/") {
-		// Directory request - append index document
-		if config.IndexDocument != nil && config.IndexDocument.Suffix != "" {
-			key = key + config.IndexDocument.Suffix
-		} else {
-			key = key + "index.html" // Default
-		}
-	}
-
-	// Try to get the object
-	reader, obj, err := h.Storage.GetObject(bucket, key, "")
-	if err != nil {
-		// Object not found - serve error document if configured
-		if config.ErrorDocument != nil && config.ErrorDocument.Key != "" {
-			errorReader, errorObj, errorErr := h.Storage.GetObject(bucket, config.ErrorDocument.Key, "")
-			if errorErr == nil {
-				contentType := mime.TypeByExtension(filepath.Ext(config.ErrorDocument.Key))
-				if contentType == "" {
-					contentType = "text/html"
-				}
-				c.DataFromReader(http.StatusNotFound, errorObj.Size, contentType, errorReader, nil)
-				return
-			}
-		}
-		c.String(http.StatusNotFound, "Not Found")
-		return
-	}
-
-	// Determine content type
-	contentType := obj.ContentType
-	if contentType == "" || contentType == "application/octet-stream" {
-		if extType := mime.TypeByExtension(filepath.Ext(key)); extType != "" {
-			contentType = extType
-		}
-	}
-	if contentType == "" {
-		contentType = "application/octet-stream"
-	}
-
-	// Set headers
-	c.Header("x-amz-version-id", obj.VersionID)
-	c.Header("ETag", fmt.Sprintf("\"%s\"", obj.VersionID))
-	c.Header("Last-Modified", obj.ModTime.UTC().Format(time.RFC3339))
-	c.Header("Content-Length", fmt.Sprintf("%d", obj.Size))
-
-	c.DataFromReader(http.StatusOK, obj.Size, contentType, reader, nil)
-}
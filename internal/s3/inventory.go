@@ -0,0 +1,144 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	"github.com/GravSpace/GravSpace/internal/auth"
+	"github.com/GravSpace/GravSpace/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+type InventoryConfiguration struct {
+	XMLName                xml.Name             `xml:"InventoryConfiguration"`
+	Id                     string               `xml:"Id"`
+	IsEnabled              bool                 `xml:"IsEnabled"`
+	Destination            InventoryDestination `xml:"Destination"`
+	Filter                 *InventoryFilter     `xml:"Filter,omitempty"`
+	IncludedObjectVersions string               `xml:"IncludedObjectVersions"`
+	Schedule               InventorySchedule    `xml:"Schedule"`
+}
+
+type InventoryDestination struct {
+	S3BucketDestination InventoryBucketDestination `xml:"S3BucketDestination"`
+}
+
+type InventoryBucketDestination struct {
+	Bucket string `xml:"Bucket"` // Bucket name or arn:aws:s3:::name
+	Format string `xml:"Format"` // CSV or JSONL
+	Prefix string `xml:"Prefix,omitempty"`
+}
+
+type InventoryFilter struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type InventorySchedule struct {
+	Frequency string `xml:"Frequency"` // Daily or Weekly
+}
+
+type ListInventoryConfigurationsResult struct {
+	XMLName                 xml.Name                 `xml:"ListInventoryConfigurationsResult"`
+	InventoryConfigurations []InventoryConfiguration `xml:"InventoryConfiguration"`
+	IsTruncated             bool                     `xml:"IsTruncated"`
+}
+
+func inventoryToXML(inv *storage.BucketInventory) InventoryConfiguration {
+	config := InventoryConfiguration{
+		Id:        inv.ID,
+		IsEnabled: inv.Enabled,
+		Destination: InventoryDestination{S3BucketDestination: InventoryBucketDestination{
+			Bucket: "arn:aws:s3:::" + inv.DestinationBucket,
+			Format: inv.Format,
+			Prefix: inv.DestinationPrefix,
+		}},
+		IncludedObjectVersions: inv.IncludedVersions,
+		Schedule:               InventorySchedule{Frequency: inv.Frequency},
+	}
+	if inv.Prefix != "" {
+		config.Filter = &InventoryFilter{Prefix: inv.Prefix}
+	}
+	return config
+}
+
+// putBucketInventory handles PUT /bucket?inventory&id=
+func (h *S3Handler) putBucketInventory(c *gin.Context, bucket string) {
+	var req InventoryConfiguration
+	if err := xml.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		auth.SendS3ErrorStatus(c, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed", bucket, "")
+		return
+	}
+	if id := c.Query("id"); id == "" || id != req.Id {
+		auth.SendS3ErrorStatus(c, http.StatusBadRequest, "InvalidArgument", "The id parameter must match the inventory configuration Id", bucket, "")
+		return
+	}
+
+	config := storage.InventoryConfiguration{
+		ID:                req.Id,
+		Enabled:           req.IsEnabled,
+		DestinationBucket: strings.TrimPrefix(req.Destination.S3BucketDestination.Bucket, "arn:aws:s3:::"),
+		DestinationPrefix: req.Destination.S3BucketDestination.Prefix,
+		Format:            req.Destination.S3BucketDestination.Format,
+		Frequency:         req.Schedule.Frequency,
+		IncludedVersions:  req.IncludedObjectVersions,
+	}
+	if req.Filter != nil {
+		config.Prefix = req.Filter.Prefix
+	}
+	// Reports are written into the destination bucket on the caller's behalf
+	if !h.allowed(c, "s3:PutObject", "arn:aws:s3:::"+config.DestinationBucket+"/"+config.DestinationPrefix) {
+		auth.SendS3ErrorStatus(c, http.StatusForbidden, "AccessDenied", "Access Denied to the destination bucket", bucket, "")
+		return
+	}
+	if err := h.store(c).PutBucketInventory(bucket, config); err != nil {
+		auth.SendS3ErrorStatus(c, http.StatusBadRequest, "InvalidArgument", err.Error(), bucket, "")
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// getBucketInventory handles GET /bucket?inventory, returning one configuration when id is given
+func (h *S3Handler) getBucketInventory(c *gin.Context, bucket string) {
+	id := c.Query("id")
+	if id == "" {
+		list, err := h.store(c).ListBucketInventories(bucket)
+		if err != nil {
+			h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
+			return
+		}
+		result := ListInventoryConfigurationsResult{}
+		for _, inv := range list {
+			result.InventoryConfigurations = append(result.InventoryConfigurations, inventoryToXML(inv))
+		}
+		c.Header("Content-Type", "application/xml")
+		c.XML(http.StatusOK, result)
+		return
+	}
+
+	inv, err := h.store(c).GetBucketInventory(bucket, id)
+	if err != nil {
+		h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
+		return
+	}
+	if inv == nil {
+		auth.SendS3ErrorStatus(c, http.StatusNotFound, "NoSuchConfiguration", "The specified configuration does not exist", bucket, "")
+		return
+	}
+	c.Header("Content-Type", "application/xml")
+	c.XML(http.StatusOK, inventoryToXML(inv))
+}
+
+// deleteBucketInventory handles DELETE /bucket?inventory&id=
+func (h *S3Handler) deleteBucketInventory(c *gin.Context, bucket string) {
+	id := c.Query("id")
+	if id == "" {
+		auth.SendS3ErrorStatus(c, http.StatusBadRequest, "InvalidArgument", "The id parameter is required", bucket, "")
+		return
+	}
+	if err := h.store(c).DeleteBucketInventory(bucket, id); err != nil {
+		h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,66 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/GravSpace/GravSpace/internal/auth"
+	"github.com/GravSpace/GravSpace/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+type BucketLoggingStatus struct {
+	XMLName        xml.Name        `xml:"BucketLoggingStatus"`
+	Xmlns          string          `xml:"xmlns,attr,omitempty"`
+	LoggingEnabled *LoggingEnabled `xml:"LoggingEnabled,omitempty"`
+}
+
+type LoggingEnabled struct {
+	TargetBucket string `xml:"TargetBucket"`
+	TargetPrefix string `xml:"TargetPrefix"`
+}
+
+// putBucketLogging handles PUT /bucket?logging. A status without LoggingEnabled turns logging off.
+func (h *S3Handler) putBucketLogging(c *gin.Context, bucket string) {
+	var req BucketLoggingStatus
+	if err := xml.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		auth.SendS3ErrorStatus(c, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed", bucket, "")
+		return
+	}
+
+	var config *storage.BucketLoggingConfiguration
+	if req.LoggingEnabled != nil {
+		config = &storage.BucketLoggingConfiguration{
+			TargetBucket: req.LoggingEnabled.TargetBucket,
+			TargetPrefix: req.LoggingEnabled.TargetPrefix,
+		}
+		// Log records are written into the target bucket on the caller's behalf
+		if !h.allowed(c, "s3:PutObject", "arn:aws:s3:::"+config.TargetBucket+"/"+config.TargetPrefix) {
+			auth.SendS3ErrorStatus(c, http.StatusForbidden, "AccessDenied", "Access Denied to the target bucket", bucket, "")
+			return
+		}
+	}
+	if err := h.store(c).PutBucketLogging(bucket, config); err != nil {
+		auth.SendS3ErrorStatus(c, http.StatusBadRequest, "InvalidTargetBucketForLogging", err.Error(), bucket, "")
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// getBucketLogging handles GET /bucket?logging
+func (h *S3Handler) getBucketLogging(c *gin.Context, bucket string) {
+	config, err := h.store(c).GetBucketLogging(bucket)
+	if err != nil {
+		h.sendS3Error(c, "InternalError", err.Error(), bucket, "")
+		return
+	}
+	result := BucketLoggingStatus{Xmlns: "http://doc.s3.amazonaws.com/2006-03-01"}
+	if config != nil {
+		result.LoggingEnabled = &LoggingEnabled{
+			TargetBucket: config.TargetBucket,
+			TargetPrefix: config.TargetPrefix,
+		}
+	}
+	c.Header("Content-Type", "application/xml")
+	c.XML(http.StatusOK, result)
+}
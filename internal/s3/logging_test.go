@@ -0,0 +1,56 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GravSpace/GravSpace/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func TestPutBucketLoggingRequiresTargetAccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	user := &auth.User{Username: "alice", Policies: []auth.Policy{{
+		Name: "photos",
+		Statement: []auth.Statement{
+			{Effect: "Allow", Action: []string{"*"}, Resource: []string{"arn:aws:s3:::photos*"}},
+			{Effect: "Allow", Action: []string{"s3:PutObject"}, Resource: []string{"arn:aws:s3:::logs/photos/*"}},
+		},
+	}}}
+	h := &S3Handler{UserManager: &auth.UserManager{}}
+
+	cases := []struct {
+		resource string
+		want     bool
+	}{
+		{"arn:aws:s3:::logs/photos/", true},
+		{"arn:aws:s3:::logs/", false},
+		{"arn:aws:s3:::audit/photos/", false},
+	}
+	for _, tc := range cases {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Set("user", user)
+		if got := h.allowed(c, "s3:PutObject", tc.resource); got != tc.want {
+			t.Errorf("allowed(%s) = %v, want %v", tc.resource, got, tc.want)
+		}
+	}
+
+	// Logging into a bucket the caller cannot write to is refused before it is stored
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("user", user)
+	body := `<BucketLoggingStatus><LoggingEnabled><TargetBucket>audit</TargetBucket><TargetPrefix>photos/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>`
+	c.Request = httptest.NewRequest(http.MethodPut, "/photos?logging", strings.NewReader(body))
+	h.putBucketLogging(c, "photos")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "AccessDenied") {
+		t.Errorf("PUT ?logging with an unwritable target = %d %s", w.Code, w.Body.String())
+	}
+
+	// A request without an authenticated user is never allowed
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	if h.allowed(c, "s3:PutObject", "arn:aws:s3:::logs/photos/") {
+		t.Error("allowed without a user")
+	}
+}
@@ -0,0 +1,37 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/GravSpace/GravSpace/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// StorageFullMiddleware rejects writes with 507 XMinioStorageFull while full reports that the
+// disk is below its critical free space threshold. Reads and deletes are still served, so
+// clients can free space.
+func StorageFullMiddleware(full func() bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !full() || !isWrite(c) {
+			c.Next()
+			return
+		}
+		auth.SendS3ErrorStatus(c, http.StatusInsufficientStorage, "XMinioStorageFull",
+			"Storage backend has reached its minimum free drive threshold. Please delete a few objects to proceed.",
+			c.Param("bucket"), strings.TrimPrefix(c.Param("key"), "/"))
+		c.Abort()
+	}
+}
+
+// isWrite reports whether a request can consume disk space. Multi-object deletes are POSTs.
+func isWrite(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodPut:
+		return true
+	case http.MethodPost:
+		_, isDelete := c.GetQuery("delete")
+		return !isDelete
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStorageFullMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	full := true
+	r := gin.New()
+	r.Use(StorageFullMiddleware(func() bool { return full }))
+	r.Any("/:bucket/*key", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/:bucket", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	cases := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPut, "/bucket/key", http.StatusInsufficientStorage},
+		{http.MethodPost, "/bucket/key?uploads", http.StatusInsufficientStorage},
+		{http.MethodGet, "/bucket/key", http.StatusOK},
+		{http.MethodDelete, "/bucket/key", http.StatusOK},
+		{http.MethodPost, "/bucket?delete", http.StatusOK},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+	}
+
+	full = false
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/bucket/key", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("PUT with free space = %d", w.Code)
+	}
+}
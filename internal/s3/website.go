@@ -0,0 +1,271 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// ServeWebsite serves static website content from a bucket
+func (h *S3Handler) ServeWebsite(c *gin.Context) {
+	bucket := c.Param("bucket")
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	// Path prefix the site is mounted under, used for relative redirects
+	base := strings.TrimSuffix(strings.TrimSuffix(c.Request.URL.Path, c.Param("key")), "/")
+
+	// Get website configuration
+	config, err := h.store(c).GetBucketWebsite(bucket)
+	if err != nil || config == nil {
+		c.String(http.StatusNotFound, "Website configuration not found for this bucket")
+		return
+	}
+
+	if config.RedirectAllRequestsTo != nil {
+		protocol := config.RedirectAllRequestsTo.Protocol
+		if protocol == "" {
+			protocol = requestProtocol(c)
+		}
+		c.Redirect(http.StatusMovedPermanently, protocol+"://"+config.RedirectAllRequestsTo.HostName+"/"+key)
+		return
+	}
+
+	// Routing rules that only depend on the key apply before the object is looked up
+	if rule := matchRoutingRule(config.RoutingRules, key, 0); rule != nil {
+		h.redirectWebsite(c, base, key, rule)
+		return
+	}
+
+	indexSuffix := "index.html" // Default
+	if config.IndexDocument != nil && config.IndexDocument.Suffix != "" {
+		indexSuffix = config.IndexDocument.Suffix
+	}
+
+	// Determine the key to fetch
+	objectKey := key
+	isIndex := false
+	if key == "" || strings.HasSuffix(key, "/") {
+		// Directory request - append index document
+		objectKey = key + indexSuffix
+		isIndex = true
+	}
+
+	reader, obj, err := h.store(c).GetObject(bucket, objectKey, "")
+	if err == nil {
+		if obj.WebsiteRedirectLocation != "" {
+			reader.Close()
+			location := obj.WebsiteRedirectLocation
+			if strings.HasPrefix(location, "/") {
+				location = base + location
+			}
+			c.Redirect(http.StatusMovedPermanently, location)
+			return
+		}
+		h.writeWebsiteDocument(c, config, objectKey, http.StatusOK, reader, obj, isIndex)
+		return
+	}
+
+	status := http.StatusNotFound
+	if errors.Is(err, storage.ErrInvalidObjectState) {
+		status = http.StatusForbidden
+	} else if errors.Is(err, storage.ErrObjectCorrupted) {
+		status = http.StatusInternalServerError
+	}
+
+	// A directory requested without its trailing slash redirects to the slash form, like S3
+	if status == http.StatusNotFound && !isIndex {
+		if _, statErr := h.store(c).StatObject(bucket, key+"/"+indexSuffix, ""); statErr == nil {
+			c.Redirect(http.StatusFound, base+"/"+key+"/")
+			return
+		}
+	}
+
+	if rule := matchRoutingRule(config.RoutingRules, key, status); rule != nil {
+		h.redirectWebsite(c, base, key, rule)
+		return
+	}
+
+	// Single-page apps handle unknown paths client-side from the root index document
+	if config.SPAFallback && status == http.StatusNotFound && objectKey != indexSuffix {
+		if indexReader, indexObj, indexErr := h.store(c).GetObject(bucket, indexSuffix, ""); indexErr == nil {
+			h.writeWebsiteDocument(c, config, indexSuffix, http.StatusOK, indexReader, indexObj, true)
+			return
+		}
+	}
+
+	// Object not found - serve error document if configured
+	if config.ErrorDocument != nil && config.ErrorDocument.Key != "" {
+		errorReader, errorObj, errorErr := h.store(c).GetObject(bucket, config.ErrorDocument.Key, "")
+		if errorErr == nil {
+			h.writeWebsiteDocument(c, config, config.ErrorDocument.Key, status, errorReader, errorObj, false)
+			return
+		}
+	}
+	c.String(status, http.StatusText(status))
+}
+
+// writeWebsiteDocument sends an object as a website response, answering conditional
+// requests with 304/412 when the document is served with a 200 status
+func (h *S3Handler) writeWebsiteDocument(c *gin.Context, config *storage.WebsiteConfiguration, key string, status int, reader io.ReadCloser, obj *storage.Object, isIndex bool) {
+	defer reader.Close()
+
+	// Determine content type
+	contentType := obj.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		if extType := mime.TypeByExtension(filepath.Ext(key)); extType != "" {
+			contentType = extType
+		}
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	etag := fmt.Sprintf("\"%s\"", obj.VersionID)
+
+	// Set headers
+	c.Header("x-amz-version-id", obj.VersionID)
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", obj.ModTime.UTC().Format(http.TimeFormat))
+	cacheControl := config.CacheControl
+	if isIndex && config.IndexCacheControl != "" {
+		cacheControl = config.IndexCacheControl
+	}
+	if cacheControl != "" {
+		c.Header("Cache-Control", cacheControl)
+	}
+
+	if status == http.StatusOK {
+		if code := checkConditionalRequest(c.Request, etag, obj.ModTime); code != 0 {
+			c.Status(code)
+			return
+		}
+	}
+
+	c.Header("Content-Length", fmt.Sprintf("%d", obj.Size))
+	if c.Request.Method == http.MethodHead {
+		c.Header("Content-Type", contentType)
+		c.Status(status)
+		return
+	}
+	c.DataFromReader(status, obj.Size, contentType, reader, nil)
+}
+
+// checkConditionalRequest evaluates If-Match, If-Unmodified-Since, If-None-Match and
+// If-Modified-Since in the order defined by RFC 7232. It returns the status to reply
+// with, or 0 when the document should be sent.
+func checkConditionalRequest(r *http.Request, etag string, modTime time.Time) int {
+	modTime = modTime.UTC().Truncate(time.Second)
+
+	if match := r.Header.Get("If-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since := r.Header.Get("If-Unmodified-Since"); since != "" {
+		if t, err := http.ParseTime(since); err == nil && modTime.After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if noneMatch := r.Header.Get("If-None-Match"); noneMatch != "" {
+		if etagMatches(noneMatch, etag) {
+			return http.StatusNotModified
+		}
+	} else if since := r.Header.Get("If-Modified-Since"); since != "" {
+		if t, err := http.ParseTime(since); err == nil && !modTime.After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// etagMatches reports whether a comma-separated If-Match/If-None-Match list contains etag,
+// using weak comparison
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRoutingRule returns the first routing rule whose condition matches the key and, for
+// rules conditioned on an error code, the status the request produced (0 before lookup)
+func matchRoutingRule(rules []storage.RoutingRule, key string, status int) *storage.RoutingRule {
+	for i := range rules {
+		rule := &rules[i]
+		cond := rule.Condition
+		if cond == nil {
+			// Unconditional rules redirect every request
+			if status == 0 {
+				return rule
+			}
+			continue
+		}
+		if cond.KeyPrefixEquals != "" && !strings.HasPrefix(key, cond.KeyPrefixEquals) {
+			continue
+		}
+		if cond.HttpErrorCodeReturnedEquals == "" {
+			if status == 0 {
+				return rule
+			}
+			continue
+		}
+		if status != 0 && cond.HttpErrorCodeReturnedEquals == strconv.Itoa(status) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// redirectWebsite applies a routing rule redirect to the requested key
+func (h *S3Handler) redirectWebsite(c *gin.Context, base, key string, rule *storage.RoutingRule) {
+	redirect := rule.Redirect
+
+	newKey := key
+	if redirect.ReplaceKeyWith != "" {
+		newKey = redirect.ReplaceKeyWith
+	} else if redirect.ReplaceKeyPrefixWith != "" {
+		prefix := ""
+		if rule.Condition != nil {
+			prefix = rule.Condition.KeyPrefixEquals
+		}
+		newKey = redirect.ReplaceKeyPrefixWith + strings.TrimPrefix(key, prefix)
+	}
+
+	location := base + "/" + newKey
+	if redirect.HostName != "" {
+		protocol := redirect.Protocol
+		if protocol == "" {
+			protocol = requestProtocol(c)
+		}
+		location = protocol + "://" + redirect.HostName + "/" + newKey
+	}
+
+	// Rules saved before codes were validated may hold one gin cannot redirect with
+	code := http.StatusMovedPermanently
+	if storage.IsRedirectCode(redirect.HttpRedirectCode) {
+		code, _ = strconv.Atoi(redirect.HttpRedirectCode)
+	}
+	c.Redirect(code, location)
+}
+
+// requestProtocol returns the scheme the client used, honoring reverse proxies
+func requestProtocol(c *gin.Context) string {
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		return proto
+	}
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,154 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMatchRoutingRule(t *testing.T) {
+	rules := []storage.RoutingRule{
+		{Condition: &storage.RoutingRuleCondition{KeyPrefixEquals: "docs/"}, Redirect: storage.RoutingRuleRedirect{ReplaceKeyPrefixWith: "documents/"}},
+		{Condition: &storage.RoutingRuleCondition{HttpErrorCodeReturnedEquals: "404"}, Redirect: storage.RoutingRuleRedirect{HostName: "fallback.example.com"}},
+		{Condition: &storage.RoutingRuleCondition{KeyPrefixEquals: "img/", HttpErrorCodeReturnedEquals: "403"}, Redirect: storage.RoutingRuleRedirect{ReplaceKeyWith: "denied.png"}},
+	}
+	cases := []struct {
+		key    string
+		status int
+		want   int // Index of the matching rule, -1 for none
+	}{
+		{"docs/guide.html", 0, 0},
+		{"docs/guide.html", 404, 1},
+		{"blog/post.html", 0, -1},
+		{"blog/post.html", 404, 1},
+		{"img/cat.png", 403, 2},
+		{"css/site.css", 403, -1},
+		{"img/cat.png", 500, -1},
+	}
+	for _, tc := range cases {
+		rule := matchRoutingRule(rules, tc.key, tc.status)
+		got := -1
+		for i := range rules {
+			if rule == &rules[i] {
+				got = i
+			}
+		}
+		if got != tc.want {
+			t.Errorf("matchRoutingRule(%q, %d) = rule %d, want %d", tc.key, tc.status, got, tc.want)
+		}
+	}
+
+	// A rule without a condition redirects every request before the lookup
+	always := []storage.RoutingRule{{Redirect: storage.RoutingRuleRedirect{HostName: "new.example.com"}}}
+	if matchRoutingRule(always, "any", 0) == nil || matchRoutingRule(always, "any", 404) != nil {
+		t.Error("unconditional rule should only match before the lookup")
+	}
+}
+
+func TestRedirectWebsite(t *testing.T) {
+	cases := []struct {
+		name     string
+		rule     storage.RoutingRule
+		key      string
+		header   string
+		code     int
+		location string
+	}{
+		{
+			name:     "prefix",
+			rule:     storage.RoutingRule{Condition: &storage.RoutingRuleCondition{KeyPrefixEquals: "docs/"}, Redirect: storage.RoutingRuleRedirect{ReplaceKeyPrefixWith: "documents/"}},
+			key:      "docs/a/b.html",
+			code:     http.StatusMovedPermanently,
+			location: "/website/site/documents/a/b.html",
+		},
+		{
+			name:     "key and host",
+			rule:     storage.RoutingRule{Redirect: storage.RoutingRuleRedirect{HostName: "example.com", ReplaceKeyWith: "moved.html", HttpRedirectCode: "302"}},
+			key:      "old.html",
+			header:   "https",
+			code:     http.StatusFound,
+			location: "https://example.com/moved.html",
+		},
+		{
+			name:     "explicit protocol",
+			rule:     storage.RoutingRule{Redirect: storage.RoutingRuleRedirect{HostName: "example.com", Protocol: "http", HttpRedirectCode: "308"}},
+			key:      "page.html",
+			header:   "https",
+			code:     http.StatusPermanentRedirect,
+			location: "http://example.com/page.html",
+		},
+		{
+			// Saved before codes were validated; gin panics on redirects above 308
+			name:     "invalid code",
+			rule:     storage.RoutingRule{Redirect: storage.RoutingRuleRedirect{ReplaceKeyWith: "x.html", HttpRedirectCode: "399"}},
+			key:      "page.html",
+			code:     http.StatusMovedPermanently,
+			location: "/website/site/x.html",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/website/site/"+tc.key, nil)
+			if tc.header != "" {
+				c.Request.Header.Set("X-Forwarded-Proto", tc.header)
+			}
+			(&S3Handler{}).redirectWebsite(c, "/website/site", tc.key, &tc.rule)
+			if w.Code != tc.code || w.Header().Get("Location") != tc.location {
+				t.Errorf("redirect = %d %s, want %d %s", w.Code, w.Header().Get("Location"), tc.code, tc.location)
+			}
+		})
+	}
+}
+
+func TestWebsiteRedirectCodeValidation(t *testing.T) {
+	for code, valid := range map[string]bool{"301": true, "302": true, "303": true, "307": true, "308": true, "300": false, "304": false, "309": false, "399": false, "abc": false} {
+		config := storage.WebsiteConfiguration{RoutingRules: []storage.RoutingRule{{Redirect: storage.RoutingRuleRedirect{HttpRedirectCode: code}}}}
+		if err := config.Validate(); (err == nil) != valid {
+			t.Errorf("HttpRedirectCode %s: Validate() = %v", code, err)
+		}
+	}
+}
+
+func TestCheckConditionalRequest(t *testing.T) {
+	modTime := time.Date(2026, 3, 1, 12, 0, 0, 500, time.UTC)
+	etag := `"v1"`
+	before := modTime.Add(-time.Hour).Format(http.TimeFormat)
+	after := modTime.Add(time.Hour).Format(http.TimeFormat)
+	exact := modTime.Format(http.TimeFormat)
+
+	cases := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"none", nil, 0},
+		{"if-match hit", map[string]string{"If-Match": `"other", "v1"`}, 0},
+		{"if-match miss", map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed},
+		{"if-match star", map[string]string{"If-Match": "*"}, 0},
+		{"unmodified since later", map[string]string{"If-Unmodified-Since": after}, 0},
+		{"unmodified since earlier", map[string]string{"If-Unmodified-Since": before}, http.StatusPreconditionFailed},
+		{"if-match wins over unmodified since", map[string]string{"If-Match": `"v1"`, "If-Unmodified-Since": before}, 0},
+		{"none-match hit", map[string]string{"If-None-Match": `W/"v1"`}, http.StatusNotModified},
+		{"none-match miss", map[string]string{"If-None-Match": `"v0"`}, 0},
+		{"modified since same second", map[string]string{"If-Modified-Since": exact}, http.StatusNotModified},
+		{"modified since earlier", map[string]string{"If-Modified-Since": before}, 0},
+		{"none-match wins over modified since", map[string]string{"If-None-Match": `"v0"`, "If-Modified-Since": after}, 0},
+		{"precondition before not modified", map[string]string{"If-Match": `"v0"`, "If-None-Match": `"v1"`}, http.StatusPreconditionFailed},
+		{"unparsable date", map[string]string{"If-Modified-Since": "yesterday"}, 0},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range tc.headers {
+			r.Header.Set(k, v)
+		}
+		if got := checkConditionalRequest(r, etag, modTime); got != tc.want {
+			t.Errorf("%s: checkConditionalRequest = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
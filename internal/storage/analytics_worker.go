@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/health"
 	"github.com/GravSpace/GravSpace/internal/jobs"
 )
 
@@ -27,11 +28,20 @@ func (w *AnalyticsWorker) Start() {
 	// Run immediately on start to ensure we have today's data (if needed)
 	w.takeSnapshot()
 
+	heartbeat := health.Worker("analytics")
+	heartbeat.Beat()
+
 	// Then run daily
 	go func() {
 		ticker := time.NewTicker(24 * time.Hour)
-		for range ticker.C {
-			w.takeSnapshot()
+		beat := time.NewTicker(health.HeartbeatInterval)
+		for {
+			select {
+			case <-ticker.C:
+				w.takeSnapshot()
+			case <-beat.C:
+			}
+			heartbeat.Beat()
 		}
 	}()
 }
@@ -46,11 +56,13 @@ func (w *AnalyticsWorker) takeSnapshot() {
 	for _, bucket := range buckets {
 		// Enqueue snapshot job for each bucket to process in background
 		if w.jobs != nil {
-			w.jobs.Enqueue(&AnalyticsSnapshotJob{
+			if _, err := w.jobs.Enqueue(&AnalyticsSnapshotJob{
 				DB:      w.db,
 				Storage: w.storage,
 				Bucket:  bucket,
-			})
+			}); err != nil {
+				log.Printf("Analytics snapshot failed to queue bucket %s: %v", bucket, err)
+			}
 		}
 	}
 	log.Printf("Analytics snapshot jobs enqueued for %d buckets", len(buckets))
@@ -58,9 +70,18 @@ func (w *AnalyticsWorker) takeSnapshot() {
 
 // AnalyticsSnapshotJob implements jobs.Job for background analytics snapshots
 type AnalyticsSnapshotJob struct {
-	DB      *database.Database
-	Storage Storage
-	Bucket  string
+	DB      *database.Database `json:"-"`
+	Storage Storage            `json:"-"`
+	Bucket  string             `json:"bucket"`
+}
+
+func (j *AnalyticsSnapshotJob) Type() string {
+	return JobTypeAnalyticsSnapshot
+}
+
+// UniqueKey keeps a bucket from being snapshotted twice when a previous job is still pending
+func (j *AnalyticsSnapshotJob) UniqueKey() string {
+	return j.Type() + ":" + j.Bucket
 }
 
 func (j *AnalyticsSnapshotJob) Name() string {
@@ -0,0 +1,670 @@
+package storage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+// Batch operations applied to every object of a manifest
+const (
+	BatchOpCopy         = "copy"
+	BatchOpPutTags      = "put_tags"
+	BatchOpDeleteTags   = "delete_tags"
+	BatchOpDelete       = "delete"
+	BatchOpRestore      = "restore" // Restore objects from the trash
+	BatchOpRetention    = "retention"
+	BatchOpLegalHold    = "legal_hold"
+	BatchOpStorageClass = "storage_class"
+)
+
+// Manifest types
+const (
+	ManifestPrefix = "prefix" // All objects under a prefix, optionally filtered
+	ManifestCSV    = "csv"    // A CSV object with bucket,key[,versionId] rows
+	ManifestTags   = "tags"   // All objects under a prefix carrying the given tags
+)
+
+const (
+	batchPending   = "pending"
+	batchRunning   = "running"
+	batchCompleted = "completed"
+	batchFailed    = "failed"
+	batchCancelled = "cancelled"
+
+	batchResultSucceeded = "succeeded"
+	batchResultFailed    = "failed"
+
+	batchPageSize    = 500
+	defaultBatchRate = 100 // Objects per second
+)
+
+// BatchManifest selects the objects a batch job works on
+type BatchManifest struct {
+	Type   string            `json:"type"`
+	Bucket string            `json:"bucket"`
+	Prefix string            `json:"prefix,omitempty"`
+	Key    string            `json:"key,omitempty"`  // CSV manifest object in Bucket
+	Tags   map[string]string `json:"tags,omitempty"` // Tag query; objects must carry all of them
+	Filter *BatchFilter      `json:"filter,omitempty"`
+}
+
+// BatchFilter narrows down the objects of a prefix or tag manifest
+type BatchFilter struct {
+	Suffix         string     `json:"suffix,omitempty"`
+	MinSize        int64      `json:"minSize,omitempty"`
+	MaxSize        int64      `json:"maxSize,omitempty"`
+	ModifiedAfter  *time.Time `json:"modifiedAfter,omitempty"`
+	ModifiedBefore *time.Time `json:"modifiedBefore,omitempty"`
+	StorageClass   string     `json:"storageClass,omitempty"`
+}
+
+// BatchParams holds the arguments of a batch operation
+type BatchParams struct {
+	DestinationBucket string            `json:"destinationBucket,omitempty"` // copy
+	DestinationPrefix string            `json:"destinationPrefix,omitempty"` // copy
+	Tags              map[string]string `json:"tags,omitempty"`              // put_tags
+	RetentionMode     string            `json:"retentionMode,omitempty"`     // retention
+	RetainUntil       *time.Time        `json:"retainUntil,omitempty"`       // retention
+	LegalHold         *bool             `json:"legalHold,omitempty"`         // legal_hold
+	StorageClass      string            `json:"storageClass,omitempty"`      // storage_class
+	BypassGovernance  bool              `json:"bypassGovernance,omitempty"`  // delete
+}
+
+// BatchJobRequest describes a new batch job
+type BatchJobRequest struct {
+	Operation     string        `json:"operation"`
+	Manifest      BatchManifest `json:"manifest"`
+	Params        BatchParams   `json:"params"`
+	RatePerSecond int           `json:"ratePerSecond"` // 0 uses the default of 100 objects per second
+	ReportBucket  string        `json:"reportBucket"`  // Empty skips the completion report
+	ReportPrefix  string        `json:"reportPrefix"`
+	CreatedBy     string        `json:"-"`
+}
+
+// BatchJob is a batch job with its manifest and parameters decoded
+type BatchJob struct {
+	*database.BatchJobRow
+	Manifest BatchManifest `json:"manifest"`
+	Params   BatchParams   `json:"params"`
+}
+
+func decodeBatchJob(row *database.BatchJobRow) (*BatchJob, error) {
+	job := &BatchJob{BatchJobRow: row}
+	if err := json.Unmarshal([]byte(row.Manifest), &job.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest of batch job %d: %w", row.ID, err)
+	}
+	if err := json.Unmarshal([]byte(row.Params), &job.Params); err != nil {
+		return nil, fmt.Errorf("invalid parameters of batch job %d: %w", row.ID, err)
+	}
+	return job, nil
+}
+
+// CreateBatchJob validates a batch job and queues it
+func (s *FileStorage) CreateBatchJob(req *BatchJobRequest) (int64, error) {
+	if s.DB == nil {
+		return 0, fmt.Errorf("database not available")
+	}
+	if err := s.validateBatchJob(req); err != nil {
+		return 0, err
+	}
+
+	manifest, err := json.Marshal(req.Manifest)
+	if err != nil {
+		return 0, err
+	}
+	params, err := json.Marshal(req.Params)
+	if err != nil {
+		return 0, err
+	}
+	row := &database.BatchJobRow{
+		Operation:     req.Operation,
+		Manifest:      string(manifest),
+		Params:        string(params),
+		RatePerSecond: req.RatePerSecond,
+	}
+	if row.RatePerSecond <= 0 {
+		row.RatePerSecond = defaultBatchRate
+	}
+	if req.ReportBucket != "" {
+		row.ReportBucket = &req.ReportBucket
+		row.ReportPrefix = &req.ReportPrefix
+	}
+	if req.CreatedBy != "" {
+		row.CreatedBy = &req.CreatedBy
+	}
+
+	id, err := s.DB.CreateBatchJob(row)
+	if err != nil {
+		return 0, err
+	}
+
+	job := &BatchOperationJob{Storage: s, BatchID: id}
+	if s.Jobs == nil {
+		go func() {
+			if err := job.Execute(); err != nil {
+				job.Dead(err)
+			}
+		}()
+		return id, nil
+	}
+	if _, err := s.Jobs.Enqueue(job); err != nil {
+		msg := err.Error()
+		s.DB.FinishBatchJob(id, batchFailed, nil, &msg)
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *FileStorage) validateBatchJob(req *BatchJobRequest) error {
+	m := req.Manifest
+	if m.Bucket == "" {
+		return fmt.Errorf("manifest bucket is required")
+	}
+	if exists, _ := s.BucketExists(m.Bucket); !exists {
+		return fmt.Errorf("manifest bucket %s does not exist", m.Bucket)
+	}
+	switch m.Type {
+	case ManifestPrefix:
+	case ManifestCSV:
+		if m.Key == "" {
+			return fmt.Errorf("CSV manifest requires the key of the manifest object")
+		}
+	case ManifestTags:
+		if len(m.Tags) == 0 {
+			return fmt.Errorf("tag manifest requires at least one tag")
+		}
+		if req.Operation == BatchOpRestore {
+			return fmt.Errorf("objects in the trash cannot be selected by tags")
+		}
+	default:
+		return fmt.Errorf("unknown manifest type %q", m.Type)
+	}
+
+	p := req.Params
+	switch req.Operation {
+	case BatchOpCopy:
+		if p.DestinationBucket == "" {
+			return fmt.Errorf("copy requires a destination bucket")
+		}
+		if exists, _ := s.BucketExists(p.DestinationBucket); !exists {
+			return fmt.Errorf("destination bucket %s does not exist", p.DestinationBucket)
+		}
+		// Copies would be picked up again by the manifest walk
+		if m.Type != ManifestCSV && p.DestinationBucket == m.Bucket && strings.HasPrefix(p.DestinationPrefix, m.Prefix) {
+			return fmt.Errorf("destination prefix must be outside the manifest prefix when copying within a bucket")
+		}
+	case BatchOpPutTags:
+		if len(p.Tags) == 0 {
+			return fmt.Errorf("put_tags requires at least one tag")
+		}
+	case BatchOpDeleteTags, BatchOpDelete, BatchOpRestore:
+	case BatchOpRetention:
+		if p.RetentionMode != "GOVERNANCE" && p.RetentionMode != "COMPLIANCE" {
+			return fmt.Errorf("retention mode must be GOVERNANCE or COMPLIANCE")
+		}
+		if p.RetainUntil == nil || !p.RetainUntil.After(time.Now()) {
+			return fmt.Errorf("retention requires a retainUntil date in the future")
+		}
+	case BatchOpLegalHold:
+		if p.LegalHold == nil {
+			return fmt.Errorf("legal_hold requires legalHold to be true or false")
+		}
+	case BatchOpStorageClass:
+		if !IsArchivedStorageClass(p.StorageClass) {
+			return fmt.Errorf("storage class must be %s or %s", StorageClassGlacier, StorageClassDeepArchive)
+		}
+	default:
+		return fmt.Errorf("unknown batch operation %q", req.Operation)
+	}
+
+	if req.ReportBucket != "" {
+		if exists, _ := s.BucketExists(req.ReportBucket); !exists {
+			return fmt.Errorf("report bucket %s does not exist", req.ReportBucket)
+		}
+	}
+	return nil
+}
+
+func (s *FileStorage) GetBatchJob(id int64) (*BatchJob, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	row, err := s.DB.GetBatchJob(id)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return decodeBatchJob(row)
+}
+
+func (s *FileStorage) ListBatchJobs(status string, limit int) ([]*BatchJob, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	rows, err := s.DB.ListBatchJobs(status, limit)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*BatchJob, 0, len(rows))
+	for _, row := range rows {
+		job, err := decodeBatchJob(row)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, job)
+	}
+	return list, nil
+}
+
+// ListBatchJobResults pages through the per-object results of a batch job. It returns the
+// cursor to pass as after for the next page.
+func (s *FileStorage) ListBatchJobResults(id int64, status string, after int64, limit int) ([]*database.BatchJobResultRow, int64, error) {
+	if s.DB == nil {
+		return nil, 0, fmt.Errorf("database not available")
+	}
+	return s.DB.ListBatchJobResults(id, status, after, limit)
+}
+
+// CancelBatchJob stops a batch job after the object it is working on. The report covers
+// the objects processed until then.
+func (s *FileStorage) CancelBatchJob(id int64) (bool, error) {
+	if s.DB == nil {
+		return false, fmt.Errorf("database not available")
+	}
+	return s.DB.CancelBatchJob(id)
+}
+
+// BatchOperationJob implements jobs.Job for running a batch job. Progress is stored after every
+// page of the manifest, so a retried attempt resumes where the previous one stopped.
+type BatchOperationJob struct {
+	Storage *FileStorage `json:"-"`
+	BatchID int64        `json:"batch_id"`
+}
+
+func (j *BatchOperationJob) Type() string {
+	return JobTypeBatchOperation
+}
+
+func (j *BatchOperationJob) Name() string {
+	return fmt.Sprintf("Batch:%d", j.BatchID)
+}
+
+func (j *BatchOperationJob) Execute() error {
+	s := j.Storage
+	row, err := s.DB.GetBatchJob(j.BatchID)
+	if err != nil {
+		return err
+	}
+	if row == nil {
+		return nil
+	}
+	started, err := s.DB.StartBatchJob(j.BatchID)
+	if err != nil {
+		return err
+	}
+	if !started {
+		return nil
+	}
+	job, err := decodeBatchJob(row)
+	if err != nil {
+		msg := err.Error()
+		return s.DB.FinishBatchJob(j.BatchID, batchFailed, nil, &msg)
+	}
+
+	// The stored counters are those of the last checkpoint; objects finished after it are
+	// skipped by this attempt, so the counters are rebuilt from the recorded results
+	counts, err := s.DB.CountBatchJobResults(j.BatchID)
+	if err != nil {
+		return err
+	}
+	run := &batchRun{
+		storage:   s,
+		job:       job,
+		processed: counts[batchResultSucceeded] + counts[batchResultFailed],
+		succeeded: counts[batchResultSucceeded],
+		failed:    counts[batchResultFailed],
+		throttle:  time.NewTicker(time.Second / time.Duration(max(row.RatePerSecond, 1))),
+	}
+	defer run.throttle.Stop()
+
+	if err := run.walk(); err != nil && !errors.Is(err, errBatchCancelled) {
+		// Keep the job running so the next attempt resumes from the stored cursor
+		return fmt.Errorf("batch job %d: %w", j.BatchID, err)
+	}
+
+	reportKey, err := s.writeBatchReport(job.BatchJobRow)
+	if err != nil {
+		msg := fmt.Sprintf("failed to write report: %v", err)
+		return s.DB.FinishBatchJob(j.BatchID, batchFailed, nil, &msg)
+	}
+	log.Printf("Batch job %d (%s) finished: %d succeeded, %d failed", j.BatchID, job.Operation, run.succeeded, run.failed)
+	return s.DB.FinishBatchJob(j.BatchID, batchCompleted, reportKey, nil)
+}
+
+// Dead marks the batch job failed once its attempts are used up
+func (j *BatchOperationJob) Dead(err error) {
+	s := j.Storage
+	msg := err.Error()
+	var reportKey *string
+	if row, _ := s.DB.GetBatchJob(j.BatchID); row != nil {
+		reportKey, _ = s.writeBatchReport(row)
+	}
+	s.DB.FinishBatchJob(j.BatchID, batchFailed, reportKey, &msg)
+}
+
+var errBatchCancelled = errors.New("batch job cancelled")
+
+// batchRun walks the manifest of one attempt of a batch job
+type batchRun struct {
+	storage   *FileStorage
+	job       *BatchJob
+	processed int64
+	succeeded int64
+	failed    int64
+	throttle  *time.Ticker
+}
+
+func (r *batchRun) walk() error {
+	switch {
+	case r.job.Manifest.Type == ManifestCSV:
+		return r.walkCSV()
+	case r.job.Operation == BatchOpRestore:
+		return r.walkTrash()
+	default:
+		return r.walkObjects()
+	}
+}
+
+// walkObjects visits the current version of every object under the manifest prefix
+func (r *batchRun) walkObjects() error {
+	s := r.storage
+	m := r.job.Manifest
+	cursor := r.job.Cursor
+	for {
+		page, err := s.DB.ListObjectsAfter(m.Bucket, m.Prefix, cursor, batchPageSize)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page {
+			cursor = obj.Key
+			if obj.VersionID == "folder" || !r.matches(obj) {
+				continue
+			}
+			r.process(BulkItem{Bucket: obj.Bucket, Key: obj.Key})
+		}
+		if err := r.checkpoint(cursor); err != nil || len(page) < batchPageSize {
+			return err
+		}
+	}
+}
+
+// walkTrash visits every deleted version under the manifest prefix
+func (r *batchRun) walkTrash() error {
+	s := r.storage
+	m := r.job.Manifest
+	afterID, _ := strconv.ParseInt(r.job.Cursor, 10, 64)
+	for {
+		page, err := s.DB.ListTrashObjectsAfter(m.Bucket, m.Prefix, afterID, batchPageSize)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page {
+			afterID = obj.ID
+			if !r.matches(obj) {
+				continue
+			}
+			r.process(BulkItem{Bucket: obj.Bucket, Key: obj.Key, VersionID: obj.VersionID})
+		}
+		if err := r.checkpoint(strconv.FormatInt(afterID, 10)); err != nil || len(page) < batchPageSize {
+			return err
+		}
+	}
+}
+
+// walkCSV reads bucket,key[,versionId] rows from the manifest object. As in S3 manifests,
+// keys are URL-encoded.
+func (r *batchRun) walkCSV() error {
+	s := r.storage
+	m := r.job.Manifest
+	reader, _, err := s.GetObject(m.Bucket, m.Key, "")
+	if err != nil {
+		return fmt.Errorf("failed to open manifest %s/%s: %w", m.Bucket, m.Key, err)
+	}
+	defer reader.Close()
+
+	skip, _ := strconv.ParseInt(r.job.Cursor, 10, 64)
+	cr := csv.NewReader(reader)
+	cr.FieldsPerRecord = -1
+	var line int64
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return r.checkpoint(strconv.FormatInt(line, 10))
+		}
+		if err != nil {
+			return fmt.Errorf("invalid manifest at line %d: %w", line+1, err)
+		}
+		line++
+		if line <= skip {
+			continue
+		}
+		if len(record) < 2 {
+			if item := (BulkItem{Bucket: m.Bucket, Key: strings.Join(record, ",")}); !r.done(item) {
+				r.record(item, fmt.Errorf("manifest line %d needs bucket and key", line))
+			}
+			continue
+		}
+		key, err := url.QueryUnescape(record[1])
+		if err != nil {
+			key = record[1]
+		}
+		item := BulkItem{Bucket: record[0], Key: key}
+		if len(record) > 2 {
+			item.VersionID = record[2]
+		}
+		r.process(item)
+
+		if line%batchPageSize == 0 {
+			if err := r.checkpoint(strconv.FormatInt(line, 10)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkpoint stores progress and stops the walk when the job was cancelled
+func (r *batchRun) checkpoint(cursor string) error {
+	s := r.storage
+	if err := s.DB.UpdateBatchJobProgress(r.job.ID, r.processed, r.succeeded, r.failed, cursor); err != nil {
+		return err
+	}
+	row, err := s.DB.GetBatchJob(r.job.ID)
+	if err != nil {
+		return err
+	}
+	if row != nil && row.Status == batchCancelled {
+		return errBatchCancelled
+	}
+	return nil
+}
+
+func (r *batchRun) matches(obj *database.ObjectRow) bool {
+	m := r.job.Manifest
+	if f := m.Filter; f != nil {
+		if f.Suffix != "" && !strings.HasSuffix(obj.Key, f.Suffix) {
+			return false
+		}
+		if f.MinSize > 0 && obj.Size < f.MinSize {
+			return false
+		}
+		if f.MaxSize > 0 && obj.Size > f.MaxSize {
+			return false
+		}
+		if f.ModifiedAfter != nil && !obj.ModifiedAt.After(*f.ModifiedAfter) {
+			return false
+		}
+		if f.ModifiedBefore != nil && !obj.ModifiedAt.Before(*f.ModifiedBefore) {
+			return false
+		}
+		if f.StorageClass != "" {
+			class := StorageClassStandard
+			if obj.StorageClass != nil && *obj.StorageClass != "" {
+				class = *obj.StorageClass
+			}
+			if class != f.StorageClass {
+				return false
+			}
+		}
+	}
+	if m.Type == ManifestTags {
+		tags, err := r.storage.GetObjectTagging(obj.Bucket, obj.Key, obj.VersionID)
+		if err != nil {
+			return false
+		}
+		for k, v := range m.Tags {
+			if tags[k] != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// process applies the operation to one object, unless an earlier attempt already did
+func (r *batchRun) process(item BulkItem) {
+	if r.done(item) {
+		return
+	}
+	<-r.throttle.C
+	r.record(item, r.apply(item))
+}
+
+// done reports whether an earlier attempt already recorded a result for an object
+func (r *batchRun) done(item BulkItem) bool {
+	done, err := r.storage.DB.HasBatchJobResult(r.job.ID, item.Bucket, item.Key, item.VersionID)
+	return err == nil && done
+}
+
+func (r *batchRun) record(item BulkItem, err error) {
+	result := &database.BatchJobResultRow{
+		BatchID:   r.job.ID,
+		Bucket:    item.Bucket,
+		Key:       item.Key,
+		VersionID: item.VersionID,
+		Status:    batchResultSucceeded,
+	}
+	r.processed++
+	if err != nil {
+		msg := err.Error()
+		result.Status = batchResultFailed
+		result.Error = &msg
+		r.failed++
+	} else {
+		r.succeeded++
+	}
+	if err := r.storage.DB.RecordBatchJobResult(result); err != nil {
+		log.Printf("Batch job %d: failed to record result for %s/%s: %v", r.job.ID, item.Bucket, item.Key, err)
+	}
+}
+
+func (r *batchRun) apply(item BulkItem) error {
+	s := r.storage
+	p := r.job.Params
+	switch r.job.Operation {
+	case BatchOpCopy:
+		reader, stat, err := s.GetObject(item.Bucket, item.Key, item.VersionID)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		_, err = s.PutObject(p.DestinationBucket, p.DestinationPrefix+item.Key, reader, stat.EncryptionType)
+		return err
+	case BatchOpPutTags:
+		return s.PutObjectTagging(item.Bucket, item.Key, item.VersionID, p.Tags)
+	case BatchOpDeleteTags:
+		return s.PutObjectTagging(item.Bucket, item.Key, item.VersionID, map[string]string{})
+	case BatchOpDelete:
+		return s.DeleteObject(item.Bucket, item.Key, item.VersionID, p.BypassGovernance)
+	case BatchOpRestore:
+		if item.VersionID == "" {
+			return fmt.Errorf("restoring from the trash requires a version ID")
+		}
+		return s.RestoreObject(item.Bucket, item.Key, item.VersionID)
+	}
+
+	// The remaining operations change a specific version
+	row, err := s.DB.GetObject(item.Bucket, item.Key, item.VersionID)
+	if err != nil {
+		return err
+	}
+	if row == nil {
+		return fmt.Errorf("object not found")
+	}
+	switch r.job.Operation {
+	case BatchOpRetention:
+		return s.SetObjectRetention(row.Bucket, row.Key, row.VersionID, *p.RetainUntil, p.RetentionMode)
+	case BatchOpLegalHold:
+		return s.SetObjectLegalHold(row.Bucket, row.Key, row.VersionID, *p.LegalHold, fmt.Sprintf("Batch job %d", r.job.ID))
+	case BatchOpStorageClass:
+		if row.StorageClass != nil && *row.StorageClass == p.StorageClass {
+			return nil
+		}
+		return s.TransitionObject(row, p.StorageClass)
+	}
+	return fmt.Errorf("unknown batch operation %q", r.job.Operation)
+}
+
+// writeBatchReport stores the per-object results as a CSV object in the report bucket
+func (s *FileStorage) writeBatchReport(row *database.BatchJobRow) (*string, error) {
+	if row.ReportBucket == nil || *row.ReportBucket == "" {
+		return nil, nil
+	}
+	prefix := ""
+	if row.ReportPrefix != nil {
+		prefix = *row.ReportPrefix
+	}
+	key := fmt.Sprintf("%sbatch-%d/report.csv", prefix, row.ID)
+
+	pr, pw := io.Pipe()
+	go func() {
+		w := csv.NewWriter(pw)
+		w.Write([]string{"Bucket", "Key", "VersionId", "Status", "Error"})
+		var after int64
+		for {
+			page, next, err := s.DB.ListBatchJobResults(row.ID, "", after, batchPageSize)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			for _, r := range page {
+				msg := ""
+				if r.Error != nil {
+					msg = *r.Error
+				}
+				w.Write([]string{r.Bucket, url.QueryEscape(r.Key), r.VersionID, r.Status, msg})
+			}
+			if len(page) < batchPageSize {
+				break
+			}
+			after = next
+		}
+		w.Flush()
+		pw.CloseWithError(w.Error())
+	}()
+
+	_, err := s.PutObject(*row.ReportBucket, key, pr, "")
+	pr.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/jobs"
+)
+
+func TestBatchJobs(t *testing.T) {
+	store := newTestStorage(t, Options{})
+	db := store.DB
+	// Jobs are queued but not started, the test runs them
+	store.Jobs = jobs.NewManager(db, 1)
+	store.Jobs.Register(JobTypeBatchOperation, 3, func() jobs.Job { return &BatchOperationJob{Storage: store} })
+	store.CreateBucket("photos")
+	store.CreateBucket("reports")
+	store.SetBucketVersioning("photos", true)
+	for _, key := range []string{"docs/a.txt", "docs/b.txt", "docs/c.txt", "other/x.txt", "list.csv"} {
+		content := "content of " + key
+		if key == "list.csv" {
+			content = "photos,docs%2Fa.txt\nphotos,docs/missing.txt\nmalformed\nphotos,other%2Fx.txt\n"
+		}
+		if _, err := store.PutObject("photos", key, strings.NewReader(content), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	create := func(manifest BatchManifest, tag string) int64 {
+		t.Helper()
+		id, err := store.CreateBatchJob(&BatchJobRequest{
+			Operation: BatchOpPutTags, Manifest: manifest, Params: BatchParams{Tags: map[string]string{"batch": tag}},
+			RatePerSecond: 1000, ReportBucket: "reports", ReportPrefix: "batch/",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	run := func(id int64) *database.BatchJobRow {
+		t.Helper()
+		if err := (&BatchOperationJob{Storage: store, BatchID: id}).Execute(); err != nil {
+			t.Fatal(err)
+		}
+		row, err := db.GetBatchJob(id)
+		if err != nil || row == nil {
+			t.Fatalf("batch job %d = %v, %v", id, row, err)
+		}
+		return row
+	}
+	tagged := func(key, tag string) bool {
+		t.Helper()
+		tags, err := store.GetObjectTagging("photos", key, "")
+		return err == nil && tags["batch"] == tag
+	}
+
+	// A prefix manifest covers every object under the prefix
+	row := run(create(BatchManifest{Type: ManifestPrefix, Bucket: "photos", Prefix: "docs/"}, "prefix"))
+	if row.Status != batchCompleted || row.Processed != 3 || row.Succeeded != 3 || row.Failed != 0 {
+		t.Errorf("prefix job = %+v", row)
+	}
+	if !tagged("docs/a.txt", "prefix") || !tagged("docs/c.txt", "prefix") || tagged("other/x.txt", "prefix") {
+		t.Error("prefix job tagged the wrong objects")
+	}
+	if row.ReportKey == nil || *row.ReportKey != fmt.Sprintf("batch/batch-%d/report.csv", row.ID) {
+		t.Errorf("report key = %v", row.ReportKey)
+	}
+
+	// A CSV manifest lists URL-encoded keys; missing objects and malformed lines fail
+	row = run(create(BatchManifest{Type: ManifestCSV, Bucket: "photos", Key: "list.csv"}, "csv"))
+	if row.Status != batchCompleted || row.Processed != 4 || row.Succeeded != 2 || row.Failed != 2 {
+		t.Errorf("CSV job = %+v", row)
+	}
+	if !tagged("docs/a.txt", "csv") || !tagged("other/x.txt", "csv") || tagged("docs/b.txt", "csv") {
+		t.Error("CSV job tagged the wrong objects")
+	}
+	failed, _, err := store.ListBatchJobResults(row.ID, batchResultFailed, 0, 10)
+	if err != nil || len(failed) != 2 || failed[0].Key != "docs/missing.txt" || !strings.Contains(*failed[1].Error, "needs bucket and key") {
+		t.Errorf("failed results = %+v, %v", failed, err)
+	}
+
+	// An attempt that stopped after its last checkpoint left results the counters miss
+	id := create(BatchManifest{Type: ManifestPrefix, Bucket: "photos", Prefix: "docs/"}, "resumed")
+	if _, err := db.StartBatchJob(id); err != nil {
+		t.Fatal(err)
+	}
+	msg := "earlier failure"
+	for _, r := range []*database.BatchJobResultRow{
+		{BatchID: id, Bucket: "photos", Key: "docs/a.txt", Status: batchResultSucceeded},
+		{BatchID: id, Bucket: "photos", Key: "docs/b.txt", Status: batchResultFailed, Error: &msg},
+	} {
+		if err := db.RecordBatchJobResult(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	row = run(id)
+	if row.Status != batchCompleted || row.Processed != 3 || row.Succeeded != 2 || row.Failed != 1 {
+		t.Errorf("resumed job = %+v", row)
+	}
+	if tagged("docs/a.txt", "resumed") || !tagged("docs/c.txt", "resumed") {
+		t.Error("resumed job applied the operation to an object processed before")
+	}
+
+	// A cancelled job is not run and cannot be cancelled again
+	id = create(BatchManifest{Type: ManifestPrefix, Bucket: "photos", Prefix: "docs/"}, "cancelled")
+	if cancelled, err := store.CancelBatchJob(id); err != nil || !cancelled {
+		t.Fatalf("cancel = %v, %v", cancelled, err)
+	}
+	row = run(id)
+	if row.Status != batchCancelled || row.Processed != 0 || tagged("docs/c.txt", "cancelled") {
+		t.Errorf("cancelled job = %+v", row)
+	}
+	if cancelled, err := store.CancelBatchJob(id); err != nil || cancelled {
+		t.Errorf("second cancel = %v, %v", cancelled, err)
+	}
+}
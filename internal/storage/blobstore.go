@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrBlobNotFound is returned by a BlobStore for an address it holds no blob under
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	Address string    `json:"address"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// BlobStore keeps the CAS blobs apart from the metadata, buckets and versions FileStorage
+// manages. Blobs are immutable and addressed by content: a SHA-256 hex digest.
+//
+// Without a BlobStore, FileStorage keeps the blobs in .cas on its data drives, where object
+// files are hard links of them and blobs can be mirrored or erasure-coded. With one, object
+// files are empty stubs and every read and write of content goes through the store.
+type BlobStore interface {
+	// Put stores the bytes read from r and returns their content address. address is the one
+	// the caller computed for the content, e.g. the hash of the plaintext a compressed or
+	// encrypted blob encodes; when empty, the SHA-256 of the bytes is used. A blob already
+	// stored under the address is kept.
+	Put(address string, r io.Reader) (string, error)
+	// Get reads length bytes of a blob from offset; a negative length reads to the end
+	Get(address string, offset, length int64) (io.ReadCloser, error)
+	Stat(address string) (BlobInfo, error)
+	// Delete removes a blob; deleting one that is not stored is not an error
+	Delete(address string) error
+	// List returns up to limit blobs (all of them when limit is not positive) whose address
+	// sorts after the given one, in address order, so that garbage collection can page
+	// through the whole store
+	List(after string, limit int) ([]BlobInfo, error)
+}
+
+func checkAddress(address string) error {
+	if !isCASBlobName(address) {
+		return fmt.Errorf("invalid blob address %q: expected a SHA-256 hex digest", address)
+	}
+	return nil
+}
+
+// LocalBlobStore keeps blobs in the .cas directory of Root, laid out like the CAS of a data
+// drive: .cas/<first two hex digits>/<address>
+type LocalBlobStore struct {
+	Root string
+}
+
+func NewLocalBlobStore(root string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(filepath.Join(root, ".cas"), 0755); err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{Root: root}, nil
+}
+
+func (l *LocalBlobStore) path(address string) string {
+	return blobPathOn(l.Root, address)
+}
+
+// Put writes the blob to a temp file and renames it into place. An *os.File read from its
+// start is hard-linked instead of copied when the address is given and it is on the same
+// filesystem.
+func (l *LocalBlobStore) Put(address string, r io.Reader) (string, error) {
+	if address != "" {
+		if err := checkAddress(address); err != nil {
+			return "", err
+		}
+		dst := l.path(address)
+		if _, err := os.Stat(dst); err == nil {
+			return address, nil
+		}
+		if file, ok := r.(*os.File); ok {
+			if pos, err := file.Seek(0, io.SeekCurrent); err == nil && pos == 0 {
+				if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+					return "", err
+				}
+				if err := os.Link(file.Name(), dst); err == nil || os.IsExist(err) {
+					return address, nil
+				}
+			}
+		}
+	}
+
+	casDir := filepath.Join(l.Root, ".cas")
+	if err := os.MkdirAll(casDir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(casDir, ".tmp-put-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	buf := bufferPool.Get().([]byte)
+	_, err = io.CopyBuffer(io.MultiWriter(tmp, hash), r, buf)
+	bufferPool.Put(buf)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if address == "" {
+		address = hex.EncodeToString(hash.Sum(nil))
+	}
+	dst := l.path(address)
+	if _, err := os.Stat(dst); err == nil {
+		return address, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	return address, os.Rename(tmp.Name(), dst)
+}
+
+func (l *LocalBlobStore) Get(address string, offset, length int64) (io.ReadCloser, error) {
+	if !isCASBlobName(address) {
+		return nil, ErrBlobNotFound
+	}
+	file, err := os.Open(l.path(address))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return readSection(file, offset, length)
+}
+
+func (l *LocalBlobStore) Stat(address string) (BlobInfo, error) {
+	if !isCASBlobName(address) {
+		return BlobInfo{}, ErrBlobNotFound
+	}
+	info, err := os.Stat(l.path(address))
+	if os.IsNotExist(err) {
+		return BlobInfo{}, ErrBlobNotFound
+	} else if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Address: address, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *LocalBlobStore) Delete(address string) error {
+	if !isCASBlobName(address) {
+		return nil
+	}
+	path := l.path(address)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(filepath.Dir(path)) // Clean parent folder if empty
+	return nil
+}
+
+func (l *LocalBlobStore) List(after string, limit int) ([]BlobInfo, error) {
+	casDir := filepath.Join(l.Root, ".cas")
+	dirs, err := os.ReadDir(casDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var blobs []BlobInfo
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 || (len(after) >= 2 && dir.Name() < after[:2]) {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(casDir, dir.Name()))
+		if err != nil {
+			return blobs, err
+		}
+		for _, e := range entries {
+			if e.IsDir() || !isCASBlobName(e.Name()) || e.Name() <= after {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue // Deleted since the listing
+			}
+			blobs = append(blobs, BlobInfo{Address: e.Name(), Size: info.Size(), ModTime: info.ModTime()})
+			if len(blobs) == limit {
+				return blobs, nil
+			}
+		}
+	}
+	return blobs, nil
+}
+
+// MemoryBlobStore keeps blobs in memory, for tests
+type MemoryBlobStore struct {
+	mu    sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemoryBlobStore() *MemoryBlobStore {
+	return &MemoryBlobStore{blobs: make(map[string]memoryBlob)}
+}
+
+func (m *MemoryBlobStore) Put(address string, r io.Reader) (string, error) {
+	if address != "" {
+		if err := checkAddress(address); err != nil {
+			return "", err
+		}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if address == "" {
+		sum := sha256.Sum256(data)
+		address = hex.EncodeToString(sum[:])
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[address]; !ok {
+		m.blobs[address] = memoryBlob{data: data, modTime: time.Now()}
+	}
+	return address, nil
+}
+
+func (m *MemoryBlobStore) Get(address string, offset, length int64) (io.ReadCloser, error) {
+	m.mu.RLock()
+	blob, ok := m.blobs[address]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	return readSection(io.NopCloser(bytes.NewReader(blob.data)), offset, length)
+}
+
+func (m *MemoryBlobStore) Stat(address string) (BlobInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, ok := m.blobs[address]
+	if !ok {
+		return BlobInfo{}, ErrBlobNotFound
+	}
+	return BlobInfo{Address: address, Size: int64(len(blob.data)), ModTime: blob.modTime}, nil
+}
+
+func (m *MemoryBlobStore) Delete(address string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, address)
+	return nil
+}
+
+func (m *MemoryBlobStore) List(after string, limit int) ([]BlobInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var blobs []BlobInfo
+	for address, blob := range m.blobs {
+		if address > after {
+			blobs = append(blobs, BlobInfo{Address: address, Size: int64(len(blob.data)), ModTime: blob.modTime})
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Address < blobs[j].Address })
+	if limit > 0 && len(blobs) > limit {
+		blobs = blobs[:limit]
+	}
+	return blobs, nil
+}
+
+// readCloser closes a reader that wraps another one
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// readSection returns length bytes of r from offset, all of the rest when length is negative.
+// Seekable readers seek to the offset; others skip to it. Closing the result closes r.
+func readSection(r io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		var err error
+		if seeker, ok := r.(io.Seeker); ok {
+			_, err = seeker.Seek(offset, io.SeekStart)
+		} else if _, err = io.CopyN(io.Discard, r, offset); err == io.EOF {
+			err = nil // Past the end: nothing is left to read
+		}
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return r, nil
+	}
+	return readCloser{io.LimitReader(r, length), r}, nil
+}
+
+// storeInBlobStore puts the file at tmpPath into the blob store as the blob of hash and
+// reports whether the blob was already stored. tmpPath is removed in every case.
+func (s *FileStorage) storeInBlobStore(tmpPath, hash string) (existed bool, err error) {
+	defer os.Remove(tmpPath)
+	if _, err := s.Blobs.Stat(hash); err == nil {
+		return true, nil
+	} else if !errors.Is(err, ErrBlobNotFound) {
+		return false, err
+	}
+	file, err := os.Open(tmpPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	_, err = s.Blobs.Put(hash, file)
+	return false, err
+}
+
+// inBlobStore reports whether the versions of a blob are stubs read from the blob store.
+// Content stored on the data drives before the blob store was configured stays there.
+func (s *FileStorage) inBlobStore(hash string) bool {
+	return s.Blobs != nil && len(s.blobCopies(hash)) == 0 && s.erasureBlob(hash) == nil
+}
@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// s3PartSize is the part size of multipart uploads; smaller blobs are stored with a single PUT
+const s3PartSize = 64 << 20
+
+// s3Timeout bounds every request of an S3BlobStore but the transfer of blob contents
+const s3Timeout = time.Minute
+
+// S3BlobStoreOptions locates the bucket an S3BlobStore keeps its blobs in
+type S3BlobStoreOptions struct {
+	// Endpoint is the URL of an S3-compatible service; empty for AWS
+	Endpoint string
+	// Region defaults to us-east-1
+	Region string
+	Bucket string
+	// Prefix is prepended to the keys of the blobs, e.g. "gravspace/"
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	UsePathStyle bool
+}
+
+// S3BlobStore keeps blobs as objects of an S3-compatible bucket, keyed like the CAS of a data
+// drive: <prefix><first two hex digits>/<address>
+type S3BlobStore struct {
+	client *awss3.Client
+	bucket string
+	prefix string
+}
+
+// NewS3BlobStore returns a store on the bucket, which must exist and be reachable
+func NewS3BlobStore(opts S3BlobStoreOptions) (*S3BlobStore, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("S3 blob store: no bucket configured")
+	}
+	region := opts.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	options := awss3.Options{
+		Region:       region,
+		Credentials:  credentials.NewStaticCredentialsProvider(opts.AccessKey, opts.SecretKey, ""),
+		UsePathStyle: opts.UsePathStyle,
+		// Plain PUTs only; aws-chunked trailing checksums are not understood by every S3 implementation
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	}
+	if opts.Endpoint != "" {
+		options.BaseEndpoint = aws.String(opts.Endpoint)
+	}
+	s := &S3BlobStore{client: awss3.New(options), bucket: opts.Bucket, prefix: opts.Prefix}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	if _, err := s.client.HeadBucket(ctx, &awss3.HeadBucketInput{Bucket: aws.String(s.bucket)}); err != nil {
+		return nil, fmt.Errorf("S3 blob store: bucket %s: %w", s.bucket, err)
+	}
+	return s, nil
+}
+
+func (s *S3BlobStore) key(address string) string {
+	return s.prefix + address[:2] + "/" + address
+}
+
+// Put uploads the blob from a file, which r is spooled to unless it is one already, so that
+// requests can be signed and retried; blobs larger than s3PartSize are uploaded in parts
+func (s *S3BlobStore) Put(address string, r io.Reader) (string, error) {
+	if address != "" {
+		if err := checkAddress(address); err != nil {
+			return "", err
+		}
+		if _, err := s.Stat(address); err == nil {
+			return address, nil
+		} else if !errors.Is(err, ErrBlobNotFound) {
+			return "", err
+		}
+	}
+
+	file, ok := r.(*os.File)
+	var start int64
+	if ok && address != "" {
+		var err error
+		if start, err = file.Seek(0, io.SeekCurrent); err != nil {
+			ok = false
+		}
+	}
+	if !ok || address == "" {
+		spool, err := os.CreateTemp("", "gravspace-blob-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		hash := sha256.New()
+		buf := bufferPool.Get().([]byte)
+		_, err = io.CopyBuffer(io.MultiWriter(spool, hash), r, buf)
+		bufferPool.Put(buf)
+		if err != nil {
+			return "", err
+		}
+		if address == "" {
+			address = hex.EncodeToString(hash.Sum(nil))
+			if _, err := s.Stat(address); err == nil {
+				return address, nil
+			}
+		}
+		file, start = spool, 0
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size() - start
+
+	ctx := context.Background()
+	key := aws.String(s.key(address))
+	if size <= s3PartSize {
+		_, err = s.client.PutObject(ctx, &awss3.PutObjectInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           key,
+			Body:          io.NewSectionReader(file, start, size),
+			ContentLength: aws.Int64(size),
+		})
+		return address, err
+	}
+	return address, s.putParts(ctx, key, file, start, size)
+}
+
+func (s *S3BlobStore) putParts(ctx context.Context, key *string, file *os.File, start, size int64) error {
+	upload, err := s.client.CreateMultipartUpload(ctx, &awss3.CreateMultipartUploadInput{Bucket: aws.String(s.bucket), Key: key})
+	if err != nil {
+		return err
+	}
+	var parts []types.CompletedPart
+	for offset := int64(0); offset < size && err == nil; offset += s3PartSize {
+		length := min(s3PartSize, size-offset)
+		number := aws.Int32(int32(len(parts) + 1))
+		var out *awss3.UploadPartOutput
+		out, err = s.client.UploadPart(ctx, &awss3.UploadPartInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           key,
+			UploadId:      upload.UploadId,
+			PartNumber:    number,
+			Body:          io.NewSectionReader(file, start+offset, length),
+			ContentLength: aws.Int64(length),
+		})
+		if err == nil {
+			parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: number})
+		}
+	}
+	if err == nil {
+		_, err = s.client.CompleteMultipartUpload(ctx, &awss3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             key,
+			UploadId:        upload.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if err != nil {
+		abortCtx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+		defer cancel()
+		s.client.AbortMultipartUpload(abortCtx, &awss3.AbortMultipartUploadInput{Bucket: aws.String(s.bucket), Key: key, UploadId: upload.UploadId})
+	}
+	return err
+}
+
+func (s *S3BlobStore) Get(address string, offset, length int64) (io.ReadCloser, error) {
+	if !isCASBlobName(address) || length == 0 {
+		if _, err := s.Stat(address); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	input := &awss3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key(address))}
+	if offset > 0 || length > 0 {
+		ranged := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			ranged += fmt.Sprint(offset + length - 1)
+		}
+		input.Range = aws.String(ranged)
+	}
+	out, err := s.client.GetObject(context.Background(), input)
+	if isS3Error(err, "InvalidRange") {
+		// The offset is past the end
+		return io.NopCloser(strings.NewReader("")), nil
+	} else if err != nil {
+		return nil, s3BlobError(err)
+	}
+	return out.Body, nil
+}
+
+func (s *S3BlobStore) Stat(address string) (BlobInfo, error) {
+	if !isCASBlobName(address) {
+		return BlobInfo{}, ErrBlobNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	out, err := s.client.HeadObject(ctx, &awss3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key(address))})
+	if err != nil {
+		return BlobInfo{}, s3BlobError(err)
+	}
+	return BlobInfo{Address: address, Size: aws.ToInt64(out.ContentLength), ModTime: aws.ToTime(out.LastModified)}, nil
+}
+
+func (s *S3BlobStore) Delete(address string) error {
+	if !isCASBlobName(address) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	_, err := s.client.DeleteObject(ctx, &awss3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key(address))})
+	if err = s3BlobError(err); errors.Is(err, ErrBlobNotFound) {
+		return nil
+	}
+	return err
+}
+
+// List pages through the bucket with ListObjectsV2. Keys sort like their addresses, since the
+// directory part of a key is the start of its address. Objects that are not blobs are skipped.
+func (s *S3BlobStore) List(after string, limit int) ([]BlobInfo, error) {
+	input := &awss3.ListObjectsV2Input{Bucket: aws.String(s.bucket), Prefix: aws.String(s.prefix)}
+	if isCASBlobName(after) {
+		input.StartAfter = aws.String(s.key(after))
+	}
+	var blobs []BlobInfo
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+		out, err := s.client.ListObjectsV2(ctx, input)
+		cancel()
+		if err != nil {
+			return blobs, err
+		}
+		for _, obj := range out.Contents {
+			dir, address, _ := strings.Cut(strings.TrimPrefix(aws.ToString(obj.Key), s.prefix), "/")
+			if !isCASBlobName(address) || !strings.HasPrefix(address, dir) || len(dir) != 2 || address <= after {
+				continue
+			}
+			blobs = append(blobs, BlobInfo{Address: address, Size: aws.ToInt64(obj.Size), ModTime: aws.ToTime(obj.LastModified)})
+			if len(blobs) == limit {
+				return blobs, nil
+			}
+		}
+		if !aws.ToBool(out.IsTruncated) || out.NextContinuationToken == nil {
+			return blobs, nil
+		}
+		input.ContinuationToken = out.NextContinuationToken
+	}
+}
+
+// s3BlobError turns the errors S3 reports for a missing key into ErrBlobNotFound
+func s3BlobError(err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return ErrBlobNotFound
+	}
+	return err
+}
+
+func isS3Error(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
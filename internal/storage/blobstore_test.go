@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlobStores(t *testing.T) {
+	local, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]BlobStore{"local": local, "memory": NewMemoryBlobStore()}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			read := func(address string, offset, length int64) string {
+				t.Helper()
+				reader, err := store.Get(address, offset, length)
+				if err != nil {
+					t.Fatalf("get %s: %v", address, err)
+				}
+				defer reader.Close()
+				data, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return string(data)
+			}
+
+			// Without an address the blob is addressed by its SHA-256
+			sum := sha256.Sum256([]byte("hello, blob"))
+			address, err := store.Put("", strings.NewReader("hello, blob"))
+			if err != nil || address != hex.EncodeToString(sum[:]) {
+				t.Fatalf("put = %s, %v", address, err)
+			}
+			if got := read(address, 0, -1); got != "hello, blob" {
+				t.Errorf("get = %q", got)
+			}
+			if got := read(address, 7, 2); got != "bl" {
+				t.Errorf("range 7+2 = %q", got)
+			}
+			if got := read(address, 7, -1); got != "blob" {
+				t.Errorf("range from 7 = %q", got)
+			}
+			if got := read(address, 50, 10); got != "" {
+				t.Errorf("range past the end = %q", got)
+			}
+			if info, err := store.Stat(address); err != nil || info.Size != 11 {
+				t.Errorf("stat = %+v, %v", info, err)
+			}
+
+			// A given address is kept, and a second put of it does not replace the blob
+			given := strings.Repeat("ab", 32)
+			if got, err := store.Put(given, strings.NewReader("first")); err != nil || got != given {
+				t.Fatalf("put with an address = %s, %v", got, err)
+			}
+			store.Put(given, strings.NewReader("second"))
+			if got := read(given, 0, -1); got != "first" {
+				t.Errorf("blob after a second put = %q", got)
+			}
+			if _, err := store.Put("../escape", strings.NewReader("x")); err == nil {
+				t.Error("put accepted an address that is not a hash")
+			}
+
+			// Listing pages in address order
+			third := strings.Repeat("0f", 32)
+			store.Put(third, strings.NewReader("third"))
+			all, err := store.List("", 0)
+			if err != nil || len(all) != 3 || all[0].Address != third || all[1].Address != given || all[2].Address != address {
+				t.Fatalf("list = %+v, %v", all, err)
+			}
+			page, _ := store.List(third, 1)
+			if len(page) != 1 || page[0].Address != given {
+				t.Errorf("page after %s = %+v", third, page)
+			}
+
+			if err := store.Delete(given); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete(given); err != nil {
+				t.Errorf("deleting a missing blob = %v", err)
+			}
+			if _, err := store.Stat(given); !errors.Is(err, ErrBlobNotFound) {
+				t.Errorf("stat of a deleted blob = %v", err)
+			}
+			if _, err := store.Get(given, 0, -1); !errors.Is(err, ErrBlobNotFound) {
+				t.Errorf("get of a deleted blob = %v", err)
+			}
+		})
+	}
+
+	// The local store uses the CAS layout of a data drive
+	address, _ := local.Put("", strings.NewReader("layout"))
+	if _, err := os.Stat(filepath.Join(local.Root, ".cas", address[:2], address)); err != nil {
+		t.Errorf("blob not in the CAS layout: %v", err)
+	}
+}
+
+func TestBlobStoreBackedStorage(t *testing.T) {
+	blobs := NewMemoryBlobStore()
+	store := newTestStorage(t, Options{Blobs: blobs})
+	db := store.DB
+	store.CreateBucket("docs")
+	store.CreateBucket("copies")
+	if err := store.SetBucketErasure("docs", 2, 1); !errors.Is(err, ErrErasureLayout) {
+		t.Errorf("erasure coding with a blob store = %v", err)
+	}
+
+	content := []byte("the quick brown fox jumps over the lazy dog")
+	for _, bucket := range []string{"docs", "copies"} {
+		if _, err := store.PutObject(bucket, "fox.txt", bytes.NewReader(content), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	row, _ := db.GetObject("docs", "fox.txt", "simple")
+	hash := *row.ContentHash
+
+	// The content is stored once in the blob store; object files are empty stubs
+	if stored, _ := blobs.List("", 0); len(stored) != 1 || stored[0].Address != hash {
+		t.Fatalf("blob store holds %+v", stored)
+	}
+	if len(store.blobCopies(hash)) != 0 {
+		t.Errorf("the blob was also stored on the drives: %v", store.blobCopies(hash))
+	}
+	if info, err := os.Stat(store.rowPath(row)); err != nil || info.Size() != 0 {
+		t.Fatalf("object file = %v, %v", info, err)
+	}
+
+	reader, obj, err := store.GetObjectRange("docs", "fox.txt", "", 4, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(got) != "quick" || obj.Size != int64(len(content)) {
+		t.Errorf("range read = %q of %d bytes", got, obj.Size)
+	}
+
+	// An unreferenced blob is collected; the shared one survives the deletion of one object
+	orphan, _ := blobs.Put("", strings.NewReader("nobody references this"))
+	if err := store.DeleteObject("copies", "fox.txt", "", false); err != nil {
+		t.Fatal(err)
+	}
+	report, err := store.GarbageCollectCAS(false)
+	if err != nil || len(report.Removed) != 1 || report.Removed[0] != orphan {
+		t.Fatalf("gc = %+v, %v", report, err)
+	}
+	if _, err := blobs.Stat(hash); err != nil {
+		t.Fatalf("referenced blob = %v", err)
+	}
+
+	// fsck verifies the content in the store and reports a blob that went missing
+	if report, err := store.Fsck(FsckOptions{}); err != nil || len(report.Issues) != 0 {
+		t.Fatalf("fsck of a consistent store = %+v, %v", report, err)
+	}
+	blobs.Delete(hash)
+	lost, err := store.Fsck(FsckOptions{Quick: true})
+	if err != nil || len(lost.Issues) == 0 || lost.Issues[0].Kind != FsckMissingBlob {
+		t.Fatalf("fsck after losing the blob = %+v, %v", lost, err)
+	}
+	if _, _, err := store.GetObject("docs", "fox.txt", ""); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("reading a lost blob = %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Bulk operations that can run as background jobs
+const (
+	BulkOpDelete     = "delete"
+	BulkOpCopy       = "copy"
+	BulkOpRestore    = "restore"     // Restore items from the trash
+	BulkOpPurge      = "purge"       // Permanently delete items from the trash
+	BulkOpEmptyTrash = "empty_trash" // Purge the whole trash of a bucket
+)
+
+// BulkItem identifies one object version of a bulk trash operation
+type BulkItem struct {
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	VersionID string `json:"versionId"`
+}
+
+// DeleteObjects deletes keys from a bucket; keys ending in "/" delete everything below them.
+// It returns the number of deleted objects and a message for every failure.
+func (s *FileStorage) DeleteObjects(bucket string, keys []string, bypassGovernance bool) (int, []string) {
+	deletedCount := 0
+	var errs []string
+
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") {
+			objects, _, err := s.ListObjects(bucket, key, "", "")
+			if err == nil {
+				for _, obj := range objects {
+					if err := s.DeleteObject(bucket, obj.Key, "", bypassGovernance); err != nil {
+						errs = append(errs, fmt.Sprintf("Failed to delete %s: %v", obj.Key, err))
+					} else {
+						deletedCount++
+					}
+				}
+			}
+			s.DeleteObject(bucket, key, "", bypassGovernance)
+		} else {
+			if err := s.DeleteObject(bucket, key, "", bypassGovernance); err != nil {
+				errs = append(errs, fmt.Sprintf("Failed to delete %s: %v", key, err))
+			} else {
+				deletedCount++
+			}
+		}
+	}
+	return deletedCount, errs
+}
+
+// CopyObjects copies keys to another bucket under destPrefix; keys ending in "/" copy everything
+// below them, keeping the folder name. It returns the number of copies and a message for every failure.
+func (s *FileStorage) CopyObjects(srcBucket string, keys []string, destBucket, destPrefix string) (int, []string) {
+	copiedCount := 0
+	var errs []string
+
+	copyOne := func(srcKey, destKey string) {
+		reader, stat, err := s.GetObject(srcBucket, srcKey, "")
+		if err != nil {
+			return
+		}
+		_, err = s.PutObject(destBucket, destKey, reader, stat.EncryptionType)
+		reader.Close()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("Failed to copy %s: %v", srcKey, err))
+		} else {
+			copiedCount++
+		}
+	}
+
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") {
+			objects, _, err := s.ListObjects(srcBucket, key, "", "")
+			if err == nil {
+				for _, obj := range objects {
+					parentDir := filepath.Dir(strings.TrimSuffix(key, "/"))
+					var relKey string
+					if parentDir == "." || parentDir == "/" || parentDir == "" {
+						relKey = obj.Key
+					} else {
+						relKey, _ = filepath.Rel(parentDir, obj.Key)
+					}
+					copyOne(obj.Key, destPrefix+relKey)
+				}
+			}
+		} else {
+			destKey := destPrefix + filepath.Base(key)
+			if destPrefix == "" {
+				destKey = key
+			}
+			copyOne(key, destKey)
+		}
+	}
+	return copiedCount, errs
+}
+
+// BulkOperationJob implements jobs.Job for running an admin bulk request in the background
+type BulkOperationJob struct {
+	Storage           *FileStorage `json:"-"`
+	Operation         string       `json:"operation"`
+	Bucket            string       `json:"bucket,omitempty"`
+	Keys              []string     `json:"keys,omitempty"`
+	Items             []BulkItem   `json:"items,omitempty"`
+	DestinationBucket string       `json:"destinationBucket,omitempty"`
+	DestinationPrefix string       `json:"destinationPrefix,omitempty"`
+	BypassGovernance  bool         `json:"bypassGovernance,omitempty"`
+}
+
+func (j *BulkOperationJob) Type() string {
+	return JobTypeBulkOperation
+}
+
+func (j *BulkOperationJob) Name() string {
+	return "Bulk:" + j.Operation + ":" + j.Bucket
+}
+
+func (j *BulkOperationJob) Execute() error {
+	s := j.Storage
+	var errs []string
+	total := len(j.Keys) + len(j.Items)
+
+	switch j.Operation {
+	case BulkOpDelete:
+		_, errs = s.DeleteObjects(j.Bucket, j.Keys, j.BypassGovernance)
+	case BulkOpCopy:
+		_, errs = s.CopyObjects(j.Bucket, j.Keys, j.DestinationBucket, j.DestinationPrefix)
+	case BulkOpRestore:
+		for _, item := range j.Items {
+			if err := s.RestoreObject(item.Bucket, item.Key, item.VersionID); err != nil {
+				errs = append(errs, fmt.Sprintf("Failed to restore %s: %v", item.Key, err))
+			}
+		}
+	case BulkOpPurge:
+		for _, item := range j.Items {
+			if err := s.DeleteTrashObject(item.Bucket, item.Key, item.VersionID); err != nil {
+				errs = append(errs, fmt.Sprintf("Failed to delete %s: %v", item.Key, err))
+			}
+		}
+	case BulkOpEmptyTrash:
+		return s.EmptyTrash(j.Bucket)
+	default:
+		return fmt.Errorf("unknown bulk operation %q", j.Operation)
+	}
+
+	if len(errs) > 0 {
+		shown := errs
+		if len(shown) > 10 {
+			shown = shown[:10]
+		}
+		return fmt.Errorf("%d of %d items failed: %s", len(errs), total, strings.Join(shown, "; "))
+	}
+	return nil
+}
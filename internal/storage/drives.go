@@ -0,0 +1,870 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/health"
+	"github.com/GravSpace/GravSpace/internal/metrics"
+)
+
+// ErrHealRunning is returned when a drive heal is requested while one runs
+var ErrHealRunning = errors.New("a drive heal is already running")
+
+// driveMarkerFile identifies a data drive. A drive directory without it is new or replaced.
+const driveMarkerFile = ".gravspace-drive"
+
+// driveHealRetryDelay is how often a queued heal checks whether the running one finished
+const driveHealRetryDelay = 10 * time.Second
+
+// driveIDSettingPrefix keys the system setting holding the marker last seen on a drive path
+const driveIDSettingPrefix = "drive_id:"
+
+// Drive is a data directory CAS blobs are placed on, typically the mount of one disk
+type Drive struct {
+	Path       string     `json:"path"`
+	ID         string     `json:"id,omitempty"`
+	Online     bool       `json:"online"`
+	Error      string     `json:"error,omitempty"`
+	TotalBytes uint64     `json:"total_bytes"`
+	FreeBytes  uint64     `json:"free_bytes"`
+	CheckedAt  *time.Time `json:"checked_at,omitempty"`
+}
+
+// DriveSet places CAS blobs across the data root and any extra drives. Each blob is stored on
+// the online drives it scores highest on, by rendezvous hashing weighted by free space, with as
+// many copies on distinct drives as configured. Object files stay under the data root: they are
+// hard links of a blob copy on the same filesystem, or symbolic links of one on another drive.
+//
+// A monitor checks every drive on an interval. A drive that cannot be read is taken offline
+// and reads fall back to a surviving copy; a drive that comes back empty, i.e. a replacement,
+// gets a new marker and a heal job copies the blobs it lost from the other drives.
+type DriveSet struct {
+	store  *FileStorage
+	copies int
+	// allowUnmounted formats extra drives that are plain directories rather than mounted disks
+	allowUnmounted bool
+	heartbeat      *health.Heartbeat
+	interval       *intervalTicker
+	healing        atomic.Bool
+	// replaced lists the drives replaced while the server was down, healed once started
+	replaced []string
+
+	mu       sync.RWMutex
+	drives   []*Drive
+	lastHeal *HealReport
+
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewDriveSet creates the drive set of a store: its root followed by the extra drive paths, with
+// the given number of copies of each blob (one when zero), checked every interval (a minute
+// when zero). Extra drives are only formatted when they are mounted disks, unless
+// allowUnmounted is set. The drives are checked once before it returns.
+func NewDriveSet(store *FileStorage, extra []string, copies int, allowUnmounted bool, interval time.Duration) (*DriveSet, error) {
+	d := &DriveSet{
+		store:          store,
+		copies:         orDefault(copies, 1),
+		allowUnmounted: allowUnmounted,
+		interval:       newIntervalTicker(orDefault(interval, time.Minute)),
+		quit:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	seen := make(map[string]bool)
+	for _, path := range append([]string{store.Root}, extra...) {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if seen[abs] {
+			return nil, fmt.Errorf("data drive %s is listed twice", path)
+		}
+		seen[abs] = true
+		d.drives = append(d.drives, &Drive{Path: abs})
+	}
+	if d.copies > len(d.drives) {
+		return nil, fmt.Errorf("%d copies of each blob need as many data drives, only %d configured", d.copies, len(d.drives))
+	}
+	d.replaced = d.check()
+	return d, nil
+}
+
+// Start checks the drives on the interval until Stop is called
+func (d *DriveSet) Start() {
+	d.heartbeat = health.Worker("drives")
+	d.heartbeat.Beat()
+
+	if len(d.replaced) > 0 {
+		d.store.startHeal("drive replaced: " + strings.Join(d.replaced, ", "))
+	}
+	ticks := d.interval.start()
+	go func() {
+		defer close(d.done)
+		defer d.interval.stop()
+		heartbeat := time.NewTicker(health.HeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-d.quit:
+				return
+			case <-ticks:
+				start := time.Now()
+				if replaced := d.check(); len(replaced) > 0 {
+					d.store.startHeal("drive replaced: " + strings.Join(replaced, ", "))
+				}
+				metrics.RecordWorkerRun("drives", time.Since(start))
+			case <-heartbeat.C:
+			}
+			d.heartbeat.Beat()
+		}
+	}()
+	log.Printf("Drive monitor started (%d drives, %d copies, Interval: %v)", len(d.drives), d.copies, d.interval.get())
+}
+
+// SetInterval changes how often the drives are checked
+func (d *DriveSet) SetInterval(interval time.Duration) {
+	if d.interval.set(interval) {
+		log.Printf("Drive monitor interval set to %v", interval)
+	}
+}
+
+func (d *DriveSet) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.quit)
+		if d.heartbeat != nil {
+			<-d.done
+		}
+	})
+}
+
+// Healing reports whether a drive heal is running
+func (d *DriveSet) Healing() bool {
+	return d.healing.Load()
+}
+
+// Copies returns how many copies of each blob are kept
+func (d *DriveSet) Copies() int {
+	return d.copies
+}
+
+// Drives returns the state of every drive as of the last check
+func (d *DriveSet) Drives() []Drive {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	drives := make([]Drive, len(d.drives))
+	for i, drive := range d.drives {
+		drives[i] = *drive
+	}
+	return drives
+}
+
+// LastHeal returns the report of the running or the last heal since the server started
+func (d *DriveSet) LastHeal() *HealReport {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.lastHeal == nil {
+		return nil
+	}
+	report := *d.lastHeal
+	return &report
+}
+
+// Offline returns the paths of the drives that are offline
+func (d *DriveSet) Offline() []string {
+	var offline []string
+	for _, drive := range d.Drives() {
+		if !drive.Online {
+			offline = append(offline, drive.Path)
+		}
+	}
+	return offline
+}
+
+// Check checks every drive now. It returns the drives that were replaced since the last check.
+func (d *DriveSet) Check() []string {
+	return d.check()
+}
+
+// check reads the marker and free space of every drive, takes unreadable drives offline and
+// formats new ones. It returns the replaced drives, which need a heal.
+func (d *DriveSet) check() []string {
+	var replaced []string
+	for i, drive := range d.Drives() {
+		next := d.probe(drive, i == 0)
+		if next.Online && next.ID != drive.ID && d.knownAs(next.Path, next.ID) {
+			replaced = append(replaced, next.Path)
+		}
+
+		switch {
+		case drive.CheckedAt != nil && drive.Online && !next.Online:
+			log.Printf("Data drive %s went offline: %s", next.Path, next.Error)
+			d.alert("Drive Offline", fmt.Sprintf("Data drive %s is offline (%s). Reads fall back to copies on other drives; blobs stored only there are unavailable until it is back.", next.Path, next.Error))
+		case drive.CheckedAt == nil && !next.Online:
+			log.Printf("Data drive %s is offline: %s", next.Path, next.Error)
+		case drive.CheckedAt != nil && !drive.Online && next.Online:
+			log.Printf("Data drive %s is back online", next.Path)
+		}
+
+		online := 0.0
+		if next.Online {
+			online = 1
+			metrics.DriveFreeBytes.WithLabelValues(next.Path).Set(float64(next.FreeBytes))
+		}
+		metrics.DriveOnline.WithLabelValues(next.Path).Set(online)
+
+		d.mu.Lock()
+		*d.drives[i] = next
+		d.mu.Unlock()
+	}
+	for _, path := range replaced {
+		log.Printf("Data drive %s was replaced; healing the blobs it held", path)
+		d.alert("Drive Replaced", fmt.Sprintf("Data drive %s holds a new disk. A heal job restores the copies it held from the other drives.", path))
+	}
+	return replaced
+}
+
+// probe checks one drive. The root drive is created if it is missing; other drives must be
+// mounted, so a missing directory means the disk is gone, and one without a marker is only
+// formatted when it is a mount point or directly inside one. Otherwise the empty mount point
+// of a disk that failed to mount would be formatted and filled on the system disk.
+func (d *DriveSet) probe(drive Drive, root bool) Drive {
+	now := time.Now().UTC()
+	next := Drive{Path: drive.Path, ID: drive.ID, CheckedAt: &now}
+	if root {
+		os.MkdirAll(drive.Path, 0755)
+	}
+	info, err := os.Stat(drive.Path)
+	if err == nil && !info.IsDir() {
+		err = fmt.Errorf("%s is not a directory", drive.Path)
+	}
+	if err != nil {
+		next.Error = err.Error()
+		return next
+	}
+
+	marker := filepath.Join(drive.Path, driveMarkerFile)
+	data, err := os.ReadFile(marker)
+	switch {
+	case os.IsNotExist(err):
+		if !root && !d.allowUnmounted {
+			if ok, err := mounted(drive.Path); err != nil || !ok {
+				if err == nil {
+					err = errors.New("not a mounted disk: neither it nor its parent directory is a mount point")
+				}
+				next.Error = fmt.Sprintf("refusing to format the drive: %v", err)
+				return next
+			}
+		}
+		id := make([]byte, 16)
+		rand.Read(id)
+		next.ID = hex.EncodeToString(id)
+		if err := os.WriteFile(marker, []byte(next.ID+"\n"), 0644); err != nil {
+			next.Error = fmt.Sprintf("writing the drive marker: %v", err)
+			return next
+		}
+		log.Printf("Data drive %s formatted with ID %s", drive.Path, next.ID)
+	case err != nil:
+		next.Error = fmt.Sprintf("reading the drive marker: %v", err)
+		return next
+	default:
+		next.ID = strings.TrimSpace(string(data))
+	}
+
+	if usage, err := health.Usage(drive.Path); err == nil {
+		next.TotalBytes, next.FreeBytes = usage.TotalBytes, usage.FreeBytes
+	}
+	next.Online = true
+	return next
+}
+
+// knownAs reports whether a drive path held a different drive before, i.e. the disk was
+// replaced. The marker of a new path is recorded right away; that of a replacement once a heal
+// completes, so a heal interrupted by a restart is started again.
+func (d *DriveSet) knownAs(path, id string) bool {
+	db := d.store.DB
+	if db == nil {
+		return false
+	}
+	previous, err := db.GetSystemSetting(driveIDSettingPrefix + path)
+	if err != nil || previous == id {
+		return false
+	}
+	if previous == "" {
+		if err := db.SetSystemSetting(driveIDSettingPrefix+path, id); err != nil {
+			log.Printf("Drive monitor error recording drive %s: %v", path, err)
+		}
+		return false
+	}
+	return true
+}
+
+// healed records the markers of the online drives after a complete heal
+func (d *DriveSet) healed() {
+	for _, drive := range d.online() {
+		if err := d.store.DB.SetSystemSetting(driveIDSettingPrefix+drive.Path, drive.ID); err != nil {
+			log.Printf("Drive heal error recording drive %s: %v", drive.Path, err)
+		}
+	}
+}
+
+func (d *DriveSet) alert(event, details string) {
+	if d.store.Notifier != nil {
+		d.store.Notifier.SendAlert(event, details)
+	}
+}
+
+// online returns the online drives
+func (d *DriveSet) online() []*Drive {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var drives []*Drive
+	for _, drive := range d.drives {
+		if drive.Online {
+			snapshot := *drive
+			drives = append(drives, &snapshot)
+		}
+	}
+	return drives
+}
+
+// placement orders the online drives by how strongly a blob prefers them. Each drive scores
+// -weight/ln(u), with u uniform in (0, 1) derived from the hash and the drive path and the
+// weight its free space, so blobs spread in proportion to free space and adding or losing a
+// drive only moves the blobs that scored highest on it.
+func (d *DriveSet) placement(hash string) []string {
+	type scored struct {
+		path  string
+		score float64
+	}
+	var drives []scored
+	for _, drive := range d.online() {
+		sum := sha256.Sum256([]byte(hash + "\x00" + drive.Path))
+		u := (float64(binary.BigEndian.Uint64(sum[:8])>>11) + 0.5) / (1 << 53)
+		weight := math.Max(float64(drive.FreeBytes), 1)
+		drives = append(drives, scored{drive.Path, -weight / math.Log(u)})
+	}
+	sort.SliceStable(drives, func(i, j int) bool { return drives[i].score > drives[j].score })
+	paths := make([]string, len(drives))
+	for i, drive := range drives {
+		paths[i] = drive.path
+	}
+	return paths
+}
+
+// blobPlacement returns the drives a blob prefers, best first. Without a drive set every blob
+// lives on the root.
+func (s *FileStorage) blobPlacement(hash string) []string {
+	if s.Drives == nil {
+		return []string{s.Root}
+	}
+	return s.Drives.placement(hash)
+}
+
+// copyFactor returns how many copies of each blob are kept
+func (s *FileStorage) copyFactor() int {
+	if s.Drives == nil {
+		return 1
+	}
+	return s.Drives.copies
+}
+
+// casRoots returns the .cas directory of every online drive
+func (s *FileStorage) casRoots() []string {
+	if s.Drives == nil {
+		return []string{filepath.Join(s.Root, ".cas")}
+	}
+	var roots []string
+	for _, drive := range s.Drives.online() {
+		roots = append(roots, filepath.Join(drive.Path, ".cas"))
+	}
+	return roots
+}
+
+func blobPathOn(drive, hash string) string {
+	return filepath.Join(drive, ".cas", hash[:2], hash)
+}
+
+// blobCopies returns the paths of the copies of a blob on the online drives, preferred first
+func (s *FileStorage) blobCopies(hash string) []string {
+	var copies []string
+	for _, drive := range s.blobPlacement(hash) {
+		path := blobPathOn(drive, hash)
+		if _, err := os.Stat(path); err == nil {
+			copies = append(copies, path)
+		}
+	}
+	return copies
+}
+
+// casPath returns the first copy of a blob, or where it would be stored when it has none
+func (s *FileStorage) casPath(hash string) string {
+	placement := s.blobPlacement(hash)
+	for _, drive := range placement {
+		path := blobPathOn(drive, hash)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	if len(placement) == 0 {
+		return blobPathOn(s.Root, hash)
+	}
+	return blobPathOn(placement[0], hash)
+}
+
+// casDirs returns the names of the prefix directories of .cas on every online drive, sorted
+func (s *FileStorage) casDirs() []string {
+	return s.casListing("")
+}
+
+// casDirBlobs returns the names of the blobs in a prefix directory on every online drive, sorted
+func (s *FileStorage) casDirBlobs(dir string) []string {
+	return s.casListing(dir)
+}
+
+func (s *FileStorage) casListing(dir string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, root := range s.casRoots() {
+		entries, err := os.ReadDir(filepath.Join(root, dir))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Error listing %s: %v", filepath.Join(root, dir), err)
+			}
+			continue
+		}
+		for _, e := range entries {
+			name := e.Name()
+			valid := e.IsDir() && len(name) == 2
+			if dir != "" {
+				valid = !e.IsDir() && isCASBlobName(name)
+			}
+			if valid && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// openBlob opens the first readable copy of a blob
+func (s *FileStorage) openBlob(hash string) (*os.File, error) {
+	err := error(&os.PathError{Op: "open", Path: blobPathOn(s.Root, hash), Err: os.ErrNotExist})
+	for _, path := range s.blobCopies(hash) {
+		file, openErr := os.Open(path)
+		if openErr == nil {
+			return file, nil
+		}
+		err = openErr
+	}
+	return nil, err
+}
+
+// storeBlob moves the file at tmpPath into the store as the blob of hash, on as many drives as
+// copies are kept. It reports whether the blob was already stored; its missing copies are then
+// added from tmpPath. tmpPath is removed in every case.
+func (s *FileStorage) storeBlob(tmpPath, hash string) (existed bool, err error) {
+	defer os.Remove(tmpPath)
+	copies := s.blobCopies(hash)
+	existed = len(copies) > 0
+	if len(copies) >= s.copyFactor() {
+		return existed, nil
+	}
+	if _, err := s.replicateBlob(hash, tmpPath); err != nil && len(s.blobCopies(hash)) == 0 {
+		return existed, err
+	}
+	return existed, nil
+}
+
+// replicateBlob writes copies of src to the preferred drives that lack one until the blob has
+// as many copies as configured. The copy on the drive of src is a hard link; the others are
+// copied even when drives share a filesystem, so that they do not share the damage of one
+// inode. It returns how many copies it added; failing drives are skipped so that a copy lands
+// elsewhere.
+func (s *FileStorage) replicateBlob(hash, src string) (int, error) {
+	if real, err := filepath.EvalSymlinks(src); err == nil {
+		src = real
+	}
+	have := len(s.blobCopies(hash))
+	added := 0
+	var lastErr error
+	for _, drive := range s.blobPlacement(hash) {
+		if have >= s.copyFactor() {
+			break
+		}
+		dst := blobPathOn(drive, hash)
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			lastErr = err
+			continue
+		}
+		var err error
+		if onDrive(src, drive) {
+			if err = os.Link(src, dst); errors.Is(err, syscall.EXDEV) {
+				err = copyBlob(src, dst)
+			}
+		} else {
+			err = copyBlob(src, dst)
+		}
+		if err != nil && !os.IsExist(err) {
+			lastErr = err
+			log.Printf("Error storing a copy of blob %s on %s: %v", hash, drive, err)
+			continue
+		}
+		have++
+		added++
+	}
+	return added, lastErr
+}
+
+// copyBlob copies a blob to another drive, atomically
+func copyBlob(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp-copy"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	buf := bufferPool.Get().([]byte)
+	_, err = io.CopyBuffer(out, in, buf)
+	bufferPool.Put(buf)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// linkBlob creates the file of an object version at path as a link of a copy of the blob,
+// preferring the copy on the drive of path
+func (s *FileStorage) linkBlob(hash, path string) error {
+	copies := s.blobCopies(hash)
+	if len(copies) == 0 {
+		return fmt.Errorf("blob %s has no copy on an online drive", hash)
+	}
+	for _, blob := range copies {
+		if onDrive(path, driveOf(blob)) {
+			return linkFile(blob, path)
+		}
+	}
+	return linkFile(copies[0], path)
+}
+
+// linkFile makes path a link of the blob copy target: a hard link when it is on the drive of
+// target, which keeps the data if the copy is removed, a symbolic link otherwise, so that the
+// file follows a copy restored by a heal
+func linkFile(target, path string) error {
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	if onDrive(path, driveOf(abs)) {
+		if err := os.Link(abs, path); !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+	return os.Symlink(abs, path)
+}
+
+// openObjectFile opens the file of an object version, or another copy of its blob when the
+// file links a copy on a drive that failed. The file of an erasure-coded blob or of a blob in
+// the blob store is a stub; its content is read from the shards or the store.
+func (s *FileStorage) openObjectFile(path, hash string) (io.ReadCloser, error) {
+	return s.openObjectRange(path, hash, 0, -1)
+}
+
+// openObjectRange is openObjectFile for length bytes from offset; a negative length reads to
+// the end
+func (s *FileStorage) openObjectRange(path, hash string, offset, length int64) (io.ReadCloser, error) {
+	if e := s.erasureBlob(hash); e != nil {
+		reader, err := s.openErasure(e)
+		if err != nil {
+			return nil, err
+		}
+		return readSection(reader, offset, length)
+	}
+	var storeErr error
+	if s.Blobs != nil && isCASBlobName(hash) {
+		reader, err := s.Blobs.Get(hash, offset, length)
+		if !errors.Is(err, ErrBlobNotFound) {
+			return reader, err
+		}
+		// Versions stored before the blob store was configured link a blob in .cas; a stub
+		// has nothing to serve
+		storeErr = fmt.Errorf("blob %s: %w", hash, err)
+	}
+	file, err := os.Open(path)
+	if err == nil && storeErr != nil {
+		if info, statErr := file.Stat(); statErr == nil && info.Size() == 0 {
+			file.Close()
+			return nil, storeErr
+		}
+	}
+	if err == nil {
+		return readSection(file, offset, length)
+	}
+	if isCASBlobName(hash) {
+		if blob, blobErr := s.openBlob(hash); blobErr == nil {
+			return readSection(blob, offset, length)
+		}
+	}
+	if storeErr != nil {
+		return nil, storeErr
+	}
+	return nil, err
+}
+
+// statObjectFile stats the file of an object version. A symbolic link to a blob copy on a
+// failed drive still counts as the file; its content is read from another copy.
+func statObjectFile(path string) (os.FileInfo, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if link, lerr := os.Lstat(path); lerr == nil && link.Mode()&os.ModeSymlink != 0 {
+			return link, nil
+		}
+	}
+	return info, err
+}
+
+// onDrive reports whether path is inside the drive directory
+func onDrive(path, drive string) bool {
+	abs, err := filepath.Abs(path)
+	return err == nil && strings.HasPrefix(abs, drive+string(filepath.Separator))
+}
+
+// removeBlob deletes every copy of a blob
+func (s *FileStorage) removeBlob(hash string) {
+	for _, root := range s.casRoots() {
+		path := filepath.Join(root, hash[:2], hash)
+		os.Remove(path)
+		os.Remove(filepath.Dir(path)) // Clean parent folder if empty
+	}
+}
+
+// HealReport describes a drive heal
+type HealReport struct {
+	Reason     string     `json:"reason,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Blobs      int        `json:"blobs"`
+	// Copied counts the copies written to restore the copy factor
+	Copied int `json:"copied"`
+	// Relinked counts the object files pointed at a surviving copy again
+	Relinked int `json:"relinked"`
+	// Rebuilt counts the erasure shards recomputed from the other shards of their blob
+	Rebuilt int `json:"rebuilt_shards"`
+	// UnderReplicated counts the blobs still short of copies, e.g. while a drive is offline
+	UnderReplicated int      `json:"under_replicated"`
+	Lost            []string `json:"lost"`
+	Errors          []string `json:"errors,omitempty"`
+}
+
+// HealDrives restores the copy factor of every blob referenced by a version from the copies on
+// the online drives, and relinks the object files whose blob copy is gone. Blobs with no copy
+// left are recovered from a hard-linked object file when one survives, and reported lost
+// otherwise. Missing shards of erasure-coded blobs are rebuilt from the others; blobs in the
+// blob store are only reported lost when the store does not hold them.
+func (s *FileStorage) HealDrives(reason string) (*HealReport, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	if s.Drives != nil {
+		if !s.Drives.healing.CompareAndSwap(false, true) {
+			return nil, ErrHealRunning
+		}
+		defer s.Drives.healing.Store(false)
+	}
+	report := &HealReport{Reason: reason, StartedAt: time.Now().UTC(), Lost: []string{}}
+	s.setHealReport(report)
+	start := time.Now()
+
+	rows, err := s.DB.ListAllObjects()
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string][]*database.ObjectRow)
+	var hashes []string
+	for _, row := range rows {
+		// Quarantined content waits for the scrubber; archived versions live in the cold tier
+		if row.ContentHash == nil || !isCASBlobName(*row.ContentHash) || isArchivedRow(row) || row.CorruptedAt != nil {
+			continue
+		}
+		hash := *row.ContentHash
+		if versions[hash] == nil {
+			hashes = append(hashes, hash)
+		}
+		versions[hash] = append(versions[hash], row)
+	}
+	sort.Strings(hashes)
+	erasureBlobs, err := s.DB.ListErasureBlobs()
+	if err != nil {
+		return nil, err
+	}
+	sharded := make(map[string]*database.ErasureBlobRow)
+	for _, e := range erasureBlobs {
+		sharded[e.Hash] = e
+	}
+
+	fail := func(format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		log.Printf("Drive heal: %s", msg)
+		report.Errors = append(report.Errors, msg)
+	}
+	for _, hash := range hashes {
+		report.Blobs++
+		if e := sharded[hash]; e != nil {
+			s.healErasure(report, e, versions[hash], fail)
+			continue
+		}
+		copies := s.blobCopies(hash)
+		if len(copies) == 0 && s.Blobs != nil {
+			// The object files are stubs; the blob store keeps the content
+			if _, err := s.Blobs.Stat(hash); errors.Is(err, ErrBlobNotFound) {
+				report.Lost = append(report.Lost, hash)
+				metrics.DriveHealBlobsTotal.WithLabelValues("lost").Inc()
+			} else if err != nil {
+				fail("looking up blob %s in the blob store: %v", hash, err)
+			}
+			continue
+		}
+		source := ""
+		if len(copies) > 0 {
+			source = copies[0]
+		} else {
+			for _, row := range versions[hash] {
+				if info, err := os.Lstat(s.rowPath(row)); err == nil && info.Mode().IsRegular() {
+					source = s.rowPath(row)
+					break
+				}
+			}
+		}
+		if source == "" {
+			report.Lost = append(report.Lost, hash)
+			metrics.DriveHealBlobsTotal.WithLabelValues("lost").Inc()
+			continue
+		}
+		if len(copies) < s.copyFactor() {
+			added, err := s.replicateBlob(hash, source)
+			report.Copied += added
+			metrics.DriveHealBlobsTotal.WithLabelValues("copied").Add(float64(added))
+			if err != nil {
+				fail("copying blob %s: %v", hash, err)
+			}
+			if len(copies)+added < s.copyFactor() {
+				report.UnderReplicated++
+			}
+		}
+
+		for _, row := range versions[hash] {
+			path := s.rowPath(row)
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				continue
+			}
+			if err := replaceWithLink(s.casPath(hash), path); err != nil {
+				fail("relinking %s/%s (version %s): %v", row.Bucket, row.Key, row.VersionID, err)
+				continue
+			}
+			report.Relinked++
+		}
+	}
+
+	if s.Drives != nil && len(report.Errors) == 0 {
+		s.Drives.healed()
+	}
+	finished := time.Now().UTC()
+	report.FinishedAt = &finished
+	s.setHealReport(report)
+	metrics.UnderReplicatedBlobs.Set(float64(report.UnderReplicated))
+	metrics.RecordWorkerRun("drive_heal", time.Since(start))
+	log.Printf("Drive heal checked %d blobs in %v: %d copies written, %d shards rebuilt, %d files relinked, %d under-replicated, %d lost",
+		report.Blobs, time.Since(start).Round(time.Millisecond), report.Copied, report.Rebuilt, report.Relinked, report.UnderReplicated, len(report.Lost))
+	if len(report.Lost) > 0 && s.Notifier != nil {
+		s.Notifier.SendAlert("Blobs Lost", fmt.Sprintf("The drive heal found %d CAS blobs with no copy, or too few erasure shards, left on the drives. Run fsck to list the affected versions.", len(report.Lost)))
+	}
+	return report, nil
+}
+
+func (s *FileStorage) setHealReport(report *HealReport) {
+	if s.Drives == nil {
+		return
+	}
+	snapshot := *report
+	s.Drives.mu.Lock()
+	s.Drives.lastHeal = &snapshot
+	s.Drives.mu.Unlock()
+}
+
+// StartDriveHeal queues a drive heal job and returns its ID
+func (s *FileStorage) StartDriveHeal(reason string) (int64, error) {
+	job := &DriveHealJob{Storage: s, Reason: reason}
+	if s.Jobs == nil {
+		go job.Execute()
+		return 0, nil
+	}
+	return s.Jobs.Enqueue(job)
+}
+
+func (s *FileStorage) startHeal(reason string) {
+	if _, err := s.StartDriveHeal(reason); err != nil {
+		log.Printf("Error queueing a drive heal: %v", err)
+	}
+}
+
+// DriveHealJob implements jobs.Job for restoring the copies of the blobs after a drive change
+type DriveHealJob struct {
+	Storage *FileStorage `json:"-"`
+	Reason  string       `json:"reason"`
+}
+
+func (j *DriveHealJob) Type() string {
+	return JobTypeDriveHeal
+}
+
+func (j *DriveHealJob) Name() string {
+	return "DriveHeal"
+}
+
+// Execute waits for a running heal to finish first: a drive replaced during a heal needs a
+// pass of its own
+func (j *DriveHealJob) Execute() error {
+	for {
+		_, err := j.Storage.HealDrives(j.Reason)
+		if !errors.Is(err, ErrHealRunning) {
+			return err
+		}
+		time.Sleep(driveHealRetryDelay)
+	}
+}
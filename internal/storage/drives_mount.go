@@ -0,0 +1,29 @@
+//go:build linux || darwin || freebsd
+
+package storage
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// mounted reports whether a directory is a mount point or sits at the top of one, i.e. it or
+// its parent is on another device than the directory above it. A disk that failed to mount
+// leaves its empty mount point on the filesystem underneath, which is neither.
+func mounted(path string) (bool, error) {
+	var devices [3]uint64
+	for i := range devices {
+		var st syscall.Stat_t
+		if err := syscall.Stat(path, &st); err != nil {
+			return false, err
+		}
+		devices[i] = uint64(st.Dev)
+		if parent := filepath.Dir(path); parent != path {
+			path = parent
+		} else if i < 2 {
+			// The filesystem root is a mount point of its own
+			return true, nil
+		}
+	}
+	return devices[0] != devices[1] || devices[1] != devices[2], nil
+}
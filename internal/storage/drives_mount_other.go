@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package storage
+
+// mounted treats every directory as mounted on platforms without device numbers in stat
+func mounted(path string) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GravSpace/GravSpace/internal/cache"
+)
+
+func TestDrivesFailureAndHeal(t *testing.T) {
+	disks := []string{t.TempDir(), t.TempDir()}
+	store := newTestStorage(t, Options{Drives: disks, Copies: 2})
+	db := store.DB
+	store.CreateBucket("photos")
+
+	read := func(key string) (string, error) {
+		t.Helper()
+		reader, _, err := store.GetObject("photos", key, "")
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		return string(data), err
+	}
+	hashes := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("img-%02d.jpg", i)
+		if _, err := store.PutObject("photos", key, strings.NewReader("picture "+key), ""); err != nil {
+			t.Fatal(err)
+		}
+		row, _ := db.GetObject("photos", key, "simple")
+		hashes[key] = *row.ContentHash
+	}
+
+	// Two copies of every blob, on distinct drives, spread over all three
+	perDrive := make(map[string]int)
+	for key, hash := range hashes {
+		copies := store.blobCopies(hash)
+		if len(copies) != 2 || driveOf(copies[0]) == driveOf(copies[1]) {
+			t.Fatalf("copies of %s = %v", key, copies)
+		}
+		for _, blob := range copies {
+			perDrive[driveOf(blob)]++
+		}
+	}
+	if len(perDrive) != 3 {
+		t.Fatalf("blobs per drive = %v", perDrive)
+	}
+
+	// A failed drive goes offline and reads fall back to the surviving copies
+	os.Rename(disks[0], disks[0]+".failed")
+	store.Drives.Check()
+	if drives := store.Drives.Drives(); drives[1].Online || !drives[0].Online || !drives[2].Online {
+		t.Fatalf("drives after the failure = %+v", drives)
+	}
+	for key := range hashes {
+		if got, err := read(key); err != nil || got != "picture "+key {
+			t.Fatalf("%s with a failed drive = %q, %v", key, got, err)
+		}
+	}
+	// Links into the failed drive keep their rows
+	if pruned := (&SyncWorker{storage: store}).pruneOrphanedRecords(); pruned != 0 {
+		t.Fatalf("sync pruned %d objects on a failed drive", pruned)
+	}
+
+	// The replacement disk is formatted and healed back to two copies of every blob
+	os.Mkdir(disks[0], 0755)
+	if replaced := store.Drives.Check(); len(replaced) != 1 || replaced[0] != disks[0] {
+		t.Fatalf("replaced = %v", replaced)
+	}
+	report, err := store.HealDrives("test")
+	if err != nil || report.Blobs != 30 || report.Copied != perDrive[disks[0]] || report.UnderReplicated != 0 || len(report.Lost) != 0 {
+		t.Fatalf("heal = %+v, %v", report, err)
+	}
+	for key, hash := range hashes {
+		if copies := store.blobCopies(hash); len(copies) != 2 {
+			t.Errorf("copies of %s after the heal = %v", key, copies)
+		}
+	}
+	if replaced := store.Drives.Check(); len(replaced) != 0 {
+		t.Errorf("drive still replaced after the heal: %v", replaced)
+	}
+	if report, err := store.Fsck(FsckOptions{}); err != nil || len(report.Issues) != 0 {
+		t.Fatalf("fsck after the heal = %+v, %v", report, err)
+	}
+
+	// The scrubber replaces a rotten copy from its mirror
+	hash := hashes["img-07.jpg"]
+	copies := store.blobCopies(hash)
+	os.WriteFile(copies[0], []byte("rotten bytes"), 0644)
+	scrubber := NewScrubber(store, 0, 0)
+	scrubber.Scrub()
+	if status := scrubber.Status(); status.Blobs != 30 || status.Corrupted != 1 || status.Restored != 1 {
+		t.Fatalf("scrub status = %+v", status)
+	}
+	if got, err := read("img-07.jpg"); err != nil || got != "picture img-07.jpg" {
+		t.Errorf("img-07.jpg after the repair = %q, %v", got, err)
+	}
+	if copies := store.blobCopies(hash); len(copies) != 2 {
+		t.Errorf("copies after the repair = %v", copies)
+	}
+	if quarantined, _ := db.ListQuarantinedBlobs(); len(quarantined) != 1 || quarantined[0].RestoredFrom == nil {
+		t.Errorf("quarantined = %+v", quarantined)
+	}
+}
+
+func TestUnmountedDriveIsNotFormatted(t *testing.T) {
+	root := t.TempDir()
+	// A directory two levels below the temporary directory is on the same disk as the ones above it
+	disk := filepath.Join(root, "mnt", "disk1")
+	if err := os.MkdirAll(disk, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := mounted(disk); err != nil || ok {
+		t.Skipf("%s looks mounted (%v, %v)", disk, ok, err)
+	}
+
+	store := &FileStorage{Root: filepath.Join(root, "data"), Cache: cache.NewInMemoryCache()}
+	drives, err := NewDriveSet(store, []string{disk}, 1, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := drives.Drives()[1]; got.Online || !strings.Contains(got.Error, "refusing to format") {
+		t.Errorf("unmounted drive = %+v", got)
+	}
+	if _, err := os.Stat(filepath.Join(disk, driveMarkerFile)); !os.IsNotExist(err) {
+		t.Errorf("unmounted drive was formatted: %v", err)
+	}
+
+	if drives, err = NewDriveSet(store, []string{disk}, 1, true, 0); err != nil {
+		t.Fatal(err)
+	}
+	if offline := drives.Offline(); len(offline) != 0 {
+		t.Errorf("offline drives with unmounted drives allowed = %v", offline)
+	}
+}
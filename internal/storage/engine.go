@@ -2,6 +2,7 @@ package storage
 
 import (
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -20,10 +21,14 @@ import (
 	"github.com/GravSpace/GravSpace/internal/cache"
 	"github.com/GravSpace/GravSpace/internal/crypto"
 	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/health"
 	"github.com/GravSpace/GravSpace/internal/jobs"
 	"github.com/GravSpace/GravSpace/internal/metrics"
 	"github.com/GravSpace/GravSpace/internal/notification"
 	"github.com/GravSpace/GravSpace/internal/notifications"
+	"github.com/GravSpace/GravSpace/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Object represents a stored object version
@@ -41,6 +46,14 @@ type Object struct {
 	CompressionType string
 	ContentHash     string
 	OriginalSize    int64
+
+	StorageClass     string     // Empty means STANDARD
+	RestoreStatus    string     // "ongoing" or "restored" for archived objects
+	RestoreExpiresAt *time.Time // Expiry of the temporary restored copy
+
+	WebsiteRedirectLocation string // x-amz-website-redirect-location
+	ReplicationStatus       string // x-amz-replication-status
+	Corrupted               bool   // The scrubber found the content damaged
 }
 
 var bufferPool = sync.Pool{
@@ -70,10 +83,11 @@ type LifecycleConfiguration struct {
 
 // LifecycleRule represents a single lifecycle rule
 type LifecycleRule struct {
-	ID         string            `json:"id"`
-	Status     string            `json:"status"` // "Enabled" or "Disabled"
-	Filter     LifecycleFilter   `json:"filter"`
-	Expiration ElementExpiration `json:"expiration"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"` // "Enabled" or "Disabled"
+	Filter      LifecycleFilter   `json:"filter"`
+	Expiration  ElementExpiration `json:"expiration"`
+	Transitions []Transition      `json:"transitions,omitempty"`
 }
 
 type LifecycleFilter struct {
@@ -84,10 +98,31 @@ type ElementExpiration struct {
 	Days int `json:"days"`
 }
 
+// Transition moves current versions to a colder storage class after a number of days
+type Transition struct {
+	Days         int    `json:"days"`
+	StorageClass string `json:"storage_class"` // GLACIER or DEEP_ARCHIVE
+}
+
+// BucketLoggingConfiguration is where the server access logs of a bucket are delivered
+type BucketLoggingConfiguration struct {
+	TargetBucket string `json:"target_bucket"`
+	TargetPrefix string `json:"target_prefix"`
+}
+
 // WebsiteConfiguration represents S3 Website configuration
 type WebsiteConfiguration struct {
-	IndexDocument *IndexDocument `json:"index_document,omitempty"`
-	ErrorDocument *ErrorDocument `json:"error_document,omitempty"`
+	IndexDocument         *IndexDocument         `json:"index_document,omitempty"`
+	ErrorDocument         *ErrorDocument         `json:"error_document,omitempty"`
+	RedirectAllRequestsTo *RedirectAllRequestsTo `json:"redirect_all_requests_to,omitempty"`
+	RoutingRules          []RoutingRule          `json:"routing_rules,omitempty"`
+
+	// SPAFallback serves the root index document instead of a 404 so that
+	// client-side routers can handle unknown paths
+	SPAFallback bool `json:"spa_fallback,omitempty"`
+	// CacheControl is sent with every document; IndexCacheControl overrides it for index documents
+	CacheControl      string `json:"cache_control,omitempty"`
+	IndexCacheControl string `json:"index_cache_control,omitempty"`
 }
 
 type IndexDocument struct {
@@ -98,6 +133,31 @@ type ErrorDocument struct {
 	Key string `json:"key"`
 }
 
+// RedirectAllRequestsTo redirects every website request to another host
+type RedirectAllRequestsTo struct {
+	HostName string `json:"host_name"`
+	Protocol string `json:"protocol,omitempty"` // http or https, defaults to the request protocol
+}
+
+// RoutingRule redirects requests matching its condition
+type RoutingRule struct {
+	Condition *RoutingRuleCondition `json:"condition,omitempty"`
+	Redirect  RoutingRuleRedirect   `json:"redirect"`
+}
+
+type RoutingRuleCondition struct {
+	KeyPrefixEquals             string `json:"key_prefix_equals,omitempty"`
+	HttpErrorCodeReturnedEquals string `json:"http_error_code_returned_equals,omitempty"`
+}
+
+type RoutingRuleRedirect struct {
+	HostName             string `json:"host_name,omitempty"`
+	Protocol             string `json:"protocol,omitempty"`
+	ReplaceKeyPrefixWith string `json:"replace_key_prefix_with,omitempty"`
+	ReplaceKeyWith       string `json:"replace_key_with,omitempty"`
+	HttpRedirectCode     string `json:"http_redirect_code,omitempty"` // defaults to 301
+}
+
 // Part represents a part of a multipart upload
 type Part struct {
 	PartNumber int
@@ -116,7 +176,9 @@ type Storage interface {
 	SetBucketObjectLock(name string, enabled bool) error
 	GetBucketObjectLock(name string) (enabled bool, mode string, days int, err error)
 	PutObject(bucket, key string, reader io.Reader, encryptionType string) (string, error)
+	PutObjectWithRedirect(bucket, key string, reader io.Reader, encryptionType, redirectLocation string) (string, error)
 	GetObject(bucket, key, versionID string) (io.ReadCloser, *Object, error)
+	GetObjectRange(bucket, key, versionID string, offset, length int64) (io.ReadCloser, *Object, error)
 	StatObject(bucket, key, versionID string) (*Object, error)
 	DeleteObject(bucket, key, versionID string, bypassGovernance bool) error
 	ListObjects(bucket, prefix, delimiter, search string) ([]Object, []string, error)
@@ -125,6 +187,21 @@ type Storage interface {
 	SetObjectLegalHold(bucket, key, versionID string, hold bool, reason string) error
 	SetBucketDefaultRetention(bucket, mode string, days int) error
 	SetBucketQuota(bucket string, quotaBytes int64) error
+	SetBucketErasure(bucket string, dataShards, parityShards int) error
+
+	// Bulk
+	DeleteObjects(bucket string, keys []string, bypassGovernance bool) (int, []string)
+	CopyObjects(srcBucket string, keys []string, destBucket, destPrefix string) (int, []string)
+
+	// Batch Operations
+	CreateBatchJob(req *BatchJobRequest) (int64, error)
+	GetBatchJob(id int64) (*BatchJob, error)
+	ListBatchJobs(status string, limit int) ([]*BatchJob, error)
+	ListBatchJobResults(id int64, status string, after int64, limit int) ([]*database.BatchJobResultRow, int64, error)
+	CancelBatchJob(id int64) (bool, error)
+
+	// Cold tier
+	RestoreArchivedObject(bucket, key, versionID string, days int) (bool, error)
 
 	// Multipart Upload
 	InitiateMultipartUpload(bucket, key string) (string, error)
@@ -151,6 +228,17 @@ type Storage interface {
 	GetBucketWebsite(bucket string) (*WebsiteConfiguration, error)
 	DeleteBucketWebsite(bucket string) error
 
+	// Server Access Logging
+	PutBucketLogging(bucket string, config *BucketLoggingConfiguration) error
+	GetBucketLogging(bucket string) (*BucketLoggingConfiguration, error)
+
+	// Inventory
+	PutBucketInventory(bucket string, config InventoryConfiguration) error
+	GetBucketInventory(bucket, id string) (*BucketInventory, error)
+	ListBucketInventories(bucket string) ([]*BucketInventory, error)
+	DeleteBucketInventory(bucket, id string) error
+	RunBucketInventory(bucket, id string) error
+
 	// Soft Delete & Recycle Bin
 	SetBucketSoftDelete(bucket string, enabled bool, retentionDays int) error
 	ListTrash(bucket, search string) ([]*database.ObjectRow, error)
@@ -165,9 +253,15 @@ type Storage interface {
 	RevokeSignature(signature string) error
 
 	// Bucket Replication
-	CreateReplicationRule(source, dest, prefix string) error
+	CreateReplicationRule(rule *database.ReplicationRow) error
 	GetReplicationRules(bucket string) ([]database.ReplicationRow, error)
 	DeleteReplicationRule(id int64) error
+	CreateReplicationTarget(target *database.ReplicationTargetRow) (int64, error)
+	ListReplicationTargets() ([]database.ReplicationTargetRow, error)
+	DeleteReplicationTarget(id int64) error
+	ListReplicationTasks(bucket, status string) ([]database.ReplicationTaskRow, error)
+	StartReplicationBackfill(bucket string, ruleID int64) (int64, error)
+	ListReplicationBackfills(bucket string) ([]database.ReplicationBackfillRow, error)
 
 	// Stats
 	StartLifecycleWorker()
@@ -183,19 +277,94 @@ type FileStorage struct {
 	Cache         cache.Cache
 	Notifier      *notification.NotificationService
 	Jobs          *jobs.Manager
-	mu            sync.Mutex // For synchronizing access if needed
 	SyncWorker    *SyncWorker
 	Notifications *notifications.Dispatcher
+
+	ReplicationWorker *ReplicationWorker
+	Metrics           *MetricsCollector
+	Scrubber          *Scrubber
+	Drives            *DriveSet
+	// Blobs, when set, stores new CAS blobs instead of .cas on the data drives
+	Blobs BlobStore
+
+	lifecycleHeartbeat *health.Heartbeat
+	lifecycleInterval  *intervalTicker
+	lifecycleQuit      chan struct{}
+	lifecycleDone      chan struct{}
+
+	// Cold tier for lifecycle transitions (empty disables transitions)
+	ColdRoot        string
+	ColdCompression bool
+
+	// ctx carries the trace of the request being served; see WithContext
+	ctx context.Context
+}
+
+// WithContext returns a shallow copy of the storage whose operations, database queries and
+// cache calls are recorded as spans of the trace in ctx. Background workers keep using the
+// original instance.
+func (s *FileStorage) WithContext(ctx context.Context) *FileStorage {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return s
+	}
+	traced := *s
+	traced.ctx = ctx
+	traced.DB = s.DB.WithContext(ctx)
+	traced.Cache = cache.Traced(s.Cache, ctx)
+	return &traced
+}
+
+// context returns the request context bound by WithContext
+func (s *FileStorage) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// startSpan starts a span for a storage operation in the bound request's trace and returns the
+// storage bound to the new span, so queries and cache calls nest under it
+func (s *FileStorage) startSpan(name string, attrs ...attribute.KeyValue) (*FileStorage, trace.Span) {
+	ctx, span := tracing.StartChild(s.context(), "storage."+name, attrs...)
+	return s.WithContext(ctx), span
 }
 
-func NewFileStorage(root string, db *database.Database) (*FileStorage, error) {
+// Options configures the cache, the cold tier, the worker intervals and the worker pools of a
+// FileStorage. Zero values select the defaults.
+type Options struct {
+	RedisURL string
+	// ColdRoot is the cold tier for lifecycle transitions; empty disables transitions
+	ColdRoot        string
+	ColdCompression bool
+
+	SyncInterval      time.Duration // 5 minutes
+	LifecycleInterval time.Duration // 1 hour
+	MetricsInterval   time.Duration // 5 minutes
+	ScrubInterval     time.Duration // 24 hours
+	ScrubRate         int64         // Bytes per second read by the scrubber; unlimited when zero
+	JobWorkers        int           // 4
+	WebhookWorkers    int           // 5
+
+	// Drives are data directories CAS blobs are placed on besides the root, each blob stored
+	// Copies times (once when zero) on distinct drives, which are checked every DriveCheckInterval.
+	// AllowUnmountedDrives formats drives that are not mounted disks.
+	Drives               []string
+	Copies               int
+	AllowUnmountedDrives bool
+	DriveCheckInterval   time.Duration // 1 minute
+
+	// Blobs stores the CAS blobs elsewhere, e.g. in an S3 bucket; nil keeps them on the drives
+	Blobs BlobStore
+}
+
+func NewFileStorage(root string, db *database.Database, opts Options) (*FileStorage, error) {
 	if err := os.MkdirAll(root, 0755); err != nil {
 		return nil, err
 	}
 
 	// Initialize cache
 	var c cache.Cache
-	redisURL := os.Getenv("REDIS_URL")
+	redisURL := opts.RedisURL
 	if redisURL != "" {
 		fmt.Printf("Using Redis cache at %s\n", redisURL)
 		var err error
@@ -210,14 +379,33 @@ func NewFileStorage(root string, db *database.Database) (*FileStorage, error) {
 
 	// Create storage instance
 	s := &FileStorage{
-		Root:          root,
-		DB:            db,
-		Cache:         c,
-		Notifier:      notification.NewNotificationService(db),
-		Jobs:          jobs.NewManager(4),                 // 4 background workers
-		Notifications: notifications.NewDispatcher(db, 5), // 5 workers
+		Root:            root,
+		DB:              db,
+		Cache:           c,
+		Notifier:        notification.NewNotificationService(db),
+		Jobs:            jobs.NewManager(db, orDefault(opts.JobWorkers, 4)),
+		Notifications:   notifications.NewDispatcher(db, orDefault(opts.WebhookWorkers, 5)),
+		ColdRoot:        opts.ColdRoot,
+		ColdCompression: opts.ColdCompression,
+		Blobs:           opts.Blobs,
+
+		lifecycleInterval: newIntervalTicker(orDefault(opts.LifecycleInterval, time.Hour)),
+	}
+
+	if s.ColdRoot != "" {
+		if err := os.MkdirAll(s.ColdRoot, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create cold storage directory: %w", err)
+		}
+	}
+
+	drives, err := NewDriveSet(s, opts.Drives, opts.Copies, opts.AllowUnmountedDrives, opts.DriveCheckInterval)
+	if err != nil {
+		return nil, err
 	}
+	s.Drives = drives
 
+	s.ReplicationWorker = NewReplicationWorker(s)
+	s.registerJobs()
 	s.Jobs.Start()
 
 	// Clean up any incorrectly scanned dot-prefixed buckets from the database
@@ -233,19 +421,27 @@ func NewFileStorage(root string, db *database.Database) (*FileStorage, error) {
 		}
 	}
 
-	// Initialize and start sync worker (every 5 minutes by default)
-	syncInterval := 5 * time.Minute
-	if intervalEnv := os.Getenv("SYNC_WORKER_INTERVAL"); intervalEnv != "" {
-		if minutes, err := strconv.Atoi(intervalEnv); err == nil {
-			syncInterval = time.Duration(minutes) * time.Minute
-		}
-	}
-	s.SyncWorker = NewSyncWorker(s, syncInterval)
+	// Periodic full sync in addition to the filesystem watcher
+	s.SyncWorker = NewSyncWorker(s, orDefault(opts.SyncInterval, 5*time.Minute))
+
+	// Gauges are recounted from the database on this interval
+	s.Metrics = NewMetricsCollector(s, orDefault(opts.MetricsInterval, 5*time.Minute))
+
+	// Every CAS blob is re-read and verified against its hash on this interval
+	s.Scrubber = NewScrubber(s, opts.ScrubInterval, opts.ScrubRate)
 
 	return s, nil
 }
 
+func orDefault[T int | time.Duration](v, def T) T {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
 func (s *FileStorage) invalidateObjectListCache(bucket, key string) {
+	s.Metrics.MarkDirty(bucket)
 	if s.Cache == nil {
 		return
 	}
@@ -259,6 +455,9 @@ func (s *FileStorage) invalidateObjectListCache(bucket, key string) {
 }
 
 func (s *FileStorage) CreateBucket(name string) error {
+	s, span := s.startSpan("CreateBucket", tracing.Bucket(name))
+	defer span.End()
+
 	if strings.HasPrefix(name, ".") || len(name) < 3 || len(name) > 63 {
 		return fmt.Errorf("invalid bucket name: must be 3-63 characters and cannot start with a dot")
 	}
@@ -270,7 +469,7 @@ func (s *FileStorage) CreateBucket(name string) error {
 	// Create in database
 	if s.DB != nil {
 		s.DB.CreateBucket(name, "admin")
-		metrics.BucketsTotal.Inc()
+		s.Metrics.MarkDirty(name)
 	}
 
 	// Invalidate bucket list cache
@@ -371,14 +570,22 @@ func (s *FileStorage) SetObjectRetention(bucket, key, versionID string, retainUn
 	if s.DB == nil {
 		return fmt.Errorf("database not available")
 	}
-	return s.DB.SetObjectRetention(bucket, key, versionID, retainUntil, mode)
+	if err := s.DB.SetObjectRetention(bucket, key, versionID, retainUntil, mode); err != nil {
+		return err
+	}
+	s.queueMetadataReplication(bucket, key, versionID)
+	return nil
 }
 
 func (s *FileStorage) SetObjectLegalHold(bucket, key, versionID string, hold bool, reason string) error {
 	if s.DB == nil {
 		return fmt.Errorf("database not available")
 	}
-	return s.DB.SetObjectLegalHold(bucket, key, versionID, hold, reason)
+	if err := s.DB.SetObjectLegalHold(bucket, key, versionID, hold, reason); err != nil {
+		return err
+	}
+	s.queueMetadataReplication(bucket, key, versionID)
+	return nil
 }
 
 func (s *FileStorage) IsSignatureUsed(signature string) (bool, error) {
@@ -419,6 +626,9 @@ func (s *FileStorage) SetBucketQuota(bucket string, quotaBytes int64) error {
 }
 
 func (s *FileStorage) ListBuckets() ([]string, error) {
+	s, span := s.startSpan("ListBuckets")
+	defer span.End()
+
 	// Try cache first
 	var buckets []string
 	if s.Cache != nil {
@@ -467,14 +677,21 @@ func (s *FileStorage) ListBuckets() ([]string, error) {
 }
 
 func (s *FileStorage) DeleteBucket(name string) error {
+	s, span := s.startSpan("DeleteBucket", tracing.Bucket(name))
+	defer span.End()
+
 	if err := os.RemoveAll(filepath.Join(s.Root, name)); err != nil {
 		return err
 	}
+	os.RemoveAll(filepath.Join(s.Root, ".restored", name))
+	if s.ColdRoot != "" {
+		os.RemoveAll(filepath.Join(s.ColdRoot, name))
+	}
 
 	// Delete from database
 	if s.DB != nil {
 		s.DB.DeleteBucket(name)
-		metrics.BucketsTotal.Dec()
+		s.Metrics.MarkDirty(name)
 	}
 
 	// Invalidate caches
@@ -485,15 +702,30 @@ func (s *FileStorage) DeleteBucket(name string) error {
 		s.Cache.Delete(cache.BucketCORSKey(name))
 		s.Cache.Delete(cache.BucketLifecycleKey(name))
 		s.Cache.Delete(cache.BucketWebsiteKey(name)) // Invalidate website cache
+		s.Cache.Delete(cache.BucketLoggingKey(name))
 	}
 
 	return nil
 }
 
 func (s *FileStorage) PutObject(bucket, key string, reader io.Reader, encryptionType string) (string, error) {
+	return s.putObject(bucket, key, reader, encryptionType, "", false)
+}
+
+// PutObjectWithRedirect stores an object with an x-amz-website-redirect-location. The redirect
+// is saved with the object's metadata, so notifications and replication see it.
+func (s *FileStorage) PutObjectWithRedirect(bucket, key string, reader io.Reader, encryptionType, redirectLocation string) (string, error) {
+	return s.putObject(bucket, key, reader, encryptionType, redirectLocation, false)
+}
+
+// putObject stores an object. Replicas written by the replication worker are marked
+// REPLICA and never queued for further replication, so bidirectional rules do not loop.
+func (s *FileStorage) putObject(bucket, key string, reader io.Reader, encryptionType, redirectLocation string, replica bool) (string, error) {
 	if reader == nil {
 		return "", fmt.Errorf("reader is nil")
 	}
+	s, span := s.startSpan("PutObject", tracing.Bucket(bucket), tracing.Key(key))
+	defer span.End()
 
 	// If key is a folder placeholder (ends in /), create directory and add to DB
 	if strings.HasSuffix(key, "/") {
@@ -519,75 +751,13 @@ func (s *FileStorage) PutObject(bucket, key string, reader io.Reader, encryption
 		return "", nil
 	}
 
-	// Check if versioning is enabled and get object lock defaults
-	versioningEnabled := false
-	var defaultRetentionMode string
-	var defaultRetentionDays int
-	if s.DB != nil {
-		bucketInfo, err := s.DB.GetBucket(bucket)
-		if err == nil && bucketInfo != nil {
-			versioningEnabled = bucketInfo.VersioningEnabled
-			if bucketInfo.ObjectLockEnabled {
-				defaultRetentionMode = bucketInfo.DefaultRetentionMode
-				defaultRetentionDays = bucketInfo.DefaultRetentionDays
-			}
-		}
-	}
-
-	// Check if the existing object has a lock (before overwriting)
-	// ONLY block if versioning is disabled. If versioning is enabled, a new version is created.
-	if !versioningEnabled && s.DB != nil {
-		existingObj, _ := s.DB.GetObject(bucket, key, "")
-		if existingObj != nil {
-			// Check for legal hold
-			if existingObj.LegalHold {
-				return "", fmt.Errorf("object is under legal hold and cannot be overwritten")
-			}
-
-			// Check for retention period
-			if existingObj.RetainUntilDate != nil && time.Now().Before(*existingObj.RetainUntilDate) {
-				lockMode := ""
-				if existingObj.LockMode != nil {
-					lockMode = *existingObj.LockMode
-				}
-				return "", fmt.Errorf("object is under %s retention until %s and cannot be overwritten",
-					lockMode, existingObj.RetainUntilDate.Format(time.RFC3339))
-			}
-		}
-	}
-
-	// Pre-check bucket quota
-	if s.DB != nil {
-		bucketInfo, err := s.DB.GetBucket(bucket)
-		if err == nil && bucketInfo != nil && bucketInfo.QuotaBytes > 0 {
-			_, currentSize, err := s.GetBucketStats(bucket)
-			if err == nil && currentSize >= bucketInfo.QuotaBytes {
-				return "", fmt.Errorf("Bucket quota exceeded (%s limit reached)", formatBytes(bucketInfo.QuotaBytes))
-			}
-		}
+	w, err := s.prepareObjectWrite(bucket, key, 0)
+	if err != nil {
+		return "", err
 	}
-
-	versionID := fmt.Sprintf("%d", time.Now().UnixNano())
-	var path string
+	path, versionID := w.path, w.versionID
 	var size int64
 
-	if versioningEnabled {
-		// Versioned storage: create directory structure
-		objectDir := filepath.Join(s.Root, bucket, key)
-		if err := os.MkdirAll(objectDir, 0755); err != nil {
-			return "", err
-		}
-		path = filepath.Join(objectDir, versionID)
-	} else {
-		// Non-versioned storage: simple file
-		objectDir := filepath.Join(s.Root, bucket, filepath.Dir(key))
-		if err := os.MkdirAll(objectDir, 0755); err != nil {
-			return "", err
-		}
-		path = filepath.Join(s.Root, bucket, key)
-		versionID = "simple"
-	}
-
 	tmpPath := path + ".tmp-" + versionID
 	tmpFile, err := os.Create(tmpPath)
 	if err != nil {
@@ -611,50 +781,40 @@ func (s *FileStorage) PutObject(bucket, key string, reader io.Reader, encryption
 	hash := sha256.New()
 	teeReader := io.TeeReader(reader, hash)
 
-	// Copy data and track size
-	var writeCloser io.WriteCloser = tmpFile
-	var errW error
-	if encryptionType == "AES256" {
-		writeCloser, errW = crypto.EncryptStream(crypto.GetMasterKey(), tmpFile)
-		if errW != nil {
-			return "", errW
-		}
-	}
-
 	compressionType := ""
-	var gzipWriter *gzip.Writer
 	if isCompressible(contentType) {
-		gzipWriter = gzip.NewWriter(writeCloser)
-		writeCloser = gzipWriter
 		compressionType = "gzip"
 	}
+	writeCloser, err := encodeStored(tmpFile, encryptionType, compressionType)
+	if err != nil {
+		return "", err
+	}
 
+	// Hashing, compression and encryption all happen while the body streams to disk
+	_, writeSpan := tracing.StartChild(s.context(), "storage.Write",
+		attribute.String("storage.compression", compressionType), attribute.String("storage.encryption", encryptionType))
 	buf := bufferPool.Get().([]byte)
 	size, err = io.CopyBuffer(writeCloser, teeReader, buf)
 	bufferPool.Put(buf)
 	if err != nil {
+		tracing.End(writeSpan, err)
 		return "", err
 	}
 
-	if gzipWriter != nil {
-		gzipWriter.Close()
-	}
-	if writeCloser != tmpFile {
-		writeCloser.Close()
+	if err := writeCloser.Close(); err != nil {
+		tracing.End(writeSpan, err)
+		return "", err
 	}
-	tmpFile.Close()
+	writeSpan.SetAttributes(attribute.Int64("storage.bytes", size))
+	writeSpan.End()
 
 	// Check bucket quota (Post-upload)
 	if s.DB != nil {
-		log.Printf("Post-upload quota check for %s", bucket)
-		bucketInfo, err := s.DB.GetBucket(bucket)
-		if err == nil && bucketInfo != nil && bucketInfo.QuotaBytes > 0 {
-			_, currentSize, err := s.GetBucketStats(bucket)
-			if err == nil && currentSize+size > bucketInfo.QuotaBytes {
-				os.Remove(tmpPath)
-				tmpCleanup = false
-				return "", fmt.Errorf("Bucket quota exceeded (%s limit reached). Upload of %s rejected.", formatBytes(bucketInfo.QuotaBytes), formatBytes(size))
-			}
+		log.Printf("%sPost-upload quota check for %s", tracing.LogPrefix(s.context()), bucket)
+		if quota, used := s.bucketQuotaUsage(bucket); quota > 0 && used+size > quota {
+			os.Remove(tmpPath)
+			tmpCleanup = false
+			return "", fmt.Errorf("Bucket quota exceeded (%s limit reached). Upload of %s rejected.", formatBytes(quota), formatBytes(size))
 		}
 	}
 
@@ -667,170 +827,348 @@ func (s *FileStorage) PutObject(bucket, key string, reader io.Reader, encryption
 	}
 	onDiskSize := fi.Size()
 
-	casPath := filepath.Join(s.Root, ".cas", contentHash[:2], contentHash)
-	os.MkdirAll(filepath.Dir(casPath), 0755)
+	isDeduplicated, err := s.linkCAS(bucket, tmpPath, path, contentHash)
+	if err != nil {
+		return "", err
+	}
+	tmpCleanup = false
+
+	row := &database.ObjectRow{
+		Size:            size,
+		ContentType:     &contentType,
+		EncryptionType:  &encryptionType,
+		ContentHash:     &contentHash,
+		CompressionType: &compressionType,
+		OriginalSize:    &onDiskSize,
+		IsDeduplicated:  isDeduplicated,
+	}
+	if redirectLocation != "" {
+		row.WebsiteRedirectLocation = &redirectLocation
+	}
+	err = s.commitObjectWrite(w, row, "ObjectCreated:Put", replica)
+	if err != nil {
+		return "", err
+	}
+	return versionID, nil
+}
+
+// objectWrite is a new version of an object being written: where its content goes and the
+// bucket settings it is stored under
+type objectWrite struct {
+	bucket        string
+	key           string
+	versionID     string
+	path          string
+	versioning    bool
+	retentionMode string
+	retentionDays int
+}
+
+// prepareObjectWrite checks that key may be written to bucket, against object locks and the
+// bucket quota, and picks the version ID and path of the new version. size is the size of the
+// content when it is known up front, or 0.
+func (s *FileStorage) prepareObjectWrite(bucket, key string, size int64) (*objectWrite, error) {
+	w := &objectWrite{bucket: bucket, key: key, versionID: fmt.Sprintf("%d", time.Now().UnixNano())}
 
-	isDeduplicated := false
+	// Check if versioning is enabled and get object lock defaults
 	if s.DB != nil {
-		existingCASObject, _ := s.DB.GetObjectByHash(contentHash)
-		if existingCASObject != nil {
-			isDeduplicated = true
-			os.Remove(tmpPath)
+		bucketInfo, err := s.DB.GetBucket(bucket)
+		if err == nil && bucketInfo != nil {
+			w.versioning = bucketInfo.VersioningEnabled
+			if bucketInfo.ObjectLockEnabled {
+				w.retentionMode = bucketInfo.DefaultRetentionMode
+				w.retentionDays = bucketInfo.DefaultRetentionDays
+			}
 		}
 	}
 
-	if !isDeduplicated {
-		if _, err := os.Stat(casPath); os.IsNotExist(err) {
-			if err := os.Rename(tmpPath, casPath); err != nil {
-				return "", err
+	// Check if the existing object has a lock (before overwriting)
+	// ONLY block if versioning is disabled. If versioning is enabled, a new version is created.
+	if !w.versioning && s.DB != nil {
+		existingObj, _ := s.DB.GetObject(bucket, key, "")
+		if existingObj != nil {
+			// Check for legal hold
+			if existingObj.LegalHold {
+				return nil, fmt.Errorf("object is under legal hold and cannot be overwritten")
+			}
+
+			// Check for retention period
+			if existingObj.RetainUntilDate != nil && time.Now().Before(*existingObj.RetainUntilDate) {
+				lockMode := ""
+				if existingObj.LockMode != nil {
+					lockMode = *existingObj.LockMode
+				}
+				return nil, fmt.Errorf("object is under %s retention until %s and cannot be overwritten",
+					lockMode, existingObj.RetainUntilDate.Format(time.RFC3339))
 			}
-		} else {
-			os.Remove(tmpPath)
 		}
 	}
-	tmpCleanup = false
 
-	if _, err := os.Stat(path); err == nil {
-		os.Remove(path)
+	// Pre-check bucket quota
+	if s.DB != nil {
+		if quota, used := s.bucketQuotaUsage(bucket); quota > 0 && (used >= quota || used+size > quota) {
+			return nil, fmt.Errorf("Bucket quota exceeded (%s limit reached)", formatBytes(quota))
+		}
 	}
-	if err := os.Link(casPath, path); err != nil {
-		return "", fmt.Errorf("failed to create hard link: %w", err)
+
+	if w.versioning {
+		// Versioned storage: create directory structure
+		objectDir := filepath.Join(s.Root, bucket, key)
+		if err := os.MkdirAll(objectDir, 0755); err != nil {
+			return nil, err
+		}
+		w.path = filepath.Join(objectDir, w.versionID)
+	} else {
+		// Non-versioned storage: simple file
+		objectDir := filepath.Join(s.Root, bucket, filepath.Dir(key))
+		if err := os.MkdirAll(objectDir, 0755); err != nil {
+			return nil, err
+		}
+		w.path = filepath.Join(s.Root, bucket, key)
+		w.versionID = "simple"
 	}
+	return w, nil
+}
 
+// commitObjectWrite makes a version whose content is linked at w.path visible: it moves the
+// latest pointer, records row with the identity and retention of the version, and sends the
+// event. Versions written by the replication worker are marked REPLICA; others are queued
+// for replication.
+func (s *FileStorage) commitObjectWrite(w *objectWrite, row *database.ObjectRow, eventName string, replica bool) error {
 	// Update latest pointer only for versioned storage
-	if versioningEnabled {
-		objectDir := filepath.Join(s.Root, bucket, key)
-		err = os.WriteFile(filepath.Join(objectDir, "latest"), []byte(versionID), 0644)
-		if err != nil {
-			return "", err
+	if w.versioning {
+		if err := os.WriteFile(filepath.Join(s.Root, w.bucket, w.key, "latest"), []byte(w.versionID), 0644); err != nil {
+			return err
 		}
 	}
 
 	// Save metadata to database
 	if s.DB != nil {
 		// Apply default retention if set
-		var retainUntil *time.Time
-		var lockMode *string
-		if defaultRetentionMode != "" && defaultRetentionDays > 0 {
-			t := time.Now().AddDate(0, 0, defaultRetentionDays)
-			retainUntil = &t
-			m := defaultRetentionMode
-			lockMode = &m
-		}
-
-		objectRow := &database.ObjectRow{
-			Bucket:          bucket,
-			Key:             key,
-			VersionID:       versionID,
-			Size:            size,
-			ETag:            &versionID,
-			ContentType:     &contentType,
-			IsLatest:        true,
-			EncryptionType:  &encryptionType,
-			RetainUntilDate: retainUntil,
-			LockMode:        lockMode,
-			ContentHash:     &contentHash,
-			CompressionType: &compressionType,
-			OriginalSize:    &onDiskSize,
-			IsDeduplicated:  isDeduplicated,
+		if w.retentionMode != "" && w.retentionDays > 0 {
+			t := time.Now().AddDate(0, 0, w.retentionDays)
+			m := w.retentionMode
+			row.RetainUntilDate = &t
+			row.LockMode = &m
+		}
+		versionID := w.versionID
+		row.Bucket = w.bucket
+		row.Key = w.key
+		row.VersionID = versionID
+		row.ETag = &versionID
+		row.IsLatest = true
+		if _, err := s.DB.CreateObject(row); err != nil {
+			return err
 		}
-		s.DB.CreateObject(objectRow)
-		metrics.ObjectsTotal.WithLabelValues(bucket).Inc()
-		metrics.StorageBytes.WithLabelValues(bucket).Add(float64(size))
 
 		// Trigger Webhook
 		if s.Notifications != nil {
-			s.Notifications.Dispatch(notifications.Event{
-				Bucket:    bucket,
-				Key:       key,
+			s.Notifications.Dispatch(s.context(), notifications.Event{
+				Bucket:    w.bucket,
+				Key:       w.key,
 				VersionID: versionID,
-				Size:      size,
+				Size:      row.Size,
 				ETag:      versionID,
-				EventName: "ObjectCreated:Put",
+				EventName: eventName,
 			})
 		}
 	}
 
 	// Invalidate object list cache for this bucket and all parent prefixes
-	s.invalidateObjectListCache(bucket, key)
+	s.invalidateObjectListCache(w.bucket, w.key)
 
-	// Trigger Asynchronous Replication if configured
+	// Queue replication if configured
 	if s.DB != nil {
-		rules, err := s.DB.GetReplicationRules(bucket)
-		if err == nil {
-			for _, rule := range rules {
-				if rule.Enabled {
-					prefix := ""
-					if rule.Prefix != nil {
-						prefix = *rule.Prefix
-					}
-					if prefix == "" || strings.HasPrefix(key, prefix) {
-						go func(destBucket string, encType string) {
-							// Open source object reader
-							reader, _, err := s.GetObject(bucket, key, versionID)
-							if err == nil {
-								defer reader.Close()
-								s.PutObject(destBucket, key, reader, encType)
-							}
-						}(rule.DestinationBucket, encryptionType)
-					}
-				}
-			}
+		if replica {
+			s.DB.SetObjectReplicationStatus(w.bucket, w.key, w.versionID, ReplicationStatusReplica)
+		} else {
+			s.queueReplication(w.bucket, w.key, w.versionID, ReplicationOpPut)
 		}
 	}
+	return nil
+}
 
-	return versionID, nil
+// bucketQuotaUsage returns the bucket's quota and current usage; quota is 0 when the bucket has
+// none or it cannot be read
+func (s *FileStorage) bucketQuotaUsage(bucket string) (quota, used int64) {
+	s, span := s.startSpan("QuotaCheck", tracing.Bucket(bucket))
+	defer span.End()
+
+	bucketInfo, err := s.DB.GetBucket(bucket)
+	if err != nil || bucketInfo == nil || bucketInfo.QuotaBytes <= 0 {
+		return 0, 0
+	}
+	_, used, err = s.GetBucketStats(bucket)
+	if err != nil {
+		return 0, 0
+	}
+	return bucketInfo.QuotaBytes, used
+}
+
+// linkCAS moves a freshly written temp file into the content-addressed store, or drops it when
+// the blob is already stored, and hard-links the object path to the blob. In buckets that are
+// erasure-coded the blob is stored as shards, and with a blob store it is put there; the object
+// path then becomes a stub.
+func (s *FileStorage) linkCAS(bucket, tmpPath, path, contentHash string) (isDeduplicated bool, err error) {
+	s, span := s.startSpan("CASLink")
+	defer func() {
+		span.SetAttributes(attribute.Bool("storage.deduplicated", isDeduplicated))
+		tracing.End(span, err)
+	}()
+
+	var existed bool
+	if s.inBlobStore(contentHash) {
+		existed, err = s.storeInBlobStore(tmpPath, contentHash)
+	} else if dataShards, parityShards := s.erasureCode(bucket, contentHash); dataShards > 0 {
+		existed, err = s.storeErasure(tmpPath, contentHash, dataShards, parityShards)
+	} else {
+		existed, err = s.storeBlob(tmpPath, contentHash)
+	}
+	if err != nil {
+		return false, err
+	}
+	if existed && s.DB != nil {
+		existingCASObject, _ := s.DB.GetObjectByHash(contentHash)
+		isDeduplicated = existingCASObject != nil
+	}
+
+	if _, err := os.Lstat(path); err == nil {
+		os.Remove(path)
+	}
+	if err := s.linkStored(contentHash, path); err != nil {
+		return isDeduplicated, fmt.Errorf("failed to create hard link: %w", err)
+	}
+	return isDeduplicated, nil
 }
 
 func (s *FileStorage) GetObject(bucket, key, versionID string) (io.ReadCloser, *Object, error) {
+	return s.GetObjectRange(bucket, key, versionID, 0, -1)
+}
+
+// GetObjectRange reads length bytes of the content of an object from offset, all of the rest
+// when length is negative. Content stored as is is read from the offset on; compressed or
+// encrypted content is decoded from the start and skipped up to it.
+func (s *FileStorage) GetObjectRange(bucket, key, versionID string, offset, length int64) (io.ReadCloser, *Object, error) {
+	s, span := s.startSpan("GetObject", tracing.Bucket(bucket), tracing.Key(key))
+	defer span.End()
+
 	// 1. Get metadata first (from DB or Stat)
 	obj, err := s.StatObject(bucket, key, versionID)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// Damaged content stays unreadable until the scrubber restores an intact copy
+	if obj.Corrupted {
+		return nil, nil, ErrObjectCorrupted
+	}
+
 	// Adjust versionID if it was empty (StatObject resolved it)
 	versionID = obj.VersionID
 
 	fullPath := filepath.Join(s.Root, bucket, key)
-	var reader *os.File
-	if versionID == "legacy" {
-		reader, err = os.Open(fullPath)
+	if versionID != "legacy" {
+		fullPath = filepath.Join(fullPath, versionID)
+	}
+	asIs := obj.EncryptionType == "" && obj.CompressionType == "" && !IsArchivedStorageClass(obj.StorageClass)
+	var reader io.ReadCloser
+	if IsArchivedStorageClass(obj.StorageClass) {
+		reader, err = s.openArchivedObject(bucket, obj)
+	} else if asIs {
+		reader, err = s.openObjectRange(fullPath, obj.ContentHash, offset, length)
 	} else {
-		reader, err = os.Open(filepath.Join(fullPath, versionID))
+		reader, err = s.openObjectFile(fullPath, obj.ContentHash)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+	if asIs {
+		return reader, obj, nil
 	}
 
+	readCloser, err := decodeStored(reader, obj.EncryptionType, obj.CompressionType)
 	if err != nil {
 		return nil, nil, err
 	}
+	if offset > 0 || length >= 0 {
+		if readCloser, err = readSection(readCloser, offset, length); err != nil {
+			return nil, nil, err
+		}
+	}
+	return readCloser, obj, nil
+}
 
-	var readCloser io.ReadCloser = reader
+// decodeStored returns the content of a stored file, decrypting and decompressing it as its
+// metadata says. Closing the result closes the file.
+func decodeStored(file io.ReadCloser, encryptionType, compressionType string) (io.ReadCloser, error) {
+	var readCloser io.ReadCloser = file
 	// Check if encrypted
-	if obj.EncryptionType == "AES256" {
-		readCloser, err = crypto.DecryptStream(crypto.GetMasterKey(), reader)
+	if encryptionType == "AES256" {
+		var err error
+		readCloser, err = crypto.DecryptStream(crypto.GetMasterKey(), file)
 		if err != nil {
-			reader.Close()
-			return nil, nil, err
+			file.Close()
+			return nil, err
 		}
 	}
 
 	// Check if compressed
-	if obj.CompressionType == "gzip" {
+	if compressionType == "gzip" {
 		gzReader, err := gzip.NewReader(readCloser)
 		if err != nil {
 			readCloser.Close()
-			return nil, nil, err
+			return nil, err
 		}
-		return &gzipReadCloser{gzReader: gzReader, fileCloser: readCloser}, obj, nil
+		return &gzipReadCloser{gzReader: gzReader, fileCloser: readCloser}, nil
 	}
 
-	return readCloser, obj, nil
+	return readCloser, nil
+}
+
+// encodeStored wraps a file so that what is written to it is compressed and then encrypted as
+// requested, the inverse of decodeStored. Closing the result flushes every layer, innermost
+// last, and closes the file.
+func encodeStored(file *os.File, encryptionType, compressionType string) (io.WriteCloser, error) {
+	var writeCloser io.WriteCloser = file
+	if encryptionType == "AES256" {
+		var err error
+		writeCloser, err = crypto.EncryptStream(crypto.GetMasterKey(), file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if compressionType == "gzip" {
+		return &gzipWriteCloser{gzWriter: gzip.NewWriter(writeCloser), fileCloser: writeCloser}, nil
+	}
+	return writeCloser, nil
 }
 
 func (s *FileStorage) StatObject(bucket, key, versionID string) (*Object, error) {
+	s, span := s.startSpan("StatObject", tracing.Bucket(bucket), tracing.Key(key))
+	defer span.End()
+
+	// Archived versions no longer have a file in the primary tier, so their metadata comes from the DB
+	if s.DB != nil && versionID != "legacy" {
+		row, _ := s.DB.GetObject(bucket, key, versionID)
+		if isArchivedRow(row) {
+			return objectFromRow(row), nil
+		}
+	}
+
 	fullPath := filepath.Join(s.Root, bucket, key)
-	info, err := os.Stat(fullPath)
+	info, err := statObjectFile(fullPath)
 	if err == nil && !info.IsDir() {
+		// Non-versioned uploads keep their metadata (compression, encryption, ...) under the "simple" version
+		if s.DB != nil {
+			if row, _ := s.DB.GetObject(bucket, key, "simple"); row != nil {
+				obj := objectFromRow(row)
+				obj.VersionID = "legacy"
+				obj.ModTime = info.ModTime()
+				return obj, nil
+			}
+		}
 		return &Object{
 			Key:       key,
 			VersionID: "legacy",
@@ -850,7 +1188,7 @@ func (s *FileStorage) StatObject(bucket, key, versionID string) (*Object, error)
 	}
 
 	path := filepath.Join(objectDir, versionID)
-	info, err = os.Stat(path)
+	info, err = statObjectFile(path)
 	if err != nil {
 		return nil, err
 	}
@@ -859,6 +1197,9 @@ func (s *FileStorage) StatObject(bucket, key, versionID string) (*Object, error)
 	contentType := ""
 	compressionType := ""
 	contentHash := ""
+	redirectLocation := ""
+	replicationStatus := ""
+	corrupted := false
 	var dbSize int64
 	var originalSize int64
 	if s.DB != nil {
@@ -880,6 +1221,13 @@ func (s *FileStorage) StatObject(bucket, key, versionID string) (*Object, error)
 			if obj.OriginalSize != nil {
 				originalSize = *obj.OriginalSize
 			}
+			if obj.WebsiteRedirectLocation != nil {
+				redirectLocation = *obj.WebsiteRedirectLocation
+			}
+			if obj.ReplicationStatus != nil {
+				replicationStatus = *obj.ReplicationStatus
+			}
+			corrupted = obj.CorruptedAt != nil
 		}
 	}
 
@@ -899,10 +1247,24 @@ func (s *FileStorage) StatObject(bucket, key, versionID string) (*Object, error)
 		CompressionType: compressionType,
 		ContentHash:     contentHash,
 		OriginalSize:    originalSize,
+
+		WebsiteRedirectLocation: redirectLocation,
+		ReplicationStatus:       replicationStatus,
+		Corrupted:               corrupted,
 	}, nil
 }
 
 func (s *FileStorage) DeleteObject(bucket, key, versionID string, bypassGovernance bool) error {
+	return s.deleteObject(bucket, key, versionID, bypassGovernance, false)
+}
+
+// deleteObject removes an object; deletions applied by the replication worker are not replicated again
+func (s *FileStorage) deleteObject(bucket, key, versionID string, bypassGovernance bool, replica bool) error {
+	s, span := s.startSpan("DeleteObject", tracing.Bucket(bucket), tracing.Key(key))
+	defer span.End()
+
+	// Only deletes of the current object are replicated; removing a specific version stays local, as in S3
+	replicateDelete := versionID == "" && !replica
 
 	// Check if soft delete is enabled for this bucket
 	softDeleteEnabled := false
@@ -914,6 +1276,7 @@ func (s *FileStorage) DeleteObject(bucket, key, versionID string, bypassGovernan
 	}
 
 	var contentHash string
+	archived := false
 
 	// Delete from database
 	if s.DB != nil {
@@ -956,9 +1319,7 @@ func (s *FileStorage) DeleteObject(bucket, key, versionID string, bypassGovernan
 				if obj.ContentHash != nil {
 					contentHash = *obj.ContentHash
 				}
-
-				metrics.ObjectsTotal.WithLabelValues(bucket).Dec()
-				metrics.StorageBytes.WithLabelValues(bucket).Sub(float64(obj.Size))
+				archived = isArchivedRow(obj)
 
 				if softDeleteEnabled {
 					s.DB.SoftDeleteObject(bucket, key, obj.VersionID)
@@ -977,7 +1338,7 @@ func (s *FileStorage) DeleteObject(bucket, key, versionID string, bypassGovernan
 
 			// Trigger Webhook for file deletion
 			if s.Notifications != nil {
-				s.Notifications.Dispatch(notifications.Event{
+				s.Notifications.Dispatch(s.context(), notifications.Event{
 					Bucket:    bucket,
 					Key:       key,
 					VersionID: versionID,
@@ -1010,7 +1371,12 @@ func (s *FileStorage) DeleteObject(bucket, key, versionID string, bypassGovernan
 
 	// Filesystem operations (move/delete) carried out concurrently with some safety
 	var fsErr error
-	if softDeleteEnabled {
+	if archived {
+		// Archived versions only exist in the cold tier; keep the archive while the version sits in the trash
+		if !softDeleteEnabled {
+			s.removeArchivedCopies(bucket, key, versionID)
+		}
+	} else if softDeleteEnabled {
 		parent := filepath.Dir(trashPath)
 		os.MkdirAll(parent, 0755)
 		fsErr = os.Rename(srcPath, trashPath)
@@ -1022,22 +1388,9 @@ func (s *FileStorage) DeleteObject(bucket, key, versionID string, bypassGovernan
 		s.cleanOrphanedCAS(contentHash)
 	}
 
-	// Trigger Asynchronous Replication of deletion if configured
-	if s.DB != nil && fsErr == nil {
-		rules, err := s.DB.GetReplicationRules(bucket)
-		if err == nil {
-			for _, rule := range rules {
-				if rule.Enabled {
-					prefix := ""
-					if rule.Prefix != nil {
-						prefix = *rule.Prefix
-					}
-					if prefix == "" || strings.HasPrefix(key, prefix) {
-						go s.DeleteObject(rule.DestinationBucket, key, versionID, bypassGovernance)
-					}
-				}
-			}
-		}
+	// Queue replication of the deletion if configured
+	if s.DB != nil && fsErr == nil && replicateDelete {
+		s.queueReplication(bucket, key, versionID, ReplicationOpDelete)
 	}
 
 	return fsErr
@@ -1061,7 +1414,15 @@ func (s *FileStorage) RestoreObject(bucket, key, versionID string) error {
 	}
 
 	if err := os.Rename(srcPath, dstPath); err != nil {
-		return err
+		// Archived versions have nothing in the trash directory; only their metadata is restored
+		archived := false
+		if os.IsNotExist(err) && s.DB != nil {
+			row, _ := s.DB.GetObjectIncludeDeleted(bucket, key, versionID)
+			archived = isArchivedRow(row)
+		}
+		if !archived {
+			return err
+		}
 	}
 
 	if s.DB != nil {
@@ -1074,12 +1435,6 @@ func (s *FileStorage) RestoreObject(bucket, key, versionID string) error {
 		if err != nil {
 			return err
 		}
-		// Logic to update metrics (approximate)
-		obj, _ := s.DB.GetObject(bucket, key, versionID)
-		if obj != nil {
-			metrics.ObjectsTotal.WithLabelValues(bucket).Inc()
-			metrics.StorageBytes.WithLabelValues(bucket).Add(float64(obj.Size))
-		}
 	}
 
 	// Invalidate cache
@@ -1139,6 +1494,9 @@ func (s *FileStorage) EmptyTrash(bucket string) error {
 
 		// Delete version file from filesystem
 		os.RemoveAll(physicalPath)
+		if isArchivedRow(obj) {
+			s.removeArchivedCopies(obj.Bucket, obj.Key, obj.VersionID)
+		}
 
 		// Delete parent directory if it's empty (this handles clearing the object directory)
 		if objectDir != "" {
@@ -1181,10 +1539,16 @@ func (s *FileStorage) DeleteTrashFilesystem(bucket, key, versionID string) error
 	} else if versionID == "simple" {
 		trashPath = filepath.Join(trashPath, "simple")
 	}
+	if versionID != "folder" {
+		s.removeArchivedCopies(bucket, key, versionID)
+	}
 	return os.RemoveAll(trashPath)
 }
 
 func (s *FileStorage) ListObjects(bucket, prefix, delimiter, search string) ([]Object, []string, error) {
+	s, span := s.startSpan("ListObjects", tracing.Bucket(bucket), attribute.String("s3.prefix", prefix))
+	defer span.End()
+
 	// Try cache first (only if no search query)
 	if search == "" && s.Cache != nil {
 		cacheKey := cache.ObjectListKey(bucket, prefix)
@@ -1252,6 +1616,9 @@ func (s *FileStorage) ListObjects(bucket, prefix, delimiter, search string) ([]O
 				if o.OriginalSize != nil {
 					obj.OriginalSize = *o.OriginalSize
 				}
+				if o.StorageClass != nil {
+					obj.StorageClass = *o.StorageClass
+				}
 				objects = append(objects, obj)
 			}
 
@@ -1367,8 +1734,11 @@ func (s *FileStorage) ListObjects(bucket, prefix, delimiter, search string) ([]O
 }
 
 func (s *FileStorage) ListVersions(bucket, key string) ([]Object, error) {
+	s, span := s.startSpan("ListVersions", tracing.Bucket(bucket), tracing.Key(key))
+	defer span.End()
+
 	fullPath := filepath.Join(s.Root, bucket, key)
-	info, err := os.Stat(fullPath)
+	info, err := statObjectFile(fullPath)
 	if err != nil {
 		return nil, err
 	}
@@ -1433,6 +1803,9 @@ func (s *FileStorage) ListVersions(bucket, key string) ([]Object, error) {
 }
 
 func (s *FileStorage) InitiateMultipartUpload(bucket, key string) (string, error) {
+	s, span := s.startSpan("InitiateMultipartUpload", tracing.Bucket(bucket), tracing.Key(key))
+	defer span.End()
+
 	uploadID := fmt.Sprintf("%d", time.Now().UnixNano())
 	uploadDir := filepath.Join(s.Root, bucket, ".uploads", uploadID)
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
@@ -1442,10 +1815,14 @@ func (s *FileStorage) InitiateMultipartUpload(bucket, key string) (string, error
 	if err := os.WriteFile(filepath.Join(uploadDir, "key"), []byte(key), 0644); err != nil {
 		return "", err
 	}
+	metrics.MultipartUploadsInFlight.Inc()
 	return uploadID, nil
 }
 
 func (s *FileStorage) UploadPart(bucket, key, uploadID string, partNumber int, reader io.Reader) (string, error) {
+	s, span := s.startSpan("UploadPart", tracing.Bucket(bucket), tracing.Key(key), attribute.Int("s3.part_number", partNumber))
+	defer span.End()
+
 	uploadDir := filepath.Join(s.Root, bucket, ".uploads", uploadID)
 	if _, err := os.Stat(uploadDir); os.IsNotExist(err) {
 		return "", fmt.Errorf("upload %s not found", uploadID)
@@ -1471,6 +1848,9 @@ func (s *FileStorage) UploadPart(bucket, key, uploadID string, partNumber int, r
 }
 
 func (s *FileStorage) CompleteMultipartUpload(bucket, key, uploadID string, parts []Part) (string, error) {
+	s, span := s.startSpan("CompleteMultipartUpload", tracing.Bucket(bucket), tracing.Key(key), attribute.Int("s3.parts", len(parts)))
+	defer span.End()
+
 	uploadDir := filepath.Join(s.Root, bucket, ".uploads", uploadID)
 	if _, err := os.Stat(uploadDir); os.IsNotExist(err) {
 		return "", fmt.Errorf("upload %s not found", uploadID)
@@ -1523,27 +1903,20 @@ func (s *FileStorage) CompleteMultipartUpload(bucket, key, uploadID string, part
 	}()
 
 	// 2. Setup streaming sink (with encryption if needed)
-	var writer io.WriteCloser = tmpFile
 	encryptionType := "" // Could be passed or retrieved from session
-	if encryptionType == "AES256" {
-		writer, err = crypto.EncryptStream(crypto.GetMasterKey(), tmpFile)
-		if err != nil {
-			return "", err
-		}
-	}
-
 	contentType := mime.TypeByExtension(filepath.Ext(key))
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
 
 	compressionType := ""
-	var gzipWriter *gzip.Writer
 	if isCompressible(contentType) {
-		gzipWriter = gzip.NewWriter(writer)
-		writer = gzipWriter
 		compressionType = "gzip"
 	}
+	writer, err := encodeStored(tmpFile, encryptionType, compressionType)
+	if err != nil {
+		return "", err
+	}
 
 	// 3. Stream parts directly, hashing original data
 	sort.Slice(parts, func(i, j int) bool {
@@ -1572,13 +1945,9 @@ func (s *FileStorage) CompleteMultipartUpload(bucket, key, uploadID string, part
 		totalSize += n
 	}
 
-	if gzipWriter != nil {
-		gzipWriter.Close()
-	}
-	if writer != tmpFile {
-		writer.Close()
+	if err := writer.Close(); err != nil {
+		return "", err
 	}
-	tmpFile.Close()
 
 	// Check bucket quota (Post-assembly)
 	if s.DB != nil {
@@ -1602,34 +1971,10 @@ func (s *FileStorage) CompleteMultipartUpload(bucket, key, uploadID string, part
 	}
 	onDiskSize := fi.Size()
 
-	casPath := filepath.Join(s.Root, ".cas", contentHash[:2], contentHash)
-	os.MkdirAll(filepath.Dir(casPath), 0755)
-
-	isDeduplicated := false
-	if s.DB != nil {
-		existingCASObject, _ := s.DB.GetObjectByHash(contentHash)
-		if existingCASObject != nil {
-			isDeduplicated = true
-			os.Remove(tmpPath)
-		}
-	}
-
-	if !isDeduplicated {
-		if _, err := os.Stat(casPath); os.IsNotExist(err) {
-			if err := os.Rename(tmpPath, casPath); err != nil {
-				return "", err
-			}
-		} else {
-			os.Remove(tmpPath)
-		}
-	}
+	isDeduplicated, err := s.linkCAS(bucket, tmpPath, targetPath, contentHash)
 	tmpCleanup = false
-
-	if _, err := os.Stat(targetPath); err == nil {
-		os.Remove(targetPath)
-	}
-	if err := os.Link(casPath, targetPath); err != nil {
-		return "", fmt.Errorf("failed to create hard link for completed multipart upload: %w", err)
+	if err != nil {
+		return "", fmt.Errorf("failed to store completed multipart upload: %w", err)
 	}
 
 	// 4. Update latest pointer and metadata
@@ -1664,13 +2009,16 @@ func (s *FileStorage) CompleteMultipartUpload(bucket, key, uploadID string, part
 			OriginalSize:    &onDiskSize,
 			IsDeduplicated:  isDeduplicated,
 		})
-		metrics.ObjectsTotal.WithLabelValues(bucket).Inc()
-		metrics.StorageBytes.WithLabelValues(bucket).Add(float64(totalSize))
+	}
+
+	// The parts have been assembled into the object
+	if err := os.RemoveAll(uploadDir); err == nil {
+		metrics.MultipartUploadsInFlight.Dec()
 	}
 
 	// Dispatch notification
 	if s.Notifications != nil {
-		s.Notifications.Dispatch(notifications.Event{
+		s.Notifications.Dispatch(s.context(), notifications.Event{
 			Bucket:    bucket,
 			Key:       key,
 			VersionID: versionID,
@@ -1683,20 +2031,44 @@ func (s *FileStorage) CompleteMultipartUpload(bucket, key, uploadID string, part
 	// Invalidate cache for completed multipart upload
 	s.invalidateObjectListCache(bucket, key)
 
+	// Queue replication if configured
+	if s.DB != nil {
+		s.queueReplication(bucket, key, versionID, ReplicationOpPut)
+	}
+
 	return versionID, nil
 }
 
 func (s *FileStorage) AbortMultipartUpload(bucket, key, uploadID string) error {
 	uploadDir := filepath.Join(s.Root, bucket, ".uploads", uploadID)
-	return os.RemoveAll(uploadDir)
+	if _, err := os.Stat(uploadDir); err != nil {
+		return nil
+	}
+	if err := os.RemoveAll(uploadDir); err != nil {
+		return err
+	}
+	metrics.MultipartUploadsInFlight.Dec()
+	return nil
 }
 
 func (s *FileStorage) PutObjectTagging(bucket, key, versionID string, tags map[string]string) error {
+	versionID, err := s.writeObjectTagging(bucket, key, versionID, tags)
+	if err != nil {
+		return err
+	}
+	if s.DB != nil {
+		s.queueMetadataReplication(bucket, key, versionID)
+	}
+	return nil
+}
+
+// writeObjectTagging stores the tags of a version and returns the version it resolved to
+func (s *FileStorage) writeObjectTagging(bucket, key, versionID string, tags map[string]string) (string, error) {
 	objectDir := filepath.Join(s.Root, bucket, key)
 	if versionID == "" {
 		data, err := os.ReadFile(filepath.Join(objectDir, "latest"))
 		if err != nil {
-			return err
+			return "", err
 		}
 		versionID = string(data)
 	}
@@ -1704,9 +2076,9 @@ func (s *FileStorage) PutObjectTagging(bucket, key, versionID string, tags map[s
 	tagsPath := filepath.Join(objectDir, versionID+".tags")
 	data, err := json.Marshal(tags)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return os.WriteFile(tagsPath, data, 0644)
+	return versionID, os.WriteFile(tagsPath, data, 0644)
 }
 
 func (s *FileStorage) GetObjectTagging(bucket, key, versionID string) (map[string]string, error) {
@@ -1935,30 +2307,169 @@ func (s *FileStorage) DeleteBucketWebsite(bucket string) error {
 	return s.DB.DeleteBucketWebsite(bucket)
 }
 
-func (s *FileStorage) StartLifecycleWorker() {
-	// Get interval from environment variable, default to 1 hour
-	intervalStr := os.Getenv("LIFECYCLE_WORKER_INTERVAL")
-	interval := 1 * time.Hour
-	if intervalStr != "" {
-		if minutes, err := strconv.Atoi(intervalStr); err == nil {
-			interval = time.Duration(minutes) * time.Minute
+// PutBucketLogging enables server access logging for a bucket; a nil configuration disables it
+func (s *FileStorage) PutBucketLogging(bucket string, config *BucketLoggingConfiguration) error {
+	if s.DB == nil {
+		return fmt.Errorf("database not available")
+	}
+	// Invalidate cache
+	if s.Cache != nil {
+		s.Cache.Delete(cache.BucketLoggingKey(bucket))
+	}
+	if config == nil || config.TargetBucket == "" {
+		return s.DB.DeleteBucketLogging(bucket)
+	}
+	if exists, _ := s.BucketExists(config.TargetBucket); !exists {
+		return fmt.Errorf("target bucket %s does not exist", config.TargetBucket)
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return s.DB.PutBucketLogging(bucket, string(data))
+}
+
+// GetBucketLogging returns nil when access logging is disabled for the bucket
+func (s *FileStorage) GetBucketLogging(bucket string) (*BucketLoggingConfiguration, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	// Try cache first; it is consulted on every request, so disabled buckets are cached too
+	var cached BucketLoggingConfiguration
+	if s.Cache != nil {
+		if ok := s.Cache.Get(cache.BucketLoggingKey(bucket), &cached); ok {
+			metrics.RecordCacheHit("bucket_logging")
+			if cached.TargetBucket == "" {
+				return nil, nil
+			}
+			return &cached, nil
 		}
+		metrics.RecordCacheMiss("bucket_logging")
 	}
 
-	fmt.Printf("Starting lifecycle worker with interval: %v\n", interval)
+	data, err := s.DB.GetBucketLogging(bucket)
+	if err != nil {
+		return nil, err
+	}
+	var config BucketLoggingConfiguration
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &config); err != nil {
+			return nil, err
+		}
+	}
+	// Cache for 30 minutes. Buckets that do not exist are not cached, so requests naming
+	// arbitrary buckets cannot fill the cache.
+	if s.Cache != nil {
+		if info, _ := s.GetBucketInfo(bucket); data != "" || info != nil {
+			s.Cache.Set(cache.BucketLoggingKey(bucket), &config, 30*time.Minute)
+		}
+	}
+	if config.TargetBucket == "" {
+		return nil, nil
+	}
+	return &config, nil
+}
 
+// Validate checks a website configuration for conflicting or malformed settings
+func (w *WebsiteConfiguration) Validate() error {
+	if w.RedirectAllRequestsTo != nil {
+		if w.RedirectAllRequestsTo.HostName == "" {
+			return fmt.Errorf("RedirectAllRequestsTo requires a host name")
+		}
+		if w.IndexDocument != nil || w.ErrorDocument != nil || len(w.RoutingRules) > 0 || w.SPAFallback {
+			return fmt.Errorf("RedirectAllRequestsTo cannot be combined with other website settings")
+		}
+		return validateRedirectProtocol(w.RedirectAllRequestsTo.Protocol)
+	}
+	for i, rule := range w.RoutingRules {
+		r := rule.Redirect
+		if r.ReplaceKeyWith != "" && r.ReplaceKeyPrefixWith != "" {
+			return fmt.Errorf("routing rule %d: ReplaceKeyWith and ReplaceKeyPrefixWith are mutually exclusive", i+1)
+		}
+		if err := validateRedirectProtocol(r.Protocol); err != nil {
+			return fmt.Errorf("routing rule %d: %w", i+1, err)
+		}
+		if r.HttpRedirectCode != "" && !IsRedirectCode(r.HttpRedirectCode) {
+			return fmt.Errorf("routing rule %d: invalid HttpRedirectCode %q: expected 301, 302, 303, 307 or 308", i+1, r.HttpRedirectCode)
+		}
+		if rule.Condition != nil && rule.Condition.HttpErrorCodeReturnedEquals != "" {
+			code, err := strconv.Atoi(rule.Condition.HttpErrorCodeReturnedEquals)
+			if err != nil || code < 400 || code > 599 {
+				return fmt.Errorf("routing rule %d: invalid HttpErrorCodeReturnedEquals %q", i+1, rule.Condition.HttpErrorCodeReturnedEquals)
+			}
+		}
+	}
+	return nil
+}
+
+// IsRedirectCode reports whether code is a status routing rules may redirect with
+func IsRedirectCode(code string) bool {
+	switch code {
+	case "301", "302", "303", "307", "308":
+		return true
+	}
+	return false
+}
+
+func validateRedirectProtocol(protocol string) error {
+	if protocol != "" && protocol != "http" && protocol != "https" {
+		return fmt.Errorf("invalid redirect protocol %q", protocol)
+	}
+	return nil
+}
+
+func (s *FileStorage) StartLifecycleWorker() {
+	if s.lifecycleInterval == nil {
+		s.lifecycleInterval = newIntervalTicker(time.Hour)
+	}
+	fmt.Printf("Starting lifecycle worker with interval: %v\n", s.lifecycleInterval.get())
+	s.lifecycleHeartbeat = health.Worker("lifecycle")
+	s.lifecycleHeartbeat.Beat()
+	s.lifecycleQuit = make(chan struct{})
+	s.lifecycleDone = make(chan struct{})
+
+	ticks := s.lifecycleInterval.start()
 	go func() {
-		ticker := time.NewTicker(interval)
-		for range ticker.C {
-			s.ProcessLifecycleRules()
+		defer close(s.lifecycleDone)
+		defer s.lifecycleInterval.stop()
+		heartbeat := time.NewTicker(health.HeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-s.lifecycleQuit:
+				return
+			case <-ticks:
+				s.ProcessLifecycleRules()
+			case <-heartbeat.C:
+			}
+			s.lifecycleHeartbeat.Beat()
 		}
 	}()
 }
 
+// SetLifecycleInterval changes how often lifecycle rules are applied
+func (s *FileStorage) SetLifecycleInterval(interval time.Duration) {
+	if s.lifecycleInterval != nil && s.lifecycleInterval.set(interval) {
+		log.Printf("Lifecycle worker interval set to %v", interval)
+	}
+}
+
+// StopLifecycleWorker waits for a running lifecycle pass to finish and stops the worker
+func (s *FileStorage) StopLifecycleWorker() {
+	if s.lifecycleQuit == nil {
+		return
+	}
+	close(s.lifecycleQuit)
+	<-s.lifecycleDone
+	s.lifecycleQuit = nil
+}
+
 func (s *FileStorage) ProcessLifecycleRules() {
 	if s.DB == nil {
 		return
 	}
+	start := time.Now()
+	defer func() { metrics.RecordWorkerRun("lifecycle", time.Since(start)) }()
 
 	// Fetch all buckets with active lifecycle rules directly from DB
 	configs, err := s.DB.GetAllLifecycles()
@@ -1969,6 +2480,7 @@ func (s *FileStorage) ProcessLifecycleRules() {
 	}
 
 	for bucket, configJSON := range configs {
+		s.lifecycleHeartbeat.Beat()
 		var config LifecycleConfiguration
 		if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
 			s.Notifier.SendAlert("Lifecycle Config Error", fmt.Sprintf("Failed to parse config for bucket %s: %v", bucket, err))
@@ -1980,18 +2492,51 @@ func (s *FileStorage) ProcessLifecycleRules() {
 				continue
 			}
 
-			// Use DB to find expired objects (optimized via indices)
-			expired, err := s.DB.GetExpiredObjects(bucket, rule.Filter.Prefix, rule.Expiration.Days)
-			if err != nil {
-				continue
+			if rule.Expiration.Days > 0 {
+				// Use DB to find expired objects (optimized via indices)
+				expired, err := s.DB.GetExpiredObjects(bucket, rule.Filter.Prefix, rule.Expiration.Days)
+				if err == nil {
+					for _, obj := range expired {
+						// Permanently delete the expired version
+						s.runLifecycleJob(&LifecycleExpireJob{Storage: s, Bucket: bucket, Key: obj.Key, VersionID: obj.VersionID})
+					}
+				}
 			}
 
-			for _, obj := range expired {
-				// Permanently delete the expired version
-				s.DeleteObject(bucket, obj.Key, obj.VersionID, true)
+			for _, t := range rule.Transitions {
+				if t.Days <= 0 || !IsArchivedStorageClass(t.StorageClass) {
+					continue
+				}
+				if s.ColdRoot == "" {
+					log.Printf("Lifecycle: skipping transition rule %s for bucket %s, COLD_STORAGE_PATH is not set", rule.ID, bucket)
+					break
+				}
+				candidates, err := s.DB.GetTransitionCandidates(bucket, rule.Filter.Prefix, t.Days, t.StorageClass)
+				if err != nil {
+					continue
+				}
+				for _, obj := range candidates {
+					s.runLifecycleJob(&LifecycleTransitionJob{Storage: s, Bucket: bucket, Key: obj.Key, VersionID: obj.VersionID, StorageClass: t.StorageClass})
+				}
 			}
 		}
 	}
+
+	s.expireRestoredCopies()
+}
+
+// runLifecycleJob queues a lifecycle action, or runs it inline when there is no job manager
+func (s *FileStorage) runLifecycleJob(job jobs.Job) {
+	if s.Jobs != nil {
+		_, err := s.Jobs.Enqueue(job)
+		if err == nil {
+			return
+		}
+		log.Printf("Lifecycle: failed to queue %s, running it now: %v", job.Name(), err)
+	}
+	if err := job.Execute(); err != nil {
+		log.Printf("Lifecycle: %s failed: %v", job.Name(), err)
+	}
 }
 
 func formatBytes(b int64) string {
@@ -2023,14 +2568,21 @@ func (s *FileStorage) cleanOrphanedCAS(hash string) {
 		return
 	}
 	count, err := s.DB.CountObjectHashReferences(hash)
-	if err == nil && count == 0 {
-		casPath := filepath.Join(s.Root, ".cas", hash[:2], hash)
-		os.Remove(casPath)
-		os.Remove(filepath.Dir(casPath)) // Clean parent folder if empty
+	if err != nil || count > 0 {
+		return
 	}
+	if s.erasureBlob(hash) != nil {
+		s.removeErasure(hash)
+		return
+	}
+	if s.Blobs != nil {
+		if err := s.Blobs.Delete(hash); err != nil {
+			log.Printf("Error deleting blob %s from the blob store: %v", hash, err)
+		}
+	}
+	s.removeBlob(hash)
 }
 
-
 type gzipReadCloser struct {
 	gzReader   *gzip.Reader
 	fileCloser io.Closer
@@ -2049,6 +2601,24 @@ func (g *gzipReadCloser) Close() error {
 	return err2
 }
 
+type gzipWriteCloser struct {
+	gzWriter   *gzip.Writer
+	fileCloser io.Closer
+}
+
+func (g *gzipWriteCloser) Write(p []byte) (n int, err error) {
+	return g.gzWriter.Write(p)
+}
+
+func (g *gzipWriteCloser) Close() error {
+	err1 := g.gzWriter.Close()
+	err2 := g.fileCloser.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
 func (s *FileStorage) IsSignatureRevoked(signature string) (bool, error) {
 	if s.DB == nil {
 		return false, nil
@@ -2063,21 +2633,24 @@ func (s *FileStorage) RevokeSignature(signature string) error {
 	return s.DB.RevokeSignature(signature)
 }
 
-func (s *FileStorage) CreateReplicationRule(source, dest, prefix string) error {
+func (s *FileStorage) CreateReplicationRule(rule *database.ReplicationRow) error {
 	if s.DB == nil {
 		return fmt.Errorf("database not available")
 	}
-	var pref *string
-	if prefix != "" {
-		pref = &prefix
+	if rule.TargetID != nil {
+		target, err := s.DB.GetReplicationTarget(*rule.TargetID)
+		if err != nil {
+			return err
+		}
+		if target == nil {
+			return fmt.Errorf("replication target %d not found", *rule.TargetID)
+		}
 	}
-	row := &database.ReplicationRow{
-		SourceBucket:      source,
-		DestinationBucket: dest,
-		Prefix:            pref,
-		Enabled:           true,
+	if rule.Prefix != nil && *rule.Prefix == "" {
+		rule.Prefix = nil
 	}
-	return s.DB.CreateReplicationRule(row)
+	rule.Enabled = true
+	return s.DB.CreateReplicationRule(rule)
 }
 
 func (s *FileStorage) GetReplicationRules(bucket string) ([]database.ReplicationRow, error) {
@@ -2094,3 +2667,48 @@ func (s *FileStorage) DeleteReplicationRule(id int64) error {
 	return s.DB.DeleteReplicationRule(id)
 }
 
+func (s *FileStorage) CreateReplicationTarget(target *database.ReplicationTargetRow) (int64, error) {
+	if s.DB == nil {
+		return 0, fmt.Errorf("database not available")
+	}
+	if target.Name == "" || target.Endpoint == "" {
+		return 0, fmt.Errorf("replication target requires a name and an endpoint")
+	}
+	if !strings.HasPrefix(target.Endpoint, "http://") && !strings.HasPrefix(target.Endpoint, "https://") {
+		return 0, fmt.Errorf("replication target endpoint must be an http(s) URL")
+	}
+	if target.AccessKey == "" || target.SecretKey == "" {
+		return 0, fmt.Errorf("replication target requires credentials")
+	}
+	if target.Region == "" {
+		target.Region = "us-east-1"
+	}
+	return s.DB.CreateReplicationTarget(target)
+}
+
+func (s *FileStorage) ListReplicationTargets() ([]database.ReplicationTargetRow, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	return s.DB.ListReplicationTargets()
+}
+
+func (s *FileStorage) DeleteReplicationTarget(id int64) error {
+	if s.DB == nil {
+		return fmt.Errorf("database not available")
+	}
+	if err := s.DB.DeleteReplicationTarget(id); err != nil {
+		return err
+	}
+	if s.ReplicationWorker != nil {
+		s.ReplicationWorker.ForgetTarget(id)
+	}
+	return nil
+}
+
+func (s *FileStorage) ListReplicationTasks(bucket, status string) ([]database.ReplicationTaskRow, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	return s.DB.ListReplicationTasks(bucket, status, 100)
+}
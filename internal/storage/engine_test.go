@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GravSpace/GravSpace/internal/cache"
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+// newTestStorage returns a storage on a fresh database in a temporary directory. Of opts only
+// the cold tier, the extra drives (formatted even when not mounted) and the blob store are used;
+// no workers or jobs are started.
+func newTestStorage(t *testing.T, opts Options) *FileStorage {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("DATABASE_URL", "file:"+filepath.Join(dir, "test.db"))
+	db, err := database.NewDatabase("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &FileStorage{
+		Root: filepath.Join(dir, "data"), DB: db, Cache: cache.NewInMemoryCache(),
+		ColdRoot: opts.ColdRoot, ColdCompression: opts.ColdCompression, Blobs: opts.Blobs,
+	}
+	if err := os.Mkdir(store.Root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.Drives) > 0 {
+		if store.Drives, err = NewDriveSet(store, opts.Drives, opts.Copies, true, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// blobBackends are the options of a storage keeping its blobs on the data drives and of one
+// keeping them in a blob store
+func blobBackends() map[string]Options {
+	return map[string]Options{"drives": {}, "blobstore": {Blobs: NewMemoryBlobStore()}}
+}
+
+func TestObjectLifecycle(t *testing.T) {
+	for name, opts := range blobBackends() {
+		t.Run(name, func(t *testing.T) {
+			store := newTestStorage(t, opts)
+			store.CreateBucket("docs")
+			store.CreateBucket("copies")
+
+			read := func(bucket, key string, offset, length int64) (string, error) {
+				t.Helper()
+				reader, _, err := store.GetObjectRange(bucket, key, "", offset, length)
+				if err != nil {
+					return "", err
+				}
+				defer reader.Close()
+				data, err := io.ReadAll(reader)
+				return string(data), err
+			}
+			stored := func(hash string) bool {
+				if store.Blobs != nil {
+					_, err := store.Blobs.Stat(hash)
+					return err == nil
+				}
+				return len(store.blobCopies(hash)) > 0
+			}
+
+			content := "the quick brown fox jumps over the lazy dog"
+			for _, bucket := range []string{"docs", "copies"} {
+				if _, err := store.PutObject(bucket, "fox.txt", strings.NewReader(content), ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			row, _ := store.DB.GetObject("docs", "fox.txt", "simple")
+			hash := *row.ContentHash
+			if got, err := read("docs", "fox.txt", 0, -1); err != nil || got != content {
+				t.Fatalf("read = %q, %v", got, err)
+			}
+			if got, err := read("docs", "fox.txt", 4, 5); err != nil || got != "quick" {
+				t.Errorf("range read = %q, %v", got, err)
+			}
+
+			// The blob shared by both objects outlives the first deletion only
+			if err := store.DeleteObject("copies", "fox.txt", "", false); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := read("copies", "fox.txt", 0, -1); err == nil {
+				t.Error("deleted object is still readable")
+			}
+			if got, err := read("docs", "fox.txt", 0, -1); err != nil || got != content || !stored(hash) {
+				t.Fatalf("shared object after deletion = %q, %v", got, err)
+			}
+			if err := store.DeleteObject("docs", "fox.txt", "", false); err != nil {
+				t.Fatal(err)
+			}
+			if stored(hash) {
+				t.Error("blob kept after its last object was deleted")
+			}
+
+			// GC removes a blob nobody references and keeps the referenced ones
+			if _, err := store.PutObject("docs", "kept.txt", strings.NewReader("kept"), ""); err != nil {
+				t.Fatal(err)
+			}
+			row, _ = store.DB.GetObject("docs", "kept.txt", "simple")
+			kept := *row.ContentHash
+			sum := sha256.Sum256([]byte("nobody references this"))
+			orphan := hex.EncodeToString(sum[:])
+			if store.Blobs != nil {
+				store.Blobs.Put(orphan, strings.NewReader("nobody references this"))
+			} else {
+				os.MkdirAll(filepath.Dir(store.casPath(orphan)), 0755)
+				os.WriteFile(store.casPath(orphan), []byte("nobody references this"), 0644)
+			}
+			report, err := store.GarbageCollectCAS(false)
+			if err != nil || len(report.Removed) != 1 || report.Removed[0] != orphan {
+				t.Fatalf("gc = %+v, %v", report, err)
+			}
+			if stored(orphan) || !stored(kept) {
+				t.Errorf("after gc orphan stored = %v, kept stored = %v", stored(orphan), stored(kept))
+			}
+		})
+	}
+}
+
+func TestPutObjectWithRedirect(t *testing.T) {
+	for name, opts := range blobBackends() {
+		t.Run(name, func(t *testing.T) {
+			store := newTestStorage(t, opts)
+			store.CreateBucket("site")
+
+			// The redirect is part of the version as it is created
+			vid, err := store.PutObjectWithRedirect("site", "old.html", strings.NewReader("moved"), "", "/new.html")
+			if err != nil {
+				t.Fatal(err)
+			}
+			obj, err := store.StatObject("site", "old.html", vid)
+			if err != nil || obj.WebsiteRedirectLocation != "/new.html" {
+				t.Fatalf("redirect after put = %+v, %v", obj, err)
+			}
+
+			// Overwriting without one removes it
+			if vid, err = store.PutObject("site", "old.html", strings.NewReader("back"), ""); err != nil {
+				t.Fatal(err)
+			}
+			if obj, err = store.StatObject("site", "old.html", vid); err != nil || obj.WebsiteRedirectLocation != "" {
+				t.Errorf("redirect after overwrite = %+v, %v", obj, err)
+			}
+		})
+	}
+}
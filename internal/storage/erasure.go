@@ -0,0 +1,649 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/cache"
+	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/erasure"
+	"github.com/GravSpace/GravSpace/internal/metrics"
+)
+
+// ErrErasureLayout is returned when a bucket is given an erasure code the drives cannot hold
+var ErrErasureLayout = errors.New("invalid erasure coding layout")
+
+// erasureBlockSize is how many bytes of each shard a stripe holds. Blobs are encoded one stripe
+// of DataShards blocks at a time, so reads and heals never hold more than a stripe in memory.
+const erasureBlockSize = 256 * 1024
+
+// erasureDir holds the shards of the erasure-coded blobs on every drive
+const erasureDir = ".ec"
+
+// Every block of a shard file is preceded by its CRC-32C, so that a read detects a damaged block
+// and reconstructs it from the other shards
+const blockHeaderSize = 4
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errBlockDamaged is returned when a shard block does not match its checksum
+var errBlockDamaged = errors.New("shard block checksum mismatch")
+
+// SetBucketErasure makes the new objects of a bucket erasure-coded with the given numbers of
+// data and parity shards, each shard on its own data drive, or mirrored again when both are
+// zero. Objects already stored keep their layout.
+func (s *FileStorage) SetBucketErasure(bucket string, dataShards, parityShards int) error {
+	if s.DB == nil {
+		return fmt.Errorf("database not available")
+	}
+	if dataShards != 0 || parityShards != 0 {
+		if s.Blobs != nil {
+			return fmt.Errorf("%w: blobs are kept in a blob store rather than on the data drives", ErrErasureLayout)
+		}
+		if _, err := erasure.New(dataShards, parityShards); err != nil {
+			return fmt.Errorf("%w: %v", ErrErasureLayout, err)
+		}
+		if drives := s.driveCount(); dataShards+parityShards > drives {
+			return fmt.Errorf("%w: %d+%d shards need %d data drives, %d are configured",
+				ErrErasureLayout, dataShards, parityShards, dataShards+parityShards, drives)
+		}
+	}
+	err := s.DB.SetBucketErasure(bucket, dataShards, parityShards)
+	if err == nil && s.Cache != nil {
+		s.Cache.Delete(cache.BucketInfoKey(bucket))
+	}
+	return err
+}
+
+// driveCount returns the number of data drives, online or not
+func (s *FileStorage) driveCount() int {
+	if s.Drives == nil {
+		return 1
+	}
+	return len(s.Drives.Drives())
+}
+
+// erasureBlob returns the shards of a blob, or nil when it is stored as whole copies
+func (s *FileStorage) erasureBlob(hash string) *database.ErasureBlobRow {
+	if s.DB == nil || !isCASBlobName(hash) {
+		return nil
+	}
+	e, err := s.DB.GetErasureBlob(hash)
+	if err != nil {
+		log.Printf("Error looking up the erasure shards of blob %s: %v", hash, err)
+		return nil
+	}
+	return e
+}
+
+// erasureCode returns the erasure code a new version of a bucket is stored with. Content that
+// is already stored keeps its layout, so every version of a hash shares one blob.
+func (s *FileStorage) erasureCode(bucket, hash string) (dataShards, parityShards int) {
+	if s.DB == nil {
+		return 0, 0
+	}
+	if e := s.erasureBlob(hash); e != nil {
+		return e.DataShards, e.ParityShards
+	}
+	if s.Blobs != nil || len(s.blobCopies(hash)) > 0 {
+		return 0, 0
+	}
+	info, err := s.DB.GetBucket(bucket)
+	if err != nil || info == nil {
+		return 0, 0
+	}
+	return info.ErasureDataShards, info.ErasureParityShards
+}
+
+func shardPathOn(drive, hash string, index int) string {
+	return filepath.Join(drive, erasureDir, hash[:2], hash, strconv.Itoa(index))
+}
+
+// shardPaths returns the path of every shard of a blob on the online drives, "" for the shards
+// that are missing
+func (s *FileStorage) shardPaths(e *database.ErasureBlobRow) []string {
+	placement := s.blobPlacement(e.Hash)
+	paths := make([]string, e.DataShards+e.ParityShards)
+	for i := range paths {
+		for _, drive := range placement {
+			path := shardPathOn(drive, e.Hash, i)
+			if _, err := os.Stat(path); err == nil {
+				paths[i] = path
+				break
+			}
+		}
+	}
+	return paths
+}
+
+// stripes returns the number of stripes of a blob
+func stripes(e *database.ErasureBlobRow) int64 {
+	perStripe := int64(e.DataShards) * e.BlockSize
+	return (e.Size + perStripe - 1) / perStripe
+}
+
+// stripeLayout returns where the blocks of a stripe start in the shard files, how long they are
+// and how many bytes of the blob the stripe holds. Every stripe but the last is full.
+func stripeLayout(e *database.ErasureBlobRow, stripe int64) (offset, blockLen, dataLen int64) {
+	perStripe := int64(e.DataShards) * e.BlockSize
+	offset = stripe * (blockHeaderSize + e.BlockSize)
+	dataLen = min(perStripe, e.Size-stripe*perStripe)
+	blockLen = (dataLen + int64(e.DataShards) - 1) / int64(e.DataShards)
+	return offset, blockLen, dataLen
+}
+
+// readBlock reads a block of a shard file and checks it against its checksum
+func readBlock(file *os.File, offset, blockLen int64) ([]byte, error) {
+	buf := make([]byte, blockHeaderSize+blockLen)
+	if _, err := file.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	block := buf[blockHeaderSize:]
+	if crc32.Checksum(block, crcTable) != binary.BigEndian.Uint32(buf) {
+		return nil, errBlockDamaged
+	}
+	return block, nil
+}
+
+func writeBlock(w io.Writer, block []byte) error {
+	var header [blockHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:], crc32.Checksum(block, crcTable))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(block)
+	return err
+}
+
+// shardWriter writes a shard to a temp file next to its final path, hashing what it writes
+type shardWriter struct {
+	path string
+	file *os.File
+	buf  *bufio.Writer
+	hash hash.Hash
+}
+
+func createShard(path string) (*shardWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	return &shardWriter{path: path, file: file, buf: bufio.NewWriter(io.MultiWriter(file, h)), hash: h}, nil
+}
+
+func (w *shardWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// commit syncs the shard and moves it into place
+func (w *shardWriter) commit() error {
+	err := w.buf.Flush()
+	if err == nil {
+		err = w.file.Sync()
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(w.file.Name(), w.path)
+	}
+	if err != nil {
+		os.Remove(w.file.Name())
+	}
+	return err
+}
+
+func (w *shardWriter) abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+func (w *shardWriter) checksum() string {
+	return hex.EncodeToString(w.hash.Sum(nil))
+}
+
+// storeErasure encodes the file at tmpPath into data and parity shards, spread over the online
+// drives the blob prefers, and records their checksums. It reports whether the blob was already
+// stored. tmpPath is removed in every case.
+func (s *FileStorage) storeErasure(tmpPath, hash string, dataShards, parityShards int) (existed bool, err error) {
+	defer os.Remove(tmpPath)
+	if s.erasureBlob(hash) != nil {
+		return true, nil
+	}
+	codec, err := erasure.New(dataShards, parityShards)
+	if err != nil {
+		return false, err
+	}
+	drives := s.blobPlacement(hash)
+	if len(drives) == 0 {
+		return false, fmt.Errorf("no data drive is online")
+	}
+	if len(drives) < codec.Shards() {
+		log.Printf("Only %d data drives are online; blob %s keeps several of its %d shards on one drive", len(drives), hash, codec.Shards())
+	}
+
+	src, err := os.Open(tmpPath)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	writers := make([]*shardWriter, codec.Shards())
+	defer func() {
+		if err != nil {
+			for _, w := range writers {
+				if w != nil {
+					w.abort()
+				}
+			}
+		}
+	}()
+	for i := range writers {
+		if writers[i], err = createShard(shardPathOn(drives[i%len(drives)], hash, i)); err != nil {
+			return false, err
+		}
+	}
+
+	perStripe := dataShards * erasureBlockSize
+	stripe := make([]byte, perStripe)
+	parity := make([][]byte, parityShards)
+	for i := range parity {
+		parity[i] = make([]byte, erasureBlockSize)
+	}
+	shards := make([][]byte, codec.Shards())
+	for {
+		n, readErr := io.ReadFull(src, stripe)
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			return false, readErr
+		}
+		blockLen := (n + dataShards - 1) / dataShards
+		clear(stripe[n : blockLen*dataShards])
+		for i := 0; i < dataShards; i++ {
+			shards[i] = stripe[i*blockLen : (i+1)*blockLen]
+		}
+		for i := range parity {
+			shards[dataShards+i] = parity[i][:blockLen]
+		}
+		if err = codec.Encode(shards); err != nil {
+			return false, err
+		}
+		for i, w := range writers {
+			if err = writeBlock(w, shards[i]); err != nil {
+				return false, err
+			}
+		}
+		if readErr == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+
+	checksums := make([]string, len(writers))
+	for i, w := range writers {
+		if err = w.commit(); err != nil {
+			return false, err
+		}
+		writers[i] = nil
+		checksums[i] = w.checksum()
+	}
+	err = s.DB.CreateErasureBlob(&database.ErasureBlobRow{
+		Hash: hash, DataShards: dataShards, ParityShards: parityShards, BlockSize: erasureBlockSize,
+		Size: info.Size(), Checksums: checksums, CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		s.removeErasure(hash)
+	}
+	return false, err
+}
+
+// writeStub atomically replaces the file of a version with an empty stub, for blobs whose
+// content is read from erasure shards or the blob store
+func writeStub(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp-stub"
+	if err := os.WriteFile(tmp, nil, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// linkStored creates the file of a version of a stored blob: a link of a CAS copy, or a stub
+// for an erasure-coded blob or one in the blob store
+func (s *FileStorage) linkStored(hash, path string) error {
+	if s.inBlobStore(hash) || s.erasureBlob(hash) != nil {
+		return writeStub(path)
+	}
+	return s.linkBlob(hash, path)
+}
+
+// relinkFile points the file of a version at its stored blob again, replacing what is there
+func (s *FileStorage) relinkFile(hash, path string) error {
+	if s.inBlobStore(hash) || s.erasureBlob(hash) != nil {
+		return writeStub(path)
+	}
+	return replaceWithLink(s.casPath(hash), path)
+}
+
+// removeErasure deletes the shards of a blob from every online drive and forgets the blob
+func (s *FileStorage) removeErasure(hash string) {
+	for _, drive := range s.blobPlacement(hash) {
+		dir := filepath.Join(drive, erasureDir, hash[:2], hash)
+		os.RemoveAll(dir)
+		os.Remove(filepath.Dir(dir)) // Clean parent folder if empty
+	}
+	if err := s.DB.DeleteErasureBlob(hash); err != nil {
+		log.Printf("Error forgetting the erasure shards of blob %s: %v", hash, err)
+	}
+}
+
+// openErasure returns the content of an erasure-coded blob, reconstructed from the parity
+// shards where data shards are missing or damaged
+func (s *FileStorage) openErasure(e *database.ErasureBlobRow) (io.ReadCloser, error) {
+	codec, err := erasure.New(e.DataShards, e.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	r := &erasureReader{blob: e, codec: codec, files: make([]*os.File, codec.Shards()), stripes: stripes(e)}
+	available := 0
+	for i, path := range s.shardPaths(e) {
+		if path == "" {
+			continue
+		}
+		if r.files[i], err = os.Open(path); err == nil {
+			available++
+		}
+	}
+	if available < e.DataShards {
+		r.Close()
+		return nil, fmt.Errorf("blob %s has %d of its %d shards, %d are needed: %w", e.Hash, available, codec.Shards(), e.DataShards, erasure.ErrTooFewShards)
+	}
+	return r, nil
+}
+
+// erasureReader reads an erasure-coded blob stripe by stripe
+type erasureReader struct {
+	blob    *database.ErasureBlobRow
+	codec   *erasure.Codec
+	files   []*os.File // nil for missing shards
+	stripe  int64
+	stripes int64
+	pending []byte
+	// degraded is set once a stripe needed the parity shards
+	degraded bool
+}
+
+func (r *erasureReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.stripe >= r.stripes {
+			return 0, io.EOF
+		}
+		if err := r.readStripe(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// readStripe reads the data blocks of the next stripe, and as many parity blocks as it takes to
+// replace the ones that are missing or damaged
+func (r *erasureReader) readStripe() error {
+	e := r.blob
+	offset, blockLen, dataLen := stripeLayout(e, r.stripe)
+	blocks, err := readStripeBlocks(r.files, e.DataShards, offset, blockLen)
+	if err != nil {
+		return fmt.Errorf("stripe %d of blob %s: %w", r.stripe, e.Hash, err)
+	}
+	if slices.ContainsFunc(blocks[:e.DataShards], func(block []byte) bool { return block == nil }) {
+		if !r.degraded {
+			r.degraded = true
+			metrics.ErasureDegradedReadsTotal.Inc()
+			log.Printf("Reconstructing blob %s from its parity shards: some data shards are missing or damaged", e.Hash)
+		}
+		if err := r.codec.ReconstructData(blocks); err != nil {
+			return err
+		}
+	}
+	data := make([]byte, 0, blockLen*int64(e.DataShards))
+	for _, block := range blocks[:e.DataShards] {
+		data = append(data, block...)
+	}
+	r.pending = data[:dataLen]
+	r.stripe++
+	return nil
+}
+
+// readStripeBlocks reads the intact blocks of a stripe in shard order until it has need of them,
+// so the parity shards are only read to replace damaged or missing data blocks; the others are
+// left nil
+func readStripeBlocks(files []*os.File, need int, offset, blockLen int64) ([][]byte, error) {
+	blocks := make([][]byte, len(files))
+	intact := 0
+	for i, file := range files {
+		if intact == need {
+			break
+		}
+		if file == nil {
+			continue
+		}
+		block, err := readBlock(file, offset, blockLen)
+		if err != nil {
+			continue
+		}
+		blocks[i] = block
+		intact++
+	}
+	if intact < need {
+		return nil, fmt.Errorf("%d of %d shards are intact: %w", intact, need, erasure.ErrTooFewShards)
+	}
+	return blocks, nil
+}
+
+func (r *erasureReader) Close() error {
+	for _, file := range r.files {
+		if file != nil {
+			file.Close()
+		}
+	}
+	return nil
+}
+
+// damagedShards returns the shards of a blob that are missing and, when verify is given, those
+// whose checksum verify computes differs from the one recorded when they were written
+func damagedShards(e *database.ErasureBlobRow, paths []string, verify func(path string) (string, error)) []int {
+	var damaged []int
+	for i, path := range paths {
+		if path == "" {
+			damaged = append(damaged, i)
+			continue
+		}
+		if verify == nil {
+			continue
+		}
+		if sum, err := verify(path); err != nil || i >= len(e.Checksums) || sum != e.Checksums[i] {
+			damaged = append(damaged, i)
+		}
+	}
+	return damaged
+}
+
+// shardChecksum returns the SHA-256 of a shard file
+func shardChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	buf := bufferPool.Get().([]byte)
+	defer bufferPool.Put(buf)
+	if _, err := io.CopyBuffer(h, file, buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// rebuildShards recomputes the damaged shards of a blob from the others. A damaged shard file is
+// rewritten in place; a missing shard goes to a preferred drive that holds no other shard of
+// the blob when there is one. It fails with erasure.ErrTooFewShards when fewer than DataShards
+// shards are intact.
+func (s *FileStorage) rebuildShards(e *database.ErasureBlobRow, paths []string, damaged []int, trigger string) error {
+	codec, err := erasure.New(e.DataShards, e.ParityShards)
+	if err != nil {
+		return err
+	}
+	if codec.Shards()-len(damaged) < e.DataShards {
+		return fmt.Errorf("%d of %d shards are damaged: %w", len(damaged), codec.Shards(), erasure.ErrTooFewShards)
+	}
+
+	files := make([]*os.File, codec.Shards())
+	defer func() {
+		for _, file := range files {
+			if file != nil {
+				file.Close()
+			}
+		}
+	}()
+	used := make(map[string]bool)
+	for i, path := range paths {
+		if path == "" || slices.Contains(damaged, i) {
+			continue
+		}
+		used[driveOfShard(path)] = true
+		if files[i], err = os.Open(path); err != nil {
+			return err
+		}
+	}
+
+	placement := s.blobPlacement(e.Hash)
+	if len(placement) == 0 {
+		return fmt.Errorf("no data drive is online")
+	}
+	writers := make(map[int]*shardWriter)
+	defer func() {
+		for _, w := range writers {
+			w.abort()
+		}
+	}()
+	for n, i := range damaged {
+		target := paths[i]
+		if target == "" {
+			drive := placement[(i+n)%len(placement)]
+			for _, candidate := range placement {
+				if !used[candidate] {
+					drive = candidate
+					break
+				}
+			}
+			used[drive] = true
+			target = shardPathOn(drive, e.Hash, i)
+		}
+		if writers[i], err = createShard(target); err != nil {
+			return err
+		}
+	}
+
+	for stripe := int64(0); stripe < stripes(e); stripe++ {
+		offset, blockLen, _ := stripeLayout(e, stripe)
+		blocks, err := readStripeBlocks(files, e.DataShards, offset, blockLen)
+		if err != nil {
+			return fmt.Errorf("stripe %d: %w", stripe, err)
+		}
+		if err := codec.Reconstruct(blocks); err != nil {
+			return err
+		}
+		for i, w := range writers {
+			if err := writeBlock(w, blocks[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, w := range writers {
+		if err := w.commit(); err != nil {
+			return err
+		}
+		delete(writers, i)
+		if i < len(e.Checksums) && w.checksum() != e.Checksums[i] {
+			log.Printf("Rebuilt shard %d of blob %s does not match its recorded checksum", i, e.Hash)
+		}
+		metrics.ErasureShardsRebuiltTotal.WithLabelValues(trigger).Inc()
+	}
+	return nil
+}
+
+// driveOfShard returns the drive a shard is stored on
+func driveOfShard(path string) string {
+	return filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(path))))
+}
+
+// healErasure rebuilds the missing shards of an erasure-coded blob and restores the stubs of
+// its versions for HealDrives
+func (s *FileStorage) healErasure(report *HealReport, e *database.ErasureBlobRow, rows []*database.ObjectRow, fail func(string, ...any)) {
+	paths := s.shardPaths(e)
+	if damaged := damagedShards(e, paths, nil); len(damaged) > 0 {
+		err := s.rebuildShards(e, paths, damaged, "heal")
+		switch {
+		case errors.Is(err, erasure.ErrTooFewShards):
+			report.Lost = append(report.Lost, e.Hash)
+			metrics.DriveHealBlobsTotal.WithLabelValues("lost").Inc()
+			return
+		case err != nil:
+			fail("rebuilding the shards of blob %s: %v", e.Hash, err)
+		default:
+			report.Rebuilt += len(damaged)
+		}
+	}
+
+	for _, row := range rows {
+		path := s.rowPath(row)
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			continue
+		}
+		if err := writeStub(path); err != nil {
+			fail("restoring %s/%s (version %s): %v", row.Bucket, row.Key, row.VersionID, err)
+			continue
+		}
+		report.Relinked++
+	}
+}
+
+// formatShards lists shard indexes for messages
+func formatShards(shards []int) string {
+	names := make([]string, len(shards))
+	for i, shard := range shards {
+		names[i] = strconv.Itoa(shard)
+	}
+	return strings.Join(names, ", ")
+}
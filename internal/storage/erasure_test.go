@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErasureCoding(t *testing.T) {
+	var disks []string
+	for i := 1; i <= 5; i++ {
+		disks = append(disks, t.TempDir())
+	}
+	store := newTestStorage(t, Options{Drives: disks})
+	db := store.DB
+	store.CreateBucket("media")
+	store.CreateBucket("plain")
+	if err := store.SetBucketErasure("media", 6, 2); !errors.Is(err, ErrErasureLayout) {
+		t.Fatalf("6+2 on six drives = %v", err)
+	}
+	if err := store.SetBucketErasure("media", 4, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(bucket, key string) ([]byte, error) {
+		t.Helper()
+		reader, _, err := store.GetObject(bucket, key, "")
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	put := func(bucket, key string, content []byte) string {
+		t.Helper()
+		if _, err := store.PutObject(bucket, key, bytes.NewReader(content), ""); err != nil {
+			t.Fatal(err)
+		}
+		row, _ := db.GetObject(bucket, key, "simple")
+		return *row.ContentHash
+	}
+
+	// A video spanning several stripes is split into six shards on six drives, with no CAS copy
+	video := make([]byte, 5*erasureBlockSize+12345)
+	rand.New(rand.NewSource(1)).Read(video)
+	hash := put("media", "clip.mp4", video)
+	e, _ := db.GetErasureBlob(hash)
+	if e == nil || e.DataShards != 4 || e.ParityShards != 2 || len(e.Checksums) != 6 {
+		t.Fatalf("erasure blob = %+v", e)
+	}
+	paths := store.shardPaths(e)
+	drives := make(map[string]bool)
+	for _, path := range paths {
+		drives[driveOfShard(path)] = true
+	}
+	if len(drives) != 6 || len(store.blobCopies(hash)) != 0 {
+		t.Fatalf("shards = %v, CAS copies = %v", paths, store.blobCopies(hash))
+	}
+	if got, err := read("media", "clip.mp4"); err != nil || !bytes.Equal(got, video) {
+		t.Fatalf("clip.mp4 read back as %d bytes, %v", len(got), err)
+	}
+
+	// A lost and a rotten shard are reconstructed on the fly
+	os.Remove(paths[0])
+	shard, _ := os.ReadFile(paths[2])
+	shard[len(shard)/2] ^= 0xff
+	os.WriteFile(paths[2], shard, 0644)
+	if got, err := read("media", "clip.mp4"); err != nil || !bytes.Equal(got, video) {
+		t.Fatalf("clip.mp4 with two damaged shards read back as %d bytes, %v", len(got), err)
+	}
+
+	// The heal rebuilds the lost shard, fsck finds the rotten one and rebuilds it
+	report, err := store.HealDrives("test")
+	if err != nil || report.Rebuilt != 1 || len(report.Lost) != 0 {
+		t.Fatalf("heal = %+v, %v", report, err)
+	}
+	check, err := store.Fsck(FsckOptions{Repair: true})
+	if err != nil || len(check.Issues) != 1 || check.Issues[0].Kind != FsckDamagedShards || !check.Issues[0].Repaired {
+		t.Fatalf("fsck = %+v, %v", check, err)
+	}
+	for i, path := range store.shardPaths(e) {
+		if sum, err := shardChecksum(path); err != nil || sum != e.Checksums[i] {
+			t.Errorf("shard %d after the repair: %s, %v", i, sum, err)
+		}
+	}
+
+	// The scrubber rebuilds a shard that rots later
+	paths = store.shardPaths(e)
+	os.WriteFile(paths[5], []byte("rotten"), 0644)
+	scrubber := NewScrubber(store, 0, 0)
+	scrubber.Scrub()
+	if status := scrubber.Status(); status.Blobs != 1 || status.Corrupted != 1 || status.Restored != 1 {
+		t.Fatalf("scrub status = %+v", status)
+	}
+	if sum, _ := shardChecksum(paths[5]); sum != e.Checksums[5] {
+		t.Errorf("shard 5 after the scrub = %s", sum)
+	}
+
+	// Content stored mirrored stays mirrored, and the other way round
+	hello := put("plain", "hello.txt", []byte("hello"))
+	put("media", "hello.txt", []byte("hello"))
+	if e, _ := db.GetErasureBlob(hello); e != nil || len(store.blobCopies(hello)) == 0 {
+		t.Errorf("mirrored content was erasure-coded again: %+v", e)
+	}
+	put("plain", "copy.mp4", video)
+	if got, err := read("plain", "copy.mp4"); err != nil || !bytes.Equal(got, video) || len(store.blobCopies(hash)) != 0 {
+		t.Fatalf("copy.mp4 read back as %d bytes, %v", len(got), err)
+	}
+
+	// Beyond the parity the content is gone
+	for _, path := range store.shardPaths(e)[:3] {
+		os.Remove(path)
+	}
+	if _, err := read("media", "clip.mp4"); err == nil || !strings.Contains(err.Error(), "shards") {
+		t.Errorf("clip.mp4 with three lost shards = %v", err)
+	}
+
+	// Deleting the last version removes the shards
+	store.DeleteObject("media", "clip.mp4", "", false)
+	store.DeleteObject("plain", "copy.mp4", "", false)
+	if e, _ := db.GetErasureBlob(hash); e != nil {
+		t.Errorf("erasure blob after the delete = %+v", e)
+	}
+	if _, err := os.Stat(filepath.Dir(paths[3])); !os.IsNotExist(err) {
+		t.Errorf("shards after the delete: %v", err)
+	}
+}
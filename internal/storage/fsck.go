@@ -0,0 +1,822 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+// Kinds of problems reported by Fsck
+const (
+	FsckMissingFile   = "missing_file"   // A version row whose file is gone
+	FsckMissingBlob   = "missing_blob"   // A version row whose CAS blob is gone
+	FsckOrphanBlob    = "orphan_blob"    // A CAS blob no version references
+	FsckNotLinked     = "not_linked"     // An object file that is a copy rather than a hard link of its CAS blob
+	FsckLatestPointer = "latest_pointer" // A latest file that disagrees with is_latest
+	FsckHashMismatch  = "hash_mismatch"  // Content that does not hash to its content_hash
+	FsckStaleUpload   = "stale_upload"   // A multipart upload nobody touched for StaleUploadAge
+	FsckCorrupted     = "corrupted"      // A version whose blob the scrubber quarantined
+	FsckDamagedShards = "damaged_shards" // An erasure-coded blob with missing or damaged shards
+	FsckUnknown       = "unknown"        // A version whose blob may be on an offline drive
+)
+
+// DefaultStaleUploadAge is how long a multipart upload may sit idle before fsck treats it as abandoned
+const DefaultStaleUploadAge = 7 * 24 * time.Hour
+
+// fsckGracePeriod keeps fsck away from blobs of uploads that are still being written on a
+// running server: they exist shortly before their database row does
+const fsckGracePeriod = time.Hour
+
+// ErrFsckRunning is returned when a consistency check is started while another one runs
+var ErrFsckRunning = errors.New("a consistency check is already running")
+
+// ErrDrivesOffline is returned for a repair while data drives are offline: blobs on them would
+// look lost and the versions pointing at them would be deleted
+var ErrDrivesOffline = errors.New("data drives are offline")
+
+var fsckRunning atomic.Bool
+
+// FsckOptions selects what Fsck does
+type FsckOptions struct {
+	// Repair fixes the problems found; otherwise they are only reported
+	Repair bool
+	// Quick skips reading every object to verify its content hash
+	Quick bool
+	// StaleUploadAge is the idle time after which a multipart upload is stale; zero selects
+	// DefaultStaleUploadAge
+	StaleUploadAge time.Duration
+}
+
+// FsckIssue is one inconsistency between the metadata database and the data directory. In
+// repair mode Action says what was done, or Error why the issue could not be repaired.
+type FsckIssue struct {
+	Kind      string `json:"kind"`
+	Bucket    string `json:"bucket,omitempty"`
+	Key       string `json:"key,omitempty"`
+	VersionID string `json:"version_id,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+	Repaired  bool   `json:"repaired"`
+	Action    string `json:"action,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// FsckReport is the result of Fsck
+type FsckReport struct {
+	Repair    bool           `json:"repair"`
+	Quick     bool           `json:"quick"`
+	StartedAt time.Time      `json:"started_at"`
+	Duration  string         `json:"duration"`
+	Objects   int            `json:"objects"`
+	Blobs     int            `json:"blobs"`
+	Uploads   int            `json:"uploads"`
+	Summary   map[string]int `json:"summary"`
+	Repaired  int            `json:"repaired"`
+	Issues    []FsckIssue    `json:"issues"`
+}
+
+// Unrepaired returns the number of issues that are still there
+func (r *FsckReport) Unrepaired() int {
+	return len(r.Issues) - r.Repaired
+}
+
+// fsck is the state of one consistency check
+type fsck struct {
+	s      *FileStorage
+	opts   FsckOptions
+	report *FsckReport
+
+	referenced map[string]bool  // Hashes the version rows point at
+	verified   map[string]error // Verification result of blobs, by hash
+	deleted    map[int64]bool   // Rows removed by repairs
+	dirty      map[string]bool  // Buckets whose listings changed
+	offline    bool             // Some data drive is offline
+}
+
+// Fsck checks the object versions in the database against the files, CAS blobs, latest
+// pointers and multipart uploads on disk, and repairs what it finds if opts.Repair is set.
+// Versions whose blob may be on an offline drive are reported as unknown, and repairs are
+// refused until every drive is back.
+func (s *FileStorage) Fsck(opts FsckOptions) (*FsckReport, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	var offline []string
+	if s.Drives != nil {
+		offline = s.Drives.Offline()
+	}
+	if opts.Repair && len(offline) > 0 {
+		return nil, fmt.Errorf("%w: %s; repair once they are back", ErrDrivesOffline, strings.Join(offline, ", "))
+	}
+	if !fsckRunning.CompareAndSwap(false, true) {
+		return nil, ErrFsckRunning
+	}
+	defer fsckRunning.Store(false)
+
+	if opts.StaleUploadAge <= 0 {
+		opts.StaleUploadAge = DefaultStaleUploadAge
+	}
+	f := &fsck{
+		s:    s,
+		opts: opts,
+		report: &FsckReport{
+			Repair:    opts.Repair,
+			Quick:     opts.Quick,
+			StartedAt: time.Now().UTC(),
+			Summary:   map[string]int{},
+			Issues:    []FsckIssue{},
+		},
+		referenced: make(map[string]bool),
+		verified:   make(map[string]error),
+		deleted:    make(map[int64]bool),
+		dirty:      make(map[string]bool),
+		offline:    len(offline) > 0,
+	}
+
+	rows, err := s.DB.ListAllObjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if err := f.checkObject(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.checkLatestPointers(rows); err != nil {
+		return nil, err
+	}
+	if err := f.checkBlobs(); err != nil {
+		return nil, err
+	}
+	if err := f.checkUploads(); err != nil {
+		return nil, err
+	}
+
+	for bucket := range f.dirty {
+		if s.Cache != nil {
+			s.Cache.DeleteByPrefix("objects:" + bucket + ":")
+		}
+		s.Metrics.MarkDirty(bucket)
+	}
+	f.report.Duration = time.Since(f.report.StartedAt).Round(time.Millisecond).String()
+	if len(f.report.Issues) > 0 {
+		log.Printf("Consistency check found %d issues, repaired %d", len(f.report.Issues), f.report.Repaired)
+	}
+	return f.report, nil
+}
+
+// add records an issue and, in repair mode, runs its repair. A nil repair marks an issue
+// that cannot be repaired automatically.
+func (f *fsck) add(issue FsckIssue, repair func() (string, error)) {
+	f.report.Summary[issue.Kind]++
+	if f.opts.Repair && repair != nil {
+		if action, err := repair(); err != nil {
+			issue.Error = err.Error()
+		} else {
+			issue.Repaired = true
+			issue.Action = action
+			f.report.Repaired++
+			if issue.Bucket != "" {
+				f.dirty[issue.Bucket] = true
+			}
+		}
+	}
+	f.report.Issues = append(f.report.Issues, issue)
+}
+
+// checkObject checks the file, the CAS blob and the content of a version row
+func (f *fsck) checkObject(row *database.ObjectRow) error {
+	// Folders have no file and archived versions live in the cold tier
+	if row.VersionID == "folder" || isArchivedRow(row) {
+		return nil
+	}
+	f.report.Objects++
+
+	s := f.s
+	path := s.rowPath(row)
+	hash := ""
+	if row.ContentHash != nil {
+		hash = *row.ContentHash
+	}
+	// The file still holds the damaged content; restoring the blob from it would undo the quarantine
+	if row.CorruptedAt != nil {
+		f.referenced[hash] = true
+		f.add(rowIssue(FsckCorrupted, row, path, "quarantined by the scrubber until an intact copy is uploaded"), nil)
+		return nil
+	}
+	fileInfo, fileErr := os.Stat(path)
+	if fileErr != nil && !os.IsNotExist(fileErr) {
+		return fileErr
+	}
+
+	if hash == "" || !isCASBlobName(hash) {
+		if fileErr != nil {
+			f.add(rowIssue(FsckMissingFile, row, path, "the version has no CAS blob to restore it from"), f.deleteRow(row))
+		}
+		return nil
+	}
+	if e := s.erasureBlob(hash); e != nil {
+		f.checkErasureObject(row, path, fileErr == nil, e)
+		return nil
+	}
+	if s.inBlobStore(hash) {
+		f.checkStoredObject(row, path, fileErr == nil)
+		return nil
+	}
+	// Placement only ranks the online drives; a blob short of copies may have the rest offline
+	if f.offline && len(s.blobCopies(hash)) < s.copyFactor() {
+		f.referenced[hash] = true
+		f.add(rowIssue(FsckUnknown, row, path, "copies of the blob may be on an offline drive"), nil)
+		return nil
+	}
+	blob := s.casPath(hash)
+	_, blobErr := os.Stat(blob)
+	if blobErr != nil && !os.IsNotExist(blobErr) {
+		return blobErr
+	}
+
+	if fileErr != nil && blobErr != nil {
+		f.referenced[hash] = true
+		f.add(rowIssue(FsckMissingFile, row, path, "the CAS blob is missing too"), f.deleteRow(row))
+		return nil
+	}
+	linked := fileErr == nil && blobErr == nil && s.linksBlob(fileInfo, hash)
+
+	// Verify what GetObject would serve before trusting it to restore the other copy
+	if !f.opts.Quick {
+		content := path
+		if fileErr != nil {
+			content = blob
+		}
+		actual, intact, err := f.verify(row, content, linked)
+		if err != nil || actual != hash {
+			f.referenced[hash] = true
+			f.addHashMismatch(row, content, actual, intact, err)
+			return nil
+		}
+	}
+	f.referenced[hash] = true
+
+	switch {
+	case fileErr != nil:
+		f.add(rowIssue(FsckMissingFile, row, path, ""), func() (string, error) {
+			return "restored the file from its CAS blob", replaceWithLink(blob, path)
+		})
+	case blobErr != nil:
+		f.add(rowIssue(FsckMissingBlob, row, blob, ""), func() (string, error) {
+			_, err := s.replicateBlob(hash, path)
+			return "restored the CAS blob from the file", err
+		})
+	case !linked:
+		f.add(rowIssue(FsckNotLinked, row, path, ""), func() (string, error) {
+			same, err := sameContent(path, blob)
+			if err != nil {
+				return "", err
+			}
+			if !same {
+				return "", fmt.Errorf("the file differs from its CAS blob")
+			}
+			return "replaced the copy with a hard link of the CAS blob", replaceWithLink(blob, path)
+		})
+	}
+	return nil
+}
+
+// checkErasureObject checks the stub of a version of an erasure-coded blob and, once per blob,
+// its shards
+func (f *fsck) checkErasureObject(row *database.ObjectRow, path string, hasFile bool, e *database.ErasureBlobRow) {
+	f.referenced[e.Hash] = true
+	if _, done := f.verified[e.Hash]; !done {
+		f.verified[e.Hash] = nil
+		f.checkShards(row, e)
+	}
+	if !hasFile {
+		f.add(rowIssue(FsckMissingFile, row, path, "the stub of an erasure-coded version"), func() (string, error) {
+			return "restored the stub of the erasure-coded version", writeStub(path)
+		})
+	}
+}
+
+// checkStoredObject checks the stub of a version of a blob in the blob store and, once per blob,
+// that the store holds the blob and, unless Quick, that its content matches the hash. Blobs
+// missing from the store are not repaired: a misconfigured store must not cost the records.
+func (f *fsck) checkStoredObject(row *database.ObjectRow, path string, hasFile bool) {
+	hash := *row.ContentHash
+	f.referenced[hash] = true
+	err, done := f.verified[hash]
+	if !done {
+		err = f.verifyStored(row)
+		f.verified[hash] = err
+		if err != nil && !errors.Is(err, ErrBlobNotFound) {
+			f.add(rowIssue(FsckHashMismatch, row, "", err.Error()), unrepairable("the stored data is damaged; restore it from a replica or backup"))
+		}
+	}
+	if errors.Is(err, ErrBlobNotFound) {
+		f.add(rowIssue(FsckMissingBlob, row, "", "the blob store does not hold the blob"), unrepairable("restore the blob from a replica or backup"))
+		return
+	}
+	if !hasFile {
+		f.add(rowIssue(FsckMissingFile, row, path, "the stub of a version in the blob store"), func() (string, error) {
+			return "restored the stub of the version", writeStub(path)
+		})
+	}
+}
+
+// verifyStored checks the blob of a version in the blob store: that it is there and, unless
+// Quick, that its content hashes to the content hash
+func (f *fsck) verifyStored(row *database.ObjectRow) error {
+	hash := *row.ContentHash
+	if f.opts.Quick {
+		_, err := f.s.Blobs.Stat(hash)
+		return err
+	}
+	encryption, compression := "", ""
+	if row.EncryptionType != nil {
+		encryption = *row.EncryptionType
+	}
+	if row.CompressionType != nil {
+		compression = *row.CompressionType
+	}
+	reader, err := f.s.Blobs.Get(hash, 0, -1)
+	if err != nil {
+		return err
+	}
+	content, err := decodeStored(reader, encryption, compression)
+	if err != nil {
+		return fmt.Errorf("the content cannot be read: %v", err)
+	}
+	defer content.Close()
+
+	h := sha256.New()
+	buf := bufferPool.Get().([]byte)
+	defer bufferPool.Put(buf)
+	if _, err := io.CopyBuffer(h, content, buf); err != nil {
+		return fmt.Errorf("the content cannot be read: %v", err)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != hash {
+		return fmt.Errorf("the content hashes to %s", actual)
+	}
+	return nil
+}
+
+// checkShards checks that every shard of an erasure-coded blob exists and, unless Quick, still
+// matches the checksum recorded when it was written
+func (f *fsck) checkShards(row *database.ObjectRow, e *database.ErasureBlobRow) {
+	s := f.s
+	paths := s.shardPaths(e)
+	var verify func(string) (string, error)
+	if !f.opts.Quick {
+		verify = shardChecksum
+	}
+	damaged := damagedShards(e, paths, verify)
+	if len(damaged) == 0 {
+		return
+	}
+	issue := rowIssue(FsckDamagedShards, row, paths[damaged[0]],
+		fmt.Sprintf("shards %s of %d+%d are missing or damaged", formatShards(damaged), e.DataShards, e.ParityShards))
+	if len(damaged) > e.ParityShards {
+		issue.Detail += "; too few are left to rebuild them"
+		f.add(issue, unrepairable("the stored data is damaged; restore it from a replica or backup"))
+		return
+	}
+	f.add(issue, func() (string, error) {
+		if err := s.rebuildShards(e, paths, damaged, "fsck"); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("rebuilt %d shards from the others", len(damaged)), nil
+	})
+}
+
+// verify returns the SHA-256 of the content of a version, and whether its encryption or
+// compression vouched that the stored bytes are intact. Blobs are verified once.
+func (f *fsck) verify(row *database.ObjectRow, path string, isBlob bool) (actual string, intact bool, err error) {
+	encryption, compression := "", ""
+	if row.EncryptionType != nil {
+		encryption = *row.EncryptionType
+	}
+	if row.CompressionType != nil {
+		compression = *row.CompressionType
+	}
+	intact = encryption == "AES256" || compression == "gzip"
+	if isBlob {
+		if err, ok := f.verified[*row.ContentHash]; ok {
+			return *row.ContentHash, intact, err
+		}
+		defer func() {
+			if actual == *row.ContentHash {
+				f.verified[actual] = err
+			}
+		}()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	content, err := decodeStored(file, encryption, compression)
+	if err != nil {
+		return "", false, err
+	}
+	defer content.Close()
+
+	h := sha256.New()
+	buf := bufferPool.Get().([]byte)
+	defer bufferPool.Put(buf)
+	if _, err := io.CopyBuffer(h, content, buf); err != nil {
+		return "", false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), intact, nil
+}
+
+// addHashMismatch reports content that does not match its hash. When GCM authentication or
+// the gzip checksum vouch for the stored bytes, the hash in the database is what is wrong and
+// the version is moved to the blob of its real hash. Otherwise the data itself is damaged.
+func (f *fsck) addHashMismatch(row *database.ObjectRow, path, actual string, intact bool, err error) {
+	issue := rowIssue(FsckHashMismatch, row, path, "")
+	if err != nil {
+		issue.Detail = fmt.Sprintf("the content cannot be read: %v", err)
+		f.add(issue, unrepairable("the stored data is damaged; restore it from a replica or backup"))
+		return
+	}
+	issue.Detail = "the content hashes to " + actual
+	if !intact {
+		f.add(issue, unrepairable("the data is stored without a checksum, so it cannot tell whether the data or the hash is wrong; restore it from a replica or backup"))
+		return
+	}
+
+	s := f.s
+	f.referenced[actual] = true
+	f.add(issue, func() (string, error) {
+		blob := s.casPath(actual)
+		if _, err := os.Stat(blob); os.IsNotExist(err) {
+			if _, err := s.replicateBlob(actual, path); err != nil {
+				return "", err
+			}
+			blob = s.casPath(actual)
+		} else if same, err := sameContent(path, blob); err != nil {
+			return "", err
+		} else if !same {
+			return "", fmt.Errorf("the CAS blob %s holds different bytes", actual)
+		}
+		if target := s.rowPath(row); target != path || !sameFile(target, blob) {
+			if err := replaceWithLink(blob, target); err != nil {
+				return "", err
+			}
+		}
+		if err := s.DB.SetObjectContentHash(row.Bucket, row.Key, row.VersionID, actual); err != nil {
+			return "", err
+		}
+		s.cleanOrphanedCAS(*row.ContentHash)
+		return "updated the content hash to match the intact data", nil
+	})
+}
+
+func unrepairable(reason string) func() (string, error) {
+	return func() (string, error) { return "", errors.New(reason) }
+}
+
+// deleteRow returns the repair that removes the row of a version whose data is gone
+func (f *fsck) deleteRow(row *database.ObjectRow) func() (string, error) {
+	return func() (string, error) {
+		if err := f.s.DB.DeleteObject(row.Bucket, row.Key, row.VersionID); err != nil {
+			return "", err
+		}
+		f.deleted[row.ID] = true
+		return "deleted the record of the lost version", nil
+	}
+}
+
+// checkLatestPointers compares the latest file of every versioned object with the version the
+// database marks as latest
+func (f *fsck) checkLatestPointers(rows []*database.ObjectRow) error {
+	type object struct{ bucket, key string }
+	versions := make(map[object][]*database.ObjectRow)
+	var order []object
+	for _, row := range rows {
+		if row.DeletedAt != nil || f.deleted[row.ID] || row.VersionID == "simple" || row.VersionID == "folder" || row.VersionID == "legacy" {
+			continue
+		}
+		o := object{row.Bucket, row.Key}
+		if _, ok := versions[o]; !ok {
+			order = append(order, o)
+		}
+		versions[o] = append(versions[o], row)
+	}
+
+	for _, o := range order {
+		pointerPath := filepath.Join(f.s.Root, o.bucket, o.key, "latest")
+		data, err := os.ReadFile(pointerPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		pointer := strings.TrimSpace(string(data))
+
+		var latest []*database.ObjectRow
+		var named, newest *database.ObjectRow
+		for _, row := range versions[o] {
+			if row.VersionID == pointer {
+				named = row
+			}
+			if row.IsLatest {
+				latest = append(latest, row)
+				if newest == nil || row.ModifiedAt.After(newest.ModifiedAt) {
+					newest = row
+				}
+			}
+		}
+
+		// The version the object should resolve to: the one marked latest, the one named by the
+		// pointer when several or none are marked
+		want := newest
+		switch {
+		case len(latest) == 1 && pointer == latest[0].VersionID:
+			continue
+		case len(latest) == 0 && named == nil:
+			continue // The current version was deleted
+		case len(latest) == 0 || (named != nil && named.IsLatest):
+			want = named
+		}
+
+		detail := fmt.Sprintf("latest names %q but %d versions are marked latest", pointer, len(latest))
+		if len(latest) == 1 {
+			detail = fmt.Sprintf("latest names %q but the database marks %q", pointer, latest[0].VersionID)
+		}
+		issue := FsckIssue{Kind: FsckLatestPointer, Bucket: o.bucket, Key: o.key, VersionID: want.VersionID, Path: pointerPath, Detail: detail}
+		f.add(issue, func() (string, error) {
+			for _, row := range latest {
+				if row != want {
+					if err := f.s.DB.UpdateObjectLatest(o.bucket, o.key, row.VersionID, false); err != nil {
+						return "", err
+					}
+				}
+			}
+			if !want.IsLatest {
+				if err := f.s.DB.UpdateObjectLatest(o.bucket, o.key, want.VersionID, true); err != nil {
+					return "", err
+				}
+			}
+			if err := os.WriteFile(pointerPath, []byte(want.VersionID), 0644); err != nil {
+				return "", err
+			}
+			return "made " + want.VersionID + " the latest version", nil
+		})
+	}
+	return nil
+}
+
+// checkBlobs looks for CAS blobs no version references, on every drive, and for erasure-coded
+// blobs and blobs in the blob store no version references
+func (f *fsck) checkBlobs() error {
+	for _, casRoot := range f.s.casRoots() {
+		if err := f.checkBlobsIn(casRoot); err != nil {
+			return err
+		}
+	}
+	if err := f.checkErasureBlobs(); err != nil {
+		return err
+	}
+	return f.checkStoredBlobs()
+}
+
+func (f *fsck) checkStoredBlobs() error {
+	store := f.s.Blobs
+	if store == nil {
+		return nil
+	}
+	cutoff := time.Now().Add(-fsckGracePeriod)
+	after := ""
+	for {
+		blobs, err := store.List(after, blobListPage)
+		if err != nil {
+			return err
+		}
+		for _, blob := range blobs {
+			f.report.Blobs++
+			if f.referenced[blob.Address] || blob.ModTime.After(cutoff) {
+				continue
+			}
+			if count, err := f.s.DB.CountObjectHashReferences(blob.Address); err != nil {
+				return err
+			} else if count > 0 {
+				continue
+			}
+			address := blob.Address
+			f.add(FsckIssue{Kind: FsckOrphanBlob, Hash: address, Detail: fmt.Sprintf("%d bytes in the blob store", blob.Size)}, func() (string, error) {
+				return "removed the blob", store.Delete(address)
+			})
+		}
+		if len(blobs) < blobListPage {
+			return nil
+		}
+		after = blobs[len(blobs)-1].Address
+	}
+}
+
+func (f *fsck) checkErasureBlobs() error {
+	blobs, err := f.s.DB.ListErasureBlobs()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-fsckGracePeriod)
+	for _, e := range blobs {
+		f.report.Blobs++
+		if f.referenced[e.Hash] || e.CreatedAt.After(cutoff) {
+			continue
+		}
+		if count, err := f.s.DB.CountObjectHashReferences(e.Hash); err != nil {
+			return err
+		} else if count > 0 {
+			continue
+		}
+		hash := e.Hash
+		detail := fmt.Sprintf("%d bytes in %d+%d erasure shards", e.Size, e.DataShards, e.ParityShards)
+		f.add(FsckIssue{Kind: FsckOrphanBlob, Hash: hash, Detail: detail}, func() (string, error) {
+			f.s.removeErasure(hash)
+			return "removed the shards", nil
+		})
+	}
+	return nil
+}
+
+func (f *fsck) checkBlobsIn(casRoot string) error {
+	cutoff := time.Now().Add(-fsckGracePeriod)
+	return filepath.WalkDir(casRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == casRoot {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !isCASBlobName(d.Name()) {
+			return nil
+		}
+		f.report.Blobs++
+		if f.referenced[d.Name()] {
+			return nil
+		}
+		// Blobs of archived versions are removed when they move to the cold tier, but a
+		// referencing row may also have appeared since the rows were listed
+		if count, err := f.s.DB.CountObjectHashReferences(d.Name()); err != nil || count > 0 {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		f.add(FsckIssue{Kind: FsckOrphanBlob, Hash: d.Name(), Path: path, Detail: fmt.Sprintf("%d bytes", info.Size())}, func() (string, error) {
+			if err := os.Remove(path); err != nil {
+				return "", err
+			}
+			os.Remove(filepath.Dir(path)) // Clean parent folder if empty
+			return "removed the blob", nil
+		})
+		return nil
+	})
+}
+
+// checkUploads looks for multipart uploads that saw no part for StaleUploadAge
+func (f *fsck) checkUploads() error {
+	buckets, err := os.ReadDir(f.s.Root)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-f.opts.StaleUploadAge)
+	for _, b := range buckets {
+		if !b.IsDir() || strings.HasPrefix(b.Name(), ".") {
+			continue
+		}
+		uploads, err := os.ReadDir(filepath.Join(f.s.Root, b.Name(), ".uploads"))
+		if err != nil {
+			continue
+		}
+		for _, u := range uploads {
+			if !u.IsDir() {
+				continue
+			}
+			f.report.Uploads++
+			dir := filepath.Join(f.s.Root, b.Name(), ".uploads", u.Name())
+			lastActivity := latestModTime(dir)
+			if lastActivity.After(cutoff) {
+				continue
+			}
+			key, _ := os.ReadFile(filepath.Join(dir, "key"))
+			bucket, uploadID := b.Name(), u.Name()
+			issue := FsckIssue{Kind: FsckStaleUpload, Bucket: bucket, Key: string(key), VersionID: uploadID, Path: dir,
+				Detail: "no activity since " + lastActivity.UTC().Format(time.RFC3339)}
+			f.add(issue, func() (string, error) {
+				return "aborted the upload", f.s.AbortMultipartUpload(bucket, string(key), uploadID)
+			})
+		}
+	}
+	return nil
+}
+
+// rowPath returns where the file of a version row lives, in the bucket or in the trash
+func (s *FileStorage) rowPath(row *database.ObjectRow) string {
+	if row.DeletedAt == nil {
+		return s.objectPath(row.Bucket, row.Key, row.VersionID)
+	}
+	return filepath.Join(s.Root, ".trash", row.Bucket, row.Key, row.VersionID)
+}
+
+func rowIssue(kind string, row *database.ObjectRow, path, detail string) FsckIssue {
+	issue := FsckIssue{Kind: kind, Bucket: row.Bucket, Key: row.Key, VersionID: row.VersionID, Path: path, Detail: detail}
+	if row.ContentHash != nil {
+		issue.Hash = *row.ContentHash
+	}
+	return issue
+}
+
+// linksBlob reports whether an object file is a link of one of the copies of a blob
+func (s *FileStorage) linksBlob(file os.FileInfo, hash string) bool {
+	for _, blob := range s.blobCopies(hash) {
+		if info, err := os.Stat(blob); err == nil && os.SameFile(file, info) {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceWithLink atomically replaces path with a link of target, a hard link unless target is
+// on another drive
+func replaceWithLink(target, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp-fsck"
+	os.Remove(tmp)
+	if err := linkFile(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func sameFile(a, b string) bool {
+	ai, errA := os.Stat(a)
+	bi, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(ai, bi)
+}
+
+// sameContent reports whether two files hold the same bytes
+func sameContent(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA, bufB := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if na != nb || !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// latestModTime returns the newest modification time of a directory and the files in it
+func latestModTime(dir string) time.Time {
+	var latest time.Time
+	if info, err := os.Stat(dir); err == nil {
+		latest = info.ModTime()
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
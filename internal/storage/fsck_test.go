@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFsckRepair(t *testing.T) {
+	store := newTestStorage(t, Options{})
+	db, root := store.DB, store.Root
+
+	store.CreateBucket("plain")
+	store.CreateBucket("versioned")
+	db.SetBucketVersioning("versioned", true)
+	put := func(bucket, key, content string) string {
+		t.Helper()
+		versionID, err := store.PutObject(bucket, key, strings.NewReader(content), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return versionID
+	}
+	hashOf := func(bucket, key, versionID string) string {
+		row, _ := db.GetObject(bucket, key, versionID)
+		return *row.ContentHash
+	}
+	old := time.Now().Add(-30 * 24 * time.Hour)
+
+	// The file of a version is gone but its blob is still there
+	put("plain", "relink.txt", "relink me")
+	os.Remove(filepath.Join(root, "plain", "relink.txt"))
+
+	// Both the file and the blob are gone
+	put("plain", "lost.txt", "lost for good")
+	os.Remove(store.casPath(hashOf("plain", "lost.txt", "simple")))
+	os.Remove(filepath.Join(root, "plain", "lost.txt"))
+
+	// The blob is gone but the file is still there
+	put("plain", "blob.txt", "blob me")
+	os.Remove(store.casPath(hashOf("plain", "blob.txt", "simple")))
+
+	// The file is a copy rather than a hard link of its blob
+	put("plain", "copy.txt", "copy me")
+	copyPath := filepath.Join(root, "plain", "copy.txt")
+	data, _ := os.ReadFile(copyPath)
+	os.Remove(copyPath)
+	os.WriteFile(copyPath, data, 0644)
+
+	// The database has the wrong hash of intact, gzip-compressed data
+	put("plain", "rehash.txt", "rehash me")
+	rehashed := hashOf("plain", "rehash.txt", "simple")
+	db.SetObjectContentHash("plain", "rehash.txt", "simple", strings.Repeat("0", 64))
+
+	// Uncompressed data does not match its hash; nothing vouches for either
+	put("plain", "rotten.bin", "rotten")
+	db.SetObjectContentHash("plain", "rotten.bin", "simple", strings.Repeat("1", 64))
+
+	// The latest pointer names an older version
+	first := put("versioned", "doc.txt", "first")
+	second := put("versioned", "doc.txt", "second")
+	os.WriteFile(filepath.Join(root, "versioned", "doc.txt", "latest"), []byte(first), 0644)
+
+	// A blob no version references and an abandoned multipart upload
+	orphan := filepath.Join(root, ".cas", "ee", strings.Repeat("e", 64))
+	os.MkdirAll(filepath.Dir(orphan), 0755)
+	os.WriteFile(orphan, []byte("leaked"), 0644)
+	os.Chtimes(orphan, old, old)
+	uploadID, _ := store.InitiateMultipartUpload("plain", "big.bin")
+	uploadDir := filepath.Join(root, "plain", ".uploads", uploadID)
+	os.Chtimes(filepath.Join(uploadDir, "key"), old, old)
+	os.Chtimes(uploadDir, old, old)
+	// Recent uploads are left alone
+	store.InitiateMultipartUpload("plain", "active.bin")
+
+	want := map[string]int{
+		FsckMissingFile:   2,
+		FsckMissingBlob:   1,
+		FsckNotLinked:     1,
+		FsckHashMismatch:  2,
+		FsckLatestPointer: 1,
+		FsckOrphanBlob:    1,
+		FsckStaleUpload:   1,
+	}
+	report, err := store.Fsck(FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Summary, want) || report.Repaired != 0 || report.Uploads != 2 {
+		t.Fatalf("dry run summary = %v, repaired %d\n%+v", report.Summary, report.Repaired, report.Issues)
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Fatal("a dry run removed the orphaned blob")
+	}
+
+	report, err = store.Fsck(FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unrepaired() != 1 {
+		t.Fatalf("%d issues left after repair:\n%+v", report.Unrepaired(), report.Issues)
+	}
+	for _, issue := range report.Issues {
+		if !issue.Repaired && (issue.Kind != FsckHashMismatch || issue.Key != "rotten.bin" || issue.Error == "") {
+			t.Errorf("unexpected unrepaired issue %+v", issue)
+		}
+	}
+
+	if row, _ := db.GetObject("plain", "lost.txt", "simple"); row != nil {
+		t.Error("the record of the lost version was kept")
+	}
+	if got := hashOf("plain", "rehash.txt", "simple"); got != rehashed {
+		t.Errorf("rehashed content hash = %s, want %s", got, rehashed)
+	}
+	for _, key := range []string{"relink.txt", "blob.txt", "copy.txt"} {
+		path := filepath.Join(root, "plain", key)
+		if !sameFile(path, store.casPath(hashOf("plain", key, "simple"))) {
+			t.Errorf("%s is not linked to its blob", key)
+		}
+	}
+	if pointer, _ := os.ReadFile(filepath.Join(root, "versioned", "doc.txt", "latest")); string(pointer) != second {
+		t.Errorf("latest = %s, want %s", pointer, second)
+	}
+	if _, err := os.Stat(uploadDir); !os.IsNotExist(err) {
+		t.Error("the stale upload was not aborted")
+	}
+
+	report, err = store.Fsck(FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Key != "rotten.bin" {
+		t.Errorf("issues after repair = %+v", report.Issues)
+	}
+}
+
+func TestFsckWithOfflineDrive(t *testing.T) {
+	disk := t.TempDir()
+	store := newTestStorage(t, Options{Drives: []string{disk}})
+	db := store.DB
+	store.CreateBucket("photos")
+	onDisk := 0
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("img-%02d.jpg", i)
+		if _, err := store.PutObject("photos", key, strings.NewReader("picture "+key), ""); err != nil {
+			t.Fatal(err)
+		}
+		row, _ := db.GetObject("photos", key, "simple")
+		if onDrive(store.casPath(*row.ContentHash), disk) {
+			onDisk++
+		}
+	}
+	if onDisk == 0 || onDisk == 30 {
+		t.Fatalf("%d of 30 blobs on disk2", onDisk)
+	}
+
+	os.Rename(disk, disk+".failed")
+	store.Drives.Check()
+	if _, err := store.Fsck(FsckOptions{Repair: true}); !errors.Is(err, ErrDrivesOffline) {
+		t.Fatalf("repair with an offline drive = %v", err)
+	}
+	report, err := store.Fsck(FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Summary[FsckUnknown] != onDisk || len(report.Issues) != onDisk {
+		t.Fatalf("fsck with an offline drive = %+v, want %d unknown", report.Summary, onDisk)
+	}
+
+	// Nothing was lost once the drive is back
+	os.Rename(disk+".failed", disk)
+	store.Drives.Check()
+	report, err = store.Fsck(FsckOptions{Repair: true})
+	if err != nil || len(report.Issues) != 0 {
+		t.Fatalf("fsck after the drive came back = %+v, %v", report, err)
+	}
+	if rows, _ := db.ListAllObjects(); len(rows) != 30 {
+		t.Errorf("%d of 30 rows left", len(rows))
+	}
+}
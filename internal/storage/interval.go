@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// intervalTicker is the ticker of a periodic worker. The interval can be changed at any time,
+// including while the worker waits on the ticker, so a configuration reload takes effect
+// without restarting the worker.
+type intervalTicker struct {
+	mu       sync.Mutex
+	interval time.Duration
+	ticker   *time.Ticker
+}
+
+func newIntervalTicker(interval time.Duration) *intervalTicker {
+	return &intervalTicker{interval: interval}
+}
+
+// start creates the ticker and returns its channel
+func (t *intervalTicker) start() <-chan time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ticker = time.NewTicker(t.interval)
+	return t.ticker.C
+}
+
+func (t *intervalTicker) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ticker != nil {
+		t.ticker.Stop()
+	}
+}
+
+// set changes the interval and restarts a running ticker. It reports whether the interval changed.
+func (t *intervalTicker) set(interval time.Duration) bool {
+	if interval <= 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if interval == t.interval {
+		return false
+	}
+	t.interval = interval
+	if t.ticker != nil {
+		t.ticker.Reset(interval)
+	}
+	return true
+}
+
+func (t *intervalTicker) get() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.interval
+}
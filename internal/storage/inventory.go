@@ -0,0 +1,417 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+// Inventory report formats and schedules
+const (
+	InventoryFormatCSV   = "CSV"
+	InventoryFormatJSONL = "JSONL"
+
+	InventoryFrequencyDaily  = "Daily"
+	InventoryFrequencyWeekly = "Weekly"
+
+	InventoryVersionsAll     = "All"
+	InventoryVersionsCurrent = "Current"
+)
+
+// inventoryFields is the schema of every report row
+var inventoryFields = []string{
+	"Bucket", "Key", "VersionId", "IsLatest", "Size", "LastModifiedDate", "ETag", "StorageClass",
+	"EncryptionStatus", "ObjectLockMode", "ObjectLockRetainUntilDate", "ObjectLockLegalHoldStatus",
+	"Tags", "IsDeduplicated",
+}
+
+var inventoryIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// InventoryConfiguration describes a scheduled inventory report of a bucket
+type InventoryConfiguration struct {
+	ID                string `json:"id"`
+	Enabled           bool   `json:"enabled"`
+	DestinationBucket string `json:"destination_bucket"`
+	DestinationPrefix string `json:"destination_prefix,omitempty"`
+	Format            string `json:"format"`            // CSV or JSONL
+	Frequency         string `json:"frequency"`         // Daily or Weekly
+	Prefix            string `json:"prefix,omitempty"`  // Only objects under this prefix are listed
+	IncludedVersions  string `json:"included_versions"` // All or Current
+}
+
+// BucketInventory is an inventory configuration with the outcome of its last run
+type BucketInventory struct {
+	InventoryConfiguration
+	Bucket          string     `json:"bucket"`
+	LastRunAt       *time.Time `json:"last_run_at,omitempty"`
+	LastManifestKey *string    `json:"last_manifest_key,omitempty"`
+}
+
+// InventoryManifest lists the data files of one inventory report
+type InventoryManifest struct {
+	SourceBucket      string              `json:"sourceBucket"`
+	DestinationBucket string              `json:"destinationBucket"`
+	Version           string              `json:"version"`
+	CreationTimestamp string              `json:"creationTimestamp"` // Milliseconds since the epoch
+	FileFormat        string              `json:"fileFormat"`
+	FileSchema        string              `json:"fileSchema"`
+	Files             []InventoryDataFile `json:"files"`
+}
+
+type InventoryDataFile struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	MD5Checksum string `json:"MD5checksum"`
+}
+
+func (s *FileStorage) PutBucketInventory(bucket string, config InventoryConfiguration) error {
+	if s.DB == nil {
+		return fmt.Errorf("database not available")
+	}
+	if !inventoryIDPattern.MatchString(config.ID) {
+		return fmt.Errorf("inventory ID must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	if config.Format == "" {
+		config.Format = InventoryFormatCSV
+	}
+	if config.Format != InventoryFormatCSV && config.Format != InventoryFormatJSONL {
+		return fmt.Errorf("inventory format must be %s or %s", InventoryFormatCSV, InventoryFormatJSONL)
+	}
+	if config.Frequency != InventoryFrequencyDaily && config.Frequency != InventoryFrequencyWeekly {
+		return fmt.Errorf("inventory frequency must be %s or %s", InventoryFrequencyDaily, InventoryFrequencyWeekly)
+	}
+	if config.IncludedVersions == "" {
+		config.IncludedVersions = InventoryVersionsCurrent
+	}
+	if config.IncludedVersions != InventoryVersionsAll && config.IncludedVersions != InventoryVersionsCurrent {
+		return fmt.Errorf("included object versions must be %s or %s", InventoryVersionsAll, InventoryVersionsCurrent)
+	}
+	if exists, _ := s.BucketExists(config.DestinationBucket); !exists {
+		return fmt.Errorf("destination bucket %s does not exist", config.DestinationBucket)
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return s.DB.PutBucketInventory(bucket, config.ID, string(data))
+}
+
+// GetBucketInventory returns nil when the bucket has no inventory configuration with the ID
+func (s *FileStorage) GetBucketInventory(bucket, id string) (*BucketInventory, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	row, err := s.DB.GetBucketInventory(bucket, id)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return decodeInventory(row)
+}
+
+func (s *FileStorage) ListBucketInventories(bucket string) ([]*BucketInventory, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	rows, err := s.DB.ListBucketInventories(bucket)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*BucketInventory, 0, len(rows))
+	for i := range rows {
+		inv, err := decodeInventory(&rows[i])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, inv)
+	}
+	return list, nil
+}
+
+func (s *FileStorage) DeleteBucketInventory(bucket, id string) error {
+	if s.DB == nil {
+		return fmt.Errorf("database not available")
+	}
+	return s.DB.DeleteBucketInventory(bucket, id)
+}
+
+// RunBucketInventory queues a report right away, regardless of its schedule
+func (s *FileStorage) RunBucketInventory(bucket, id string) error {
+	inv, err := s.GetBucketInventory(bucket, id)
+	if err != nil {
+		return err
+	}
+	if inv == nil {
+		return fmt.Errorf("inventory configuration %s not found for bucket %s", id, bucket)
+	}
+	return s.queueInventory(bucket, id)
+}
+
+func decodeInventory(row *database.InventoryRow) (*BucketInventory, error) {
+	inv := &BucketInventory{
+		Bucket:          row.Bucket,
+		LastRunAt:       row.LastRunAt,
+		LastManifestKey: row.LastManifestKey,
+	}
+	if err := json.Unmarshal([]byte(row.Config), &inv.InventoryConfiguration); err != nil {
+		return nil, fmt.Errorf("invalid inventory configuration %s of bucket %s: %w", row.ID, row.Bucket, err)
+	}
+	return inv, nil
+}
+
+func (s *FileStorage) queueInventory(bucket, id string) error {
+	job := &InventoryJob{Storage: s, Bucket: bucket, ConfigID: id}
+	if s.Jobs == nil {
+		go func() {
+			if err := job.Execute(); err != nil {
+				log.Printf("Inventory %s of %s failed: %v", id, bucket, err)
+			}
+		}()
+		return nil
+	}
+	_, err := s.Jobs.Enqueue(job)
+	return err
+}
+
+// InventoryWorker queues the inventory reports that are due
+type InventoryWorker struct {
+	store *FileStorage
+}
+
+func NewInventoryWorker(store *FileStorage) *InventoryWorker {
+	return &InventoryWorker{store: store}
+}
+
+func (w *InventoryWorker) Start() {
+	go func() {
+		w.queueDueReports()
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			w.queueDueReports()
+		}
+	}()
+	log.Println("Inventory worker started (Interval: 1 hour)")
+}
+
+func (w *InventoryWorker) queueDueReports() {
+	inventories, err := w.store.ListBucketInventories("")
+	if err != nil {
+		log.Printf("Inventory worker error listing configurations: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, inv := range inventories {
+		if !inv.Enabled {
+			continue
+		}
+		interval := 24 * time.Hour
+		if inv.Frequency == InventoryFrequencyWeekly {
+			interval = 7 * 24 * time.Hour
+		}
+		if inv.LastRunAt != nil && now.Sub(*inv.LastRunAt) < interval {
+			continue
+		}
+		if err := w.store.queueInventory(inv.Bucket, inv.ID); err != nil {
+			log.Printf("Inventory worker failed to queue %s of %s: %v", inv.ID, inv.Bucket, err)
+		}
+	}
+}
+
+// InventoryJob implements jobs.Job for writing one inventory report
+type InventoryJob struct {
+	Storage  *FileStorage `json:"-"`
+	Bucket   string       `json:"bucket"`
+	ConfigID string       `json:"config_id"`
+}
+
+func (j *InventoryJob) Type() string {
+	return JobTypeInventory
+}
+
+func (j *InventoryJob) Name() string {
+	return "Inventory:" + j.Bucket + ":" + j.ConfigID
+}
+
+func (j *InventoryJob) UniqueKey() string {
+	return j.Type() + ":" + j.Bucket + "/" + j.ConfigID
+}
+
+func (j *InventoryJob) Execute() error {
+	s := j.Storage
+	inv, err := s.GetBucketInventory(j.Bucket, j.ConfigID)
+	if err != nil {
+		return err
+	}
+	// Removed since the job was queued
+	if inv == nil {
+		return nil
+	}
+
+	runAt := time.Now().UTC()
+	manifestKey, err := s.writeInventory(j.Bucket, &inv.InventoryConfiguration, runAt)
+	if err != nil {
+		return fmt.Errorf("inventory %s of %s: %w", j.ConfigID, j.Bucket, err)
+	}
+	log.Printf("Inventory %s of %s written to %s/%s", j.ConfigID, j.Bucket, inv.DestinationBucket, manifestKey)
+	return s.DB.SetInventoryLastRun(j.Bucket, j.ConfigID, runAt, manifestKey)
+}
+
+// writeInventory streams the objects table into a data file and writes the manifest describing it.
+// Files are laid out as <prefix><bucket>/<id>/data/<timestamp>.<ext> and
+// <prefix><bucket>/<id>/<timestamp>/manifest.json.
+func (s *FileStorage) writeInventory(bucket string, config *InventoryConfiguration, runAt time.Time) (string, error) {
+	stamp := runAt.Format("2006-01-02T15-04Z")
+	base := config.DestinationPrefix + bucket + "/" + config.ID + "/"
+	ext := ".csv"
+	if config.Format == InventoryFormatJSONL {
+		ext = ".jsonl"
+	}
+	dataKey := base + "data/" + stamp + ext
+	manifestKey := base + stamp + "/manifest.json"
+
+	pr, pw := io.Pipe()
+	hash := md5.New()
+	counter := &countingWriter{w: io.MultiWriter(pw, hash)}
+	go func() {
+		pw.CloseWithError(s.writeInventoryRows(bucket, config, counter))
+	}()
+	_, err := s.PutObject(config.DestinationBucket, dataKey, pr, "")
+	pr.Close()
+	if err != nil {
+		return "", err
+	}
+
+	manifest := InventoryManifest{
+		SourceBucket:      bucket,
+		DestinationBucket: config.DestinationBucket,
+		Version:           "2016-11-30",
+		CreationTimestamp: strconv.FormatInt(runAt.UnixMilli(), 10),
+		FileFormat:        config.Format,
+		FileSchema:        strings.Join(inventoryFields, ", "),
+		Files: []InventoryDataFile{{
+			Key:         dataKey,
+			Size:        counter.n,
+			MD5Checksum: hex.EncodeToString(hash.Sum(nil)),
+		}},
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.PutObject(config.DestinationBucket, manifestKey, strings.NewReader(string(data)), ""); err != nil {
+		return "", err
+	}
+	return manifestKey, nil
+}
+
+func (s *FileStorage) writeInventoryRows(bucket string, config *InventoryConfiguration, w io.Writer) error {
+	allVersions := config.IncludedVersions == InventoryVersionsAll
+
+	if config.Format == InventoryFormatJSONL {
+		enc := json.NewEncoder(w)
+		return s.DB.ForEachObject(bucket, config.Prefix, allVersions, func(obj *database.ObjectRow) error {
+			record := make(map[string]interface{}, len(inventoryFields))
+			for i, value := range s.inventoryRecord(obj) {
+				record[inventoryFields[i]] = value
+			}
+			return enc.Encode(record)
+		})
+	}
+
+	cw := csv.NewWriter(w)
+	err := s.DB.ForEachObject(bucket, config.Prefix, allVersions, func(obj *database.ObjectRow) error {
+		record := s.inventoryRecord(obj)
+		fields := make([]string, len(record))
+		for i, value := range record {
+			switch v := value.(type) {
+			case map[string]string:
+				fields[i] = encodeInventoryTags(v)
+			case string:
+				fields[i] = v
+			default:
+				fields[i] = fmt.Sprint(v)
+			}
+		}
+		// Keys are URL-encoded, as in S3 inventory reports
+		fields[1] = url.QueryEscape(obj.Key)
+		return cw.Write(fields)
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// inventoryRecord returns the values of a report row in the order of inventoryFields
+func (s *FileStorage) inventoryRecord(obj *database.ObjectRow) []interface{} {
+	etag, storageClass, lockMode, retainUntil := "", StorageClassStandard, "", ""
+	if obj.ETag != nil {
+		etag = strings.Trim(*obj.ETag, `"`)
+	}
+	if obj.StorageClass != nil && *obj.StorageClass != "" {
+		storageClass = *obj.StorageClass
+	}
+	encryption := "NOT-SSE"
+	if obj.EncryptionType != nil && *obj.EncryptionType == "AES256" {
+		encryption = "SSE-S3"
+	}
+	if obj.LockMode != nil {
+		lockMode = *obj.LockMode
+	}
+	if obj.RetainUntilDate != nil {
+		retainUntil = obj.RetainUntilDate.UTC().Format(time.RFC3339)
+	}
+	legalHold := "OFF"
+	if obj.LegalHold {
+		legalHold = "ON"
+	}
+	tags, err := s.GetObjectTagging(obj.Bucket, obj.Key, obj.VersionID)
+	if err != nil {
+		tags = map[string]string{}
+	}
+
+	return []interface{}{
+		obj.Bucket, obj.Key, obj.VersionID, obj.IsLatest, obj.Size, obj.ModifiedAt.UTC().Format(time.RFC3339),
+		etag, storageClass, encryption, lockMode, retainUntil, legalHold, tags, obj.IsDeduplicated,
+	}
+}
+
+// encodeInventoryTags writes tags as a URL query string with sorted keys
+func encodeInventoryTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = url.QueryEscape(k) + "=" + url.QueryEscape(tags[k])
+	}
+	return strings.Join(parts, "&")
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
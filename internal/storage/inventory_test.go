@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestInventoryReports(t *testing.T) {
+	store := newTestStorage(t, Options{})
+	store.CreateBucket("photos")
+	store.CreateBucket("reports")
+	store.SetBucketVersioning("photos", true)
+
+	// The underscore in the filter prefix must not match any character
+	for _, key := range []string{"a_b/1.txt", "a_b/1.txt", "a_b/with space.txt", "aXb/2.txt"} {
+		if _, err := store.PutObject("photos", key, strings.NewReader("content of "+key), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.PutObjectTagging("photos", "a_b/with space.txt", "", map[string]string{"team": "a&b", "env": "prod"}); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(key string) []byte {
+		t.Helper()
+		reader, _, err := store.GetObject("reports", key, "")
+		if err != nil {
+			t.Fatalf("read %s: %v", key, err)
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	// run writes a report and returns its manifest and the content of its single data file
+	run := func(config InventoryConfiguration) (InventoryManifest, []byte) {
+		t.Helper()
+		if err := store.PutBucketInventory("photos", config); err != nil {
+			t.Fatal(err)
+		}
+		job := &InventoryJob{Storage: store, Bucket: "photos", ConfigID: config.ID}
+		if err := job.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		inv, err := store.GetBucketInventory("photos", config.ID)
+		if err != nil || inv.LastRunAt == nil || inv.LastManifestKey == nil {
+			t.Fatalf("inventory after a run = %+v, %v", inv, err)
+		}
+		if !strings.HasPrefix(*inv.LastManifestKey, "inv/photos/"+config.ID+"/") || !strings.HasSuffix(*inv.LastManifestKey, "/manifest.json") {
+			t.Errorf("manifest key = %s", *inv.LastManifestKey)
+		}
+		var manifest InventoryManifest
+		if err := json.Unmarshal(read(*inv.LastManifestKey), &manifest); err != nil {
+			t.Fatal(err)
+		}
+		if manifest.SourceBucket != "photos" || manifest.DestinationBucket != "reports" || manifest.FileFormat != config.Format || len(manifest.Files) != 1 {
+			t.Fatalf("manifest = %+v", manifest)
+		}
+		data := read(manifest.Files[0].Key)
+		sum := md5.Sum(data)
+		if manifest.Files[0].Size != int64(len(data)) || manifest.Files[0].MD5Checksum != hex.EncodeToString(sum[:]) {
+			t.Errorf("manifest file %+v does not describe %d bytes with MD5 %x", manifest.Files[0], len(data), sum)
+		}
+		return manifest, data
+	}
+
+	manifest, data := run(InventoryConfiguration{
+		ID: "all", Enabled: true, DestinationBucket: "reports", DestinationPrefix: "inv/",
+		Format: InventoryFormatCSV, Frequency: InventoryFrequencyDaily, Prefix: "a_b/", IncludedVersions: InventoryVersionsAll,
+	})
+	if !strings.HasSuffix(manifest.Files[0].Key, ".csv") || !strings.HasPrefix(manifest.Files[0].Key, "inv/photos/all/data/") {
+		t.Errorf("data file key = %s", manifest.Files[0].Key)
+	}
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both versions of 1.txt, then the tagged object; aXb/ is not under the prefix
+	if len(rows) != 3 {
+		t.Fatalf("CSV rows = %q", rows)
+	}
+	if rows[0][1] != "a_b%2F1.txt" || rows[1][1] != "a_b%2F1.txt" || rows[0][3] == rows[1][3] || rows[0][2] == rows[1][2] {
+		t.Errorf("version rows = %q", rows[:2])
+	}
+	tagged := rows[2]
+	if len(tagged) != 14 || tagged[0] != "photos" || tagged[1] != "a_b%2Fwith+space.txt" || tagged[4] != "29" ||
+		tagged[7] != StorageClassStandard || tagged[8] != "NOT-SSE" || tagged[11] != "OFF" || tagged[12] != "env=prod&team=a%26b" {
+		t.Errorf("tagged row = %q", tagged)
+	}
+
+	_, data = run(InventoryConfiguration{
+		ID: "current", Enabled: true, DestinationBucket: "reports", DestinationPrefix: "inv/",
+		Format: InventoryFormatJSONL, Frequency: InventoryFrequencyWeekly,
+	})
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 3 {
+		t.Fatalf("JSONL records = %v", records)
+	}
+	for i, key := range []string{"aXb/2.txt", "a_b/1.txt", "a_b/with space.txt"} {
+		if records[i]["Key"] != key || records[i]["IsLatest"] != true || len(records[i]) != len(inventoryFields) {
+			t.Errorf("record %d = %v, want current version of %s", i, records[i], key)
+		}
+	}
+	if tags, _ := records[2]["Tags"].(map[string]interface{}); tags["team"] != "a&b" {
+		t.Errorf("tags = %v", records[2]["Tags"])
+	}
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/GravSpace/GravSpace/internal/jobs"
+	"github.com/GravSpace/GravSpace/internal/metrics"
+)
+
+// Job types run by the background job manager
+const (
+	JobTypeAnalyticsSnapshot   = "analytics_snapshot"
+	JobTypeRestoreObject       = "restore_object"
+	JobTypeReplication         = "replication"
+	JobTypeReplicationBackfill = "replication_backfill"
+	JobTypeLifecycleExpire     = "lifecycle_expire"
+	JobTypeLifecycleTransition = "lifecycle_transition"
+	JobTypeBulkOperation       = "bulk_operation"
+	JobTypeBatchOperation      = "batch_operation"
+	JobTypeInventory           = "inventory"
+	JobTypeDriveHeal           = "drive_heal"
+)
+
+// registerJobs tells the job manager how to rebuild each job type from its stored payload
+func (s *FileStorage) registerJobs() {
+	s.Jobs.Register(JobTypeAnalyticsSnapshot, 3, func() jobs.Job {
+		return &AnalyticsSnapshotJob{DB: s.DB, Storage: s}
+	})
+	s.Jobs.Register(JobTypeRestoreObject, 3, func() jobs.Job {
+		return &RestoreObjectJob{Storage: s}
+	})
+	s.Jobs.Register(JobTypeReplication, replicationMaxAttempts, func() jobs.Job {
+		return &ReplicationJob{Worker: s.ReplicationWorker}
+	})
+	s.Jobs.Register(JobTypeReplicationBackfill, 3, func() jobs.Job {
+		return &ReplicationBackfillJob{Storage: s}
+	})
+	s.Jobs.Register(JobTypeLifecycleExpire, 5, func() jobs.Job {
+		return &LifecycleExpireJob{Storage: s}
+	})
+	s.Jobs.Register(JobTypeLifecycleTransition, 5, func() jobs.Job {
+		return &LifecycleTransitionJob{Storage: s}
+	})
+	// Bulk operations are not idempotent (copies create new versions), so they are not retried automatically
+	s.Jobs.Register(JobTypeBulkOperation, 1, func() jobs.Job {
+		return &BulkOperationJob{Storage: s}
+	})
+	s.Jobs.Register(JobTypeInventory, 3, func() jobs.Job {
+		return &InventoryJob{Storage: s}
+	})
+	s.Jobs.Register(JobTypeDriveHeal, 3, func() jobs.Job {
+		return &DriveHealJob{Storage: s}
+	})
+	// Batch jobs resume from their stored cursor and skip objects that already have a result
+	s.Jobs.Register(JobTypeBatchOperation, 3, func() jobs.Job {
+		return &BatchOperationJob{Storage: s}
+	})
+}
+
+// LifecycleExpireJob implements jobs.Job for permanently deleting a version that reached its expiration
+type LifecycleExpireJob struct {
+	Storage   *FileStorage `json:"-"`
+	Bucket    string       `json:"bucket"`
+	Key       string       `json:"key"`
+	VersionID string       `json:"version_id"`
+}
+
+func (j *LifecycleExpireJob) Type() string {
+	return JobTypeLifecycleExpire
+}
+
+func (j *LifecycleExpireJob) Name() string {
+	return "LifecycleExpire:" + j.Bucket + "/" + j.Key
+}
+
+func (j *LifecycleExpireJob) UniqueKey() string {
+	return j.Type() + ":" + j.Bucket + "/" + j.Key + "/" + j.VersionID
+}
+
+func (j *LifecycleExpireJob) Execute() error {
+	if err := j.Storage.DeleteObject(j.Bucket, j.Key, j.VersionID, true); err != nil {
+		return err
+	}
+	metrics.WorkerDeletionsTotal.WithLabelValues("lifecycle").Inc()
+	return nil
+}
+
+// LifecycleTransitionJob implements jobs.Job for moving a version to the cold tier
+type LifecycleTransitionJob struct {
+	Storage      *FileStorage `json:"-"`
+	Bucket       string       `json:"bucket"`
+	Key          string       `json:"key"`
+	VersionID    string       `json:"version_id"`
+	StorageClass string       `json:"storage_class"`
+}
+
+func (j *LifecycleTransitionJob) Type() string {
+	return JobTypeLifecycleTransition
+}
+
+func (j *LifecycleTransitionJob) Name() string {
+	return "LifecycleTransition:" + j.Bucket + "/" + j.Key
+}
+
+func (j *LifecycleTransitionJob) UniqueKey() string {
+	return j.Type() + ":" + j.Bucket + "/" + j.Key + "/" + j.VersionID
+}
+
+func (j *LifecycleTransitionJob) Execute() error {
+	s := j.Storage
+	row, err := s.DB.GetObject(j.Bucket, j.Key, j.VersionID)
+	if err != nil {
+		return err
+	}
+	// Deleted or already transitioned since the job was queued
+	if row == nil || (row.StorageClass != nil && *row.StorageClass == j.StorageClass) {
+		return nil
+	}
+	if err := s.TransitionObject(row, j.StorageClass); err != nil {
+		return fmt.Errorf("failed to transition %s/%s: %w", j.Bucket, j.Key, err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/GravSpace/GravSpace/internal/cache"
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+// NewOfflineStorage returns a storage for maintenance commands run against a stopped instance.
+// Unlike NewFileStorage it starts no workers, watchers or job pools.
+func NewOfflineStorage(root string, db *database.Database) (*FileStorage, error) {
+	if _, err := os.Stat(root); err != nil {
+		return nil, fmt.Errorf("data directory: %w", err)
+	}
+	return &FileStorage{
+		Root:  root,
+		DB:    db,
+		Cache: cache.NewInMemoryCache(),
+	}, nil
+}
+
+// UseDrives spreads the CAS blobs of an offline storage over the extra data drives of the
+// server, so that maintenance commands see every copy
+func (s *FileStorage) UseDrives(paths []string, copies int, allowUnmounted bool) error {
+	drives, err := NewDriveSet(s, paths, copies, allowUnmounted, 0)
+	if err != nil {
+		return err
+	}
+	s.Drives = drives
+	return nil
+}
+
+// ForceDeleteBucket deletes a bucket with all its objects, versions and trash, and removes the
+// CAS blobs no other object references
+func (s *FileStorage) ForceDeleteBucket(name string) error {
+	if s.DB == nil {
+		return fmt.Errorf("database not available")
+	}
+	hashes, err := s.DB.ListBucketContentHashes(name)
+	if err != nil {
+		return err
+	}
+	if err := s.DB.DeletePrefix(name, ""); err != nil {
+		return err
+	}
+	if err := s.DeleteBucket(name); err != nil {
+		return err
+	}
+	os.RemoveAll(filepath.Join(s.Root, ".trash", name))
+	for _, hash := range hashes {
+		s.cleanOrphanedCAS(hash)
+	}
+	return nil
+}
+
+// CASGCReport is the result of GarbageCollectCAS
+type CASGCReport struct {
+	DryRun     bool     `json:"dry_run"`
+	Scanned    int      `json:"scanned"`
+	Removed    []string `json:"removed"`
+	FreedBytes int64    `json:"freed_bytes"`
+}
+
+// GarbageCollectCAS removes the blobs of the content-addressed store, on every data drive and
+// in the blob store, that no object version references any more, whole copies and erasure
+// shards alike. Object files hard-linked to a removed blob keep their data. With dryRun the
+// blobs are only reported.
+func (s *FileStorage) GarbageCollectCAS(dryRun bool) (*CASGCReport, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	report := &CASGCReport{DryRun: dryRun, Removed: []string{}}
+	removed := make(map[string]bool)
+	for _, casRoot := range s.casRoots() {
+		if err := s.collectCAS(casRoot, report, removed); err != nil {
+			return report, err
+		}
+	}
+	if err := s.collectErasure(report, removed); err != nil {
+		return report, err
+	}
+	return report, s.collectBlobStore(report, removed)
+}
+
+// blobListPage is how many blobs are listed from the blob store at a time
+const blobListPage = 1000
+
+// collectBlobStore removes the unreferenced blobs of the blob store
+func (s *FileStorage) collectBlobStore(report *CASGCReport, removed map[string]bool) error {
+	if s.Blobs == nil {
+		return nil
+	}
+	after := ""
+	for {
+		blobs, err := s.Blobs.List(after, blobListPage)
+		if err != nil {
+			return err
+		}
+		for _, blob := range blobs {
+			report.Scanned++
+			count, err := s.DB.CountObjectHashReferences(blob.Address)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			if !report.DryRun {
+				if err := s.Blobs.Delete(blob.Address); err != nil {
+					return err
+				}
+			}
+			if !removed[blob.Address] {
+				removed[blob.Address] = true
+				report.Removed = append(report.Removed, blob.Address)
+			}
+			report.FreedBytes += blob.Size
+		}
+		if len(blobs) < blobListPage {
+			return nil
+		}
+		after = blobs[len(blobs)-1].Address
+	}
+}
+
+// collectErasure removes the unreferenced erasure-coded blobs
+func (s *FileStorage) collectErasure(report *CASGCReport, removed map[string]bool) error {
+	blobs, err := s.DB.ListErasureBlobs()
+	if err != nil {
+		return err
+	}
+	for _, e := range blobs {
+		report.Scanned++
+		count, err := s.DB.CountObjectHashReferences(e.Hash)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		for _, path := range s.shardPaths(e) {
+			if info, err := os.Stat(path); path != "" && err == nil {
+				report.FreedBytes += info.Size()
+			}
+		}
+		if !report.DryRun {
+			s.removeErasure(e.Hash)
+		}
+		if !removed[e.Hash] {
+			removed[e.Hash] = true
+			report.Removed = append(report.Removed, e.Hash)
+		}
+	}
+	return nil
+}
+
+// collectCAS removes the unreferenced blobs of one drive. A blob with copies on several drives
+// is reported once.
+func (s *FileStorage) collectCAS(casRoot string, report *CASGCReport, removed map[string]bool) error {
+	dryRun := report.DryRun
+	return filepath.WalkDir(casRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == casRoot {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !isCASBlobName(d.Name()) {
+			return nil
+		}
+		report.Scanned++
+
+		count, err := s.DB.CountObjectHashReferences(d.Name())
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			os.Remove(filepath.Dir(path)) // Clean parent folder if empty
+		}
+		if !removed[d.Name()] {
+			removed[d.Name()] = true
+			report.Removed = append(report.Removed, d.Name())
+		}
+		report.FreedBytes += info.Size()
+		return nil
+	})
+}
+
+// isCASBlobName reports whether name is a SHA-256 hex digest, as blobs are named
+func isCASBlobName(name string) bool {
+	if len(name) != 64 {
+		return false
+	}
+	for _, c := range name {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOfflineMaintenance(t *testing.T) {
+	fixture := newTestStorage(t, Options{})
+	db, root := fixture.DB, fixture.Root
+	store, err := NewOfflineStorage(filepath.Join(root, "missing"), db)
+	if err == nil {
+		t.Fatal("expected an error for a missing data directory")
+	}
+	store, err = NewOfflineStorage(root, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.CreateBucket("kept-bucket")
+	store.CreateBucket("doomed-bucket")
+	for _, bucket := range []string{"kept-bucket", "doomed-bucket"} {
+		if _, err := store.PutObject(bucket, "shared.txt", strings.NewReader("shared content"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.PutObject("doomed-bucket", "own.txt", strings.NewReader("own content"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := store.Fsck(FsckOptions{}); err != nil || len(report.Issues) != 0 || report.Objects != 3 || report.Blobs != 2 {
+		t.Fatalf("fsck of a consistent store = %+v, %v", report, err)
+	}
+
+	// Exports round-trip into an empty database
+	var export bytes.Buffer
+	if _, err := db.Export(&export); err != nil {
+		t.Fatal(err)
+	}
+	restored := newTestStorage(t, Options{}).DB
+	imported, _, err := restored.Import(bytes.NewReader(export.Bytes()), false)
+	if err != nil || imported["objects"] != 3 || imported["buckets"] != 2 {
+		t.Fatalf("import = %v, %v", imported, err)
+	}
+	if _, _, err := restored.Import(bytes.NewReader(export.Bytes()), false); err == nil {
+		t.Error("importing twice without replace should fail")
+	}
+	if _, _, err := restored.Import(bytes.NewReader(export.Bytes()), true); err != nil {
+		t.Errorf("import with replace: %v", err)
+	}
+	if pending, err := restored.PendingMigrations(); err != nil || len(pending) != 0 {
+		t.Errorf("pending migrations = %v, %v", pending, err)
+	}
+
+	// Blobs shared with another bucket survive a forced delete
+	if n, _ := db.CountBucketObjects("doomed-bucket"); n != 2 {
+		t.Fatalf("doomed-bucket has %d versions", n)
+	}
+	if err := store.ForceDeleteBucket("doomed-bucket"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := db.CountBucketObjects("doomed-bucket"); n != 0 {
+		t.Errorf("%d versions left after a forced delete", n)
+	}
+	report, err := store.Fsck(FsckOptions{})
+	if err != nil || len(report.Issues) != 0 || report.Blobs != 1 {
+		t.Fatalf("fsck after a forced delete = %+v, %v", report, err)
+	}
+
+	// A blob left behind by a crash is an orphan
+	orphan := strings.Repeat("ab", 32)
+	os.MkdirAll(filepath.Join(root, ".cas", "ab"), 0755)
+	os.WriteFile(filepath.Join(root, ".cas", "ab", orphan), []byte("lost"), 0644)
+	crashed := time.Now().Add(-2 * fsckGracePeriod)
+	os.Chtimes(filepath.Join(root, ".cas", "ab", orphan), crashed, crashed)
+	if report, _ := store.Fsck(FsckOptions{}); len(report.Issues) != 1 || report.Issues[0].Kind != FsckOrphanBlob {
+		t.Errorf("fsck issues = %+v", report.Issues)
+	}
+	gc, err := store.GarbageCollectCAS(true)
+	if err != nil || len(gc.Removed) != 1 || gc.Removed[0] != orphan {
+		t.Fatalf("dry run = %+v, %v", gc, err)
+	}
+	if gc, _ := store.GarbageCollectCAS(false); gc.Scanned != 2 || gc.FreedBytes != 4 {
+		t.Errorf("gc = %+v", gc)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".cas", "ab")); !os.IsNotExist(err) {
+		t.Error("orphaned blob was not removed")
+	}
+}
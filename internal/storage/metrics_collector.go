@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/metrics"
+)
+
+// metricsRefreshInterval is how often buckets touched by writes are recounted and the queue
+// gauges are refreshed
+const metricsRefreshInterval = 15 * time.Second
+
+// MetricsCollector keeps the gauges that describe stored data (buckets, objects and bytes per
+// bucket, CAS usage, multipart uploads, job and webhook queues) in line with the database and
+// the filesystem. Writes mark their bucket dirty and dirty buckets are recounted on the next
+// refresh. A full reconcile at startup and on every interval sets the gauges from scratch, so
+// they are correct after a restart and do not drift when data changes outside the engine.
+type MetricsCollector struct {
+	storage  *FileStorage
+	interval *intervalTicker
+
+	mu      sync.Mutex
+	dirty   map[string]bool
+	buckets map[string]bool // buckets that have object gauges
+
+	quit      chan struct{}
+	waitGroup sync.WaitGroup
+	stopOnce  sync.Once
+}
+
+func NewMetricsCollector(storage *FileStorage, interval time.Duration) *MetricsCollector {
+	return &MetricsCollector{
+		storage:  storage,
+		interval: newIntervalTicker(interval),
+		dirty:    make(map[string]bool),
+		buckets:  make(map[string]bool),
+		quit:     make(chan struct{}),
+	}
+}
+
+// Start reconciles all gauges and keeps them up to date until Stop is called
+func (m *MetricsCollector) Start() {
+	m.waitGroup.Add(1)
+	go m.run()
+	log.Printf("Metrics collector started (reconcile interval: %v)", m.interval.get())
+}
+
+// SetInterval changes the interval of the full reconcile
+func (m *MetricsCollector) SetInterval(interval time.Duration) {
+	if m.interval.set(interval) {
+		log.Printf("Metrics reconcile interval set to %v", interval)
+	}
+}
+
+func (m *MetricsCollector) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.quit)
+		m.waitGroup.Wait()
+	})
+}
+
+// MarkDirty schedules the gauges of a bucket to be recounted. It is safe to call on a nil collector.
+func (m *MetricsCollector) MarkDirty(bucket string) {
+	if m == nil || bucket == "" {
+		return
+	}
+	m.mu.Lock()
+	m.dirty[bucket] = true
+	m.mu.Unlock()
+}
+
+func (m *MetricsCollector) run() {
+	defer m.waitGroup.Done()
+	m.Reconcile()
+
+	refresh := time.NewTicker(metricsRefreshInterval)
+	defer refresh.Stop()
+	full := m.interval.start()
+	defer m.interval.stop()
+	for {
+		select {
+		case <-m.quit:
+			return
+		case <-refresh.C:
+			m.refresh()
+		case <-full:
+			m.Reconcile()
+		}
+	}
+}
+
+// Reconcile recomputes every gauge from the database and the filesystem
+func (m *MetricsCollector) Reconcile() {
+	if db := m.storage.DB; db != nil {
+		// Writes that land while counting mark their bucket again and are picked up by the next refresh
+		m.mu.Lock()
+		m.dirty = make(map[string]bool)
+		m.mu.Unlock()
+
+		if buckets, err := db.ListBuckets(); err != nil {
+			log.Printf("Metrics: failed to list buckets: %v", err)
+		} else if usage, err := db.GetBucketUsage(); err != nil {
+			log.Printf("Metrics: failed to count objects: %v", err)
+		} else {
+			m.setBucketGauges(buckets, usage, nil)
+		}
+		m.updateCAS()
+	}
+	m.countMultipartUploads()
+	m.updateQueues()
+}
+
+// refresh recounts the dirty buckets and the queue gauges
+func (m *MetricsCollector) refresh() {
+	m.mu.Lock()
+	dirty := m.dirty
+	m.dirty = make(map[string]bool)
+	m.mu.Unlock()
+
+	if db := m.storage.DB; db != nil && len(dirty) > 0 {
+		names := make([]string, 0, len(dirty))
+		for bucket := range dirty {
+			names = append(names, bucket)
+		}
+		if buckets, err := db.ListBuckets(); err != nil {
+			log.Printf("Metrics: failed to list buckets: %v", err)
+		} else if usage, err := db.GetBucketUsage(names...); err != nil {
+			log.Printf("Metrics: failed to count objects: %v", err)
+		} else {
+			m.setBucketGauges(buckets, usage, dirty)
+		}
+		m.updateCAS()
+	}
+	m.updateQueues()
+}
+
+// setBucketGauges sets the object gauges of the buckets in only, or of every bucket when only
+// is nil. Gauges of buckets that no longer exist are removed.
+func (m *MetricsCollector) setBucketGauges(buckets []string, usage map[string]database.BucketUsage, only map[string]bool) {
+	metrics.BucketsTotal.Set(float64(len(buckets)))
+	exists := make(map[string]bool, len(buckets))
+	for _, b := range buckets {
+		exists[b] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	targets := only
+	if targets == nil {
+		targets = make(map[string]bool, len(exists)+len(m.buckets))
+		for b := range exists {
+			targets[b] = true
+		}
+		for b := range m.buckets {
+			targets[b] = true
+		}
+	}
+	for bucket := range targets {
+		if !exists[bucket] {
+			if m.buckets[bucket] {
+				metrics.ObjectsTotal.DeleteLabelValues(bucket)
+				metrics.StorageBytes.DeleteLabelValues(bucket)
+				delete(m.buckets, bucket)
+			}
+			continue
+		}
+		u := usage[bucket]
+		metrics.ObjectsTotal.WithLabelValues(bucket).Set(float64(u.Objects))
+		metrics.StorageBytes.WithLabelValues(bucket).Set(float64(u.Bytes))
+		m.buckets[bucket] = true
+	}
+}
+
+func (m *MetricsCollector) updateCAS() {
+	u, err := m.storage.DB.GetCASUsage()
+	if err != nil {
+		log.Printf("Metrics: failed to measure CAS usage: %v", err)
+		return
+	}
+	metrics.CASBlobs.Set(float64(u.Blobs))
+	metrics.CASBytes.WithLabelValues("logical").Set(float64(u.LogicalBytes))
+	metrics.CASBytes.WithLabelValues("stored").Set(float64(u.StoredBytes))
+	metrics.CASBytes.WithLabelValues("physical").Set(float64(u.PhysicalBytes))
+}
+
+// countMultipartUploads counts the upload directories under <bucket>/.uploads
+func (m *MetricsCollector) countMultipartUploads() {
+	entries, err := os.ReadDir(m.storage.Root)
+	if err != nil {
+		return
+	}
+	count := 0
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		uploads, err := os.ReadDir(filepath.Join(m.storage.Root, e.Name(), ".uploads"))
+		if err != nil {
+			continue
+		}
+		for _, u := range uploads {
+			if u.IsDir() {
+				count++
+			}
+		}
+	}
+	metrics.MultipartUploadsInFlight.Set(float64(count))
+}
+
+func (m *MetricsCollector) updateQueues() {
+	if m.storage.Jobs != nil {
+		m.storage.Jobs.UpdateMetrics()
+	}
+	if m.storage.Notifications != nil {
+		m.storage.Notifications.UpdateMetrics()
+	}
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsCollectorReconcile(t *testing.T) {
+	store := newTestStorage(t, Options{})
+	store.Metrics = NewMetricsCollector(store, time.Hour)
+
+	store.CreateBucket("gauged-bucket")
+	store.CreateBucket("gone-bucket")
+	for _, key := range []string{"a.bin", "b.bin", "c.bin"} {
+		if _, err := store.PutObject("gauged-bucket", key, strings.NewReader("same content"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.DeleteObject("gauged-bucket", "c.bin", "", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.InitiateMultipartUpload("gauged-bucket", "big.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart starts from empty gauges; reconcile rebuilds them
+	metrics.ObjectsTotal.Reset()
+	metrics.StorageBytes.Reset()
+	metrics.MultipartUploadsInFlight.Set(0)
+	store.Metrics.Reconcile()
+
+	if n := testutil.ToFloat64(metrics.ObjectsTotal.WithLabelValues("gauged-bucket")); n != 2 {
+		t.Errorf("objects = %v, want 2", n)
+	}
+	if n := testutil.ToFloat64(metrics.StorageBytes.WithLabelValues("gauged-bucket")); n != 24 {
+		t.Errorf("bytes = %v, want 24", n)
+	}
+	if n := testutil.ToFloat64(metrics.CASBlobs); n != 1 {
+		t.Errorf("CAS blobs = %v, want 1", n)
+	}
+	if n := testutil.ToFloat64(metrics.CASBytes.WithLabelValues("logical")); n != 24 {
+		t.Errorf("logical bytes = %v, want 24", n)
+	}
+	if n := testutil.ToFloat64(metrics.CASBytes.WithLabelValues("physical")); n != 12 {
+		t.Errorf("physical bytes = %v, want 12", n)
+	}
+	if n := testutil.ToFloat64(metrics.MultipartUploadsInFlight); n != 1 {
+		t.Errorf("multipart uploads = %v, want 1", n)
+	}
+
+	// Writes mark their bucket dirty and the refresh recounts it; deleted buckets lose their gauges
+	store.PutObject("gauged-bucket", "d.bin", strings.NewReader("more"), "")
+	store.DeleteBucket("gone-bucket")
+	store.Metrics.refresh()
+	if n := testutil.ToFloat64(metrics.ObjectsTotal.WithLabelValues("gauged-bucket")); n != 3 {
+		t.Errorf("objects after refresh = %v, want 3", n)
+	}
+	if n := testutil.CollectAndCount(metrics.ObjectsTotal); n != 1 {
+		t.Errorf("object gauges = %d, want 1", n)
+	}
+}
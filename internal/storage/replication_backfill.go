@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+const (
+	backfillRunning   = "running"
+	backfillCompleted = "completed"
+	backfillFailed    = "failed"
+
+	backfillPageSize = 500
+)
+
+// StartReplicationBackfill queues every existing object a rule has not replicated yet
+func (s *FileStorage) StartReplicationBackfill(bucket string, ruleID int64) (int64, error) {
+	if s.DB == nil {
+		return 0, fmt.Errorf("database not available")
+	}
+	rule, err := s.DB.GetReplicationRule(ruleID)
+	if err != nil {
+		return 0, err
+	}
+	if rule == nil || rule.SourceBucket != bucket {
+		return 0, fmt.Errorf("replication rule %d not found for bucket %s", ruleID, bucket)
+	}
+
+	id, err := s.DB.CreateReplicationBackfill(rule.ID, bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	job := &ReplicationBackfillJob{Storage: s, BackfillID: id, Rule: *rule}
+	if s.Jobs == nil {
+		go job.Execute()
+		return id, nil
+	}
+	if _, err := s.Jobs.Enqueue(job); err != nil {
+		msg := err.Error()
+		s.DB.UpdateReplicationBackfill(id, backfillFailed, 0, 0, &msg)
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *FileStorage) ListReplicationBackfills(bucket string) ([]database.ReplicationBackfillRow, error) {
+	if s.DB == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	return s.DB.ListReplicationBackfills(bucket)
+}
+
+// ReplicationBackfillJob implements jobs.Job for walking a source bucket and queueing missing replicas
+type ReplicationBackfillJob struct {
+	Storage    *FileStorage            `json:"-"`
+	BackfillID int64                   `json:"backfill_id"`
+	Rule       database.ReplicationRow `json:"rule"`
+}
+
+func (j *ReplicationBackfillJob) Type() string {
+	return JobTypeReplicationBackfill
+}
+
+func (j *ReplicationBackfillJob) Name() string {
+	return fmt.Sprintf("ReplicationBackfill:%s:%d", j.Rule.SourceBucket, j.Rule.ID)
+}
+
+func (j *ReplicationBackfillJob) Execute() error {
+	s := j.Storage
+	prefix := ""
+	if j.Rule.Prefix != nil {
+		prefix = *j.Rule.Prefix
+	}
+
+	var scanned, queued int64
+	startAfter := ""
+	for {
+		page, err := s.DB.ListObjectsAfter(j.Rule.SourceBucket, prefix, startAfter, backfillPageSize)
+		if err != nil {
+			msg := err.Error()
+			s.DB.UpdateReplicationBackfill(j.BackfillID, backfillFailed, scanned, queued, &msg)
+			return fmt.Errorf("replication backfill of %s failed: %w", j.Rule.SourceBucket, err)
+		}
+
+		for _, obj := range page {
+			startAfter = obj.Key
+			scanned++
+			if !j.missing(obj) {
+				continue
+			}
+			task := &database.ReplicationTaskRow{
+				RuleID:       j.Rule.ID,
+				SourceBucket: obj.Bucket,
+				Key:          obj.Key,
+				VersionID:    obj.VersionID,
+				Operation:    ReplicationOpPut,
+			}
+			if err := s.enqueueReplicationTask(task); err != nil {
+				log.Printf("Replication backfill: failed to queue %s/%s: %v", obj.Bucket, obj.Key, err)
+				continue
+			}
+			s.DB.SetObjectReplicationStatus(obj.Bucket, obj.Key, obj.VersionID, ReplicationStatusPending)
+			queued++
+		}
+
+		s.DB.UpdateReplicationBackfill(j.BackfillID, backfillRunning, scanned, queued, nil)
+		if len(page) < backfillPageSize {
+			break
+		}
+	}
+
+	log.Printf("Replication backfill of %s (rule %d) queued %d of %d objects", j.Rule.SourceBucket, j.Rule.ID, queued, scanned)
+	return s.DB.UpdateReplicationBackfill(j.BackfillID, backfillCompleted, scanned, queued, nil)
+}
+
+// missing reports whether an object still has to be copied by the rule
+func (j *ReplicationBackfillJob) missing(obj *database.ObjectRow) bool {
+	s := j.Storage
+	if obj.VersionID == "folder" {
+		return false
+	}
+	// Replicas are never replicated again, as with live writes
+	if obj.ReplicationStatus != nil && *obj.ReplicationStatus == ReplicationStatusReplica {
+		return false
+	}
+	done, err := s.DB.HasReplicationTask(j.Rule.ID, obj.Bucket, obj.Key, obj.VersionID, ReplicationOpPut)
+	if err != nil || done {
+		return false
+	}
+	// A local destination that already holds the same content needs no copy
+	if j.Rule.TargetID == nil && obj.ContentHash != nil {
+		existing, _ := s.DB.GetObject(j.Rule.DestinationBucket, obj.Key, "")
+		if existing != nil && existing.ContentHash != nil && *existing.ContentHash == *obj.ContentHash {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/jobs"
+)
+
+func TestReplicationTaskFailsWhenItsJobCannotBeQueued(t *testing.T) {
+	store := newTestStorage(t, Options{})
+	db := store.DB
+	store.ReplicationWorker = NewReplicationWorker(store)
+	// The replication job type is not registered, so queueing its job fails
+	store.Jobs = jobs.NewManager(db, 1)
+
+	newTask := func() *database.ReplicationTaskRow {
+		return &database.ReplicationTaskRow{RuleID: 1, SourceBucket: "photos", Key: "a.jpg", VersionID: "v1", Operation: ReplicationOpPut}
+	}
+	if err := store.enqueueReplicationTask(newTask()); err == nil {
+		t.Fatal("enqueue succeeded without a job")
+	}
+	tasks, err := db.ListReplicationTasks("photos", "", 10)
+	if err != nil || len(tasks) != 1 || tasks[0].Status != replicationTaskFailed || tasks[0].LastError == nil {
+		t.Fatalf("tasks = %+v, %v", tasks, err)
+	}
+
+	// The failed task does not swallow the next change of the object
+	store.Jobs.Register(JobTypeReplication, 1, func() jobs.Job { return &ReplicationJob{Worker: store.ReplicationWorker} })
+	if err := store.enqueueReplicationTask(newTask()); err != nil {
+		t.Fatal(err)
+	}
+	tasks, _ = db.ListReplicationTasks("photos", "", 10)
+	if len(tasks) != 1 || tasks[0].Status != replicationTaskPending {
+		t.Errorf("tasks after a new change = %+v", tasks)
+	}
+}
+
+func TestLinkReplica(t *testing.T) {
+	store := newTestStorage(t, Options{})
+	db := store.DB
+	for _, bucket := range []string{"photos", "versioned", "plain", "small"} {
+		store.CreateBucket(bucket)
+	}
+	store.SetBucketVersioning("versioned", true)
+	store.SetBucketQuota("small", 4)
+
+	content := "replicated content"
+	if _, err := store.PutObject("photos", "a.txt", strings.NewReader(content), ""); err != nil {
+		t.Fatal(err)
+	}
+	src, _ := db.GetObject("photos", "a.txt", "")
+
+	// Replicas share the source's blob and are marked REPLICA
+	for _, bucket := range []string{"versioned", "plain"} {
+		if linked, err := store.linkReplica(bucket, "a.txt", src); err != nil || !linked {
+			t.Fatalf("link into %s = %v, %v", bucket, linked, err)
+		}
+		row, _ := db.GetObject(bucket, "a.txt", "")
+		if row == nil || *row.ContentHash != *src.ContentHash || row.ReplicationStatus == nil || *row.ReplicationStatus != ReplicationStatusReplica {
+			t.Fatalf("replica in %s = %+v", bucket, row)
+		}
+		reader, _, err := store.GetObject(bucket, "a.txt", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(got) != content {
+			t.Errorf("replica in %s reads %q", bucket, got)
+		}
+	}
+	if versions, _ := store.ListVersions("versioned", "a.txt"); len(versions) != 1 || versions[0].VersionID == "simple" {
+		t.Errorf("versions of the replica = %+v", versions)
+	}
+
+	// The checks of a put apply: a held object is not overwritten and quotas are enforced
+	if err := store.SetObjectLegalHold("plain", "a.txt", "simple", true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.linkReplica("plain", "a.txt", src); err == nil {
+		t.Error("replica overwrote an object under legal hold")
+	}
+	if _, err := store.linkReplica("small", "a.txt", src); err == nil || !strings.Contains(err.Error(), "quota") {
+		t.Errorf("link into a bucket over quota = %v", err)
+	}
+	if _, err := store.linkReplica("missing", "a.txt", src); err == nil {
+		t.Error("linked into a bucket that does not exist")
+	}
+}
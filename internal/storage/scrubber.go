@@ -0,0 +1,862 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/erasure"
+	"github.com/GravSpace/GravSpace/internal/health"
+	"github.com/GravSpace/GravSpace/internal/metrics"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var (
+	// ErrObjectCorrupted is returned when reading a version whose content the scrubber found damaged
+	ErrObjectCorrupted = errors.New("The object's data failed an integrity check and is quarantined")
+	// ErrScrubRunning is returned when a scrub pass is requested while one runs
+	ErrScrubRunning = errors.New("a scrub pass is already running")
+)
+
+// System settings that let a scrub pass survive restarts
+const (
+	scrubCursorSetting   = "scrub_cursor"    // Last blob verified by an unfinished pass
+	scrubLastPassSetting = "scrub_last_pass" // When the last pass finished (RFC 3339)
+)
+
+// erasureCursorPrefix marks a cursor saved while the pass verified the erasure-coded blobs,
+// which come after every blob under .cas
+const erasureCursorPrefix = "ec:"
+
+// scrubCursorSaveInterval is how often a running pass records how far it got
+const scrubCursorSaveInterval = time.Minute
+
+// ScrubStatus describes the running or the last scrub pass
+type ScrubStatus struct {
+	Running    bool       `json:"running"`
+	Progress   float64    `json:"progress"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	LastPassAt *time.Time `json:"last_pass_completed_at,omitempty"`
+	Blobs      int64      `json:"blobs"`
+	Bytes      int64      `json:"bytes"`
+	Corrupted  int64      `json:"corrupted"`
+	Restored   int64      `json:"restored"`
+	Interval   string     `json:"interval"`
+	Rate       int64      `json:"rate_bytes_per_second"`
+}
+
+// Scrubber re-reads every CAS blob in the background, decrypting and decompressing it, and
+// checks that its content still hashes to the name it is stored under. Damaged blobs are moved
+// to .quarantine, the versions stored in them are flagged so that reads fail instead of
+// returning bad data, and an intact copy is fetched from a remote replica when one exists.
+// The shards of erasure-coded blobs are checked against their recorded checksums and rebuilt
+// from the others when damaged.
+type Scrubber struct {
+	store     *FileStorage
+	heartbeat *health.Heartbeat
+	interval  *intervalTicker
+	rate      atomic.Int64 // Bytes read per second; zero reads at full speed
+	running   atomic.Bool
+
+	// Pacing of the current pass
+	paceStart time.Time
+	paceBytes int64
+
+	mu     sync.Mutex
+	status ScrubStatus
+
+	trigger  chan struct{}
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewScrubber creates a scrubber that starts a pass every interval (daily when zero) and reads
+// at most rate bytes per second (unlimited when zero)
+func NewScrubber(store *FileStorage, interval time.Duration, rate int64) *Scrubber {
+	w := &Scrubber{
+		store:    store,
+		interval: newIntervalTicker(orDefault(interval, 24*time.Hour)),
+		trigger:  make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	w.rate.Store(max(rate, 0))
+	return w
+}
+
+func (w *Scrubber) Start() {
+	w.heartbeat = health.Worker("scrub")
+	w.heartbeat.Beat()
+	w.updateQuarantineGauge()
+
+	ticks := w.interval.start()
+	go func() {
+		defer close(w.done)
+		defer w.interval.stop()
+		heartbeat := time.NewTicker(health.HeartbeatInterval)
+		defer heartbeat.Stop()
+
+		// An interrupted pass resumes, and a pass missed while the server was down starts now
+		if w.due() {
+			w.Scrub()
+		}
+		for {
+			select {
+			case <-w.quit:
+				return
+			case <-ticks:
+				w.Scrub()
+			case <-w.trigger:
+				w.Scrub()
+			case <-heartbeat.C:
+			}
+			w.heartbeat.Beat()
+		}
+	}()
+	log.Printf("Scrubber started (Interval: %v, Rate: %s)", w.interval.get(), formatRate(w.rate.Load()))
+}
+
+// SetInterval changes how often a scrub pass starts
+func (w *Scrubber) SetInterval(interval time.Duration) {
+	if w.interval.set(interval) {
+		log.Printf("Scrubber interval set to %v", interval)
+	}
+}
+
+// SetRate changes how many bytes per second a pass reads; zero removes the limit
+func (w *Scrubber) SetRate(rate int64) {
+	if rate = max(rate, 0); w.rate.Swap(rate) != rate {
+		log.Printf("Scrubber rate set to %s", formatRate(rate))
+	}
+}
+
+// Stop interrupts a running pass, which resumes after the next start, and stops the scrubber
+func (w *Scrubber) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.quit)
+		<-w.done
+	})
+}
+
+// RunNow starts a pass without waiting for the interval
+func (w *Scrubber) RunNow() error {
+	if w.running.Load() {
+		return ErrScrubRunning
+	}
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Status returns the progress of the running pass, or the results of the last one
+func (w *Scrubber) Status() ScrubStatus {
+	w.mu.Lock()
+	status := w.status
+	w.mu.Unlock()
+	status.Running = w.running.Load()
+	status.Interval = w.interval.get().String()
+	status.Rate = w.rate.Load()
+	if status.LastPassAt == nil {
+		status.LastPassAt = w.lastPass()
+	}
+	return status
+}
+
+// due reports whether a pass was interrupted or the last one finished more than an interval ago
+func (w *Scrubber) due() bool {
+	if cursor, _ := w.store.DB.GetSystemSetting(scrubCursorSetting); cursor != "" {
+		return true
+	}
+	last := w.lastPass()
+	return last == nil || time.Since(*last) >= w.interval.get()
+}
+
+func (w *Scrubber) lastPass() *time.Time {
+	value, _ := w.store.DB.GetSystemSetting(scrubLastPassSetting)
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func (w *Scrubber) stopping() bool {
+	select {
+	case <-w.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Scrub verifies every blob under .cas, continuing where an interrupted pass stopped. It
+// returns when the pass is complete or the scrubber is stopping.
+func (w *Scrubber) Scrub() {
+	if !w.running.CompareAndSwap(false, true) {
+		return
+	}
+	defer w.running.Store(false)
+	start := time.Now()
+	defer func() { metrics.RecordWorkerRun("scrub", time.Since(start)) }()
+
+	db := w.store.DB
+	cursor, err := db.GetSystemSetting(scrubCursorSetting)
+	if err != nil {
+		log.Printf("Scrubber error reading its cursor, verifying every blob: %v", err)
+	}
+	w.mu.Lock()
+	startedAt := start.UTC()
+	w.status = ScrubStatus{StartedAt: &startedAt}
+	w.mu.Unlock()
+	w.paceStart, w.paceBytes = time.Now(), 0
+	if cursor != "" {
+		log.Printf("Scrubber resuming the interrupted pass after blob %s", cursor)
+	}
+
+	dirs := w.store.casDirs()
+	erasureCursor, inErasure := strings.CutPrefix(cursor, erasureCursorPrefix)
+	if inErasure {
+		dirs = nil
+	}
+
+	lastSaved := time.Now()
+	for i, dir := range dirs {
+		if cursor != "" && dir < cursor[:2] {
+			continue
+		}
+		blobs := w.store.casDirBlobs(dir)
+		for j, name := range blobs {
+			if name <= cursor {
+				continue
+			}
+			w.scrubBlob(name)
+			if w.stopping() {
+				// The blob being read when the scrubber stopped is verified again on resume
+				w.saveCursor(cursor)
+				log.Printf("Scrubber interrupted; the pass resumes on the next start")
+				return
+			}
+			cursor = name
+			w.heartbeat.Beat()
+
+			progress := (float64(i) + float64(j+1)/float64(len(blobs))) / float64(len(dirs))
+			metrics.ScrubProgress.Set(progress)
+			w.mu.Lock()
+			w.status.Progress = progress
+			w.mu.Unlock()
+			if time.Since(lastSaved) >= scrubCursorSaveInterval {
+				w.saveCursor(cursor)
+				lastSaved = time.Now()
+			}
+		}
+	}
+	if !w.scrubErasure(erasureCursor) {
+		return
+	}
+	finished := time.Now().UTC()
+	w.saveCursor("")
+	if err := db.SetSystemSetting(scrubLastPassSetting, finished.Format(time.RFC3339)); err != nil {
+		log.Printf("Scrubber error recording the finished pass: %v", err)
+	}
+	metrics.ScrubProgress.Set(1)
+	metrics.ScrubLastPassCompleted.Set(float64(finished.Unix()))
+	w.updateQuarantineGauge()
+
+	w.mu.Lock()
+	w.status.Progress = 1
+	w.status.LastPassAt = &finished
+	status := w.status
+	w.mu.Unlock()
+	log.Printf("Scrubber verified %d blobs (%s) in %v: %d corrupted, %d restored",
+		status.Blobs, formatBytes(status.Bytes), time.Since(start).Round(time.Second), status.Corrupted, status.Restored)
+}
+
+func (w *Scrubber) saveCursor(cursor string) {
+	if err := w.store.DB.SetSystemSetting(scrubCursorSetting, cursor); err != nil {
+		log.Printf("Scrubber error saving its cursor: %v", err)
+	}
+}
+
+// scrubBlob verifies every copy of a blob against its name. Damaged copies are replaced from an
+// intact one; when no copy is intact the blob is quarantined.
+func (w *Scrubber) scrubBlob(hash string) {
+	copies := w.store.blobCopies(hash)
+	if len(copies) == 0 {
+		// Removed by a delete since the directory was listed
+		return
+	}
+	rows, err := w.store.DB.ListObjectsByHash(hash)
+	if err != nil {
+		log.Printf("Scrubber error looking up blob %s: %v", hash, err)
+		return
+	}
+	if len(rows) == 0 {
+		// Orphaned blobs are left to fsck and gc-cas
+		return
+	}
+
+	// The first version stored under the hash wrote the blob, with its own encryption and compression
+	encryption, compression := rowEncoding(rows[0])
+	var intact, reason string
+	var damaged []string
+	for _, path := range copies {
+		actual, err := w.verifyBlob(path, encryption, compression)
+		if w.stopping() {
+			return
+		}
+		switch {
+		case err == nil && actual == hash:
+			if intact == "" {
+				intact = path
+			}
+			continue
+		case err != nil:
+			reason = fmt.Sprintf("the content cannot be read: %v", err)
+		default:
+			reason = fmt.Sprintf("the content hashes to %s", actual)
+		}
+		damaged = append(damaged, path)
+	}
+
+	w.mu.Lock()
+	w.status.Blobs++
+	w.mu.Unlock()
+	if len(damaged) == 0 {
+		metrics.ScrubBlobsTotal.WithLabelValues("ok").Inc()
+		w.healFlagged(hash, rows)
+		return
+	}
+
+	metrics.ScrubBlobsTotal.WithLabelValues("corrupted").Inc()
+	w.mu.Lock()
+	w.status.Corrupted++
+	w.mu.Unlock()
+	var size int64
+	if info, err := os.Stat(damaged[0]); err == nil {
+		size = info.Size()
+	}
+	if intact != "" {
+		w.repairCopies(hash, intact, damaged, size, reason, rows)
+		return
+	}
+	dst, err := quarantineCopies(hash, damaged)
+	if err != nil {
+		log.Printf("Scrubber error quarantining blob %s: %v", hash, err)
+	}
+	if dst == "" {
+		return
+	}
+	w.quarantine(hash, dst, size, reason, rows)
+}
+
+// scrubErasure verifies the erasure-coded blobs after the one in cursor. It returns false when
+// the scrubber is stopping.
+func (w *Scrubber) scrubErasure(cursor string) bool {
+	blobs, err := w.store.DB.ListErasureBlobs()
+	if err != nil {
+		log.Printf("Scrubber error listing the erasure-coded blobs: %v", err)
+		return true
+	}
+	lastSaved := time.Now()
+	for _, e := range blobs {
+		if e.Hash <= cursor {
+			continue
+		}
+		w.scrubShards(e)
+		if w.stopping() {
+			w.saveCursor(erasureCursorPrefix + cursor)
+			log.Printf("Scrubber interrupted; the pass resumes on the next start")
+			return false
+		}
+		cursor = e.Hash
+		w.heartbeat.Beat()
+		if time.Since(lastSaved) >= scrubCursorSaveInterval {
+			w.saveCursor(erasureCursorPrefix + cursor)
+			lastSaved = time.Now()
+		}
+	}
+	return true
+}
+
+// scrubShards verifies every shard of an erasure-coded blob against its checksum and rebuilds
+// the damaged ones from the others. When too few are intact the blob is quarantined.
+func (w *Scrubber) scrubShards(e *database.ErasureBlobRow) {
+	s := w.store
+	rows, err := s.DB.ListObjectsByHash(e.Hash)
+	if err != nil {
+		log.Printf("Scrubber error looking up blob %s: %v", e.Hash, err)
+		return
+	}
+	if len(rows) == 0 {
+		// Orphaned blobs are left to fsck and gc-cas
+		return
+	}
+	paths := s.shardPaths(e)
+	damaged := damagedShards(e, paths, w.verifyShard)
+	if w.stopping() {
+		return
+	}
+
+	w.mu.Lock()
+	w.status.Blobs++
+	w.mu.Unlock()
+	if len(damaged) == 0 {
+		metrics.ScrubBlobsTotal.WithLabelValues("ok").Inc()
+		w.healFlagged(e.Hash, rows)
+		return
+	}
+
+	metrics.ScrubBlobsTotal.WithLabelValues("corrupted").Inc()
+	w.mu.Lock()
+	w.status.Corrupted++
+	w.mu.Unlock()
+	reason := fmt.Sprintf("shards %s of %d+%d are missing or damaged", formatShards(damaged), e.DataShards, e.ParityShards)
+	err = s.rebuildShards(e, paths, damaged, "scrub")
+	switch {
+	case err == nil:
+		metrics.ScrubRestoredTotal.WithLabelValues("parity").Inc()
+		w.mu.Lock()
+		w.status.Restored++
+		w.mu.Unlock()
+		log.Printf("Scrubber rebuilt blob %s: %s", e.Hash, reason)
+		if s.Notifier != nil {
+			s.Notifier.SendAlert("Corrupted Object Data", fmt.Sprintf("Erasure-coded blob %s had damage (%s). The shards were rebuilt from the others; check the health of the drives holding them.",
+				e.Hash, reason))
+		}
+		w.healFlagged(e.Hash, rows)
+	case errors.Is(err, erasure.ErrTooFewShards):
+		dst, err := quarantineShards(s, e)
+		if err != nil {
+			log.Printf("Scrubber error quarantining blob %s: %v", e.Hash, err)
+		}
+		if dst == "" {
+			return
+		}
+		w.quarantine(e.Hash, dst, e.Size, reason+", too many to rebuild", rows)
+	default:
+		log.Printf("Scrubber error rebuilding blob %s (%s): %v", e.Hash, reason, err)
+	}
+}
+
+// verifyShard returns the SHA-256 of a shard, reading it at the configured rate
+func (w *Scrubber) verifyShard(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	r := &scrubReader{w: w, file: file}
+	defer r.Close()
+
+	h := sha256.New()
+	buf := bufferPool.Get().([]byte)
+	defer bufferPool.Put(buf)
+	if _, err := io.CopyBuffer(h, r, buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// quarantineShards moves the shards of a blob that cannot be rebuilt to the .quarantine
+// directory of their drives and forgets the blob, so that an upload or a replica can store it
+// again. It returns where the first shards went.
+func quarantineShards(s *FileStorage, e *database.ErasureBlobRow) (string, error) {
+	first := ""
+	for _, drive := range s.blobPlacement(e.Hash) {
+		src := filepath.Join(drive, erasureDir, e.Hash[:2], e.Hash)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		dir := filepath.Join(drive, ".quarantine")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return first, err
+		}
+		dst := filepath.Join(dir, e.Hash+".shards")
+		if _, err := os.Stat(dst); err == nil {
+			dst += "." + time.Now().UTC().Format("20060102T150405")
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return first, err
+		}
+		os.Remove(filepath.Dir(src)) // Clean parent folder if empty
+		if first == "" {
+			first = dst
+		}
+	}
+	if first == "" {
+		// Every shard is gone; there is nothing to move
+		first = filepath.Join(erasureDir, e.Hash[:2], e.Hash)
+	}
+	return first, s.DB.DeleteErasureBlob(e.Hash)
+}
+
+// verifyBlob returns the SHA-256 of the content of a blob, reading it at the configured rate
+func (w *Scrubber) verifyBlob(path, encryption, compression string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	content, err := decodeStored(&scrubReader{w: w, file: file}, encryption, compression)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	h := sha256.New()
+	buf := bufferPool.Get().([]byte)
+	defer bufferPool.Put(buf)
+	if _, err := io.CopyBuffer(h, content, buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// throttle accounts for n bytes read and sleeps long enough to keep the pass at the configured
+// rate. It returns false when the scrubber is stopping.
+func (w *Scrubber) throttle(n int) bool {
+	metrics.ScrubBytesTotal.Add(float64(n))
+	w.mu.Lock()
+	w.status.Bytes += int64(n)
+	w.mu.Unlock()
+
+	rate := w.rate.Load()
+	if rate <= 0 {
+		return !w.stopping()
+	}
+	w.paceBytes += int64(n)
+	ahead := time.Duration(float64(w.paceBytes)/float64(rate)*float64(time.Second)) - time.Since(w.paceStart)
+	if ahead < -time.Second {
+		// Reading fell behind, e.g. after a rate change; do not make up for it in a burst
+		w.paceStart, w.paceBytes = time.Now(), 0
+		return !w.stopping()
+	}
+	if ahead <= 0 {
+		return !w.stopping()
+	}
+	timer := time.NewTimer(ahead)
+	defer timer.Stop()
+	select {
+	case <-w.quit:
+		return false
+	case <-timer.C:
+		w.heartbeat.Beat()
+		return true
+	}
+}
+
+// errScrubStopped aborts the verification of a blob when the scrubber stops
+var errScrubStopped = errors.New("scrubber stopping")
+
+// scrubReader paces the reads of a blob
+type scrubReader struct {
+	w    *Scrubber
+	file *os.File
+}
+
+func (r *scrubReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	if n > 0 && !r.w.throttle(n) {
+		return n, errScrubStopped
+	}
+	return n, err
+}
+
+func (r *scrubReader) Close() error {
+	return r.file.Close()
+}
+
+// quarantine records a damaged blob moved out of the store to dst, flags the versions stored in
+// it, fetches an intact copy from a replica if it can and raises an alert
+func (w *Scrubber) quarantine(hash, dst string, size int64, reason string, rows []*database.ObjectRow) {
+	s := w.store
+	flagged, err := s.DB.SetContentCorrupted(hash, true)
+	if err != nil {
+		log.Printf("Scrubber error flagging the versions of blob %s: %v", hash, err)
+	}
+	if err := s.DB.QuarantineBlob(&database.QuarantinedBlobRow{
+		Hash: hash, Path: dst, Size: size, Reason: reason, Objects: flagged, DetectedAt: time.Now().UTC(),
+	}); err != nil {
+		log.Printf("Scrubber error recording quarantined blob %s: %v", hash, err)
+	}
+	log.Printf("Scrubber quarantined blob %s: %s; %d versions flagged", hash, reason, flagged)
+
+	var versions []string
+	for _, row := range rows {
+		if len(versions) == 10 {
+			versions = append(versions, fmt.Sprintf("and %d more", len(rows)-10))
+			break
+		}
+		versions = append(versions, fmt.Sprintf("%s/%s (version %s)", row.Bucket, row.Key, row.VersionID))
+	}
+	details := fmt.Sprintf("Blob %s is damaged (%s) and was moved to %s. Affected versions: %s.",
+		hash, reason, dst, strings.Join(versions, ", "))
+
+	source, err := w.restoreFromReplica(hash, rows)
+	switch {
+	case source != "":
+		details += fmt.Sprintf(" An intact copy was restored from %s.", source)
+	case err != nil:
+		details += fmt.Sprintf(" Restoring it from a replica failed: %v. Reads of these versions fail until it is uploaded again.", err)
+	default:
+		details += " No replica holds a copy; reads of these versions fail until it is uploaded again."
+	}
+	w.updateQuarantineGauge()
+	if s.Notifier != nil {
+		s.Notifier.SendAlert("Corrupted Object Data", details)
+	}
+}
+
+// repairCopies replaces the damaged copies of a blob with the intact one, on another drive
+// when the placement prefers it, and raises an alert: the drive they were on may be failing
+func (w *Scrubber) repairCopies(hash, intact string, damaged []string, size int64, reason string, rows []*database.ObjectRow) {
+	s := w.store
+	dst, err := quarantineCopies(hash, damaged)
+	if err != nil {
+		log.Printf("Scrubber error quarantining a copy of blob %s: %v", hash, err)
+	}
+	if dst == "" {
+		return
+	}
+	if err := s.DB.QuarantineBlob(&database.QuarantinedBlobRow{
+		Hash: hash, Path: dst, Size: size, Reason: reason, DetectedAt: time.Now().UTC(),
+	}); err != nil {
+		log.Printf("Scrubber error recording quarantined blob %s: %v", hash, err)
+	}
+	if _, err := s.replicateBlob(hash, intact); err != nil {
+		log.Printf("Scrubber error copying blob %s: %v", hash, err)
+	}
+	source := "the copy on " + driveOf(intact)
+	if err := w.relink(hash, rows, source); err != nil {
+		log.Printf("Scrubber error restoring the versions of blob %s: %v", hash, err)
+		return
+	}
+	metrics.ScrubRestoredTotal.WithLabelValues("mirror").Inc()
+	w.mu.Lock()
+	w.status.Restored++
+	w.mu.Unlock()
+	w.updateQuarantineGauge()
+	log.Printf("Scrubber replaced %d damaged copies of blob %s (%s) from %s", len(damaged), hash, reason, source)
+	if s.Notifier != nil {
+		s.Notifier.SendAlert("Corrupted Object Data", fmt.Sprintf("A copy of CAS blob %s is damaged (%s) and was moved to %s. It was replaced from %s; check the health of %s.",
+			hash, reason, dst, source, driveOf(damaged[0])))
+	}
+}
+
+// quarantineCopies moves the copies of a blob to the .quarantine directory of their drive and
+// returns where the first one went
+func quarantineCopies(hash string, copies []string) (string, error) {
+	first := ""
+	for _, path := range copies {
+		dir := filepath.Join(driveOf(path), ".quarantine")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return first, err
+		}
+		dst := filepath.Join(dir, hash)
+		if _, err := os.Stat(dst); err == nil {
+			// The same content rotted before
+			dst += "." + time.Now().UTC().Format("20060102T150405")
+		}
+		if err := os.Rename(path, dst); err != nil {
+			return first, err
+		}
+		if first == "" {
+			first = dst
+		}
+	}
+	return first, nil
+}
+
+// driveOf returns the drive a blob copy is stored on
+func driveOf(blob string) string {
+	return filepath.Dir(filepath.Dir(filepath.Dir(blob)))
+}
+
+// restoreFromReplica fetches the content of a quarantined blob from the remote replica of one
+// of its versions. Buckets replicated on this server share the damaged blob and cannot help.
+func (w *Scrubber) restoreFromReplica(hash string, rows []*database.ObjectRow) (string, error) {
+	s := w.store
+	var lastErr error
+	for _, row := range rows {
+		if row.DeletedAt != nil {
+			continue
+		}
+		rules, err := s.DB.GetReplicationRules(row.Bucket)
+		if err != nil {
+			return "", err
+		}
+		for _, rule := range rules {
+			if rule.TargetID == nil || !rule.Enabled || (rule.Prefix != nil && !strings.HasPrefix(row.Key, *rule.Prefix)) {
+				continue
+			}
+			source := fmt.Sprintf("%s/%s on replication target %d", rule.DestinationBucket, row.Key, *rule.TargetID)
+			if err := w.fetchBlob(hash, *rule.TargetID, rule.DestinationBucket, row.Key, rows[0]); err != nil {
+				lastErr = fmt.Errorf("%s: %w", source, err)
+				log.Printf("Scrubber could not restore blob %s from %v", hash, lastErr)
+				continue
+			}
+			if err := w.relink(hash, rows, source); err != nil {
+				return "", err
+			}
+			metrics.ScrubRestoredTotal.WithLabelValues("replica").Inc()
+			w.mu.Lock()
+			w.status.Restored++
+			w.mu.Unlock()
+			log.Printf("Scrubber restored blob %s from %s", hash, source)
+			return source, nil
+		}
+	}
+	return "", lastErr
+}
+
+// fetchBlob downloads an object from a replication target and stores it as the blob of hash,
+// encoded like the versions that share it, if its content matches
+func (w *Scrubber) fetchBlob(hash string, targetID int64, bucket, key string, encoding *database.ObjectRow) error {
+	s := w.store
+	var client *awss3.Client
+	if s.ReplicationWorker != nil {
+		var err error
+		if client, err = s.ReplicationWorker.client(targetID); err != nil {
+			return err
+		}
+	} else {
+		target, err := s.DB.GetReplicationTarget(targetID)
+		if err != nil {
+			return err
+		}
+		if target == nil {
+			return fmt.Errorf("replication target %d not found", targetID)
+		}
+		client = newReplicationClient(target)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	out, err := client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(strings.TrimPrefix(key, "/")),
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+
+	blob := s.casPath(hash)
+	if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		return err
+	}
+	tmpPath := blob + ".tmp-scrub"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	encryption, compression := rowEncoding(encoding)
+	writer, err := encodeStored(tmpFile, encryption, compression)
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	h := sha256.New()
+	buf := bufferPool.Get().([]byte)
+	_, err = io.CopyBuffer(writer, io.TeeReader(out.Body, h), buf)
+	bufferPool.Put(buf)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != hash {
+		os.Remove(tmpPath)
+		return fmt.Errorf("the replica holds different content (%s)", actual)
+	}
+
+	// An upload of the same content may have stored the blob again in the meantime
+	_, err = s.storeBlob(tmpPath, hash)
+	return err
+}
+
+// healFlagged clears the flag of versions quarantined earlier once their blob is intact again,
+// which happens when the same content is uploaded after the blob was quarantined
+func (w *Scrubber) healFlagged(hash string, rows []*database.ObjectRow) {
+	var flagged []*database.ObjectRow
+	for _, row := range rows {
+		if row.CorruptedAt != nil {
+			flagged = append(flagged, row)
+		}
+	}
+	if len(flagged) == 0 {
+		return
+	}
+	if err := w.relink(hash, flagged, "an upload of the same content"); err != nil {
+		log.Printf("Scrubber error restoring the versions of blob %s: %v", hash, err)
+		return
+	}
+	metrics.ScrubRestoredTotal.WithLabelValues("upload").Inc()
+	w.mu.Lock()
+	w.status.Restored++
+	w.mu.Unlock()
+	w.updateQuarantineGauge()
+	log.Printf("Scrubber restored %d versions of blob %s from a new upload of the same content", len(flagged), hash)
+}
+
+// relink points the files of the given versions at the restored blob and clears their flag
+func (w *Scrubber) relink(hash string, rows []*database.ObjectRow, source string) error {
+	s := w.store
+	for _, row := range rows {
+		if err := s.relinkFile(hash, s.rowPath(row)); err != nil {
+			return fmt.Errorf("relinking %s/%s (version %s): %w", row.Bucket, row.Key, row.VersionID, err)
+		}
+	}
+	if _, err := s.DB.SetContentCorrupted(hash, false); err != nil {
+		return err
+	}
+	return s.DB.MarkBlobRestored(hash, source)
+}
+
+func (w *Scrubber) updateQuarantineGauge() {
+	blobs, err := w.store.DB.ListQuarantinedBlobs()
+	if err != nil {
+		return
+	}
+	waiting := 0
+	for _, b := range blobs {
+		if b.RestoredAt == nil {
+			waiting++
+		}
+	}
+	metrics.QuarantinedBlobs.Set(float64(waiting))
+}
+
+// rowEncoding returns the encryption and compression a version was stored with
+func rowEncoding(row *database.ObjectRow) (encryption, compression string) {
+	if row.EncryptionType != nil {
+		encryption = *row.EncryptionType
+	}
+	if row.CompressionType != nil {
+		compression = *row.CompressionType
+	}
+	return encryption, compression
+}
+
+func formatRate(rate int64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	return formatBytes(rate) + "/s"
+}
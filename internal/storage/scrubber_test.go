@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+func TestScrubberQuarantine(t *testing.T) {
+	store := newTestStorage(t, Options{})
+	db, root := store.DB, store.Root
+	store.CreateBucket("plain")
+	store.CreateBucket("copies")
+
+	read := func(bucket, key string) (string, error) {
+		t.Helper()
+		reader, _, err := store.GetObject(bucket, key, "")
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		return string(data), err
+	}
+	put := func(bucket, key, content, encryption string) string {
+		t.Helper()
+		if _, err := store.PutObject(bucket, key, strings.NewReader(content), encryption); err != nil {
+			t.Fatal(err)
+		}
+		row, _ := db.GetObject(bucket, key, "simple")
+		return *row.ContentHash
+	}
+
+	// Encrypted and compressed content is stored intact, so the scrubber leaves it alone
+	secret := strings.Repeat("encrypted and compressed ", 5000)
+	put("plain", "secret.txt", secret, "AES256")
+	if got, err := read("plain", "secret.txt"); err != nil || got != secret {
+		t.Fatalf("encrypted text read back as %d bytes, %v", len(got), err)
+	}
+
+	// Two versions share a blob that rots
+	rotten := put("plain", "rotten.bin", "original content", "")
+	put("copies", "rotten.bin", "original content", "")
+	os.WriteFile(store.casPath(rotten), []byte("flipped content!"), 0644)
+
+	// A replicated version is fetched back from the replica
+	replicated := strings.Repeat("replicated ", 100)
+	replicatedHash := put("plain", "docs/replicated.txt", replicated, "AES256")
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/backup/docs/replicated.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, replicated)
+	}))
+	defer remote.Close()
+	targetID, err := db.CreateReplicationTarget(&database.ReplicationTargetRow{
+		Name: "backup", Endpoint: remote.URL, AccessKey: "key", SecretKey: "secret", UsePathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := "docs/"
+	db.CreateReplicationRule(&database.ReplicationRow{SourceBucket: "plain", DestinationBucket: "backup", Prefix: &prefix, Enabled: true, TargetID: &targetID})
+	os.Truncate(store.casPath(replicatedHash), 40)
+
+	scrubber := NewScrubber(store, 0, 1<<30)
+	scrubber.Scrub()
+	status := scrubber.Status()
+	if status.Blobs != 3 || status.Corrupted != 2 || status.Restored != 1 || status.Progress != 1 || status.LastPassAt == nil {
+		t.Fatalf("status = %+v", status)
+	}
+	if cursor, _ := db.GetSystemSetting(scrubCursorSetting); cursor != "" {
+		t.Errorf("cursor %q left after a complete pass", cursor)
+	}
+
+	if got, err := read("plain", "docs/replicated.txt"); err != nil || got != replicated {
+		t.Errorf("replicated.txt after restore = %d bytes, %v", len(got), err)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".quarantine", rotten)); err != nil {
+		t.Errorf("rotten blob not quarantined: %v", err)
+	}
+	for _, bucket := range []string{"plain", "copies"} {
+		if _, err := read(bucket, "rotten.bin"); !errors.Is(err, ErrObjectCorrupted) {
+			t.Errorf("reading %s/rotten.bin = %v, want ErrObjectCorrupted", bucket, err)
+		}
+	}
+	quarantined, _ := db.ListQuarantinedBlobs()
+	if len(quarantined) != 2 || quarantined[0].Hash != rotten || quarantined[0].Objects != 2 || quarantined[0].RestoredAt != nil ||
+		quarantined[1].RestoredFrom == nil || !strings.Contains(*quarantined[1].RestoredFrom, "backup/docs/replicated.txt") {
+		t.Fatalf("quarantined = %+v", quarantined)
+	}
+	report, err := store.Fsck(FsckOptions{Repair: true, Quick: true})
+	if err != nil || report.Summary[FsckCorrupted] != 2 || report.Unrepaired() != 2 {
+		t.Fatalf("fsck = %+v, %v", report, err)
+	}
+
+	// Uploading the same content again stores an intact blob that heals the flagged versions
+	put("plain", "again.bin", "original content", "")
+	scrubber.Scrub()
+	for _, bucket := range []string{"plain", "copies"} {
+		if got, err := read(bucket, "rotten.bin"); err != nil || got != "original content" {
+			t.Errorf("%s/rotten.bin after a new upload = %q, %v", bucket, got, err)
+		}
+	}
+	if quarantined, _ := db.ListQuarantinedBlobs(); quarantined[0].RestoredAt == nil && quarantined[1].RestoredAt == nil {
+		t.Errorf("quarantine not marked restored: %+v", quarantined)
+	}
+	if report, _ := store.Fsck(FsckOptions{}); len(report.Issues) != 0 {
+		t.Errorf("fsck issues after healing = %+v", report.Issues)
+	}
+}
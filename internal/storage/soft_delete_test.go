@@ -5,19 +5,11 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
-
-	"github.com/GravSpace/GravSpace/internal/database"
 )
 
 func TestSoftDeleteWorkflow(t *testing.T) {
-	root := t.TempDir()
-	dbPath := filepath.Join(root, "test.db")
-	db, _ := database.NewDatabase(dbPath)
-
-	store := &FileStorage{
-		Root: root,
-		DB:   db,
-	}
+	store := newTestStorage(t, Options{})
+	db, root := store.DB, store.Root
 
 	bucket := "recycle-test"
 	store.CreateBucket(bucket)
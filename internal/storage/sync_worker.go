@@ -5,21 +5,27 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/GravSpace/GravSpace/internal/cache"
 	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/health"
+	"github.com/GravSpace/GravSpace/internal/metrics"
 	"github.com/fsnotify/fsnotify"
 )
 
 // SyncWorker handles periodic and event-driven filesystem-to-database synchronization
 type SyncWorker struct {
 	storage    *FileStorage
-	interval   time.Duration
+	interval   *intervalTicker
 	stopChan   chan bool
 	watcher    *fsnotify.Watcher
 	debounceMs int
 	dirtyPaths map[string]time.Time
+	heartbeat  *health.Heartbeat
+	waitGroup  sync.WaitGroup
+	stopOnce   sync.Once
 }
 
 // NewSyncWorker creates a new sync worker with 2s default debouncing
@@ -31,7 +37,7 @@ func NewSyncWorker(storage *FileStorage, interval time.Duration) *SyncWorker {
 
 	return &SyncWorker{
 		storage:    storage,
-		interval:   interval,
+		interval:   newIntervalTicker(interval),
 		stopChan:   make(chan bool),
 		watcher:    watcher,
 		debounceMs: 2000,
@@ -41,23 +47,41 @@ func NewSyncWorker(storage *FileStorage, interval time.Duration) *SyncWorker {
 
 // Start begins the sync worker
 func (sw *SyncWorker) Start() {
+	sw.waitGroup.Add(1)
 	go sw.run()
 }
 
-// Stop stops the sync worker
+// Stop stops the sync worker, waiting for a running sync to finish
 func (sw *SyncWorker) Stop() {
-	if sw.watcher != nil {
-		sw.watcher.Close()
+	sw.stopOnce.Do(func() {
+		close(sw.stopChan)
+		sw.waitGroup.Wait()
+		if sw.watcher != nil {
+			sw.watcher.Close()
+		}
+	})
+}
+
+// SetInterval changes the interval of the periodic full sync
+func (sw *SyncWorker) SetInterval(interval time.Duration) {
+	if sw != nil && sw.interval.set(interval) {
+		log.Printf("Sync worker interval set to %v", interval)
 	}
-	sw.stopChan <- true
 }
 
 func (sw *SyncWorker) run() {
+	defer sw.waitGroup.Done()
+
 	// Start watching root and buckets
 	sw.setupWatcher()
 
-	ticker := time.NewTicker(sw.interval)
-	defer ticker.Stop()
+	ticks := sw.interval.start()
+	defer sw.interval.stop()
+
+	sw.heartbeat = health.Worker("sync")
+	sw.heartbeat.Beat()
+	heartbeatTicker := time.NewTicker(health.HeartbeatInterval)
+	defer heartbeatTicker.Stop()
 
 	debounceTicker := time.NewTicker(500 * time.Millisecond)
 	defer debounceTicker.Stop()
@@ -67,7 +91,7 @@ func (sw *SyncWorker) run() {
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticks:
 			// Full periodic sync fallback
 			sw.syncFilesystemToDatabase()
 
@@ -75,6 +99,9 @@ func (sw *SyncWorker) run() {
 			// Check for debounced paths
 			sw.processDirtyPaths()
 
+		case <-heartbeatTicker.C:
+			sw.heartbeat.Beat()
+
 		case event, ok := <-sw.watcher.Events:
 			if !ok {
 				return
@@ -203,6 +230,7 @@ func (sw *SyncWorker) syncFilesystemToDatabase() {
 		}
 
 		bucketName := bucketEntry.Name()
+		sw.heartbeat.Beat()
 
 		// Ensure bucket exists in database
 		exists, _ := sw.storage.DB.BucketExists(bucketName)
@@ -260,6 +288,7 @@ func (sw *SyncWorker) syncFilesystemToDatabase() {
 					if err == nil {
 						synced++
 						affectedBuckets[bucketName] = true
+						metrics.SyncRecordsFixedTotal.WithLabelValues("indexed").Inc()
 					} else {
 						log.Printf("Sync error creating object %s in DB: %v\n", relPath, err)
 						errors++
@@ -270,6 +299,7 @@ func (sw *SyncWorker) syncFilesystemToDatabase() {
 					if err == nil {
 						synced++
 						affectedBuckets[bucketName] = true
+						metrics.SyncRecordsFixedTotal.WithLabelValues("restored").Inc()
 						log.Printf("Ghost object detected: un-deleted %s/%s\n", bucketName, relPath)
 					} else {
 						log.Printf("Sync error restoring ghost object %s: %v\n", relPath, err)
@@ -299,6 +329,7 @@ func (sw *SyncWorker) syncFilesystemToDatabase() {
 					sw.storage.DB.CreateObject(objectRow)
 					synced++
 					affectedBuckets[bucketName] = true
+					metrics.SyncRecordsFixedTotal.WithLabelValues("folder").Inc()
 					log.Printf("Indexed folder placeholder: %s/%s\n", bucketName, folderKey)
 				}
 				return nil
@@ -315,9 +346,14 @@ func (sw *SyncWorker) syncFilesystemToDatabase() {
 
 			// Get file info
 			versionPath := filepath.Join(path, versionID)
-			versionInfo, err := os.Stat(versionPath)
+			versionInfo, err := statObjectFile(versionPath)
 			if err != nil {
 				if os.IsNotExist(err) {
+					// Archived versions live in the cold tier, so a missing file is expected
+					if dbObj, _ := sw.storage.DB.GetObject(bucketName, relPath, versionID); isArchivedRow(dbObj) {
+						return filepath.SkipDir
+					}
+
 					log.Printf("Warning: Latest version %s for %s missing. Attempting repair...", versionID, relPath)
 
 					entries, readErr := os.ReadDir(path)
@@ -344,6 +380,7 @@ func (sw *SyncWorker) syncFilesystemToDatabase() {
 							// Update latest pointer
 							if wErr := os.WriteFile(latestPath, []byte(newestName), 0644); wErr == nil {
 								log.Printf("Repaired: Promoted %s to latest for %s", newestName, relPath)
+								metrics.SyncRecordsFixedTotal.WithLabelValues("latest_pointer").Inc()
 								versionID = newestName
 								versionPath = filepath.Join(path, versionID)
 								versionInfo, err = os.Stat(versionPath) // Retry stat
@@ -354,6 +391,7 @@ func (sw *SyncWorker) syncFilesystemToDatabase() {
 							// No versions found, remove broken pointer
 							os.Remove(latestPath)
 							log.Printf("Removed broken latest pointer for %s (no versions found)", relPath)
+							metrics.SyncRecordsFixedTotal.WithLabelValues("latest_pointer").Inc()
 							return filepath.SkipDir // Treat as deleted
 						}
 					}
@@ -390,6 +428,7 @@ func (sw *SyncWorker) syncFilesystemToDatabase() {
 				if err == nil {
 					synced++
 					affectedBuckets[bucketName] = true
+					metrics.SyncRecordsFixedTotal.WithLabelValues("indexed").Inc()
 				} else {
 					log.Printf("Sync error creating object %s in DB: %v\n", relPath, err)
 					errors++
@@ -400,6 +439,7 @@ func (sw *SyncWorker) syncFilesystemToDatabase() {
 				if err == nil {
 					synced++
 					affectedBuckets[bucketName] = true
+					metrics.SyncRecordsFixedTotal.WithLabelValues("restored").Inc()
 					log.Printf("Ghost object detected: un-deleted %s/%s\n", bucketName, relPath)
 				} else {
 					log.Printf("Sync error restoring ghost object %s: %v\n", relPath, err)
@@ -411,6 +451,7 @@ func (sw *SyncWorker) syncFilesystemToDatabase() {
 				if err == nil {
 					synced++
 					affectedBuckets[bucketName] = true
+					metrics.SyncRecordsFixedTotal.WithLabelValues("is_latest").Inc()
 					log.Printf("Fixed is_latest for %s/%s\n", bucketName, relPath)
 				} else {
 					log.Printf("Sync error updating is_latest for %s: %v\n", relPath, err)
@@ -432,6 +473,11 @@ func (sw *SyncWorker) syncFilesystemToDatabase() {
 	// Prune orphaned records
 	prunedCount := sw.pruneOrphanedRecords()
 	sw.pruneOrphanedBuckets()
+	metrics.RecordWorkerRun("sync", time.Since(startTime))
+
+	for bucket := range affectedBuckets {
+		sw.storage.Metrics.MarkDirty(bucket)
+	}
 
 	// Invalidate cache for affected buckets
 	if sw.storage.Cache != nil {
@@ -463,6 +509,10 @@ func (sw *SyncWorker) pruneOrphanedRecords() int {
 		if obj.DeletedAt != nil {
 			continue
 		}
+		// Archived versions have no file in the primary tier
+		if isArchivedRow(obj) {
+			continue
+		}
 
 		objectDir := filepath.Join(sw.storage.Root, obj.Bucket, obj.Key)
 		versionPath := filepath.Join(objectDir, obj.VersionID)
@@ -472,7 +522,7 @@ func (sw *SyncWorker) pruneOrphanedRecords() int {
 			versionPath = filepath.Join(sw.storage.Root, obj.Bucket, obj.Key)
 		}
 
-		if _, err := os.Stat(versionPath); os.IsNotExist(err) {
+		if _, err := statObjectFile(versionPath); os.IsNotExist(err) {
 			// Physical file/directory missing, prune DB record
 			err := sw.storage.DB.DeleteObject(obj.Bucket, obj.Key, obj.VersionID)
 			if err != nil {
@@ -480,6 +530,8 @@ func (sw *SyncWorker) pruneOrphanedRecords() int {
 			} else {
 				prunedCount++
 				affectedBuckets[obj.Bucket] = true
+				metrics.SyncRecordsFixedTotal.WithLabelValues("pruned_object").Inc()
+				sw.storage.Metrics.MarkDirty(obj.Bucket)
 			}
 		}
 	}
@@ -512,6 +564,7 @@ func (sw *SyncWorker) pruneOrphanedBuckets() {
 				log.Printf("Prune error deleting bucket %s: %v\n", bucketName, err)
 			} else {
 				prunedCount++
+				metrics.SyncRecordsFixedTotal.WithLabelValues("pruned_bucket").Inc()
 				log.Printf("Pruned orphaned bucket: %s\n", bucketName)
 			}
 		}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SweepTempFiles removes the ".tmp-" files that uploads, multipart completions and tier moves
+// leave behind when the process dies mid-write. Only files last modified before the cutoff are
+// removed, so writes that started after it are not touched.
+func (s *FileStorage) SweepTempFiles(before time.Time) int {
+	removed := 0
+	roots := []string{s.Root, s.ColdRoot}
+	// Blob copies and erasure shards on the other data drives are written to temp files as well
+	if s.Drives != nil {
+		for i, drive := range s.Drives.Drives() {
+			if i > 0 && drive.Online {
+				roots = append(roots, filepath.Join(drive.Path, ".cas"), filepath.Join(drive.Path, erasureDir))
+			}
+		}
+	}
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.Contains(d.Name(), ".tmp-") {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || !info.ModTime().Before(before) {
+				return nil
+			}
+			if err := os.Remove(path); err != nil {
+				log.Printf("Failed to remove stale temp file %s: %v", path, err)
+				return nil
+			}
+			removed++
+			return nil
+		})
+	}
+	if removed > 0 {
+		log.Printf("Removed %d stale temp files", removed)
+	}
+	return removed
+}
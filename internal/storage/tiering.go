@@ -0,0 +1,412 @@
+package storage
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/cache"
+	"github.com/GravSpace/GravSpace/internal/database"
+)
+
+const (
+	StorageClassStandard    = "STANDARD"
+	StorageClassGlacier     = "GLACIER"
+	StorageClassDeepArchive = "DEEP_ARCHIVE"
+
+	restoreStatusOngoing  = "ongoing"
+	restoreStatusRestored = "restored"
+)
+
+var (
+	// ErrInvalidObjectState is returned when an archived object is read without an active restore
+	ErrInvalidObjectState = errors.New("The operation is not valid for the object's storage class")
+	// ErrRestoreInProgress is returned when a restore is requested while another one is running
+	ErrRestoreInProgress = errors.New("Object restore is already in progress")
+)
+
+// IsArchivedStorageClass reports whether objects of the given class live in the cold tier
+func IsArchivedStorageClass(class string) bool {
+	return class == StorageClassGlacier || class == StorageClassDeepArchive
+}
+
+func isArchivedRow(row *database.ObjectRow) bool {
+	return row != nil && row.StorageClass != nil && IsArchivedStorageClass(*row.StorageClass)
+}
+
+// objectPath returns the primary-tier path of an object version
+func (s *FileStorage) objectPath(bucket, key, versionID string) string {
+	if versionID == "simple" || versionID == "legacy" || versionID == "folder" {
+		return filepath.Join(s.Root, bucket, key)
+	}
+	return filepath.Join(s.Root, bucket, key, versionID)
+}
+
+// coldPath returns the cold-tier path of an archived version. Compressed archives carry a .gz suffix.
+func (s *FileStorage) coldPath(bucket, key, versionID string) string {
+	return filepath.Join(s.ColdRoot, bucket, key, versionID)
+}
+
+// restoredPath returns where the temporary copy of a restored archive is kept
+func (s *FileStorage) restoredPath(bucket, key, versionID string) string {
+	return filepath.Join(s.Root, ".restored", bucket, key, versionID)
+}
+
+// TransitionObject moves an object version into the cold tier. The stored bytes are
+// archived as-is (still encrypted/compressed as on disk), optionally gzipped again.
+func (s *FileStorage) TransitionObject(row *database.ObjectRow, storageClass string) error {
+	if s.DB == nil {
+		return fmt.Errorf("database not available")
+	}
+	if s.ColdRoot == "" {
+		return fmt.Errorf("cold storage tier is not configured (COLD_STORAGE_PATH)")
+	}
+	if !IsArchivedStorageClass(storageClass) {
+		return fmt.Errorf("unsupported transition storage class: %s", storageClass)
+	}
+
+	// Already archived (e.g. GLACIER -> DEEP_ARCHIVE): the bytes stay where they are
+	if isArchivedRow(row) {
+		return s.DB.SetObjectStorageClass(row.Bucket, row.Key, row.VersionID, storageClass)
+	}
+
+	srcPath := s.objectPath(row.Bucket, row.Key, row.VersionID)
+	hash := ""
+	if row.ContentHash != nil {
+		hash = *row.ContentHash
+	}
+	src, err := s.openObjectFile(srcPath, hash)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dstPath := s.coldPath(row.Bucket, row.Key, row.VersionID)
+	if s.ColdCompression {
+		dstPath += ".gz"
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := dstPath + ".tmp-" + row.VersionID
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = tmpFile
+	var gzipWriter *gzip.Writer
+	if s.ColdCompression {
+		gzipWriter = gzip.NewWriter(tmpFile)
+		w = gzipWriter
+	}
+
+	buf := bufferPool.Get().([]byte)
+	_, err = io.CopyBuffer(w, src, buf)
+	bufferPool.Put(buf)
+	if err == nil && gzipWriter != nil {
+		err = gzipWriter.Close()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, dstPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := s.DB.SetObjectStorageClass(row.Bucket, row.Key, row.VersionID, storageClass); err != nil {
+		os.Remove(dstPath)
+		return err
+	}
+
+	// Drop the primary-tier hard link; the CAS blob goes once nothing else references it
+	os.Remove(srcPath)
+	if row.ContentHash != nil {
+		s.cleanOrphanedCAS(*row.ContentHash)
+	}
+
+	s.invalidateObjectListCache(row.Bucket, row.Key)
+	if s.Cache != nil {
+		s.Cache.Delete(cache.ObjectMetadataKey(row.Bucket, row.Key, row.VersionID))
+	}
+
+	log.Printf("Lifecycle: transitioned %s/%s (version: %s) to %s", row.Bucket, row.Key, row.VersionID, storageClass)
+	return nil
+}
+
+// RestoreArchivedObject requests a temporary copy of an archived object for the given
+// number of days. It returns true when a restored copy already existed and only its
+// expiry was extended; otherwise the restore runs in the background.
+func (s *FileStorage) RestoreArchivedObject(bucket, key, versionID string, days int) (bool, error) {
+	if s.DB == nil {
+		return false, fmt.Errorf("database not available")
+	}
+	if days <= 0 {
+		return false, fmt.Errorf("restore days must be a positive integer")
+	}
+
+	row, err := s.DB.GetObject(bucket, key, versionID)
+	if err != nil {
+		return false, err
+	}
+	if row == nil {
+		return false, os.ErrNotExist
+	}
+	if !isArchivedRow(row) {
+		return false, ErrInvalidObjectState
+	}
+
+	if row.RestoreStatus != nil {
+		switch *row.RestoreStatus {
+		case restoreStatusOngoing:
+			return false, ErrRestoreInProgress
+		case restoreStatusRestored:
+			status := restoreStatusRestored
+			expiresAt := restoreExpiry(days)
+			return true, s.DB.SetObjectRestoreStatus(bucket, key, row.VersionID, &status, &expiresAt)
+		}
+	}
+
+	started, err := s.DB.StartObjectRestore(bucket, key, row.VersionID, restoreStatusOngoing)
+	if err != nil {
+		return false, err
+	}
+	if !started {
+		return false, ErrRestoreInProgress
+	}
+
+	job := &RestoreObjectJob{Storage: s, Bucket: bucket, Key: key, VersionID: row.VersionID, Days: days}
+	if s.Jobs == nil {
+		go func() {
+			if err := job.Execute(); err != nil {
+				job.Dead(err)
+			}
+		}()
+		return false, nil
+	}
+	if _, err := s.Jobs.Enqueue(job); err != nil {
+		// Clear the ongoing marker, or every later request would be refused as in progress
+		if resetErr := s.DB.SetObjectRestoreStatus(bucket, key, row.VersionID, nil, nil); resetErr != nil {
+			log.Printf("Failed to reset the restore status of %s/%s (version: %s): %v", bucket, key, row.VersionID, resetErr)
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// restoreExpiry returns midnight UTC of the day after the requested number of days, as S3 does
+func restoreExpiry(days int) time.Time {
+	return time.Now().UTC().AddDate(0, 0, days+1).Truncate(24 * time.Hour)
+}
+
+// RestoreObjectJob implements jobs.Job for copying an archived object back to the primary tier
+type RestoreObjectJob struct {
+	Storage   *FileStorage `json:"-"`
+	Bucket    string       `json:"bucket"`
+	Key       string       `json:"key"`
+	VersionID string       `json:"version_id"`
+	Days      int          `json:"days"`
+}
+
+func (j *RestoreObjectJob) Type() string {
+	return JobTypeRestoreObject
+}
+
+func (j *RestoreObjectJob) Name() string {
+	return "RestoreObject:" + j.Bucket + "/" + j.Key
+}
+
+func (j *RestoreObjectJob) UniqueKey() string {
+	return j.Type() + ":" + j.Bucket + "/" + j.Key + "/" + j.VersionID
+}
+
+func (j *RestoreObjectJob) Execute() error {
+	s := j.Storage
+	if err := s.copyFromColdTier(j.Bucket, j.Key, j.VersionID); err != nil {
+		return fmt.Errorf("restore failed for %s/%s: %w", j.Bucket, j.Key, err)
+	}
+
+	status := restoreStatusRestored
+	expiresAt := restoreExpiry(j.Days)
+	if err := s.DB.SetObjectRestoreStatus(j.Bucket, j.Key, j.VersionID, &status, &expiresAt); err != nil {
+		return err
+	}
+	if s.Cache != nil {
+		s.Cache.Delete(cache.ObjectMetadataKey(j.Bucket, j.Key, j.VersionID))
+	}
+	log.Printf("Restored %s/%s (version: %s) until %s", j.Bucket, j.Key, j.VersionID, expiresAt.Format(time.RFC3339))
+	return nil
+}
+
+// Dead clears the ongoing marker once the restore is given up on, so it can be requested again
+func (j *RestoreObjectJob) Dead(err error) {
+	s := j.Storage
+	s.DB.SetObjectRestoreStatus(j.Bucket, j.Key, j.VersionID, nil, nil)
+	if s.Notifier != nil {
+		s.Notifier.SendAlert("Object Restore Failed", fmt.Sprintf("Failed to restore %s/%s (version: %s): %v", j.Bucket, j.Key, j.VersionID, err))
+	}
+}
+
+func (s *FileStorage) copyFromColdTier(bucket, key, versionID string) error {
+	srcPath := s.coldPath(bucket, key, versionID)
+	compressed := false
+	if _, err := os.Stat(srcPath + ".gz"); err == nil {
+		srcPath += ".gz"
+		compressed = true
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var r io.Reader = src
+	if compressed {
+		gzReader, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer gzReader.Close()
+		r = gzReader
+	}
+
+	dstPath := s.restoredPath(bucket, key, versionID)
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+	tmpPath := dstPath + ".tmp-" + versionID
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	buf := bufferPool.Get().([]byte)
+	_, err = io.CopyBuffer(tmpFile, r, buf)
+	bufferPool.Put(buf)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, dstPath)
+}
+
+// expireRestoredCopies removes temporary restored copies whose expiry has passed
+func (s *FileStorage) expireRestoredCopies() {
+	if s.DB == nil {
+		return
+	}
+	expired, err := s.DB.GetExpiredRestores()
+	if err != nil {
+		log.Printf("Lifecycle error: failed to list expired restores: %v", err)
+		return
+	}
+	for _, obj := range expired {
+		os.Remove(s.restoredPath(obj.Bucket, obj.Key, obj.VersionID))
+		s.DB.SetObjectRestoreStatus(obj.Bucket, obj.Key, obj.VersionID, nil, nil)
+		if s.Cache != nil {
+			s.Cache.Delete(cache.ObjectMetadataKey(obj.Bucket, obj.Key, obj.VersionID))
+		}
+	}
+	if len(expired) > 0 {
+		log.Printf("Lifecycle: expired %d restored archive copies", len(expired))
+	}
+}
+
+// removeArchivedCopies deletes the cold-tier archive and any restored copy of a version
+func (s *FileStorage) removeArchivedCopies(bucket, key, versionID string) {
+	if s.ColdRoot != "" {
+		coldPath := s.coldPath(bucket, key, versionID)
+		os.Remove(coldPath)
+		os.Remove(coldPath + ".gz")
+	}
+	os.Remove(s.restoredPath(bucket, key, versionID))
+}
+
+// openArchivedObject opens the restored copy of an archived version, or fails with
+// ErrInvalidObjectState when no unexpired restore exists.
+func (s *FileStorage) openArchivedObject(bucket string, obj *Object) (*os.File, error) {
+	if obj.RestoreStatus != restoreStatusRestored || (obj.RestoreExpiresAt != nil && time.Now().After(*obj.RestoreExpiresAt)) {
+		return nil, ErrInvalidObjectState
+	}
+	f, err := os.Open(s.restoredPath(bucket, obj.Key, obj.VersionID))
+	if os.IsNotExist(err) {
+		return nil, ErrInvalidObjectState
+	}
+	return f, err
+}
+
+// RestoreHeader formats the x-amz-restore header value for an archived object, or returns
+// an empty string when no restore has been requested.
+func (o *Object) RestoreHeader() string {
+	switch o.RestoreStatus {
+	case restoreStatusOngoing:
+		return `ongoing-request="true"`
+	case restoreStatusRestored:
+		if o.RestoreExpiresAt != nil {
+			return fmt.Sprintf(`ongoing-request="false", expiry-date="%s"`, o.RestoreExpiresAt.UTC().Format(time.RFC1123))
+		}
+		return `ongoing-request="false"`
+	}
+	return ""
+}
+
+// objectFromRow builds an Object from its metadata record
+func objectFromRow(row *database.ObjectRow) *Object {
+	obj := &Object{
+		Key:              row.Key,
+		VersionID:        row.VersionID,
+		Size:             row.Size,
+		IsLatest:         row.IsLatest,
+		ModTime:          row.ModifiedAt,
+		RetainUntilDate:  row.RetainUntilDate,
+		LegalHold:        row.LegalHold,
+		RestoreExpiresAt: row.RestoreExpiresAt,
+	}
+	if row.EncryptionType != nil {
+		obj.EncryptionType = *row.EncryptionType
+	}
+	if row.LockMode != nil {
+		obj.LockMode = *row.LockMode
+	}
+	if row.ContentType != nil {
+		obj.ContentType = *row.ContentType
+	}
+	if row.CompressionType != nil {
+		obj.CompressionType = *row.CompressionType
+	}
+	if row.ContentHash != nil {
+		obj.ContentHash = *row.ContentHash
+	}
+	if row.OriginalSize != nil {
+		obj.OriginalSize = *row.OriginalSize
+	}
+	if row.StorageClass != nil {
+		obj.StorageClass = *row.StorageClass
+	}
+	if row.RestoreStatus != nil {
+		obj.RestoreStatus = *row.RestoreStatus
+	}
+	if row.WebsiteRedirectLocation != nil {
+		obj.WebsiteRedirectLocation = *row.WebsiteRedirectLocation
+	}
+	if row.ReplicationStatus != nil {
+		obj.ReplicationStatus = *row.ReplicationStatus
+	}
+	obj.Corrupted = row.CorruptedAt != nil
+	return obj
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/jobs"
+)
+
+func TestTransitionRestoreAndExpiry(t *testing.T) {
+	store := newTestStorage(t, Options{ColdRoot: t.TempDir(), ColdCompression: true})
+	db := store.DB
+	store.CreateBucket("photos")
+	if _, err := store.PutObject("photos", "a.txt", strings.NewReader("archived content"), ""); err != nil {
+		t.Fatal(err)
+	}
+	read := func() (string, error) {
+		t.Helper()
+		reader, _, err := store.GetObject("photos", "a.txt", "")
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		return string(data), err
+	}
+	status := func() *database.ObjectRow {
+		t.Helper()
+		row, err := db.GetObject("photos", "a.txt", "simple")
+		if err != nil || row == nil {
+			t.Fatalf("object row = %v, %v", row, err)
+		}
+		return row
+	}
+
+	if err := store.TransitionObject(status(), StorageClassGlacier); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.coldPath("photos", "a.txt", "simple") + ".gz"); err != nil {
+		t.Fatalf("no compressed archive: %v", err)
+	}
+	if _, err := read(); !errors.Is(err, ErrInvalidObjectState) {
+		t.Fatalf("read of an archived object = %v, want ErrInvalidObjectState", err)
+	}
+
+	// A restore that cannot be queued leaves the object restorable
+	store.Jobs = jobs.NewManager(db, 1)
+	if _, err := store.RestoreArchivedObject("photos", "a.txt", "", 1); err == nil {
+		t.Fatal("restore queued without a registered job type")
+	}
+	if row := status(); row.RestoreStatus != nil {
+		t.Fatalf("restore status after a failed enqueue = %q", *row.RestoreStatus)
+	}
+
+	// A request made while a restore is queued finds it in progress
+	store.Jobs.Register(JobTypeRestoreObject, 3, func() jobs.Job { return &RestoreObjectJob{Storage: store} })
+	if _, err := store.RestoreArchivedObject("photos", "a.txt", "", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RestoreArchivedObject("photos", "a.txt", "", 1); !errors.Is(err, ErrRestoreInProgress) {
+		t.Fatalf("second restore = %v, want ErrRestoreInProgress", err)
+	}
+	if queued, err := db.ListJobs("", JobTypeRestoreObject, 10); err != nil || len(queued) != 1 {
+		t.Fatalf("restore jobs queued = %d, %v", len(queued), err)
+	}
+	if (&RestoreObjectJob{Bucket: "photos", Key: "a.txt", VersionID: "simple"}).UniqueKey() == (&RestoreObjectJob{Bucket: "photos", Key: "a.txt", VersionID: "v2"}).UniqueKey() {
+		t.Error("restores of two versions share a unique key")
+	}
+	if err := db.SetObjectRestoreStatus("photos", "a.txt", "simple", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Jobs = nil
+	if extended, err := store.RestoreArchivedObject("photos", "a.txt", "", 1); err != nil || extended {
+		t.Fatalf("restore = %v, %v", extended, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for row := status(); row.RestoreStatus == nil || *row.RestoreStatus != restoreStatusRestored; row = status() {
+		if time.Now().After(deadline) {
+			t.Fatalf("restore did not finish: %+v", row)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if data, err := read(); err != nil || data != "archived content" {
+		t.Fatalf("read of a restored object = %q, %v", data, err)
+	}
+	if extended, err := store.RestoreArchivedObject("photos", "a.txt", "", 3); err != nil || !extended {
+		t.Fatalf("restore of a restored object = %v, %v", extended, err)
+	}
+	if row := status(); row.RestoreExpiresAt == nil || !row.RestoreExpiresAt.Equal(restoreExpiry(3)) {
+		t.Errorf("extended expiry = %v, want %v", row.RestoreExpiresAt, restoreExpiry(3))
+	}
+
+	// Once the restore expires the copy is removed and reads fail again
+	restored, past := restoreStatusRestored, time.Now().UTC().Add(-time.Minute)
+	if err := db.SetObjectRestoreStatus("photos", "a.txt", "simple", &restored, &past); err != nil {
+		t.Fatal(err)
+	}
+	store.expireRestoredCopies()
+	if row := status(); row.RestoreStatus != nil || row.RestoreExpiresAt != nil {
+		t.Errorf("restore after expiry = %v, %v", row.RestoreStatus, row.RestoreExpiresAt)
+	}
+	if _, err := os.Stat(store.restoredPath("photos", "a.txt", "simple")); !os.IsNotExist(err) {
+		t.Errorf("restored copy left after expiry: %v", err)
+	}
+	if _, err := read(); !errors.Is(err, ErrInvalidObjectState) {
+		t.Errorf("read after the restore expired = %v, want ErrInvalidObjectState", err)
+	}
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestPutObjectSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer provider.Shutdown(context.Background())
+
+	store := newTestStorage(t, Options{})
+	db := store.DB
+	store.CreateBucket("traced-bucket")
+	store.SetBucketQuota("traced-bucket", 1<<20)
+
+	// Without a span in the context nothing is recorded
+	if _, err := store.PutObject("traced-bucket", "untraced.txt", strings.NewReader("hello"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(recorder.Ended()); n != 0 {
+		t.Fatalf("recorded %d spans outside a trace", n)
+	}
+
+	ctx, request := provider.Tracer("test").Start(context.Background(), "request")
+	if _, err := store.WithContext(ctx).PutObject("traced-bucket", "doc.txt", strings.NewReader("hello world"), ""); err != nil {
+		t.Fatal(err)
+	}
+	request.End()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	put, ok := spans["storage.PutObject"]
+	if !ok || put.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Fatalf("storage.PutObject is not a child of the request span")
+	}
+	for _, name := range []string{"storage.QuotaCheck", "storage.Write", "storage.CASLink", "db.CreateObject"} {
+		s, ok := spans[name]
+		if !ok {
+			t.Fatalf("missing span %s", name)
+		}
+		if s.Parent().SpanID() != put.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of storage.PutObject", name)
+		}
+	}
+	if s, ok := spans["db.GetBucketStats"]; !ok || s.Parent().SpanID() != spans["storage.QuotaCheck"].SpanContext().SpanID() {
+		t.Errorf("db.GetBucketStats is not a child of storage.QuotaCheck")
+	}
+
+	// Failed queries mark their span, lookups that find nothing do not
+	recorder = tracetest.NewSpanRecorder()
+	provider.RegisterSpanProcessor(recorder)
+	ctx, request = provider.Tracer("test").Start(context.Background(), "request")
+	defer request.End()
+	traced := db.WithContext(ctx)
+	if err := traced.CreateBucket("traced-bucket", ""); err == nil {
+		t.Fatal("created a bucket twice")
+	}
+	if obj, err := traced.GetObject("traced-bucket", "missing.txt", ""); obj != nil || err != nil {
+		t.Fatalf("GetObject of a missing key = %v, %v", obj, err)
+	}
+	spans = map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	if s, ok := spans["db.CreateBucket"]; !ok || s.Status().Code != codes.Error {
+		t.Errorf("db.CreateBucket span of a failed insert is not marked failed")
+	}
+	if s, ok := spans["db.GetObject"]; !ok || s.Status().Code == codes.Error {
+		t.Errorf("db.GetObject span of a missing key is marked failed")
+	}
+}
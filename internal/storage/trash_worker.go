@@ -2,37 +2,80 @@ package storage
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/health"
+	"github.com/GravSpace/GravSpace/internal/metrics"
 )
 
 type TrashWorker struct {
-	db    *database.Database
-	store *FileStorage
+	db        *database.Database
+	store     *FileStorage
+	heartbeat *health.Heartbeat
+	interval  *intervalTicker
+
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
-func NewTrashWorker(db *database.Database, store *FileStorage) *TrashWorker {
+// NewTrashWorker creates a worker that purges expired trash every interval (hourly when zero)
+func NewTrashWorker(db *database.Database, store *FileStorage, interval time.Duration) *TrashWorker {
 	return &TrashWorker{
-		db:    db,
-		store: store,
+		db:       db,
+		store:    store,
+		interval: newIntervalTicker(orDefault(interval, time.Hour)),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
 	}
 }
 
 func (w *TrashWorker) Start() {
-	// Simple worker that runs every hour
+	w.heartbeat = health.Worker("trash")
+	w.heartbeat.Beat()
+
+	ticks := w.interval.start()
 	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
+		defer close(w.done)
+		defer w.interval.stop()
+		heartbeat := time.NewTicker(health.HeartbeatInterval)
+		defer heartbeat.Stop()
 
-		for range ticker.C {
-			w.ProcessTrash()
+		for {
+			select {
+			case <-w.quit:
+				return
+			case <-ticks:
+				w.ProcessTrash()
+			case <-heartbeat.C:
+			}
+			w.heartbeat.Beat()
 		}
 	}()
-	log.Println("Trash worker started (Interval: 1 hour)")
+	log.Printf("Trash worker started (Interval: %v)", w.interval.get())
+}
+
+// SetInterval changes how often expired trash is purged
+func (w *TrashWorker) SetInterval(interval time.Duration) {
+	if w.interval.set(interval) {
+		log.Printf("Trash worker interval set to %v", interval)
+	}
+}
+
+// Stop waits for a running cleanup to finish and stops the worker
+func (w *TrashWorker) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.quit)
+		<-w.done
+	})
 }
 
 func (w *TrashWorker) ProcessTrash() {
+	start := time.Now()
+	defer func() { metrics.RecordWorkerRun("trash", time.Since(start)) }()
+
 	buckets, err := w.db.ListBuckets()
 	if err != nil {
 		log.Printf("Trash worker error listing buckets: %v", err)
@@ -40,6 +83,7 @@ func (w *TrashWorker) ProcessTrash() {
 	}
 
 	for _, bName := range buckets {
+		w.heartbeat.Beat()
 		bucket, err := w.db.GetBucket(bName)
 		if err != nil || bucket == nil || !bucket.SoftDeleteEnabled {
 			continue
@@ -79,6 +123,7 @@ func (w *TrashWorker) ProcessTrash() {
 			if err := w.db.DeleteObjectsByID(idsToDelete); err != nil {
 				log.Printf("Trash worker error batch deleting from DB for bucket %s: %v", bName, err)
 			} else {
+				metrics.WorkerDeletionsTotal.WithLabelValues("trash").Add(float64(len(idsToDelete)))
 				log.Printf("Trash worker: successfully batch deleted %d metadata records for bucket %s", len(idsToDelete), bName)
 			}
 		}
@@ -1,28 +1,37 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
-	"os"
-
+	"github.com/GravSpace/GravSpace/internal/accesslog"
 	"github.com/GravSpace/GravSpace/internal/audit"
 	"github.com/GravSpace/GravSpace/internal/auth"
+	"github.com/GravSpace/GravSpace/internal/cache"
+	"github.com/GravSpace/GravSpace/internal/config"
+	"github.com/GravSpace/GravSpace/internal/crypto"
 	"github.com/GravSpace/GravSpace/internal/database"
 	"github.com/GravSpace/GravSpace/internal/health"
 	"github.com/GravSpace/GravSpace/internal/metrics"
-	"github.com/GravSpace/GravSpace/internal/notifications"
+	"github.com/GravSpace/GravSpace/internal/ratelimit"
 	"github.com/GravSpace/GravSpace/internal/s3"
 	"github.com/GravSpace/GravSpace/internal/storage"
-	"github.com/gin-contrib/cors"
+	"github.com/GravSpace/GravSpace/internal/tracing"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 // Version information (set via ldflags during build)
@@ -41,6 +50,7 @@ func main() {
 	// Parse command line flags
 	versionFlag := flag.Bool("version", false, "Print version information")
 	flag.BoolVar(versionFlag, "v", false, "Print version information (shorthand)")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "Path to a YAML or TOML configuration file")
 	flag.Parse()
 
 	// Handle version flag
@@ -52,68 +62,108 @@ func main() {
 		os.Exit(0)
 	}
 
+	// The file, then the environment, override the defaults; invalid settings stop the server
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	if cfg.Auth.SSEMasterKey != "" {
+		if err := crypto.SetMasterKey(cfg.Auth.SSEMasterKey); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid SSE master key: %v\n", err)
+			os.Exit(exitError)
+		}
+	}
+
+	// Maintenance commands run against the database and exit
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(cfg, flag.Args()))
+	}
+
 	// Initialize Apps with Gin
 	gin.SetMode(gin.ReleaseMode)
-	adminApp := gin.Default()
-	s3App := gin.Default()
+	adminApp := gin.New()
+	s3App := gin.New()
 
 	// Log startup information
 	log.Printf("Starting GravSpace v%s", Version)
-
-	// Environment variables
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "secret" // Fallback for dev, should be set in prod
-		log.Println("Warning: JWT_SECRET not set, using default 'secret'")
+	if *configPath != "" {
+		log.Printf("Loaded configuration from %s", *configPath)
 	}
 
-	adminPort := os.Getenv("ADMIN_PORT")
-	if adminPort == "" {
-		adminPort = "8080"
+	// Tracing exports to an OTLP collector when OTEL_EXPORTER_OTLP_ENDPOINT is set
+	shutdownTracing, err := tracing.Init(context.Background(), Version)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
 	}
-
-	s3Port := os.Getenv("S3_PORT")
-	if s3Port == "" {
-		// Use 9000 as default
-		s3Port = "9000"
+	if tracing.Enabled() {
+		log.Printf("Tracing enabled, exporting spans over OTLP")
 	}
 
-	// Middleware config
-	corsOrigins := os.Getenv("CORS_ORIGINS")
-	allowedOrigins := []string{"*"}
-	if corsOrigins != "" {
-		allowedOrigins = strings.Split(corsOrigins, ",")
+	jwtSecret := cfg.Auth.JWTSecret
+	if jwtSecret == "" {
+		jwtSecret = "secret" // Fallback for dev, should be set in prod
+		log.Println("Warning: JWT_SECRET not set, using default 'secret'")
 	}
 
-	corsConfig := cors.Config{
-		AllowOrigins:     allowedOrigins,
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "x-amz-date", "x-amz-content-sha256", "x-amz-server-side-encryption"},
-		AllowMethods:     []string{"GET", "HEAD", "PUT", "POST", "DELETE", "OPTIONS"},
-		ExposeHeaders:    []string{"x-amz-version-id", "x-amz-server-side-encryption", "ETag", "Content-Length", "Last-Modified"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}
+	adminPort := cfg.Server.AdminPort
+	s3Port := cfg.Server.S3Port
+
+	// Middleware config; CORS origins and the log level can be changed by a reload
+	globalCORS := newCORSHandler(cfg.Server.CORSOrigins)
+	setLogLevel(cfg.Server.LogLevel)
+	requestLogger := gin.LoggerWithConfig(gin.LoggerConfig{Formatter: logFormatter, Skip: skipRequestLog})
 
 	// Apply Gin middleware
+	// The logger runs inside the tracing middleware so request lines carry the trace ID
 	adminApp.Use(gin.Recovery())
-	adminApp.Use(gin.Logger())
-	adminApp.Use(cors.New(corsConfig))
+	adminApp.Use(otelgin.Middleware("gravspace-admin", otelgin.WithFilter(skipTracing)))
+	adminApp.Use(requestLogger)
+	adminApp.Use(metrics.Middleware("admin", adminRequestLabels))
+	adminApp.Use(globalCORS.Handle)
 
 	s3App.Use(gin.Recovery())
-	s3App.Use(gin.Logger())
-	s3App.Use(cors.New(corsConfig))
+	s3App.Use(otelgin.Middleware("gravspace-s3", otelgin.WithFilter(skipTracing)))
+	s3App.Use(requestLogger)
+	s3App.Use(metrics.Middleware("s3", auth.S3RequestLabels))
 
 	// Initialize Database
-	db, err := database.NewDatabase("./db/metadata.db")
+	db, err := database.Open(cfg.Database.URL, cfg.Database.AuthToken, cfg.Paths.Database)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
 	// Initialize Storage and Auth
-	store, err := storage.NewFileStorage("./data", db)
+	blobs, err := openBlobStore(cfg.BlobStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, err := storage.NewFileStorage(cfg.Paths.Data, db, storage.Options{
+		RedisURL:          cfg.Redis.URL,
+		ColdRoot:          cfg.ColdStorage.Path,
+		ColdCompression:   cfg.ColdStorage.Compression != "none",
+		SyncInterval:      time.Duration(cfg.Workers.SyncInterval),
+		LifecycleInterval: time.Duration(cfg.Workers.LifecycleInterval),
+		MetricsInterval:   time.Duration(cfg.Workers.MetricsReconcileInterval),
+		ScrubInterval:     time.Duration(cfg.Workers.ScrubInterval),
+		ScrubRate:         int64(cfg.Workers.ScrubRateMB) << 20,
+		JobWorkers:        cfg.Workers.JobWorkers,
+		WebhookWorkers:    cfg.Workers.WebhookWorkers,
+
+		Drives:               cfg.Drives.Paths,
+		Copies:               cfg.Drives.Copies,
+		AllowUnmountedDrives: cfg.Drives.AllowUnmounted,
+		DriveCheckInterval:   time.Duration(cfg.Drives.CheckInterval),
+		Blobs:                blobs,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
+	// Uploads interrupted by a crash or kill leave .tmp- files behind
+	go store.SweepTempFiles(time.Now())
+
+	// Bucket CORS rules take precedence over the global CORS configuration
+	s3App.Use(s3.BucketCORSMiddleware(store, globalCORS.Handle))
 
 	um, err := auth.NewUserManager(db)
 	if err != nil {
@@ -125,15 +175,51 @@ func main() {
 	}
 
 	// Initialize Notifications Dispatcher
-	dispatcher := notifications.NewDispatcher(db, 5)
+	dispatcher := store.Notifications
+	dispatcher.SetRegion(cfg.Server.Region)
 	dispatcher.Start()
-	store.Notifications = dispatcher
 
-	s3Handler := &s3.S3Handler{Storage: store}
+	// Gauges for stored data and queues, reconciled with the database at startup
+	store.Metrics.Start()
+
+	s3Handler := &s3.S3Handler{Storage: store, UserManager: um}
 	healthChecker := health.NewHealthChecker()
 
+	// Readiness checks: free space and inodes of the data drives, the metadata database and Redis.
+	// Below the critical free space threshold S3 writes are rejected.
+	var drivePaths []string
+	for _, drive := range store.Drives.Drives() {
+		drivePaths = append(drivePaths, drive.Path)
+	}
+	diskMonitor := health.NewDiskMonitor(drivePaths, store.Drives.Copies(), cfg.Health.DiskWarnPercent, cfg.Health.DiskCriticalPercent)
+	diskMonitor.Start()
+	healthChecker.DataRoot = store.Root
+	healthChecker.Disk = diskMonitor
+	healthChecker.HeartbeatTimeout = time.Duration(cfg.Health.HeartbeatTimeout)
+	healthChecker.AddCheck("database", true, db.Ping)
+	if len(cfg.Drives.Paths) > 0 {
+		healthChecker.AddCheck("drives", false, func(context.Context) error {
+			if offline := store.Drives.Offline(); len(offline) > 0 {
+				return fmt.Errorf("offline: %s", strings.Join(offline, ", "))
+			}
+			return nil
+		})
+	}
+	if cfg.Redis.URL != "" {
+		if redisCache, ok := store.Cache.(*cache.RedisCache); ok {
+			healthChecker.AddCheck("redis", true, redisCache.Ping)
+		} else {
+			healthChecker.AddCheck("redis", false, func(context.Context) error {
+				return errors.New("unreachable at startup, using the in-memory cache")
+			})
+		}
+	}
+
 	// Start Background Workers in a goroutine
+	trashWorker := storage.NewTrashWorker(db, store, time.Duration(cfg.Workers.TrashInterval))
+	workersStarted := make(chan struct{})
 	go func() {
+		defer close(workersStarted)
 		// Small delay to allow listeners to start first
 		time.Sleep(1 * time.Second)
 
@@ -144,6 +230,11 @@ func main() {
 			store.SyncWorker.Start()
 		}
 
+		// Replication Queue
+		if store.ReplicationWorker != nil {
+			store.ReplicationWorker.Start()
+		}
+
 		// Lifecycle Management
 		store.StartLifecycleWorker()
 
@@ -152,21 +243,60 @@ func main() {
 		analyticsWorker.Start()
 
 		// Trash Cleanup
-		trashWorker := storage.NewTrashWorker(db, store)
 		trashWorker.Start()
+
+		// Inventory Reports
+		inventoryWorker := storage.NewInventoryWorker(store)
+		inventoryWorker.Start()
+
+		// Bit-rot Scrubbing
+		store.Scrubber.Start()
+
+		// Data Drive Monitoring
+		store.Drives.Start()
 	}()
 
 	// Initialize Audit Logger
-	auditLogger, err := audit.NewAuditLogger("./logs/audit.log", db)
+	auditFile := audit.FileConfig{
+		Path:      filepath.Join(cfg.Paths.Logs, "audit.log"),
+		MaxSizeMB: cfg.Audit.MaxSizeMB,
+		MaxAge:    cfg.Audit.MaxAge,
+		Compress:  true,
+	}
+	auditLogger, err := audit.NewAuditLogger(auditFile, db)
 	if err != nil {
 		log.Printf("Warning: Failed to initialize audit logger: %v", err)
 		auditLogger = nil // Continue without audit logging
 	}
+	var auditRetention *audit.RetentionWorker
 	if auditLogger != nil {
-		defer auditLogger.Close()
+		signer, err := audit.NewSigner(cfg.Audit.SigningKey)
+		if err != nil {
+			log.Fatalf("Invalid AUDIT_SIGNING_KEY: %v", err)
+		}
+		if signer == nil {
+			log.Println("Warning: AUDIT_SIGNING_KEY not set, audit checkpoints will not be signed")
+		}
+		auditLogger.SetSigner(signer)
+
+		auditLogger.StartCheckpoints(time.Duration(cfg.Audit.CheckpointInterval))
+
+		auditRetention = audit.NewRetentionWorker(auditLogger, store)
+		auditRetention.Start()
 	}
 
-	adminHandler := &s3.AdminHandler{UserManager: um, Storage: store, S3Port: s3Port, AuditLogger: auditLogger}
+	// Server access logs (enabled per bucket with PUT ?logging)
+	accessLogger := accesslog.NewLogger(store, time.Duration(cfg.AccessLog.FlushInterval))
+	accessLogger.Start()
+
+	// Rate limiting (configured through the admin settings API, or the config file)
+	rateLimiter := ratelimit.NewLimiter()
+	if redisCache, ok := store.Cache.(*cache.RedisCache); ok {
+		rateLimiter.SetRedis(redisCache.Client())
+	}
+	applyRateLimits(rateLimiter, db, cfg.RateLimits)
+
+	adminHandler := &s3.AdminHandler{UserManager: um, Storage: store, S3Port: s3Port, Region: cfg.Server.Region, ServerHost: cfg.Server.Host, AuditLogger: auditLogger, RateLimiter: rateLimiter, AuditRetention: auditRetention}
 
 	// Health Check Routes (no auth required)
 	adminApp.GET("/health/live", healthChecker.LivenessHandler)
@@ -176,8 +306,8 @@ func main() {
 	// Metrics endpoint (no auth required)
 	adminApp.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
-	// Start metrics updater
-	metrics.StartMetricsUpdater()
+	// Distinct bucket label values on request metrics; further buckets are reported as "_other"
+	metrics.SetBucketLabelLimit(cfg.Metrics.BucketLabelLimit)
 
 	// Auth Routes
 	handleLogin := func(c *gin.Context) {
@@ -273,6 +403,7 @@ func main() {
 		admin.PUT("/buckets/:bucket/retention", adminHandler.SetObjectRetention)
 		admin.PUT("/buckets/:bucket/retention/default", adminHandler.SetBucketDefaultRetention)
 		admin.PUT("/buckets/:bucket/quota", adminHandler.SetBucketQuota)
+		admin.PUT("/buckets/:bucket/erasure", adminHandler.SetBucketErasure)
 		admin.PUT("/buckets/:bucket/legal-hold", adminHandler.SetObjectLegalHold)
 		admin.GET("/buckets/:bucket/objects", adminHandler.ListObjects)
 		admin.GET("/buckets/:bucket/objects/*key", adminHandler.GetObject)
@@ -285,6 +416,9 @@ func main() {
 		admin.GET("/buckets/:bucket/replication", adminHandler.ListReplicationRules)
 		admin.POST("/buckets/:bucket/replication", adminHandler.CreateReplicationRule)
 		admin.DELETE("/buckets/:bucket/replication/:id", adminHandler.DeleteReplicationRule)
+		admin.GET("/buckets/:bucket/replication/queue", adminHandler.ListReplicationQueue)
+		admin.GET("/buckets/:bucket/replication/backfills", adminHandler.ListReplicationBackfills)
+		admin.POST("/buckets/:bucket/replication/:id/backfill", adminHandler.StartReplicationBackfill)
 		admin.GET("/presigns", adminHandler.ListPresignedURLs)
 		admin.DELETE("/presigns", adminHandler.RevokePresignedURL)
 		admin.GET("/buckets/:bucket/tags/*key", adminHandler.GetObjectTagging)
@@ -301,6 +435,12 @@ func main() {
 		admin.GET("/buckets/:bucket/cors", adminHandler.GetBucketCors)
 		admin.PUT("/buckets/:bucket/cors", adminHandler.PutBucketCors)
 		admin.DELETE("/buckets/:bucket/cors", adminHandler.DeleteBucketCors)
+		admin.GET("/buckets/:bucket/logging", adminHandler.GetBucketLogging)
+		admin.PUT("/buckets/:bucket/logging", adminHandler.SetBucketLogging)
+		admin.GET("/buckets/:bucket/inventory", adminHandler.ListBucketInventories)
+		admin.PUT("/buckets/:bucket/inventory/:id", adminHandler.PutBucketInventory)
+		admin.DELETE("/buckets/:bucket/inventory/:id", adminHandler.DeleteBucketInventory)
+		admin.POST("/buckets/:bucket/inventory/:id/run", adminHandler.RunBucketInventory)
 		admin.PUT("/buckets/:bucket/soft-delete", adminHandler.SetBucketSoftDelete)
 		admin.GET("/trash", adminHandler.ListTrash)
 		admin.POST("/trash/restore", adminHandler.RestoreObject)
@@ -315,12 +455,41 @@ func main() {
 
 		iam.GET("/stats", adminHandler.GetSystemStats)
 		iam.GET("/audit-logs", adminHandler.GetAuditLogs)
+		iam.GET("/audit-logs/export", adminHandler.ExportAuditLogs)
 		iam.GET("/audit/stream", adminHandler.StreamAuditLogs)
+		iam.GET("/audit/verify", adminHandler.VerifyAuditLog)
+		iam.GET("/fsck", adminHandler.RunFsck)
+		iam.POST("/fsck/repair", adminHandler.RunFsck)
+		iam.GET("/scrub", adminHandler.GetScrubStatus)
+		iam.POST("/scrub", adminHandler.RunScrub)
+		iam.GET("/drives", adminHandler.GetDrives)
+		iam.POST("/drives/heal", adminHandler.HealDrives)
+		iam.GET("/audit/checkpoints", adminHandler.ListAuditCheckpoints)
+		iam.POST("/audit/checkpoints", adminHandler.CreateAuditCheckpoint)
+		iam.GET("/audit/retention", adminHandler.GetAuditRetention)
+		iam.PUT("/audit/retention", adminHandler.UpdateAuditRetention)
+		iam.POST("/audit/retention/run", adminHandler.RunAuditRetention)
+		iam.GET("/audit/sinks", adminHandler.GetAuditSinks)
+		iam.PUT("/audit/sinks", adminHandler.UpdateAuditSinks)
 		iam.GET("/analytics/storage", adminHandler.GetStorageAnalytics)
 		iam.GET("/analytics/requests", adminHandler.GetActionAnalytics)
 		iam.GET("/analytics/content-types", adminHandler.GetContentTypeBreakdown)
 		iam.GET("/settings", adminHandler.GetSystemSettings)
 		iam.POST("/settings", adminHandler.UpdateSystemSettings)
+		iam.GET("/settings/rate-limits", adminHandler.GetRateLimits)
+		iam.PUT("/settings/rate-limits", adminHandler.UpdateRateLimits)
+		iam.GET("/jobs", adminHandler.ListJobs)
+		iam.GET("/jobs/:id", adminHandler.GetJob)
+		iam.POST("/jobs/:id/retry", adminHandler.RetryJob)
+		iam.POST("/jobs/:id/cancel", adminHandler.CancelJob)
+		iam.GET("/batch-jobs", adminHandler.ListBatchJobs)
+		iam.POST("/batch-jobs", adminHandler.CreateBatchJob)
+		iam.GET("/batch-jobs/:id", adminHandler.GetBatchJob)
+		iam.GET("/batch-jobs/:id/results", adminHandler.ListBatchJobResults)
+		iam.POST("/batch-jobs/:id/cancel", adminHandler.CancelBatchJob)
+		iam.GET("/replication/targets", adminHandler.ListReplicationTargets)
+		iam.POST("/replication/targets", adminHandler.CreateReplicationTarget)
+		iam.DELETE("/replication/targets/:id", adminHandler.DeleteReplicationTarget)
 		iam.GET("/users", adminHandler.ListUsers)
 		iam.POST("/users", adminHandler.CreateUser)
 		iam.DELETE("/users/:username", adminHandler.DeleteUser)
@@ -340,7 +509,11 @@ func main() {
 
 	// S3 API Routes (Protected)
 	s3Group := s3App.Group("")
+	s3Group.Use(accessLogger.Middleware())
+	s3Group.Use(ratelimit.Middleware(rateLimiter, ratelimit.ScopeIP))
 	s3Group.Use(auth.S3AuthMiddleware(um, auditLogger, store))
+	s3Group.Use(s3.StorageFullMiddleware(diskMonitor.Full))
+	s3Group.Use(ratelimit.Middleware(rateLimiter, ratelimit.ScopeAccessKey, ratelimit.ScopeUser, ratelimit.ScopeBucket))
 
 	// List Buckets
 	s3Group.GET("/", s3Handler.ListBuckets)
@@ -359,20 +532,165 @@ func main() {
 	s3Group.POST("/:bucket/*key", s3Handler.PostObject)
 	s3Group.DELETE("/:bucket/*key", s3Handler.DeleteObject)
 
-	// Website Static Hosting (Public access handled within handler)
-	s3App.GET("/website/:bucket/*key", s3Handler.ServeWebsite)
-	s3App.GET("/website/:bucket", s3Handler.ServeWebsite)
+	// Website Static Hosting (Public access handled within handler). Requests are anonymous,
+	// so they are limited by source IP and bucket.
+	website := s3App.Group("/website", ratelimit.Middleware(rateLimiter, ratelimit.ScopeIP, ratelimit.ScopeBucket))
+	website.GET("/:bucket/*key", s3Handler.ServeWebsite)
+	website.GET("/:bucket", s3Handler.ServeWebsite)
+	website.HEAD("/:bucket/*key", s3Handler.ServeWebsite)
+	website.HEAD("/:bucket", s3Handler.ServeWebsite)
 
 	// Start both servers
+	adminServer := &http.Server{Addr: ":" + adminPort, Handler: adminApp}
+	s3Server := &http.Server{Addr: ":" + s3Port, Handler: s3App}
+	serverErr := make(chan error, 2)
 	go func() {
 		log.Printf("Starting Admin API on :%s", adminPort)
-		if err := adminApp.Run(":" + adminPort); err != nil {
-			log.Fatalf("Admin API failed: %v", err)
+		if err := adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- fmt.Errorf("Admin API failed: %w", err)
 		}
 	}()
+	go func() {
+		log.Printf("Starting S3 API on :%s", s3Port)
+		if err := s3Server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- fmt.Errorf("S3 API failed: %w", err)
+		}
+	}()
+
+	// SIGHUP reloads the configuration file and applies the settings that are safe to change
+	reloader := &reloader{
+		path:    *configPath,
+		current: cfg,
+		store:   store,
+		trash:   trashWorker,
+		cors:    globalCORS,
+		limiter: rateLimiter,
+		db:      db,
+	}
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			reloader.reload()
+		}
+	}()
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case <-signals.Done():
+		log.Println("Shutting down, draining in-flight requests...")
+	case err := <-serverErr:
+		log.Printf("%v, shutting down", err)
+		exitCode = 1
+	}
+	// A second signal skips the graceful shutdown
+	stopSignals()
+
+	signal.Stop(hangups)
+	shutdownTimeout := time.Duration(cfg.Server.ShutdownTimeout)
+
+	// 1. Stop accepting connections and let in-flight requests finish
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	for _, server := range []*http.Server{s3Server, adminServer} {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Forcing server %s closed: %v", server.Addr, err)
+			server.Close()
+		}
+	}
+	cancel()
+
+	// 2. Stop the workers that change data, waiting for the current pass of each
+	<-workersStarted
+	store.SyncWorker.Stop()
+	store.StopLifecycleWorker()
+	trashWorker.Stop()
+	store.Scrubber.Stop()
+	store.Drives.Stop()
+	store.Metrics.Stop()
+	diskMonitor.Stop()
+
+	// 3. Flush access logs, which are written as objects, then finish running jobs
+	accessLogger.Stop()
+	store.Jobs.Stop()
+
+	// 4. Deliver the queued webhooks, or keep them in the DLQ
+	ctx, cancel = context.WithTimeout(context.Background(), shutdownTimeout)
+	dispatcher.Stop(ctx)
+	cancel()
+
+	// 5. Seal the audit log, flush traces and close the database last
+	if auditRetention != nil {
+		auditRetention.Stop()
+	}
+	if auditLogger != nil {
+		auditLogger.Close()
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownTracing(ctx)
+	cancel()
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+	log.Println("Shutdown complete")
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// skipTracing leaves scrapes and probes out of traces
+func skipTracing(r *http.Request) bool {
+	return r.URL.Path != "/metrics" && !strings.HasPrefix(r.URL.Path, "/health/")
+}
 
-	log.Printf("Starting S3 API on :%s", s3Port)
-	if err := s3App.Run(":" + s3Port); err != nil {
-		log.Fatalf("S3 API failed: %v", err)
+// logFormatter is gin's request log line with the trace ID appended when the request is traced
+func logFormatter(param gin.LogFormatterParams) string {
+	traceID := ""
+	if param.Request != nil {
+		if id := tracing.TraceID(param.Request.Context()); id != "" {
+			traceID = " | trace_id=" + id
+		}
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v%s\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		param.Path,
+		traceID,
+		param.ErrorMessage,
+	)
+}
+
+// adminRequestLabels records admin requests under their route and whether they carry a token
+func adminRequestLabels(c *gin.Context) (string, string) {
+	operation := c.FullPath()
+	if operation == "" {
+		operation = "unmatched"
+	}
+	authType := auth.AuthTypeAnonymous
+	if strings.HasPrefix(c.GetHeader("Authorization"), "Bearer ") || c.Query("token") != "" {
+		authType = "token"
+	}
+	return operation, authType
+}
+
+// openBlobStore returns the store configured for the CAS blobs, nil to keep them on the drives
+func openBlobStore(cfg config.BlobStoreConfig) (storage.BlobStore, error) {
+	switch cfg.Type {
+	case config.BlobStoreLocal:
+		return storage.NewLocalBlobStore(cfg.Path)
+	case config.BlobStoreS3:
+		return storage.NewS3BlobStore(storage.S3BlobStoreOptions{
+			Endpoint:     cfg.S3.Endpoint,
+			Region:       cfg.S3.Region,
+			Bucket:       cfg.S3.Bucket,
+			Prefix:       cfg.S3.Prefix,
+			AccessKey:    cfg.S3.AccessKey,
+			SecretKey:    cfg.S3.SecretKey,
+			UsePathStyle: cfg.S3.UsePathStyle,
+		})
 	}
+	return nil, nil
 }
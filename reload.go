@@ -0,0 +1,127 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GravSpace/GravSpace/internal/config"
+	"github.com/GravSpace/GravSpace/internal/database"
+	"github.com/GravSpace/GravSpace/internal/metrics"
+	"github.com/GravSpace/GravSpace/internal/ratelimit"
+	"github.com/GravSpace/GravSpace/internal/storage"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// reloader applies a changed configuration file on SIGHUP. Worker intervals, CORS origins, rate
+// limits, the log level and the metrics label limit change in place; other settings are only
+// reported and take effect on the next restart.
+type reloader struct {
+	path    string
+	store   *storage.FileStorage
+	trash   *storage.TrashWorker
+	cors    *corsHandler
+	limiter *ratelimit.Limiter
+	db      *database.Database
+
+	mu      sync.Mutex
+	current *config.Config
+}
+
+func (r *reloader) reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.Load(r.path)
+	if err != nil {
+		log.Printf("Configuration reload failed, keeping the current settings: %v", err)
+		return
+	}
+
+	r.store.SyncWorker.SetInterval(time.Duration(next.Workers.SyncInterval))
+	r.store.SetLifecycleInterval(time.Duration(next.Workers.LifecycleInterval))
+	r.trash.SetInterval(time.Duration(next.Workers.TrashInterval))
+	r.store.Metrics.SetInterval(time.Duration(next.Workers.MetricsReconcileInterval))
+	r.store.Scrubber.SetInterval(time.Duration(next.Workers.ScrubInterval))
+	r.store.Scrubber.SetRate(int64(next.Workers.ScrubRateMB) << 20)
+	r.store.Drives.SetInterval(time.Duration(next.Drives.CheckInterval))
+	r.cors.set(next.Server.CORSOrigins)
+	setLogLevel(next.Server.LogLevel)
+	metrics.SetBucketLabelLimit(next.Metrics.BucketLabelLimit)
+	applyRateLimits(r.limiter, r.db, next.RateLimits)
+
+	if changed := r.current.RestartRequired(next); len(changed) > 0 {
+		log.Printf("Configuration reloaded; changes to %s take effect after a restart", strings.Join(changed, ", "))
+	} else {
+		log.Println("Configuration reloaded")
+	}
+	r.current = next
+}
+
+// applyRateLimits uses the limits from the config file when it has them, and otherwise the
+// limits saved through the admin API
+func applyRateLimits(limiter *ratelimit.Limiter, db *database.Database, fromFile *ratelimit.Config) {
+	if fromFile != nil {
+		limiter.SetConfig(*fromFile)
+		return
+	}
+	data, err := db.GetSystemSetting(ratelimit.SettingKey)
+	if err != nil {
+		return
+	}
+	cfg, err := ratelimit.ParseConfig(data)
+	if err != nil {
+		log.Printf("Warning: Invalid rate limit configuration: %v", err)
+		return
+	}
+	limiter.SetConfig(cfg)
+}
+
+// corsHandler applies the global CORS configuration. Bucket CORS rules take precedence on the
+// S3 API.
+type corsHandler struct {
+	current atomic.Pointer[gin.HandlerFunc]
+}
+
+func newCORSHandler(origins []string) *corsHandler {
+	h := &corsHandler{}
+	h.set(origins)
+	return h
+}
+
+func (h *corsHandler) set(origins []string) {
+	handler := cors.New(cors.Config{
+		AllowOrigins:     origins,
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "x-amz-date", "x-amz-content-sha256", "x-amz-server-side-encryption"},
+		AllowMethods:     []string{"GET", "HEAD", "PUT", "POST", "DELETE", "OPTIONS"},
+		ExposeHeaders:    []string{"x-amz-version-id", "x-amz-server-side-encryption", "ETag", "Content-Length", "Last-Modified"},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	})
+	h.current.Store(&handler)
+}
+
+func (h *corsHandler) Handle(c *gin.Context) {
+	(*h.current.Load())(c)
+}
+
+// logLevel is the minimum level of request log lines: warn logs only 4xx and 5xx responses,
+// error only 5xx responses
+var logLevel atomic.Value
+
+func setLogLevel(level string) {
+	logLevel.Store(level)
+}
+
+func skipRequestLog(c *gin.Context) bool {
+	switch logLevel.Load() {
+	case config.LogWarn:
+		return c.Writer.Status() < 400
+	case config.LogError:
+		return c.Writer.Status() < 500
+	}
+	return false
+}